@@ -0,0 +1,9 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package apipb holds the protocol buffers messages and the gRPC service of
+// the local API, generated from api.proto with protoc-gen-go 1.1.0.
+package apipb
+
+//go:generate protoc --go_out=plugins=grpc:. api.proto
@@ -0,0 +1,307 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/tsuru/bs/container"
+)
+
+// The types below mirror the subset of the cAdvisor v1.3 REST API served by
+// bs, so tools built for cAdvisor can read the container metrics from bs.
+// Every container is reported with a single stats sample, taken when the
+// request is made, and the cumulative counters can be used to compute rates
+// across requests.
+
+// cadvisorConcurrentStats is the number of containers whose stats are read
+// from Docker at the same time when listing them, as each read takes about a
+// second.
+const cadvisorConcurrentStats = 10
+
+type cadvisorMachineInfo struct {
+	NumCores       int    `json:"num_cores"`
+	MemoryCapacity uint64 `json:"memory_capacity"`
+	MachineID      string `json:"machine_id"`
+}
+
+type cadvisorContainerInfo struct {
+	Name      string                    `json:"name"`
+	Aliases   []string                  `json:"aliases,omitempty"`
+	Namespace string                    `json:"namespace,omitempty"`
+	Labels    map[string]string         `json:"labels,omitempty"`
+	Spec      cadvisorContainerSpec     `json:"spec"`
+	Stats     []*cadvisorContainerStats `json:"stats"`
+}
+
+type cadvisorContainerSpec struct {
+	CreationTime time.Time         `json:"creation_time"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Image        string            `json:"image,omitempty"`
+	HasCPU       bool              `json:"has_cpu"`
+	CPU          struct {
+		Limit  uint64 `json:"limit"`
+		Quota  uint64 `json:"quota,omitempty"`
+		Period uint64 `json:"period,omitempty"`
+	} `json:"cpu"`
+	HasMemory bool `json:"has_memory"`
+	Memory    struct {
+		Limit     uint64 `json:"limit,omitempty"`
+		SwapLimit uint64 `json:"swap_limit,omitempty"`
+	} `json:"memory"`
+	HasNetwork bool `json:"has_network"`
+	HasDiskIO  bool `json:"has_diskio"`
+}
+
+type cadvisorContainerStats struct {
+	Timestamp time.Time `json:"timestamp"`
+	CPU       struct {
+		Usage struct {
+			Total  uint64   `json:"total"`
+			PerCPU []uint64 `json:"per_cpu_usage,omitempty"`
+			User   uint64   `json:"user"`
+			System uint64   `json:"system"`
+		} `json:"usage"`
+		CFS struct {
+			Periods          uint64 `json:"periods"`
+			ThrottledPeriods uint64 `json:"throttled_periods"`
+			ThrottledTime    uint64 `json:"throttled_time"`
+		} `json:"cfs"`
+	} `json:"cpu"`
+	Memory struct {
+		Usage      uint64 `json:"usage"`
+		MaxUsage   uint64 `json:"max_usage"`
+		Cache      uint64 `json:"cache"`
+		RSS        uint64 `json:"rss"`
+		Swap       uint64 `json:"swap"`
+		WorkingSet uint64 `json:"working_set"`
+		Failcnt    uint64 `json:"failcnt"`
+	} `json:"memory"`
+	Network struct {
+		cadvisorInterfaceStats
+		Interfaces []cadvisorInterfaceStats `json:"interfaces,omitempty"`
+	} `json:"network"`
+	DiskIO struct {
+		IoServiceBytes []cadvisorPerDiskStats `json:"io_service_bytes,omitempty"`
+		IoServiced     []cadvisorPerDiskStats `json:"io_serviced,omitempty"`
+	} `json:"diskio"`
+}
+
+type cadvisorInterfaceStats struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+type cadvisorPerDiskStats struct {
+	Major uint64            `json:"major"`
+	Minor uint64            `json:"minor"`
+	Stats map[string]uint64 `json:"stats"`
+}
+
+func (s *Server) cadvisorMachine(w http.ResponseWriter, r *http.Request) {
+	var info cadvisorMachineInfo
+	var err error
+	info.NumCores, err = cpu.CPUCounts(true)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	memStat, err := mem.VirtualMemory()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	info.MemoryCapacity = memStat.Total
+	info.MachineID, _ = os.Hostname()
+	writeJSON(w, info)
+}
+
+func (s *Server) cadvisorDockerContainers(w http.ResponseWriter, r *http.Request) {
+	containers, err := s.infoClient.ListContainers()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ids := make(chan string)
+	infos := make(chan cadvisorContainerInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < cadvisorConcurrentStats && i < len(containers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				cont, err := s.infoClient.GetContainer(id, true, nil)
+				if err != nil {
+					// The container may be gone since it was listed.
+					continue
+				}
+				info, err := newCadvisorContainerInfo(cont)
+				if err != nil {
+					continue
+				}
+				infos <- info
+			}
+		}()
+	}
+	go func() {
+		for _, c := range containers {
+			ids <- c.ID
+		}
+		close(ids)
+		wg.Wait()
+		close(infos)
+	}()
+	result := make(map[string]cadvisorContainerInfo, len(containers))
+	for info := range infos {
+		result[info.Name] = info
+	}
+	writeJSON(w, result)
+}
+
+func (s *Server) cadvisorDockerContainer(w http.ResponseWriter, r *http.Request) {
+	cont, ok := s.findContainer(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	info, err := newCadvisorContainerInfo(cont)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, map[string]cadvisorContainerInfo{info.Name: info})
+}
+
+func newCadvisorContainerInfo(cont *container.Container) (cadvisorContainerInfo, error) {
+	stats, err := cont.Stats()
+	if err != nil {
+		return cadvisorContainerInfo{}, err
+	}
+	info := cadvisorContainerInfo{
+		Name:      "/docker/" + cont.ID,
+		Aliases:   []string{strings.TrimPrefix(cont.Name, "/"), cont.ID},
+		Namespace: "docker",
+	}
+	info.Spec.CreationTime = cont.Created
+	info.Spec.HasCPU = true
+	info.Spec.HasMemory = true
+	info.Spec.HasNetwork = true
+	info.Spec.HasDiskIO = true
+	if cont.Config != nil {
+		info.Labels = cont.Config.Labels
+		info.Spec.Labels = cont.Config.Labels
+		info.Spec.Image = cont.Config.Image
+	}
+	if hc := cont.HostConfig; hc != nil {
+		// Negative values mean unlimited, left out like in cAdvisor.
+		info.Spec.CPU.Limit = positive(hc.CPUShares)
+		info.Spec.CPU.Quota = positive(hc.CPUQuota)
+		info.Spec.CPU.Period = positive(hc.CPUPeriod)
+		info.Spec.Memory.Limit = positive(hc.Memory)
+		info.Spec.Memory.SwapLimit = positive(hc.MemorySwap)
+	}
+	if stats != nil {
+		info.Stats = []*cadvisorContainerStats{newCadvisorStats(stats)}
+	}
+	return info, nil
+}
+
+func newCadvisorStats(s *docker.Stats) *cadvisorContainerStats {
+	stats := &cadvisorContainerStats{Timestamp: s.Read}
+	usage := s.CPUStats.CPUUsage
+	stats.CPU.Usage.Total = usage.TotalUsage
+	stats.CPU.Usage.PerCPU = usage.PercpuUsage
+	stats.CPU.Usage.User = usage.UsageInUsermode
+	stats.CPU.Usage.System = usage.UsageInKernelmode
+	throttling := s.CPUStats.ThrottlingData
+	stats.CPU.CFS.Periods = throttling.Periods
+	stats.CPU.CFS.ThrottledPeriods = throttling.ThrottledPeriods
+	stats.CPU.CFS.ThrottledTime = throttling.ThrottledTime
+	memory := s.MemoryStats
+	stats.Memory.Usage = memory.Usage
+	stats.Memory.MaxUsage = memory.MaxUsage
+	stats.Memory.Cache = memory.Stats.Cache
+	stats.Memory.RSS = memory.Stats.Rss
+	stats.Memory.Swap = memory.Stats.Swap
+	stats.Memory.Failcnt = memory.Failcnt
+	// The working set is the memory the kernel can't easily reclaim, the
+	// same used by cAdvisor.
+	stats.Memory.WorkingSet = memory.Usage
+	if memory.Stats.TotalInactiveFile < memory.Usage {
+		stats.Memory.WorkingSet = memory.Usage - memory.Stats.TotalInactiveFile
+	}
+	networks := s.Networks
+	if len(networks) == 0 && s.Network != (docker.NetworkStats{}) {
+		networks = map[string]docker.NetworkStats{"eth0": s.Network}
+	}
+	for name, n := range networks {
+		iface := cadvisorInterfaceStats{
+			Name:      name,
+			RxBytes:   n.RxBytes,
+			RxPackets: n.RxPackets,
+			RxErrors:  n.RxErrors,
+			RxDropped: n.RxDropped,
+			TxBytes:   n.TxBytes,
+			TxPackets: n.TxPackets,
+			TxErrors:  n.TxErrors,
+			TxDropped: n.TxDropped,
+		}
+		stats.Network.Interfaces = append(stats.Network.Interfaces, iface)
+		stats.Network.RxBytes += n.RxBytes
+		stats.Network.RxPackets += n.RxPackets
+		stats.Network.RxErrors += n.RxErrors
+		stats.Network.RxDropped += n.RxDropped
+		stats.Network.TxBytes += n.TxBytes
+		stats.Network.TxPackets += n.TxPackets
+		stats.Network.TxErrors += n.TxErrors
+		stats.Network.TxDropped += n.TxDropped
+	}
+	sort.Slice(stats.Network.Interfaces, func(i, j int) bool {
+		return stats.Network.Interfaces[i].Name < stats.Network.Interfaces[j].Name
+	})
+	stats.DiskIO.IoServiceBytes = cadvisorDiskStats(s.BlkioStats.IOServiceBytesRecursive)
+	stats.DiskIO.IoServiced = cadvisorDiskStats(s.BlkioStats.IOServicedRecursive)
+	return stats
+}
+
+// cadvisorDiskStats groups the blkio entries by device, keyed by operation,
+// like cAdvisor does.
+func cadvisorDiskStats(entries []docker.BlkioStatsEntry) []cadvisorPerDiskStats {
+	var result []cadvisorPerDiskStats
+	index := make(map[[2]uint64]int)
+	for _, e := range entries {
+		key := [2]uint64{e.Major, e.Minor}
+		i, ok := index[key]
+		if !ok {
+			i = len(result)
+			index[key] = i
+			result = append(result, cadvisorPerDiskStats{Major: e.Major, Minor: e.Minor, Stats: map[string]uint64{}})
+		}
+		result[i].Stats[e.Op] = e.Value
+	}
+	return result
+}
+
+func positive(v int64) uint64 {
+	if v < 0 {
+		return 0
+	}
+	return uint64(v)
+}
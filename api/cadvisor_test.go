@@ -0,0 +1,85 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCadvisorMachine(c *check.C) {
+	var info cadvisorMachineInfo
+	code := s.get(c, "/api/v1.3/machine", &info)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(info.NumCores > 0, check.Equals, true)
+	c.Assert(info.MemoryCapacity > 0, check.Equals, true)
+}
+
+func (s *S) prepareStats() time.Time {
+	read := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.dockerServer.PrepareStats(s.containerID, func(id string) docker.Stats {
+		stats := docker.Stats{Read: read}
+		stats.CPUStats.CPUUsage.TotalUsage = 3000
+		stats.CPUStats.CPUUsage.PercpuUsage = []uint64{1000, 2000}
+		stats.CPUStats.CPUUsage.UsageInUsermode = 2000
+		stats.CPUStats.CPUUsage.UsageInKernelmode = 1000
+		stats.MemoryStats.Usage = 1024
+		stats.MemoryStats.Stats.TotalInactiveFile = 256
+		stats.MemoryStats.Stats.Rss = 512
+		stats.Networks = map[string]docker.NetworkStats{
+			"eth1": {RxBytes: 10, TxBytes: 20},
+			"eth0": {RxBytes: 1, TxBytes: 2},
+		}
+		stats.BlkioStats.IOServiceBytesRecursive = []docker.BlkioStatsEntry{
+			{Major: 8, Minor: 0, Op: "Read", Value: 100},
+			{Major: 8, Minor: 0, Op: "Write", Value: 200},
+		}
+		return stats
+	})
+	return read
+}
+
+func (s *S) TestCadvisorDockerContainers(c *check.C) {
+	read := s.prepareStats()
+	var containers map[string]cadvisorContainerInfo
+	code := s.get(c, "/api/v1.3/docker", &containers)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(containers, check.HasLen, 1)
+	info := containers["/docker/"+s.containerID]
+	c.Assert(info.Name, check.Equals, "/docker/"+s.containerID)
+	c.Assert(info.Aliases, check.DeepEquals, []string{"myapp-web", s.containerID})
+	c.Assert(info.Namespace, check.Equals, "docker")
+	c.Assert(info.Labels, check.DeepEquals, map[string]string{"label": "value"})
+	c.Assert(info.Spec.Image, check.Equals, "tsuru/python")
+	c.Assert(info.Stats, check.HasLen, 1)
+	stats := info.Stats[0]
+	c.Assert(stats.Timestamp.Equal(read), check.Equals, true)
+	c.Assert(stats.CPU.Usage.Total, check.Equals, uint64(3000))
+	c.Assert(stats.CPU.Usage.PerCPU, check.DeepEquals, []uint64{1000, 2000})
+	c.Assert(stats.Memory.Usage, check.Equals, uint64(1024))
+	c.Assert(stats.Memory.WorkingSet, check.Equals, uint64(768))
+	c.Assert(stats.Memory.RSS, check.Equals, uint64(512))
+	c.Assert(stats.Network.RxBytes, check.Equals, uint64(11))
+	c.Assert(stats.Network.TxBytes, check.Equals, uint64(22))
+	c.Assert(stats.Network.Interfaces, check.HasLen, 2)
+	c.Assert(stats.Network.Interfaces[0].Name, check.Equals, "eth0")
+	c.Assert(stats.DiskIO.IoServiceBytes, check.DeepEquals, []cadvisorPerDiskStats{
+		{Major: 8, Minor: 0, Stats: map[string]uint64{"Read": 100, "Write": 200}},
+	})
+}
+
+func (s *S) TestCadvisorDockerContainer(c *check.C) {
+	s.prepareStats()
+	var containers map[string]cadvisorContainerInfo
+	code := s.get(c, "/api/v1.3/docker/"+s.containerID, &containers)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers["/docker/"+s.containerID].Stats, check.HasLen, 1)
+	code = s.get(c, "/api/v1.3/docker/unknown", nil)
+	c.Assert(code, check.Equals, http.StatusNotFound)
+}
@@ -0,0 +1,136 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/api/apipb"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/metric"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startGRPC serves the gRPC service on the unix socket in GRPCAddress. The
+// socket isn't encrypted, so tcp addresses aren't supported.
+func (s *Server) startGRPC() error {
+	if u, err := url.Parse(s.GRPCAddress); err != nil || u.Scheme != "unix" {
+		return fmt.Errorf("invalid gRPC address %q, expected unix:///path/to/socket", s.GRPCAddress)
+	}
+	listener, err := s.listen(s.GRPCAddress, nil)
+	if err != nil {
+		return err
+	}
+	s.grpcServer = grpc.NewServer()
+	apipb.RegisterBSServer(s.grpcServer, &grpcService{server: s})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if serveErr := s.grpcServer.Serve(listener); serveErr != nil {
+			bslog.Errorf("[api] error serving gRPC requests: %s", serveErr)
+		}
+	}()
+	return nil
+}
+
+// grpcService implements the gRPC service of the API, answering with the
+// same data as the HTTP endpoints.
+type grpcService struct {
+	server *Server
+}
+
+func (g *grpcService) GetHostMetrics(ctx context.Context, req *apipb.HostMetricsRequest) (*apipb.Metrics, error) {
+	metrics, err := g.server.collectHostMetrics()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return newMetrics(metrics), nil
+}
+
+func (g *grpcService) ListContainers(ctx context.Context, req *apipb.ListContainersRequest) (*apipb.ContainerList, error) {
+	containers, err := g.server.runningContainers()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	result := &apipb.ContainerList{Containers: make([]*apipb.Container, 0, len(containers))}
+	for _, cont := range containers {
+		result.Containers = append(result.Containers, newContainer(cont))
+	}
+	return result, nil
+}
+
+func (g *grpcService) GetContainer(ctx context.Context, req *apipb.ContainerRequest) (*apipb.Container, error) {
+	cont, err := g.findContainer(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return newContainer(cont), nil
+}
+
+func (g *grpcService) GetContainerMetrics(ctx context.Context, req *apipb.ContainerRequest) (*apipb.Metrics, error) {
+	cont, err := g.findContainer(req.Id)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := metric.ContainerMetrics(cont)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return newMetrics(metrics), nil
+}
+
+func (g *grpcService) findContainer(id string) (*container.Container, error) {
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing container id")
+	}
+	cont, err := g.server.infoClient.GetContainer(id, true, nil)
+	if err != nil {
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return cont, nil
+}
+
+func newContainer(cont *container.Container) *apipb.Container {
+	result := &apipb.Container{
+		Id:          cont.ID,
+		Name:        strings.TrimPrefix(cont.Name, "/"),
+		Hostname:    cont.ShortHostname,
+		TsuruApp:    cont.TsuruApp,
+		AppName:     cont.AppName,
+		ProcessName: cont.ProcessName,
+	}
+	if cont.Config != nil {
+		result.Image = cont.Config.Image
+		result.Labels = cont.Config.Labels
+	}
+	return result
+}
+
+func newMetrics(metrics map[string]metric.Metric) *apipb.Metrics {
+	result := &apipb.Metrics{Metrics: make(map[string]*apipb.Metric, len(metrics))}
+	for name, m := range metrics {
+		value := &apipb.Metric{}
+		if m.IsInt() {
+			value.Value = &apipb.Metric_IntValue{IntValue: m.Int64()}
+		} else {
+			value.Value = &apipb.Metric_FloatValue{FloatValue: m.Float64()}
+		}
+		if key, tag := m.Tag(); key != "" {
+			value.Tags = map[string]string{key: tag}
+		}
+		result.Metrics[name] = value
+	}
+	return result
+}
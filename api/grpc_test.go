@@ -0,0 +1,102 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/api/apipb"
+	"github.com/tsuru/bs/metric"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) grpcClient(c *check.C) apipb.BSClient {
+	conn, err := grpc.Dial(s.grpcPath, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}))
+	c.Assert(err, check.IsNil)
+	s.grpcConns = append(s.grpcConns, conn)
+	return apipb.NewBSClient(conn)
+}
+
+func (s *S) TestGRPCHostMetrics(c *check.C) {
+	s.server.AddHostMetricsSource(fakeSource{
+		"load1":             metric.Float(0.5),
+		"process_1_mem_rss": metric.Int(4096).WithTag("process_name", "java"),
+	})
+	metrics, err := s.grpcClient(c).GetHostMetrics(context.Background(), &apipb.HostMetricsRequest{})
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics.Metrics, check.HasLen, 3)
+	c.Assert(metrics.Metrics["problems"].GetIntValue(), check.Equals, int64(2))
+	c.Assert(metrics.Metrics["load1"].GetFloatValue(), check.Equals, 0.5)
+	c.Assert(metrics.Metrics["process_1_mem_rss"].GetIntValue(), check.Equals, int64(4096))
+	c.Assert(metrics.Metrics["process_1_mem_rss"].Tags, check.DeepEquals, map[string]string{"process_name": "java"})
+}
+
+func (s *S) TestGRPCListContainers(c *check.C) {
+	list, err := s.grpcClient(c).ListContainers(context.Background(), &apipb.ListContainersRequest{})
+	c.Assert(err, check.IsNil)
+	c.Assert(list.Containers, check.HasLen, 1)
+	c.Assert(list.Containers[0].Id, check.Equals, s.containerID)
+	c.Assert(list.Containers[0].AppName, check.Equals, "myapp")
+}
+
+func (s *S) TestGRPCGetContainer(c *check.C) {
+	cont, err := s.grpcClient(c).GetContainer(context.Background(), &apipb.ContainerRequest{Id: s.containerID})
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Id, check.Equals, s.containerID)
+	c.Assert(cont.Name, check.Equals, "myapp-web")
+	c.Assert(cont.Image, check.Equals, "tsuru/python")
+	c.Assert(cont.TsuruApp, check.Equals, true)
+	c.Assert(cont.AppName, check.Equals, "myapp")
+	c.Assert(cont.ProcessName, check.Equals, "web")
+	c.Assert(cont.Labels, check.DeepEquals, map[string]string{"label": "value"})
+}
+
+func (s *S) TestGRPCGetContainerNotFound(c *check.C) {
+	client := s.grpcClient(c)
+	_, err := client.GetContainer(context.Background(), &apipb.ContainerRequest{Id: "unknown"})
+	c.Assert(status.Code(err), check.Equals, codes.NotFound)
+	_, err = client.GetContainerMetrics(context.Background(), &apipb.ContainerRequest{})
+	c.Assert(status.Code(err), check.Equals, codes.InvalidArgument)
+}
+
+func (s *S) TestGRPCContainerMetrics(c *check.C) {
+	s.dockerServer.PrepareStats(s.containerID, func(id string) docker.Stats {
+		stats := docker.Stats{}
+		stats.MemoryStats.Usage = 512
+		stats.MemoryStats.Limit = 1024
+		return stats
+	})
+	metrics, err := s.grpcClient(c).GetContainerMetrics(context.Background(), &apipb.ContainerRequest{Id: s.containerID})
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics.Metrics["mem_max"].GetIntValue(), check.Equals, int64(512))
+	c.Assert(metrics.Metrics["mem_pct_max"].GetFloatValue(), check.Equals, float64(50))
+}
+
+func (s *S) TestGRPCOnlyUnixSockets(c *check.C) {
+	server := &Server{GRPCAddress: "tcp://127.0.0.1:0", DockerEndpoint: s.dockerServer.URL()}
+	err := server.Start()
+	c.Assert(err, check.ErrorMatches, `invalid gRPC address "tcp://127.0.0.1:0", expected unix:///path/to/socket`)
+}
+
+func (s *S) TestGRPCWithoutHTTP(c *check.C) {
+	path := filepath.Join(c.MkDir(), "grpc-only.sock")
+	server := &Server{GRPCAddress: "unix://" + path, DockerEndpoint: s.dockerServer.URL()}
+	err := server.Start()
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	c.Assert(server.server, check.IsNil)
+	s.grpcPath = path
+	_, err = s.grpcClient(c).ListContainers(context.Background(), &apipb.ListContainersRequest{})
+	c.Assert(err, check.IsNil)
+}
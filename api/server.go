@@ -0,0 +1,539 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package api implements a local HTTP API exposing the host and container
+// metrics and the container metadata collected by bs, so other tools running
+// in the node can reuse them instead of polling Docker and /proc themselves.
+// The metrics and metadata are also served by a gRPC service, defined in
+// apipb, on a unix socket.
+package api
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gorilla/mux"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/buildinfo"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/log"
+	"github.com/tsuru/bs/metric"
+	"google.golang.org/grpc"
+)
+
+type Server struct {
+	// Address is the address the HTTP API listens on, either
+	// unix:///path/to/socket or tcp://host:port. When empty the HTTP API is
+	// disabled.
+	Address string
+	// GRPCAddress is the unix socket the gRPC service listens on, as
+	// unix:///path/to/socket. When empty the gRPC service is disabled.
+	GRPCAddress    string
+	DockerEndpoint string
+	// TLSCertFile and TLSKeyFile are the PEM encoded certificate and key
+	// used to serve the API over TLS. They're required by tcp addresses, so
+	// the API isn't exposed unencrypted on the network.
+	TLSCertFile string
+	TLSKeyFile  string
+	// SocketOwner and SocketGroup are the user and group, by name or ID,
+	// owning the unix sockets the API listens on. When empty the ownership
+	// isn't changed.
+	SocketOwner string
+	SocketGroup string
+	// SocketMode is the mode of the unix sockets the API listens on. When
+	// zero the mode is defined by the process umask.
+	SocketMode os.FileMode
+	// Readiness reports whether bs is ready in the /ready endpoint. When nil
+	// bs is always reported as ready.
+	Readiness Readiness
+	// LogTailer streams the log lines of containers in the /logs/<id>
+	// endpoint. When nil the endpoint is unavailable.
+	LogTailer LogTailer
+	// Forwarders lists, pauses and resumes log destinations in the
+	// /forwarders endpoints. When nil the endpoints are unavailable.
+	Forwarders Forwarders
+	// Flushers are flushed by the admin /flush endpoint.
+	Flushers []Flusher
+	// AdminToken is the bearer token required by admin endpoints. When empty
+	// admin endpoints are disabled.
+	AdminToken  string
+	infoClient  *container.InfoClient
+	hostClient  *metric.HostClient
+	hostSources []metric.HostMetricsSource
+	listener    net.Listener
+	server      *http.Server
+	grpcServer  *grpc.Server
+	wg          sync.WaitGroup
+}
+
+// Readiness returns whether bs is ready and the errors of the dependencies
+// still unavailable, by name.
+type Readiness interface {
+	Ready() (bool, map[string]string)
+}
+
+// LogTailer streams the log lines forwarded for the container with the given
+// ID, encoded as JSON documents, until the returned function is called.
+type LogTailer interface {
+	Tail(id string) (<-chan []byte, func())
+}
+
+// Forwarders reports the state of the log destinations and pauses and
+// resumes forwarding to them.
+type Forwarders interface {
+	State() map[string]interface{}
+	Pause(destination string) error
+	Resume(destination string) error
+}
+
+// Flusher sends the logs or metrics buffered by bs immediately, waiting up to
+// timeout.
+type Flusher interface {
+	Flush(timeout time.Duration) error
+}
+
+type readyStatus struct {
+	Ready   bool
+	Pending map[string]string `json:",omitempty"`
+}
+
+type containerInfo struct {
+	ID          string
+	Name        string
+	Image       string
+	Hostname    string
+	TsuruApp    bool
+	AppName     string
+	ProcessName string
+	Labels      map[string]string
+}
+
+// AddHostMetricsSource adds a source of metrics to the host metrics
+// endpoint. It must be called before Start.
+func (s *Server) AddHostMetricsSource(source metric.HostMetricsSource) {
+	s.hostSources = append(s.hostSources, source)
+}
+
+func (s *Server) Start() error {
+	var err error
+	s.infoClient, err = container.NewClient(s.DockerEndpoint)
+	if err != nil {
+		return err
+	}
+	s.hostClient, err = metric.NewHostClient()
+	if err != nil {
+		bslog.Warnf("[api] host metrics unavailable: %s", err)
+	}
+	if s.Address != "" {
+		if err = s.startHTTP(); err != nil {
+			return err
+		}
+	}
+	if s.GRPCAddress != "" {
+		if err = s.startGRPC(); err != nil {
+			s.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) startHTTP() error {
+	var tlsConfig *tls.Config
+	if s.TLSCertFile != "" || s.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.TLSCertFile, s.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS certificate: %s", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	var err error
+	s.listener, err = s.listen(s.Address, tlsConfig)
+	if err != nil {
+		return err
+	}
+	s.server = &http.Server{Handler: s.router()}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		serveErr := s.server.Serve(s.listener)
+		if serveErr != nil && serveErr != http.ErrServerClosed {
+			bslog.Errorf("[api] error serving requests: %s", serveErr)
+		}
+	}()
+	return nil
+}
+
+// Stop stops the server, blocking until it actually stops.
+func (s *Server) Stop() {
+	if s.server != nil {
+		s.server.Close()
+	}
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+	s.wg.Wait()
+}
+
+// Wait blocks until the server stops.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// listen listens on the given address, applying the configured permissions
+// to unix sockets.
+func (s *Server) listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	l, err := listen(address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if addr, ok := l.Addr().(*net.UnixAddr); ok {
+		if err = s.setSocketPermissions(addr.Name); err != nil {
+			l.Close()
+			return nil, fmt.Errorf("unable to set permissions of socket %q: %s", addr.Name, err)
+		}
+	}
+	return l, nil
+}
+
+// listen listens on the given address. tcp addresses require a TLS
+// configuration, as the API has no other protection on the network.
+func listen(address string, tlsConfig *tls.Config) (net.Listener, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		if path == "" {
+			path = u.Host
+		}
+		// Remove the socket left behind by a previous run.
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return net.Listen("unix", path)
+	case "tcp":
+		if tlsConfig == nil {
+			return nil, errors.New("tcp addresses require a TLS certificate and key")
+		}
+		return tls.Listen("tcp", u.Host, tlsConfig)
+	}
+	return nil, fmt.Errorf("invalid protocol %q, expected unix or tcp", u.Scheme)
+}
+
+func (s *Server) router() http.Handler {
+	r := mux.NewRouter()
+	r.HandleFunc("/ready", s.ready).Methods("GET")
+	r.HandleFunc("/info", s.info).Methods("GET")
+	r.HandleFunc("/host/metrics", s.hostMetrics).Methods("GET")
+	r.HandleFunc("/containers", s.listContainers).Methods("GET")
+	r.HandleFunc("/containers/{id}", s.getContainer).Methods("GET")
+	r.HandleFunc("/containers/{id}/metrics", s.containerMetrics).Methods("GET")
+	r.HandleFunc("/logs/{id}", s.admin(s.tailLogs)).Methods("GET")
+	r.HandleFunc("/config", s.admin(s.config)).Methods("GET")
+	r.HandleFunc("/flush", s.admin(s.flush)).Methods("POST")
+	r.HandleFunc("/forwarders", s.admin(s.listForwarders)).Methods("GET")
+	r.HandleFunc("/forwarders/pause", s.admin(s.pauseForwarder(true))).Methods("POST")
+	r.HandleFunc("/forwarders/resume", s.admin(s.pauseForwarder(false))).Methods("POST")
+	r.HandleFunc("/api/v1.3/machine", s.cadvisorMachine).Methods("GET")
+	r.HandleFunc("/api/v1.3/docker", s.cadvisorDockerContainers).Methods("GET")
+	r.HandleFunc("/api/v1.3/docker/", s.cadvisorDockerContainers).Methods("GET")
+	r.HandleFunc("/api/v1.3/docker/{id}", s.cadvisorDockerContainer).Methods("GET")
+	return r
+}
+
+func (s *Server) ready(w http.ResponseWriter, r *http.Request) {
+	status := readyStatus{Ready: true}
+	if s.Readiness != nil {
+		status.Ready, status.Pending = s.Readiness.Ready()
+	}
+	if !status.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, status)
+}
+
+func (s *Server) info(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, buildinfo.Get())
+}
+
+func (s *Server) hostMetrics(w http.ResponseWriter, r *http.Request) {
+	result, err := s.collectHostMetrics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// collectHostMetrics returns the metrics collected from the host and the ones
+// provided by the host metrics sources.
+func (s *Server) collectHostMetrics() (map[string]metric.Metric, error) {
+	result := map[string]metric.Metric{}
+	if s.hostClient != nil {
+		metrics, err := s.hostClient.GetHostMetrics()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range metrics {
+			for k, v := range m {
+				result[k] = v
+			}
+		}
+	}
+	for _, source := range s.hostSources {
+		for k, v := range source.HostMetrics() {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	containers, err := s.runningContainers()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	result := make([]containerInfo, 0, len(containers))
+	for _, cont := range containers {
+		result = append(result, newContainerInfo(cont))
+	}
+	writeJSON(w, result)
+}
+
+// runningContainers returns the running containers, skipping the ones gone
+// since they were listed.
+func (s *Server) runningContainers() ([]*container.Container, error) {
+	containers, err := s.infoClient.ListContainers()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*container.Container, 0, len(containers))
+	for _, c := range containers {
+		cont, err := s.infoClient.GetContainer(c.ID, true, nil)
+		if err != nil {
+			continue
+		}
+		result = append(result, cont)
+	}
+	return result, nil
+}
+
+func (s *Server) getContainer(w http.ResponseWriter, r *http.Request) {
+	cont, ok := s.findContainer(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	writeJSON(w, newContainerInfo(cont))
+}
+
+func (s *Server) containerMetrics(w http.ResponseWriter, r *http.Request) {
+	cont, ok := s.findContainer(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	metrics, err := metric.ContainerMetrics(cont)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, metrics)
+}
+
+// admin wraps handlers of admin endpoints, requiring the admin token in the
+// Authorization header.
+func (s *Server) admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken == "" {
+			writeError(w, http.StatusForbidden, errors.New("admin endpoints are disabled"))
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid admin token"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// tailLogs streams the log lines of a container as forwarded by bs, one JSON
+// document per line, until the client disconnects.
+func (s *Server) tailLogs(w http.ResponseWriter, r *http.Request) {
+	if s.LogTailer == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("log forwarder is disabled"))
+		return
+	}
+	if follow, _ := strconv.ParseBool(r.URL.Query().Get("follow")); !follow {
+		writeError(w, http.StatusBadRequest, errors.New("bs doesn't store logs, only follow=1 is supported"))
+		return
+	}
+	cont, ok := s.findContainer(w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+	lines, stop := s.LogTailer.Tail(cont.ID)
+	defer stop()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-lines:
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// config lists every setting read by bs, with its effective and default
+// values and its source, secrets masked.
+func (s *Server) config(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, config.Settings())
+}
+
+// defaultFlushTimeout is the timeout of the /flush endpoint when not set in
+// the timeout query parameter, in seconds.
+const defaultFlushTimeout = 30 * time.Second
+
+// flush sends the buffered logs and metrics immediately, answering after
+// every flusher finishes, concurrently, or the timeout expires.
+func (s *Server) flush(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultFlushTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", v))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	errs := make([]error, len(s.Flushers))
+	var wg sync.WaitGroup
+	for i, flusher := range s.Flushers {
+		wg.Add(1)
+		go func(i int, flusher Flusher) {
+			defer wg.Done()
+			errs[i] = flusher.Flush(timeout)
+		}(i, flusher)
+	}
+	wg.Wait()
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) > 0 {
+		writeError(w, http.StatusInternalServerError, errors.New(strings.Join(msgs, "; ")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listForwarders(w http.ResponseWriter, r *http.Request) {
+	if s.Forwarders == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("log forwarder is disabled"))
+		return
+	}
+	writeJSON(w, s.Forwarders.State()["queues"])
+}
+
+// pauseForwarder pauses or resumes the destination in the destination query
+// parameter, as listed in /forwarders.
+func (s *Server) pauseForwarder(pause bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Forwarders == nil {
+			writeError(w, http.StatusServiceUnavailable, errors.New("log forwarder is disabled"))
+			return
+		}
+		destination := r.URL.Query().Get("destination")
+		if destination == "" {
+			writeError(w, http.StatusBadRequest, errors.New("missing destination"))
+			return
+		}
+		var err error
+		if pause {
+			err = s.Forwarders.Pause(destination)
+		} else {
+			err = s.Forwarders.Resume(destination)
+		}
+		if err == log.ErrUnknownDestination {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) findContainer(w http.ResponseWriter, id string) (*container.Container, bool) {
+	cont, err := s.infoClient.GetContainer(id, true, nil)
+	if err != nil {
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			writeError(w, http.StatusNotFound, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, err)
+		}
+		return nil, false
+	}
+	return cont, true
+}
+
+func newContainerInfo(cont *container.Container) containerInfo {
+	info := containerInfo{
+		ID:          cont.ID,
+		Name:        strings.TrimPrefix(cont.Name, "/"),
+		Hostname:    cont.ShortHostname,
+		TsuruApp:    cont.TsuruApp,
+		AppName:     cont.AppName,
+		ProcessName: cont.ProcessName,
+	}
+	if cont.Config != nil {
+		info.Image = cont.Config.Image
+		info.Labels = cont.Config.Labels
+	}
+	return info
+}
+
+func writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(data)
+	if err != nil {
+		bslog.Errorf("[api] unable to write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	http.Error(w, err.Error(), code)
+}
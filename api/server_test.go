@@ -0,0 +1,385 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/bs/buildinfo"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/log"
+	"github.com/tsuru/bs/metric"
+	"google.golang.org/grpc"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	dockerServer *dtesting.DockerServer
+	containerID  string
+	socketPath   string
+	grpcPath     string
+	server       *Server
+	client       *http.Client
+	grpcConns    []*grpc.ClientConn
+}
+
+type fakeSource map[string]metric.Metric
+
+func (s fakeSource) HostMetrics() map[string]metric.Metric {
+	return s
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	os.Unsetenv("HOST_PROC")
+	var err error
+	s.dockerServer, err = dtesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	client, err := docker.NewClient(s.dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	err = client.PullImage(docker.PullImageOptions{Repository: "tsuru/python"}, docker.AuthConfiguration{})
+	c.Assert(err, check.IsNil)
+	cont, err := client.CreateContainer(docker.CreateContainerOptions{
+		Name: "myapp-web",
+		Config: &docker.Config{
+			Image:  "tsuru/python",
+			Env:    []string{"TSURU_APPNAME=myapp", "TSURU_PROCESSNAME=web"},
+			Labels: map[string]string{"label": "value"},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = s.dockerServer.MutateContainer(cont.ID, docker.State{Running: true})
+	c.Assert(err, check.IsNil)
+	s.containerID = cont.ID
+	s.socketPath = filepath.Join(c.MkDir(), "bs.sock")
+	s.grpcPath = filepath.Join(c.MkDir(), "bs-grpc.sock")
+	s.server = &Server{
+		Address:        "unix://" + s.socketPath,
+		GRPCAddress:    "unix://" + s.grpcPath,
+		DockerEndpoint: s.dockerServer.URL(),
+	}
+	s.server.AddHostMetricsSource(fakeSource{"problems": metric.Int(2)})
+	err = s.server.Start()
+	c.Assert(err, check.IsNil)
+	s.client = &http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", s.socketPath)
+		},
+	}}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	for _, conn := range s.grpcConns {
+		conn.Close()
+	}
+	s.grpcConns = nil
+	s.server.Stop()
+	s.dockerServer.Stop()
+}
+
+func (s *S) get(c *check.C, path string, result interface{}) int {
+	resp, err := s.client.Get("http://bs" + path)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && result != nil {
+		err = json.NewDecoder(resp.Body).Decode(result)
+		c.Assert(err, check.IsNil)
+	}
+	return resp.StatusCode
+}
+
+func (s *S) TestHostMetrics(c *check.C) {
+	var metrics map[string]float64
+	code := s.get(c, "/host/metrics", &metrics)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(metrics, check.DeepEquals, map[string]float64{"problems": 2})
+}
+
+type fakeReadiness map[string]string
+
+func (r fakeReadiness) Ready() (bool, map[string]string) {
+	return len(r) == 0, r
+}
+
+func (s *S) TestReady(c *check.C) {
+	var status readyStatus
+	code := s.get(c, "/ready", &status)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(status, check.DeepEquals, readyStatus{Ready: true})
+	s.server.Readiness = fakeReadiness{}
+	code = s.get(c, "/ready", &status)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(status, check.DeepEquals, readyStatus{Ready: true})
+}
+
+func (s *S) TestReadyPending(c *check.C) {
+	s.server.Readiness = fakeReadiness{"docker": "connection refused"}
+	resp, err := s.client.Get("http://bs/ready")
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusServiceUnavailable)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, "application/json")
+	var status readyStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.DeepEquals, readyStatus{
+		Ready:   false,
+		Pending: map[string]string{"docker": "connection refused"},
+	})
+}
+
+func (s *S) TestInfo(c *check.C) {
+	var info buildinfo.Info
+	code := s.get(c, "/info", &info)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(info, check.DeepEquals, buildinfo.Get())
+}
+
+func (s *S) TestListContainers(c *check.C) {
+	var containers []containerInfo
+	code := s.get(c, "/containers", &containers)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers[0].ID, check.Equals, s.containerID)
+	c.Assert(containers[0].AppName, check.Equals, "myapp")
+}
+
+func (s *S) TestGetContainer(c *check.C) {
+	var cont containerInfo
+	code := s.get(c, "/containers/"+s.containerID, &cont)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(cont, check.DeepEquals, containerInfo{
+		ID:          s.containerID,
+		Name:        "myapp-web",
+		Image:       "tsuru/python",
+		Hostname:    cont.Hostname,
+		TsuruApp:    true,
+		AppName:     "myapp",
+		ProcessName: "web",
+		Labels:      map[string]string{"label": "value"},
+	})
+}
+
+func (s *S) TestGetContainerNotFound(c *check.C) {
+	code := s.get(c, "/containers/unknown", nil)
+	c.Assert(code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestContainerMetrics(c *check.C) {
+	s.dockerServer.PrepareStats(s.containerID, func(id string) docker.Stats {
+		stats := docker.Stats{}
+		stats.MemoryStats.Usage = 512
+		stats.MemoryStats.Limit = 1024
+		return stats
+	})
+	var metrics map[string]float64
+	code := s.get(c, "/containers/"+s.containerID+"/metrics", &metrics)
+	c.Assert(code, check.Equals, http.StatusOK)
+	c.Assert(metrics["mem_max"], check.Equals, float64(512))
+	c.Assert(metrics["mem_pct_max"], check.Equals, float64(50))
+}
+
+func (s *S) TestListenInvalidProtocol(c *check.C) {
+	_, err := listen("udp://127.0.0.1:0", nil)
+	c.Assert(err, check.ErrorMatches, `invalid protocol "udp", expected unix or tcp`)
+}
+
+func (s *S) TestListenTCPRequiresTLS(c *check.C) {
+	_, err := listen("tcp://127.0.0.1:0", nil)
+	c.Assert(err, check.ErrorMatches, `tcp addresses require a TLS certificate and key`)
+}
+
+func (s *S) TestListenTCPWithTLS(c *check.C) {
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+	l, err := listen("tcp://127.0.0.1:0", &tls.Config{Certificates: tlsServer.TLS.Certificates})
+	c.Assert(err, check.IsNil)
+	server := &http.Server{Handler: s.server.router()}
+	go server.Serve(l)
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	resp, err := client.Get("https://" + l.Addr().String() + "/ready")
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+}
+
+type fakeTailer struct {
+	id    string
+	lines chan []byte
+}
+
+func (t *fakeTailer) Tail(id string) (<-chan []byte, func()) {
+	t.id = id
+	return t.lines, func() {}
+}
+
+func (s *S) adminGet(c *check.C, path, token string) *http.Response {
+	req, err := http.NewRequest("GET", "http://bs"+path, nil)
+	c.Assert(err, check.IsNil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := s.client.Do(req)
+	c.Assert(err, check.IsNil)
+	return resp
+}
+
+func (s *S) TestTailLogsAuth(c *check.C) {
+	s.server.LogTailer = &fakeTailer{lines: make(chan []byte)}
+	resp := s.adminGet(c, "/logs/"+s.containerID+"?follow=1", "secret")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusForbidden)
+	s.server.AdminToken = "secret"
+	resp = s.adminGet(c, "/logs/"+s.containerID+"?follow=1", "")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusUnauthorized)
+	resp = s.adminGet(c, "/logs/"+s.containerID+"?follow=1", "wrong")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusUnauthorized)
+	resp = s.adminGet(c, "/logs/"+s.containerID, "secret")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusBadRequest)
+	resp = s.adminGet(c, "/logs/unknown?follow=1", "secret")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestTailLogs(c *check.C) {
+	tailer := &fakeTailer{lines: make(chan []byte, 2)}
+	tailer.lines <- []byte(`{"message":"line 1"}`)
+	tailer.lines <- []byte(`{"message":"line 2"}`)
+	s.server.LogTailer = tailer
+	s.server.AdminToken = "secret"
+	resp := s.adminGet(c, "/logs/myapp-web?follow=1", "secret")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, "application/x-ndjson")
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	c.Assert(err, check.IsNil)
+	c.Assert(line, check.Equals, "{\"message\":\"line 1\"}\n")
+	line, err = reader.ReadString('\n')
+	c.Assert(err, check.IsNil)
+	c.Assert(line, check.Equals, "{\"message\":\"line 2\"}\n")
+	c.Assert(tailer.id, check.Equals, s.containerID)
+}
+
+type fakeForwarders struct {
+	paused map[string]bool
+}
+
+func (f *fakeForwarders) State() map[string]interface{} {
+	return map[string]interface{}{
+		"queues": []map[string]interface{}{{"destination": "syslog udp://127.0.0.1:1514", "paused": f.paused["syslog udp://127.0.0.1:1514"]}},
+	}
+}
+
+func (f *fakeForwarders) Pause(destination string) error {
+	return f.set(destination, true)
+}
+
+func (f *fakeForwarders) Resume(destination string) error {
+	return f.set(destination, false)
+}
+
+func (f *fakeForwarders) set(destination string, paused bool) error {
+	if destination != "syslog udp://127.0.0.1:1514" {
+		return log.ErrUnknownDestination
+	}
+	f.paused[destination] = paused
+	return nil
+}
+
+func (s *S) adminPost(c *check.C, path, token string) int {
+	req, err := http.NewRequest("POST", "http://bs"+path, nil)
+	c.Assert(err, check.IsNil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := s.client.Do(req)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func (s *S) TestPauseResumeForwarders(c *check.C) {
+	forwarders := &fakeForwarders{paused: map[string]bool{}}
+	s.server.Forwarders = forwarders
+	s.server.AdminToken = "secret"
+	destination := url.QueryEscape("syslog udp://127.0.0.1:1514")
+	c.Assert(s.adminPost(c, "/forwarders/pause?destination="+destination, "wrong"), check.Equals, http.StatusUnauthorized)
+	c.Assert(s.adminPost(c, "/forwarders/pause", "secret"), check.Equals, http.StatusBadRequest)
+	c.Assert(s.adminPost(c, "/forwarders/pause?destination=other", "secret"), check.Equals, http.StatusNotFound)
+	c.Assert(s.adminPost(c, "/forwarders/pause?destination="+destination, "secret"), check.Equals, http.StatusNoContent)
+	c.Assert(forwarders.paused, check.DeepEquals, map[string]bool{"syslog udp://127.0.0.1:1514": true})
+	resp := s.adminGet(c, "/forwarders", "secret")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	var queues []map[string]interface{}
+	err := json.NewDecoder(resp.Body).Decode(&queues)
+	c.Assert(err, check.IsNil)
+	c.Assert(queues, check.DeepEquals, []map[string]interface{}{{"destination": "syslog udp://127.0.0.1:1514", "paused": true}})
+	c.Assert(s.adminPost(c, "/forwarders/resume?destination="+destination, "secret"), check.Equals, http.StatusNoContent)
+	c.Assert(forwarders.paused, check.DeepEquals, map[string]bool{"syslog udp://127.0.0.1:1514": false})
+}
+
+type fakeFlusher struct {
+	timeout time.Duration
+	err     error
+}
+
+func (f *fakeFlusher) Flush(timeout time.Duration) error {
+	f.timeout = timeout
+	return f.err
+}
+
+func (s *S) TestFlush(c *check.C) {
+	logs := &fakeFlusher{}
+	metrics := &fakeFlusher{}
+	s.server.Flushers = []Flusher{logs, metrics}
+	s.server.AdminToken = "secret"
+	c.Assert(s.adminPost(c, "/flush", "wrong"), check.Equals, http.StatusUnauthorized)
+	c.Assert(s.adminPost(c, "/flush", "secret"), check.Equals, http.StatusNoContent)
+	c.Assert(logs.timeout, check.Equals, defaultFlushTimeout)
+	c.Assert(metrics.timeout, check.Equals, defaultFlushTimeout)
+	c.Assert(s.adminPost(c, "/flush?timeout=5", "secret"), check.Equals, http.StatusNoContent)
+	c.Assert(logs.timeout, check.Equals, 5*time.Second)
+	c.Assert(s.adminPost(c, "/flush?timeout=x", "secret"), check.Equals, http.StatusBadRequest)
+	metrics.err = errors.New("timeout waiting for metrics to be sent")
+	c.Assert(s.adminPost(c, "/flush", "secret"), check.Equals, http.StatusInternalServerError)
+}
+
+func (s *S) TestConfig(c *check.C) {
+	s.server.AdminToken = "secret"
+	resp := s.adminGet(c, "/config", "wrong")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusUnauthorized)
+	resp = s.adminGet(c, "/config", "secret")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	var settings []config.Setting
+	err := json.NewDecoder(resp.Body).Decode(&settings)
+	c.Assert(err, check.IsNil)
+	c.Assert(settings, check.DeepEquals, config.Settings())
+}
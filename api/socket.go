@@ -0,0 +1,61 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// setSocketPermissions applies the configured mode and ownership to the unix
+// socket in path, allowing non-root processes in the node to use the API.
+func (s *Server) setSocketPermissions(path string) error {
+	if s.SocketMode != 0 {
+		if err := os.Chmod(path, s.SocketMode); err != nil {
+			return err
+		}
+	}
+	if s.SocketOwner == "" && s.SocketGroup == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	var err error
+	if s.SocketOwner != "" {
+		if uid, err = lookupUID(s.SocketOwner); err != nil {
+			return err
+		}
+	}
+	if s.SocketGroup != "" {
+		if gid, err = lookupGID(s.SocketGroup); err != nil {
+			return err
+		}
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupUID returns the ID of the user with the given name or ID.
+func lookupUID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// lookupGID returns the ID of the group with the given name or ID.
+func lookupGID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(g.Gid)
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package api
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestServerSocketPermissions(c *check.C) {
+	current, err := user.Current()
+	c.Assert(err, check.IsNil)
+	path := filepath.Join(c.MkDir(), "perms.sock")
+	server := &Server{
+		Address:        "unix://" + path,
+		DockerEndpoint: s.dockerServer.URL(),
+		SocketOwner:    current.Username,
+		SocketGroup:    current.Gid,
+		SocketMode:     0600,
+	}
+	err = server.Start()
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	info, err := os.Stat(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(info.Mode().Perm(), check.Equals, os.FileMode(0600))
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(strconv.Itoa(int(stat.Uid)), check.Equals, current.Uid)
+	c.Assert(strconv.Itoa(int(stat.Gid)), check.Equals, current.Gid)
+}
+
+func (s *S) TestServerSocketUnknownOwner(c *check.C) {
+	path := filepath.Join(c.MkDir(), "perms.sock")
+	server := &Server{
+		Address:        "unix://" + path,
+		DockerEndpoint: s.dockerServer.URL(),
+		SocketOwner:    "bs-unknown-user",
+	}
+	err := server.Start()
+	c.Assert(err, check.ErrorMatches, `unable to set permissions of socket ".*perms.sock": .*bs-unknown-user.*`)
+}
+
+func (s *S) TestLookupIDs(c *check.C) {
+	uid, err := lookupUID("1234")
+	c.Assert(err, check.IsNil)
+	c.Assert(uid, check.Equals, 1234)
+	gid, err := lookupGID("4321")
+	c.Assert(err, check.IsNil)
+	c.Assert(gid, check.Equals, 4321)
+	current, err := user.Current()
+	c.Assert(err, check.IsNil)
+	uid, err = lookupUID(current.Username)
+	c.Assert(err, check.IsNil)
+	c.Assert(strconv.Itoa(uid), check.Equals, current.Uid)
+}
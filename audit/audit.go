@@ -0,0 +1,256 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package audit records the actions taken by bs itself, like the
+// configuration it started with, forwarder reconnections, dropped messages
+// and garbage collection, in a dedicated stream kept apart from the regular
+// logs for post-incident analysis.
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+const (
+	DefaultFacility = "local6"
+
+	syslogTag       = "bs-audit"
+	syslogSeverity  = 6
+	dialTimeout     = 5 * time.Second
+	writeTimeout    = 5 * time.Second
+	timestampLayout = time.RFC3339
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var (
+	mu     sync.Mutex
+	stream *auditStream
+	// now is overridden by tests.
+	now = time.Now
+)
+
+// queueSize is the number of records waiting to be written. Records are
+// dropped when the queue is full, so a slow destination never blocks bs.
+const queueSize = 1000
+
+type auditStream struct {
+	dial     func() (io.WriteCloser, error)
+	syslog   bool
+	priority int
+	hostname string
+	writer   io.WriteCloser
+	failing  bool
+	dropping bool
+	records  chan record
+	quit     chan struct{}
+}
+
+// record is a formatted line, or a flush request, closing flushed once the
+// records queued before it are written.
+type record struct {
+	line    string
+	flushed chan struct{}
+}
+
+// Setup configures the destination of audit records. It may be a file path, a
+// file:// URL or a udp://, tcp://, unix:// or unixgram:// syslog address, in
+// which case records are sent with the given facility, or DefaultFacility if
+// empty. An empty destination disables auditing.
+func Setup(destination, facility string) error {
+	s, err := newStream(destination, facility)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		s.records = make(chan record, queueSize)
+		s.quit = make(chan struct{})
+		go s.run()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if stream != nil {
+		close(stream.quit)
+	}
+	stream = s
+	return nil
+}
+
+func newStream(destination, facility string) (*auditStream, error) {
+	if destination == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit log destination %q: %s", destination, err)
+	}
+	switch u.Scheme {
+	case "", "file":
+		path := u.Path
+		return &auditStream{dial: func() (io.WriteCloser, error) {
+			return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		}}, nil
+	case "udp", "tcp", "unix", "unixgram":
+		if facility == "" {
+			facility = DefaultFacility
+		}
+		code, ok := facilities[strings.ToLower(facility)]
+		if !ok {
+			return nil, fmt.Errorf("invalid audit log syslog facility %q", facility)
+		}
+		address := u.Host
+		if u.Scheme == "unix" || u.Scheme == "unixgram" {
+			address = u.Path
+		}
+		hostname, _ := os.Hostname()
+		return &auditStream{
+			dial: func() (io.WriteCloser, error) {
+				return net.DialTimeout(u.Scheme, address, dialTimeout)
+			},
+			syslog:   true,
+			priority: code*8 + syslogSeverity,
+			hostname: hostname,
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid audit log destination %q: unsupported scheme %q", destination, u.Scheme)
+}
+
+// Enabled returns whether audit records are being written anywhere.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return stream != nil
+}
+
+// Record queues an audit record of the given category, e.g. "config", "log"
+// or "gc", to be written in background. It does nothing when auditing is
+// disabled, and drops the record when the queue is full.
+func Record(category, msg string, params ...interface{}) {
+	mu.Lock()
+	defer mu.Unlock()
+	if stream == nil {
+		return
+	}
+	line := stream.format(category, fmt.Sprintf(msg, params...))
+	select {
+	case stream.records <- record{line: line}:
+		stream.dropping = false
+	default:
+		if !stream.dropping {
+			bslog.Errorf("[audit] queue full, dropping audit records")
+		}
+		stream.dropping = true
+	}
+}
+
+// Flush waits up to timeout for the queued records to be written, so they're
+// not lost when bs exits.
+func Flush(timeout time.Duration) error {
+	mu.Lock()
+	s := stream
+	mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	r := record{flushed: make(chan struct{})}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case s.records <- r:
+	case <-s.quit:
+		return nil
+	case <-timer.C:
+		return errors.New("timeout flushing audit records")
+	}
+	select {
+	case <-r.flushed:
+		return nil
+	case <-timer.C:
+		return errors.New("timeout flushing audit records")
+	}
+}
+
+// run writes the queued records until the stream is replaced by Setup,
+// writing the ones already queued before closing the destination.
+func (s *auditStream) run() {
+	for {
+		select {
+		case r := <-s.records:
+			s.handle(r)
+		case <-s.quit:
+			for {
+				select {
+				case r := <-s.records:
+					s.handle(r)
+				default:
+					if s.writer != nil {
+						s.writer.Close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *auditStream) handle(r record) {
+	if r.flushed != nil {
+		close(r.flushed)
+		return
+	}
+	s.write(r.line)
+}
+
+func (s *auditStream) format(category, msg string) string {
+	if s.syslog {
+		return fmt.Sprintf("<%d>%s %s %s: [%s] %s\n", s.priority, now().Format(time.Stamp), s.hostname, syslogTag, category, msg)
+	}
+	return fmt.Sprintf("%s [%s] %s\n", now().Format(timestampLayout), category, msg)
+}
+
+func (s *auditStream) write(line string) {
+	// Connections may have been closed by the other end, so writing is
+	// retried once with a new one.
+	var err error
+	for i := 0; i < 2; i++ {
+		if s.writer == nil {
+			s.writer, err = s.dial()
+			if err != nil {
+				continue
+			}
+		}
+		if conn, ok := s.writer.(net.Conn); ok {
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		}
+		_, err = io.WriteString(s.writer, line)
+		if err == nil {
+			break
+		}
+		s.writer.Close()
+		s.writer = nil
+	}
+	if err != nil {
+		if !s.failing {
+			bslog.Errorf("[audit] unable to write audit record: %s", err)
+		}
+		s.failing = true
+		return
+	}
+	s.failing = false
+}
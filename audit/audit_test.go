@@ -0,0 +1,144 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package audit
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (s *S) SetUpTest(c *check.C) {
+	now = func() time.Time {
+		return time.Date(2017, 6, 5, 16, 13, 47, 0, time.UTC)
+	}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	Setup("", "")
+	now = time.Now
+}
+
+func (s *S) TestRecordDisabled(c *check.C) {
+	c.Assert(Setup("", DefaultFacility), check.IsNil)
+	c.Assert(Enabled(), check.Equals, false)
+	Record("config", "nothing %d", 1)
+}
+
+func (s *S) TestRecordFile(c *check.C) {
+	dir := c.MkDir()
+	path := filepath.Join(dir, "audit.log")
+	c.Assert(Setup(path, DefaultFacility), check.IsNil)
+	c.Assert(Enabled(), check.Equals, true)
+	Record("config", "started with %s", "a=b")
+	Record("gc", "removed container %s", "abc")
+	c.Assert(Flush(5*time.Second), check.IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "2017-06-05T16:13:47Z [config] started with a=b\n"+
+		"2017-06-05T16:13:47Z [gc] removed container abc\n")
+	c.Assert(Setup("file://"+path, DefaultFacility), check.IsNil)
+	Record("log", "appended")
+	c.Assert(Flush(5*time.Second), check.IsNil)
+	data, err = ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Matches, `(?s).*\[gc\] removed container abc\n.*\[log\] appended\n$`)
+}
+
+func (s *S) TestRecordSyslog(c *check.C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	c.Assert(Setup("udp://"+conn.LocalAddr().String(), ""), check.IsNil)
+	c.Assert(stream.priority, check.Equals, 22*8+6)
+	c.Assert(Setup("udp://"+conn.LocalAddr().String(), "local0"), check.IsNil)
+	Record("log", "lost connection to %s", "syslog udp://x")
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, check.IsNil)
+	hostname, _ := os.Hostname()
+	c.Assert(string(buf[:n]), check.Equals, "<134>Jun  5 16:13:47 "+hostname+" bs-audit: [log] lost connection to syslog udp://x\n")
+}
+
+func (s *S) TestRecordTCPReconnect(c *check.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	lines := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 1024)
+			n, _ := conn.Read(buf)
+			lines <- string(buf[:n])
+			conn.Close()
+		}
+	}()
+	c.Assert(Setup("tcp://"+l.Addr().String(), "daemon"), check.IsNil)
+	Record("gc", "first")
+	c.Assert(<-lines, check.Matches, `<30>.* bs-audit: \[gc\] first\n`)
+	// Writes to a closed connection may only fail after a few attempts.
+	deadline := time.After(5 * time.Second)
+	for {
+		Record("gc", "second")
+		select {
+		case line := <-lines:
+			c.Assert(line, check.Matches, `<30>.* bs-audit: \[gc\] second\n`)
+			return
+		case <-deadline:
+			c.Fatal("timeout waiting for reconnection")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestRecordDropsWhenFull(c *check.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	c.Assert(Setup("tcp://"+l.Addr().String(), ""), check.IsNil)
+	// The connection is never read, so the queue fills once the socket
+	// buffers are full, and Record must not block.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100*queueSize; i++ {
+			Record("log", "%0512d", i)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		c.Fatal("Record blocked on a full queue")
+	}
+	c.Assert(Flush(10*time.Millisecond), check.NotNil)
+}
+
+func (s *S) TestFlushDisabled(c *check.C) {
+	c.Assert(Flush(time.Second), check.IsNil)
+}
+
+func (s *S) TestSetupInvalid(c *check.C) {
+	c.Assert(Setup("http://localhost", DefaultFacility), check.ErrorMatches, `invalid audit log destination "http://localhost": unsupported scheme "http"`)
+	c.Assert(Setup("udp://localhost:514", "local9"), check.ErrorMatches, `invalid audit log syslog facility "local9"`)
+	c.Assert(Setup("%zz", DefaultFacility), check.ErrorMatches, `invalid audit log destination .*`)
+}
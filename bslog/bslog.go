@@ -20,6 +20,10 @@ func Debugf(msg string, params ...interface{}) {
 	}
 }
 
+func Infof(msg string, params ...interface{}) {
+	logPrintf("INFO", msg, params...)
+}
+
 func Warnf(msg string, params ...interface{}) {
 	logPrintf("WARNING", msg, params...)
 }
@@ -0,0 +1,105 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bstest
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tsuru/bs/metric"
+)
+
+// Stat is a metric sent to a FakeBackend. Host metrics have the Host field set
+// and the Container field empty.
+type Stat struct {
+	Container metric.ContainerInfo
+	Host      metric.HostInfo
+	Key       string
+	Value     interface{}
+}
+
+// FakeBackend is a metric.Backend keeping every metric sent to it in memory.
+type FakeBackend struct {
+	mu       sync.Mutex
+	stats    []Stat
+	failures []error
+}
+
+// Register registers b as the metrics backend with the given name, to be
+// used by metric runners configured with it.
+func (b *FakeBackend) Register(name string) {
+	metric.Register(name, func() (metric.Backend, error) {
+		return b, nil
+	})
+}
+
+func (b *FakeBackend) Send(container metric.ContainerInfo, key string, value interface{}) error {
+	return b.add(Stat{Container: container, Key: key, Value: value})
+}
+
+func (b *FakeBackend) SendConn(container metric.ContainerInfo, host string) error {
+	return b.add(Stat{Container: container, Key: "connection", Value: host})
+}
+
+func (b *FakeBackend) SendHost(host metric.HostInfo, key string, value interface{}) error {
+	return b.add(Stat{Host: host, Key: key, Value: value})
+}
+
+func (b *FakeBackend) add(stat Stat) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.failures) > 0 {
+		err := b.failures[0]
+		b.failures = b.failures[1:]
+		return err
+	}
+	b.stats = append(b.stats, stat)
+	return nil
+}
+
+// PrepareFailure makes the next call to any of the send methods fail with
+// err. Failures are returned in the order they were prepared.
+func (b *FakeBackend) PrepareFailure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = append(b.failures, err)
+}
+
+// Stats returns the metrics sent so far, sorted by container name, host name
+// and key, as metrics are sent concurrently.
+func (b *FakeBackend) Stats() []Stat {
+	b.mu.Lock()
+	stats := append([]Stat(nil), b.stats...)
+	b.mu.Unlock()
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Container.Name != stats[j].Container.Name {
+			return stats[i].Container.Name < stats[j].Container.Name
+		}
+		if stats[i].Host.Name != stats[j].Host.Name {
+			return stats[i].Host.Name < stats[j].Host.Name
+		}
+		return stats[i].Key < stats[j].Key
+	})
+	return stats
+}
+
+// Values returns the values of the metrics sent with key.
+func (b *FakeBackend) Values(key string) []interface{} {
+	var values []interface{}
+	for _, stat := range b.Stats() {
+		if stat.Key == key {
+			values = append(values, stat.Value)
+		}
+	}
+	return values
+}
+
+// Reset discards the metrics sent and the prepared failures.
+func (b *FakeBackend) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats = nil
+	b.failures = nil
+}
@@ -0,0 +1,9 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bstest provides test doubles for programs embedding bs components:
+// a fake metrics backend, a fake log forwarding destination and a fake
+// Docker server with helpers to create tsuru app containers, so integration
+// tests don't need a real Docker daemon or log server.
+package bstest
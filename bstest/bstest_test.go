@@ -0,0 +1,116 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bstest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (s *S) TestFakeBackend(c *check.C) {
+	var b FakeBackend
+	b.Register("bstest-fake")
+	backend, err := metric.Get("bstest-fake")
+	c.Assert(err, check.IsNil)
+	c.Assert(backend, check.Equals, &b)
+	info := metric.ContainerInfo{Name: "c1", App: "myapp"}
+	c.Assert(b.Send(info, "mem", 10), check.IsNil)
+	c.Assert(b.Send(info, "cpu", 0.5), check.IsNil)
+	c.Assert(b.SendConn(info, "10.0.0.1:80"), check.IsNil)
+	c.Assert(b.SendHost(metric.HostInfo{Name: "node1"}, "load1", 2), check.IsNil)
+	c.Assert(b.Stats(), check.DeepEquals, []Stat{
+		{Host: metric.HostInfo{Name: "node1"}, Key: "load1", Value: 2},
+		{Container: info, Key: "connection", Value: "10.0.0.1:80"},
+		{Container: info, Key: "cpu", Value: 0.5},
+		{Container: info, Key: "mem", Value: 10},
+	})
+	c.Assert(b.Values("cpu"), check.DeepEquals, []interface{}{0.5})
+	failure := errors.New("backend down")
+	b.PrepareFailure(failure)
+	c.Assert(b.Send(info, "mem", 11), check.Equals, failure)
+	c.Assert(b.Send(info, "mem", 12), check.IsNil)
+	c.Assert(b.Values("mem"), check.DeepEquals, []interface{}{10, 12})
+	b.PrepareFailure(failure)
+	b.Reset()
+	c.Assert(b.Stats(), check.HasLen, 0)
+	c.Assert(b.Send(info, "mem", 13), check.IsNil)
+}
+
+func (s *S) TestFakeForwarderTCP(c *check.C) {
+	f, err := NewFakeForwarder("tcp")
+	c.Assert(err, check.IsNil)
+	defer f.Stop()
+	c.Assert(f.Address(), check.Matches, `tcp://127\.0\.0\.1:\d+`)
+	conn, err := net.Dial("tcp", f.Address()[len("tcp://"):])
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	fmt.Fprint(conn, "<30>msg 1\n<30>msg 2\n")
+	msgs, err := f.WaitMessages(2, 5*time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(msgs, check.DeepEquals, []string{"<30>msg 1", "<30>msg 2"})
+	f.Reset()
+	c.Assert(f.Messages(), check.HasLen, 0)
+	_, err = f.WaitMessages(1, 50*time.Millisecond)
+	c.Assert(err, check.ErrorMatches, "timeout waiting for 1 messages, got 0")
+}
+
+func (s *S) TestFakeForwarderUDP(c *check.C) {
+	f, err := NewFakeForwarder("udp")
+	c.Assert(err, check.IsNil)
+	defer f.Stop()
+	conn, err := net.Dial("udp", f.Address()[len("udp://"):])
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	fmt.Fprint(conn, "<30>msg 1\n")
+	msgs, err := f.WaitMessages(1, 5*time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(msgs, check.DeepEquals, []string{"<30>msg 1"})
+}
+
+func (s *S) TestFakeForwarderInvalidNetwork(c *check.C) {
+	_, err := NewFakeForwarder("sctp")
+	c.Assert(err, check.ErrorMatches, `unsupported network "sctp"`)
+}
+
+func (s *S) TestFakeDockerServer(c *check.C) {
+	server, err := NewFakeDockerServer()
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	id, err := server.AddAppContainer("web-1", "myapp", "web")
+	c.Assert(err, check.IsNil)
+	client, err := container.NewClient(server.URL())
+	c.Assert(err, check.IsNil)
+	cont, err := client.GetAppContainer(id, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.AppName, check.Equals, "myapp")
+	c.Assert(cont.ProcessName, check.Equals, "web")
+	c.Assert(cont.State.Running, check.Equals, true)
+	c.Assert(server.SetRunning(id, false), check.IsNil)
+	inspected, err := server.Client().InspectContainer(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(inspected.State.Running, check.Equals, false)
+	id, err = server.AddContainer(ContainerOptions{Name: "other", Image: "busybox", Labels: map[string]string{"a": "b"}})
+	c.Assert(err, check.IsNil)
+	inspected, err = server.Client().InspectContainer(id)
+	c.Assert(err, check.IsNil)
+	c.Assert(inspected.Config.Image, check.Equals, "busybox")
+	c.Assert(inspected.Config.Labels, check.DeepEquals, map[string]string{"a": "b"})
+	c.Assert(inspected.State.Running, check.Equals, false)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bstest
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+)
+
+// DefaultImage is the image used by containers created by FakeDockerServer.
+const DefaultImage = "tsuru/python"
+
+// FakeDockerServer is a fake Docker API server, with helpers to create the
+// containers of tsuru apps and to change their state.
+type FakeDockerServer struct {
+	*dtesting.DockerServer
+	client *docker.Client
+}
+
+// ContainerOptions are the options of containers created by
+// FakeDockerServer.AddContainer. Only the name is required.
+type ContainerOptions struct {
+	Name   string
+	Image  string
+	Env    []string
+	Labels map[string]string
+	State  *docker.State
+}
+
+// NewFakeDockerServer starts a fake Docker server listening in a random port
+// of the loopback interface.
+func NewFakeDockerServer() (*FakeDockerServer, error) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := docker.NewClient(server.URL())
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+	return &FakeDockerServer{DockerServer: server, client: client}, nil
+}
+
+// Client returns a client of the server.
+func (s *FakeDockerServer) Client() *docker.Client {
+	return s.client
+}
+
+// AddContainer creates a container, pulling its image first, and sets its
+// state when given, returning the container ID.
+func (s *FakeDockerServer) AddContainer(opts ContainerOptions) (string, error) {
+	image := opts.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	err := s.client.PullImage(docker.PullImageOptions{Repository: image}, docker.AuthConfiguration{})
+	if err != nil {
+		return "", err
+	}
+	cont, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: opts.Name,
+		Config: &docker.Config{
+			Image:  image,
+			Cmd:    []string{"mycmd"},
+			Env:    opts.Env,
+			Labels: opts.Labels,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if opts.State != nil {
+		err = s.MutateContainer(cont.ID, *opts.State)
+		if err != nil {
+			return "", err
+		}
+	}
+	return cont.ID, nil
+}
+
+// AddAppContainer creates a running container of a tsuru app, with the
+// environment variables bs uses to identify the app and process.
+func (s *FakeDockerServer) AddAppContainer(name, appName, processName string) (string, error) {
+	return s.AddContainer(ContainerOptions{
+		Name:  name,
+		Env:   []string{"TSURU_APPNAME=" + appName, "TSURU_PROCESSNAME=" + processName},
+		State: &docker.State{Running: true, Pid: 1},
+	})
+}
+
+// SetRunning changes whether the container is running.
+func (s *FakeDockerServer) SetRunning(id string, running bool) error {
+	cont, err := s.client.InspectContainer(id)
+	if err != nil {
+		return err
+	}
+	state := cont.State
+	state.Running = running
+	return s.MutateContainer(id, state)
+}
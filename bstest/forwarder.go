@@ -0,0 +1,172 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bstest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeForwarder is a syslog server receiving the messages forwarded by bs,
+// over TCP, one message per line, or UDP, one message per datagram. Use
+// Address in LOG_SYSLOG_FORWARD_ADDRESSES.
+type FakeForwarder struct {
+	network  string
+	listener net.Listener
+	conn     net.PacketConn
+	mu       sync.Mutex
+	messages []string
+	notify   chan struct{}
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewFakeForwarder starts a fake forwarding destination listening in a random
+// port of the loopback interface. The network is either "tcp" or "udp".
+func NewFakeForwarder(network string) (*FakeForwarder, error) {
+	f := &FakeForwarder{
+		network: network,
+		notify:  make(chan struct{}, 1),
+		conns:   make(map[net.Conn]struct{}),
+	}
+	switch network {
+	case "tcp":
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		f.listener = l
+		f.wg.Add(1)
+		go f.accept()
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		f.conn = conn
+		f.wg.Add(1)
+		go f.readPackets()
+	default:
+		return nil, fmt.Errorf("unsupported network %q", network)
+	}
+	return f, nil
+}
+
+// Address returns the address of the forwarder in the format used in
+// LOG_SYSLOG_FORWARD_ADDRESSES, e.g. tcp://127.0.0.1:4321.
+func (f *FakeForwarder) Address() string {
+	if f.listener != nil {
+		return "tcp://" + f.listener.Addr().String()
+	}
+	return "udp://" + f.conn.LocalAddr().String()
+}
+
+func (f *FakeForwarder) accept() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		if f.closed {
+			f.mu.Unlock()
+			conn.Close()
+			return
+		}
+		f.conns[conn] = struct{}{}
+		f.mu.Unlock()
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer func() {
+				conn.Close()
+				f.mu.Lock()
+				delete(f.conns, conn)
+				f.mu.Unlock()
+			}()
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				f.add(scanner.Text())
+			}
+		}()
+	}
+}
+
+func (f *FakeForwarder) readPackets() {
+	defer f.wg.Done()
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		f.add(strings.TrimSuffix(string(buf[:n]), "\n"))
+	}
+}
+
+func (f *FakeForwarder) add(msg string) {
+	f.mu.Lock()
+	f.messages = append(f.messages, msg)
+	f.mu.Unlock()
+	select {
+	case f.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Messages returns the messages received so far.
+func (f *FakeForwarder) Messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.messages...)
+}
+
+// WaitMessages waits until at least n messages are received, returning them,
+// or the timeout expires, returning an error.
+func (f *FakeForwarder) WaitMessages(n int, timeout time.Duration) ([]string, error) {
+	deadline := time.After(timeout)
+	for {
+		if msgs := f.Messages(); len(msgs) >= n {
+			return msgs, nil
+		}
+		select {
+		case <-f.notify:
+		case <-deadline:
+			return nil, fmt.Errorf("timeout waiting for %d messages, got %d", n, len(f.Messages()))
+		}
+	}
+}
+
+// Reset discards the messages received.
+func (f *FakeForwarder) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = nil
+}
+
+// Stop closes the listener and every connection, blocking until they're
+// closed.
+func (f *FakeForwarder) Stop() {
+	if f.listener != nil {
+		f.listener.Close()
+		f.mu.Lock()
+		f.closed = true
+		for conn := range f.conns {
+			conn.Close()
+		}
+		f.mu.Unlock()
+	}
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.wg.Wait()
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package buildinfo describes the running bs build, so version skew across
+// the nodes of a cluster can be audited. The version, git commit and build
+// date are set at build time with the linker, e.g.:
+//
+//	go build -ldflags "-X github.com/tsuru/bs/buildinfo.GitCommit=$(git rev-parse --short HEAD)"
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/metric"
+)
+
+var (
+	Version   = "v1.12"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+type Info struct {
+	Version   string
+	GitCommit string
+	BuildDate string
+	GoVersion string
+	// Features are the optional features enabled in the configuration.
+	Features []string
+}
+
+// Get returns the info of the running build.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Features:  config.Features(),
+	}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s with %s)", i.Version, i.GitCommit, i.BuildDate, i.GoVersion)
+}
+
+// HostMetrics returns the bs_build_info metric, the version encoded as a
+// number, major*10000 + minor*100 + patch, as metric backends don't support
+// labels. It's zero for versions not in the vMAJOR.MINOR[.PATCH] format.
+// The git commit is reported in bs_build_commit, its first 7 hex digits as a
+// number, printed back with "%07x", or zero when unknown.
+func (i Info) HostMetrics() map[string]metric.Metric {
+	return map[string]metric.Metric{
+		"bs_build_info":   metric.Int(int64(versionNumber(i.Version))),
+		"bs_build_commit": metric.Int(commitNumber(i.GitCommit)),
+	}
+}
+
+func commitNumber(commit string) int64 {
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	n, err := strconv.ParseInt(commit, 16, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func versionNumber(version string) int {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0
+	}
+	var number int
+	for i, weight := range []int{10000, 100, 1} {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil || n < 0 || n >= 100 {
+			return 0
+		}
+		number += n * weight
+	}
+	return number
+}
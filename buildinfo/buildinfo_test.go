@@ -0,0 +1,72 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package buildinfo
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (S) TestGet(c *check.C) {
+	oldCommit, oldDate := GitCommit, BuildDate
+	defer func() { GitCommit, BuildDate = oldCommit, oldDate }()
+	GitCommit = "abc123"
+	BuildDate = "2017-11-20T10:00:00Z"
+	info := Get()
+	c.Assert(info.Version, check.Equals, Version)
+	c.Assert(info.GitCommit, check.Equals, "abc123")
+	c.Assert(info.BuildDate, check.Equals, "2017-11-20T10:00:00Z")
+	c.Assert(info.GoVersion, check.Equals, runtime.Version())
+	c.Assert(info.Features, check.NotNil)
+	c.Assert(info.String(), check.Equals, Version+" (commit abc123, built 2017-11-20T10:00:00Z with "+runtime.Version()+")")
+}
+
+func (S) TestHostMetrics(c *check.C) {
+	tests := []struct {
+		version string
+		value   int64
+	}{
+		{"v1.12", 11200},
+		{"v1.12.3", 11203},
+		{"2.0.1", 20001},
+		{"v1", 0},
+		{"v1.2.3.4", 0},
+		{"v1.x", 0},
+		{"v1.100", 0},
+		{"dev", 0},
+	}
+	for _, tt := range tests {
+		metrics := Info{Version: tt.version}.HostMetrics()
+		c.Check(metrics["bs_build_info"], check.Equals, metric.Int(tt.value), check.Commentf("version %q", tt.version))
+	}
+}
+
+func (S) TestHostMetricsCommit(c *check.C) {
+	tests := []struct {
+		commit string
+		value  int64
+	}{
+		{"abc1234", 0xabc1234},
+		{"abc1234def5678", 0xabc1234},
+		{"12f", 0x12f},
+		{"unknown", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		metrics := Info{Version: "v1.12", GitCommit: tt.commit}.HostMetrics()
+		c.Check(metrics["bs_build_commit"], check.Equals, metric.Int(tt.value), check.Commentf("commit %q", tt.commit))
+	}
+}
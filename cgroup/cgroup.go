@@ -0,0 +1,287 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cgroup detects where the host cgroup hierarchies are mounted and
+// how the container runtime lays out container cgroups in them, so container
+// cgroup files can be found regardless of the distribution, cgroup version or
+// Docker cgroup driver.
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+)
+
+// Mode is the cgroup version layout found in the host.
+type Mode int
+
+const (
+	// ModeLegacy means every controller is mounted in a cgroup v1 hierarchy.
+	ModeLegacy Mode = iota
+	// ModeHybrid means resource controllers are mounted as cgroup v1, with
+	// an additional cgroup v2 hierarchy used only for process tracking.
+	ModeHybrid
+	// ModeUnified means every controller is in a single cgroup v2 hierarchy.
+	ModeUnified
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeHybrid:
+		return "hybrid"
+	case ModeUnified:
+		return "unified"
+	}
+	return "legacy"
+}
+
+// Driver is the cgroup driver used by Docker to name container cgroups.
+type Driver string
+
+const (
+	DriverCgroupfs Driver = "cgroupfs"
+	DriverSystemd  Driver = "systemd"
+)
+
+// Hierarchy holds the cgroup mount points found in the host.
+type Hierarchy struct {
+	Mode   Mode
+	Driver Driver
+	// Prefix is prepended to every mount point, used when the host cgroup
+	// filesystem is mounted somewhere else inside the bs container.
+	Prefix      string
+	controllers map[string]string
+	unified     string
+}
+
+var knownControllers = map[string]bool{
+	"blkio":      true,
+	"cpu":        true,
+	"cpuacct":    true,
+	"cpuset":     true,
+	"devices":    true,
+	"freezer":    true,
+	"hugetlb":    true,
+	"memory":     true,
+	"net_cls":    true,
+	"net_prio":   true,
+	"perf_event": true,
+	"pids":       true,
+	"rdma":       true,
+}
+
+var containerIDRegexp = regexp.MustCompile(`(?:^|[/-])([a-fA-F0-9]{64})(?:\.scope)?$`)
+
+// Detect detects the host cgroup hierarchy from the mount table of the host
+// init process, found under HOST_PROC. The driver is detected from the
+// existing container cgroups unless set in CGROUP_DRIVER.
+func Detect() (*Hierarchy, error) {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	file, err := os.Open(filepath.Join(procPath, "1", "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h, err := parseMountInfo(file)
+	if err != nil {
+		return nil, err
+	}
+	h.Prefix = config.StringEnvOrDefault("", "CGROUP_MOUNT_PREFIX")
+	driver := Driver(config.StringEnvOrDefault("", "CGROUP_DRIVER"))
+	switch driver {
+	case DriverCgroupfs, DriverSystemd:
+		h.Driver = driver
+	case "":
+		h.Driver = h.detectDriver()
+	default:
+		return nil, fmt.Errorf("invalid cgroup driver %q", driver)
+	}
+	return h, nil
+}
+
+// parseMountInfo parses a mountinfo file as described in proc(5), returning
+// the cgroup hierarchies found in it.
+func parseMountInfo(r io.Reader) (*Hierarchy, error) {
+	h := &Hierarchy{controllers: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " - ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		mountFields := strings.Fields(parts[0])
+		fsFields := strings.Fields(parts[1])
+		if len(mountFields) < 5 || len(fsFields) < 3 {
+			continue
+		}
+		mountPoint := unescapeMountPoint(mountFields[4])
+		switch fsFields[0] {
+		case "cgroup2":
+			if h.unified == "" {
+				h.unified = mountPoint
+			}
+		case "cgroup":
+			for _, opt := range strings.Split(fsFields[2], ",") {
+				if !isController(opt) {
+					continue
+				}
+				if _, ok := h.controllers[opt]; !ok {
+					h.controllers[opt] = mountPoint
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var resources int
+	for name := range h.controllers {
+		if !strings.HasPrefix(name, "name=") {
+			resources++
+		}
+	}
+	switch {
+	case h.unified != "" && resources == 0:
+		h.Mode = ModeUnified
+	case h.unified != "":
+		h.Mode = ModeHybrid
+	case resources > 0:
+		h.Mode = ModeLegacy
+	default:
+		return nil, fmt.Errorf("no cgroup hierarchy found")
+	}
+	return h, nil
+}
+
+func isController(opt string) bool {
+	if strings.HasPrefix(opt, "name=") {
+		return true
+	}
+	return knownControllers[opt]
+}
+
+// unescapeMountPoint decodes the octal escapes used by the kernel for
+// spaces and other special characters in mount points.
+func unescapeMountPoint(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				buf.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		buf.WriteByte(path[i])
+	}
+	return buf.String()
+}
+
+// Controllers returns the names of the cgroup v1 controllers found, sorted.
+func (h *Hierarchy) Controllers() []string {
+	names := make([]string, 0, len(h.controllers))
+	for name := range h.controllers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MountPoint returns where the hierarchy holding the given controller is
+// mounted, or an empty string if the controller isn't available. In unified
+// mode every controller lives in the cgroup v2 mount.
+func (h *Hierarchy) MountPoint(controller string) string {
+	if h.Mode == ModeUnified {
+		return h.Prefix + h.unified
+	}
+	if mountPoint, ok := h.controllers[controller]; ok {
+		return h.Prefix + mountPoint
+	}
+	return ""
+}
+
+// ContainerPath returns the directory of the container cgroup in the
+// hierarchy of the given controller, trying the layout of the detected
+// driver first.
+func (h *Hierarchy) ContainerPath(controller, id string) (string, error) {
+	mountPoint := h.MountPoint(controller)
+	if mountPoint == "" {
+		return "", fmt.Errorf("cgroup controller %q not found", controller)
+	}
+	candidates := []string{
+		filepath.Join(mountPoint, "docker", id),
+		filepath.Join(mountPoint, "system.slice", "docker-"+id+".scope"),
+	}
+	if h.Driver == DriverSystemd {
+		candidates[0], candidates[1] = candidates[1], candidates[0]
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup for container %q not found in %q", id, mountPoint)
+}
+
+// OpenContainerFile opens a file in the container cgroup directory of the
+// given controller.
+func (h *Hierarchy) OpenContainerFile(controller, id, name string) (*os.File, error) {
+	path, err := h.ContainerPath(controller, id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(path, name))
+}
+
+func (h *Hierarchy) detectDriver() Driver {
+	mountPoint := h.MountPoint("cpu")
+	if mountPoint == "" {
+		mountPoint = h.MountPoint("memory")
+	}
+	if mountPoint == "" {
+		return DriverCgroupfs
+	}
+	if _, err := os.Stat(filepath.Join(mountPoint, "docker")); err == nil {
+		return DriverCgroupfs
+	}
+	scopes, _ := filepath.Glob(filepath.Join(mountPoint, "system.slice", "docker-*.scope"))
+	if len(scopes) > 0 {
+		return DriverSystemd
+	}
+	if h.Mode == ModeUnified {
+		return DriverSystemd
+	}
+	return DriverCgroupfs
+}
+
+// ContainerID returns the container ID found in the contents of a
+// /proc/<pid>/cgroup file, supporting both cgroupfs and systemd driver
+// layouts. It returns an empty string when the process isn't running in a
+// container.
+func ContainerID(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if result := containerIDRegexp.FindStringSubmatch(parts[2]); result != nil {
+			return result[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+// Ubuntu 16.04 on amd64, Docker using the cgroupfs driver.
+const ubuntuXenialAmd64 = `17 22 0:16 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+22 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,discard,data=ordered
+24 17 0:20 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:9 - tmpfs tmpfs ro,mode=755
+25 24 0:21 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:10 - cgroup cgroup rw,xattr,release_agent=/lib/systemd/systemd-cgroups-agent,name=systemd
+28 24 0:24 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:14 - cgroup cgroup rw,cpu,cpuacct
+29 24 0:25 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:15 - cgroup cgroup rw,memory
+30 24 0:26 / /sys/fs/cgroup/blkio rw,nosuid,nodev,noexec,relatime shared:16 - cgroup cgroup rw,blkio
+31 24 0:27 / /sys/fs/cgroup/pids rw,nosuid,nodev,noexec,relatime shared:17 - cgroup cgroup rw,pids`
+
+// CentOS 7 on amd64, Docker using the systemd driver.
+const centos7Amd64 = `18 41 0:17 / /sys rw,nosuid,nodev,noexec,relatime shared:6 - sysfs sysfs rw,seclabel
+24 18 0:20 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:7 - tmpfs tmpfs ro,seclabel,mode=755
+25 24 0:21 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:8 - cgroup cgroup rw,seclabel,xattr,release_agent=/usr/lib/systemd/systemd-cgroups-agent,name=systemd
+28 24 0:24 / /sys/fs/cgroup/cpuacct,cpu rw,nosuid,nodev,noexec,relatime shared:11 - cgroup cgroup rw,seclabel,cpuacct,cpu
+29 24 0:25 / /sys/fs/cgroup/cpuset rw,nosuid,nodev,noexec,relatime shared:12 - cgroup cgroup rw,seclabel,cpuset
+30 24 0:26 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:13 - cgroup cgroup rw,seclabel,memory
+41 0 253:0 / / rw,relatime shared:1 - xfs /dev/mapper/centos-root rw,seclabel,attr2,inode64,noquota`
+
+// Debian 10 on arm64, systemd in hybrid mode.
+const debianBusterArm64 = `20 26 0:19 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+26 1 179:2 / / rw,relatime shared:1 - ext4 /dev/mmcblk0p2 rw
+29 20 0:24 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:4 - tmpfs tmpfs ro,mode=755
+30 29 0:25 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:5 - cgroup2 cgroup2 rw,nsdelegate
+31 29 0:26 / /sys/fs/cgroup/systemd rw,nosuid,nodev,noexec,relatime shared:6 - cgroup cgroup rw,xattr,name=systemd
+35 29 0:30 / /sys/fs/cgroup/cpu,cpuacct rw,nosuid,nodev,noexec,relatime shared:15 - cgroup cgroup rw,cpu,cpuacct
+36 29 0:31 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:16 - cgroup cgroup rw,memory`
+
+// Ubuntu 22.04 on arm64, cgroup v2 only.
+const ubuntuJammyArm64 = `24 29 0:22 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+29 1 259:1 / / rw,relatime shared:1 - ext4 /dev/nvme0n1p1 rw,discard,errors=remount-ro
+33 24 0:27 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw,nsdelegate,memory_recursiveprot`
+
+func (S) TestParseMountInfo(c *check.C) {
+	tests := []struct {
+		name        string
+		data        string
+		mode        Mode
+		controllers []string
+		cpu         string
+		memory      string
+	}{
+		{
+			name:        "ubuntu-xenial-amd64",
+			data:        ubuntuXenialAmd64,
+			mode:        ModeLegacy,
+			controllers: []string{"blkio", "cpu", "cpuacct", "memory", "name=systemd", "pids"},
+			cpu:         "/sys/fs/cgroup/cpu,cpuacct",
+			memory:      "/sys/fs/cgroup/memory",
+		},
+		{
+			name:        "centos-7-amd64",
+			data:        centos7Amd64,
+			mode:        ModeLegacy,
+			controllers: []string{"cpu", "cpuacct", "cpuset", "memory", "name=systemd"},
+			cpu:         "/sys/fs/cgroup/cpuacct,cpu",
+			memory:      "/sys/fs/cgroup/memory",
+		},
+		{
+			name:        "debian-buster-arm64",
+			data:        debianBusterArm64,
+			mode:        ModeHybrid,
+			controllers: []string{"cpu", "cpuacct", "memory", "name=systemd"},
+			cpu:         "/sys/fs/cgroup/cpu,cpuacct",
+			memory:      "/sys/fs/cgroup/memory",
+		},
+		{
+			name:        "ubuntu-jammy-arm64",
+			data:        ubuntuJammyArm64,
+			mode:        ModeUnified,
+			controllers: []string{},
+			cpu:         "/sys/fs/cgroup",
+			memory:      "/sys/fs/cgroup",
+		},
+	}
+	for _, tt := range tests {
+		h, err := parseMountInfo(strings.NewReader(tt.data))
+		c.Assert(err, check.IsNil, check.Commentf(tt.name))
+		c.Assert(h.Mode, check.Equals, tt.mode, check.Commentf(tt.name))
+		c.Assert(h.Controllers(), check.DeepEquals, tt.controllers, check.Commentf(tt.name))
+		c.Assert(h.MountPoint("cpu"), check.Equals, tt.cpu, check.Commentf(tt.name))
+		c.Assert(h.MountPoint("memory"), check.Equals, tt.memory, check.Commentf(tt.name))
+	}
+}
+
+func (S) TestParseMountInfoNoCgroups(c *check.C) {
+	_, err := parseMountInfo(strings.NewReader("22 0 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw"))
+	c.Assert(err, check.ErrorMatches, "no cgroup hierarchy found")
+}
+
+func (S) TestParseMountInfoEscapedMountPoint(c *check.C) {
+	h, err := parseMountInfo(strings.NewReader(`29 24 0:25 / /host\040sys/memory rw - cgroup cgroup rw,memory`))
+	c.Assert(err, check.IsNil)
+	c.Assert(h.MountPoint("memory"), check.Equals, "/host sys/memory")
+}
+
+func (S) TestDetect(c *check.C) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	err = os.MkdirAll(filepath.Join(dir, "proc", "1"), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "proc", "1", "mountinfo"), []byte(centos7Amd64), 0644)
+	c.Assert(err, check.IsNil)
+	scope := filepath.Join(dir, "sys", "fs", "cgroup", "cpuacct,cpu", "system.slice", "docker-abc.scope")
+	err = os.MkdirAll(scope, 0755)
+	c.Assert(err, check.IsNil)
+	os.Setenv("HOST_PROC", filepath.Join(dir, "proc"))
+	os.Setenv("CGROUP_MOUNT_PREFIX", dir)
+	defer os.Unsetenv("HOST_PROC")
+	defer os.Unsetenv("CGROUP_MOUNT_PREFIX")
+	h, err := Detect()
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Mode, check.Equals, ModeLegacy)
+	c.Assert(h.Driver, check.Equals, DriverSystemd)
+	path, err := h.ContainerPath("cpuacct", "abc")
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, scope)
+	err = ioutil.WriteFile(filepath.Join(scope, "cpu.stat"), []byte("nr_periods 0\n"), 0644)
+	c.Assert(err, check.IsNil)
+	file, err := h.OpenContainerFile("cpu", "abc", "cpu.stat")
+	c.Assert(err, check.IsNil)
+	file.Close()
+	_, err = h.ContainerPath("cpuacct", "def")
+	c.Assert(err, check.ErrorMatches, `cgroup for container "def" not found in .*`)
+	_, err = h.ContainerPath("pids", "abc")
+	c.Assert(err, check.ErrorMatches, `cgroup controller "pids" not found`)
+	os.Setenv("CGROUP_DRIVER", "cgroupfs")
+	defer os.Unsetenv("CGROUP_DRIVER")
+	h, err = Detect()
+	c.Assert(err, check.IsNil)
+	c.Assert(h.Driver, check.Equals, DriverCgroupfs)
+	path, err = h.ContainerPath("cpuacct", "abc")
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, scope)
+	os.Setenv("CGROUP_DRIVER", "invalid")
+	_, err = Detect()
+	c.Assert(err, check.ErrorMatches, `invalid cgroup driver "invalid"`)
+}
+
+func (S) TestDetectDriverCgroupfs(c *check.C) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	h, err := parseMountInfo(strings.NewReader(ubuntuJammyArm64))
+	c.Assert(err, check.IsNil)
+	h.Prefix = dir
+	err = os.MkdirAll(filepath.Join(dir, "sys", "fs", "cgroup", "docker", "abc"), 0755)
+	c.Assert(err, check.IsNil)
+	c.Assert(h.detectDriver(), check.Equals, DriverCgroupfs)
+	path, err := h.ContainerPath("memory", "abc")
+	c.Assert(err, check.IsNil)
+	c.Assert(path, check.Equals, filepath.Join(dir, "sys", "fs", "cgroup", "docker", "abc"))
+}
+
+func (S) TestContainerID(c *check.C) {
+	id := "3e86c741c2c6bd556d5e8a3e5bc56363ca40a81927e6d55c30aefdcea7ca54ad"
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"4:memory:/docker/" + id + "\n3:cpu:/docker/" + id, id},
+		{"1:name=systemd:/system.slice/docker-" + id + ".scope", id},
+		{"0::/system.slice/docker-" + id + ".scope", id},
+		{"0::/kubepods.slice/kubepods-besteffort.slice/cri-containerd-" + id + ".scope", id},
+		{"1:name=systemd:/user.slice/user-900.slice/session-22.scope\n0::/", ""},
+		{"0::/", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		c.Assert(ContainerID([]byte(tt.data)), check.Equals, tt.expected, check.Commentf(tt.data))
+	}
+}
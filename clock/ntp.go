@@ -0,0 +1,70 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clock
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	ntpPacketSize = 48
+	// Seconds between the NTP epoch (1900) and the Unix epoch (1970).
+	ntpEpochOffset = 2208988800
+)
+
+var errInvalidNTPResponse = errors.New("invalid NTP response")
+
+// ntpOffset queries the NTP server at addr using SNTP (RFC 4330), returning
+// the offset of the server clock relative to the local clock.
+func ntpOffset(addr string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "123")
+	}
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return 0, err
+	}
+	request := make([]byte, ntpPacketSize)
+	// LI = 0 (no warning), VN = 4, Mode = 3 (client).
+	request[0] = 0x23
+	t1 := time.Now()
+	_, err = conn.Write(request)
+	if err != nil {
+		return 0, err
+	}
+	response := make([]byte, ntpPacketSize)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, err
+	}
+	t4 := time.Now()
+	if n < ntpPacketSize || response[0]&0x7 != 4 {
+		return 0, errInvalidNTPResponse
+	}
+	t2 := ntpTime(response[32:40])
+	t3 := ntpTime(response[40:48])
+	if t3.IsZero() {
+		return 0, errInvalidNTPResponse
+	}
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+func ntpTime(data []byte) time.Time {
+	secs := binary.BigEndian.Uint32(data[:4])
+	frac := binary.BigEndian.Uint32(data[4:])
+	if secs == 0 && frac == 0 {
+		return time.Time{}
+	}
+	nsecs := (int64(frac) * int64(time.Second)) >> 32
+	return time.Unix(int64(secs)-ntpEpochOffset, nsecs)
+}
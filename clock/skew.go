@@ -0,0 +1,161 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package clock periodically measures the skew between the host clock and a
+// reference clock, as skewed nodes silently corrupt log ordering.
+package clock
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/supervisor"
+	"github.com/tsuru/bs/tsuruapi"
+)
+
+const (
+	DefaultInterval  = 5 * time.Minute
+	DefaultThreshold = time.Second
+
+	ntpTimeout = 10 * time.Second
+)
+
+type SkewConfig struct {
+	// NTPServer is the NTP server used as reference clock. When empty, the
+	// Date header returned by the tsuru API is used instead.
+	NTPServer string
+	// TsuruClient is the client used to reach the tsuru API.
+	TsuruClient *tsuruapi.Client
+	// Interval is the interval between measurements.
+	Interval time.Duration
+	// Threshold is the absolute skew above which a warning is logged.
+	Threshold time.Duration
+}
+
+// SkewMonitor measures the clock skew periodically, keeping the last
+// measurement to be reported as a host metric.
+type SkewMonitor struct {
+	config   SkewConfig
+	measure  func() (time.Duration, error)
+	mu       sync.RWMutex
+	skew     time.Duration
+	measured bool
+	abort    chan struct{}
+	exit     chan struct{}
+}
+
+func NewSkewMonitor(config SkewConfig) *SkewMonitor {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultThreshold
+	}
+	m := &SkewMonitor{
+		config: config,
+		abort:  make(chan struct{}),
+		exit:   make(chan struct{}),
+	}
+	if config.NTPServer != "" {
+		m.measure = func() (time.Duration, error) {
+			return ntpOffset(config.NTPServer, ntpTimeout)
+		}
+	} else {
+		m.measure = m.tsuruOffset
+	}
+	return m
+}
+
+// Start measures the clock skew periodically until Stop is called.
+func (m *SkewMonitor) Start() {
+	supervisor.Go("clock skew monitor", func() {
+		for {
+			m.Run()
+			select {
+			case <-m.abort:
+				close(m.exit)
+				return
+			case <-time.After(m.config.Interval):
+			}
+		}
+	})
+}
+
+// Stop stops the monitor, blocking until it actually stops.
+func (m *SkewMonitor) Stop() {
+	close(m.abort)
+	<-m.exit
+}
+
+// Wait blocks until the monitor stops.
+func (m *SkewMonitor) Wait() {
+	<-m.exit
+}
+
+// Run measures the clock skew once. A positive skew means the host clock is
+// behind the reference clock.
+func (m *SkewMonitor) Run() {
+	skew, err := m.measure()
+	if err != nil {
+		bslog.Errorf("[clock skew] unable to measure clock skew: %s", err)
+		return
+	}
+	m.mu.Lock()
+	m.skew = skew
+	m.measured = true
+	m.mu.Unlock()
+	if abs(skew) > m.config.Threshold {
+		bslog.Warnf("[clock skew] host clock is off by %v, above the %v threshold", skew, m.config.Threshold)
+	}
+}
+
+// HostMetrics returns the last measured clock skew in milliseconds.
+func (m *SkewMonitor) HostMetrics() map[string]metric.Metric {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.measured {
+		return nil
+	}
+	return map[string]metric.Metric{
+		"clock_skew_ms": metric.Float(float64(m.skew) / float64(time.Millisecond)),
+	}
+}
+
+// tsuruOffset estimates the clock skew using the Date header returned by the
+// tsuru API. The header has a resolution of one second, so the server time
+// is assumed to be in the middle of the second.
+func (m *SkewMonitor) tsuruOffset() (time.Duration, error) {
+	start := time.Now()
+	resp, err := m.config.TsuruClient.Do("GET", "/info", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now()
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	date := resp.Header.Get("Date")
+	if date == "" {
+		return 0, errors.New("no Date header in tsuru API response")
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		return 0, err
+	}
+	serverTime = serverTime.Add(500 * time.Millisecond)
+	localTime := start.Add(end.Sub(start) / 2)
+	return serverTime.Sub(localTime), nil
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
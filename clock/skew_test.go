@@ -0,0 +1,106 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package clock
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/tsuruapi"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (s *S) TestSkewMonitorRun(c *check.C) {
+	m := NewSkewMonitor(SkewConfig{})
+	c.Assert(m.HostMetrics(), check.IsNil)
+	m.measure = func() (time.Duration, error) {
+		return -1500 * time.Millisecond, nil
+	}
+	m.Run()
+	c.Assert(m.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"clock_skew_ms": metric.Float(-1500)})
+	m.measure = func() (time.Duration, error) {
+		return 0, errors.New("unreachable")
+	}
+	m.Run()
+	c.Assert(m.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"clock_skew_ms": metric.Float(-1500)})
+}
+
+func (s *S) TestSkewMonitorStartStop(c *check.C) {
+	m := NewSkewMonitor(SkewConfig{Interval: time.Millisecond})
+	m.measure = func() (time.Duration, error) {
+		return time.Millisecond, nil
+	}
+	m.Start()
+	m.Stop()
+	c.Assert(m.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"clock_skew_ms": metric.Float(1)})
+}
+
+func (s *S) TestSkewMonitorTsuruOffset(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/info")
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	}))
+	defer server.Close()
+	m := NewSkewMonitor(SkewConfig{TsuruClient: tsuruapi.NewClient(tsuruapi.Config{Endpoint: server.URL})})
+	offset, err := m.measure()
+	c.Assert(err, check.IsNil)
+	c.Assert(offset > -time.Hour-2*time.Second && offset < -time.Hour+2*time.Second, check.Equals, true,
+		check.Commentf("offset: %v", offset))
+}
+
+func (s *S) TestNTPOffset(c *check.C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	go func() {
+		request := make([]byte, ntpPacketSize)
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+		response := make([]byte, ntpPacketSize)
+		// LI = 0, VN = 4, Mode = 4 (server).
+		response[0] = 0x24
+		now := time.Now().Add(10 * time.Second)
+		putNTPTime(response[32:40], now)
+		putNTPTime(response[40:48], now)
+		conn.WriteTo(response, addr)
+	}()
+	m := NewSkewMonitor(SkewConfig{NTPServer: conn.LocalAddr().String()})
+	offset, err := m.measure()
+	c.Assert(err, check.IsNil)
+	c.Assert(offset > 9*time.Second && offset < 11*time.Second, check.Equals, true,
+		check.Commentf("offset: %v", offset))
+}
+
+func (s *S) TestNTPTime(c *check.C) {
+	t := time.Date(2017, 3, 1, 12, 30, 15, 500000000, time.UTC)
+	data := make([]byte, 8)
+	putNTPTime(data, t)
+	got := ntpTime(data)
+	c.Assert(got.Sub(t) < time.Microsecond && t.Sub(got) < time.Microsecond, check.Equals, true)
+	c.Assert(ntpTime(make([]byte, 8)).IsZero(), check.Equals, true)
+}
+
+func putNTPTime(data []byte, t time.Time) {
+	secs := uint32(t.Unix() + ntpEpochOffset)
+	frac := uint32((int64(t.Nanosecond()) << 32) / int64(time.Second))
+	binary.BigEndian.PutUint32(data[:4], secs)
+	binary.BigEndian.PutUint32(data[4:], frac)
+}
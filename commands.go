@@ -0,0 +1,216 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/buildinfo"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/dryrun"
+	"github.com/tsuru/bs/log"
+	"github.com/tsuru/bs/metric"
+)
+
+// command is a bs subcommand. bs runs as a daemon when no command is given.
+// Commands are dispatched from a table, with flags parsed by the flag package,
+// rather than through a CLI framework: none is vendored and the commands take
+// few flags, so a new dependency isn't worth it.
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "run", usage: "Run bs, reporting status, logs and metrics until signaled to stop", run: run},
+		{name: "check-config", usage: "Validate the configuration loaded from the environment and print it", run: checkConfig},
+		{name: "collect-once", usage: "Collect host and container metrics once and print them as JSON", run: collectOnce},
+		{name: "metrics", args: "[-once] [-format json|influx|prom]", usage: "Collect host metrics and print them, once or every METRICS_INTERVAL", run: hostMetrics},
+		{name: "replay", args: "[FILE...]", usage: "Forward saved syslog messages through the log backends", run: replay},
+		{name: "version", usage: "Print version, build information and enabled features", run: version},
+		{name: "help", usage: "Print this help", run: help},
+	}
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: bs [COMMAND] [ARGS]\n\nCommands:\n")
+	for _, cmd := range commands {
+		name := cmd.name
+		if cmd.args != "" {
+			name += " " + cmd.args
+		}
+		fmt.Fprintf(w, "  %-20s %s\n", name, cmd.usage)
+	}
+	fmt.Fprintf(w, "\nbs runs when no command is given. Settings are read from environment variables.\n")
+}
+
+func help(args []string) error {
+	usage(os.Stdout)
+	return nil
+}
+
+func version(args []string) error {
+	info := buildinfo.Get()
+	fmt.Printf("bs version %s\n", info.Version)
+	fmt.Printf("git commit: %s\n", info.GitCommit)
+	fmt.Printf("build date: %s\n", info.BuildDate)
+	fmt.Printf("go version: %s\n", info.GoVersion)
+	fmt.Printf("features: %s\n", strings.Join(info.Features, ","))
+	return nil
+}
+
+// checkConfig prints the loaded configuration and every problem found in
+// it, failing if there's any.
+func checkConfig(args []string) error {
+	for _, pair := range strings.Split(config.Summary(), " ") {
+		fmt.Println(pair)
+	}
+	errs := config.Validate()
+	if err := log.ValidateBackends(config.Config.LogBackends); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_BACKENDS: %s", err))
+	}
+	if config.Config.MetricsBackend != "" {
+		if _, err := metric.Get(config.Config.MetricsBackend); err != nil {
+			errs = append(errs, fmt.Errorf("METRICS_BACKEND: %s", err))
+		}
+	}
+	if len(errs) == 0 {
+		fmt.Println("configuration is valid")
+		return nil
+	}
+	fmt.Println()
+	for _, err := range errs {
+		fmt.Printf("error: %s\n", err)
+	}
+	return fmt.Errorf("%d configuration errors found", len(errs))
+}
+
+// collectOnce prints one round of host and container metrics, for debugging.
+func collectOnce(args []string) error {
+	flags := flag.NewFlagSet("bs collect-once", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		return errors.New("unexpected arguments: " + strings.Join(flags.Args(), " "))
+	}
+	snapshot, err := metric.CollectOnce(config.Config.DockerEndpoint)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", data)
+	return nil
+}
+
+// hostMetrics prints the metrics of the host in the given format, once or on
+// every interval until interrupted, without running the daemon.
+func hostMetrics(args []string) error {
+	flags := flag.NewFlagSet("bs metrics", flag.ExitOnError)
+	once := flags.Bool("once", false, "Collect the metrics once and exit")
+	format := flags.String("format", "json", "Output format: "+strings.Join(metric.HostMetricsFormats, ", "))
+	sample := flags.Duration("sample", time.Second, "Time between the first two readings, used to calculate CPU usage")
+	interval := flags.Duration("interval", config.Config.MetricsInterval, "Time between collections, unless -once is given")
+	flags.Parse(args)
+	if err := metric.WriteHostMetrics(ioutil.Discard, *format, "", nil, time.Time{}); err != nil {
+		return err
+	}
+	client, err := metric.NewHostClient()
+	if err != nil {
+		return err
+	}
+	hostname, err := client.GetHostname()
+	if err != nil {
+		return err
+	}
+	collect := func() (map[string]float64, error) {
+		metrics, err := client.GetHostMetrics()
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]float64)
+		for _, m := range metrics {
+			for k, v := range m {
+				result[k] = v.Float64()
+			}
+		}
+		return result, nil
+	}
+	if *sample > 0 {
+		if _, err = collect(); err != nil {
+			return err
+		}
+		time.Sleep(*sample)
+	}
+	for {
+		metrics, err := collect()
+		if err != nil {
+			return err
+		}
+		if err = metric.WriteHostMetrics(os.Stdout, *format, hostname, metrics, time.Now()); err != nil {
+			return err
+		}
+		if *once {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// replay forwards the syslog messages saved in the given files, or read from
+// the standard input if no file is given, through the configured log
+// backends.
+func replay(paths []string) error {
+	var readers []io.Reader
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+	if len(readers) == 0 {
+		readers = append(readers, os.Stdin)
+	}
+	if config.Config.DryRun {
+		err := dryrun.Setup(config.Config.DryRunOutput)
+		if err != nil {
+			return err
+		}
+	}
+	lf := log.LogForwarder{
+		DockerEndpoint:  config.Config.DockerEndpoint,
+		EnabledBackends: config.Config.LogBackends,
+	}
+	lines, err := lf.Replay(io.MultiReader(readers...))
+	received, forwarded := lf.Progress()
+	bslog.Infof("Replayed %d lines, %d of %d messages forwarded\n", lines, forwarded, received)
+	return err
+}
@@ -5,8 +5,12 @@
 package config
 
 import (
+	"fmt"
+	"math"
+	"net/url"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,49 +23,375 @@ const (
 	DefaultBufferSize     = 1000000
 	DefaultWsPingInterval = 30
 	DefaultDockerEndpoint = "unix:///var/run/docker.sock"
+	DefaultHostProc       = "/proc"
+
+	maxDurationSeconds = float64(math.MaxInt64 / int64(time.Second))
+
+	defaultEnvPrefix = "BS_"
+)
+
+var (
+	// envPrefix is prepended to the name of every setting, set in
+	// BS_ENV_PREFIX. Prefixed variables take precedence over the legacy
+	// unprefixed ones, avoiding collisions with generic names set in node
+	// images.
+	envPrefix = defaultEnvPrefix
+	// libraryEnvs are read directly by libraries, so their prefixed values
+	// are copied to the unprefixed names when loading the config.
+	libraryEnvs = []string{"HOST_PROC", "HOST_SYS", "HOST_ETC"}
 )
 
 var Config struct {
 	DockerEndpoint      string
 	TsuruEndpoint       string
 	TsuruToken          string
+	TsuruTokenFile      string
+	TsuruDialTimeout    time.Duration
+	TsuruRequestTimeout time.Duration
+	TsuruIdleTimeout    time.Duration
+	TsuruMaxRetries     int
 	MetricsInterval     time.Duration
+	MetricsJitter       time.Duration
 	MetricsBackend      string
 	StatusInterval      time.Duration
+	StatusCompress      bool
+	StatusChunkSize     int
+	StatusDifferential  bool
+	StatusFullSync      time.Duration
+	StatusJitter        time.Duration
+	StatusRetryQueue    int
+	FingerprintFile     string
+	NodeProblemEnabled  bool
+	NodeProblemInterval time.Duration
+	NodeMetadataEnabled bool
+	NodeMetadataTTL     time.Duration
+	ClockSkewEnabled    bool
+	ClockSkewNTPServer  string
+	ClockSkewInterval   time.Duration
+	ClockSkewThreshold  time.Duration
+	HeartbeatInterval   time.Duration
+	HeartbeatPath       string
+	HeartbeatURL        string
+	GCEnabled           bool
+	GCInterval          time.Duration
+	GCDiskPath          string
+	GCThreshold         int
+	GCMinAge            time.Duration
+	WatchdogEnabled     bool
+	WatchdogInterval    time.Duration
+	WatchdogStalled     int
+	WatchdogAction      string
+	StartupWaitTimeout  time.Duration
+	DryRun              bool
+	DryRunOutput        string
+	APIListenAddress    string
+	APIGRPCAddress      string
+	APISocketOwner      string
+	APISocketGroup      string
+	APISocketMode       os.FileMode
+	APIAdminToken       string
+	APITLSCertFile      string
+	APITLSKeyFile       string
 	SyslogListenAddress string
 	LogBackends         []string
+	NetProbeInterval    time.Duration
+	NetProbeTimeout     time.Duration
+	NetProbeDNSNames    []string
+	NetProbeHTTPURLs    []string
+	NetProbeImage       string
+	NetProbePull        bool
+	NetProbeInsecure    bool
+	NetProbeUsername    string
+	NetProbePassword    string
+	AuditLog            string
+	AuditLogFacility    string
 }
 
 func init() {
+	DeclareDefault("HOST_PROC", DefaultHostProc)
 	LoadConfig()
 }
 
 func LoadConfig() {
 	bslog.Debug, _ = strconv.ParseBool(os.Getenv("BS_DEBUG"))
+	envPrefix = defaultEnvPrefix
+	if prefix, ok := os.LookupEnv("BS_ENV_PREFIX"); ok {
+		envPrefix = prefix
+	}
+	for _, env := range libraryEnvs {
+		if v, ok := lookupPrefixedEnv(env); ok {
+			os.Setenv(env, v)
+		}
+	}
+	detectHostPaths()
 	Config.DockerEndpoint = StringEnvOrDefault(DefaultDockerEndpoint, "DOCKER_ENDPOINT")
-	Config.TsuruEndpoint = os.Getenv("TSURU_ENDPOINT")
-	Config.TsuruToken = os.Getenv("TSURU_TOKEN")
-	Config.SyslogListenAddress = os.Getenv("SYSLOG_LISTEN_ADDRESS")
+	Config.TsuruEndpoint = StringEnvOrDefault("", "TSURU_ENDPOINT")
+	Config.TsuruToken = StringEnvOrDefault("", "TSURU_TOKEN")
+	Config.TsuruTokenFile = StringEnvOrDefault("", "TSURU_TOKEN_FILE")
+	Config.TsuruDialTimeout = SecondsEnvOrDefault(0, "TSURU_API_DIAL_TIMEOUT")
+	Config.TsuruRequestTimeout = SecondsEnvOrDefault(0, "TSURU_API_REQUEST_TIMEOUT")
+	Config.TsuruIdleTimeout = SecondsEnvOrDefault(0, "TSURU_API_IDLE_CONN_TIMEOUT")
+	Config.TsuruMaxRetries = IntEnvOrDefault(0, "TSURU_API_MAX_RETRIES")
+	Config.SyslogListenAddress = StringEnvOrDefault("", "SYSLOG_LISTEN_ADDRESS")
+	Config.APIListenAddress = StringEnvOrDefault("", "API_LISTEN_ADDRESS")
+	Config.APIGRPCAddress = StringEnvOrDefault("", "API_GRPC_ADDRESS")
+	Config.APISocketOwner = StringEnvOrDefault("", "API_SOCKET_OWNER")
+	Config.APISocketGroup = StringEnvOrDefault("", "API_SOCKET_GROUP")
+	Config.APISocketMode = FileModeEnvOrDefault(0, "API_SOCKET_MODE")
+	Config.APIAdminToken = StringEnvOrDefault("", "API_ADMIN_TOKEN")
+	Config.APITLSCertFile = StringEnvOrDefault("", "API_TLS_CERT_FILE")
+	Config.APITLSKeyFile = StringEnvOrDefault("", "API_TLS_KEY_FILE")
 	Config.StatusInterval = SecondsEnvOrDefault(DefaultInterval, "STATUS_INTERVAL")
+	Config.StatusCompress = BoolEnvOrDefault(false, "STATUS_COMPRESS")
+	Config.StatusChunkSize = IntEnvOrDefault(0, "STATUS_CHUNK_SIZE")
+	Config.StatusDifferential = BoolEnvOrDefault(false, "STATUS_DIFFERENTIAL")
+	Config.StatusFullSync = SecondsEnvOrDefault(0, "STATUS_FULL_SYNC_INTERVAL")
+	Config.StatusJitter = SecondsEnvOrDefault(0, "STATUS_INTERVAL_JITTER")
+	Config.StatusRetryQueue = IntEnvOrDefault(10, "STATUS_RETRY_QUEUE_SIZE")
+	Config.FingerprintFile = StringEnvOrDefault("", "CONFIG_FINGERPRINT_FILE")
+	Config.NodeProblemEnabled = BoolEnvOrDefault(false, "NODE_PROBLEM_DETECTOR")
+	Config.NodeProblemInterval = SecondsEnvOrDefault(0, "NODE_PROBLEM_INTERVAL")
+	Config.NodeMetadataEnabled = BoolEnvOrDefault(false, "NODE_METADATA_ENABLED")
+	Config.NodeMetadataTTL = SecondsEnvOrDefault(0, "NODE_METADATA_CACHE_TTL")
+	Config.ClockSkewEnabled = BoolEnvOrDefault(false, "CLOCK_SKEW_ENABLED")
+	Config.ClockSkewNTPServer = StringEnvOrDefault("", "CLOCK_SKEW_NTP_SERVER")
+	Config.ClockSkewInterval = SecondsEnvOrDefault(0, "CLOCK_SKEW_INTERVAL")
+	Config.ClockSkewThreshold = time.Duration(IntEnvOrDefault(0, "CLOCK_SKEW_THRESHOLD_MS")) * time.Millisecond
+	Config.HeartbeatInterval = SecondsEnvOrDefault(0, "HEARTBEAT_INTERVAL")
+	Config.HeartbeatPath = StringEnvOrDefault("", "HEARTBEAT_PATH")
+	Config.HeartbeatURL = StringEnvOrDefault("", "HEARTBEAT_URL")
+	Config.GCEnabled = BoolEnvOrDefault(false, "GC_ENABLED")
+	Config.GCInterval = SecondsEnvOrDefault(0, "GC_INTERVAL")
+	Config.GCDiskPath = StringEnvOrDefault("", "GC_DISK_PATH")
+	Config.GCThreshold = IntEnvOrDefault(0, "GC_DISK_THRESHOLD")
+	Config.GCMinAge = SecondsEnvOrDefault(0, "GC_MIN_AGE")
+	Config.WatchdogEnabled = BoolEnvOrDefault(false, "WATCHDOG_ENABLED")
+	Config.WatchdogInterval = SecondsEnvOrDefault(0, "WATCHDOG_INTERVAL")
+	Config.WatchdogStalled = IntEnvOrDefault(0, "WATCHDOG_STALLED_INTERVALS")
+	Config.WatchdogAction = StringEnvOrDefault("", "WATCHDOG_ACTION")
+	Config.StartupWaitTimeout = SecondsEnvOrDefault(0, "STARTUP_WAIT_TIMEOUT")
+	Config.DryRun = BoolEnvOrDefault(false, "DRY_RUN")
+	Config.DryRunOutput = StringEnvOrDefault("", "DRY_RUN_OUTPUT")
 	Config.MetricsInterval = SecondsEnvOrDefault(DefaultInterval, "METRICS_INTERVAL")
-	Config.MetricsBackend = os.Getenv("METRICS_BACKEND")
+	Config.MetricsJitter = SecondsEnvOrDefault(0, "METRICS_INTERVAL_JITTER")
+	Config.MetricsBackend = StringEnvOrDefault("", "METRICS_BACKEND")
 	Config.LogBackends = StringsEnvOrDefault([]string{"tsuru", "syslog"}, "LOG_BACKENDS")
+	Config.NetProbeInterval = SecondsEnvOrDefault(0, "NETPROBE_INTERVAL")
+	Config.NetProbeTimeout = SecondsEnvOrDefault(0, "NETPROBE_TIMEOUT")
+	Config.NetProbeDNSNames = StringsEnvOrDefault(nil, "NETPROBE_DNS_NAMES")
+	Config.NetProbeHTTPURLs = StringsEnvOrDefault(nil, "NETPROBE_HTTP_URLS")
+	Config.NetProbeImage = StringEnvOrDefault("", "NETPROBE_REGISTRY_IMAGE")
+	Config.NetProbePull = BoolEnvOrDefault(false, "NETPROBE_REGISTRY_PULL")
+	Config.NetProbeInsecure = BoolEnvOrDefault(false, "NETPROBE_REGISTRY_INSECURE")
+	Config.NetProbeUsername = StringEnvOrDefault("", "NETPROBE_REGISTRY_USERNAME")
+	Config.NetProbePassword = StringEnvOrDefault("", "NETPROBE_REGISTRY_PASSWORD")
+	Config.AuditLog = StringEnvOrDefault("", "AUDIT_LOG")
+	Config.AuditLogFacility = StringEnvOrDefault("", "AUDIT_LOG_FACILITY")
+}
+
+// Summary returns the loaded configuration as space separated name=value
+// pairs, leaving out tokens and passwords.
+func Summary() string {
+	v := reflect.ValueOf(Config)
+	t := v.Type()
+	pairs := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if strings.HasSuffix(name, "Token") || strings.HasSuffix(name, "Password") {
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%v", name, v.Field(i).Interface()))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// Features returns the optional features enabled in the loaded
+// configuration, along with the log and metrics backends, sorted.
+func Features() []string {
+	// Settings read by other packages are checked here by name, so the list
+	// doesn't depend on those packages being initialized.
+	set := func(envs ...string) bool {
+		for _, env := range envs {
+			if v, _ := Getenv(env); v != "" {
+				return true
+			}
+		}
+		return false
+	}
+	enabled := func(env string) bool {
+		v, _ := Getenv(env)
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	positive := func(env string) bool {
+		v, _ := Getenv(env)
+		n, _ := strconv.Atoi(v)
+		return n > 0
+	}
+	flags := map[string]bool{
+		"node-problem-detector":  Config.NodeProblemEnabled,
+		"node-metadata":          Config.NodeMetadataEnabled,
+		"clock-skew":             Config.ClockSkewEnabled,
+		"heartbeat":              HeartbeatEnabled(),
+		"gc":                     Config.GCEnabled,
+		"watchdog":               Config.WatchdogEnabled,
+		"startup-wait":           Config.StartupWaitTimeout > 0,
+		"status-compression":     Config.StatusCompress,
+		"status-differential":    Config.StatusDifferential,
+		"leader-lock":            set("HOSTCHECK_LEADER_ETCD_ENDPOINTS"),
+		"config-fingerprint":     Config.FingerprintFile != "",
+		"token-file":             Config.TsuruTokenFile != "",
+		"api":                    Config.APIListenAddress != "",
+		"api-admin":              Config.APIListenAddress != "" && Config.APIAdminToken != "",
+		"api-grpc":               Config.APIGRPCAddress != "",
+		"audit-log":              Config.AuditLog != "",
+		"dry-run":                Config.DryRun,
+		"netprobe":               NetProbeEnabled(),
+		"non-tsuru-containers":   enabled("NON_TSURU_CONTAINERS"),
+		"container-exclusion":    set("CONTAINER_EXCLUDE_LABELS", "CONTAINER_EXCLUDE_NAMES"),
+		"kmsg":                   enabled("LOG_KMSG_ENABLED"),
+		"log-access-logs":        enabled("LOG_ACCESS_LOGS"),
+		"log-counters":           set("LOG_COUNTERS"),
+		"log-dead-letter":        set("LOG_DEAD_LETTER_DESTINATION"),
+		"log-received-timestamp": enabled("LOG_RECEIVED_TIMESTAMP"),
+		"log-sanitize":           set("LOG_SANITIZE"),
+		"log-sequence-numbers":   enabled("LOG_SEQUENCE_NUMBERS"),
+		"log-severity-inference": enabled("LOG_INFER_SEVERITY"),
+		"log-stream-field":       enabled("LOG_STREAM_FIELD"),
+		"log-trace-context":      enabled("LOG_EXTRACT_TRACE_CONTEXT"),
+		"log-rate-limit":         positive("LOG_MAX_BYTES_PER_SECOND") || positive("LOG_SYSLOG_MAX_BYTES_PER_SECOND") || positive("LOG_GELF_MAX_BYTES_PER_SECOND") || positive("LOG_TSURU_MAX_BYTES_PER_SECOND"),
+		"syslog-compression":     set("LOG_SYSLOG_COMPRESSION"),
+		"syslog-signing":         set("LOG_SYSLOG_HMAC_KEY"),
+		"syslog-template":        set("LOG_SYSLOG_MESSAGE_TEMPLATE"),
+		"metrics-extra-tags":     set("METRICS_EXTRA_TAGS"),
+		"metrics-app-aggregates": enabled("METRICS_APP_AGGREGATES"),
+		"metrics-short-lived":    enabled("METRICS_SHORT_LIVED_CONTAINERS"),
+		"metrics-state-file":     set("METRICS_STATE_FILE"),
+		"metrics-ntp":            set("METRICS_NTP_SOURCE"),
+		"metrics-smart":          enabled("METRICS_SMART_ENABLED"),
+		"metrics-slices":         enabled("METRICS_SLICES_ENABLED"),
+		"metrics-sysctls":        set("METRICS_SYSCTLS"),
+		"metrics-systemd":        enabled("METRICS_SYSTEMD_ENABLED"),
+		"metrics-top-processes":  positive("METRICS_TOP_PROCESSES"),
+	}
+	features := make([]string, 0, len(flags)+len(Config.LogBackends)+1)
+	for name, enabled := range flags {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	for _, backend := range Config.LogBackends {
+		features = append(features, "log-backend-"+backend)
+	}
+	if Config.MetricsBackend != "" {
+		features = append(features, "metrics-backend-"+Config.MetricsBackend)
+	}
+	sort.Strings(features)
+	return features
+}
+
+// NetProbeEnabled returns whether any network probe is configured.
+func NetProbeEnabled() bool {
+	return len(Config.NetProbeDNSNames) > 0 || len(Config.NetProbeHTTPURLs) > 0 || Config.NetProbeImage != ""
+}
+
+// HeartbeatEnabled returns whether heartbeats are enabled and have somewhere
+// to be sent to.
+func HeartbeatEnabled() bool {
+	return Config.HeartbeatInterval > 0 && (Config.HeartbeatPath != "" || Config.HeartbeatURL != "")
+}
+
+// Validate checks the loaded configuration for values bs would fail to use,
+// returning every problem found.
+func Validate() []error {
+	var errs []error
+	checkURL := func(env, value string, schemes ...string) {
+		u, err := url.Parse(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", env, err))
+			return
+		}
+		for _, scheme := range schemes {
+			if u.Scheme == scheme {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: invalid protocol %q in %q, expected %s", env, u.Scheme, value, strings.Join(schemes, " or ")))
+	}
+	checkURL("DOCKER_ENDPOINT", Config.DockerEndpoint, "unix", "tcp", "http", "https")
+	if Config.TsuruEndpoint != "" {
+		checkURL("TSURU_ENDPOINT", Config.TsuruEndpoint, "http", "https")
+	}
+	if !(len(Config.LogBackends) == 1 && Config.LogBackends[0] == "none") {
+		checkURL("SYSLOG_LISTEN_ADDRESS", Config.SyslogListenAddress, "tcp", "udp")
+	}
+	if Config.APIListenAddress != "" {
+		checkURL("API_LISTEN_ADDRESS", Config.APIListenAddress, "unix", "tcp")
+		if strings.HasPrefix(Config.APIListenAddress, "tcp://") && (Config.APITLSCertFile == "" || Config.APITLSKeyFile == "") {
+			errs = append(errs, fmt.Errorf("API_LISTEN_ADDRESS: tcp addresses require API_TLS_CERT_FILE and API_TLS_KEY_FILE"))
+		}
+	}
+	if Config.APIGRPCAddress != "" {
+		checkURL("API_GRPC_ADDRESS", Config.APIGRPCAddress, "unix")
+	}
+	if v, _ := Getenv("API_SOCKET_MODE"); v != "" && Config.APISocketMode == 0 {
+		errs = append(errs, fmt.Errorf("API_SOCKET_MODE: invalid file mode %q, expected an octal mode like 0660", v))
+	}
+	if Config.MetricsInterval <= 0 {
+		errs = append(errs, fmt.Errorf("METRICS_INTERVAL: must be positive, got %s", Config.MetricsInterval))
+	}
+	if Config.StatusInterval <= 0 {
+		errs = append(errs, fmt.Errorf("STATUS_INTERVAL: must be positive, got %s", Config.StatusInterval))
+	}
+	if v, _ := Getenv("METRICS_EXTRA_TAGS"); v != "" {
+		if _, err := parseTags(v); err != nil {
+			errs = append(errs, fmt.Errorf("METRICS_EXTRA_TAGS: %s", err))
+		}
+	}
+	if Config.HeartbeatInterval > 0 && Config.HeartbeatPath == "" && Config.HeartbeatURL == "" {
+		errs = append(errs, fmt.Errorf("HEARTBEAT_INTERVAL: heartbeats require HEARTBEAT_URL or HEARTBEAT_PATH"))
+	}
+	switch Config.WatchdogAction {
+	case "", "restart", "exit":
+	default:
+		errs = append(errs, fmt.Errorf("WATCHDOG_ACTION: invalid action %q, expected restart or exit", Config.WatchdogAction))
+	}
+	return errs
+}
+
+// Getenv returns the value of the setting with the given name, from the
+// prefixed environment variable when set, and the name of the variable
+// holding it.
+func Getenv(name string) (string, string) {
+	if v, ok := lookupPrefixedEnv(name); ok {
+		return v, envPrefix + name
+	}
+	return os.Getenv(name), name
+}
+
+func lookupPrefixedEnv(name string) (string, bool) {
+	if envPrefix == "" {
+		return "", false
+	}
+	return os.LookupEnv(envPrefix + name)
 }
 
 func envOrDefault(convert func(string) interface{}, defaultValue interface{}, envs ...string) interface{} {
-	for i, env := range envs {
-		val := os.Getenv(env)
+	for i, name := range envs {
+		val, env := Getenv(name)
 		converted := convert(val)
 		if converted != nil {
 			if i > 0 {
 				bslog.Warnf("The environment variable %s is deprecated. Please set %s in the future.", env, envs[0])
 			}
+			recordSetting(envs, env, converted, defaultValue)
 			return converted
 		}
 	}
 	if defaultValue != nil && !reflect.DeepEqual(defaultValue, reflect.Zero(reflect.ValueOf(defaultValue).Type()).Interface()) {
 		bslog.Warnf("invalid value for %s. Using the default value of %v", strings.Join(envs, " or "), defaultValue)
 	}
+	recordSetting(envs, "", defaultValue, defaultValue)
 	return defaultValue
 }
 
@@ -91,6 +421,38 @@ func StringsEnvOrDefault(defaultValue []string, envs ...string) []string {
 	return nil
 }
 
+// TagsEnvOrDefault parses a comma separated list of name=value pairs, like
+// dc=sp1,rack=b12.
+func TagsEnvOrDefault(defaultValue map[string]string, envs ...string) map[string]string {
+	value := envOrDefault(func(v string) interface{} {
+		tags, err := parseTags(v)
+		if err != nil || len(tags) == 0 {
+			return nil
+		}
+		return tags
+	}, defaultValue, envs...)
+	if value != nil {
+		return value.(map[string]string)
+	}
+	return nil
+}
+
+func parseTags(v string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected name=value", pair)
+		}
+		tags[name] = strings.TrimSpace(parts[1])
+	}
+	return tags, nil
+}
+
 func IntEnvOrDefault(defaultValue int, envs ...string) int {
 	return envOrDefault(func(v string) interface{} {
 		val, err := strconv.Atoi(v)
@@ -101,10 +463,34 @@ func IntEnvOrDefault(defaultValue int, envs ...string) int {
 	}, defaultValue, envs...).(int)
 }
 
+// FileModeEnvOrDefault parses the value of the environment variables as an
+// octal file mode, e.g. 0660.
+func FileModeEnvOrDefault(defaultValue os.FileMode, envs ...string) os.FileMode {
+	return envOrDefault(func(v string) interface{} {
+		val, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || val > uint64(os.ModePerm) {
+			return nil
+		}
+		return os.FileMode(val)
+	}, defaultValue, envs...).(os.FileMode)
+}
+
+func BoolEnvOrDefault(defaultValue bool, envs ...string) bool {
+	return envOrDefault(func(v string) interface{} {
+		val, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil
+		}
+		return val
+	}, defaultValue, envs...).(bool)
+}
+
 func SecondsEnvOrDefault(defaultValue float64, envs ...string) time.Duration {
 	return time.Duration(envOrDefault(func(v string) interface{} {
 		val, err := strconv.ParseFloat(v, 64)
-		if err != nil {
+		// Values that don't fit in a time.Duration, NaN and infinities
+		// included, are invalid.
+		if err != nil || !(math.Abs(val) <= maxDurationSeconds) {
 			return nil
 		}
 		return val
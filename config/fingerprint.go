@@ -0,0 +1,313 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// fingerprintLength is the number of hex digits kept from the settings hash,
+// enough to tell configurations apart in the status payload.
+const fingerprintLength = 16
+
+// Drift compares the effective configuration with the one bs ran with before
+// restarting. Fingerprint identifies the current settings, Previous the ones
+// saved in the fingerprint file, if any, and Changed lists the names of the
+// settings whose values differ between them.
+type Drift struct {
+	Fingerprint string
+	Previous    string   `form:",omitempty" json:",omitempty"`
+	Changed     []string `form:",omitempty" json:",omitempty"`
+}
+
+// fingerprintState is what is saved in the fingerprint file.
+type fingerprintState struct {
+	Fingerprint string            `json:"fingerprint"`
+	SavedAt     time.Time         `json:"saved_at"`
+	Settings    map[string]string `json:"settings"`
+}
+
+// fingerprintSettings are the settings whose values make up the fingerprint,
+// every environment variable read by bs, including deprecated names. The set
+// is fixed, so the fingerprint doesn't depend on which packages have read
+// their settings by the time it's calculated.
+var fingerprintSettings = []string{
+	"API_ADMIN_TOKEN",
+	"API_GRPC_ADDRESS",
+	"API_LISTEN_ADDRESS",
+	"API_SOCKET_GROUP",
+	"API_SOCKET_MODE",
+	"API_SOCKET_OWNER",
+	"API_TLS_CERT_FILE",
+	"API_TLS_KEY_FILE",
+	"AUDIT_LOG",
+	"AUDIT_LOG_FACILITY",
+	"CGROUP_DRIVER",
+	"CGROUP_MOUNT_PREFIX",
+	"CLOCK_SKEW_ENABLED",
+	"CLOCK_SKEW_INTERVAL",
+	"CLOCK_SKEW_NTP_SERVER",
+	"CLOCK_SKEW_THRESHOLD_MS",
+	"CONFIG_FINGERPRINT_FILE",
+	"CONTAINER_EXCLUDE_LABELS",
+	"CONTAINER_EXCLUDE_NAMES",
+	"CONTAINER_SELECTION_ENV",
+	"DOCKER_ENDPOINT",
+	"DRY_RUN",
+	"DRY_RUN_OUTPUT",
+	"GC_DISK_PATH",
+	"GC_DISK_THRESHOLD",
+	"GC_ENABLED",
+	"GC_INTERVAL",
+	"GC_MIN_AGE",
+	"HEARTBEAT_INTERVAL",
+	"HEARTBEAT_PATH",
+	"HEARTBEAT_URL",
+	"HOSTCHECK_BASE_CONTAINER_NAME",
+	"HOSTCHECK_CONTAINER_MESSAGE",
+	"HOSTCHECK_DNS_NAMES",
+	"HOSTCHECK_EXTRA_PATHS",
+	"HOSTCHECK_KIND_FILTER",
+	"HOSTCHECK_LEADER_ETCD_ENDPOINTS",
+	"HOSTCHECK_LEADER_LOCK_NAME",
+	"HOSTCHECK_LEADER_LOCK_TTL",
+	"HOSTCHECK_REGISTRY_IMAGE",
+	"HOSTCHECK_ROOT_PATH_OVERRIDE",
+	"HOSTCHECK_TIMEOUT",
+	"HOST_ETC",
+	"HOST_PATHS_AUTODETECT_DISABLED",
+	"HOST_PROC",
+	"HOST_SYS",
+	"LOG_ACCESS_LOGS",
+	"LOG_ACCESS_LOGS_METRICS",
+	"LOG_ACCESS_LOGS_W3C_FIELDS",
+	"LOG_BACKENDS",
+	"LOG_BUFFER_SIZE",
+	"LOG_COUNTERS",
+	"LOG_DEAD_LETTER_DESTINATION",
+	"LOG_EXTRACT_TRACE_CONTEXT",
+	"LOG_FORWARD_SHARDS",
+	"LOG_GELF_BUFFER_SIZE",
+	"LOG_GELF_EXTRA_TAGS",
+	"LOG_GELF_FIELDS_WHITELIST",
+	"LOG_GELF_FORWARD_SHARDS",
+	"LOG_GELF_HOST",
+	"LOG_GELF_MAX_BYTES_PER_SECOND",
+	"LOG_HIGH_PRIORITY_RESERVED_BUFFER",
+	"LOG_INFER_SEVERITY",
+	"LOG_KMSG_ENABLED",
+	"LOG_KMSG_PATH",
+	"LOG_KUBERNETES_LOG_DIR",
+	"LOG_KUBERNETES_LOG_POS_DIR",
+	"LOG_LISTEN_RECEIVE_BUFFER",
+	"LOG_LOW_PRIORITY_MAX_BUFFER",
+	"LOG_MAX_BYTES_PER_SECOND",
+	"LOG_MAX_LINE_POLICY",
+	"LOG_MAX_LINE_SIZE",
+	"LOG_RECEIVED_TIMESTAMP",
+	"LOG_SANITIZE",
+	"LOG_SEQUENCE_NUMBERS",
+	"LOG_STREAM_FIELD",
+	"LOG_STREAM_SEVERITY",
+	"LOG_SYSLOG_BUFFER_SIZE",
+	"LOG_SYSLOG_COMPRESSION",
+	"LOG_SYSLOG_CONN_MAX_AGE",
+	"LOG_SYSLOG_FORWARD_ADDRESSES",
+	"LOG_SYSLOG_FORWARD_SHARDS",
+	"LOG_SYSLOG_HMAC_BATCH_SIZE",
+	"LOG_SYSLOG_HMAC_KEY",
+	"LOG_SYSLOG_MAX_BYTES_PER_SECOND",
+	"LOG_SYSLOG_MESSAGE_EXTRA_END",
+	"LOG_SYSLOG_MESSAGE_EXTRA_START",
+	"LOG_SYSLOG_MESSAGE_TEMPLATE",
+	"LOG_SYSLOG_MTU_NETWORK_INTERFACE",
+	"LOG_SYSLOG_TIMESTAMP_FORMAT",
+	"LOG_SYSLOG_TIMEZONE",
+	"LOG_TSURU_BUFFER_SIZE",
+	"LOG_TSURU_CONN_MAX_AGE",
+	"LOG_TSURU_FORWARD_SHARDS",
+	"LOG_TSURU_MAX_BYTES_PER_SECOND",
+	"LOG_TSURU_PING_INTERVAL",
+	"LOG_TSURU_PONG_INTERVAL",
+	"LOG_UDP_READ_BATCH",
+	"LOG_WARMUP_TIMEOUT",
+	"LOG_WS_PING_INTERVAL",
+	"LOG_WS_PONG_INTERVAL",
+	"METRICS_APP_AGGREGATES",
+	"METRICS_BACKEND",
+	"METRICS_EXTRA_TAGS",
+	"METRICS_INTERVAL",
+	"METRICS_INTERVAL_JITTER",
+	"METRICS_LOGSTASH_CLIENT",
+	"METRICS_LOGSTASH_HOST",
+	"METRICS_LOGSTASH_PORT",
+	"METRICS_LOGSTASH_PROTOCOL",
+	"METRICS_LOGSTASH_SCHEMA_VERSION",
+	"METRICS_NETWORK_INTERFACE",
+	"METRICS_NTP_SOURCE",
+	"METRICS_SHORT_LIVED_CONTAINERS",
+	"METRICS_SLICES",
+	"METRICS_SLICES_ENABLED",
+	"METRICS_SMART_ENABLED",
+	"METRICS_STATE_FILE",
+	"METRICS_SYSCTLS",
+	"METRICS_SYSTEMD_ENABLED",
+	"METRICS_SYSTEMD_UNITS",
+	"METRICS_TOP_PROCESSES",
+	"NETPROBE_DNS_NAMES",
+	"NETPROBE_HTTP_URLS",
+	"NETPROBE_INTERVAL",
+	"NETPROBE_REGISTRY_IMAGE",
+	"NETPROBE_REGISTRY_INSECURE",
+	"NETPROBE_REGISTRY_PASSWORD",
+	"NETPROBE_REGISTRY_PULL",
+	"NETPROBE_REGISTRY_USERNAME",
+	"NETPROBE_TIMEOUT",
+	"NODE_METADATA_CACHE_TTL",
+	"NODE_METADATA_ENABLED",
+	"NODE_PROBLEM_CONNTRACK_THRESHOLD",
+	"NODE_PROBLEM_DETECTOR",
+	"NODE_PROBLEM_DOCKER_TIMEOUT",
+	"NODE_PROBLEM_INTERVAL",
+	"NODE_PROBLEM_MAX_ZOMBIES",
+	"NODE_PROBLEM_MOUNTS",
+	"NODE_PROBLEM_PROBES",
+	"NODE_PROBLEM_WRITABLE_PATHS",
+	"NODE_PROBLEM_WRITE_TIMEOUT",
+	"NON_TSURU_CONTAINERS",
+	"STARTUP_WAIT_TIMEOUT",
+	"STATUS_CHUNK_SIZE",
+	"STATUS_COMPRESS",
+	"STATUS_DIFFERENTIAL",
+	"STATUS_FULL_SYNC_INTERVAL",
+	"STATUS_INTERVAL",
+	"STATUS_INTERVAL_JITTER",
+	"STATUS_RETRY_QUEUE_SIZE",
+	"SYSLOG_FORWARD_ADDRESSES",
+	"SYSLOG_LISTEN_ADDRESS",
+	"SYSLOG_TIMEZONE",
+	"TSURU_API_DIAL_TIMEOUT",
+	"TSURU_API_IDLE_CONN_TIMEOUT",
+	"TSURU_API_MAX_RETRIES",
+	"TSURU_API_REQUEST_TIMEOUT",
+	"TSURU_ENDPOINT",
+	"TSURU_TOKEN",
+	"TSURU_TOKEN_FILE",
+	"WATCHDOG_ACTION",
+	"WATCHDOG_ENABLED",
+	"WATCHDOG_INTERVAL",
+	"WATCHDOG_STALLED_INTERVALS",
+}
+
+// CheckDrift returns how the current settings compare with the ones saved in
+// path and saves the current ones in their place. The fingerprint is a hash of
+// the environment values of fingerprintSettings, empty when unset, with
+// secrets masked so rotating them doesn't change it. An empty path only
+// calculates the fingerprint. A missing or invalid file is treated as the
+// first start.
+func CheckDrift(path string, now time.Time) (Drift, error) {
+	values := settingValues()
+	drift := Drift{Fingerprint: fingerprint(values)}
+	if path == "" {
+		return drift, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return drift, err
+	}
+	var previous fingerprintState
+	if err == nil && json.Unmarshal(data, &previous) == nil && previous.Fingerprint != "" {
+		drift.Previous = previous.Fingerprint
+		drift.Changed = changedSettings(previous.Settings, values)
+	}
+	if drift.Previous == drift.Fingerprint {
+		return drift, nil
+	}
+	return drift, saveFingerprint(path, fingerprintState{
+		Fingerprint: drift.Fingerprint,
+		SavedAt:     now,
+		Settings:    values,
+	})
+}
+
+// Drifted returns whether the configuration changed since the previous start.
+func (d Drift) Drifted() bool {
+	return d.Previous != "" && d.Previous != d.Fingerprint
+}
+
+func settingValues() map[string]string {
+	values := make(map[string]string, len(fingerprintSettings))
+	for _, name := range fingerprintSettings {
+		value, _ := Getenv(name)
+		if value != "" && isSecret(name) {
+			value = maskedValue
+		}
+		values[name] = value
+	}
+	return values
+}
+
+func fingerprint(values map[string]string) string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(values[name]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:fingerprintLength]
+}
+
+func changedSettings(previous, current map[string]string) []string {
+	var changed []string
+	for name, value := range current {
+		if old, ok := previous[name]; !ok || old != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// saveFingerprint replaces the fingerprint file atomically, so a crash never
+// leaves a partial file behind.
+func saveFingerprint(path string, state fingerprintState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
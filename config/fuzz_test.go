@@ -0,0 +1,48 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package config
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+func FuzzEnvOrDefault(f *testing.F) {
+	for _, v := range []string{"", "10", "1.5", "-1", "NaN", "1e309", "true", "a, b,,c", " "} {
+		f.Add(v)
+	}
+	bslog.Logger = log.New(ioutil.Discard, "", 0)
+	defer func() { bslog.Logger = log.New(os.Stderr, "", log.LstdFlags) }()
+	defer os.Unsetenv("FUZZ_ENV")
+	f.Fuzz(func(t *testing.T, value string) {
+		if strings.IndexByte(value, 0) != -1 {
+			return
+		}
+		os.Setenv("FUZZ_ENV", value)
+		d := SecondsEnvOrDefault(7, "FUZZ_ENV")
+		expected, _ := strconv.ParseFloat(value, 64)
+		if d != 7*time.Second && math.Abs(d.Seconds()-expected) > 1 {
+			t.Fatalf("%q: got duration %v", value, d)
+		}
+		IntEnvOrDefault(7, "FUZZ_ENV")
+		BoolEnvOrDefault(true, "FUZZ_ENV")
+		for _, s := range StringsEnvOrDefault(nil, "FUZZ_ENV") {
+			if s != strings.TrimSpace(s) {
+				t.Fatalf("%q: untrimmed value %q", value, s)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+// hostRoots are the paths where the host root filesystem is commonly mounted
+// in containers.
+var hostRoots = []string{"/host", "/rootfs"}
+
+// detectHostPaths sets HOST_PROC, HOST_SYS and HOST_ETC, used to read the host
+// filesystems, to the ones found in the host root filesystem mounted in the
+// container. Variables already set, even to empty values, are kept.
+func detectHostPaths() {
+	if runtime.GOOS == "windows" || BoolEnvOrDefault(false, "HOST_PATHS_AUTODETECT_DISABLED") {
+		return
+	}
+	root := findHostRoot()
+	if root == "" {
+		return
+	}
+	for _, env := range libraryEnvs {
+		if _, ok := os.LookupEnv(env); ok {
+			continue
+		}
+		path := filepath.Join(root, strings.ToLower(strings.TrimPrefix(env, "HOST_")))
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			bslog.Infof("Using host %s mounted in %s, set %s to override it", filepath.Base(path), path, env)
+			os.Setenv(env, path)
+		}
+	}
+}
+
+// findHostRoot returns the first of hostRoots holding a proc filesystem.
+func findHostRoot() string {
+	for _, root := range hostRoots {
+		if _, err := os.Stat(filepath.Join(root, "proc", "meminfo")); err == nil {
+			return root
+		}
+	}
+	return ""
+}
@@ -8,12 +8,14 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"path"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/hashicorp/golang-lru"
+	"github.com/tsuru/bs/config"
 )
 
 var (
@@ -21,8 +23,19 @@ var (
 
 	hexRegex = regexp.MustCompile(`(?i)^[a-f0-9]+$`)
 
-	appNameLabels     = []string{"bs.tsuru.io/log-app-name", "log-app-name", "io.kubernetes.container.name"}
-	processNameLabels = []string{"bs.tsuru.io/log-process-name", "log-process-name", "io.kubernetes.pod.name"}
+	appNameLabels = []string{
+		"bs.tsuru.io/log-app-name",
+		"log-app-name",
+		"io.kubernetes.container.name",
+		"com.docker.swarm.service.name",
+		"com.docker.compose.service",
+	}
+	processNameLabels = []string{
+		"bs.tsuru.io/log-process-name",
+		"log-process-name",
+		"io.kubernetes.pod.name",
+		"com.docker.swarm.task.name",
+	}
 )
 
 const containerIDTrimSize = 12
@@ -31,6 +44,17 @@ type InfoClient struct {
 	endpoint       string
 	client         *docker.Client
 	containerCache *lru.Cache
+	excludeLabels  []excludeLabel
+	excludeNames   []string
+	nonTsuru       bool
+}
+
+// excludeLabel is a label from CONTAINER_EXCLUDE_LABELS, excluding the
+// containers having it with any value when hasValue is false.
+type excludeLabel struct {
+	name     string
+	value    string
+	hasValue bool
 }
 
 type Container struct {
@@ -62,7 +86,12 @@ var (
 )
 
 func NewClient(endpoint string) (*InfoClient, error) {
-	c := InfoClient{endpoint: endpoint}
+	c := InfoClient{
+		endpoint:      endpoint,
+		excludeLabels: parseExcludeLabels(config.StringsEnvOrDefault(nil, "CONTAINER_EXCLUDE_LABELS")),
+		excludeNames:  config.StringsEnvOrDefault(nil, "CONTAINER_EXCLUDE_NAMES"),
+		nonTsuru:      config.BoolEnvOrDefault(false, "NON_TSURU_CONTAINERS"),
+	}
 	var err error
 	c.containerCache, err = lru.New(100)
 	if err != nil {
@@ -78,6 +107,27 @@ func NewClient(endpoint string) (*InfoClient, error) {
 	return &c, nil
 }
 
+// parseExcludeLabels splits the labels in CONTAINER_EXCLUDE_LABELS, either
+// just the label name or name=value, once, as they're checked for every log
+// line.
+func parseExcludeLabels(labels []string) []excludeLabel {
+	result := make([]excludeLabel, 0, len(labels))
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		exclude := excludeLabel{name: parts[0]}
+		if len(parts) == 2 {
+			exclude.value, exclude.hasValue = parts[1], true
+		}
+		result = append(result, exclude)
+	}
+	return result
+}
+
+// CacheLen returns the number of containers in the cache.
+func (c *InfoClient) CacheLen() int {
+	return c.containerCache.Len()
+}
+
 func (c *InfoClient) GetClient() *docker.Client {
 	return c.client
 }
@@ -138,6 +188,9 @@ func (c *InfoClient) getContainer(containerId string, useCache bool) (*Container
 		process, ok := contData.GetLabelAny(processNameLabels...)
 		if !ok {
 			process = contData.ID
+			if c.nonTsuru {
+				process = contData.Config.Image
+			}
 		}
 		contData.AppName = name
 		contData.ProcessName = process
@@ -175,6 +228,63 @@ func (c *Container) Stats() (*docker.Stats, error) {
 	return <-statsCh, nil
 }
 
+// StreamStats sends the container stats to the given channel as they're
+// reported by Docker, until the container is removed or done is closed. The
+// stats channel is closed when it returns.
+func (c *Container) StreamStats(stats chan<- *docker.Stats, done <-chan bool) error {
+	opts := docker.StatsOptions{
+		ID:      c.ID,
+		Stream:  true,
+		Stats:   stats,
+		Done:    done,
+		Timeout: 10 * time.Second,
+	}
+	return c.client.client.Stats(opts)
+}
+
+// Identified returns whether the container has an application identity to be
+// used in metrics and logs, either being a tsuru application or, when
+// NON_TSURU_CONTAINERS is enabled, any container, identified by its name and
+// image.
+func (c *Container) Identified() bool {
+	return c.TsuruApp || (c.client != nil && c.client.nonTsuru)
+}
+
+// GetIdentifiedContainer returns the container with id containerId if it
+// has an application identity. See Container.Identified.
+func (c *InfoClient) GetIdentifiedContainer(containerId string, useCache bool) (*Container, error) {
+	if c.nonTsuru {
+		return c.GetContainer(containerId, useCache, nil)
+	}
+	return c.GetAppContainer(containerId, useCache)
+}
+
+// Excluded returns whether the container must be ignored by metrics and log
+// forwarding, having one of the labels in CONTAINER_EXCLUDE_LABELS, either
+// just the label name or name=value, or a name matching one of the patterns
+// in CONTAINER_EXCLUDE_NAMES.
+func (c *Container) Excluded() bool {
+	if c.client == nil {
+		return false
+	}
+	for _, label := range c.client.excludeLabels {
+		if c.Config == nil {
+			break
+		}
+		value, ok := c.Config.Labels[label.name]
+		if ok && (!label.hasValue || label.value == value) {
+			return true
+		}
+	}
+	name := strings.TrimPrefix(c.Name, "/")
+	for _, pattern := range c.client.excludeNames {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // HasEnvs checks if the container has the requiredEnvs variables set
 func (c *Container) HasEnvs(requiredEnvs []string) bool {
 	for _, env := range requiredEnvs {
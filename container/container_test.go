@@ -5,7 +5,9 @@
 package container
 
 import (
+	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"testing"
 
@@ -90,6 +92,32 @@ func (S) TestInfoClientGetContainerNonApp(c *check.C) {
 	c.Assert(dockerCalls, check.Equals, 1)
 }
 
+func (S) TestInfoClientGetContainerNonTsuruMode(c *check.C) {
+	os.Setenv("NON_TSURU_CONTAINERS", "true")
+	defer os.Unsetenv("NON_TSURU_CONTAINERS")
+	dockerServer, err := dTesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer dockerServer.Stop()
+	id := createContainer(c, dockerServer.URL(), nil, "myContName")
+	client, err := NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	cont, err := client.GetIdentifiedContainer(id, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.AppName, check.Equals, "myContName")
+	c.Assert(cont.ProcessName, check.Equals, "myimg")
+	c.Assert(cont.TsuruApp, check.Equals, false)
+	c.Assert(cont.Identified(), check.Equals, true)
+	os.Unsetenv("NON_TSURU_CONTAINERS")
+	client, err = NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	_, err = client.GetIdentifiedContainer(id, true)
+	c.Assert(err, check.Equals, ErrTsuruVariablesNotFound)
+	cont, err = client.GetContainer(id, true, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.ProcessName, check.Equals, id)
+	c.Assert(cont.Identified(), check.Equals, false)
+}
+
 func (S) TestInfoClientGetAppContainer(c *check.C) {
 	dockerCalls := 0
 	dockerServer, err := dTesting.NewServer("127.0.0.1:0", nil, func(req *http.Request) {
@@ -156,3 +184,102 @@ func (S) TestContainerHasEnvs(c *check.C) {
 	c.Assert(cont.HasEnvs([]string{"ENV"}), check.Equals, false)
 	c.Assert(cont.HasEnvs([]string{"TSURU_APPNAME", "ENV"}), check.Equals, false)
 }
+
+func (S) TestContainerExcluded(c *check.C) {
+	os.Setenv("CONTAINER_EXCLUDE_LABELS", "bs.tsuru.io/exclude, tier=infra")
+	os.Setenv("CONTAINER_EXCLUDE_NAMES", "big-sibling,node-exporter*")
+	defer os.Unsetenv("CONTAINER_EXCLUDE_LABELS")
+	defer os.Unsetenv("CONTAINER_EXCLUDE_NAMES")
+	client, err := NewClient("unix:///var/run/docker.sock")
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected bool
+	}{
+		{"/myapp-web-1", nil, false},
+		{"/myapp-web-1", map[string]string{"tier": "app"}, false},
+		{"/myapp-web-1", map[string]string{"tier": "infra"}, true},
+		{"/myapp-web-1", map[string]string{"bs.tsuru.io/exclude": ""}, true},
+		{"/big-sibling", nil, true},
+		{"/node-exporter-x1", nil, true},
+		{"/big-sibling-2", nil, false},
+	}
+	for _, tt := range tests {
+		cont := Container{
+			Container: docker.Container{Name: tt.name, Config: &docker.Config{Labels: tt.labels}},
+			client:    client,
+		}
+		c.Assert(cont.Excluded(), check.Equals, tt.expected, check.Commentf("%s %v", tt.name, tt.labels))
+	}
+	cont := Container{Container: docker.Container{Name: "/big-sibling"}}
+	c.Assert(cont.Excluded(), check.Equals, false)
+}
+
+func (S) TestParseExcludeLabels(c *check.C) {
+	c.Assert(parseExcludeLabels(nil), check.HasLen, 0)
+	c.Assert(parseExcludeLabels([]string{"bs.tsuru.io/exclude", "tier=infra", "empty=", "a=b=c"}), check.DeepEquals, []excludeLabel{
+		{name: "bs.tsuru.io/exclude"},
+		{name: "tier", value: "infra", hasValue: true},
+		{name: "empty", hasValue: true},
+		{name: "a", value: "b=c", hasValue: true},
+	})
+}
+
+func (S) TestInfoClientGetContainerOrchestratorLabels(c *check.C) {
+	dockerServer, err := dTesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer dockerServer.Stop()
+	dockerClient, err := docker.NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	err = dockerClient.PullImage(docker.PullImageOptions{Repository: "myimg"}, docker.AuthConfiguration{})
+	c.Assert(err, check.IsNil)
+	client, err := NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	tests := []struct {
+		labels  map[string]string
+		app     string
+		process string
+	}{
+		{
+			labels: map[string]string{
+				"com.docker.swarm.service.name": "web",
+				"com.docker.swarm.task.name":    "web.1.x8s7d6",
+				"com.docker.stack.namespace":    "shop",
+			},
+			app:     "web",
+			process: "web.1.x8s7d6",
+		},
+		{
+			labels: map[string]string{
+				"com.docker.compose.project": "shop",
+				"com.docker.compose.service": "worker",
+			},
+			app: "worker",
+		},
+		{
+			labels: map[string]string{
+				"com.docker.compose.service": "worker",
+				"bs.tsuru.io/log-app-name":   "custom",
+			},
+			app: "custom",
+		},
+	}
+	for i, tt := range tests {
+		opts := docker.CreateContainerOptions{
+			Name:   fmt.Sprintf("cont%d", i),
+			Config: &docker.Config{Image: "myimg", Labels: tt.labels},
+		}
+		created, err := dockerClient.CreateContainer(opts)
+		c.Assert(err, check.IsNil)
+		cont, err := client.GetContainer(created.ID, false, nil)
+		c.Assert(err, check.IsNil)
+		process := tt.process
+		if process == "" {
+			process = created.ID
+		}
+		c.Assert(cont.AppName, check.Equals, tt.app)
+		c.Assert(cont.ProcessName, check.Equals, process)
+		c.Assert(cont.TsuruApp, check.Equals, false)
+	}
+}
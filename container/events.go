@@ -0,0 +1,105 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package container
+
+import (
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/bslog"
+)
+
+const (
+	eventsMinBackoff = time.Second
+	eventsMaxBackoff = 30 * time.Second
+)
+
+// EventListener receives the Docker events. go-dockerclient closes the
+// channels of its listeners when the Docker daemon restarts or reconnecting
+// to it fails, so the listener subscribes again, with backoff, whenever that
+// happens.
+type EventListener struct {
+	add        func(chan<- *docker.APIEvents) error
+	remove     func(chan *docker.APIEvents) error
+	events     chan *docker.APIEvents
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// NewEventListener subscribes to the Docker events of the client.
+func (c *InfoClient) NewEventListener() (*EventListener, error) {
+	l := &EventListener{
+		add:        c.client.AddEventListener,
+		remove:     c.client.RemoveEventListener,
+		minBackoff: eventsMinBackoff,
+		maxBackoff: eventsMaxBackoff,
+	}
+	err := l.subscribe()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *EventListener) subscribe() error {
+	events := make(chan *docker.APIEvents, 100)
+	err := l.add(events)
+	if err != nil {
+		return err
+	}
+	l.events = events
+	return nil
+}
+
+// Run calls handle for each event until abort is closed, unsubscribing
+// before returning. It may be called again after handle panics.
+func (l *EventListener) Run(abort <-chan struct{}, handle func(*docker.APIEvents)) {
+	defer l.unsubscribe()
+	for {
+		if l.events == nil && !l.resubscribe(abort) {
+			return
+		}
+		select {
+		case ev, ok := <-l.events:
+			if !ok {
+				l.unsubscribe()
+				continue
+			}
+			handle(ev)
+		case <-abort:
+			return
+		}
+	}
+}
+
+func (l *EventListener) unsubscribe() {
+	if l.events != nil {
+		l.remove(l.events)
+		l.events = nil
+	}
+}
+
+// resubscribe subscribes to the events again, retrying with backoff. It
+// returns false if abort is closed before subscribing succeeds.
+func (l *EventListener) resubscribe(abort <-chan struct{}) bool {
+	backoff := l.minBackoff
+	for {
+		bslog.Warnf("[docker events] event stream closed, subscribing again in %s", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-abort:
+			return false
+		}
+		err := l.subscribe()
+		if err == nil {
+			return true
+		}
+		bslog.Errorf("[docker events] unable to subscribe to events: %s", err)
+		backoff *= 2
+		if backoff > l.maxBackoff {
+			backoff = l.maxBackoff
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package container
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/check.v1"
+)
+
+type fakeEventSource struct {
+	mu       sync.Mutex
+	fails    int
+	adds     int
+	removes  int
+	channels chan chan<- *docker.APIEvents
+}
+
+func newFakeEventSource() *fakeEventSource {
+	return &fakeEventSource{channels: make(chan chan<- *docker.APIEvents, 10)}
+}
+
+func (s *fakeEventSource) add(events chan<- *docker.APIEvents) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fails > 0 {
+		s.fails--
+		return errors.New("cannot connect to docker")
+	}
+	s.adds++
+	s.channels <- events
+	return nil
+}
+
+func (s *fakeEventSource) remove(events chan *docker.APIEvents) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removes++
+	return nil
+}
+
+func (s *fakeEventSource) listener(c *check.C) *EventListener {
+	l := &EventListener{
+		add:        s.add,
+		remove:     s.remove,
+		minBackoff: time.Millisecond,
+		maxBackoff: 5 * time.Millisecond,
+	}
+	c.Assert(l.subscribe(), check.IsNil)
+	return l
+}
+
+func (S) TestEventListenerSubscribesAgainWhenClosed(c *check.C) {
+	source := newFakeEventSource()
+	l := source.listener(c)
+	first := <-source.channels
+	source.fails = 2
+	handled := make(chan *docker.APIEvents, 10)
+	abort := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(abort, func(ev *docker.APIEvents) {
+			handled <- ev
+		})
+	}()
+	first <- &docker.APIEvents{Status: "start", ID: "c1"}
+	c.Assert((<-handled).ID, check.Equals, "c1")
+	close(first)
+	var second chan<- *docker.APIEvents
+	select {
+	case second = <-source.channels:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for the listener to subscribe again")
+	}
+	second <- &docker.APIEvents{Status: "die", ID: "c2"}
+	c.Assert((<-handled).ID, check.Equals, "c2")
+	close(abort)
+	<-done
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	c.Assert(source.adds, check.Equals, 2)
+	c.Assert(source.removes, check.Equals, 2)
+}
+
+func (S) TestEventListenerAbortWhileResubscribing(c *check.C) {
+	source := newFakeEventSource()
+	l := source.listener(c)
+	l.minBackoff = time.Minute
+	first := <-source.channels
+	abort := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Run(abort, func(ev *docker.APIEvents) {})
+	}()
+	close(first)
+	close(abort)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for the listener to stop")
+	}
+	c.Assert(source.adds, check.Equals, 1)
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dryrun implements the dry-run mode of bs, where log forwarders and
+// metric backends write what they would send to stdout or to a file instead
+// of the network, so templates, filters and routing rules can be validated
+// before pointing bs at production destinations.
+package dryrun
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	mu  sync.Mutex
+	out io.Writer
+)
+
+// Setup enables the dry-run mode, writing to the file in output, which may
+// also be a file:// URL, or to stdout when output is empty or "stdout".
+func Setup(output string) error {
+	var w io.Writer
+	switch output {
+	case "", "stdout":
+		w = os.Stdout
+	default:
+		f, err := os.OpenFile(strings.TrimPrefix(output, "file://"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	closeOutput()
+	out = w
+	return nil
+}
+
+// Enabled returns whether the dry-run mode is enabled.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return out != nil
+}
+
+// Write writes a single line with the destination and the payload that would
+// be sent to it.
+func Write(destination string, payload []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if out == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString("[dry-run] ")
+	buf.WriteString(destination)
+	buf.WriteByte(' ')
+	buf.Write(bytes.TrimRight(payload, "\r\n"))
+	buf.WriteByte('\n')
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+// Disable disables the dry-run mode, closing the output file.
+func Disable() {
+	mu.Lock()
+	defer mu.Unlock()
+	closeOutput()
+	out = nil
+}
+
+func closeOutput() {
+	if c, ok := out.(io.Closer); ok && out != os.Stdout {
+		c.Close()
+	}
+}
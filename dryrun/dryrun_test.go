@@ -0,0 +1,74 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dryrun
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (S) TearDownTest(c *check.C) {
+	Disable()
+}
+
+func (S) TestDisabled(c *check.C) {
+	c.Assert(Enabled(), check.Equals, false)
+	c.Assert(Write("dest", []byte("payload")), check.IsNil)
+}
+
+func (S) TestSetupFile(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dry-run.log")
+	err := Setup("file://" + path)
+	c.Assert(err, check.IsNil)
+	c.Assert(Enabled(), check.Equals, true)
+	c.Assert(Write("syslog udp://10.0.0.1:514", []byte("<30>msg1\n")), check.IsNil)
+	c.Assert(Write("metrics logstash", []byte(`{"key":"cpu_max"}`)), check.IsNil)
+	Disable()
+	c.Assert(Enabled(), check.Equals, false)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "[dry-run] syslog udp://10.0.0.1:514 <30>msg1\n"+
+		`[dry-run] metrics logstash {"key":"cpu_max"}`+"\n")
+}
+
+func (S) TestSetupFileAppends(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dry-run.log")
+	err := ioutil.WriteFile(path, []byte("previous\n"), 0600)
+	c.Assert(err, check.IsNil)
+	err = Setup(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(Write("dest", []byte("payload")), check.IsNil)
+	Disable()
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals, "previous\n[dry-run] dest payload\n")
+}
+
+func (S) TestSetupStdout(c *check.C) {
+	err := Setup("")
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Equals, os.Stdout)
+	err = Setup("stdout")
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.Equals, os.Stdout)
+}
+
+func (S) TestSetupInvalidFile(c *check.C) {
+	err := Setup(filepath.Join(c.MkDir(), "missing", "dry-run.log"))
+	c.Assert(err, check.NotNil)
+	c.Assert(Enabled(), check.Equals, false)
+}
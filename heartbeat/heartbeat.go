@@ -0,0 +1,173 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package heartbeat periodically notifies that the node agent is alive,
+// independently from the container status reports, so dead nodes are
+// detected without waiting for a full status cycle.
+package heartbeat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/buildinfo"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/supervisor"
+	"github.com/tsuru/bs/tsuruapi"
+)
+
+const DefaultInterval = 10 * time.Second
+
+type Config struct {
+	// TsuruClient is the client used to send heartbeats to the tsuru API.
+	TsuruClient *tsuruapi.Client
+	// Path is the path in the tsuru API heartbeats are sent to. The tsuru API
+	// has no heartbeat endpoint of its own, so either Path or URL must be
+	// set, pointing to an endpoint provided by an extension or proxy.
+	Path string
+	// URL, when set, is a webhook heartbeats are sent to instead of the
+	// tsuru API.
+	URL string
+	// Addrs are the node addresses sent in each heartbeat.
+	Addrs []string
+	// Interval is the interval between heartbeats.
+	Interval time.Duration
+}
+
+type payload struct {
+	Addrs     []string  `json:"addrs"`
+	Hostname  string    `json:"hostname"`
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Sender sends heartbeats periodically, keeping track of failures to be
+// reported as host metrics.
+type Sender struct {
+	config     Config
+	hostname   string
+	httpClient *http.Client
+	mu         sync.RWMutex
+	failures   int
+	lastBeat   time.Time
+	abort      chan struct{}
+	exit       chan struct{}
+}
+
+func NewSender(config Config) *Sender {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	hostname, _ := os.Hostname()
+	return &Sender{
+		config:     config,
+		hostname:   hostname,
+		httpClient: &http.Client{Timeout: config.Interval},
+		abort:      make(chan struct{}),
+		exit:       make(chan struct{}),
+	}
+}
+
+// Start sends heartbeats periodically until Stop is called.
+func (s *Sender) Start() {
+	supervisor.Go("heartbeat", func() {
+		for {
+			s.Run()
+			select {
+			case <-s.abort:
+				close(s.exit)
+				return
+			case <-time.After(s.config.Interval):
+			}
+		}
+	})
+}
+
+// Stop stops the sender, blocking until it actually stops.
+func (s *Sender) Stop() {
+	close(s.abort)
+	<-s.exit
+}
+
+// Wait blocks until the sender stops.
+func (s *Sender) Wait() {
+	<-s.exit
+}
+
+// Run sends a single heartbeat.
+func (s *Sender) Run() {
+	err := s.send()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failures++
+		bslog.Errorf("[heartbeat] unable to send heartbeat (%d consecutive failures): %s", s.failures, err)
+		return
+	}
+	s.failures = 0
+	s.lastBeat = time.Now()
+}
+
+// HostMetrics returns the number of consecutive failed heartbeats.
+func (s *Sender) HostMetrics() map[string]metric.Metric {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]metric.Metric{
+		"heartbeat_failures": metric.Int(int64(s.failures)),
+	}
+}
+
+// State returns the time of the last heartbeat sent and the number of
+// consecutive failures.
+func (s *Sender) State() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]interface{}{
+		"last_heartbeat": s.lastBeat,
+		"failures":       s.failures,
+	}
+}
+
+func (s *Sender) send() error {
+	data, err := json.Marshal(payload{
+		Addrs:     s.config.Addrs,
+		Hostname:  s.hostname,
+		Version:   buildinfo.Version,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	var resp *http.Response
+	if s.config.URL != "" {
+		var req *http.Request
+		req, err = http.NewRequest("POST", s.config.URL, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header = header
+		resp, err = s.httpClient.Do(req)
+	} else {
+		resp, err = s.config.TsuruClient.Do("POST", s.config.Path, header, data)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected response %d: %s", resp.StatusCode, string(body))
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package heartbeat
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/buildinfo"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/tsuruapi"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (s *S) TestSenderRunTsuru(c *check.C) {
+	var req *http.Request
+	var body payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer server.Close()
+	sender := NewSender(Config{
+		TsuruClient: tsuruapi.NewClient(tsuruapi.Config{Endpoint: server.URL, Token: "mytoken"}),
+		Path:        "/node/heartbeat",
+		Addrs:       []string{"10.0.0.1"},
+	})
+	c.Assert(sender.config.Interval, check.Equals, DefaultInterval)
+	sender.Run()
+	c.Assert(req, check.NotNil)
+	c.Assert(req.Method, check.Equals, "POST")
+	c.Assert(req.URL.Path, check.Equals, "/node/heartbeat")
+	c.Assert(req.Header.Get("Authorization"), check.Equals, "bearer mytoken")
+	c.Assert(req.Header.Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(body.Addrs, check.DeepEquals, []string{"10.0.0.1"})
+	c.Assert(body.Hostname, check.Equals, sender.hostname)
+	c.Assert(body.Version, check.Equals, buildinfo.Version)
+	c.Assert(time.Since(body.Timestamp) < time.Minute, check.Equals, true)
+	c.Assert(sender.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"heartbeat_failures": metric.Int(0)})
+}
+
+func (s *S) TestSenderRunWebhook(c *check.C) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if len(paths) < 3 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	sender := NewSender(Config{URL: server.URL + "/hook"})
+	sender.Run()
+	sender.Run()
+	c.Assert(sender.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"heartbeat_failures": metric.Int(2)})
+	c.Assert(sender.State()["last_heartbeat"], check.Equals, time.Time{})
+	sender.Run()
+	c.Assert(sender.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"heartbeat_failures": metric.Int(0)})
+	c.Assert(sender.State()["last_heartbeat"], check.Not(check.Equals), time.Time{})
+	c.Assert(paths, check.DeepEquals, []string{"/hook", "/hook", "/hook"})
+}
+
+func (s *S) TestSenderStartStop(c *check.C) {
+	beats := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		beats <- struct{}{}
+	}))
+	defer server.Close()
+	sender := NewSender(Config{URL: server.URL, Interval: 10 * time.Millisecond})
+	sender.Start()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-beats:
+		case <-time.After(5 * time.Second):
+			c.Fatal("timeout waiting for heartbeat")
+		}
+	}
+	sender.Stop()
+}
@@ -0,0 +1,15 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package integration holds end to end tests running bs components against a
+// real Docker daemon, with containers logging through the syslog driver to
+// bs, which forwards logs and metrics to sinks started by the tests. They're
+// built with the integration tag and skipped when Docker isn't available:
+//
+//	go test -tags integration ./integration
+//
+// DOCKER_ENDPOINT sets the Docker daemon used, INTEGRATION_IMAGE the image of
+// the test containers (busybox by default) and INTEGRATION_HOST the address
+// the daemon uses to reach bs (127.0.0.1 by default).
+package integration
@@ -0,0 +1,245 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/log"
+	"github.com/tsuru/bs/metric"
+	_ "github.com/tsuru/bs/metric/logstash"
+	"gopkg.in/check.v1"
+)
+
+const deliveryTimeout = 30 * time.Second
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	endpoint string
+	image    string
+	host     string
+	client   *docker.Client
+}
+
+func (s *S) SetUpSuite(c *check.C) {
+	s.endpoint = envOrDefault("DOCKER_ENDPOINT", config.DefaultDockerEndpoint)
+	s.image = envOrDefault("INTEGRATION_IMAGE", "busybox:latest")
+	s.host = envOrDefault("INTEGRATION_HOST", "127.0.0.1")
+	var err error
+	s.client, err = docker.NewClient(s.endpoint)
+	c.Assert(err, check.IsNil)
+	if err = s.client.Ping(); err != nil {
+		c.Skip(fmt.Sprintf("docker unavailable at %s: %s", s.endpoint, err))
+	}
+	repository, tag := s.image, "latest"
+	if i := strings.LastIndex(s.image, ":"); i > 0 {
+		repository, tag = s.image[:i], s.image[i+1:]
+	}
+	err = s.client.PullImage(docker.PullImageOptions{Repository: repository, Tag: tag}, docker.AuthConfiguration{})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	for _, env := range []string{"LOG_SYSLOG_FORWARD_ADDRESSES", "LOG_GELF_HOST", "METRICS_LOGSTASH_HOST", "METRICS_LOGSTASH_PORT"} {
+		os.Unsetenv(env)
+	}
+}
+
+func envOrDefault(env, defaultValue string) string {
+	if value := os.Getenv(env); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// freeUDPPort returns a UDP port not in use in the loopback interface.
+func freeUDPPort(c *check.C) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// udpSink receives UDP packets, standing for a remote syslog server or
+// logstash.
+type udpSink struct {
+	conn    *net.UDPConn
+	packets chan string
+}
+
+func newUDPSink(c *check.C) *udpSink {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, check.IsNil)
+	sink := &udpSink{conn: conn, packets: make(chan string, 1000)}
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				close(sink.packets)
+				return
+			}
+			select {
+			case sink.packets <- string(buf[:n]):
+			default:
+			}
+		}
+	}()
+	return sink
+}
+
+func (s *udpSink) addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// wait returns the first packet accepted by match, failing the test if none
+// arrives in time.
+func (s *udpSink) wait(c *check.C, match func(string) bool) string {
+	timeout := time.After(deliveryTimeout)
+	for {
+		select {
+		case packet, ok := <-s.packets:
+			if !ok {
+				c.Fatal("sink closed")
+			}
+			if match(packet) {
+				return packet
+			}
+		case <-timeout:
+			c.Fatalf("timeout waiting for packet in sink %s", s.addr())
+		}
+	}
+}
+
+func (s *udpSink) close() {
+	s.conn.Close()
+}
+
+// startContainer starts a tsuru app container printing marker every second,
+// logging to bs through the syslog driver.
+func (s *S) startContainer(c *check.C, syslogPort int, marker string) *docker.Container {
+	cont, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image: s.image,
+			Cmd:   []string{"sh", "-c", fmt.Sprintf("while true; do echo %s; sleep 1; done", marker)},
+			Env:   []string{"TSURU_APPNAME=integrationapp", "TSURU_PROCESSNAME=web"},
+		},
+		HostConfig: &docker.HostConfig{
+			LogConfig: docker.LogConfig{
+				Type:   "syslog",
+				Config: map[string]string{"syslog-address": fmt.Sprintf("udp://%s:%d", s.host, syslogPort)},
+			},
+		},
+	})
+	c.Assert(err, check.IsNil)
+	err = s.client.StartContainer(cont.ID, nil)
+	c.Assert(err, check.IsNil)
+	return cont
+}
+
+func (s *S) removeContainer(cont *docker.Container) {
+	s.client.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID, Force: true})
+}
+
+func (s *S) TestLogDelivery(c *check.C) {
+	syslogSink := newUDPSink(c)
+	defer syslogSink.close()
+	gelfPort := freeUDPPort(c)
+	gelfReader, err := gelf.NewReader(fmt.Sprintf("127.0.0.1:%d", gelfPort))
+	c.Assert(err, check.IsNil)
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "udp://"+syslogSink.addr().String())
+	os.Setenv("LOG_GELF_HOST", fmt.Sprintf("127.0.0.1:%d", gelfPort))
+	bsPort := freeUDPPort(c)
+	lf := log.LogForwarder{
+		BindAddress:     fmt.Sprintf("udp://0.0.0.0:%d", bsPort),
+		DockerEndpoint:  s.endpoint,
+		EnabledBackends: []string{"syslog", "gelf"},
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer func() {
+		lf.Stop()
+		lf.Wait()
+	}()
+	marker := fmt.Sprintf("integration-log-%d", time.Now().UnixNano())
+	cont := s.startContainer(c, bsPort, marker)
+	defer s.removeContainer(cont)
+	line := syslogSink.wait(c, func(packet string) bool {
+		return strings.Contains(packet, marker)
+	})
+	c.Assert(line, check.Matches, fmt.Sprintf(`<\d+>.* integrationapp\[web\]: %s\n?`, regexp.QuoteMeta(marker)))
+	msgs := make(chan *gelf.Message)
+	go func() {
+		for {
+			msg, err := gelfReader.ReadMessage()
+			if err != nil {
+				close(msgs)
+				return
+			}
+			msgs <- msg
+		}
+	}()
+	timeout := time.After(deliveryTimeout)
+	for {
+		select {
+		case msg, ok := <-msgs:
+			c.Assert(ok, check.Equals, true)
+			if msg.Short != marker {
+				continue
+			}
+			c.Assert(msg.Extra["_app"], check.Equals, "integrationapp")
+			c.Assert(msg.Extra["_pid"], check.Equals, "web")
+			return
+		case <-timeout:
+			c.Fatal("timeout waiting for gelf message")
+		}
+	}
+}
+
+func (s *S) TestMetricDelivery(c *check.C) {
+	logstashSink := newUDPSink(c)
+	defer logstashSink.close()
+	os.Setenv("METRICS_LOGSTASH_HOST", "127.0.0.1")
+	os.Setenv("METRICS_LOGSTASH_PORT", fmt.Sprint(logstashSink.addr().Port))
+	marker := fmt.Sprintf("integration-metric-%d", time.Now().UnixNano())
+	cont := s.startContainer(c, freeUDPPort(c), marker)
+	defer s.removeContainer(cont)
+	runner := metric.NewRunner(s.endpoint, time.Second, "logstash")
+	err := runner.Start()
+	c.Assert(err, check.IsNil)
+	defer runner.Stop()
+	var message map[string]interface{}
+	logstashSink.wait(c, func(packet string) bool {
+		message = nil
+		if err := json.Unmarshal([]byte(packet), &message); err != nil {
+			return false
+		}
+		return message["app"] == "integrationapp" && message["metric"] == "mem_max"
+	})
+	c.Assert(message["process"], check.Equals, "web")
+	c.Assert(message["host"], check.Equals, cont.ID[:12])
+	c.Assert(message["client"], check.Equals, "tsuru")
+	value, ok := message["value"].(float64)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(value > 0, check.Equals, true)
+}
@@ -0,0 +1,19 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jitter
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Add returns interval increased by a random duration in the range [0, max),
+// so agents started together don't keep reporting at the same instant.
+func Add(interval, max time.Duration) time.Duration {
+	if max <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(max)))
+}
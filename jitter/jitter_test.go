@@ -0,0 +1,30 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package jitter
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (S) TestAdd(c *check.C) {
+	c.Assert(Add(time.Minute, 0), check.Equals, time.Minute)
+	c.Assert(Add(time.Minute, -time.Second), check.Equals, time.Minute)
+	for i := 0; i < 100; i++ {
+		d := Add(time.Minute, 10*time.Second)
+		c.Assert(d >= time.Minute, check.Equals, true)
+		c.Assert(d < time.Minute+10*time.Second, check.Equals, true)
+	}
+}
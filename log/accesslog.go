@@ -0,0 +1,279 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/metric"
+)
+
+const (
+	maxLatencySamples     = 1000
+	containerStatsMaxIdle = 10 * time.Minute
+)
+
+// accessLog holds the fields parsed from an HTTP access log line. Latency is
+// in seconds and negative when not present in the line.
+type accessLog struct {
+	method  string
+	path    string
+	status  int
+	bytes   int64
+	latency float64
+}
+
+// accessLogParser recognizes access log lines in the Common and Combined Log
+// Formats, used by nginx and apache, optionally followed by the request time,
+// and in the W3C Extended Log Format when its fields are configured.
+type accessLogParser struct {
+	w3cFields []string
+	metrics   bool
+	mu        sync.Mutex
+	stats     map[string]*accessStats
+	lastPrune time.Time
+}
+
+type accessStats struct {
+	since     time.Time
+	lastSeen  time.Time
+	requests  int
+	errors    int
+	seen      int
+	latencies []float64
+}
+
+// newAccessLogParser returns the access log parser enabled in the
+// environment, or nil when access log parsing is disabled.
+func newAccessLogParser() *accessLogParser {
+	if !config.BoolEnvOrDefault(false, "LOG_ACCESS_LOGS") {
+		return nil
+	}
+	return &accessLogParser{
+		w3cFields: config.StringsEnvOrDefault(nil, "LOG_ACCESS_LOGS_W3C_FIELDS"),
+		metrics:   config.BoolEnvOrDefault(false, "LOG_ACCESS_LOGS_METRICS"),
+		stats:     make(map[string]*accessStats),
+		lastPrune: time.Now(),
+	}
+}
+
+func (p *accessLogParser) parse(line []byte) (*accessLog, bool) {
+	if a, ok := parseCommonLog(line); ok {
+		return a, true
+	}
+	if len(p.w3cFields) > 0 {
+		return parseW3CLog(line, p.w3cFields)
+	}
+	return nil, false
+}
+
+// record adds the request to the metrics of the container.
+func (p *accessLogParser) record(containerID string, a *accessLog) {
+	if !p.metrics {
+		return
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats[containerID]
+	if s == nil {
+		s = &accessStats{since: now}
+		p.stats[containerID] = s
+	}
+	s.lastSeen = now
+	s.requests++
+	if a.status >= 500 {
+		s.errors++
+	}
+	if a.latency < 0 {
+		return
+	}
+	// Reservoir sampling keeps a uniform sample of the latencies with bounded
+	// memory.
+	s.seen++
+	if len(s.latencies) < maxLatencySamples {
+		s.latencies = append(s.latencies, a.latency)
+	} else if i := rand.Intn(s.seen); i < maxLatencySamples {
+		s.latencies[i] = a.latency
+	}
+}
+
+// containerMetrics returns the request rate, the 5xx responses rate and the
+// 95th percentile latency of the container since the last call, resetting
+// them.
+func (p *accessLogParser) containerMetrics(containerID string) map[string]metric.Metric {
+	if !p.metrics {
+		return nil
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.lastPrune) > containerStatsMaxIdle {
+		for id, s := range p.stats {
+			if now.Sub(s.lastSeen) > containerStatsMaxIdle {
+				delete(p.stats, id)
+			}
+		}
+		p.lastPrune = now
+	}
+	s := p.stats[containerID]
+	if s == nil {
+		return nil
+	}
+	elapsed := now.Sub(s.since).Seconds()
+	if elapsed < 1 {
+		elapsed = 1
+	}
+	metrics := map[string]metric.Metric{
+		"http_request_rate": metric.Float(float64(s.requests) / elapsed),
+		"http_5xx_rate":     metric.Float(float64(s.errors) / elapsed),
+	}
+	if len(s.latencies) > 0 {
+		sort.Float64s(s.latencies)
+		metrics["http_latency_p95"] = metric.Float(s.latencies[(len(s.latencies)*95-1)/100])
+	}
+	if s.requests == 0 {
+		delete(p.stats, containerID)
+		return metrics
+	}
+	s.since = now
+	s.requests, s.errors, s.seen = 0, 0, 0
+	s.latencies = s.latencies[:0]
+	return metrics
+}
+
+// parseCommonLog parses lines like:
+//
+//	127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /index.html?a=1 HTTP/1.1" 200 2326 "-" "curl/7.47.0" 0.012
+//
+// The referer and user agent are optional, the request time may be a bare
+// number of seconds, like nginx $request_time, or a rt= or request_time= pair.
+func parseCommonLog(line []byte) (*accessLog, bool) {
+	open := bytes.IndexByte(line, '[')
+	if open < 1 || line[open-1] != ' ' {
+		return nil, false
+	}
+	end := bytes.IndexByte(line[open:], ']')
+	if end == -1 {
+		return nil, false
+	}
+	rest := line[open+end+1:]
+	if !bytes.HasPrefix(rest, []byte(` "`)) {
+		return nil, false
+	}
+	rest = rest[2:]
+	end = bytes.IndexByte(rest, '"')
+	if end == -1 {
+		return nil, false
+	}
+	request := strings.Fields(string(rest[:end]))
+	if len(request) < 2 {
+		return nil, false
+	}
+	rest = rest[end+1:]
+	fields := strings.Fields(string(rest))
+	if len(fields) < 2 {
+		return nil, false
+	}
+	a := &accessLog{method: request[0], path: requestPath(request[1]), latency: -1}
+	var err error
+	a.status, err = strconv.Atoi(fields[0])
+	if err != nil || a.status < 100 || a.status > 599 {
+		return nil, false
+	}
+	if fields[1] != "-" {
+		a.bytes, err = strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, false
+		}
+	}
+	a.latency = findLatency(rest)
+	return a, true
+}
+
+// findLatency looks for the request time after the quoted fields of a
+// combined log line.
+func findLatency(rest []byte) float64 {
+	if idx := bytes.LastIndexByte(rest, '"'); idx != -1 {
+		rest = rest[idx+1:]
+	} else if fields := bytes.Fields(rest); len(fields) > 2 {
+		rest = bytes.Join(fields[2:], []byte{' '})
+	} else {
+		return -1
+	}
+	for _, field := range strings.Fields(string(rest)) {
+		if idx := strings.IndexByte(field, '='); idx != -1 {
+			switch field[:idx] {
+			case "rt", "request_time":
+				field = field[idx+1:]
+			default:
+				continue
+			}
+		} else if !strings.Contains(field, ".") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(field, 64); err == nil && v >= 0 {
+			return v
+		}
+	}
+	return -1
+}
+
+// parseW3CLog parses a line in the W3C Extended Log Format with the given
+// fields, as declared in its #Fields directive. The method, path, status,
+// bytes and latency are taken from the cs-method, cs-uri-stem, sc-status,
+// sc-bytes and time-taken (in milliseconds) fields.
+func parseW3CLog(line []byte, names []string) (*accessLog, bool) {
+	if len(line) == 0 || line[0] == '#' {
+		return nil, false
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) != len(names) {
+		return nil, false
+	}
+	a := &accessLog{latency: -1, status: -1}
+	for i, name := range names {
+		value := fields[i]
+		if value == "-" {
+			continue
+		}
+		switch name {
+		case "cs-method":
+			a.method = value
+		case "cs-uri-stem":
+			a.path = value
+		case "sc-status":
+			status, err := strconv.Atoi(value)
+			if err != nil || status < 100 || status > 599 {
+				return nil, false
+			}
+			a.status = status
+		case "sc-bytes":
+			a.bytes, _ = strconv.ParseInt(value, 10, 64)
+		case "time-taken":
+			if ms, err := strconv.ParseFloat(value, 64); err == nil {
+				a.latency = ms / 1000
+			}
+		}
+	}
+	if a.status == -1 || a.method == "" {
+		return nil, false
+	}
+	return a, true
+}
+
+func requestPath(uri string) string {
+	if idx := strings.IndexAny(uri, "?#"); idx != -1 {
+		return uri[:idx]
+	}
+	return uri
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"os"
+	"time"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseCommonLog(c *check.C) {
+	tests := []struct {
+		line     string
+		expected *accessLog
+	}{
+		{
+			`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			&accessLog{method: "GET", path: "/apache_pb.gif", status: 200, bytes: 2326, latency: -1},
+		},
+		{
+			`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "POST /users?page=2 HTTP/1.1" 502 - "-" "curl/7.47.0"`,
+			&accessLog{method: "POST", path: "/users", status: 502, latency: -1},
+		},
+		{
+			`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET / HTTP/1.1" 200 612 "http://a.com/x y" "Mozilla/5.0 (X11)" 0.012`,
+			&accessLog{method: "GET", path: "/", status: 200, bytes: 612, latency: 0.012},
+		},
+		{
+			`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET /health HTTP/1.1" 204 0 "-" "kube-probe/1.7" upstream=app rt=1.5`,
+			&accessLog{method: "GET", path: "/health", status: 204, latency: 1.5},
+		},
+		{
+			`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET /a HTTP/1.1" 404 10 request_time=0.250`,
+			&accessLog{method: "GET", path: "/a", status: 404, bytes: 10, latency: 0.25},
+		},
+		{
+			`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET /a HTTP/1.1" 200 10 "-" "agent" 1234`,
+			&accessLog{method: "GET", path: "/a", status: 200, bytes: 10, latency: -1},
+		},
+		{`[INFO] "GET /a HTTP/1.1" 200 10`, nil},
+		{`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET /a HTTP/1.1" 999 10`, nil},
+		{`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET /a HTTP/1.1" 200 abc`, nil},
+		{`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "GET /a HTTP/1.1"`, nil},
+		{`10.0.0.1 - - [05/Jun/2017:16:13:47 +0000] "-" 400 0`, nil},
+		{`just a regular log line`, nil},
+	}
+	for _, tt := range tests {
+		a, ok := parseCommonLog([]byte(tt.line))
+		c.Check(ok, check.Equals, tt.expected != nil, check.Commentf("%q", tt.line))
+		c.Check(a, check.DeepEquals, tt.expected, check.Commentf("%q", tt.line))
+	}
+}
+
+func (s *S) TestParseW3CLog(c *check.C) {
+	fields := []string{"date", "time", "s-ip", "cs-method", "cs-uri-stem", "cs-uri-query", "sc-status", "sc-bytes", "time-taken"}
+	a, ok := parseW3CLog([]byte("2017-06-05 16:13:47 10.0.0.1 GET /default.htm a=1 503 1024 250"), fields)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(a, check.DeepEquals, &accessLog{method: "GET", path: "/default.htm", status: 503, bytes: 1024, latency: 0.25})
+	a, ok = parseW3CLog([]byte("2017-06-05 16:13:47 10.0.0.1 GET /default.htm - 200 - -"), fields)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(a, check.DeepEquals, &accessLog{method: "GET", path: "/default.htm", status: 200, latency: -1})
+	for _, line := range []string{
+		"#Fields: date time s-ip cs-method cs-uri-stem cs-uri-query sc-status sc-bytes time-taken",
+		"2017-06-05 16:13:47 10.0.0.1 GET /default.htm",
+		"2017-06-05 16:13:47 10.0.0.1 GET /default.htm - abc 0 1",
+		"",
+	} {
+		_, ok = parseW3CLog([]byte(line), fields)
+		c.Check(ok, check.Equals, false, check.Commentf("%q", line))
+	}
+}
+
+func (s *S) TestNewAccessLogParser(c *check.C) {
+	c.Assert(newAccessLogParser(), check.IsNil)
+	os.Setenv("LOG_ACCESS_LOGS", "true")
+	p := newAccessLogParser()
+	c.Assert(p, check.NotNil)
+	c.Assert(p.metrics, check.Equals, false)
+	_, ok := p.parse([]byte("2017-06-05 16:13:47 GET / 200 10"))
+	c.Assert(ok, check.Equals, false)
+	os.Setenv("LOG_ACCESS_LOGS_W3C_FIELDS", "date,time,cs-method,cs-uri-stem,sc-status,time-taken")
+	os.Setenv("LOG_ACCESS_LOGS_METRICS", "true")
+	p = newAccessLogParser()
+	c.Assert(p.metrics, check.Equals, true)
+	a, ok := p.parse([]byte("2017-06-05 16:13:47 GET / 200 10"))
+	c.Assert(ok, check.Equals, true)
+	c.Assert(a, check.DeepEquals, &accessLog{method: "GET", path: "/", status: 200, latency: 0.01})
+}
+
+func (s *S) TestAccessLogParserMetrics(c *check.C) {
+	p := &accessLogParser{metrics: true, stats: make(map[string]*accessStats), lastPrune: time.Now()}
+	c.Assert(p.containerMetrics("c1"), check.IsNil)
+	for i := 1; i <= 100; i++ {
+		status := 200
+		if i%10 == 0 {
+			status = 503
+		}
+		p.record("c1", &accessLog{status: status, latency: float64(i) / 100})
+	}
+	p.record("c1", &accessLog{status: 200, latency: -1})
+	p.stats["c1"].since = time.Now().Add(-10 * time.Second)
+	metrics := p.containerMetrics("c1")
+	rate := metrics["http_request_rate"].Float64()
+	c.Assert(rate > 9.9 && rate <= 10.1, check.Equals, true)
+	rate = metrics["http_5xx_rate"].Float64()
+	c.Assert(rate > 0.9 && rate <= 1, check.Equals, true)
+	c.Assert(metrics["http_latency_p95"], check.Equals, metric.Float(0.95))
+	metrics = p.containerMetrics("c1")
+	c.Assert(metrics, check.DeepEquals, map[string]metric.Metric{"http_request_rate": metric.Float(0), "http_5xx_rate": metric.Float(0)})
+	c.Assert(p.stats, check.HasLen, 0)
+	c.Assert(p.containerMetrics("c1"), check.IsNil)
+}
+
+func (s *S) TestAccessLogParserMetricsSampling(c *check.C) {
+	p := &accessLogParser{metrics: true, stats: make(map[string]*accessStats), lastPrune: time.Now()}
+	for i := 0; i < maxLatencySamples*3; i++ {
+		p.record("c1", &accessLog{status: 200, latency: 1})
+	}
+	c.Assert(p.stats["c1"].latencies, check.HasLen, maxLatencySamples)
+	c.Assert(p.stats["c1"].requests, check.Equals, maxLatencySamples*3)
+	c.Assert(p.containerMetrics("c1")["http_latency_p95"], check.Equals, metric.Float(1))
+}
+
+func (s *S) TestAccessLogParserMetricsPrune(c *check.C) {
+	p := &accessLogParser{metrics: true, stats: make(map[string]*accessStats), lastPrune: time.Now()}
+	p.record("c1", &accessLog{status: 200, latency: -1})
+	p.record("c2", &accessLog{status: 200, latency: -1})
+	p.stats["c1"].lastSeen = time.Now().Add(-2 * containerStatsMaxIdle)
+	p.lastPrune = time.Now().Add(-2 * containerStatsMaxIdle)
+	p.containerMetrics("c3")
+	c.Assert(p.stats, check.HasLen, 1)
+	c.Assert(p.stats["c2"], check.NotNil)
+}
+
+func (s *S) TestAccessLogParserMetricsDisabled(c *check.C) {
+	p := &accessLogParser{stats: make(map[string]*accessStats)}
+	p.record("c1", &accessLog{status: 200, latency: -1})
+	c.Assert(p.stats, check.HasLen, 0)
+	c.Assert(p.containerMetrics("c1"), check.IsNil)
+}
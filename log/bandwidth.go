@@ -0,0 +1,85 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/tsuru/tsuru/app"
+)
+
+// bandwidthLimiter caps the bytes per second forwarded to a destination,
+// shared by all shards of its queue. Forwarders wait for the bandwidth
+// instead of dropping messages, which accumulate in the queue buffer and are
+// only dropped when it's full.
+type bandwidthLimiter struct {
+	rate   float64
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	after  func(time.Duration) <-chan time.Time
+}
+
+// newBandwidthLimiter returns a limiter allowing bytesPerSecond, with bursts
+// of up to one second of traffic, or nil if bytesPerSecond isn't positive.
+func newBandwidthLimiter(bytesPerSecond int) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+		now:    time.Now,
+		after:  time.After,
+	}
+}
+
+// wait blocks until n bytes can be sent or quit is closed, returning false in
+// the latter case, so stopping a forwarder isn't delayed by a throttled
+// message. A nil limiter never blocks.
+func (l *bandwidthLimiter) wait(n int, quit <-chan bool) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-l.after(delay):
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// messageSize returns the approximate number of bytes sent to forward msg.
+func messageSize(msg LogMessage) int {
+	switch m := msg.(type) {
+	case bufferWithIdx:
+		return len(m.buffer)
+	case *gelf.Message:
+		return len(m.Short) + len(m.Full) + len(m.Host) + len(m.RawExtra)
+	case *app.Applog:
+		return len(m.Message) + len(m.AppName) + len(m.Source) + len(m.Unit)
+	}
+	return 0
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/tsuru/tsuru/app"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestNewBandwidthLimiterDisabled(c *check.C) {
+	c.Assert(newBandwidthLimiter(0), check.IsNil)
+	c.Assert(newBandwidthLimiter(-1), check.IsNil)
+	var l *bandwidthLimiter
+	c.Assert(l.wait(1000, nil), check.Equals, true)
+}
+
+func (s *S) TestBandwidthLimiterWait(c *check.C) {
+	l := newBandwidthLimiter(1000)
+	var slept []time.Duration
+	l.after = func(d time.Duration) <-chan time.Time {
+		slept = append(slept, d)
+		return time.After(0)
+	}
+	now := l.last
+	l.now = func() time.Time { return now }
+	c.Assert(l.wait(600, nil), check.Equals, true)
+	c.Assert(l.wait(400, nil), check.Equals, true)
+	c.Assert(slept, check.HasLen, 0)
+	c.Assert(l.wait(500, nil), check.Equals, true)
+	c.Assert(slept, check.HasLen, 1)
+	c.Assert(slept[0], check.Equals, 500*time.Millisecond)
+	c.Assert(l.wait(250, nil), check.Equals, true)
+	c.Assert(slept, check.HasLen, 2)
+	c.Assert(slept[1], check.Equals, 750*time.Millisecond)
+}
+
+func (s *S) TestBandwidthLimiterRefill(c *check.C) {
+	l := newBandwidthLimiter(1000)
+	var slept []time.Duration
+	l.after = func(d time.Duration) <-chan time.Time {
+		slept = append(slept, d)
+		return time.After(0)
+	}
+	now := l.last
+	l.now = func() time.Time { return now }
+	l.tokens = 0
+	now = now.Add(10 * time.Second)
+	c.Assert(l.wait(1000, nil), check.Equals, true)
+	c.Assert(slept, check.HasLen, 0)
+	now = now.Add(500 * time.Millisecond)
+	c.Assert(l.wait(1000, nil), check.Equals, true)
+	c.Assert(slept, check.DeepEquals, []time.Duration{500 * time.Millisecond})
+}
+
+func (s *S) TestBandwidthLimiterWaitQuit(c *check.C) {
+	l := newBandwidthLimiter(1000)
+	l.tokens = 0
+	quit := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		done <- l.wait(1000000, quit)
+	}()
+	close(quit)
+	select {
+	case ok := <-done:
+		c.Assert(ok, check.Equals, false)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for the limiter to stop")
+	}
+}
+
+func (s *S) TestMessageSize(c *check.C) {
+	c.Assert(messageSize(bufferWithIdx{buffer: []byte("<30>msg\n")}), check.Equals, 8)
+	c.Assert(messageSize(&gelf.Message{Short: "msg", Host: "host"}), check.Equals, 7)
+	c.Assert(messageSize(&app.Applog{Message: "msg", AppName: "app", Source: "web", Unit: "abc"}), check.Equals, 12)
+	c.Assert(messageSize("other"), check.Equals, 0)
+}
@@ -2,13 +2,17 @@ package log
 
 import (
 	"bufio"
+	"compress/gzip"
 	"fmt"
 	"net"
 	"sync"
 	"time"
 )
 
-var bufferConnSize = 4096
+var (
+	bufferConnSize           = 4096
+	compressedBufferConnSize = 64 * 1024
+)
 
 type bufferedConn struct {
 	net.Conn
@@ -32,6 +36,38 @@ func newBufferedConn(conn net.Conn, maxLatency time.Duration) *bufferedConn {
 	return bConn
 }
 
+// newGzipBufferedConn returns a buffered connection that compresses every
+// batch written to conn as a separate gzip member. The stream sent is a valid
+// multi-member gzip stream, which can be read by any gzip reader.
+func newGzipBufferedConn(conn net.Conn, maxLatency time.Duration) *bufferedConn {
+	bConn := &bufferedConn{
+		Conn:    conn,
+		w:       bufio.NewWriterSize(&gzipBatchWriter{conn: conn, gz: gzip.NewWriter(nil)}, compressedBufferConnSize),
+		latency: maxLatency,
+		done:    make(chan struct{}),
+	}
+	if bConn.latency > 0 {
+		go bConn.flushLoop()
+	}
+	return bConn
+}
+
+type gzipBatchWriter struct {
+	conn net.Conn
+	gz   *gzip.Writer
+}
+
+func (w *gzipBatchWriter) Write(batch []byte) (int, error) {
+	w.gz.Reset(w.conn)
+	if _, err := w.gz.Write(batch); err != nil {
+		return 0, err
+	}
+	if err := w.gz.Close(); err != nil {
+		return 0, err
+	}
+	return len(batch), nil
+}
+
 func (c *bufferedConn) Write(msg []byte) (int, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -57,6 +93,13 @@ func (c *bufferedConn) Close() error {
 	return err
 }
 
+// Flush writes the buffered data to the connection.
+func (c *bufferedConn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
 func (c *bufferedConn) SetWriteDeadline(deadline time.Time) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -2,7 +2,9 @@ package log
 
 import (
 	"bytes"
+	"compress/gzip"
 	"io"
+	"io/ioutil"
 	"net"
 	"time"
 
@@ -104,3 +106,32 @@ func (s *S) TestBufferedConnSetWriteDeadlineIsEffective(c *check.C) {
 	err = bConn.Close()
 	c.Assert(err, check.ErrorMatches, `.*i/o timeout.*`)
 }
+
+func (s *S) TestGzipBufferedConn(c *check.C) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer tcpListener.Close()
+	ch := make(chan []byte)
+	go func() {
+		conn, err := tcpListener.Accept()
+		c.Assert(err, check.IsNil)
+		defer conn.Close()
+		reader, err := gzip.NewReader(conn)
+		c.Assert(err, check.IsNil)
+		data, err := ioutil.ReadAll(reader)
+		c.Assert(err, check.IsNil)
+		ch <- data
+	}()
+	conn, err := net.Dial("tcp", tcpListener.Addr().String())
+	c.Assert(err, check.IsNil)
+	bConn := newGzipBufferedConn(conn, 0)
+	_, err = bConn.Write([]byte("msg1\n"))
+	c.Assert(err, check.IsNil)
+	err = bConn.flush()
+	c.Assert(err, check.IsNil)
+	_, err = bConn.Write([]byte("msg2\n"))
+	c.Assert(err, check.IsNil)
+	err = bConn.Close()
+	c.Assert(err, check.IsNil)
+	c.Assert(string(<-ch), check.Equals, "msg1\nmsg2\n")
+}
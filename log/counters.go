@@ -0,0 +1,165 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/metric"
+)
+
+const logCountersLabel = "bs.tsuru.io/log-counters"
+
+type logCounter struct {
+	name   string
+	regexp *regexp.Regexp
+}
+
+// logCounters counts the log lines of each container matching configurable
+// regular expressions, reported as container metrics. Counters are set for
+// every app in the LOG_COUNTERS env var and for a single app in the
+// bs.tsuru.io/log-counters label of its containers, both holding a JSON
+// object mapping counter names to regular expressions.
+type logCounters struct {
+	global    []logCounter
+	mu        sync.Mutex
+	byLabel   map[string][]logCounter
+	stats     map[string]*counterStats
+	lastPrune time.Time
+}
+
+type counterStats struct {
+	counters []logCounter
+	counts   []uint64
+	lastSeen time.Time
+}
+
+// newLogCounters returns the log counters configured in the environment. The
+// global counters may be empty, as apps can still set their own counters.
+func newLogCounters() *logCounters {
+	global, err := parseLogCounters(config.StringEnvOrDefault("", "LOG_COUNTERS"))
+	if err != nil {
+		bslog.Warnf("[log forwarder] ignoring invalid LOG_COUNTERS: %s", err)
+	}
+	return &logCounters{
+		global:    global,
+		byLabel:   make(map[string][]logCounter),
+		stats:     make(map[string]*counterStats),
+		lastPrune: time.Now(),
+	}
+}
+
+func parseLogCounters(data string) ([]logCounter, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var exprs map[string]string
+	err := json.Unmarshal([]byte(data), &exprs)
+	if err != nil {
+		return nil, err
+	}
+	counters := make([]logCounter, 0, len(exprs))
+	for name, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("counter %q: %s", name, err)
+		}
+		counters = append(counters, logCounter{name: name, regexp: re})
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		return counters[i].name < counters[j].name
+	})
+	return counters, nil
+}
+
+// countersFor returns the global counters merged with the ones set in the
+// labels of the container, which take precedence. Parsed labels are cached
+// by value. Must be called with c.mu held.
+func (c *logCounters) countersFor(cont *container.Container) []logCounter {
+	if cont.Config == nil {
+		return c.global
+	}
+	label := cont.Config.Labels[logCountersLabel]
+	if label == "" {
+		return c.global
+	}
+	if counters, ok := c.byLabel[label]; ok {
+		return counters
+	}
+	appCounters, err := parseLogCounters(label)
+	if err != nil {
+		bslog.Warnf("[log forwarder] ignoring invalid %s label in container %s: %s", logCountersLabel, cont.ShortHostname, err)
+	}
+	names := make(map[string]bool, len(appCounters))
+	for _, counter := range appCounters {
+		names[counter.name] = true
+	}
+	counters := appCounters
+	for _, counter := range c.global {
+		if !names[counter.name] {
+			counters = append(counters, counter)
+		}
+	}
+	c.byLabel[label] = counters
+	return counters
+}
+
+// count increments the counters of the container matching content.
+func (c *logCounters) count(cont *container.Container, content []byte) {
+	if len(c.global) == 0 && (cont.Config == nil || cont.Config.Labels[logCountersLabel] == "") {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats[cont.ID]
+	if s == nil {
+		counters := c.countersFor(cont)
+		if len(counters) == 0 {
+			return
+		}
+		s = &counterStats{counters: counters, counts: make([]uint64, len(counters))}
+		c.stats[cont.ID] = s
+	}
+	s.lastSeen = time.Now()
+	for i, counter := range s.counters {
+		if counter.regexp.Match(content) {
+			s.counts[i]++
+		}
+	}
+}
+
+// containerMetrics returns the number of lines matching each counter of the
+// container since the last call, named after the counter with a log_ prefix.
+func (c *logCounters) containerMetrics(id string) map[string]metric.Metric {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPrune) > containerStatsMaxIdle {
+		for id, s := range c.stats {
+			if now.Sub(s.lastSeen) > containerStatsMaxIdle {
+				delete(c.stats, id)
+			}
+		}
+		c.lastPrune = now
+	}
+	s := c.stats[id]
+	if s == nil {
+		return nil
+	}
+	metrics := make(map[string]metric.Metric, len(s.counters))
+	for i, counter := range s.counters {
+		metrics["log_"+counter.name] = metric.Int(int64(s.counts[i]))
+		s.counts[i] = 0
+	}
+	return metrics
+}
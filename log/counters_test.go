@@ -0,0 +1,103 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"os"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseLogCounters(c *check.C) {
+	counters, err := parseLogCounters(`{"timeouts": "(?i)timeout", "errors": "ERROR"}`)
+	c.Assert(err, check.IsNil)
+	c.Assert(counters, check.HasLen, 2)
+	c.Assert(counters[0].name, check.Equals, "errors")
+	c.Assert(counters[0].regexp.String(), check.Equals, "ERROR")
+	c.Assert(counters[1].name, check.Equals, "timeouts")
+	counters, err = parseLogCounters("")
+	c.Assert(err, check.IsNil)
+	c.Assert(counters, check.IsNil)
+	_, err = parseLogCounters(`{"errors": "(ERROR"}`)
+	c.Assert(err, check.ErrorMatches, `counter "errors": .*`)
+	_, err = parseLogCounters(`["ERROR"]`)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestNewLogCounters(c *check.C) {
+	c.Assert(newLogCounters().global, check.HasLen, 0)
+	os.Setenv("LOG_COUNTERS", `{"errors": "ERROR"}`)
+	counters := newLogCounters()
+	c.Assert(counters.global, check.HasLen, 1)
+	os.Setenv("LOG_COUNTERS", `{"errors": "(ERROR"}`)
+	c.Assert(newLogCounters().global, check.HasLen, 0)
+}
+
+func (s *S) TestLogCountersCount(c *check.C) {
+	os.Setenv("LOG_COUNTERS", `{"errors": "ERROR", "timeouts": "(?i)timeout"}`)
+	counters := newLogCounters()
+	cont := &container.Container{Container: docker.Container{ID: "c1", Config: &docker.Config{}}}
+	c.Assert(counters.containerMetrics("c1"), check.IsNil)
+	for _, line := range []string{"ERROR: request Timeout", "ok", "ERROR", "connection timeout"} {
+		counters.count(cont, []byte(line))
+	}
+	c.Assert(counters.containerMetrics("c1"), check.DeepEquals, map[string]metric.Metric{
+		"log_errors":   metric.Int(2),
+		"log_timeouts": metric.Int(2),
+	})
+	c.Assert(counters.containerMetrics("c1"), check.DeepEquals, map[string]metric.Metric{
+		"log_errors":   metric.Int(0),
+		"log_timeouts": metric.Int(0),
+	})
+}
+
+func (s *S) TestLogCountersLabel(c *check.C) {
+	os.Setenv("LOG_COUNTERS", `{"errors": "ERROR", "timeouts": "timeout"}`)
+	counters := newLogCounters()
+	cont := &container.Container{Container: docker.Container{ID: "c1", Config: &docker.Config{
+		Labels: map[string]string{logCountersLabel: `{"errors": "(?i)error", "slow": "slow query"}`},
+	}}}
+	for _, line := range []string{"error: slow query", "timeout", "ERROR"} {
+		counters.count(cont, []byte(line))
+	}
+	c.Assert(counters.containerMetrics("c1"), check.DeepEquals, map[string]metric.Metric{
+		"log_errors":   metric.Int(2),
+		"log_slow":     metric.Int(1),
+		"log_timeouts": metric.Int(1),
+	})
+	c.Assert(counters.byLabel, check.HasLen, 1)
+}
+
+func (s *S) TestLogCountersLabelOnly(c *check.C) {
+	counters := newLogCounters()
+	cont := &container.Container{Container: docker.Container{ID: "c1", Config: &docker.Config{}}}
+	counters.count(cont, []byte("ERROR"))
+	c.Assert(counters.stats, check.HasLen, 0)
+	cont.Config.Labels = map[string]string{logCountersLabel: `{"errors": "ERROR"}`}
+	cont.ID = "c2"
+	counters.count(cont, []byte("ERROR"))
+	c.Assert(counters.containerMetrics("c2"), check.DeepEquals, map[string]metric.Metric{"log_errors": metric.Int(1)})
+	cont.Config.Labels = map[string]string{logCountersLabel: `{"errors": "(ERROR"}`}
+	cont.ID = "c3"
+	counters.count(cont, []byte("ERROR"))
+	c.Assert(counters.containerMetrics("c3"), check.IsNil)
+}
+
+func (s *S) TestLogCountersPrune(c *check.C) {
+	os.Setenv("LOG_COUNTERS", `{"errors": "ERROR"}`)
+	counters := newLogCounters()
+	for _, id := range []string{"c1", "c2"} {
+		counters.count(&container.Container{Container: docker.Container{ID: id}}, []byte("ERROR"))
+	}
+	counters.stats["c1"].lastSeen = time.Now().Add(-2 * containerStatsMaxIdle)
+	counters.lastPrune = time.Now().Add(-2 * containerStatsMaxIdle)
+	counters.containerMetrics("c3")
+	c.Assert(counters.stats, check.HasLen, 1)
+	c.Assert(counters.stats["c2"], check.NotNil)
+}
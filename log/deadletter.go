@@ -0,0 +1,232 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+)
+
+const (
+	deadLetterSyslog     = "syslog"
+	deadLetterKubernetes = "kubernetes"
+)
+
+var errInvalidMessage = errors.New("missing timestamp or priority")
+
+// deadLetter is a message bs was unable to parse, written as a JSON line to
+// the dead-letter destination. Raw holds the bytes received, encoded in
+// base64.
+type deadLetter struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Error  string    `json:"error"`
+	Raw    []byte    `json:"raw"`
+}
+
+// rawLine returns the line p was parsed from, or nil when p wasn't received
+// as a syslog message.
+func (p *rawLogParts) rawLine() []byte {
+	if p.parser == nil {
+		return nil
+	}
+	return p.parser.line
+}
+
+// deadLetterQueueSize is the number of messages waiting to be written to the
+// dead-letter destination. Messages are dropped when the queue is full, so a
+// slow destination never blocks the parsing of logs.
+const deadLetterQueueSize = 1000
+
+const (
+	deadLetterWriteTimeout = 5 * time.Second
+	deadLetterMinBackoff   = time.Second
+	deadLetterMaxBackoff   = time.Minute
+)
+
+// deadLetterWriter writes the messages bs is unable to parse, along with the
+// raw bytes received, to a file or to a separate tcp or udp destination, for
+// offline analysis. Messages are queued and written by a goroutine of its
+// own. While the destination fails, it's only dialed again after a backoff,
+// dropping the messages in between.
+type deadLetterWriter struct {
+	dial     func() (io.WriteCloser, error)
+	letters  chan []byte
+	quit     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	writer   io.WriteCloser
+	failing  bool
+	backoff  time.Duration
+	retryAt  time.Time
+	dropping int32
+	count    uint64
+	dropped  uint64
+}
+
+// newDeadLetterWriter returns a writer to the destination, a file path, a
+// file:// URL or a tcp:// or udp:// address. An empty destination disables
+// the dead-letter stream, returning nil.
+func newDeadLetterWriter(destination string) (*deadLetterWriter, error) {
+	if destination == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dead-letter destination %q: %s", destination, err)
+	}
+	var w *deadLetterWriter
+	switch u.Scheme {
+	case "", "file":
+		path := u.Path
+		w = &deadLetterWriter{dial: func() (io.WriteCloser, error) {
+			return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		}}
+	case "tcp", "udp":
+		w = &deadLetterWriter{dial: func() (io.WriteCloser, error) {
+			return net.DialTimeout(u.Scheme, u.Host, forwardConnDialTimeout)
+		}}
+	default:
+		return nil, fmt.Errorf("invalid dead-letter destination %q: unsupported scheme %q", destination, u.Scheme)
+	}
+	w.start(deadLetterQueueSize)
+	return w, nil
+}
+
+// start starts the goroutine writing the queued messages.
+func (w *deadLetterWriter) start(queueSize int) {
+	w.letters = make(chan []byte, queueSize)
+	w.quit = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.run()
+}
+
+// write queues a message that couldn't be parsed, dropping it when the queue
+// is full. It does nothing when the writer is nil.
+func (w *deadLetterWriter) write(source string, raw []byte, parseErr error) {
+	if w == nil {
+		return
+	}
+	atomic.AddUint64(&w.count, 1)
+	letter := deadLetter{Time: time.Now().UTC(), Source: source, Raw: raw}
+	if parseErr != nil {
+		letter.Error = parseErr.Error()
+	}
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	select {
+	case w.letters <- data:
+		atomic.StoreInt32(&w.dropping, 0)
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+		if atomic.CompareAndSwapInt32(&w.dropping, 0, 1) {
+			bslog.Errorf("[log forwarder] dead-letter queue full, dropping messages")
+		}
+	}
+}
+
+// run writes the queued messages until close is called, writing the ones
+// already queued before closing the destination.
+func (w *deadLetterWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case data := <-w.letters:
+			w.send(data, time.Now())
+		case <-w.quit:
+			for {
+				select {
+				case data := <-w.letters:
+					w.send(data, time.Now())
+				default:
+					if w.writer != nil {
+						w.writer.Close()
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *deadLetterWriter) send(data []byte, now time.Time) {
+	if w.writer == nil && now.Before(w.retryAt) {
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	// Connections may have been closed by the other end, so writing is
+	// retried once with a new one.
+	var err error
+	for i := 0; i < 2; i++ {
+		if w.writer == nil {
+			w.writer, err = w.dial()
+			if err != nil {
+				w.writer = nil
+				break
+			}
+		}
+		if conn, ok := w.writer.(net.Conn); ok {
+			conn.SetWriteDeadline(now.Add(deadLetterWriteTimeout))
+		}
+		_, err = w.writer.Write(data)
+		if err == nil {
+			break
+		}
+		w.writer.Close()
+		w.writer = nil
+	}
+	if err != nil {
+		atomic.AddUint64(&w.dropped, 1)
+		w.backoff *= 2
+		if w.backoff < deadLetterMinBackoff {
+			w.backoff = deadLetterMinBackoff
+		}
+		if w.backoff > deadLetterMaxBackoff {
+			w.backoff = deadLetterMaxBackoff
+		}
+		w.retryAt = now.Add(w.backoff)
+		if !w.failing {
+			bslog.Errorf("[log forwarder] unable to write dead letter, retrying in %s: %s", w.backoff, err)
+		}
+		w.failing = true
+		return
+	}
+	w.failing = false
+	w.backoff = 0
+}
+
+// close writes the messages already queued and closes the destination.
+func (w *deadLetterWriter) close() {
+	if w == nil {
+		return
+	}
+	w.once.Do(func() {
+		close(w.quit)
+	})
+	<-w.done
+}
+
+// metrics returns the number of messages sent to the dead-letter stream and
+// the number of them dropped, due to a full queue or a failing destination.
+func (w *deadLetterWriter) metrics() map[string]metric.Metric {
+	return map[string]metric.Metric{
+		"log_dead_letters":         metric.Int(int64(atomic.LoadUint64(&w.count))),
+		"log_dead_letters_dropped": metric.Int(int64(atomic.LoadUint64(&w.dropped))),
+	}
+}
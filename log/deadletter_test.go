@@ -0,0 +1,152 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+func (s *S) TestDeadLetterWriterFile(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dead.log")
+	w, err := newDeadLetterWriter("file://" + path)
+	c.Assert(err, check.IsNil)
+	w.write(deadLetterSyslog, []byte("<30>garbage\x00"), &parseError{msg: "bad"})
+	w.write(deadLetterKubernetes, []byte("{not json"), nil)
+	w.close()
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	c.Assert(lines, check.HasLen, 2)
+	var letter deadLetter
+	c.Assert(json.Unmarshal(lines[0], &letter), check.IsNil)
+	c.Assert(letter.Source, check.Equals, "syslog")
+	c.Assert(letter.Raw, check.DeepEquals, []byte("<30>garbage\x00"))
+	c.Assert(letter.Error, check.Equals, `could not parse "": bad`)
+	c.Assert(letter.Time.IsZero(), check.Equals, false)
+	letter = deadLetter{}
+	c.Assert(json.Unmarshal(lines[1], &letter), check.IsNil)
+	c.Assert(letter.Source, check.Equals, "kubernetes")
+	c.Assert(letter.Raw, check.DeepEquals, []byte("{not json"))
+	c.Assert(letter.Error, check.Equals, "")
+	c.Assert(w.metrics(), check.DeepEquals, map[string]metric.Metric{
+		"log_dead_letters":         metric.Int(2),
+		"log_dead_letters_dropped": metric.Int(0),
+	})
+}
+
+func (s *S) TestDeadLetterWriterUDP(c *check.C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	w, err := newDeadLetterWriter("udp://" + conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	defer w.close()
+	w.write(deadLetterSyslog, []byte("raw"), errInvalidMessage)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	c.Assert(err, check.IsNil)
+	var letter deadLetter
+	c.Assert(json.Unmarshal(buf[:n], &letter), check.IsNil)
+	c.Assert(letter.Raw, check.DeepEquals, []byte("raw"))
+	c.Assert(letter.Error, check.Equals, errInvalidMessage.Error())
+}
+
+// nopWriteCloser discards writes, optionally blocking them until unblocked.
+type nopWriteCloser struct {
+	block chan struct{}
+}
+
+func (w nopWriteCloser) Write(p []byte) (int, error) {
+	if w.block != nil {
+		<-w.block
+	}
+	return len(p), nil
+}
+
+func (w nopWriteCloser) Close() error {
+	return nil
+}
+
+func (s *S) TestDeadLetterWriterQueueFull(c *check.C) {
+	block := make(chan struct{})
+	w := &deadLetterWriter{dial: func() (io.WriteCloser, error) {
+		return nopWriteCloser{block: block}, nil
+	}}
+	w.start(2)
+	for i := 0; i < 10; i++ {
+		w.write(deadLetterSyslog, []byte("raw"), nil)
+	}
+	close(block)
+	w.close()
+	c.Assert(w.metrics()["log_dead_letters"], check.Equals, metric.Int(10))
+	dropped := atomic.LoadUint64(&w.dropped)
+	c.Assert(dropped >= 7 && dropped <= 8, check.Equals, true, check.Commentf("dropped %d", dropped))
+}
+
+func (s *S) TestDeadLetterWriterBackoff(c *check.C) {
+	var dials int
+	w := &deadLetterWriter{dial: func() (io.WriteCloser, error) {
+		dials++
+		if dials == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return nopWriteCloser{}, nil
+	}}
+	now := time.Now()
+	w.send([]byte("1"), now)
+	w.send([]byte("2"), now.Add(deadLetterMinBackoff/2))
+	c.Assert(dials, check.Equals, 1)
+	c.Assert(w.failing, check.Equals, true)
+	w.send([]byte("3"), now.Add(deadLetterMinBackoff))
+	c.Assert(dials, check.Equals, 2)
+	c.Assert(w.failing, check.Equals, false)
+	c.Assert(w.backoff, check.Equals, time.Duration(0))
+	c.Assert(atomic.LoadUint64(&w.dropped), check.Equals, uint64(2))
+}
+
+func (s *S) TestDeadLetterWriterDisabled(c *check.C) {
+	w, err := newDeadLetterWriter("")
+	c.Assert(err, check.IsNil)
+	c.Assert(w, check.IsNil)
+	w.write(deadLetterSyslog, []byte("raw"), nil)
+	w.close()
+}
+
+func (s *S) TestDeadLetterWriterInvalidDestination(c *check.C) {
+	_, err := newDeadLetterWriter("kafka://broker:9092")
+	c.Assert(err, check.ErrorMatches, `invalid dead-letter destination "kafka://broker:9092": unsupported scheme "kafka"`)
+}
+
+func (s *S) TestLogForwarderHandleDeadLetter(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dead.log")
+	w, err := newDeadLetterWriter(path)
+	c.Assert(err, check.IsNil)
+	lf := LogForwarder{deadLetters: w}
+	parser := (&LenientFormat{}).GetParser([]byte("<30>not a syslog line"))
+	err = parser.Parse()
+	c.Assert(err, check.NotNil)
+	lf.Handle(parser.Dump(), 0, err)
+	lf.Handle(format.LogParts{"parts": &rawLogParts{content: []byte("no parser")}}, 0, nil)
+	w.close()
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	var letter deadLetter
+	c.Assert(json.Unmarshal(data, &letter), check.IsNil)
+	c.Assert(letter.Source, check.Equals, "syslog")
+	c.Assert(letter.Raw, check.DeepEquals, []byte("<30>not a syslog line"))
+	c.Assert(lf.HostMetrics()["log_dead_letters"], check.Equals, metric.Int(1))
+}
@@ -8,15 +8,28 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"sync"
 	"time"
 
 	"gopkg.in/mcuadros/go-syslog.v2/format"
 )
 
+// parserPool holds the parsers used by LenientFormat. Parsers are returned to
+// the pool by the handler, through rawLogParts.release, once the message was
+// sent to every backend, so no allocation is needed for parsing under load.
+var parserPool = sync.Pool{
+	New: func() interface{} {
+		return &LenientParser{}
+	},
+}
+
 type LenientFormat struct{}
 
 func (f *LenientFormat) GetParser(line []byte) format.LogParser {
-	return &LenientParser{line: line}
+	p := parserPool.Get().(*LenientParser)
+	p.line = line
+	p.parts.parser = p
+	return p
 }
 
 func (f *LenientFormat) GetSplitFunc() bufio.SplitFunc {
@@ -28,15 +41,51 @@ type rawLogParts struct {
 	priority  []byte
 	content   []byte
 	container []byte
+	access    *accessLog
+	traceID   []byte
+	spanID    []byte
+	class     logClass
+	retention string
+	received  int64
+	sequence  uint64
+	piece     int
+	pieces    int
+	stream    logStream
+	shardKey  string
+	parser    *LenientParser
 }
 
 func (p *rawLogParts) String() string {
 	return fmt.Sprintf("{log entry: %v %q %q %q}", p.ts, string(p.priority), string(p.content), string(p.container))
 }
 
+// key returns the key routing p to a shard of each destination: the ID of its
+// container, so the lines of a container are always forwarded in order
+// through the same connection, even when it shares the hostname with other
+// containers, like the ones in the host network. Host messages, without a
+// container, are routed by unit.
+func (p *rawLogParts) key(unit string) string {
+	if p.shardKey != "" {
+		return p.shardKey
+	}
+	return unit
+}
+
+// release returns the parser that produced p to the pool. Neither p nor any of
+// its byte slices may be used after calling release.
+func (p *rawLogParts) release() {
+	lp := p.parser
+	if lp == nil {
+		return
+	}
+	*lp = LenientParser{}
+	parserPool.Put(lp)
+}
+
 type LenientParser struct {
-	line  []byte
-	parts rawLogParts
+	line   []byte
+	groups [7][]byte
+	parts  rawLogParts
 }
 
 type parseError struct {
@@ -49,19 +98,17 @@ func (e *parseError) Error() string {
 }
 
 func (p *LenientParser) Parse() error {
-	groups := parseLogLine(p.line)
-	if len(groups) != 7 {
-		return &parseError{line: p.line, msg: "invalid groups length"}
-	}
+	p.parts = rawLogParts{parser: p.parts.parser}
+	parseLogLine(p.line, &p.groups)
+	groups := &p.groups
 	var err error
 	if len(groups[2]) == 0 {
-		p.parts.ts, err = time.Parse(time.RFC3339, string(groups[1]))
+		p.parts.ts, err = parseRFC3339(groups[1])
 		if err != nil {
 			return &parseError{line: p.line, msg: "unable to parse time as RFC3339"}
 		}
 	} else {
-		dt := string(bytes.Join(groups[1:3], []byte{' '}))
-		p.parts.ts, err = time.ParseInLocation(time.Stamp, dt, time.Local)
+		p.parts.ts, err = parseStamp(groups[1], groups[2], time.Local)
 		if err != nil {
 			return &parseError{line: p.line, msg: "unable to parse time as Stamp"}
 		}
@@ -83,3 +130,93 @@ func (p *LenientParser) Location(*time.Location) {
 func (p *LenientParser) Dump() format.LogParts {
 	return format.LogParts{"parts": &p.parts}
 }
+
+// parseRFC3339 parses UTC timestamps, like the ones sent by Docker, without
+// allocating. Anything else is handed to time.Parse.
+func parseRFC3339(b []byte) (time.Time, error) {
+	if len(b) < 20 || b[4] != '-' || b[7] != '-' || b[10] != 'T' || b[13] != ':' || b[16] != ':' || b[len(b)-1] != 'Z' {
+		return time.Parse(time.RFC3339, string(b))
+	}
+	year, ok1 := atoiFixed(b[0:4])
+	month, ok2 := atoiFixed(b[5:7])
+	day, ok3 := atoiFixed(b[8:10])
+	hour, ok4 := atoiFixed(b[11:13])
+	min, ok5 := atoiFixed(b[14:16])
+	sec, ok6 := atoiFixed(b[17:19])
+	var nsec int
+	ok7 := true
+	if frac := b[19 : len(b)-1]; len(frac) > 0 {
+		ok7 = len(frac) >= 2 && len(frac) <= 10 && frac[0] == '.'
+		if ok7 {
+			nsec, ok7 = atoiFixed(frac[1:])
+			for i := len(frac) - 1; i < 9; i++ {
+				nsec *= 10
+			}
+		}
+	}
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && ok7) || !validDate(year, month, day, hour, min, sec) {
+		return time.Parse(time.RFC3339, string(b))
+	}
+	return time.Date(year, time.Month(month), day, hour, min, sec, nsec, time.UTC), nil
+}
+
+var stampMonths = [...]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}
+
+// parseStamp parses a time.Stamp timestamp, split by the line parser in the
+// month and the remaining "_2 15:04:05", without allocating. The resulting
+// time has year zero, just like time.ParseInLocation would return. Anything
+// unexpected is handed to time.ParseInLocation.
+func parseStamp(monthName, rest []byte, loc *time.Location) (time.Time, error) {
+	month := 0
+	for i, name := range stampMonths {
+		if string(monthName) == name {
+			month = i + 1
+			break
+		}
+	}
+	sp := bytes.IndexByte(rest, ' ')
+	ok := month != 0 && sp > 0 && sp <= 2 && len(rest)-sp-1 >= 7 && len(rest)-sp-1 <= 8
+	var day, hour, min, sec int
+	if ok {
+		clock := rest[sp+1:]
+		hl := len(clock) - 6
+		var ok1, ok2, ok3, ok4 bool
+		day, ok1 = atoiFixed(rest[:sp])
+		hour, ok2 = atoiFixed(clock[:hl])
+		min, ok3 = atoiFixed(clock[hl+1 : hl+3])
+		sec, ok4 = atoiFixed(clock[hl+4:])
+		ok = ok1 && ok2 && ok3 && ok4 && clock[hl] == ':' && clock[hl+3] == ':' && validDate(0, month, day, hour, min, sec)
+	}
+	if !ok {
+		dt := string(bytes.Join([][]byte{monthName, rest}, []byte{' '}))
+		return time.ParseInLocation(time.Stamp, dt, loc)
+	}
+	return time.Date(0, time.Month(month), day, hour, min, sec, 0, loc), nil
+}
+
+func atoiFixed(b []byte) (int, bool) {
+	if len(b) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+var daysInMonth = [...]int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+func validDate(year, month, day, hour, min, sec int) bool {
+	if month < 1 || month > 12 || day < 1 || hour > 23 || min > 59 || sec > 59 {
+		return false
+	}
+	days := daysInMonth[month-1]
+	if month == 2 && year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+		days = 29
+	}
+	return day <= days
+}
@@ -16,7 +16,69 @@ func (s *S) TestLenientFormatGetParser(c *check.C) {
 	lf := LenientFormat{}
 	line := []byte("abc")
 	parser := lf.GetParser(line)
-	c.Assert(parser, check.DeepEquals, &LenientParser{line: line})
+	lp, ok := parser.(*LenientParser)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(lp.line, check.DeepEquals, line)
+	c.Assert(lp.parts.parser, check.Equals, lp)
+}
+
+func (s *S) TestLenientFormatParserReuse(c *check.C) {
+	lf := LenientFormat{}
+	parser := lf.GetParser([]byte("<30>2015-06-05T16:13:47Z vagrant-ubuntu-trusty-64 docker/00dfa98fe8e0[4843]: hey"))
+	c.Assert(parser.Parse(), check.IsNil)
+	parts := parser.Dump()["parts"].(*rawLogParts)
+	c.Assert(string(parts.content), check.Equals, "hey")
+	parts.release()
+	c.Assert(parser.(*LenientParser).line, check.IsNil)
+	parser = lf.GetParser([]byte("<30>invalid"))
+	c.Assert(parser.Parse(), check.NotNil)
+	parts = parser.Dump()["parts"].(*rawLogParts)
+	c.Assert(parts.content, check.IsNil)
+	c.Assert(parts.container, check.IsNil)
+	c.Assert(parts.ts.IsZero(), check.Equals, true)
+	parts.release()
+}
+
+func (s *S) TestParseRFC3339(c *check.C) {
+	examples := []string{
+		"2015-06-05T16:13:47Z",
+		"2015-06-05T16:13:47.1Z",
+		"2015-06-05T16:13:47.123456Z",
+		"2015-06-05T16:13:47.123456789Z",
+		"2015-06-05T16:13:47-03:00",
+		"2015-06-05T16:13:47.5+02:00",
+		"2016-02-29T00:00:00Z",
+		"2015-02-29T00:00:00Z",
+		"2015-06-05T24:13:47Z",
+		"2015-06-05T16:13:47.Z",
+		"2015-06-05 16:13:47Z",
+		"invalid",
+	}
+	for _, ex := range examples {
+		expected, expectedErr := time.Parse(time.RFC3339, ex)
+		t, err := parseRFC3339([]byte(ex))
+		c.Check(err != nil, check.Equals, expectedErr != nil, check.Commentf("%s", ex))
+		c.Check(t.Equal(expected), check.Equals, true, check.Commentf("%s: %v != %v", ex, t, expected))
+	}
+}
+
+func (s *S) TestParseStamp(c *check.C) {
+	examples := [][2]string{
+		{"May", "13 21:10:17"},
+		{"Dec", "6 05:08:46"},
+		{"Jul", "1 8:26:01"},
+		{"Feb", "29 00:00:00"},
+		{"Feb", "30 00:00:00"},
+		{"Jun", "13 21:60:17"},
+		{"Abc", "13 21:10:17"},
+		{"May", "13 21:10"},
+	}
+	for _, ex := range examples {
+		expected, expectedErr := time.ParseInLocation(time.Stamp, ex[0]+" "+ex[1], time.Local)
+		t, err := parseStamp([]byte(ex[0]), []byte(ex[1]), time.Local)
+		c.Check(err != nil, check.Equals, expectedErr != nil, check.Commentf("%v", ex))
+		c.Check(t.Equal(expected), check.Equals, true, check.Commentf("%v: %v != %v", ex, t, expected))
+	}
 }
 
 func (s *S) TestLenientFormatGetSplitFunc(c *check.C) {
@@ -27,6 +89,7 @@ func (s *S) TestLenientFormatGetSplitFunc(c *check.C) {
 
 func BenchmarkLenientParserParse(b *testing.B) {
 	logLine := []byte("<30>2015-06-05T16:13:47Z vagrant-ubuntu-trusty-64 docker/00dfa98fe8e0[4843]: hey")
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		lp := LenientParser{line: logLine}
@@ -36,6 +99,7 @@ func BenchmarkLenientParserParse(b *testing.B) {
 
 func BenchmarkLenientParserParseNewFormat(b *testing.B) {
 	logLine := []byte("<30> May 13 21:10:17 vagrant-ubuntu-trusty-64 docker/00dfa98fe8e0[10798]: hey")
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		lp := LenientParser{line: logLine}
@@ -45,6 +109,7 @@ func BenchmarkLenientParserParseNewFormat(b *testing.B) {
 
 func BenchmarkLenientParserParseUnixFormat(b *testing.B) {
 	logLine := []byte("<30>May 13 21:10:17 docker/00dfa98fe8e0[10798]: hey")
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		lp := LenientParser{line: logLine}
@@ -52,6 +117,34 @@ func BenchmarkLenientParserParseUnixFormat(b *testing.B) {
 	}
 }
 
+func BenchmarkLenientFormat(b *testing.B) {
+	logLine := []byte("<30>2015-06-05T16:13:47Z vagrant-ubuntu-trusty-64 docker/00dfa98fe8e0[4843]: hey")
+	var lf LenientFormat
+	b.ReportAllocs()
+	b.SetBytes(int64(len(logLine)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parser := lf.GetParser(logLine)
+		parser.Parse()
+		parser.Dump()["parts"].(*rawLogParts).release()
+	}
+}
+
+func BenchmarkLenientFormatParallel(b *testing.B) {
+	logLine := []byte("<30> May 13 21:10:17 vagrant-ubuntu-trusty-64 docker/00dfa98fe8e0[10798]: hey")
+	var lf LenientFormat
+	b.ReportAllocs()
+	b.SetBytes(int64(len(logLine)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			parser := lf.GetParser(logLine)
+			parser.Parse()
+			parser.Dump()["parts"].(*rawLogParts).release()
+		}
+	})
+}
+
 func (s *S) TestLenientParserParse(c *check.C) {
 	examples := []string{
 		"<27>Jul 21 18:26:01 docker/091cafae73a9[927]: ",
@@ -0,0 +1,248 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var fuzzLines = []string{
+	"<30>2015-06-05T16:13:47Z myhost docker/00c4cbe6a7dd: mymsg",
+	"<30>2015-06-05T16:13:47.123456789Z myhost docker/00c4cbe6a7dd[1234]: mymsg",
+	"<30>Jun  5 16:13:47 myhost docker/00c4cbe6a7dd[1234]: mymsg",
+	"<30>Jun 15 16:13:47 docker/00c4cbe6a7dd: ",
+	"<30>2015-06-05T16:13:47-03:00 myhost docker/00c4cbe6a7dd: mymsg",
+	"<30>",
+	"",
+}
+
+func FuzzLenientFormat(f *testing.F) {
+	for _, line := range fuzzLines {
+		f.Add([]byte(line))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		original := append([]byte(nil), data...)
+		p := (&LenientFormat{}).GetParser(data).(*LenientParser)
+		err := p.Parse()
+		parts := p.Dump()["parts"].(*rawLogParts)
+		if err == nil && parts.ts.IsZero() && len(parts.priority) > 0 {
+			t.Errorf("zero time without error parsing %q", data)
+		}
+		parts.release()
+		if !bytes.Equal(data, original) {
+			t.Errorf("parser modified its input: %q", original)
+		}
+	})
+}
+
+func FuzzParseRFC3339(f *testing.F) {
+	for _, ts := range []string{"2015-06-05T16:13:47Z", "2015-06-05T16:13:47.123Z", "2016-02-29T23:59:59.999999999Z", "2015-06-05T16:13:47+03:00"} {
+		f.Add(ts)
+	}
+	f.Fuzz(func(t *testing.T, ts string) {
+		got, err := parseRFC3339([]byte(ts))
+		expected, expectedErr := time.Parse(time.RFC3339, ts)
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("%q: got error %v, time.Parse error %v", ts, err, expectedErr)
+		}
+		if err == nil && !got.Equal(expected) {
+			t.Fatalf("%q: got %v, time.Parse got %v", ts, got, expected)
+		}
+	})
+}
+
+func FuzzParseStamp(f *testing.F) {
+	for _, ts := range []string{"Jun  5 16:13:47", "Dec 31 23:59:59", "Feb 29 00:00:00", "Jan 1 1:02:03"} {
+		f.Add(ts)
+	}
+	f.Fuzz(func(t *testing.T, ts string) {
+		idx := bytes.IndexByte([]byte(ts), ' ')
+		if idx == -1 {
+			return
+		}
+		got, err := parseStamp([]byte(ts[:idx]), []byte(ts[idx+1:]), time.UTC)
+		expected, expectedErr := time.ParseInLocation(time.Stamp, ts, time.UTC)
+		if (err == nil) != (expectedErr == nil) {
+			t.Fatalf("%q: got error %v, time.ParseInLocation error %v", ts, err, expectedErr)
+		}
+		if err == nil && !got.Equal(expected) {
+			t.Fatalf("%q: got %v, time.ParseInLocation got %v", ts, got, expected)
+		}
+	})
+}
+
+func FuzzInferSeverity(f *testing.F) {
+	for _, line := range []string{`{"level":"error","msg":"x"}`, `{"severity" : "warn"}`, "level=info msg=x", `msg="a b" level="debug"`, "ERROR x", `{"level":"`} {
+		f.Add([]byte(line))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if sev, ok := inferSeverity(data); ok && (sev < 0 || sev > 7) {
+			t.Fatalf("invalid severity %d for %q", sev, data)
+		}
+		for _, key := range severityKeys {
+			if v := jsonStringField(data, key); v != nil && !bytes.Contains(data, v) {
+				t.Fatalf("json value %q not in %q", v, data)
+			}
+			if v := logfmtField(data, key); v != nil && !bytes.Contains(data, v) {
+				t.Fatalf("logfmt value %q not in %q", v, data)
+			}
+		}
+	})
+}
+
+func FuzzSanitize(f *testing.F) {
+	for _, line := range []string{"\x1b[31mred\x1b[0m", "\x1b]0;title\x07text", "a\xffb", "tab\there\r\n", "\x1b", "\x1b[", "\x1b]"} {
+		f.Add([]byte(line))
+	}
+	s := &sanitizer{stripANSI: true, fixUTF8: true, escapeControl: true}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := s.sanitize(data)
+		if !utf8.Valid(out) {
+			t.Fatalf("invalid utf-8 after sanitizing %q: %q", data, out)
+		}
+		for _, c := range out {
+			if c < 0x20 && c != '\t' || c == 0x7f {
+				t.Fatalf("control character left after sanitizing %q: %q", data, out)
+			}
+		}
+		if again := s.sanitize(out); !bytes.Equal(again, out) {
+			t.Fatalf("sanitizing is not idempotent for %q: %q, then %q", data, out, again)
+		}
+	})
+}
+
+func FuzzLineLimitSplit(f *testing.F) {
+	f.Add([]byte("héllo wörld"), 3)
+	f.Add([]byte("abc"), 1)
+	f.Fuzz(func(t *testing.T, data []byte, size int) {
+		if size < 1 || size > 1024 {
+			return
+		}
+		if len(data) <= size {
+			return
+		}
+		l := &lineLimit{size: size, policy: linePolicySplit}
+		var joined []byte
+		l.apply(&rawLogParts{content: data}, func(p *rawLogParts) {
+			if len(p.content) == 0 || len(p.content) > size {
+				t.Fatalf("invalid piece %q of %q with size %d", p.content, data, size)
+			}
+			joined = append(joined, p.content...)
+		})
+		if !bytes.Equal(joined, data) {
+			t.Fatalf("split pieces %q don't match %q", joined, data)
+		}
+	})
+}
+
+func FuzzAccessLog(f *testing.F) {
+	for _, line := range []string{
+		`127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET /a HTTP/1.0" 200 2326 "-" "curl" 0.1`,
+		`1 - - [x] "GET /" 500 - rt=1`,
+		`a [b] "c d" 200 1 " `,
+	} {
+		f.Add([]byte(line))
+	}
+	fields := []string{"date", "time", "cs-method", "cs-uri-stem", "sc-status", "time-taken"}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, parse := range []func([]byte) (*accessLog, bool){
+			parseCommonLog,
+			func(b []byte) (*accessLog, bool) { return parseW3CLog(b, fields) },
+		} {
+			a, ok := parse(data)
+			if ok && (a.status < 100 || a.status > 599 || a.method == "") {
+				t.Fatalf("invalid access log %#v for %q", a, data)
+			}
+		}
+	})
+}
+
+func FuzzExtractTraceContext(f *testing.F) {
+	for _, line := range []string{
+		"traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"X-B3-TraceId: 463ac35c9f6413ad X-B3-SpanId: a2fb4a1d1a96d312",
+		`{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"}`,
+		"traceparent=",
+	} {
+		f.Add([]byte(line))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		traceID, spanID := extractTraceContext(data)
+		if traceID == nil && spanID != nil {
+			t.Fatalf("span without trace in %q", data)
+		}
+		if traceID != nil && (len(traceID) != 16 && len(traceID) != 32 || !isHex(traceID)) {
+			t.Fatalf("invalid trace id %q in %q", traceID, data)
+		}
+		if spanID != nil && (len(spanID) != 16 || !isHex(spanID)) {
+			t.Fatalf("invalid span id %q in %q", spanID, data)
+		}
+	})
+}
+
+func FuzzParseLogCounters(f *testing.F) {
+	f.Add(`{"errors": "ERROR", "timeouts": "(?i)timeout"}`)
+	f.Add(`{"a": "(", "b": "x"}`)
+	f.Add(`[]`)
+	f.Fuzz(func(t *testing.T, data string) {
+		counters, err := parseLogCounters(data)
+		if err != nil {
+			return
+		}
+		for _, counter := range counters {
+			if counter.regexp == nil {
+				t.Fatalf("nil regexp for counter %q in %q", counter.name, data)
+			}
+		}
+	})
+}
+
+func FuzzParseKmsgRecord(f *testing.F) {
+	f.Add([]byte("6,1234,5678,-;Out of memory: Kill process 1234 (java)\n"))
+	f.Add([]byte("3,1,2,c,more;EXT4-fs error"))
+	f.Add([]byte(";"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		record, err := parseKmsgRecord(data)
+		if err == nil && (record.priority < 0 || record.priority > maxPriority) {
+			t.Fatalf("invalid priority %d in %q", record.priority, data)
+		}
+	})
+}
+
+func FuzzScanLimitedLines(f *testing.F) {
+	f.Add([]byte("short\na much longer line\nend"), 8)
+	f.Fuzz(func(t *testing.T, data []byte, max int) {
+		if max < 1 || max > 1024 {
+			return
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, max), max)
+		scanner.Split(scanLimitedLines(max))
+		for scanner.Scan() {
+			if len(scanner.Bytes()) > max {
+				t.Fatalf("line %q over %d bytes", scanner.Bytes(), max)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatalf("scanning %q with max %d: %s", data, max, err)
+		}
+	})
+}
+
+func FuzzParseLogFileLine(f *testing.F) {
+	f.Add([]byte(`{"log":"msg1\n","stream":"stderr","time":"2017-03-21T21:28:22.0Z"}` + "\n"))
+	f.Add([]byte(`{"log":"\u00e9\"","stream":"stdout"}`))
+	f.Add([]byte(`{"log":null}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseLogFileLine(data)
+	})
+}
@@ -12,22 +12,39 @@ import (
 	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/tsuru/bs/audit"
 	"github.com/tsuru/bs/bslog"
 	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/node"
 )
 
+const defaultGelfHost = "localhost:12201"
+
+var defaultGelfFieldsWhitelist = []string{
+	"request_id",
+	"request_time",
+	"request_uri",
+	"status",
+	"method",
+	"uri",
+}
+
 type gelfBackend struct {
 	extra           json.RawMessage
 	host            string
 	fieldsWhitelist []string
-	msgCh           chan<- LogMessage
-	quitCh          chan<- bool
+	queue           *messageQueue
 	nextNotify      *time.Timer
+	nodeMetadata    *node.MetadataCache
+	extraTags       map[string]string
+	streamField     bool
 }
 
 func (b *gelfBackend) initialize() error {
 	bufferSize := config.IntEnvOrDefault(config.DefaultBufferSize, "LOG_GELF_BUFFER_SIZE", "LOG_BUFFER_SIZE")
-	b.host = config.StringEnvOrDefault("localhost:12201", "LOG_GELF_HOST")
+	shards := config.IntEnvOrDefault(defaultForwardShards, "LOG_GELF_FORWARD_SHARDS", "LOG_FORWARD_SHARDS")
+	maxBytesPerSecond := config.IntEnvOrDefault(0, "LOG_GELF_MAX_BYTES_PER_SECOND", "LOG_MAX_BYTES_PER_SECOND")
+	b.host = config.StringEnvOrDefault(defaultGelfHost, "LOG_GELF_HOST")
 	extra := config.StringEnvOrDefault("", "LOG_GELF_EXTRA_TAGS")
 	if extra != "" {
 		data := map[string]interface{}{}
@@ -37,20 +54,13 @@ func (b *gelfBackend) initialize() error {
 			b.extra = json.RawMessage(extra)
 		}
 	}
-	b.fieldsWhitelist = config.StringsEnvOrDefault([]string{
-		"request_id",
-		"request_time",
-		"request_uri",
-		"status",
-		"method",
-		"uri",
-	}, "LOG_GELF_FIELDS_WHITELIST")
+	b.extraTags = config.TagsEnvOrDefault(nil, "METRICS_EXTRA_TAGS")
+	b.fieldsWhitelist = config.StringsEnvOrDefault(defaultGelfFieldsWhitelist, "LOG_GELF_FIELDS_WHITELIST")
+	b.streamField = config.BoolEnvOrDefault(false, "LOG_STREAM_FIELD")
 	b.nextNotify = time.NewTimer(0)
-	var err error
-	b.msgCh, b.quitCh, err = processMessages(b, bufferSize)
-	if err != nil {
-		return err
-	}
+	b.queue = newMessageQueue(func() forwarderBackend {
+		return b
+	}, shards, bufferSize, maxBytesPerSecond)
 	return nil
 }
 
@@ -72,19 +82,66 @@ func (b *gelfBackend) sendMessage(parts *rawLogParts, appName, processName, cont
 		},
 		RawExtra: b.extra,
 	}
-	select {
-	case b.msgCh <- msg:
-	default:
+	if metadata := b.nodeMetadata.Get(); metadata.Address != "" {
+		msg.Extra["_pool"] = metadata.Pool
+		msg.Extra["_node"] = metadata.Address
+	}
+	if parts.retention != "" {
+		msg.Extra["_retention"] = parts.retention
+	}
+	if b.streamField && parts.stream != streamUnknown {
+		msg.Extra["_stream"] = parts.stream.String()
+	}
+	if parts.received != 0 {
+		msg.Extra["_received_ns"] = parts.received
+	}
+	if parts.sequence != 0 {
+		msg.Extra["_sequence"] = parts.sequence
+		if parts.pieces > 0 {
+			msg.Extra["_piece"] = parts.piece
+			msg.Extra["_pieces"] = parts.pieces
+		}
+	}
+	if parts.traceID != nil {
+		msg.Extra["_trace_id"] = string(parts.traceID)
+		if parts.spanID != nil {
+			msg.Extra["_span_id"] = string(parts.spanID)
+		}
+	}
+	if a := parts.access; a != nil {
+		msg.Extra["_http_method"] = a.method
+		msg.Extra["_http_path"] = a.path
+		msg.Extra["_http_status"] = a.status
+		msg.Extra["_http_bytes"] = a.bytes
+		if a.latency >= 0 {
+			msg.Extra["_http_latency"] = a.latency
+		}
+	}
+	for k, v := range b.extraTags {
+		if _, ok := msg.Extra["_"+k]; !ok {
+			msg.Extra["_"+k] = v
+		}
+	}
+	if !b.queue.send(parts.key(container), msg, parts.class) {
 		select {
 		case <-b.nextNotify.C:
 			bslog.Errorf("Dropping log messages to gelf due to full channel buffer.")
+			audit.Record("log", "dropped %d messages to gelf due to full channel buffer", b.queue.takeDropped())
 			b.nextNotify.Reset(time.Minute)
 		default:
 		}
 	}
 }
+func (b *gelfBackend) setNodeMetadata(metadata *node.MetadataCache) {
+	b.nodeMetadata = metadata
+}
+
 func (b *gelfBackend) stop() {
-	close(b.quitCh)
+	b.queue.stop()
+}
+
+func (b *gelfBackend) messageQueues() []*messageQueue {
+	return []*messageQueue{b.queue}
 }
 
 type gelfConnWrapper struct {
@@ -100,6 +157,10 @@ func (w *gelfConnWrapper) Write(msg []byte) (int, error) {
 	return 0, nil
 }
 
+func (b *gelfBackend) String() string {
+	return "gelf " + b.host
+}
+
 func (b *gelfBackend) connect() (net.Conn, error) {
 	writer, err := gelf.NewWriter(b.host)
 	if err != nil {
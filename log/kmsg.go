@@ -0,0 +1,205 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+)
+
+const (
+	kernelEventOOM      = "oom"
+	kernelEventFSError  = "fs_error"
+	kernelEventNICReset = "nic_reset"
+
+	kernelAppName = "kernel"
+)
+
+var kernelEventPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	{kernelEventOOM, regexp.MustCompile(`Out of memory|Memory cgroup out of memory|oom-kill:|invoked oom-killer`)},
+	{kernelEventFSError, regexp.MustCompile(`EXT4-fs (error|warning)|EXT4-fs \(\S+\): Remounting filesystem read-only|XFS \(\S+\): .*([Cc]orruption|I/O error|[Ss]hutting down|[Ff]ilesystem has been shut down)`)},
+	{kernelEventNICReset, regexp.MustCompile(`NETDEV WATCHDOG|[Rr]eset adapter|NIC Link is Down|[Tt]x [Uu]nit [Hh]ang|[Rr]esetting the adapter`)},
+}
+
+var errInvalidKmsgRecord = errors.New("invalid kmsg record")
+
+type kmsgRecord struct {
+	priority int
+	seq      uint64
+	message  string
+}
+
+// parseKmsgRecord parses a record in the /dev/kmsg format:
+// "<priority>,<sequence>,<timestamp>,<flags>[,...];<message>".
+func parseKmsgRecord(line []byte) (kmsgRecord, error) {
+	idx := bytes.IndexByte(line, ';')
+	if idx == -1 {
+		return kmsgRecord{}, errInvalidKmsgRecord
+	}
+	fields := bytes.Split(line[:idx], []byte{','})
+	if len(fields) < 3 {
+		return kmsgRecord{}, errInvalidKmsgRecord
+	}
+	priority, err := strconv.Atoi(string(fields[0]))
+	if err != nil || priority < 0 || priority > maxPriority {
+		return kmsgRecord{}, errInvalidKmsgRecord
+	}
+	seq, err := strconv.ParseUint(string(fields[1]), 10, 64)
+	if err != nil {
+		return kmsgRecord{}, errInvalidKmsgRecord
+	}
+	return kmsgRecord{
+		priority: priority,
+		seq:      seq,
+		message:  string(bytes.TrimRight(line[idx+1:], "\n")),
+	}, nil
+}
+
+func classifyKernelMessage(message string) string {
+	for _, p := range kernelEventPatterns {
+		if p.pattern.MatchString(message) {
+			return p.kind
+		}
+	}
+	return ""
+}
+
+// kmsgReader reads kernel messages from /dev/kmsg looking for OOM killer
+// invocations, filesystem errors and NIC resets. Events found are forwarded
+// to the log backends and counted to be reported as host metrics.
+type kmsgReader struct {
+	path     string
+	hostname string
+	send     func(parts *rawLogParts, appName, processName, hostname string)
+	file     *os.File
+	mu       sync.Mutex
+	counters map[string]int64
+	done     chan struct{}
+}
+
+func newKmsgReader(path string, send func(*rawLogParts, string, string, string)) *kmsgReader {
+	hostname, _ := os.Hostname()
+	return &kmsgReader{
+		path:     path,
+		hostname: hostname,
+		send:     send,
+		counters: map[string]int64{
+			kernelEventOOM:      0,
+			kernelEventFSError:  0,
+			kernelEventNICReset: 0,
+		},
+		done: make(chan struct{}),
+	}
+}
+
+// start opens the kernel log and starts reading it. Messages logged before
+// start are skipped when seekEnd is true.
+func (r *kmsgReader) start(seekEnd bool) error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	if seekEnd {
+		_, err = f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	r.file = f
+	go r.read()
+	return nil
+}
+
+func (r *kmsgReader) stop() {
+	r.file.Close()
+	<-r.done
+}
+
+func (r *kmsgReader) read() {
+	defer close(r.done)
+	// Each read from /dev/kmsg returns a single record, the buffer must be
+	// large enough to hold the largest one.
+	reader := bufio.NewReaderSize(r.file, 8192)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			if isKmsgOverwritten(err) {
+				bslog.Warnf("[kmsg] kernel messages were overwritten before being read")
+				continue
+			}
+			if err != io.EOF && !isClosedFileErr(err) {
+				bslog.Errorf("[kmsg] error reading %s: %s", r.path, err)
+			}
+			return
+		}
+		// Lines starting with a space hold the record dictionary.
+		if len(line) == 0 || line[0] == ' ' {
+			continue
+		}
+		record, err := parseKmsgRecord(line)
+		if err != nil {
+			bslog.Debugf("[kmsg] ignoring %q: %s", line, err)
+			continue
+		}
+		r.handle(record)
+	}
+}
+
+func (r *kmsgReader) handle(record kmsgRecord) {
+	kind := classifyKernelMessage(record.message)
+	if kind == "" {
+		return
+	}
+	r.mu.Lock()
+	r.counters[kind]++
+	r.mu.Unlock()
+	r.send(&rawLogParts{
+		ts:       time.Now(),
+		priority: []byte(strconv.Itoa(record.priority)),
+		content:  []byte(fmt.Sprintf("kind=%s %s", kind, record.message)),
+	}, kernelAppName, kind, r.hostname)
+}
+
+// metrics returns the number of events of each kind found since the reader
+// was started.
+func (r *kmsgReader) metrics() map[string]metric.Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	metrics := make(map[string]metric.Metric, len(r.counters))
+	for kind, count := range r.counters {
+		metrics["kernel_"+kind] = metric.Int(int64(count))
+	}
+	return metrics
+}
+
+func isKmsgOverwritten(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		return pathErr.Err == syscall.EPIPE
+	}
+	return false
+}
+
+func isClosedFileErr(err error) bool {
+	if pathErr, ok := err.(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == os.ErrClosed
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseKmsgRecord(c *check.C) {
+	record, err := parseKmsgRecord([]byte("6,339,5140900,-;NET: Registered protocol family 10\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(record, check.DeepEquals, kmsgRecord{priority: 6, seq: 339, message: "NET: Registered protocol family 10"})
+	record, err = parseKmsgRecord([]byte("3,340,5141000,c,more;msg;with;semicolons"))
+	c.Assert(err, check.IsNil)
+	c.Assert(record.message, check.Equals, "msg;with;semicolons")
+	_, err = parseKmsgRecord([]byte("no separator"))
+	c.Assert(err, check.Equals, errInvalidKmsgRecord)
+	_, err = parseKmsgRecord([]byte("x,1,2,-;msg"))
+	c.Assert(err, check.Equals, errInvalidKmsgRecord)
+	_, err = parseKmsgRecord([]byte("6,1;msg"))
+	c.Assert(err, check.Equals, errInvalidKmsgRecord)
+	_, err = parseKmsgRecord([]byte("-1,1,2,-;msg"))
+	c.Assert(err, check.Equals, errInvalidKmsgRecord)
+	_, err = parseKmsgRecord([]byte("192,1,2,-;msg"))
+	c.Assert(err, check.Equals, errInvalidKmsgRecord)
+}
+
+func (s *S) TestClassifyKernelMessage(c *check.C) {
+	tests := []struct {
+		message string
+		kind    string
+	}{
+		{"Out of memory: Kill process 1234 (java) score 900 or sacrifice child", kernelEventOOM},
+		{"Memory cgroup out of memory: Kill process 4321 (node) score 1000 or sacrifice child", kernelEventOOM},
+		{"java invoked oom-killer: gfp_mask=0x24000c0, order=0, oom_score_adj=0", kernelEventOOM},
+		{"EXT4-fs error (device sda1): ext4_lookup:1602: inode #1234: comm ls: deleted inode referenced: 5678", kernelEventFSError},
+		{"EXT4-fs (sda1): Remounting filesystem read-only", kernelEventFSError},
+		{"XFS (dm-0): Corruption detected. Unmount and run xfs_repair", kernelEventFSError},
+		{"NETDEV WATCHDOG: eth0 (ixgbe): transmit queue 3 timed out", kernelEventNICReset},
+		{"ixgbe 0000:01:00.0 eth0: Reset adapter", kernelEventNICReset},
+		{"e1000e: eth0 NIC Link is Down", kernelEventNICReset},
+		{"docker0: port 1(veth1234) entered forwarding state", ""},
+	}
+	for _, tt := range tests {
+		c.Check(classifyKernelMessage(tt.message), check.Equals, tt.kind, check.Commentf("message: %q", tt.message))
+	}
+}
+
+func (s *S) TestKmsgReader(c *check.C) {
+	path := filepath.Join(c.MkDir(), "kmsg")
+	data := "6,1,100,-;docker0: port 1(veth1234) entered forwarding state\n" +
+		"3,2,200,-;Out of memory: Kill process 1234 (java) score 900 or sacrifice child\n" +
+		" SUBSYSTEM=memory\n" +
+		"3,3,300,-;EXT4-fs error (device sda1): ext4_find_entry:1465: inode #2: comm ls: reading directory lblock 0\n" +
+		"3,4,400,-;Memory cgroup out of memory: Kill process 4321 (node) score 1000 or sacrifice child\n"
+	err := ioutil.WriteFile(path, []byte(data), 0644)
+	c.Assert(err, check.IsNil)
+	var mu sync.Mutex
+	var sent []string
+	reader := newKmsgReader(path, func(parts *rawLogParts, appName, processName, hostname string) {
+		mu.Lock()
+		defer mu.Unlock()
+		c.Check(appName, check.Equals, kernelAppName)
+		c.Check(string(parts.priority), check.Equals, "3")
+		sent = append(sent, processName+" "+string(parts.content))
+	})
+	err = reader.start(false)
+	c.Assert(err, check.IsNil)
+	<-reader.done
+	c.Assert(sent, check.DeepEquals, []string{
+		"oom kind=oom Out of memory: Kill process 1234 (java) score 900 or sacrifice child",
+		"fs_error kind=fs_error EXT4-fs error (device sda1): ext4_find_entry:1465: inode #2: comm ls: reading directory lblock 0",
+		"oom kind=oom Memory cgroup out of memory: Kill process 4321 (node) score 1000 or sacrifice child",
+	})
+	c.Assert(reader.metrics(), check.DeepEquals, map[string]metric.Metric{
+		"kernel_oom":       metric.Int(2),
+		"kernel_fs_error":  metric.Int(1),
+		"kernel_nic_reset": metric.Int(0),
+	})
+}
+
+func (s *S) TestKmsgReaderSeekEnd(c *check.C) {
+	path := filepath.Join(c.MkDir(), "kmsg")
+	err := ioutil.WriteFile(path, []byte("3,1,100,-;Out of memory: Kill process 1 (a)\n"), 0644)
+	c.Assert(err, check.IsNil)
+	reader := newKmsgReader(path, func(*rawLogParts, string, string, string) {
+		c.Error("unexpected message")
+	})
+	err = reader.start(true)
+	c.Assert(err, check.IsNil)
+	<-reader.done
+	c.Assert(reader.metrics()["kernel_oom"], check.Equals, metric.Int(0))
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/metric"
+)
+
+const (
+	linePolicyTruncate = "truncate"
+	linePolicySplit    = "split"
+	linePolicyDrop     = "drop"
+
+	defaultMaxLineSize = 64 * 1024
+	truncatedMarker    = "...(truncated)"
+	// syslogHeaderSlack is room left for the syslog header when reading
+	// lines from TCP connections.
+	syslogHeaderSlack = 1024
+)
+
+// lineLimit enforces the max size of the content of log messages.
+type lineLimit struct {
+	size      int
+	policy    string
+	oversized uint64
+}
+
+func newLineLimit() *lineLimit {
+	l := &lineLimit{
+		size:   config.IntEnvOrDefault(defaultMaxLineSize, "LOG_MAX_LINE_SIZE"),
+		policy: config.StringEnvOrDefault(linePolicyTruncate, "LOG_MAX_LINE_POLICY"),
+	}
+	switch l.policy {
+	case linePolicyTruncate, linePolicySplit, linePolicyDrop:
+	default:
+		bslog.Warnf("[log forwarder] invalid max line policy %q, using %q", l.policy, linePolicyTruncate)
+		l.policy = linePolicyTruncate
+	}
+	return l
+}
+
+// readSize returns the max size of lines read from stream connections. Longer
+// lines are cut when read, before the policy is applied.
+func (l *lineLimit) readSize() int {
+	if l.size+syslogHeaderSlack > defaultMaxLineSize {
+		return l.size + syslogHeaderSlack
+	}
+	return defaultMaxLineSize
+}
+
+// apply calls send with parts, when its content fits the limit, or according
+// to the policy otherwise: with the content truncated and ending with a
+// marker, once for each piece of the content or not at all. Pieces are
+// numbered, starting at 1, in parts.piece, out of parts.pieces, both left
+// zero for lines sent whole.
+func (l *lineLimit) apply(parts *rawLogParts, send func(*rawLogParts)) {
+	if l.size <= 0 || len(parts.content) <= l.size {
+		send(parts)
+		return
+	}
+	atomic.AddUint64(&l.oversized, 1)
+	switch l.policy {
+	case linePolicyDrop:
+	case linePolicySplit:
+		content := parts.content
+		parts.pieces = 0
+		for rest := content; len(rest) > 0; parts.pieces++ {
+			rest = rest[runeBoundary(rest, l.size):]
+		}
+		for i := 1; len(content) > 0; i++ {
+			n := runeBoundary(content, l.size)
+			parts.content = content[:n]
+			parts.piece = i
+			send(parts)
+			content = content[n:]
+		}
+	default:
+		n := l.size - len(truncatedMarker)
+		marker := truncatedMarker
+		if n <= 0 {
+			n, marker = l.size, ""
+		}
+		n = runeBoundary(parts.content, n)
+		truncated := make([]byte, 0, n+len(marker))
+		truncated = append(truncated, parts.content[:n]...)
+		parts.content = append(truncated, marker...)
+		send(parts)
+	}
+}
+
+func (l *lineLimit) metrics() map[string]metric.Metric {
+	return map[string]metric.Metric{
+		"log_oversized_lines": metric.Int(int64(atomic.LoadUint64(&l.oversized))),
+	}
+}
+
+// runeBoundary returns the largest index up to n that doesn't split an UTF-8
+// encoded rune in b.
+func runeBoundary(b []byte, n int) int {
+	if n >= len(b) {
+		return len(b)
+	}
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return n
+}
+
+// scanLimitedLines returns a split function that behaves like
+// bufio.ScanLines, except that lines longer than max are returned cut to max
+// bytes, with the remaining discarded, instead of failing the scanner.
+func scanLimitedLines(max int) bufio.SplitFunc {
+	discarding := false
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if discarding {
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				discarding = false
+				return i + 1, nil, nil
+			}
+			return len(data), nil, nil
+		}
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if advance == 0 && token == nil && err == nil && len(data) >= max {
+			discarding = true
+			return len(data), data[:max], nil
+		}
+		return advance, token, err
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) applyLimit(l *lineLimit, content string) []string {
+	var sent []string
+	l.apply(&rawLogParts{content: []byte(content)}, func(p *rawLogParts) {
+		sent = append(sent, string(p.content))
+	})
+	return sent
+}
+
+func (s *S) TestLineLimitFits(c *check.C) {
+	l := &lineLimit{size: 10, policy: linePolicyDrop}
+	c.Assert(s.applyLimit(l, "0123456789"), check.DeepEquals, []string{"0123456789"})
+	c.Assert(l.oversized, check.Equals, uint64(0))
+}
+
+func (s *S) TestLineLimitTruncate(c *check.C) {
+	l := &lineLimit{size: 20, policy: linePolicyTruncate}
+	c.Assert(s.applyLimit(l, "0123456789abcdefghijklmnop"), check.DeepEquals, []string{"012345" + truncatedMarker})
+	l = &lineLimit{size: 5, policy: linePolicyTruncate}
+	c.Assert(s.applyLimit(l, "0123456789"), check.DeepEquals, []string{"01234"})
+	c.Assert(l.metrics(), check.DeepEquals, map[string]metric.Metric{"log_oversized_lines": metric.Int(1)})
+}
+
+func (s *S) TestLineLimitSplit(c *check.C) {
+	l := &lineLimit{size: 4, policy: linePolicySplit}
+	c.Assert(s.applyLimit(l, "0123456789"), check.DeepEquals, []string{"0123", "4567", "89"})
+	c.Assert(s.applyLimit(l, "abção"), check.DeepEquals, []string{"abç", "ão"})
+	c.Assert(l.oversized, check.Equals, uint64(2))
+}
+
+func (s *S) TestLineLimitDrop(c *check.C) {
+	l := &lineLimit{size: 4, policy: linePolicyDrop}
+	c.Assert(s.applyLimit(l, "0123456789"), check.IsNil)
+	c.Assert(l.oversized, check.Equals, uint64(1))
+}
+
+func (s *S) TestNewLineLimit(c *check.C) {
+	l := newLineLimit()
+	c.Assert(l.size, check.Equals, defaultMaxLineSize)
+	c.Assert(l.policy, check.Equals, linePolicyTruncate)
+	c.Assert(l.readSize(), check.Equals, defaultMaxLineSize+syslogHeaderSlack)
+	os.Setenv("LOG_MAX_LINE_SIZE", "100000")
+	os.Setenv("LOG_MAX_LINE_POLICY", "split")
+	l = newLineLimit()
+	c.Assert(l.size, check.Equals, 100000)
+	c.Assert(l.policy, check.Equals, linePolicySplit)
+	c.Assert(l.readSize(), check.Equals, 100000+syslogHeaderSlack)
+	os.Setenv("LOG_MAX_LINE_SIZE", "100")
+	c.Assert(newLineLimit().readSize(), check.Equals, defaultMaxLineSize)
+	os.Setenv("LOG_MAX_LINE_POLICY", "explode")
+	l = newLineLimit()
+	c.Assert(l.policy, check.Equals, linePolicyTruncate)
+}
+
+func (s *S) TestRuneBoundary(c *check.C) {
+	b := []byte("aç€")
+	c.Assert(runeBoundary(b, 10), check.Equals, len(b))
+	c.Assert(runeBoundary(b, 1), check.Equals, 1)
+	c.Assert(runeBoundary(b, 2), check.Equals, 1)
+	c.Assert(runeBoundary(b, 3), check.Equals, 3)
+	c.Assert(runeBoundary(b, 4), check.Equals, 3)
+	c.Assert(runeBoundary(b, 5), check.Equals, 3)
+}
+
+func (s *S) TestScanLimitedLines(c *check.C) {
+	input := "short\r\n" + strings.Repeat("x", 100) + "\nafter\nlast"
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Buffer(make([]byte, 16), 32)
+	scanner.Split(scanLimitedLines(32))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	c.Assert(scanner.Err(), check.IsNil)
+	c.Assert(lines, check.DeepEquals, []string{"short", strings.Repeat("x", 32), "after", "last"})
+}
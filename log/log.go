@@ -5,25 +5,45 @@
 package log
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"sync"
 	"time"
 
+	"github.com/tsuru/bs/audit"
 	"github.com/tsuru/bs/bslog"
 	"github.com/tsuru/bs/config"
 	"github.com/tsuru/bs/container"
-	"gopkg.in/mcuadros/go-syslog.v2"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/node"
+	"github.com/tsuru/bs/privilege"
+	"github.com/tsuru/bs/supervisor"
 	"gopkg.in/mcuadros/go-syslog.v2/format"
 )
 
 const (
 	forwardConnDialTimeout  = time.Second
 	forwardConnWriteTimeout = time.Second
+	defaultWarmUpTimeout    = 10
 	noneBackend             = "none"
+
+	defaultForwardShards       = 1
+	defaultConnMaxAge          = -1
+	defaultKubernetesLogDir    = "/var/log/containers"
+	defaultKubernetesLogPosDir = "/var/log/bs"
+	defaultKmsgPath            = "/dev/kmsg"
 )
 
+// ErrUnknownDestination is returned when pausing or resuming a destination
+// that isn't enabled.
+var ErrUnknownDestination = errors.New("unknown log destination")
+
+// errForwarderStopped is returned when a forwarder is stopped while waiting
+// to forward a message.
+var errForwarderStopped = errors.New("log forwarder stopped")
+
 var (
 	stopWg      sync.WaitGroup
 	logBackends = map[string]func() logBackend{
@@ -33,17 +53,51 @@ var (
 	}
 )
 
+func init() {
+	for _, backend := range []string{"TSURU", "SYSLOG", "GELF"} {
+		config.DeclareDefault("LOG_"+backend+"_BUFFER_SIZE", config.DefaultBufferSize)
+		config.DeclareDefault("LOG_"+backend+"_FORWARD_SHARDS", defaultForwardShards)
+	}
+	config.DeclareDefault("LOG_TSURU_PING_INTERVAL", config.DefaultWsPingInterval)
+	config.DeclareDefault("LOG_TSURU_CONN_MAX_AGE", defaultConnMaxAge)
+	config.DeclareDefault("LOG_SYSLOG_CONN_MAX_AGE", defaultConnMaxAge)
+	config.DeclareDefault("LOG_SYSLOG_MTU_NETWORK_INTERFACE", defaultMTUInterface)
+	config.DeclareDefault("LOG_SYSLOG_HMAC_BATCH_SIZE", defaultSignBatchSize)
+	config.DeclareDefault("LOG_GELF_HOST", defaultGelfHost)
+	config.DeclareDefault("LOG_GELF_FIELDS_WHITELIST", defaultGelfFieldsWhitelist)
+	config.DeclareDefault("LOG_LOW_PRIORITY_MAX_BUFFER", defaultLowPriorityMaxBuffer)
+	config.DeclareDefault("LOG_MAX_LINE_SIZE", defaultMaxLineSize)
+	config.DeclareDefault("LOG_MAX_LINE_POLICY", linePolicyTruncate)
+	config.DeclareDefault("LOG_UDP_READ_BATCH", defaultUDPReadBatch)
+	config.DeclareDefault("LOG_KUBERNETES_LOG_DIR", defaultKubernetesLogDir)
+	config.DeclareDefault("LOG_KUBERNETES_LOG_POS_DIR", defaultKubernetesLogPosDir)
+	config.DeclareDefault("LOG_KMSG_PATH", defaultKmsgPath)
+	config.DeclareDefault("LOG_WARMUP_TIMEOUT", defaultWarmUpTimeout)
+}
+
 type LogMessage interface{}
 
 type LogForwarder struct {
 	BindAddress     string
 	DockerEndpoint  string
 	EnabledBackends []string
+	NodeMetadata    *node.MetadataCache
 	infoClient      *container.InfoClient
-	server          *syslog.Server
 	backends        []logBackend
 	formatter       *LenientFormat
 	kubeStreamer    *kubernetesLogStreamer
+	kmsg            *kmsgReader
+	listener        syslogListener
+	lineLimit       *lineLimit
+	sanitizer       *sanitizer
+	severity        *severityInferrer
+	accessLogs      *accessLogParser
+	counters        *logCounters
+	traceContext    bool
+	receipt         *receiptClock
+	sequences       *sequencer
+	deadLetters     *deadLetterWriter
+	tails           tailRegistry
 }
 
 type forwarderBackend interface {
@@ -52,70 +106,187 @@ type forwarderBackend interface {
 	close(conn net.Conn)
 }
 
+// batchingForwarder is implemented by forwarders grouping the messages
+// written to each connection in batches, like the signed syslog ones.
+// closeBatch is called every batchInterval, when positive, closing the
+// current batch if due, and before flushes, with force set, closing it if it
+// has any message.
+type batchingForwarder interface {
+	batchInterval() time.Duration
+	closeBatch(conn net.Conn, force bool) error
+}
+
+// syslogListener receives the syslog messages sent by Docker.
+type syslogListener interface {
+	start()
+	stop()
+	wait()
+}
+
 type logBackend interface {
 	initialize() error
 	sendMessage(*rawLogParts, string, string, string)
 	stop()
+	messageQueues() []*messageQueue
 }
 
-func processMessages(forwarder forwarderBackend, bufferSize int) (chan<- LogMessage, chan<- bool, error) {
+// processMessages starts the worker forwarding the messages sent to the
+// returned channels until the quit channel is closed. Messages waiting in
+// the high priority channel are always forwarded first. The first connection
+// is made by the worker itself, so destinations are connected in parallel,
+// and its result is sent to the returned ready channel.
+func processMessages(forwarder forwarderBackend, bufferSize int, q *messageQueue) (chan<- LogMessage, chan<- LogMessage, chan<- bool, <-chan error) {
 	ch := make(chan LogMessage, bufferSize)
+	high := make(chan LogMessage, bufferSize)
 	quit := make(chan bool)
+	ready := make(chan error, 1)
 	if initializable, ok := forwarder.(interface {
 		initialize(<-chan bool)
 	}); ok {
 		initializable.initialize(quit)
 	}
-	conn, err := forwarder.connect()
-	if err != nil {
-		return nil, nil, err
-	}
 	stopWg.Add(1)
 	go func() {
 		defer stopWg.Done()
-		var err error
-		for {
-			select {
-			case <-quit:
-				return
-			default:
-			}
-			if conn == nil {
-				conn, err = forwarder.connect()
-				if err != nil {
-					conn = nil
-					time.Sleep(100 * time.Millisecond)
-					continue
-				}
-			}
-		loop:
+		conn, err := forwarder.connect()
+		ready <- err
+		if err != nil {
+			q.progress.failed(err)
+			conn = nil
+		}
+		var reconnecting bool
+		progress := &q.progress
+		batcher, _ := forwarder.(batchingForwarder)
+		var batches <-chan time.Time
+		if batcher != nil && batcher.batchInterval() > 0 {
+			ticker := time.NewTicker(batcher.batchInterval())
+			defer ticker.Stop()
+			batches = ticker.C
+		}
+		supervisor.Run("log forwarder", func() {
 			for {
+				paused, resumed := q.pause.channels()
 				select {
 				case <-quit:
-					break loop
-				case msg := <-ch:
-					if msg == nil {
+					return
+				case <-resumed:
+				}
+				if conn == nil {
+					conn, err = forwarder.connect()
+					if err != nil {
+						progress.failed(err)
+						conn = nil
+						time.Sleep(100 * time.Millisecond)
+						continue
+					}
+					if reconnecting {
+						audit.Record("log", "reconnected to %s", forwarderName(forwarder))
+						reconnecting = false
+					}
+				}
+				progress.track(conn)
+				forward := func(msg LogMessage) error {
+					if !q.limiter.wait(messageSize(msg), quit) {
+						return errForwarderStopped
+					}
+					err := forwarder.process(conn, msg)
+					if err == nil {
+						progress.forwarded()
+					}
+					return err
+				}
+			loop:
+				for {
+					var msg LogMessage
+					select {
+					case <-quit:
+						break loop
+					case <-paused:
 						break loop
+					case msg = <-high:
+					default:
+						select {
+						case <-quit:
+							break loop
+						case <-paused:
+							break loop
+						case msg = <-high:
+						case msg = <-ch:
+							if msg == nil {
+								break loop
+							}
+						case <-batches:
+							err = batcher.closeBatch(conn, false)
+							if err != nil {
+								break loop
+							}
+							continue
+						}
 					}
-					err = forwarder.process(conn, msg)
+					if req, ok := msg.(flushRequest); ok {
+						// High priority messages sent before the flush
+						// request may still be waiting.
+						err = nil
+						for err == nil && len(high) > 0 {
+							err = forward(<-high)
+						}
+						if err == nil && batcher != nil {
+							err = batcher.closeBatch(conn, true)
+						}
+						if err == nil {
+							err = flushConn(conn)
+						}
+						req <- err
+						if err != nil {
+							break loop
+						}
+						continue
+					}
+					err = forward(msg)
 					if err != nil {
 						break loop
 					}
 				}
+				progress.untrack(conn)
+				forwarder.close(conn)
+				switch err {
+				case nil, errForwarderStopped:
+					break
+				case errConnMaxAgeExceeded:
+					bslog.Warnf("[log forwarder] connection max age exceeded, forcing reconnection")
+				default:
+					progress.failed(err)
+					bslog.Errorf("[log forwarder] error writing to %#v: %s", forwarder, err)
+					audit.Record("log", "lost connection to %s: %s", forwarderName(forwarder), err)
+					reconnecting = true
+				}
+				conn = nil
 			}
-			forwarder.close(conn)
-			switch err {
-			case nil:
-				break
-			case errConnMaxAgeExceeded:
-				bslog.Warnf("[log forwarder] connection max age exceeded, forcing reconnection")
-			default:
-				bslog.Errorf("[log forwarder] error writing to %#v: %s", forwarder, err)
-			}
-			conn = nil
-		}
+		})
 	}()
-	return ch, quit, nil
+	return ch, high, quit, ready
+}
+
+// ValidateBackends checks that every name in backends is a known log
+// backend, or that backends is only "none".
+func ValidateBackends(backends []string) error {
+	if len(backends) == 1 && backends[0] == noneBackend {
+		return nil
+	}
+	for _, name := range backends {
+		if logBackends[name] == nil {
+			return fmt.Errorf("invalid log backend: %s", name)
+		}
+	}
+	return nil
+}
+
+// forwarderName describes the destination of a forwarder in audit records.
+func forwarderName(forwarder forwarderBackend) string {
+	if s, ok := forwarder.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", forwarder)
 }
 
 func (l *LogForwarder) Start() (err error) {
@@ -127,13 +298,76 @@ func (l *LogForwarder) Start() (err error) {
 	if len(l.EnabledBackends) == 1 && l.EnabledBackends[0] == noneBackend {
 		return
 	}
+	err = l.initialize()
+	if err != nil {
+		return
+	}
+	url, err := url.Parse(l.BindAddress)
+	if err != nil {
+		return
+	}
+	rcvBuf := config.IntEnvOrDefault(0, "LOG_LISTEN_RECEIVE_BUFFER")
+	switch url.Scheme {
+	case "tcp":
+		var tcp *tcpReader
+		tcp, err = newTCPReader(url.Host, l.formatter, l, rcvBuf, l.lineLimit.readSize())
+		if err != nil {
+			return
+		}
+		l.listener = tcp
+	case "udp":
+		batch := config.IntEnvOrDefault(defaultUDPReadBatch, "LOG_UDP_READ_BATCH")
+		var udp *udpReader
+		udp, err = newUDPReader(url.Host, l.formatter, l, batch, rcvBuf)
+		if err != nil {
+			return
+		}
+		l.listener = udp
+	default:
+		return fmt.Errorf("invalid protocol %q, expected tcp or udp", url.Scheme)
+	}
+	kubeLogDir := config.StringEnvOrDefault(defaultKubernetesLogDir, "LOG_KUBERNETES_LOG_DIR")
+	kubeLogPosDir := config.StringEnvOrDefault(defaultKubernetesLogPosDir, "LOG_KUBERNETES_LOG_POS_DIR")
+	l.kubeStreamer, err = newKubeLogStreamer(l, l.infoClient, kubeLogDir, kubeLogPosDir)
+	if err == nil {
+		// Log files are followed with tail.
+		if execErr := privilege.Exec.Check(); execErr != nil {
+			bslog.Warnf("[log forwarder] not monitoring log files in %s: %s", kubeLogDir, execErr)
+			l.kubeStreamer = nil
+		} else {
+			go l.kubeStreamer.watch()
+		}
+	} else if err != errNoLogDirectory {
+		return err
+	}
+	if config.BoolEnvOrDefault(false, "LOG_KMSG_ENABLED") {
+		kmsgPath := config.StringEnvOrDefault(defaultKmsgPath, "LOG_KMSG_PATH")
+		kmsg := newKmsgReader(kmsgPath, l.sendHostMessage)
+		if kmsgErr := kmsg.start(true); kmsgErr != nil {
+			bslog.Warnf("[log forwarder] unable to read kernel messages from %s: %s", kmsgPath, kmsgErr)
+		} else {
+			l.kmsg = kmsg
+		}
+	}
+	l.listener.start()
+	return nil
+}
+
+// initialize initializes the backends, the docker client used to find the
+// containers of the received messages and the message processors.
+func (l *LogForwarder) initialize() error {
 	for _, backendName := range l.EnabledBackends {
 		constructor := logBackends[backendName]
 		if constructor == nil {
 			return fmt.Errorf("invalid log backend: %s", backendName)
 		}
 		backend := constructor()
-		err = backend.initialize()
+		if b, ok := backend.(interface {
+			setNodeMetadata(*node.MetadataCache)
+		}); ok {
+			b.setNodeMetadata(l.NodeMetadata)
+		}
+		err := backend.initialize()
 		if err != nil {
 			return fmt.Errorf("unable to initialize log backend %q: %s", backendName, err)
 		}
@@ -142,50 +376,207 @@ func (l *LogForwarder) Start() (err error) {
 	if len(l.backends) == 0 {
 		bslog.Warnf("no log backend enabled, discarding all received log messages.")
 	}
+	err := l.warmUp(config.SecondsEnvOrDefault(defaultWarmUpTimeout, "LOG_WARMUP_TIMEOUT"))
+	if err != nil {
+		return err
+	}
 	l.infoClient, err = container.NewClient(l.DockerEndpoint)
 	if err != nil {
-		err = fmt.Errorf("unable to initialize docker client %s: %s", l.DockerEndpoint, err)
-		return
+		return fmt.Errorf("unable to initialize docker client %s: %s", l.DockerEndpoint, err)
 	}
 	l.formatter = &LenientFormat{}
-	l.server = syslog.NewServer()
-	l.server.SetHandler(l)
-	l.server.SetFormat(l.formatter)
-	url, err := url.Parse(l.BindAddress)
-	if err != nil {
-		return
+	l.lineLimit = newLineLimit()
+	l.sanitizer = newSanitizer()
+	l.severity = newSeverityInferrer()
+	l.accessLogs = newAccessLogParser()
+	l.counters = newLogCounters()
+	l.traceContext = config.BoolEnvOrDefault(false, "LOG_EXTRACT_TRACE_CONTEXT")
+	if config.BoolEnvOrDefault(false, "LOG_RECEIVED_TIMESTAMP") {
+		l.receipt = newReceiptClock()
 	}
-	if url.Scheme == "tcp" {
-		err = l.server.ListenTCP(url.Host)
-	} else if url.Scheme == "udp" {
-		err = l.server.ListenUDP(url.Host)
-	} else {
-		err = fmt.Errorf("invalid protocol %q, expected tcp or udp", url.Scheme)
+	if config.BoolEnvOrDefault(false, "LOG_SEQUENCE_NUMBERS") {
+		l.sequences = newSequencer()
 	}
-	if err != nil {
-		return
+	l.deadLetters, err = newDeadLetterWriter(config.StringEnvOrDefault("", "LOG_DEAD_LETTER_DESTINATION"))
+	return err
+}
+
+// warmUp waits up to timeout for the first connection to every destination,
+// made in parallel by the queues as the backends are initialized, logging
+// the ones ready and the ones still connecting, which are left connecting in
+// background. Failing to connect within the timeout fails the
+// initialization, as it's usually caused by an invalid destination.
+func (l *LogForwarder) warmUp(timeout time.Duration) error {
+	start := time.Now()
+	deadline := time.After(timeout)
+	for i, backend := range l.backends {
+		for _, q := range backend.messageQueues() {
+			err := q.waitReady(deadline)
+			switch err {
+			case nil:
+				bslog.Infof("[log forwarder] %s ready in %s", q.name, time.Since(start))
+			case errNotReady:
+				lastErr, _ := q.progress.lastError()
+				if lastErr == "" {
+					lastErr = err.Error()
+				}
+				bslog.Warnf("[log forwarder] %s not ready after %s, connecting in background: %s", q.name, timeout, lastErr)
+				audit.Record("log", "%s not ready after %s: %s", q.name, timeout, lastErr)
+			default:
+				return fmt.Errorf("unable to initialize log backend %q: %s", l.EnabledBackends[i], err)
+			}
+		}
 	}
-	kubeLogDir := config.StringEnvOrDefault("/var/log/containers", "LOG_KUBERNETES_LOG_DIR")
-	kubeLogPosDir := config.StringEnvOrDefault("/var/log/bs", "LOG_KUBERNETES_LOG_POS_DIR")
-	l.kubeStreamer, err = newKubeLogStreamer(l, l.infoClient, kubeLogDir, kubeLogPosDir)
-	if err == nil {
-		go l.kubeStreamer.watch()
-	} else if err != errNoLogDirectory {
-		return err
+	return nil
+}
+
+// HostMetrics returns counters of the kernel events found in the kernel log,
+// if reading it is enabled, the number of messages dropped by the kernel in
+// the UDP syslog socket, the number of lines over the max line size and the
+// number of messages written to the dead-letter destination.
+func (l *LogForwarder) HostMetrics() map[string]metric.Metric {
+	var metrics map[string]metric.Metric
+	if l.kmsg != nil {
+		metrics = l.kmsg.metrics()
+	}
+	if metrics == nil {
+		metrics = make(map[string]metric.Metric)
+	}
+	if l.lineLimit != nil {
+		for k, v := range l.lineLimit.metrics() {
+			metrics[k] = v
+		}
+	}
+	if l.deadLetters != nil {
+		for k, v := range l.deadLetters.metrics() {
+			metrics[k] = v
+		}
+	}
+	if udp, ok := l.listener.(*udpReader); ok {
+		drops, err := udp.drops()
+		if err == nil {
+			metrics["log_udp_drops"] = metric.Int(int64(drops))
+		} else if err != errSocketStatsUnsupported {
+			bslog.Debugf("[log forwarder] unable to read udp socket drops: %s", err)
+		}
+	}
+	return metrics
+}
+
+// Progress returns the number of messages sent to the backends, including the
+// ones dropped due to full buffers, and the number of messages actually
+// forwarded by them. Paused destinations are left out, as they receive
+// messages without forwarding any by design.
+func (l *LogForwarder) Progress() (received, forwarded uint64) {
+	for _, backend := range l.backends {
+		for _, q := range backend.messageQueues() {
+			if q.pause.isPaused() {
+				continue
+			}
+			r, f := q.counts()
+			received += r
+			forwarded += f
+		}
+	}
+	return received, forwarded
+}
+
+// State returns the state of the queue of every backend destination and the
+// number of containers in the cache, for state dumps.
+func (l *LogForwarder) State() map[string]interface{} {
+	queues := []map[string]interface{}{}
+	for _, backend := range l.backends {
+		for _, q := range backend.messageQueues() {
+			queues = append(queues, q.state())
+		}
+	}
+	state := map[string]interface{}{"queues": queues}
+	if l.infoClient != nil {
+		state["container_cache"] = l.infoClient.CacheLen()
+	}
+	return state
+}
+
+// Restart forces every backend to reconnect, unblocking forwarders stuck
+// writing to dead connections.
+func (l *LogForwarder) Restart() error {
+	for _, backend := range l.backends {
+		for _, q := range backend.messageQueues() {
+			q.reconnect()
+		}
+	}
+	return nil
+}
+
+// Flush waits for the messages buffered for every destination to be
+// forwarded and flushes their connections, up to timeout. It's used before
+// shutting down nodes, so no logs are left behind.
+func (l *LogForwarder) Flush(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for _, backend := range l.backends {
+		for _, q := range backend.messageQueues() {
+			if err := q.flush(deadline); err != nil {
+				return fmt.Errorf("unable to flush %s: %s", q.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Pause stops forwarding logs to the destination with the given name, as
+// reported in State, closing its connections. Messages are kept only in the
+// in-memory buffer of the destination, nothing is spooled to disk, and new
+// messages are dropped once it's full, until Resume is called.
+func (l *LogForwarder) Pause(destination string) error {
+	return l.setPaused(destination, true)
+}
+
+// Resume resumes forwarding logs to a destination paused with Pause.
+func (l *LogForwarder) Resume(destination string) error {
+	return l.setPaused(destination, false)
+}
+
+func (l *LogForwarder) setPaused(destination string, pause bool) error {
+	for _, backend := range l.backends {
+		for _, q := range backend.messageQueues() {
+			if q.name != destination {
+				continue
+			}
+			if q.pause.set(pause) {
+				action := "resumed"
+				if pause {
+					action = "paused"
+				}
+				audit.Record("log", "%s forwarding to %s", action, destination)
+			}
+			return nil
+		}
+	}
+	return ErrUnknownDestination
+}
+
+// sendHostMessage sends a message not related to any container to every
+// backend but tsuru, which only accepts app logs.
+func (l *LogForwarder) sendHostMessage(parts *rawLogParts, appName, processName, hostname string) {
+	l.receipt.stamp(parts)
+	for _, backend := range l.backends {
+		if _, ok := backend.(*tsuruBackend); ok {
+			continue
+		}
+		backend.sendMessage(parts, appName, processName, hostname)
 	}
-	return l.server.Boot()
 }
 
 func (l *LogForwarder) Wait() {
-	if l.server != nil {
-		l.server.Wait()
+	if l.listener != nil {
+		l.listener.wait()
 	}
 	stopWg.Wait()
 }
 
 func (l *LogForwarder) Stop() {
-	if l.server != nil {
-		l.server.Kill()
+	if l.listener != nil {
+		l.listener.stop()
 	}
 	for _, backend := range l.backends {
 		backend.stop()
@@ -193,6 +584,10 @@ func (l *LogForwarder) Stop() {
 	if l.kubeStreamer != nil {
 		l.kubeStreamer.stop()
 	}
+	if l.kmsg != nil {
+		l.kmsg.stop()
+	}
+	l.deadLetters.close()
 }
 
 func (l *LogForwarder) stopWait() {
@@ -202,8 +597,10 @@ func (l *LogForwarder) stopWait() {
 
 func (l *LogForwarder) Handle(logParts format.LogParts, _ int64, err error) {
 	parts := logParts["parts"].(*rawLogParts)
+	defer parts.release()
 	if err != nil {
 		bslog.Debugf("[log forwarder] ignored msg %v error processing: %s", parts, err)
+		l.deadLetter(deadLetterSyslog, parts.rawLine(), err)
 		return
 	}
 	if len(parts.content) == 0 {
@@ -213,14 +610,86 @@ func (l *LogForwarder) Handle(logParts format.LogParts, _ int64, err error) {
 	}
 	if parts.ts.IsZero() || len(parts.priority) == 0 {
 		bslog.Debugf("[log forwarder] invalid message %v", parts)
+		if line := parts.rawLine(); line != nil {
+			l.deadLetter(deadLetterSyslog, line, errInvalidMessage)
+		}
 		return
 	}
+	l.receipt.stamp(parts)
 	contStr := string(parts.container)
 	contData, err := l.infoClient.GetContainer(contStr, true, nil)
 	if err != nil {
 		bslog.Debugf("[log forwarder] error getting container %v for msg %v", contStr, parts)
 		return
 	}
+	if contData.Excluded() {
+		return
+	}
+	if l.sequences != nil {
+		parts.sequence = l.sequences.next(contData.ID, time.Now())
+	}
+	parts.shardKey = contData.ID
+	if parts.stream == streamUnknown {
+		parts.stream = streamFromPriority(parts.priority)
+	}
+	parts.class = containerLogClass(contData)
+	parts.retention = containerLogRetention(contData)
+	if l.sanitizer != nil {
+		parts.content = l.sanitizer.sanitize(parts.content)
+	}
+	if l.severity != nil {
+		l.severity.apply(parts, contData)
+	}
+	if l.accessLogs != nil {
+		if access, ok := l.accessLogs.parse(parts.content); ok {
+			parts.access = access
+			l.accessLogs.record(contData.ID, access)
+		}
+	}
+	if l.counters != nil {
+		l.counters.count(contData, parts.content)
+	}
+	if l.traceContext {
+		parts.traceID, parts.spanID = extractTraceContext(parts.content)
+	}
+	if l.lineLimit == nil {
+		l.sendMessage(parts, contData)
+		return
+	}
+	l.lineLimit.apply(parts, func(p *rawLogParts) {
+		l.sendMessage(p, contData)
+	})
+}
+
+// deadLetter writes a message that couldn't be parsed, received from source,
+// to the dead-letter destination, if any.
+func (l *LogForwarder) deadLetter(source string, raw []byte, err error) {
+	l.deadLetters.write(source, raw, err)
+}
+
+// ContainerMetrics returns the HTTP metrics aggregated from the access logs of
+// the container, if enabled, and the number of its lines matching each log
+// counter.
+func (l *LogForwarder) ContainerMetrics(id string) map[string]metric.Metric {
+	var metrics map[string]metric.Metric
+	if l.accessLogs != nil {
+		metrics = l.accessLogs.containerMetrics(id)
+	}
+	if l.counters == nil {
+		return metrics
+	}
+	counts := l.counters.containerMetrics(id)
+	if metrics == nil {
+		return counts
+	}
+	for k, v := range counts {
+		metrics[k] = v
+	}
+	return metrics
+}
+
+func (l *LogForwarder) sendMessage(parts *rawLogParts, contData *container.Container) {
+	l.tails.publish(parts, contData)
 	for _, backend := range l.backends {
 		if !contData.TsuruApp {
 			if _, ok := backend.(*tsuruBackend); ok {
@@ -17,6 +17,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -24,12 +25,17 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"text/template"
 	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
 	"github.com/fsouza/go-dockerclient"
 	dTesting "github.com/fsouza/go-dockerclient/testing"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/dryrun"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/node"
+	"github.com/tsuru/bs/tsuruapi"
 	"github.com/tsuru/tsuru/app"
 	"golang.org/x/net/websocket"
 	"gopkg.in/check.v1"
@@ -165,6 +171,13 @@ func (s *S) TestLogForwarderStartNoneBackend(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *S) TestValidateBackends(c *check.C) {
+	c.Assert(ValidateBackends([]string{"none"}), check.IsNil)
+	c.Assert(ValidateBackends([]string{"tsuru", "syslog", "gelf"}), check.IsNil)
+	c.Assert(ValidateBackends([]string{"tsuru", "kafka"}), check.ErrorMatches, "invalid log backend: kafka")
+	c.Assert(ValidateBackends([]string{"tsuru", "none"}), check.ErrorMatches, "invalid log backend: none")
+}
+
 func (s *S) TestLogForwarderStartWithTimezone(c *check.C) {
 	os.Setenv("LOG_SYSLOG_TIMEZONE", "America/Grenada")
 	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
@@ -352,18 +365,20 @@ func (s *S) TestLogForwarderOverflow(c *check.C) {
 	}
 	err = lf.Start()
 	c.Assert(err, check.IsNil)
-	parts := format.LogParts{"parts": &rawLogParts{
-		ts:        time.Date(2015, 6, 5, 16, 13, 47, 0, time.UTC),
-		priority:  []byte("30"),
-		content:   []byte("hey"),
-		container: []byte(s.id),
-	}}
 	wg := sync.WaitGroup{}
 	for i := 0; i < 32; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for j := 0; j < 1000; j++ {
+				// Handle owns the parts it receives, like the ones
+				// returned by the parser for each message.
+				parts := format.LogParts{"parts": &rawLogParts{
+					ts:        time.Date(2015, 6, 5, 16, 13, 47, 0, time.UTC),
+					priority:  []byte("30"),
+					content:   []byte("hey"),
+					container: []byte(s.id),
+				}}
 				lf.Handle(parts, 0, nil)
 			}
 		}()
@@ -552,6 +567,192 @@ func (s *S) TestLogForwarderStartWithMessageExtra(c *check.C) {
 	c.Assert(string(buffer[:n]), check.Equals, fmt.Sprintf("<30>Jun  5 13:13:47 %s coolappname[procx]: #val1 mymsg #val2 #myvalue\n", s.idShort))
 }
 
+func (s *S) TestLogForwarderStartWithNodeMetadata(c *check.C) {
+	tsuruServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"nodes": [{"Address": "http://127.0.0.1:2375", "Pool": "pool1"}]}`))
+	}))
+	defer tsuruServer.Close()
+	metadata := node.NewMetadataCache(tsuruapi.NewClient(tsuruapi.Config{Endpoint: tsuruServer.URL}), []string{"127.0.0.1"}, 0)
+	err := metadata.Refresh()
+	c.Assert(err, check.IsNil)
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	udpConn, err := net.ListenUDP("udp", addr)
+	c.Assert(err, check.IsNil)
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "udp://"+udpConn.LocalAddr().String())
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+		NodeMetadata:    metadata,
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	conn, err := net.Dial("udp", "127.0.0.1:59317")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	msg := []byte(fmt.Sprintf("<30>2015-06-05T16:13:47Z myhost docker/%s: mymsg\n", s.id))
+	_, err = conn.Write(msg)
+	c.Assert(err, check.IsNil)
+	buffer := make([]byte, 1024)
+	udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := udpConn.Read(buffer)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buffer[:n]), check.Equals, fmt.Sprintf("<30>Jun  5 13:13:47 %s coolappname[procx]: pool=pool1 node=http://127.0.0.1:2375 mymsg\n", s.idShort))
+}
+
+func (s *S) TestLogForwarderStartWithExtraTags(c *check.C) {
+	os.Setenv("METRICS_EXTRA_TAGS", "rack=b12,dc=sp1")
+	defer os.Unsetenv("METRICS_EXTRA_TAGS")
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	udpConn, err := net.ListenUDP("udp", addr)
+	c.Assert(err, check.IsNil)
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "udp://"+udpConn.LocalAddr().String())
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	conn, err := net.Dial("udp", "127.0.0.1:59317")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	msg := []byte(fmt.Sprintf("<30>2015-06-05T16:13:47Z myhost docker/%s: mymsg\n", s.id))
+	_, err = conn.Write(msg)
+	c.Assert(err, check.IsNil)
+	buffer := make([]byte, 1024)
+	udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := udpConn.Read(buffer)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buffer[:n]), check.Equals, fmt.Sprintf("<30>Jun  5 13:13:47 %s coolappname[procx]: dc=sp1 rack=b12 mymsg\n", s.idShort))
+}
+
+func (s *S) TestLogForwarderStartWithMessageTemplate(c *check.C) {
+	os.Setenv("LOG_SYSLOG_MESSAGE_TEMPLATE", `{{.Timestamp.Format "2006-01-02T15:04:05"}} app={{.AppName}} process={{.ProcessName}} container={{.ContainerID}} msg={{.Message}}`)
+	defer os.Unsetenv("LOG_SYSLOG_MESSAGE_TEMPLATE")
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	udpConn, err := net.ListenUDP("udp", addr)
+	c.Assert(err, check.IsNil)
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "udp://"+udpConn.LocalAddr().String())
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	conn, err := net.Dial("udp", "127.0.0.1:59317")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	msg := []byte(fmt.Sprintf("<30>2015-06-05T16:13:47Z myhost docker/%s: mymsg\n", s.id))
+	_, err = conn.Write(msg)
+	c.Assert(err, check.IsNil)
+	buffer := make([]byte, 1024)
+	udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := udpConn.Read(buffer)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(buffer[:n]), check.Equals, fmt.Sprintf("<30>2015-06-05T13:13:47 app=coolappname process=procx container=%s msg=mymsg\n", s.idShort))
+}
+
+func (s *S) TestLogForwarderStartWithInvalidMessageTemplate(c *check.C) {
+	os.Setenv("LOG_SYSLOG_MESSAGE_TEMPLATE", "{{.Message")
+	defer os.Unsetenv("LOG_SYSLOG_MESSAGE_TEMPLATE")
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+	}
+	err := lf.Start()
+	c.Assert(err, check.ErrorMatches, `unable to initialize log backend "syslog": unable to parse syslog message template: .*`)
+}
+
+func (s *S) TestLogForwarderStartWithInvalidCompression(c *check.C) {
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "tcp://127.0.0.1:1514")
+	os.Setenv("LOG_SYSLOG_COMPRESSION", "zstd")
+	defer os.Unsetenv("LOG_SYSLOG_COMPRESSION")
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+	}
+	err := lf.Start()
+	c.Assert(err, check.ErrorMatches, `unable to initialize log backend "syslog": invalid LOG_SYSLOG_COMPRESSION "zstd", expected gzip`)
+}
+
+func (s *S) TestSyslogBackendAppendTemplate(c *check.C) {
+	parts := &rawLogParts{
+		ts:        time.Date(2015, 6, 5, 16, 13, 47, 0, time.UTC),
+		content:   []byte("my msg"),
+		traceID:   []byte("0af7651916cd43dd"),
+		retention: "7d",
+		received:  1433520827123456789,
+		sequence:  42,
+		piece:     2,
+		pieces:    3,
+		stream:    streamStderr,
+	}
+	tests := []struct {
+		template   string
+		expected   string
+		headerIdx  int
+		contentIdx int
+	}{
+		{"{{.AppName}}: {{.Message}} end", "myapp: my msg end", 7, 13},
+		{"{{.AppName}}: {{printf \"%q\" .Message}}", `myapp: "my msg"`, 0, 15},
+		{"{{.Message}} {{.Message}}", "my msg my msg", 0, 13},
+		{"{{.ProcessName}} {{.ContainerID}}", "web abc123", 0, 10},
+		{"trace={{.TraceID}} span={{.SpanID}} {{.Message}}", "trace=0af7651916cd43dd span= my msg", 29, 35},
+		{"dc={{.Tags.dc}} {{.Message}}", "dc=sp1 my msg", 7, 13},
+		{"retention={{.Retention}} {{.Message}}", "retention=7d my msg", 13, 19},
+		{"received={{.ReceivedNS}} {{.Message}}", "received=1433520827123456789 my msg", 29, 35},
+		{"seq={{.Sequence}} {{.Message}}", "seq=42 my msg", 7, 13},
+		{"seq={{.Sequence}}.{{.Piece}}/{{.Pieces}} {{.Message}}", "seq=42.2/3 my msg", 11, 17},
+		{"stream={{.Stream}} {{.Message}}", "stream=stderr my msg", 14, 20},
+	}
+	for _, tt := range tests {
+		b := syslogBackend{syslogLocation: time.UTC, extraTags: map[string]string{"dc": "sp1"}}
+		b.template = template.Must(template.New("syslog").Parse(tt.template))
+		buffer, headerIdx, contentIdx, err := b.appendTemplate(nil, parts, "myapp", "web", "abc123")
+		c.Assert(err, check.IsNil)
+		c.Check(string(buffer), check.Equals, tt.expected)
+		c.Check(headerIdx, check.Equals, tt.headerIdx)
+		c.Check(contentIdx, check.Equals, tt.contentIdx)
+	}
+}
+
+func (s *S) TestSyslogBackendTimestampFormat(c *check.C) {
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "udp://127.0.0.1:59318")
+	os.Setenv("LOG_SYSLOG_TIMEZONE", "UTC")
+	defer os.Unsetenv("LOG_SYSLOG_TIMEZONE")
+	defer os.Unsetenv("LOG_SYSLOG_TIMESTAMP_FORMAT")
+	ts := time.Date(2015, 6, 5, 16, 13, 47, 123456789, time.UTC)
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"", "Jun  5 16:13:47"},
+		{"rfc3164", "Jun  5 16:13:47"},
+		{"RFC3339", "2015-06-05T16:13:47Z"},
+		{"rfc3339nano", "2015-06-05T16:13:47.123456789Z"},
+		{"epoch_millis", "1433520827123"},
+		{"2006/01/02 15:04:05.000", "2015/06/05 16:13:47.123"},
+	}
+	for _, tt := range tests {
+		os.Setenv("LOG_SYSLOG_TIMESTAMP_FORMAT", tt.format)
+		var b syslogBackend
+		err := b.initialize()
+		c.Assert(err, check.IsNil)
+		c.Check(string(b.appendTimestamp(nil, ts)), check.Equals, tt.expected, check.Commentf("format: %q", tt.format))
+		b.stop()
+	}
+}
+
 func (s *S) TestLogForwarderSyslogSplit(c *check.C) {
 	os.Setenv("LOG_SYSLOG_MESSAGE_EXTRA_START", "#val1")
 	os.Setenv("LOG_SYSLOG_MESSAGE_EXTRA_END", "#val2")
@@ -639,6 +840,45 @@ func (s *S) TestLogForwarderSyslogSplit(c *check.C) {
 	}
 }
 
+func (s *S) TestSyslogForwarderSplitLargeTemplate(c *check.C) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, check.IsNil)
+	defer udpConn.Close()
+	forwardURL, _ := url.Parse("udp://" + udpConn.LocalAddr().String())
+	f := &syslogForwarder{
+		url:        forwardURL,
+		bufferPool: &sync.Pool{New: func() interface{} { return make([]byte, 200) }},
+		connMaxAge: -1,
+		mtu:        udpHeaderSz + 200,
+	}
+	conn, err := f.connect()
+	c.Assert(err, check.IsNil)
+	defer f.close(conn)
+	parts := &rawLogParts{ts: time.Date(2015, 6, 5, 16, 13, 47, 0, time.UTC)}
+	tests := []struct {
+		template string
+		content  string
+	}{
+		{"{{.AppName}} " + strings.Repeat("h", 250) + " {{.Message}}", strings.Repeat("*", 300)},
+		{"{{.AppName}} " + strings.Repeat("h", 184) + " {{.Message}}", strings.Repeat("*", 2000)},
+	}
+	for _, tt := range tests {
+		b := syslogBackend{syslogLocation: time.UTC}
+		b.template = template.Must(template.New("syslog").Parse(tt.template))
+		parts.content = []byte(tt.content)
+		buffer, headerIdx, contentIdx, err := b.appendTemplate(nil, parts, "myapp", "web", "abc123")
+		c.Assert(err, check.IsNil)
+		expected := string(buffer)
+		err = f.process(conn, bufferWithIdx{buffer: buffer, headerIdx: headerIdx, contentIdx: contentIdx})
+		c.Assert(err, check.IsNil)
+		udpConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		received := make([]byte, 4096)
+		n, err := udpConn.Read(received)
+		c.Assert(err, check.IsNil)
+		c.Assert(string(received[:n]), check.Equals, expected)
+	}
+}
+
 func (s *S) TestLogForwarderStartFromFile(c *check.C) {
 	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
 	c.Assert(err, check.IsNil)
@@ -836,11 +1076,10 @@ func BenchmarkMessagesWaitOneSyslogAddress(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		lf.Handle(parts, 1, nil)
 	}
-	close(lf.backends[0].(*syslogBackend).msgChans[0])
+	close(lf.backends[0].(*syslogBackend).queues[0].chans[0])
 	<-done[0]
 	b.StopTimer()
-	lf.server.Kill()
-	lf.Wait()
+	lf.stopWait()
 }
 
 func BenchmarkMessagesWaitTwoSyslogAddresses(b *testing.B) {
@@ -874,13 +1113,12 @@ func BenchmarkMessagesWaitTwoSyslogAddresses(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		lf.Handle(parts, 1, nil)
 	}
-	close(lf.backends[0].(*syslogBackend).msgChans[0])
-	close(lf.backends[0].(*syslogBackend).msgChans[1])
+	close(lf.backends[0].(*syslogBackend).queues[0].chans[0])
+	close(lf.backends[0].(*syslogBackend).queues[1].chans[0])
 	<-done[0]
 	<-done[1]
 	b.StopTimer()
-	lf.server.Kill()
-	lf.Wait()
+	lf.stopWait()
 }
 
 func BenchmarkMessagesBroadcastNonAppContainer(b *testing.B) {
@@ -1042,7 +1280,7 @@ func BenchmarkMessagesBroadcastWaitTsuru(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		lf.Handle(parts, 1, nil)
 	}
-	close(lf.backends[0].(*tsuruBackend).msgCh)
+	close(lf.backends[0].(*tsuruBackend).queue.chans[0])
 	<-done
 	b.StopTimer()
 }
@@ -1079,6 +1317,33 @@ func (s *S) TestGelfForwarder(c *check.C) {
 	c.Assert(gelfMsg.Extra["_pid"], check.Equals, "procx")
 }
 
+func (s *S) TestGelfForwarderMetricsExtraTags(c *check.C) {
+	defer os.Unsetenv("LOG_GELF_HOST")
+	os.Setenv("METRICS_EXTRA_TAGS", "dc=sp1,app=other")
+	defer os.Unsetenv("METRICS_EXTRA_TAGS")
+	reader, err := gelf.NewReader("127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	os.Setenv("LOG_GELF_HOST", reader.Addr())
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"gelf"},
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	conn, err := net.Dial("udp", "127.0.0.1:59317")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	msg := []byte(fmt.Sprintf("<30>2015-06-05T16:13:47Z myhost docker/%s: mymsg\n", s.id))
+	_, err = conn.Write(msg)
+	c.Assert(err, check.IsNil)
+	gelfMsg, err := reader.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Assert(gelfMsg.Extra["_app"], check.Equals, "coolappname")
+	c.Assert(gelfMsg.Extra["_dc"], check.Equals, "sp1")
+}
+
 func (s *S) TestGelfForwarderExtraTags(c *check.C) {
 	defer os.Unsetenv("LOG_GELF_HOST")
 	defer os.Unsetenv("LOG_GELF_EXTRA_TAGS")
@@ -1114,6 +1379,40 @@ func (s *S) TestGelfForwarderExtraTags(c *check.C) {
 	c.Assert(gelfMsg.Extra["_tags"], check.Equals, "TSURU")
 }
 
+func (s *S) TestGelfForwarderAccessLog(c *check.C) {
+	defer os.Unsetenv("LOG_GELF_HOST")
+	reader, err := gelf.NewReader("127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	os.Setenv("LOG_GELF_HOST", reader.Addr())
+	os.Setenv("LOG_ACCESS_LOGS", "true")
+	os.Setenv("LOG_ACCESS_LOGS_METRICS", "true")
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"gelf"},
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	conn, err := net.Dial("udp", "127.0.0.1:59317")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	msg := []byte(fmt.Sprintf(`<30>2015-06-05T16:13:47Z myhost docker/%s: 10.0.0.1 - - [05/Jun/2015:16:13:47 +0000] "GET /users?id=1 HTTP/1.1" 503 12 "-" "curl" 0.300`+"\n", s.id))
+	_, err = conn.Write(msg)
+	c.Assert(err, check.IsNil)
+	gelfMsg, err := reader.ReadMessage()
+	c.Assert(err, check.IsNil)
+	c.Assert(gelfMsg.Extra["_http_method"], check.Equals, "GET")
+	c.Assert(gelfMsg.Extra["_http_path"], check.Equals, "/users")
+	c.Assert(gelfMsg.Extra["_http_status"], check.Equals, float64(503))
+	c.Assert(gelfMsg.Extra["_http_bytes"], check.Equals, float64(12))
+	c.Assert(gelfMsg.Extra["_http_latency"], check.Equals, 0.3)
+	metrics := lf.ContainerMetrics(s.id)
+	c.Assert(metrics["http_request_rate"].Float64() > 0, check.Equals, true)
+	c.Assert(metrics["http_5xx_rate"], check.Equals, metrics["http_request_rate"])
+	c.Assert(metrics["http_latency_p95"], check.Equals, metric.Float(0.3))
+}
+
 func (s *S) TestGelfForwarderParseExtraTags(c *check.C) {
 	defer os.Unsetenv("LOG_GELF_HOST")
 	defer os.Unsetenv("LOG_GELF_FIELDS_WHITELIST")
@@ -1265,3 +1564,43 @@ func BenchmarkMessagesGelfBackendProcess(b *testing.B) {
 	}
 	b.StopTimer()
 }
+
+func (s *S) TestLogForwarderDryRun(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dry-run.log")
+	err := dryrun.Setup(path)
+	c.Assert(err, check.IsNil)
+	defer dryrun.Disable()
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "tcp://127.0.0.1:1")
+	os.Setenv("LOG_GELF_HOST", "127.0.0.1:1")
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:59317",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog", "gelf"},
+	}
+	err = lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	conn, err := net.Dial("udp", "127.0.0.1:59317")
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	_, err = conn.Write([]byte(fmt.Sprintf("<30>2015-06-05T16:13:47Z myhost docker/%s: mymsg\n", s.id)))
+	c.Assert(err, check.IsNil)
+	var lines []string
+	timeout := time.After(5 * time.Second)
+	for len(lines) < 2 {
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for dry-run output, got %q", lines)
+		case <-time.After(10 * time.Millisecond):
+		}
+		data, err := ioutil.ReadFile(path)
+		c.Assert(err, check.IsNil)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	sort.Strings(lines)
+	c.Assert(lines[0], check.Matches, `\[dry-run\] gelf 127\.0\.0\.1:1 \{.*"short_message":"mymsg".*\}`)
+	c.Assert(lines[1], check.Matches, `\[dry-run\] syslog tcp://127\.0\.0\.1:1 <30>Jun  5 \d\d:13:47 \w+ coolappname\[procx\]: mymsg`)
+	received, forwarded := lf.Progress()
+	c.Assert(received, check.Equals, uint64(2))
+	c.Assert(forwarded, check.Equals, uint64(2))
+}
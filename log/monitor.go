@@ -5,12 +5,12 @@
 package log
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
-	stdSyslog "log/syslog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,6 +22,7 @@ import (
 
 	"github.com/tsuru/bs/bslog"
 	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/supervisor"
 	"gopkg.in/mcuadros/go-syslog.v2"
 	"gopkg.in/mcuadros/go-syslog.v2/format"
 )
@@ -30,6 +31,11 @@ const (
 	severityMask = 0x07
 	facilityMask = 0xf8
 
+	// Same values as log/syslog, which isn't available on Windows.
+	syslogFacilityDaemon = 3 << 3
+	syslogSeverityInfo   = 6
+	syslogSeverityErr    = 3
+
 	podContainerName    = "POD"
 	kubeSystemNamespace = "kube-system"
 )
@@ -122,34 +128,62 @@ func (m *fileMonitor) updatePos() {
 
 func (m *fileMonitor) streamOutput() {
 	defer close(m.streamDone)
-	dec := json.NewDecoder(m.reader)
-	var lineData logLine
-	for {
-		err := dec.Decode(&lineData)
-		if err != nil {
-			if err != io.EOF {
-				bslog.Errorf("error decoding log file line: %v", err)
+	reader := bufio.NewReader(m.reader)
+	supervisor.Run("log file monitor", func() {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				m.handleLine(line)
+			}
+			if err != nil {
+				if err != io.EOF {
+					bslog.Errorf("error reading log file %q: %v", m.path, err)
+				}
+				return
 			}
-			return
-		}
-		timeNano := lineData.Time.UnixNano()
-		if timeNano <= m.loadedLastTime {
-			continue
 		}
-		facility := stdSyslog.LOG_DAEMON
-		severity := stdSyslog.LOG_INFO
-		if lineData.Stream != "stdout" {
-			severity = stdSyslog.LOG_ERR
+	})
+}
+
+// handleLine handles a line of a Docker json-file log. Invalid lines are
+// skipped, as they're written by Docker one per line, so the following lines
+// can still be read, and written to the dead-letter destination, if any.
+func (m *fileMonitor) handleLine(line []byte) {
+	lineData, err := parseLogFileLine(line)
+	if err != nil {
+		bslog.Debugf("error decoding log file line in %q: %v", m.path, err)
+		if dl, ok := m.handler.(interface {
+			deadLetter(string, []byte, error)
+		}); ok {
+			dl.deadLetter(deadLetterKubernetes, line, err)
 		}
-		pr := int((facility & facilityMask) | (severity & severityMask))
-		atomic.StoreInt64(&m.lastTime, timeNano)
-		m.handler.Handle(format.LogParts{"parts": &rawLogParts{
-			content:   bytes.TrimSpace(lineData.Log),
-			ts:        lineData.Time,
-			priority:  []byte(strconv.Itoa(pr)),
-			container: m.container,
-		}}, 0, nil)
+		return
+	}
+	timeNano := lineData.Time.UnixNano()
+	if timeNano <= m.loadedLastTime {
+		return
+	}
+	facility := syslogFacilityDaemon
+	severity := syslogSeverityInfo
+	if lineData.Stream != "stdout" {
+		severity = syslogSeverityErr
 	}
+	pr := int((facility & facilityMask) | (severity & severityMask))
+	atomic.StoreInt64(&m.lastTime, timeNano)
+	m.handler.Handle(format.LogParts{"parts": &rawLogParts{
+		content:   bytes.TrimSpace(lineData.Log),
+		ts:        lineData.Time,
+		priority:  []byte(strconv.Itoa(pr)),
+		container: m.container,
+	}}, 0, nil)
+}
+
+// parseLogFileLine decodes a line of a Docker json-file log. The log content
+// may point into line.
+func parseLogFileLine(line []byte) (logLine, error) {
+	var lineData logLine
+	err := json.Unmarshal(line, &lineData)
+	return lineData, err
 }
 
 func (m *fileMonitor) alive() bool {
@@ -273,13 +307,16 @@ func (s *kubernetesLogStreamer) watchOnce() {
 			entry.namespace == kubeSystemNamespace {
 			continue
 		}
-		_, err = s.client.GetAppContainer(entry.containerID, true)
+		cont, err := s.client.GetIdentifiedContainer(entry.containerID, true)
 		if err != nil {
 			if err != container.ErrTsuruVariablesNotFound {
 				bslog.Errorf("unable to get container info for %q: %s", f, err)
 			}
 			continue
 		}
+		if cont.Excluded() {
+			continue
+		}
 		m := s.monitors[entry.containerID]
 		if m != nil && !m.alive() {
 			m = nil
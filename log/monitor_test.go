@@ -94,6 +94,36 @@ func (s *S) TestFileMonitorRun(c *check.C) {
 	c.Assert(m.alive(), check.Equals, true)
 }
 
+func (s *S) TestFileMonitorRunSkipsInvalidLines(c *check.C) {
+	f, err := ioutil.TempFile("", "bs-file-monitor")
+	c.Assert(err, check.IsNil)
+	defer os.Remove(f.Name())
+	_, err = f.Write([]byte(`{"log":"msg1\n","stream":"stderr","time":"2017-03-21T21:28:22.0Z"}
+{"log":"broken\n","stream":"std
+{"log":"msg2\n","stream":"stdout","time":"invalid"}
+{"log":"msg3\n","stream":"stdout","time":"2017-03-21T21:28:42.0Z"}
+`))
+	c.Assert(err, check.IsNil)
+	c.Assert(f.Close(), check.IsNil)
+	th := &testHandler{parts: make(chan format.LogParts)}
+	m, err := newFileMonitor(th, f.Name(), "cont1")
+	c.Assert(err, check.IsNil)
+	err = m.start()
+	c.Assert(err, check.IsNil)
+	m.run()
+	defer stopWaitTimeout(c, m)
+	ts0, _ := time.Parse(time.RFC3339, "2017-03-21T21:28:22Z")
+	expectedMessages := []rawLogParts{
+		{content: []byte("msg1"), ts: ts0, container: []byte("cont1"), priority: []byte("27")},
+		{content: []byte("msg3"), ts: ts0.Add(20 * time.Second), container: []byte("cont1"), priority: []byte("30")},
+	}
+	for _, expected := range expectedMessages {
+		parts := partsTimeout(c, th.parts)
+		c.Check(parts["parts"], check.DeepEquals, &expected)
+	}
+	c.Assert(m.alive(), check.Equals, true)
+}
+
 func (s *S) TestFileMonitorRunOnTruncate(c *check.C) {
 	fName := withTempFile(c)
 	defer os.Remove(fName)
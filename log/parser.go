@@ -16,9 +16,9 @@ const lineparser_error int = 0
 const lineparser_en_main int = 1
 
 //line parser.rl:12
-func parseLogLine(data []byte) [][]byte {
-	entries := make([][]byte, 7)
-	starts := make([]int, 7)
+func parseLogLine(data []byte, entries *[7][]byte) {
+	*entries = [7][]byte{}
+	var starts [7]int
 	cs, p, pe := 0, 0, len(data)
 	eof := pe
 	push := func(v int) {
@@ -997,7 +997,4 @@ func parseLogLine(data []byte) [][]byte {
 		{
 		}
 	}
-
-//line parser.rl:33
-	return entries
 }
@@ -10,9 +10,9 @@ package log
     machine lineparser;
     write data;
 }%%
-func parseLogLine(data []byte) [][]byte {
-    entries := make([][]byte, 7)
-    starts := make([]int, 7)
+func parseLogLine(data []byte, entries *[7][]byte) {
+    *entries = [7][]byte{}
+    var starts [7]int
     cs, p, pe := 0, 0, len(data)
     eof := pe
     push := func(v int) {
@@ -31,5 +31,4 @@ func parseLogLine(data []byte) [][]byte {
         write init;
         write exec;
     }%%
-    return entries
 }
@@ -0,0 +1,108 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"strings"
+
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+)
+
+const (
+	logPriorityLabel  = "bs.tsuru.io/log-priority"
+	logRetentionLabel = "logging.retention"
+
+	defaultLowPriorityMaxBuffer = 50
+)
+
+// logClass is the priority class of the logs of a container, set in the
+// bs.tsuru.io/log-priority label. Under backpressure, messages of high
+// priority containers are forwarded before the others, messages of low
+// priority containers are the first ones dropped, and part of the buffer of
+// each destination may be reserved to high priority ones.
+type logClass int
+
+const (
+	classNormal logClass = iota
+	classHigh
+	classLow
+)
+
+func (c logClass) String() string {
+	switch c {
+	case classHigh:
+		return "high"
+	case classLow:
+		return "low"
+	}
+	return "normal"
+}
+
+// containerLogClass returns the priority class set in the labels of cont,
+// normal when absent or invalid.
+func containerLogClass(cont *container.Container) logClass {
+	if cont.Config == nil {
+		return classNormal
+	}
+	switch cont.Config.Labels[logPriorityLabel] {
+	case "high":
+		return classHigh
+	case "low":
+		return classLow
+	}
+	return classNormal
+}
+
+// containerLogRetention returns the retention hint set in the
+// logging.retention label of cont, like 7d, forwarded with its messages so
+// downstream storage can apply retention policies per app.
+func containerLogRetention(cont *container.Container) string {
+	if cont.Config == nil {
+		return ""
+	}
+	return strings.TrimSpace(cont.Config.Labels[logRetentionLabel])
+}
+
+// classLimits holds the max number of messages waiting in a shard buffer for
+// a message of each class to be accepted, as a percentage of its capacity.
+type classLimits struct {
+	normal int
+	low    int
+}
+
+func newClassLimits() classLimits {
+	reserved := config.IntEnvOrDefault(0, "LOG_HIGH_PRIORITY_RESERVED_BUFFER")
+	low := config.IntEnvOrDefault(defaultLowPriorityMaxBuffer, "LOG_LOW_PRIORITY_MAX_BUFFER")
+	return classLimits{normal: clampPercent(100 - reserved), low: clampPercent(low)}
+}
+
+// limit returns how many messages may be waiting in a buffer with capacity
+// for a message of class to be accepted, never less than one.
+func (l classLimits) limit(capacity int, class logClass) int {
+	var n int
+	switch class {
+	case classHigh:
+		return capacity
+	case classLow:
+		n = capacity * l.low / 100
+	default:
+		n = capacity * l.normal / 100
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func clampPercent(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 100 {
+		return 100
+	}
+	return v
+}
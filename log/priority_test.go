@@ -0,0 +1,139 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/container"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestContainerLogClass(c *check.C) {
+	cont := &container.Container{}
+	c.Assert(containerLogClass(cont), check.Equals, classNormal)
+	tests := map[string]logClass{
+		"high":    classHigh,
+		"low":     classLow,
+		"normal":  classNormal,
+		"invalid": classNormal,
+	}
+	for value, expected := range tests {
+		cont = &container.Container{Container: docker.Container{Config: &docker.Config{
+			Labels: map[string]string{logPriorityLabel: value},
+		}}}
+		c.Check(containerLogClass(cont), check.Equals, expected)
+	}
+}
+
+func (s *S) TestContainerLogRetention(c *check.C) {
+	c.Assert(containerLogRetention(&container.Container{}), check.Equals, "")
+	cont := &container.Container{Container: docker.Container{Config: &docker.Config{
+		Labels: map[string]string{logRetentionLabel: " 7d "},
+	}}}
+	c.Assert(containerLogRetention(cont), check.Equals, "7d")
+}
+
+func (s *S) TestClassLimits(c *check.C) {
+	l := newClassLimits()
+	c.Assert(l.limit(100, classHigh), check.Equals, 100)
+	c.Assert(l.limit(100, classNormal), check.Equals, 100)
+	c.Assert(l.limit(100, classLow), check.Equals, 50)
+	c.Assert(l.limit(1, classLow), check.Equals, 1)
+	os.Setenv("LOG_HIGH_PRIORITY_RESERVED_BUFFER", "10")
+	defer os.Unsetenv("LOG_HIGH_PRIORITY_RESERVED_BUFFER")
+	os.Setenv("LOG_LOW_PRIORITY_MAX_BUFFER", "200")
+	defer os.Unsetenv("LOG_LOW_PRIORITY_MAX_BUFFER")
+	l = newClassLimits()
+	c.Assert(l.limit(100, classNormal), check.Equals, 90)
+	c.Assert(l.limit(100, classLow), check.Equals, 100)
+}
+
+func (s *S) TestMessageQueueClasses(c *check.C) {
+	os.Setenv("LOG_HIGH_PRIORITY_RESERVED_BUFFER", "10")
+	defer os.Unsetenv("LOG_HIGH_PRIORITY_RESERVED_BUFFER")
+	block := make(chan struct{})
+	defer close(block)
+	q := newMessageQueue(func() forwarderBackend {
+		return &blockingForwarder{block: block}
+	}, 1, 10, 0)
+	defer q.stop()
+	var accepted int
+	for i := 0; i < 20; i++ {
+		if q.send("low", i, classLow) {
+			accepted++
+		}
+	}
+	c.Assert(accepted <= 6, check.Equals, true)
+	for q.send("normal", 0, classNormal) {
+	}
+	c.Assert(q.send("high", 0, classHigh), check.Equals, true)
+}
+
+// gatedForwarder records the messages it receives, blocking on each one until
+// release is closed.
+type gatedForwarder struct {
+	fakeForwarder
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *gatedForwarder) process(conn net.Conn, msg LogMessage) error {
+	f.fakeForwarder.process(conn, msg)
+	f.once.Do(func() { close(f.started) })
+	<-f.release
+	return nil
+}
+
+func (s *S) TestMessageQueueForwardsHighPriorityFirst(c *check.C) {
+	f := &gatedForwarder{started: make(chan struct{}), release: make(chan struct{})}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 1, 10, 0)
+	defer q.stop()
+	c.Assert(q.send("normal", "n0", classNormal), check.Equals, true)
+	<-f.started
+	for _, msg := range []string{"n1", "n2", "n3"} {
+		c.Assert(q.send("normal", msg, classNormal), check.Equals, true)
+	}
+	c.Assert(q.send("low", "l1", classLow), check.Equals, true)
+	for _, msg := range []string{"h1", "h2"} {
+		c.Assert(q.send("high", msg, classHigh), check.Equals, true)
+	}
+	close(f.release)
+	timeout := time.After(5 * time.Second)
+	for len(f.received()) < 7 {
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for messages, got %v", f.received())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Assert(f.received(), check.DeepEquals, []LogMessage{"n0", "h1", "h2", "n1", "n2", "n3", "l1"})
+}
+
+func (s *S) TestMessageQueueClassesShareBuffer(c *check.C) {
+	f := &gatedForwarder{started: make(chan struct{}), release: make(chan struct{})}
+	defer close(f.release)
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 1, 4, 0)
+	defer q.stop()
+	c.Assert(q.send("high", 0, classHigh), check.Equals, true)
+	<-f.started
+	var accepted int
+	for i := 0; i < 10; i++ {
+		if q.send("high", i, classHigh) {
+			accepted++
+		}
+	}
+	c.Assert(accepted, check.Equals, 4)
+	c.Assert(q.send("normal", 0, classNormal), check.Equals, false)
+}
@@ -0,0 +1,372 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/tsuru/bs/dryrun"
+)
+
+var (
+	errFlushTimeout = errors.New("timeout waiting for buffered messages to be forwarded")
+	errNotReady     = errors.New("timeout waiting for the first connection")
+)
+
+// messageQueue spreads the messages sent to a single destination among one or
+// more shards, each one with its own channel, worker goroutine and
+// connection, so the goroutines handling incoming logs don't all contend on
+// the same channel. Messages are routed to shards by key, keeping the order of
+// messages sharing the same key. Each shard also has a channel for high
+// priority messages, forwarded before the ones waiting in its other channel.
+type messageQueue struct {
+	name         string
+	chans        []chan<- LogMessage
+	highChans    []chan<- LogMessage
+	quits        []chan<- bool
+	ready        []<-chan error
+	dropped      uint64
+	totalDropped uint64
+	received     uint64
+	progress     forwardProgress
+	limits       classLimits
+	limiter      *bandwidthLimiter
+	pause        pauseGate
+}
+
+// newMessageQueue starts the shards of a queue, calling newForwarder once per
+// shard. The buffer is split evenly among the shards, which share the
+// bandwidth cap in maxBytesPerSecond, when positive. In dry-run mode the
+// forwarders are replaced by ones writing to the dry-run output. The shards
+// connect in background, waitReady waits for them.
+func newMessageQueue(newForwarder func() forwarderBackend, shards, bufferSize, maxBytesPerSecond int) *messageQueue {
+	if shards < 1 {
+		shards = 1
+	}
+	shardBuffer := bufferSize / shards
+	if shardBuffer < 1 {
+		shardBuffer = 1
+	}
+	q := &messageQueue{
+		limits:  newClassLimits(),
+		limiter: newBandwidthLimiter(maxBytesPerSecond),
+		pause:   newPauseGate(),
+	}
+	for i := 0; i < shards; i++ {
+		forwarder := newForwarder()
+		if dryrun.Enabled() {
+			forwarder = &dryRunForwarder{name: forwarderName(forwarder)}
+		}
+		if q.name == "" {
+			q.name = forwarderName(forwarder)
+		}
+		ch, high, quit, ready := processMessages(forwarder, shardBuffer, q)
+		q.chans = append(q.chans, ch)
+		q.highChans = append(q.highChans, high)
+		q.quits = append(q.quits, quit)
+		q.ready = append(q.ready, ready)
+	}
+	return q
+}
+
+// waitReady waits for the first connection of every shard, returning the
+// error of the first one failing, or errNotReady when some shard is still
+// connecting by the deadline. It must be called only once.
+func (q *messageQueue) waitReady(deadline <-chan time.Time) error {
+	for _, ready := range q.ready {
+		select {
+		case err := <-ready:
+			if err != nil {
+				return err
+			}
+		case <-deadline:
+			return errNotReady
+		}
+	}
+	return nil
+}
+
+// send enqueues msg in the shard chosen by key, without blocking. High
+// priority messages go to the high priority channel of the shard, sharing
+// its buffer size with the other classes. It returns false when the shard
+// buffer is full, for messages of the given class, and the message was
+// dropped.
+func (q *messageQueue) send(key string, msg LogMessage, class logClass) bool {
+	atomic.AddUint64(&q.received, 1)
+	var i int
+	if len(q.chans) > 1 {
+		i = shardIndex(key, len(q.chans))
+	}
+	ch := q.chans[i]
+	queued := len(ch) + len(q.highChans[i])
+	if class == classHigh {
+		ch = q.highChans[i]
+	}
+	if queued >= q.limits.limit(cap(ch), class) {
+		atomic.AddUint64(&q.dropped, 1)
+		atomic.AddUint64(&q.totalDropped, 1)
+		return false
+	}
+	select {
+	case ch <- msg:
+		return true
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+		atomic.AddUint64(&q.totalDropped, 1)
+		return false
+	}
+}
+
+// takeDropped returns the number of messages dropped since the last call.
+func (q *messageQueue) takeDropped() uint64 {
+	return atomic.SwapUint64(&q.dropped, 0)
+}
+
+// counts returns the number of messages sent to the queue, including the
+// dropped ones, and the number of messages forwarded by its shards.
+func (q *messageQueue) counts() (received, forwarded uint64) {
+	return atomic.LoadUint64(&q.received), atomic.LoadUint64(&q.progress.count)
+}
+
+// reconnect closes the current connection of every shard, forcing them to
+// reconnect. Shards blocked writing to a stuck connection are unblocked with
+// an error.
+func (q *messageQueue) reconnect() {
+	q.progress.closeConns()
+}
+
+// state describes the queue for state dumps: the messages waiting in the
+// buffers of its shards, its counters and the last error writing to the
+// destination.
+func (q *messageQueue) state() map[string]interface{} {
+	var queued, capacity int
+	for i, ch := range q.chans {
+		queued += len(ch) + len(q.highChans[i])
+		capacity += cap(ch)
+	}
+	received, forwarded := q.counts()
+	state := map[string]interface{}{
+		"destination": q.name,
+		"shards":      len(q.chans),
+		"queued":      queued,
+		"capacity":    capacity,
+		"received":    received,
+		"forwarded":   forwarded,
+		"dropped":     atomic.LoadUint64(&q.totalDropped),
+		"paused":      q.pause.isPaused(),
+	}
+	if err, errTime := q.progress.lastError(); err != "" {
+		state["last_error"] = err
+		state["last_error_time"] = errTime
+	}
+	return state
+}
+
+// flushRequest is sent through the shards of a queue to flush their
+// connections once the messages before it are forwarded. The result of the
+// flush is sent back on the channel.
+type flushRequest chan error
+
+// flush waits for the messages in the buffers of the queue to be forwarded,
+// flushing the connections of its shards afterwards, until deadline.
+func (q *messageQueue) flush(deadline <-chan time.Time) error {
+	reqs := make([]flushRequest, len(q.chans))
+	for i, ch := range q.chans {
+		reqs[i] = make(flushRequest, 1)
+		select {
+		case ch <- reqs[i]:
+		case <-deadline:
+			return errFlushTimeout
+		}
+	}
+	for _, req := range reqs {
+		select {
+		case err := <-req:
+			if err != nil {
+				return err
+			}
+		case <-deadline:
+			return errFlushTimeout
+		}
+	}
+	return nil
+}
+
+// flushConn writes the data buffered in conn, if any.
+func flushConn(conn net.Conn) error {
+	if f, ok := conn.(interface {
+		Flush() error
+	}); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// pauseGate pauses the shards of a queue. Paused shards close their
+// connections and stop forwarding, leaving messages in their buffers, until
+// resumed.
+type pauseGate struct {
+	mu      sync.Mutex
+	paused  chan struct{}
+	resumed chan struct{}
+}
+
+func newPauseGate() pauseGate {
+	resumed := make(chan struct{})
+	close(resumed)
+	return pauseGate{paused: make(chan struct{}), resumed: resumed}
+}
+
+// channels returns a channel closed when the queue is paused and another
+// closed when it's running.
+func (g *pauseGate) channels() (paused, resumed <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused, g.resumed
+}
+
+// set pauses or resumes the queue, returning false when it was already in
+// the requested state.
+func (g *pauseGate) set(pause bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.isPausedLocked() == pause {
+		return false
+	}
+	if pause {
+		close(g.paused)
+		g.resumed = make(chan struct{})
+	} else {
+		close(g.resumed)
+		g.paused = make(chan struct{})
+	}
+	return true
+}
+
+func (g *pauseGate) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.isPausedLocked()
+}
+
+func (g *pauseGate) isPausedLocked() bool {
+	select {
+	case <-g.paused:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *messageQueue) stop() {
+	for _, quit := range q.quits {
+		close(quit)
+	}
+}
+
+// forwardProgress keeps track of the messages forwarded by the shards of a
+// queue and of their open connections.
+type forwardProgress struct {
+	count   uint64
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	err     string
+	errTime time.Time
+}
+
+func (p *forwardProgress) forwarded() {
+	atomic.AddUint64(&p.count, 1)
+}
+
+func (p *forwardProgress) failed(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err.Error()
+	p.errTime = time.Now()
+}
+
+func (p *forwardProgress) lastError() (string, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err, p.errTime
+}
+
+func (p *forwardProgress) track(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns == nil {
+		p.conns = make(map[net.Conn]struct{})
+	}
+	p.conns[conn] = struct{}{}
+}
+
+func (p *forwardProgress) untrack(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+func (p *forwardProgress) closeConns() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+}
+
+// shardIndex hashes key using FNV-1a.
+func shardIndex(key string, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
+
+// dryRunForwarder writes the messages that would be forwarded by a forwarder
+// to the dry-run output.
+type dryRunForwarder struct {
+	name string
+}
+
+func (f *dryRunForwarder) connect() (net.Conn, error) {
+	return nil, nil
+}
+
+func (f *dryRunForwarder) process(conn net.Conn, msg LogMessage) error {
+	var payload []byte
+	switch m := msg.(type) {
+	case bufferWithIdx:
+		payload = m.buffer
+	case *gelf.Message:
+		var buf bytes.Buffer
+		if err := m.MarshalJSONBuf(&buf); err != nil {
+			return err
+		}
+		payload = buf.Bytes()
+	default:
+		var err error
+		if payload, err = json.Marshal(m); err != nil {
+			return err
+		}
+	}
+	return dryrun.Write(f.name, payload)
+}
+
+func (f *dryRunForwarder) close(conn net.Conn) {}
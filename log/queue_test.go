@@ -0,0 +1,415 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	"gopkg.in/check.v1"
+)
+
+type queuedMsg struct {
+	key string
+	n   int
+}
+
+type fakeForwarder struct {
+	mu       sync.Mutex
+	messages []LogMessage
+}
+
+func (f *fakeForwarder) connect() (net.Conn, error) {
+	return nil, nil
+}
+
+func (f *fakeForwarder) process(conn net.Conn, msg LogMessage) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+func (f *fakeForwarder) close(conn net.Conn) {}
+
+func (f *fakeForwarder) received() []LogMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]LogMessage(nil), f.messages...)
+}
+
+func (s *S) TestMessageQueueShards(c *check.C) {
+	var forwarders []*fakeForwarder
+	q := newMessageQueue(func() forwarderBackend {
+		f := &fakeForwarder{}
+		forwarders = append(forwarders, f)
+		return f
+	}, 4, 100, 0)
+	defer q.stop()
+	c.Assert(q.chans, check.HasLen, 4)
+	c.Assert(forwarders, check.HasLen, 4)
+	keys := []string{"c1", "c2", "c3", "c4", "c5", "c6"}
+	for i := 0; i < 10; i++ {
+		for _, k := range keys {
+			c.Assert(q.send(k, queuedMsg{key: k, n: i}, classNormal), check.Equals, true)
+		}
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		var total int
+		for _, f := range forwarders {
+			total += len(f.received())
+		}
+		if total == len(keys)*10 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for messages, got %d", total)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	for _, k := range keys {
+		f := forwarders[shardIndex(k, 4)]
+		var got []int
+		for _, msg := range f.received() {
+			if m := msg.(queuedMsg); m.key == k {
+				got = append(got, m.n)
+			}
+		}
+		c.Assert(got, check.DeepEquals, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	}
+}
+
+func (s *S) TestMessageQueueFull(c *check.C) {
+	block := make(chan struct{})
+	defer close(block)
+	q := newMessageQueue(func() forwarderBackend {
+		return &blockingForwarder{block: block}
+	}, 2, 2, 0)
+	defer q.stop()
+	var dropped int
+	for i := 0; i < 10; i++ {
+		if !q.send("same-key", i, classNormal) {
+			dropped++
+		}
+	}
+	// One message is held by the blocked worker and one is buffered.
+	c.Assert(dropped >= 8, check.Equals, true)
+	c.Assert(q.takeDropped(), check.Equals, uint64(dropped))
+	c.Assert(q.takeDropped(), check.Equals, uint64(0))
+}
+
+func (s *S) TestShardIndex(c *check.C) {
+	c.Assert(shardIndex("abc", 1), check.Equals, 0)
+	c.Assert(shardIndex("abc", 8), check.Equals, shardIndex("abc", 8))
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		idx := shardIndex(fmt.Sprintf("container-%d", i), 4)
+		c.Assert(idx >= 0 && idx < 4, check.Equals, true)
+		seen[idx] = true
+	}
+	c.Assert(seen, check.HasLen, 4)
+}
+
+func (s *S) TestSyslogBackendForwardShards(c *check.C) {
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "udp://127.0.0.1:1514")
+	os.Setenv("LOG_FORWARD_SHARDS", "3")
+	b := &syslogBackend{}
+	err := b.initialize()
+	c.Assert(err, check.IsNil)
+	defer b.stop()
+	c.Assert(b.queues, check.HasLen, 1)
+	c.Assert(b.queues[0].chans, check.HasLen, 3)
+}
+
+func (s *S) TestGelfBackendShardsByContainer(c *check.C) {
+	var forwarders []*fakeForwarder
+	b := &gelfBackend{nextNotify: time.NewTimer(time.Minute)}
+	b.queue = newMessageQueue(func() forwarderBackend {
+		f := &fakeForwarder{}
+		forwarders = append(forwarders, f)
+		return f
+	}, 4, 100, 0)
+	defer b.queue.stop()
+	var ids []string
+	for i := 0; i < 20; i++ {
+		ids = append(ids, fmt.Sprintf("container-%d", i))
+	}
+	for _, id := range ids {
+		b.sendMessage(&rawLogParts{content: []byte(id), priority: []byte("14"), shardKey: id}, "app", "web", "node1")
+	}
+	b.sendMessage(&rawLogParts{content: []byte("kernel"), priority: []byte("14")}, "kernel", "", "node1")
+	timeout := time.After(5 * time.Second)
+	for {
+		var total int
+		for _, f := range forwarders {
+			total += len(f.received())
+		}
+		if total == len(ids)+1 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for messages, got %d", total)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	shards := map[string]int{}
+	for i, f := range forwarders {
+		for _, msg := range f.received() {
+			shards[msg.(*gelf.Message).Short] = i
+		}
+	}
+	for _, id := range ids {
+		c.Assert(shards[id], check.Equals, shardIndex(id, 4), check.Commentf("container %s", id))
+	}
+	c.Assert(shards["kernel"], check.Equals, shardIndex("node1", 4))
+}
+
+type blockingForwarder struct {
+	fakeForwarder
+	block chan struct{}
+}
+
+func (f *blockingForwarder) process(conn net.Conn, msg LogMessage) error {
+	<-f.block
+	return nil
+}
+
+// pipeForwarder forwards messages to in-memory connections nobody reads
+// from, so every write blocks until the connection is closed.
+type pipeForwarder struct {
+	fakeForwarder
+	connects int32
+}
+
+func (f *pipeForwarder) connect() (net.Conn, error) {
+	atomic.AddInt32(&f.connects, 1)
+	conn, _ := net.Pipe()
+	return conn, nil
+}
+
+func (f *pipeForwarder) process(conn net.Conn, msg LogMessage) error {
+	_, err := conn.Write([]byte("msg"))
+	return err
+}
+
+func (f *pipeForwarder) close(conn net.Conn) {
+	conn.Close()
+}
+
+func (s *S) TestMessageQueueCountsAndReconnect(c *check.C) {
+	f := &pipeForwarder{}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 1, 10, 0)
+	defer q.stop()
+	c.Assert(q.send("c1", "msg", classNormal), check.Equals, true)
+	received, forwarded := q.counts()
+	c.Assert(received, check.Equals, uint64(1))
+	c.Assert(forwarded, check.Equals, uint64(0))
+	timeout := time.After(5 * time.Second)
+	for atomic.LoadInt32(&f.connects) < 2 {
+		q.reconnect()
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for reconnection")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	state := q.state()
+	c.Assert(state["last_error"], check.Equals, "io: read/write on closed pipe")
+	delete(state, "last_error")
+	c.Assert(state["last_error_time"], check.FitsTypeOf, time.Time{})
+	delete(state, "last_error_time")
+	c.Assert(state, check.DeepEquals, map[string]interface{}{
+		"destination": "*log.pipeForwarder",
+		"shards":      1,
+		"queued":      0,
+		"capacity":    10,
+		"received":    uint64(1),
+		"forwarded":   uint64(0),
+		"dropped":     uint64(0),
+		"paused":      false,
+	})
+}
+
+func (s *S) TestMessageQueuePause(c *check.C) {
+	f := &fakeForwarder{}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 1, 10, 0)
+	defer q.stop()
+	c.Assert(q.pause.set(true), check.Equals, true)
+	c.Assert(q.pause.set(true), check.Equals, false)
+	c.Assert(q.state()["paused"], check.Equals, true)
+	for i := 0; i < 3; i++ {
+		c.Assert(q.send("c1", i, classNormal), check.Equals, true)
+	}
+	time.Sleep(50 * time.Millisecond)
+	_, forwarded := q.counts()
+	c.Assert(forwarded, check.Equals, uint64(0))
+	c.Assert(q.pause.set(false), check.Equals, true)
+	c.Assert(q.pause.set(false), check.Equals, false)
+	timeout := time.After(5 * time.Second)
+	for {
+		if _, forwarded = q.counts(); forwarded == 3 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for messages, forwarded %d", forwarded)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Assert(f.received(), check.DeepEquals, []LogMessage{0, 1, 2})
+}
+
+func (s *S) TestLogForwarderProgressSkipsPaused(c *check.C) {
+	block := make(chan struct{})
+	defer close(block)
+	running := newMessageQueue(func() forwarderBackend {
+		return &blockingForwarder{block: block}
+	}, 1, 10, 0)
+	defer running.stop()
+	paused := newMessageQueue(func() forwarderBackend {
+		return &blockingForwarder{block: block}
+	}, 1, 10, 0)
+	defer paused.stop()
+	paused.pause.set(true)
+	lf := LogForwarder{backends: []logBackend{&gelfBackend{queue: running}, &gelfBackend{queue: paused}}}
+	running.send("c1", 0, classNormal)
+	paused.send("c1", 0, classNormal)
+	paused.send("c1", 1, classNormal)
+	received, _ := lf.Progress()
+	c.Assert(received, check.Equals, uint64(1))
+}
+
+func (s *S) TestLogForwarderPauseUnknownDestination(c *check.C) {
+	var lf LogForwarder
+	c.Assert(lf.Pause("syslog udp://127.0.0.1:1514"), check.Equals, ErrUnknownDestination)
+	c.Assert(lf.Resume("syslog udp://127.0.0.1:1514"), check.Equals, ErrUnknownDestination)
+}
+
+func (s *S) TestMessageQueueCountsForwarded(c *check.C) {
+	f := &fakeForwarder{}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 2, 10, 0)
+	defer q.stop()
+	for i := 0; i < 5; i++ {
+		c.Assert(q.send(fmt.Sprintf("c%d", i), i, classNormal), check.Equals, true)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		received, forwarded := q.counts()
+		c.Assert(received, check.Equals, uint64(5))
+		if forwarded == 5 {
+			break
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for messages, forwarded %d", forwarded)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+type flushingConn struct {
+	net.Conn
+	flushes int32
+}
+
+func (c *flushingConn) Flush() error {
+	atomic.AddInt32(&c.flushes, 1)
+	return nil
+}
+
+type flushForwarder struct {
+	fakeForwarder
+	conn *flushingConn
+}
+
+func (f *flushForwarder) connect() (net.Conn, error) {
+	return f.conn, nil
+}
+
+func (s *S) TestMessageQueueFlush(c *check.C) {
+	f := &flushForwarder{conn: &flushingConn{}}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 2, 10, 0)
+	defer q.stop()
+	for i := 0; i < 5; i++ {
+		c.Assert(q.send(fmt.Sprintf("c%d", i), i, classNormal), check.Equals, true)
+	}
+	err := q.flush(time.After(5 * time.Second))
+	c.Assert(err, check.IsNil)
+	c.Assert(f.received(), check.HasLen, 5)
+	c.Assert(atomic.LoadInt32(&f.conn.flushes), check.Equals, int32(2))
+	_, forwarded := q.counts()
+	c.Assert(forwarded, check.Equals, uint64(5))
+}
+
+func (s *S) TestMessageQueueFlushTimeout(c *check.C) {
+	f := &fakeForwarder{}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 1, 10, 0)
+	defer q.stop()
+	q.pause.set(true)
+	err := q.flush(time.After(50 * time.Millisecond))
+	c.Assert(err, check.Equals, errFlushTimeout)
+}
+
+// slowForwarder connects only when unblocked, failing with err.
+type slowForwarder struct {
+	fakeForwarder
+	unblock chan struct{}
+	err     error
+}
+
+func (f *slowForwarder) connect() (net.Conn, error) {
+	<-f.unblock
+	return nil, f.err
+}
+
+func (s *S) TestMessageQueueWaitReady(c *check.C) {
+	f := &slowForwarder{unblock: make(chan struct{})}
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 3, 10, 0)
+	defer q.stop()
+	c.Assert(q.waitReady(time.After(50*time.Millisecond)), check.Equals, errNotReady)
+	c.Assert(q.send("c1", "msg", classNormal), check.Equals, true)
+	close(f.unblock)
+	c.Assert(q.waitReady(time.After(5*time.Second)), check.IsNil)
+	timeout := time.After(5 * time.Second)
+	for len(f.received()) == 0 {
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for message")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestMessageQueueWaitReadyError(c *check.C) {
+	f := &slowForwarder{unblock: make(chan struct{}), err: fmt.Errorf("connection refused")}
+	close(f.unblock)
+	q := newMessageQueue(func() forwarderBackend {
+		return f
+	}, 2, 10, 0)
+	defer q.stop()
+	c.Assert(q.waitReady(time.After(5*time.Second)), check.ErrorMatches, "connection refused")
+	errMsg, _ := q.progress.lastError()
+	c.Assert(errMsg, check.Equals, "connection refused")
+}
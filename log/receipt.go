@@ -0,0 +1,27 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import "time"
+
+// receiptClock stamps messages as bs receives them, in nanoseconds since the
+// epoch, as the wall clock read when bs started plus the time elapsed since,
+// measured by time.Since with the monotonic clock, so the stamps never go
+// backwards when the wall clock is stepped.
+type receiptClock struct {
+	base time.Time
+}
+
+func newReceiptClock() *receiptClock {
+	return &receiptClock{base: time.Now()}
+}
+
+// stamp sets the receipt time of parts, unless already set.
+func (c *receiptClock) stamp(parts *rawLogParts) {
+	if c == nil || parts.received != 0 {
+		return
+	}
+	parts.received = c.base.UnixNano() + int64(time.Since(c.base))
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestReceiptClockStamp(c *check.C) {
+	clock := newReceiptClock()
+	before := time.Now().UnixNano()
+	var first, second rawLogParts
+	clock.stamp(&first)
+	clock.stamp(&second)
+	after := time.Now().UnixNano()
+	c.Assert(first.received >= clock.base.UnixNano(), check.Equals, true)
+	c.Assert(first.received <= second.received, check.Equals, true)
+	c.Assert(second.received-before <= after-before+int64(time.Millisecond), check.Equals, true)
+	stamped := second.received
+	clock.stamp(&second)
+	c.Assert(second.received, check.Equals, stamped)
+}
+
+func (s *S) TestReceiptClockDisabled(c *check.C) {
+	var clock *receiptClock
+	var parts rawLogParts
+	clock.stamp(&parts)
+	c.Assert(parts.received, check.Equals, int64(0))
+}
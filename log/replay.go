@@ -0,0 +1,81 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// replayMaxPending is the max number of replayed messages waiting in the
+	// backend queues, so replaying large files doesn't overflow the buffers
+	// and drop messages.
+	replayMaxPending uint64 = 1000
+	// replayTimeout is how long Replay waits for the backends to forward
+	// any message before giving up.
+	replayTimeout = time.Minute
+)
+
+// Replay reads syslog messages from r, one per line, in the format sent by
+// the Docker syslog driver, and forwards them through the enabled backends
+// as if they were received by the syslog listener. It's used instead of
+// Start, for recovering logs saved to files by other means while the
+// destinations were unavailable, as bs has no spool of its own, and returns
+// once every message was forwarded, with the number of lines read. Messages
+// of containers that no longer exist are dropped, as their metadata can't be
+// read.
+func (l *LogForwarder) Replay(r io.Reader) (int, error) {
+	defer l.stopWait()
+	err := l.initialize()
+	if err != nil {
+		return 0, err
+	}
+	if len(l.backends) == 0 {
+		return 0, errors.New("no log backend enabled")
+	}
+	maxLine := l.lineLimit.readSize()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 4096), maxLine)
+	scanner.Split(scanLimitedLines(maxLine))
+	var lines int
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if err = l.waitPending(replayMaxPending); err != nil {
+			return lines, err
+		}
+		handleLine(l.formatter, l, scanner.Bytes())
+		lines++
+	}
+	if err = scanner.Err(); err != nil {
+		return lines, err
+	}
+	return lines, l.waitPending(0)
+}
+
+// waitPending blocks until at most max messages sent to the backends weren't
+// forwarded yet, failing if no message is forwarded for replayTimeout.
+func (l *LogForwarder) waitPending(max uint64) error {
+	var lastForwarded uint64
+	lastProgress := time.Now()
+	for {
+		received, forwarded := l.Progress()
+		if received-forwarded <= max {
+			return nil
+		}
+		if forwarded != lastForwarded {
+			lastForwarded = forwarded
+			lastProgress = time.Now()
+		} else if time.Since(lastProgress) > replayTimeout {
+			return fmt.Errorf("%d messages not forwarded, no progress for %s", received-forwarded, replayTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
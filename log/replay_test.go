@@ -0,0 +1,57 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/bs/dryrun"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestLogForwarderReplay(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dry-run.log")
+	err := dryrun.Setup(path)
+	c.Assert(err, check.IsNil)
+	defer dryrun.Disable()
+	os.Setenv("LOG_SYSLOG_FORWARD_ADDRESSES", "tcp://127.0.0.1:1")
+	defer os.Unsetenv("LOG_SYSLOG_FORWARD_ADDRESSES")
+	oldMaxPending := replayMaxPending
+	replayMaxPending = 1
+	defer func() { replayMaxPending = oldMaxPending }()
+	var input string
+	for i := 0; i < 10; i++ {
+		input += fmt.Sprintf("<30>2015-06-05T16:13:47Z myhost docker/%s: msg%d\n\n", s.id, i)
+	}
+	input += "invalid line\n"
+	lf := LogForwarder{
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+	}
+	lines, err := lf.Replay(strings.NewReader(input))
+	c.Assert(err, check.IsNil)
+	c.Assert(lines, check.Equals, 11)
+	received, forwarded := lf.Progress()
+	c.Assert(received, check.Equals, uint64(10))
+	c.Assert(forwarded, check.Equals, uint64(10))
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	output := strings.Split(strings.TrimSpace(string(data)), "\n")
+	c.Assert(output, check.HasLen, 10)
+	for i, line := range output {
+		c.Assert(line, check.Matches, fmt.Sprintf(`\[dry-run\] syslog tcp://127\.0\.0\.1:1 <30>.* coolappname\[procx\]: msg%d`, i))
+	}
+}
+
+func (s *S) TestLogForwarderReplayNoBackends(c *check.C) {
+	lf := LogForwarder{DockerEndpoint: s.dockerServer.URL()}
+	lines, err := lf.Replay(strings.NewReader("line\n"))
+	c.Assert(err, check.ErrorMatches, "no log backend enabled")
+	c.Assert(lines, check.Equals, 0)
+}
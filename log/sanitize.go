@@ -0,0 +1,160 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"unicode/utf8"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+)
+
+const (
+	sanitizeANSI    = "ansi"
+	sanitizeUTF8    = "utf8"
+	sanitizeControl = "control"
+
+	escapeChar = 0x1b
+	hexDigits  = "0123456789abcdef"
+)
+
+// sanitizer cleans the content of log messages before they're forwarded,
+// stripping ANSI escape sequences, replacing invalid UTF-8 and escaping
+// control characters.
+type sanitizer struct {
+	stripANSI     bool
+	fixUTF8       bool
+	escapeControl bool
+}
+
+// newSanitizer returns the sanitizer enabled in the environment, or nil when
+// sanitization is disabled.
+func newSanitizer() *sanitizer {
+	var s sanitizer
+	for _, name := range config.StringsEnvOrDefault(nil, "LOG_SANITIZE") {
+		switch name {
+		case sanitizeANSI:
+			s.stripANSI = true
+		case sanitizeUTF8:
+			s.fixUTF8 = true
+		case sanitizeControl:
+			s.escapeControl = true
+		default:
+			bslog.Warnf("[log forwarder] unknown sanitizer %q", name)
+		}
+	}
+	if !s.stripANSI && !s.fixUTF8 && !s.escapeControl {
+		return nil
+	}
+	return &s
+}
+
+// sanitize returns the cleaned content. Content that doesn't need any change
+// is returned as is, without allocating.
+func (s *sanitizer) sanitize(content []byte) []byte {
+	if !s.needed(content) {
+		return content
+	}
+	out := make([]byte, 0, len(content)+len(content)/8)
+	for i := 0; i < len(content); {
+		c := content[i]
+		if c == escapeChar && s.stripANSI {
+			if n := ansiSequenceLen(content[i:]); n > 0 {
+				i += n
+				continue
+			}
+		}
+		if c < utf8.RuneSelf {
+			if s.escapeControl && isControl(c) {
+				out = appendEscaped(out, c)
+			} else {
+				out = append(out, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(content[i:])
+		if r == utf8.RuneError && size == 1 && s.fixUTF8 {
+			out = append(out, "\uFFFD"...)
+		} else {
+			out = append(out, content[i:i+size]...)
+		}
+		i += size
+	}
+	return out
+}
+
+func (s *sanitizer) needed(content []byte) bool {
+	ascii := true
+	for _, c := range content {
+		if c >= utf8.RuneSelf {
+			ascii = false
+		} else if (c == escapeChar && s.stripANSI) || (s.escapeControl && isControl(c)) {
+			return true
+		}
+	}
+	return !ascii && s.fixUTF8 && !utf8.Valid(content)
+}
+
+// isControl reports whether c is a control character, tabs excluded.
+func isControl(c byte) bool {
+	return (c < 0x20 && c != '\t') || c == 0x7f
+}
+
+func appendEscaped(out []byte, c byte) []byte {
+	switch c {
+	case '\n':
+		return append(out, '\\', 'n')
+	case '\r':
+		return append(out, '\\', 'r')
+	}
+	return append(out, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+}
+
+// ansiSequenceLen returns the length of the ANSI escape sequence at the start
+// of b, or 0 if b doesn't start with a complete sequence. CSI sequences
+// (colors, cursor movement), OSC sequences (window titles, links) and other
+// short escapes are recognized.
+func ansiSequenceLen(b []byte) int {
+	if len(b) < 2 || b[0] != escapeChar {
+		return 0
+	}
+	switch b[1] {
+	case '[':
+		for i := 2; i < len(b); i++ {
+			switch c := b[i]; {
+			case c >= 0x40 && c <= 0x7e:
+				return i + 1
+			case c < 0x20 || c > 0x7e:
+				return 0
+			}
+		}
+		return 0
+	case ']':
+		for i := 2; i < len(b); i++ {
+			if b[i] == 0x07 {
+				return i + 1
+			}
+			if b[i] == escapeChar && i+1 < len(b) && b[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return 0
+	}
+	if b[1] >= 0x40 && b[1] <= 0x5f {
+		return 2
+	}
+	// Escapes with intermediate bytes, like charset selection in "\x1b(B".
+	for i := 1; i < len(b); i++ {
+		switch c := b[i]; {
+		case c >= 0x20 && c <= 0x2f:
+		case c >= 0x30 && c <= 0x7e && i > 1:
+			return i + 1
+		default:
+			return 0
+		}
+	}
+	return 0
+}
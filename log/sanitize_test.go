@@ -0,0 +1,63 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestNewSanitizer(c *check.C) {
+	c.Assert(newSanitizer(), check.IsNil)
+	os.Setenv("LOG_SANITIZE", "ansi, control,unknown")
+	c.Assert(newSanitizer(), check.DeepEquals, &sanitizer{stripANSI: true, escapeControl: true})
+	os.Setenv("LOG_SANITIZE", "unknown")
+	c.Assert(newSanitizer(), check.IsNil)
+}
+
+func (s *S) TestSanitize(c *check.C) {
+	all := &sanitizer{stripANSI: true, fixUTF8: true, escapeControl: true}
+	tests := []struct {
+		s        *sanitizer
+		input    string
+		expected string
+	}{
+		{all, "plain message", "plain message"},
+		{all, "ação válida\tok", "ação válida\tok"},
+		{all, "\x1b[1;31mERROR\x1b[0m failed", "ERROR failed"},
+		{all, "\x1b]0;title\x07msg", "msg"},
+		{all, "\x1b]8;;http://x\x1b\\link", "link"},
+		{all, "\x1b(B\x1b[mmsg", "msg"},
+		{all, "\x1b(", "\\x1b("},
+		{all, "\x1bMmsg", "msg"},
+		{all, "incomplete \x1b[31", "incomplete \\x1b[31"},
+		{all, "bell\x07 nul\x00 del\x7f\r\nend", "bell\\x07 nul\\x00 del\\x7f\\r\\nend"},
+		{all, "bad \xff\xfe utf8 \xe2\x82", "bad \uFFFD\uFFFD utf8 \uFFFD\uFFFD"},
+		{&sanitizer{stripANSI: true}, "\x1b[32mok\x1b[0m\x00\xff", "ok\x00\xff"},
+		{&sanitizer{fixUTF8: true}, "\x1b[32mok\xff", "\x1b[32mok\uFFFD"},
+		{&sanitizer{escapeControl: true}, "\x1b[32mok\xff", "\\x1b[32mok\xff"},
+	}
+	for _, tt := range tests {
+		c.Check(string(tt.s.sanitize([]byte(tt.input))), check.Equals, tt.expected, check.Commentf("%q", tt.input))
+	}
+}
+
+func (s *S) TestSanitizeUnchangedDoesNotCopy(c *check.C) {
+	all := &sanitizer{stripANSI: true, fixUTF8: true, escapeControl: true}
+	content := []byte("ação válida")
+	c.Assert(&all.sanitize(content)[0], check.Equals, &content[0])
+}
+
+func BenchmarkSanitize(b *testing.B) {
+	all := &sanitizer{stripANSI: true, fixUTF8: true, escapeControl: true}
+	content := []byte("2017-06-05 16:13:47 INFO request finished path=/api/v1/users status=200 duration=13ms")
+	b.ReportAllocs()
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		all.sanitize(content)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// sequenceMaxIdle is how long the sequence of a container without messages
+// is kept. The sequence of a container pruned restarts at 1, as when bs
+// restarts.
+const sequenceMaxIdle = time.Hour
+
+// sequencer numbers the messages received from each container, starting at
+// 1, so lost messages can be detected downstream by gaps in the sequence.
+// Messages are numbered as soon as their container is known, so the ones
+// dropped by bs afterwards, like oversized lines or the ones over full
+// buffers, show up as gaps too.
+type sequencer struct {
+	mu        sync.Mutex
+	streams   map[string]*sequenceStream
+	lastPrune time.Time
+}
+
+type sequenceStream struct {
+	last     uint64
+	lastSeen time.Time
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{
+		streams:   make(map[string]*sequenceStream),
+		lastPrune: time.Now(),
+	}
+}
+
+// next returns the next number in the sequence of the container.
+func (s *sequencer) next(id string, now time.Time) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastPrune) > sequenceMaxIdle {
+		for id, stream := range s.streams {
+			if now.Sub(stream.lastSeen) > sequenceMaxIdle {
+				delete(s.streams, id)
+			}
+		}
+		s.lastPrune = now
+	}
+	stream := s.streams[id]
+	if stream == nil {
+		stream = &sequenceStream{}
+		s.streams[id] = stream
+	}
+	stream.last++
+	stream.lastSeen = now
+	return stream.last
+}
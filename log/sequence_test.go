@@ -0,0 +1,110 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+	"gopkg.in/check.v1"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+func (s *S) TestSequencerNext(c *check.C) {
+	seq := newSequencer()
+	now := time.Now()
+	c.Assert(seq.next("c1", now), check.Equals, uint64(1))
+	c.Assert(seq.next("c1", now), check.Equals, uint64(2))
+	c.Assert(seq.next("c2", now), check.Equals, uint64(1))
+	c.Assert(seq.next("c1", now), check.Equals, uint64(3))
+}
+
+func (s *S) TestSequencerPrune(c *check.C) {
+	seq := newSequencer()
+	now := time.Now()
+	c.Assert(seq.next("c1", now), check.Equals, uint64(1))
+	c.Assert(seq.next("c2", now), check.Equals, uint64(1))
+	later := now.Add(sequenceMaxIdle / 2)
+	c.Assert(seq.next("c2", later), check.Equals, uint64(2))
+	pruned := now.Add(sequenceMaxIdle + time.Minute)
+	c.Assert(seq.next("c2", pruned), check.Equals, uint64(3))
+	c.Assert(seq.next("c1", pruned), check.Equals, uint64(1))
+	c.Assert(seq.streams, check.HasLen, 2)
+}
+
+func (s *S) TestLogForwarderHandleNumbersDroppedLines(c *check.C) {
+	dockerServer, contID, err := serverWithContainer()
+	c.Assert(err, check.IsNil)
+	defer dockerServer.Stop()
+	infoClient, err := container.NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	lf := LogForwarder{
+		infoClient: infoClient,
+		sequences:  newSequencer(),
+		lineLimit:  &lineLimit{size: 5, policy: linePolicyDrop},
+	}
+	lines, stop := lf.Tail(contID)
+	defer stop()
+	for _, content := range []string{"one", "too long", "three"} {
+		lf.Handle(format.LogParts{"parts": &rawLogParts{
+			ts:        time.Now(),
+			priority:  []byte("30"),
+			content:   []byte(content),
+			container: []byte(contID),
+		}}, 0, nil)
+	}
+	var sequences []uint64
+	for len(lines) > 0 {
+		var line tailLine
+		c.Assert(json.Unmarshal(<-lines, &line), check.IsNil)
+		sequences = append(sequences, line.Sequence)
+	}
+	c.Assert(sequences, check.DeepEquals, []uint64{1, 3})
+}
+
+func (s *S) TestLogForwarderHandleNumbersSplitLines(c *check.C) {
+	os.Setenv("LOG_SEQUENCE_NUMBERS", "true")
+	os.Setenv("LOG_MAX_LINE_SIZE", "5")
+	os.Setenv("LOG_MAX_LINE_POLICY", "split")
+	defer os.Unsetenv("LOG_SEQUENCE_NUMBERS")
+	defer os.Unsetenv("LOG_MAX_LINE_SIZE")
+	defer os.Unsetenv("LOG_MAX_LINE_POLICY")
+	dockerServer, contID, err := serverWithContainer()
+	c.Assert(err, check.IsNil)
+	defer dockerServer.Stop()
+	infoClient, err := container.NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	lf := LogForwarder{infoClient: infoClient}
+	if config.BoolEnvOrDefault(false, "LOG_SEQUENCE_NUMBERS") {
+		lf.sequences = newSequencer()
+	}
+	lf.lineLimit = newLineLimit()
+	lines, stop := lf.Tail(contID)
+	defer stop()
+	for _, content := range []string{"one", "0123456789ab", "three"} {
+		lf.Handle(format.LogParts{"parts": &rawLogParts{
+			ts:        time.Now(),
+			priority:  []byte("30"),
+			content:   []byte(content),
+			container: []byte(contID),
+		}}, 0, nil)
+	}
+	var sent []tailLine
+	for len(lines) > 0 {
+		var line tailLine
+		c.Assert(json.Unmarshal(<-lines, &line), check.IsNil)
+		sent = append(sent, tailLine{Sequence: line.Sequence, Piece: line.Piece, Pieces: line.Pieces, Message: line.Message})
+	}
+	c.Assert(sent, check.DeepEquals, []tailLine{
+		{Sequence: 1, Message: "one"},
+		{Sequence: 2, Piece: 1, Pieces: 3, Message: "01234"},
+		{Sequence: 2, Piece: 2, Pieces: 3, Message: "56789"},
+		{Sequence: 2, Piece: 3, Pieces: 3, Message: "ab"},
+		{Sequence: 3, Message: "three"},
+	})
+}
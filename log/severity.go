@@ -0,0 +1,245 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+)
+
+const (
+	inferSeverityLabel = "bs.tsuru.io/log-infer-severity"
+	// severityPrefixSize is how far in the line severity words are looked for,
+	// leaving room for timestamps and logger names.
+	severityPrefixSize = 64
+	maxPriority        = 191
+)
+
+var (
+	// priorityStrings holds the text representation of every syslog priority,
+	// avoiding allocations when replacing the priority of a message.
+	priorityStrings [maxPriority + 1][]byte
+
+	severityWords = map[string]int{
+		"EMERG":    0,
+		"PANIC":    0,
+		"ALERT":    1,
+		"CRIT":     2,
+		"CRITICAL": 2,
+		"FATAL":    2,
+		"ERR":      3,
+		"ERROR":    3,
+		"WARN":     4,
+		"WARNING":  4,
+		"NOTICE":   5,
+		"INFO":     6,
+		"DEBUG":    7,
+		"TRACE":    7,
+	}
+	severityKeys = [][]byte{[]byte("level"), []byte("severity")}
+)
+
+func init() {
+	for i := range priorityStrings {
+		priorityStrings[i] = []byte(strconv.Itoa(i))
+	}
+}
+
+// severityInferrer replaces the severity of log messages, set by Docker
+// according to the stream the line was written to, with the one set for the
+// stream in LOG_STREAM_SEVERITY and with the one found in the content of the
+// line.
+type severityInferrer struct {
+	enabled bool
+	streams map[logStream]int
+}
+
+func newSeverityInferrer() *severityInferrer {
+	s := &severityInferrer{
+		enabled: config.BoolEnvOrDefault(false, "LOG_INFER_SEVERITY"),
+	}
+	for name, value := range config.TagsEnvOrDefault(nil, "LOG_STREAM_SEVERITY") {
+		stream := parseStream(name)
+		severity, ok := severityValue([]byte(value))
+		if stream == streamUnknown || !ok {
+			bslog.Warnf("[log forwarder] ignoring invalid LOG_STREAM_SEVERITY %s=%s, expected stdout or stderr and a severity like warning", name, value)
+			continue
+		}
+		if s.streams == nil {
+			s.streams = make(map[logStream]int)
+		}
+		s.streams[stream] = severity
+	}
+	return s
+}
+
+// apply sets the severity of parts to the one set for its stream, if any, and
+// infers it from the content if enabled for the container, either globally
+// or through the bs.tsuru.io/log-infer-severity label.
+func (s *severityInferrer) apply(parts *rawLogParts, cont *container.Container) {
+	if severity, ok := s.streams[parts.stream]; ok {
+		setSeverity(parts, severity)
+	}
+	enabled := s.enabled
+	if cont.Config != nil {
+		if v, ok := cont.Config.Labels[inferSeverityLabel]; ok {
+			if b, err := strconv.ParseBool(v); err == nil {
+				enabled = b
+			}
+		}
+	}
+	if !enabled {
+		return
+	}
+	severity, ok := inferSeverity(parts.content)
+	if !ok {
+		return
+	}
+	setSeverity(parts, severity)
+}
+
+// setSeverity replaces the severity in the priority of parts, keeping the
+// facility.
+func setSeverity(parts *rawLogParts, severity int) {
+	pri, err := strconv.Atoi(string(parts.priority))
+	if err != nil || pri < 0 || pri > maxPriority {
+		return
+	}
+	parts.priority = priorityStrings[pri&^7|severity]
+}
+
+// inferSeverity looks for the severity of a log line in JSON "level" or
+// "severity" fields, in logfmt level=value pairs and in upper case words
+// like ERROR or WARN near the start of the line.
+func inferSeverity(content []byte) (int, bool) {
+	trimmed := bytes.TrimLeft(content, " \t")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		for _, key := range severityKeys {
+			if value := jsonStringField(trimmed, key); value != nil {
+				if sev, ok := severityValue(value); ok {
+					return sev, true
+				}
+			}
+		}
+	}
+	for _, key := range severityKeys {
+		if value := logfmtField(content, key); value != nil {
+			if sev, ok := severityValue(value); ok {
+				return sev, true
+			}
+		}
+	}
+	prefix := content
+	if len(prefix) > severityPrefixSize {
+		prefix = prefix[:severityPrefixSize]
+	}
+	for i := 0; i < len(prefix); {
+		if !isUpperLetter(prefix[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(prefix) && isUpperLetter(prefix[i]) {
+			i++
+		}
+		if (start > 0 && isWordChar(prefix[start-1])) || (i < len(prefix) && isWordChar(prefix[i])) {
+			continue
+		}
+		if sev, ok := severityWords[string(prefix[start:i])]; ok {
+			return sev, true
+		}
+	}
+	return 0, false
+}
+
+// severityValue maps a level name, in any case, to its syslog severity.
+func severityValue(value []byte) (int, bool) {
+	if len(value) > len("CRITICAL") {
+		return 0, false
+	}
+	var buf [len("CRITICAL")]byte
+	upper := buf[:len(value)]
+	for i, c := range value {
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper[i] = c
+	}
+	sev, ok := severityWords[string(upper)]
+	return sev, ok
+}
+
+// jsonStringField returns the string value of key in a JSON object, without
+// decoding it. Only keys and values without escape sequences are found.
+func jsonStringField(data, key []byte) []byte {
+	for {
+		idx := bytes.Index(data, key)
+		if idx == -1 {
+			return nil
+		}
+		before := data[:idx]
+		data = data[idx+len(key):]
+		if len(before) == 0 || before[len(before)-1] != '"' || len(data) == 0 || data[0] != '"' {
+			continue
+		}
+		rest := bytes.TrimLeft(data[1:], " \t")
+		if len(rest) == 0 || rest[0] != ':' {
+			continue
+		}
+		rest = bytes.TrimLeft(rest[1:], " \t")
+		if len(rest) == 0 || rest[0] != '"' {
+			continue
+		}
+		end := bytes.IndexByte(rest[1:], '"')
+		if end == -1 {
+			return nil
+		}
+		return rest[1 : end+1]
+	}
+}
+
+// logfmtField returns the value of key=value pairs, with optionally quoted
+// values.
+func logfmtField(data, key []byte) []byte {
+	for {
+		idx := bytes.Index(data, key)
+		if idx == -1 {
+			return nil
+		}
+		before := data[:idx]
+		data = data[idx+len(key):]
+		if len(before) > 0 && before[len(before)-1] != ' ' && before[len(before)-1] != '\t' {
+			continue
+		}
+		if len(data) == 0 || data[0] != '=' {
+			continue
+		}
+		value := data[1:]
+		if len(value) > 0 && value[0] == '"' {
+			end := bytes.IndexByte(value[1:], '"')
+			if end == -1 {
+				return nil
+			}
+			return value[1 : end+1]
+		}
+		end := bytes.IndexAny(value, " \t")
+		if end == -1 {
+			end = len(value)
+		}
+		return value[:end]
+	}
+}
+
+func isUpperLetter(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isWordChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_'
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"os"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/container"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestInferSeverity(c *check.C) {
+	tests := []struct {
+		content  string
+		severity int
+		ok       bool
+	}{
+		{"ERROR something failed", 3, true},
+		{"[WARN] disk almost full", 4, true},
+		{"2017-06-05 16:13:47.123 INFO  [main] c.e.App - started", 6, true},
+		{"2017/06/05 16:13:47 [DEBUG] connecting", 7, true},
+		{"FATAL: out of memory", 2, true},
+		{"panic: runtime error", 0, false},
+		{"PANIC: runtime error", 0, true},
+		{"ERRORS found: 0", 0, false},
+		{"INFORMATION only", 0, false},
+		{"myERROR is lowercase prefixed", 0, false},
+		{"just a line with error in it", 0, false},
+		{`{"level":"error","msg":"failed"}`, 3, true},
+		{` {"msg":"ok", "severity" : "Warning"}`, 4, true},
+		{`{"msg":"level is not here","lvl":"error"}`, 0, false},
+		{`{"level":"verbose","msg":"ERROR"}`, 3, true},
+		{`time=2017-06-05 level=warn msg="slow query"`, 4, true},
+		{`msg="level=error in message" level=info`, 6, true},
+		{`loglevel=error msg=x`, 0, false},
+		{"GET /index.html 200 - request from CRIT0 host", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		sev, ok := inferSeverity([]byte(tt.content))
+		c.Check(ok, check.Equals, tt.ok, check.Commentf("%q", tt.content))
+		c.Check(sev, check.Equals, tt.severity, check.Commentf("%q", tt.content))
+	}
+}
+
+func (s *S) TestSeverityInferrerApply(c *check.C) {
+	cont := &container.Container{Container: docker.Container{Config: &docker.Config{}}}
+	inferrer := &severityInferrer{enabled: true}
+	parts := &rawLogParts{priority: []byte("30"), content: []byte("ERROR failed")}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "27")
+	parts = &rawLogParts{priority: []byte("27"), content: []byte("INFO ok")}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "30")
+	parts = &rawLogParts{priority: []byte("27"), content: []byte("no severity")}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "27")
+	cont.Config.Labels = map[string]string{inferSeverityLabel: "false"}
+	parts = &rawLogParts{priority: []byte("30"), content: []byte("ERROR failed")}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "30")
+	inferrer.enabled = false
+	cont.Config.Labels = map[string]string{inferSeverityLabel: "true"}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "27")
+	cont.Config.Labels = nil
+	parts = &rawLogParts{priority: []byte("30"), content: []byte("ERROR failed")}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "30")
+}
+
+func (s *S) TestNewSeverityInferrer(c *check.C) {
+	c.Assert(newSeverityInferrer().enabled, check.Equals, false)
+	os.Setenv("LOG_INFER_SEVERITY", "true")
+	c.Assert(newSeverityInferrer().enabled, check.Equals, true)
+}
+
+func (s *S) TestSeverityInferrerStreamSeverity(c *check.C) {
+	os.Setenv("LOG_STREAM_SEVERITY", "stderr=warning,stdout=notice,other=err,stdout=bogus")
+	defer os.Unsetenv("LOG_STREAM_SEVERITY")
+	inferrer := newSeverityInferrer()
+	c.Assert(inferrer.streams, check.DeepEquals, map[logStream]int{streamStderr: 4})
+	os.Setenv("LOG_STREAM_SEVERITY", "stderr=warning,stdout=notice")
+	inferrer = newSeverityInferrer()
+	c.Assert(inferrer.streams, check.DeepEquals, map[logStream]int{streamStderr: 4, streamStdout: 5})
+	cont := &container.Container{Container: docker.Container{Config: &docker.Config{}}}
+	parts := &rawLogParts{priority: []byte("27"), content: []byte("failed"), stream: streamStderr}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "28")
+	parts = &rawLogParts{priority: []byte("30"), content: []byte("ok"), stream: streamStdout}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "29")
+	parts = &rawLogParts{priority: []byte("30"), content: []byte("kernel")}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "30")
+	inferrer.enabled = true
+	parts = &rawLogParts{priority: []byte("27"), content: []byte("DEBUG details"), stream: streamStderr}
+	inferrer.apply(parts, cont)
+	c.Assert(string(parts.priority), check.Equals, "31")
+}
+
+func BenchmarkInferSeverity(b *testing.B) {
+	content := []byte("2017-06-05 16:13:47.123 INFO  [main] c.e.App - request finished status=200")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		inferSeverity(content)
+	}
+}
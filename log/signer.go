@@ -0,0 +1,107 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSignBatchSize = 100
+	signBatchMaxAge      = time.Second
+	// signaturePriority is the syslog priority of the signature lines:
+	// facility syslog, severity info.
+	signaturePriority = "<46>"
+	signatureAppName  = "bs-integrity"
+)
+
+// batchSigner signs the lines forwarded through a connection in batches. Each
+// batch is followed by a signature line holding the shard of the destination
+// writing it, the batch number, its number of lines and the HMAC-SHA256 of
+// the lines, as written, followed by the "shard=<s> batch=<n> lines=<k>"
+// text, so the receiver can verify the integrity and origin of the lines and
+// detect batches missing. Batches are numbered from 1 when bs starts, for
+// each shard.
+type batchSigner struct {
+	hostname string
+	shard    int
+	maxLines int
+	mac      hash.Hash
+	batch    uint64
+	lines    int
+	started  time.Time
+}
+
+func newBatchSigner(key []byte, maxLines int, hostname string, shard int) *batchSigner {
+	if maxLines <= 0 {
+		maxLines = defaultSignBatchSize
+	}
+	return &batchSigner{
+		hostname: hostname,
+		shard:    shard,
+		maxLines: maxLines,
+		mac:      hmac.New(sha256.New, key),
+	}
+}
+
+// add records a line written in the current batch.
+func (s *batchSigner) add(line []byte, now time.Time) {
+	if s.lines == 0 {
+		s.started = now
+	}
+	s.mac.Write(line)
+	s.lines++
+}
+
+// due returns whether the current batch is complete, either by reaching the
+// max number of lines or by being open for too long.
+func (s *batchSigner) due(now time.Time) bool {
+	return s.lines >= s.maxLines || (s.lines > 0 && now.Sub(s.started) >= signBatchMaxAge)
+}
+
+// pending returns whether there are lines not signed yet.
+func (s *batchSigner) pending() bool {
+	return s.lines > 0
+}
+
+// reset discards the lines of the current batch, which were sent to a
+// connection no longer used, keeping the batch number.
+func (s *batchSigner) reset() {
+	s.mac.Reset()
+	s.lines = 0
+}
+
+// sign returns the signature line of the current batch and starts a new one.
+func (s *batchSigner) sign(now time.Time) []byte {
+	s.batch++
+	summary := make([]byte, 0, 64)
+	summary = append(summary, "shard="...)
+	summary = strconv.AppendInt(summary, int64(s.shard), 10)
+	summary = append(summary, " batch="...)
+	summary = strconv.AppendUint(summary, s.batch, 10)
+	summary = append(summary, " lines="...)
+	summary = strconv.AppendInt(summary, int64(s.lines), 10)
+	s.mac.Write(summary)
+	sum := s.mac.Sum(nil)
+	line := make([]byte, 0, 160)
+	line = append(line, signaturePriority...)
+	line = now.UTC().AppendFormat(line, time.RFC3339)
+	line = append(line, ' ')
+	line = append(line, s.hostname...)
+	line = append(line, ' ')
+	line = append(line, signatureAppName...)
+	line = append(line, ':', ' ')
+	line = append(line, summary...)
+	line = append(line, " hmac="...)
+	line = append(line, hex.EncodeToString(sum)...)
+	line = append(line, '\n')
+	s.reset()
+	return line
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+var signatureRegexp = regexp.MustCompile(`^<46>\S+ node1 bs-integrity: (shard=(\d+) batch=(\d+) lines=(\d+)) hmac=([0-9a-f]{64})\n$`)
+
+func expectedHMAC(key string, lines []string, summary string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	for _, line := range lines {
+		mac.Write([]byte(line))
+	}
+	mac.Write([]byte(summary))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *S) TestBatchSignerSign(c *check.C) {
+	now := time.Date(2017, 5, 10, 12, 0, 0, 0, time.UTC)
+	signer := newBatchSigner([]byte("secret"), 2, "node1", 3)
+	c.Assert(signer.due(now), check.Equals, false)
+	c.Assert(signer.pending(), check.Equals, false)
+	signer.add([]byte("<30>line 1\n"), now)
+	c.Assert(signer.due(now), check.Equals, false)
+	c.Assert(signer.due(now.Add(signBatchMaxAge)), check.Equals, true)
+	signer.add([]byte("<30>line 2\n"), now)
+	c.Assert(signer.due(now), check.Equals, true)
+	sig := string(signer.sign(now))
+	c.Assert(sig, check.Equals, "<46>2017-05-10T12:00:00Z node1 bs-integrity: shard=3 batch=1 lines=2 hmac="+
+		expectedHMAC("secret", []string{"<30>line 1\n", "<30>line 2\n"}, "shard=3 batch=1 lines=2")+"\n")
+	c.Assert(signer.pending(), check.Equals, false)
+	signer.add([]byte("<30>line 3\n"), now)
+	signer.reset()
+	signer.add([]byte("<30>line 4\n"), now)
+	sig = string(signer.sign(now))
+	c.Assert(sig, check.Matches, `.* shard=3 batch=2 lines=1 hmac=`+expectedHMAC("secret", []string{"<30>line 4\n"}, "shard=3 batch=2 lines=1")+"\n")
+}
+
+func (s *S) TestSyslogForwarderSignsBatches(c *check.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	received := make(chan []string)
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		reader := bufio.NewReader(conn)
+		for {
+			line, readErr := reader.ReadString('\n')
+			if readErr != nil {
+				break
+			}
+			lines = append(lines, line)
+		}
+		received <- lines
+	}()
+	forwardURL, _ := url.Parse("tcp://" + listener.Addr().String())
+	f := &syslogForwarder{
+		url:           forwardURL,
+		bufferPool:    &sync.Pool{New: func() interface{} { return make([]byte, 200) }},
+		connMaxAge:    -1,
+		signKey:       []byte("secret"),
+		signBatchSize: 2,
+		hostname:      "node1",
+		shard:         1,
+	}
+	conn, err := f.connect()
+	c.Assert(err, check.IsNil)
+	var sent []string
+	for i := 0; i < 3; i++ {
+		line := fmt.Sprintf("<30>Jun  5 16:13:47 c1 app[web]: msg %d\n", i)
+		sent = append(sent, line)
+		err = f.process(conn, bufferWithIdx{buffer: []byte(line), headerIdx: 0, contentIdx: len(line) - 1})
+		c.Assert(err, check.IsNil)
+	}
+	f.close(conn)
+	var lines []string
+	select {
+	case lines = <-received:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for lines")
+	}
+	c.Assert(lines, check.HasLen, 5)
+	c.Assert(lines[0:2], check.DeepEquals, sent[0:2])
+	c.Assert(lines[3], check.Equals, sent[2])
+	for _, tt := range []struct {
+		sig     string
+		lines   []string
+		summary string
+	}{
+		{lines[2], sent[0:2], "shard=1 batch=1 lines=2"},
+		{lines[4], sent[2:], "shard=1 batch=2 lines=1"},
+	} {
+		m := signatureRegexp.FindStringSubmatch(tt.sig)
+		c.Assert(m, check.NotNil, check.Commentf("signature: %q", tt.sig))
+		c.Assert(m[1], check.Equals, tt.summary)
+		c.Assert(m[5], check.Equals, expectedHMAC("secret", tt.lines, tt.summary))
+	}
+}
+
+// lineReceiver accepts a single connection on listener, sending each line
+// read from it to the returned channel.
+func lineReceiver(listener net.Listener) <-chan string {
+	lines := make(chan string, 10)
+	go func() {
+		defer close(lines)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines
+}
+
+func (s *S) TestSyslogForwarderSignsIdleAndFlushedBatches(c *check.C) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer listener.Close()
+	lines := lineReceiver(listener)
+	forwardURL, _ := url.Parse("tcp://" + listener.Addr().String())
+	q := newMessageQueue(func() forwarderBackend {
+		return &syslogForwarder{
+			url:           forwardURL,
+			bufferPool:    &sync.Pool{New: func() interface{} { return make([]byte, 200) }},
+			connMaxAge:    -1,
+			signKey:       []byte("secret"),
+			signBatchSize: 100,
+			hostname:      "node1",
+		}
+	}, 1, 10, 0)
+	defer q.stop()
+	next := func(timeout time.Duration) string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(timeout):
+			c.Fatal("timeout waiting for line")
+		}
+		return ""
+	}
+	line := "<30>Jun  5 16:13:47 c1 app[web]: msg 1\n"
+	c.Assert(q.send("c1", bufferWithIdx{buffer: []byte(line), contentIdx: len(line) - 1}, classNormal), check.Equals, true)
+	c.Assert(next(5*time.Second), check.Equals, line)
+	m := signatureRegexp.FindStringSubmatch(next(5 * time.Second))
+	c.Assert(m, check.NotNil)
+	c.Assert(m[1], check.Equals, "shard=0 batch=1 lines=1")
+	line = "<30>Jun  5 16:13:47 c1 app[web]: msg 2\n"
+	c.Assert(q.send("c1", bufferWithIdx{buffer: []byte(line), contentIdx: len(line) - 1}, classNormal), check.Equals, true)
+	c.Assert(q.flush(time.After(5*time.Second)), check.IsNil)
+	c.Assert(next(signBatchMaxAge/2), check.Equals, line)
+	m = signatureRegexp.FindStringSubmatch(next(signBatchMaxAge / 2))
+	c.Assert(m, check.NotNil)
+	c.Assert(m[1], check.Equals, "shard=0 batch=2 lines=1")
+}
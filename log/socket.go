@@ -0,0 +1,54 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/tsuru/bs/bslog"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+var errSocketStatsUnsupported = errors.New("socket stats are not supported on this platform")
+
+func handleDatagram(f format.Format, handler syslog.Handler, msg []byte) {
+	// Ignore trailing control characters and NULs, just like go-syslog.
+	n := len(msg)
+	for ; n > 0 && msg[n-1] < 32; n-- {
+	}
+	if n == 0 {
+		return
+	}
+	handleLine(f, handler, msg[:n])
+}
+
+func handleLine(f format.Format, handler syslog.Handler, line []byte) {
+	parser := f.GetParser(line)
+	err := parser.Parse()
+	handler.Handle(parser.Dump(), int64(len(line)), err)
+}
+
+// logReceiveBuffer logs the receive buffer size reported by the kernel after
+// it was set, as the kernel may cap or adjust the requested size.
+func logReceiveBuffer(proto string, conn syscall.Conn, requested int) {
+	effective, err := socketReceiveBuffer(conn)
+	if err == errSocketStatsUnsupported {
+		bslog.Infof("[log forwarder] %s socket receive buffer set to %d bytes", proto, requested)
+		return
+	}
+	if err != nil {
+		bslog.Warnf("[log forwarder] unable to read %s socket receive buffer: %s", proto, err)
+		return
+	}
+	// Linux doubles the requested size for bookkeeping, so only a smaller
+	// size, capped by net.core.rmem_max, is unexpected.
+	if effective >= requested {
+		bslog.Infof("[log forwarder] %s socket receive buffer set to %d bytes, effective size reported by the kernel is %d bytes", proto, requested, effective)
+		return
+	}
+	bslog.Warnf("[log forwarder] %s socket receive buffer set to %d bytes, but the kernel reports only %d bytes, check net.core.rmem_max", proto, requested, effective)
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Overridden in tests.
+var procNetPath = "/proc/net"
+
+// socketReceiveBuffer returns SO_RCVBUF for the socket. Linux reports twice
+// the requested size, accounting for its bookkeeping overhead.
+func socketReceiveBuffer(conn syscall.Conn) (int, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		size, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, sockErr
+}
+
+// socketDrops returns the number of datagrams dropped by the kernel in an UDP
+// socket, as reported in /proc/net/udp and /proc/net/udp6.
+func socketDrops(conn syscall.Conn) (uint64, error) {
+	inode, err := socketInode(conn)
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range []string{"udp", "udp6"} {
+		f, err := os.Open(filepath.Join(procNetPath, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		drops, found, err := parseSocketDrops(f, inode)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return drops, nil
+		}
+	}
+	return 0, fmt.Errorf("socket with inode %d not found in %s", inode, procNetPath)
+}
+
+func socketInode(conn syscall.Conn) (uint64, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var st unix.Stat_t
+	var statErr error
+	err = raw.Control(func(fd uintptr) {
+		statErr = unix.Fstat(int(fd), &st)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Ino), statErr
+}
+
+// parseSocketDrops looks for the socket with the given inode in the contents
+// of /proc/net/udp, returning its drops column.
+func parseSocketDrops(r io.Reader, inode uint64) (uint64, bool, error) {
+	inodeStr := strconv.FormatUint(inode, 10)
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 || fields[9] != inodeStr {
+			continue
+		}
+		drops, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid drops value %q: %s", fields[12], err)
+		}
+		return drops, true, nil
+	}
+	return 0, false, scanner.Err()
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"net"
+	"strings"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseSocketDrops(c *check.C) {
+	data := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  250: 00000000:0202 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 15712 2 ffff8800b8e1c000 0
+ 1094: 0100007F:7BCD 00000000:0000 07 00000000:00034000 00:00000000 00000000     0        0 834561 2 ffff8800b8e1d100 1234
+`
+	drops, found, err := parseSocketDrops(strings.NewReader(data), 834561)
+	c.Assert(err, check.IsNil)
+	c.Assert(found, check.Equals, true)
+	c.Assert(drops, check.Equals, uint64(1234))
+	drops, found, err = parseSocketDrops(strings.NewReader(data), 15712)
+	c.Assert(err, check.IsNil)
+	c.Assert(found, check.Equals, true)
+	c.Assert(drops, check.Equals, uint64(0))
+	_, found, err = parseSocketDrops(strings.NewReader(data), 999)
+	c.Assert(err, check.IsNil)
+	c.Assert(found, check.Equals, false)
+}
+
+func (s *S) TestSocketDrops(c *check.C) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	drops, err := socketDrops(conn)
+	c.Assert(err, check.IsNil)
+	c.Assert(drops, check.Equals, uint64(0))
+}
+
+func (s *S) TestSocketReceiveBuffer(c *check.C) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	err = conn.SetReadBuffer(8192)
+	c.Assert(err, check.IsNil)
+	size, err := socketReceiveBuffer(conn)
+	c.Assert(err, check.IsNil)
+	c.Assert(size >= 8192, check.Equals, true)
+}
+
+func (s *S) TestLogForwarderHostMetricsUDPDrops(c *check.C) {
+	lf := LogForwarder{
+		BindAddress:     "udp://127.0.0.1:0",
+		DockerEndpoint:  s.dockerServer.URL(),
+		EnabledBackends: []string{"syslog"},
+	}
+	err := lf.Start()
+	c.Assert(err, check.IsNil)
+	defer lf.stopWait()
+	c.Assert(lf.HostMetrics(), check.DeepEquals, map[string]metric.Metric{"log_udp_drops": metric.Int(0), "log_oversized_lines": metric.Int(0)})
+}
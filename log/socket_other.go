@@ -0,0 +1,18 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package log
+
+import "syscall"
+
+func socketReceiveBuffer(conn syscall.Conn) (int, error) {
+	return 0, errSocketStatsUnsupported
+}
+
+func socketDrops(conn syscall.Conn) (uint64, error) {
+	return 0, errSocketStatsUnsupported
+}
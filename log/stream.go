@@ -0,0 +1,53 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import "strconv"
+
+// logStream is the output stream a container wrote a log line to.
+type logStream uint8
+
+const (
+	streamUnknown logStream = iota
+	streamStdout
+	streamStderr
+)
+
+var streamNames = map[string]logStream{
+	"stdout": streamStdout,
+	"stderr": streamStderr,
+}
+
+func (s logStream) String() string {
+	switch s {
+	case streamStdout:
+		return "stdout"
+	case streamStderr:
+		return "stderr"
+	}
+	return ""
+}
+
+// parseStream returns the stream named as in Docker logs, or streamUnknown.
+func parseStream(name string) logStream {
+	return streamNames[name]
+}
+
+// streamFromPriority returns the stream of a line received from the Docker
+// syslog driver, which sends the lines written to stdout with the info
+// severity and the ones written to stderr with the err severity.
+func streamFromPriority(priority []byte) logStream {
+	pri, err := strconv.Atoi(string(priority))
+	if err != nil || pri < 0 || pri > maxPriority {
+		return streamUnknown
+	}
+	switch pri & severityMask {
+	case syslogSeverityInfo:
+		return streamStdout
+	case syslogSeverityErr:
+		return streamStderr
+	}
+	return streamUnknown
+}
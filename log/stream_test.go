@@ -0,0 +1,35 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import "gopkg.in/check.v1"
+
+func (s *S) TestStreamFromPriority(c *check.C) {
+	tests := []struct {
+		priority string
+		stream   logStream
+	}{
+		{"30", streamStdout},
+		{"27", streamStderr},
+		{"14", streamStdout},
+		{"11", streamStderr},
+		{"28", streamUnknown},
+		{"192", streamUnknown},
+		{"x", streamUnknown},
+		{"", streamUnknown},
+	}
+	for _, tt := range tests {
+		c.Check(streamFromPriority([]byte(tt.priority)), check.Equals, tt.stream, check.Commentf("%q", tt.priority))
+	}
+}
+
+func (s *S) TestParseStream(c *check.C) {
+	c.Assert(parseStream("stdout"), check.Equals, streamStdout)
+	c.Assert(parseStream("stderr"), check.Equals, streamStderr)
+	c.Assert(parseStream("other"), check.Equals, streamUnknown)
+	c.Assert(streamStdout.String(), check.Equals, "stdout")
+	c.Assert(streamStderr.String(), check.Equals, "stderr")
+	c.Assert(streamUnknown.String(), check.Equals, "")
+}
@@ -5,30 +5,44 @@
 package log
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
+	"github.com/tsuru/bs/audit"
 	"github.com/tsuru/bs/bslog"
 	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/node"
 )
 
 const (
 	udpMessageDefaultMTU = 1500
+	defaultMTUInterface  = "eth0"
 	udpHeaderSz          = 100 // Exagerated a bit due to possibility of ipv6 extensions, ipsec, etc.
+	epochMillisLayout    = "epoch_millis"
 )
 
 type syslogBackend struct {
 	syslogLocation   *time.Location
 	syslogExtraStart []byte
 	syslogExtraEnd   []byte
-	msgChans         []chan<- LogMessage
-	quitChans        []chan<- bool
+	queues           []*messageQueue
 	bufferPool       sync.Pool
 	nextNotify       *time.Timer
+	nodeMetadata     *node.MetadataCache
+	template         *template.Template
+	timestampLayout  string
+	extraTags        map[string]string
+	extraTagsPrefix  []byte
+	streamField      bool
 }
 
 type syslogForwarder struct {
@@ -38,6 +52,12 @@ type syslogForwarder struct {
 	messageLimit  int
 	connCreatedAt time.Time
 	connMaxAge    time.Duration
+	compression   string
+	signKey       []byte
+	signBatchSize int
+	hostname      string
+	shard         int
+	signer        *batchSigner
 }
 
 func (b *syslogBackend) initialize() error {
@@ -49,7 +69,19 @@ func (b *syslogBackend) initialize() error {
 	if extra != "" {
 		b.syslogExtraEnd = []byte(" " + os.ExpandEnv(extra))
 	}
+	b.extraTags = config.TagsEnvOrDefault(nil, "METRICS_EXTRA_TAGS")
+	b.extraTagsPrefix = formatTags(b.extraTags)
+	b.streamField = config.BoolEnvOrDefault(false, "LOG_STREAM_FIELD")
+	if tmpl := config.StringEnvOrDefault("", "LOG_SYSLOG_MESSAGE_TEMPLATE"); tmpl != "" {
+		var err error
+		b.template, err = template.New("syslog").Parse(tmpl)
+		if err != nil {
+			return fmt.Errorf("unable to parse syslog message template: %s", err)
+		}
+	}
 	bufferSize := config.IntEnvOrDefault(config.DefaultBufferSize, "LOG_SYSLOG_BUFFER_SIZE", "LOG_BUFFER_SIZE")
+	shards := config.IntEnvOrDefault(defaultForwardShards, "LOG_SYSLOG_FORWARD_SHARDS", "LOG_FORWARD_SHARDS")
+	maxBytesPerSecond := config.IntEnvOrDefault(0, "LOG_SYSLOG_MAX_BYTES_PER_SECOND", "LOG_MAX_BYTES_PER_SECOND")
 	forwardAddresses := config.StringsEnvOrDefault(nil, "LOG_SYSLOG_FORWARD_ADDRESSES", "SYSLOG_FORWARD_ADDRESSES")
 	if len(forwardAddresses) == 0 {
 		return nil
@@ -64,8 +96,21 @@ func (b *syslogBackend) initialize() error {
 			bslog.Warnf("unable to parse syslog timezone format: %s", err)
 		}
 	}
+	b.timestampLayout = time.Stamp
+	timestampFormat := config.StringEnvOrDefault("", "LOG_SYSLOG_TIMESTAMP_FORMAT")
+	switch strings.ToLower(timestampFormat) {
+	case "", "rfc3164":
+	case "rfc3339":
+		b.timestampLayout = time.RFC3339
+	case "rfc3339nano":
+		b.timestampLayout = time.RFC3339Nano
+	case "epoch_millis":
+		b.timestampLayout = epochMillisLayout
+	default:
+		b.timestampLayout = timestampFormat
+	}
 	mtu := udpMessageDefaultMTU
-	mtuInterface := config.StringEnvOrDefault("eth0", "LOG_SYSLOG_MTU_NETWORK_INTERFACE")
+	mtuInterface := config.StringEnvOrDefault(defaultMTUInterface, "LOG_SYSLOG_MTU_NETWORK_INTERFACE")
 	if mtuInterface != "" {
 		iface, err := net.InterfaceByName(mtuInterface)
 		if err == nil && iface.MTU > 0 {
@@ -80,27 +125,58 @@ func (b *syslogBackend) initialize() error {
 		},
 	}
 	b.nextNotify = time.NewTimer(0)
-	connMaxAge := config.SecondsEnvOrDefault(-1, "LOG_SYSLOG_CONN_MAX_AGE")
+	connMaxAge := config.SecondsEnvOrDefault(defaultConnMaxAge, "LOG_SYSLOG_CONN_MAX_AGE")
+	compression := config.StringEnvOrDefault("", "LOG_SYSLOG_COMPRESSION")
+	if compression != "" && compression != "gzip" {
+		return fmt.Errorf("invalid LOG_SYSLOG_COMPRESSION %q, expected gzip", compression)
+	}
+	signKey := []byte(config.StringEnvOrDefault("", "LOG_SYSLOG_HMAC_KEY"))
+	signBatchSize := config.IntEnvOrDefault(defaultSignBatchSize, "LOG_SYSLOG_HMAC_BATCH_SIZE")
+	hostname, _ := os.Hostname()
 	for _, addr := range forwardAddresses {
 		forwardUrl, err := url.Parse(addr)
 		if err != nil {
 			return fmt.Errorf("unable to parse %q: %s", addr, err)
 		}
-		forwardChan, quitChan, err := processMessages(&syslogForwarder{
-			url:        forwardUrl,
-			bufferPool: &b.bufferPool,
-			mtu:        mtu,
-			connMaxAge: connMaxAge,
-		}, bufferSize)
-		if err != nil {
-			return err
-		}
-		b.msgChans = append(b.msgChans, forwardChan)
-		b.quitChans = append(b.quitChans, quitChan)
+		var shard int
+		queue := newMessageQueue(func() forwarderBackend {
+			f := &syslogForwarder{
+				url:           forwardUrl,
+				bufferPool:    &b.bufferPool,
+				mtu:           mtu,
+				connMaxAge:    connMaxAge,
+				compression:   compression,
+				signKey:       signKey,
+				signBatchSize: signBatchSize,
+				hostname:      hostname,
+				shard:         shard,
+			}
+			shard++
+			return f
+		}, shards, bufferSize, maxBytesPerSecond)
+		b.queues = append(b.queues, queue)
 	}
 	return nil
 }
 
+// formatTags renders the tags as name=value pairs sorted by name, each one
+// followed by a space.
+func formatTags(tags map[string]string) []byte {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		buf = append(buf, name...)
+		buf = append(buf, '=')
+		buf = append(buf, tags[name]...)
+		buf = append(buf, ' ')
+	}
+	return buf
+}
+
 type bufferWithIdx struct {
 	buffer     []byte
 	headerIdx  int
@@ -108,7 +184,7 @@ type bufferWithIdx struct {
 }
 
 func (b *syslogBackend) sendMessage(parts *rawLogParts, appName, processName, container string) {
-	lenSyslogs := len(b.msgChans)
+	lenSyslogs := len(b.queues)
 	if lenSyslogs == 0 {
 		return
 	}
@@ -116,21 +192,73 @@ func (b *syslogBackend) sendMessage(parts *rawLogParts, appName, processName, co
 	buffer = append(buffer, '<')
 	buffer = append(buffer, parts.priority...)
 	buffer = append(buffer, '>')
-	buffer = append(buffer, parts.ts.In(b.syslogLocation).Format(time.Stamp)...)
-	buffer = append(buffer, ' ')
-	buffer = append(buffer, container...)
-	buffer = append(buffer, ' ')
-	buffer = append(buffer, appName...)
-	buffer = append(buffer, '[')
-	buffer = append(buffer, processName...)
-	buffer = append(buffer, ']', ':', ' ')
-	buffer = append(buffer, b.syslogExtraStart...)
-	headerIdx := len(buffer)
-	buffer = append(buffer, parts.content...)
-	contentIdx := len(buffer)
-	buffer = append(buffer, b.syslogExtraEnd...)
+	var headerIdx, contentIdx int
+	if b.template != nil {
+		var err error
+		buffer, headerIdx, contentIdx, err = b.appendTemplate(buffer, parts, appName, processName, container)
+		if err != nil {
+			select {
+			case <-b.nextNotify.C:
+				bslog.Errorf("Dropping log messages to syslog due to template error: %s", err)
+				b.nextNotify.Reset(time.Minute)
+			default:
+			}
+			b.bufferPool.Put(buffer)
+			return
+		}
+	} else {
+		buffer = b.appendTimestamp(buffer, parts.ts)
+		buffer = append(buffer, ' ')
+		buffer = append(buffer, container...)
+		buffer = append(buffer, ' ')
+		buffer = append(buffer, appName...)
+		buffer = append(buffer, '[')
+		buffer = append(buffer, processName...)
+		buffer = append(buffer, ']', ':', ' ')
+		if metadata := b.nodeMetadata.Get(); metadata.Address != "" {
+			buffer = append(buffer, "pool="...)
+			buffer = append(buffer, metadata.Pool...)
+			buffer = append(buffer, " node="...)
+			buffer = append(buffer, metadata.Address...)
+			buffer = append(buffer, ' ')
+		}
+		if parts.retention != "" {
+			buffer = append(buffer, "retention="...)
+			buffer = append(buffer, parts.retention...)
+			buffer = append(buffer, ' ')
+		}
+		if parts.received != 0 {
+			buffer = append(buffer, "received_ns="...)
+			buffer = strconv.AppendInt(buffer, parts.received, 10)
+			buffer = append(buffer, ' ')
+		}
+		if parts.sequence != 0 {
+			buffer = append(buffer, "seq="...)
+			buffer = strconv.AppendUint(buffer, parts.sequence, 10)
+			buffer = append(buffer, ' ')
+			if parts.pieces > 0 {
+				buffer = append(buffer, "piece="...)
+				buffer = strconv.AppendInt(buffer, int64(parts.piece), 10)
+				buffer = append(buffer, '/')
+				buffer = strconv.AppendInt(buffer, int64(parts.pieces), 10)
+				buffer = append(buffer, ' ')
+			}
+		}
+		if b.streamField && parts.stream != streamUnknown {
+			buffer = append(buffer, "stream="...)
+			buffer = append(buffer, parts.stream.String()...)
+			buffer = append(buffer, ' ')
+		}
+		buffer = append(buffer, b.extraTagsPrefix...)
+		buffer = append(buffer, b.syslogExtraStart...)
+		headerIdx = len(buffer)
+		buffer = append(buffer, parts.content...)
+		contentIdx = len(buffer)
+		buffer = append(buffer, b.syslogExtraEnd...)
+	}
 	buffer = append(buffer, '\n')
-	for i, ch := range b.msgChans {
+	key := parts.key(container)
+	for i, queue := range b.queues {
 		var chBuffer []byte
 		if i == lenSyslogs-1 {
 			chBuffer = buffer
@@ -138,16 +266,21 @@ func (b *syslogBackend) sendMessage(parts *rawLogParts, appName, processName, co
 			chBuffer = b.bufferPool.Get().([]byte)[:0]
 			chBuffer = append(chBuffer, buffer...)
 		}
-		select {
-		case ch <- bufferWithIdx{
+		sent := queue.send(key, bufferWithIdx{
 			buffer:     chBuffer,
 			headerIdx:  headerIdx,
 			contentIdx: contentIdx,
-		}:
-		default:
+		}, parts.class)
+		if !sent {
+			b.bufferPool.Put(chBuffer)
 			select {
 			case <-b.nextNotify.C:
 				bslog.Errorf("Dropping log messages to syslog due to full channel buffer.")
+				var dropped uint64
+				for _, q := range b.queues {
+					dropped += q.takeDropped()
+				}
+				audit.Record("log", "dropped %d messages to syslog due to full channel buffer", dropped)
 				b.nextNotify.Reset(time.Minute)
 			default:
 			}
@@ -155,20 +288,122 @@ func (b *syslogBackend) sendMessage(parts *rawLogParts, appName, processName, co
 	}
 }
 
+// syslogTemplateData holds the fields available to the template set in
+// LOG_SYSLOG_MESSAGE_TEMPLATE.
+type syslogTemplateData struct {
+	AppName     string
+	ProcessName string
+	ContainerID string
+	Timestamp   time.Time
+	Message     string
+	Pool        string
+	Node        string
+	TraceID     string
+	SpanID      string
+	Retention   string
+	ReceivedNS  int64
+	Sequence    uint64
+	Piece       int
+	Pieces      int
+	Stream      string
+	Tags        map[string]string
+}
+
+// templateContentMark replaces the message when rendering the template to
+// find where the content starts and ends, so long messages can be split
+// keeping the rest of the template in every part.
+const templateContentMark = "\x00"
+
+func (b *syslogBackend) appendTemplate(buffer []byte, parts *rawLogParts, appName, processName, container string) ([]byte, int, int, error) {
+	metadata := b.nodeMetadata.Get()
+	data := syslogTemplateData{
+		AppName:     appName,
+		ProcessName: processName,
+		ContainerID: container,
+		Timestamp:   parts.ts.In(b.syslogLocation),
+		Message:     templateContentMark,
+		Pool:        metadata.Pool,
+		Node:        metadata.Address,
+		TraceID:     string(parts.traceID),
+		SpanID:      string(parts.spanID),
+		Retention:   parts.retention,
+		ReceivedNS:  parts.received,
+		Sequence:    parts.sequence,
+		Piece:       parts.piece,
+		Pieces:      parts.pieces,
+		Stream:      parts.stream.String(),
+		Tags:        b.extraTags,
+	}
+	var out bytes.Buffer
+	err := b.template.Execute(&out, data)
+	if err != nil {
+		return buffer, 0, 0, err
+	}
+	rendered := out.Bytes()
+	if idx := bytes.Index(rendered, []byte(templateContentMark)); idx != -1 && bytes.Count(rendered, []byte(templateContentMark)) == 1 {
+		buffer = append(buffer, rendered[:idx]...)
+		headerIdx := len(buffer)
+		buffer = append(buffer, parts.content...)
+		contentIdx := len(buffer)
+		buffer = append(buffer, rendered[idx+len(templateContentMark):]...)
+		return buffer, headerIdx, contentIdx, nil
+	}
+	// The message is either absent, repeated or transformed by the template,
+	// render it again with the actual message and split the whole line.
+	out.Reset()
+	data.Message = string(parts.content)
+	err = b.template.Execute(&out, data)
+	if err != nil {
+		return buffer, 0, 0, err
+	}
+	headerIdx := len(buffer)
+	buffer = append(buffer, out.Bytes()...)
+	return buffer, headerIdx, len(buffer), nil
+}
+
+func (b *syslogBackend) appendTimestamp(buffer []byte, ts time.Time) []byte {
+	if b.timestampLayout == epochMillisLayout {
+		return strconv.AppendInt(buffer, ts.UnixNano()/int64(time.Millisecond), 10)
+	}
+	return ts.In(b.syslogLocation).AppendFormat(buffer, b.timestampLayout)
+}
+
+func (b *syslogBackend) setNodeMetadata(metadata *node.MetadataCache) {
+	b.nodeMetadata = metadata
+}
+
 func (b *syslogBackend) stop() {
-	for _, ch := range b.quitChans {
-		close(ch)
+	for _, queue := range b.queues {
+		queue.stop()
 	}
 }
 
+func (b *syslogBackend) messageQueues() []*messageQueue {
+	return b.queues
+}
+
+func (f *syslogForwarder) String() string {
+	return "syslog " + f.url.String()
+}
+
 func (f *syslogForwarder) connect() (net.Conn, error) {
 	conn, err := net.DialTimeout(f.url.Scheme, f.url.Host, forwardConnDialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("[log forwarder] unable to connect to %q: %s", f.url, err)
 	}
 	if f.url.Scheme == "tcp" {
-		conn = newBufferedConn(conn, time.Second)
+		if f.compression == "gzip" {
+			conn = newGzipBufferedConn(conn, time.Second)
+		} else {
+			conn = newBufferedConn(conn, time.Second)
+		}
 		f.connCreatedAt = time.Now()
+		if len(f.signKey) > 0 {
+			if f.signer == nil {
+				f.signer = newBatchSigner(f.signKey, f.signBatchSize, f.hostname, f.shard)
+			}
+			f.signer.reset()
+		}
 	} else {
 		f.messageLimit = f.mtu - udpHeaderSz
 	}
@@ -179,19 +414,26 @@ func (f *syslogForwarder) splitParts(conn net.Conn, bufIdx bufferWithIdx) error
 	fullLen := len(bufIdx.buffer)
 	if f.messageLimit <= 0 || fullLen <= f.messageLimit {
 		// Fast path, message fit, no manipulation needed.
-		err := f.writePart(conn, bufIdx.buffer)
-		f.bufferPool.Put(bufIdx.buffer)
-		return err
+		return f.writeUnsplit(conn, bufIdx)
 	}
 	headerBuf := bufIdx.buffer[:bufIdx.headerIdx]
 	trailerBuf := bufIdx.buffer[bufIdx.contentIdx:]
 	contentBuf := bufIdx.buffer[bufIdx.headerIdx:bufIdx.contentIdx]
 	availableSz := f.messageLimit - (len(headerBuf) + len(trailerBuf))
 	contentSz := len(contentBuf)
+	// The header and trailer, usually from a large template, may leave no
+	// room for the content and the part number in a datagram, in which case
+	// the message is sent unsplit, relying on IP fragmentation.
+	if availableSz <= 6 {
+		return f.writeUnsplit(conn, bufIdx)
+	}
 	nParts := contentSz / (availableSz - 6)
 	if contentSz%(availableSz-6) != 0 {
 		nParts++
 	}
+	if availableSz <= len(fmt.Sprintf(" (%d/%d)", nParts, nParts)) {
+		return f.writeUnsplit(conn, bufIdx)
+	}
 	i := 0
 	for contentSz > 0 {
 		var buffer []byte
@@ -225,13 +467,34 @@ func (f *syslogForwarder) process(conn net.Conn, msg LogMessage) error {
 	if err != nil {
 		return err
 	}
+	err = f.closeBatch(conn, false)
+	if err != nil {
+		return err
+	}
 	if f.url.Scheme == "tcp" && f.connMaxAge >= 0 && time.Since(f.connCreatedAt) >= f.connMaxAge {
 		return errConnMaxAgeExceeded
 	}
 	return nil
 }
 
+// writeUnsplit sends the whole message in a single datagram.
+func (f *syslogForwarder) writeUnsplit(conn net.Conn, bufIdx bufferWithIdx) error {
+	err := f.writePart(conn, bufIdx.buffer)
+	f.bufferPool.Put(bufIdx.buffer)
+	return err
+}
+
+// writePart writes a line to conn, adding it to the batch being signed, if
+// signing is enabled.
 func (f *syslogForwarder) writePart(conn net.Conn, buf []byte) error {
+	err := f.write(conn, buf)
+	if err == nil && f.signer != nil {
+		f.signer.add(buf, time.Now())
+	}
+	return err
+}
+
+func (f *syslogForwarder) write(conn net.Conn, buf []byte) error {
 	err := conn.SetWriteDeadline(time.Now().Add(forwardConnWriteTimeout))
 	if err != nil {
 		return err
@@ -247,7 +510,31 @@ func (f *syslogForwarder) writePart(conn net.Conn, buf []byte) error {
 	return nil
 }
 
+// batchInterval returns how often the batch being signed is checked while no
+// message is written, so idle connections still get their lines signed about
+// signBatchMaxAge after they're sent.
+func (f *syslogForwarder) batchInterval() time.Duration {
+	if len(f.signKey) == 0 || f.url.Scheme != "tcp" {
+		return 0
+	}
+	return signBatchMaxAge / 2
+}
+
+// closeBatch writes the signature of the batch being signed, if signing is
+// enabled, when the batch is due or, with force, when it has any line.
+func (f *syslogForwarder) closeBatch(conn net.Conn, force bool) error {
+	if f.signer == nil {
+		return nil
+	}
+	now := time.Now()
+	if !f.signer.due(now) && !(force && f.signer.pending()) {
+		return nil
+	}
+	return f.write(conn, f.signer.sign(now))
+}
+
 func (f *syslogForwarder) close(conn net.Conn) {
+	f.closeBatch(conn, true)
 	// Reset deadline, if we don't do this the connection remains open
 	// on the other end (causing tests to fail) for some weird reason.
 	conn.SetWriteDeadline(time.Time{})
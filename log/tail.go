@@ -0,0 +1,119 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/bs/container"
+)
+
+// tailBufferSize is the number of lines buffered for each tail. Lines are
+// dropped when the reader falls behind, never blocking the forwarding.
+const tailBufferSize = 256
+
+// tailLine is a log line as forwarded by bs, after the enrichment, sent to
+// the readers tailing the logs of a container.
+type tailLine struct {
+	Time      time.Time `json:"time"`
+	Container string    `json:"container"`
+	App       string    `json:"app,omitempty"`
+	Process   string    `json:"process,omitempty"`
+	Priority  int       `json:"priority"`
+	Class     string    `json:"class"`
+	Retention string    `json:"retention,omitempty"`
+	Received  int64     `json:"received_ns,omitempty"`
+	Sequence  uint64    `json:"sequence,omitempty"`
+	Piece     int       `json:"piece,omitempty"`
+	Pieces    int       `json:"pieces,omitempty"`
+	Stream    string    `json:"stream,omitempty"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	SpanID    string    `json:"span_id,omitempty"`
+	Message   string    `json:"message"`
+}
+
+// tailRegistry holds the readers tailing the logs of each container, by
+// container ID.
+type tailRegistry struct {
+	count int32
+	mu    sync.RWMutex
+	tails map[string]map[chan []byte]struct{}
+}
+
+// Tail starts streaming the log lines of the container with the given ID, as
+// JSON documents, until the returned stop function is called.
+func (l *LogForwarder) Tail(id string) (<-chan []byte, func()) {
+	return l.tails.add(id)
+}
+
+func (r *tailRegistry) add(id string) (<-chan []byte, func()) {
+	ch := make(chan []byte, tailBufferSize)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tails == nil {
+		r.tails = make(map[string]map[chan []byte]struct{})
+	}
+	if r.tails[id] == nil {
+		r.tails[id] = make(map[chan []byte]struct{})
+	}
+	r.tails[id][ch] = struct{}{}
+	atomic.AddInt32(&r.count, 1)
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			delete(r.tails[id], ch)
+			if len(r.tails[id]) == 0 {
+				delete(r.tails, id)
+			}
+			atomic.AddInt32(&r.count, -1)
+		})
+	}
+}
+
+// publish sends the line in parts to the readers tailing cont, if any.
+func (r *tailRegistry) publish(parts *rawLogParts, cont *container.Container) {
+	if atomic.LoadInt32(&r.count) == 0 {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tails := r.tails[cont.ID]
+	if len(tails) == 0 {
+		return
+	}
+	priority, _ := strconv.Atoi(string(parts.priority))
+	data, err := json.Marshal(tailLine{
+		Time:      parts.ts,
+		Container: cont.ShortHostname,
+		App:       cont.AppName,
+		Process:   cont.ProcessName,
+		Priority:  priority,
+		Class:     parts.class.String(),
+		Retention: parts.retention,
+		Received:  parts.received,
+		Sequence:  parts.sequence,
+		Piece:     parts.piece,
+		Pieces:    parts.pieces,
+		Stream:    parts.stream.String(),
+		TraceID:   string(parts.traceID),
+		SpanID:    string(parts.spanID),
+		Message:   string(parts.content),
+	})
+	if err != nil {
+		return
+	}
+	for ch := range tails {
+		select {
+		case ch <- data:
+		default:
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/container"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTailRegistry(c *check.C) {
+	var r tailRegistry
+	cont := &container.Container{
+		Container:     docker.Container{ID: "c1"},
+		AppName:       "myapp",
+		ProcessName:   "web",
+		ShortHostname: "c1short",
+	}
+	parts := &rawLogParts{
+		ts:        time.Date(2015, 6, 5, 16, 13, 47, 0, time.UTC),
+		priority:  []byte("30"),
+		content:   []byte("my msg"),
+		class:     classHigh,
+		retention: "7d",
+	}
+	r.publish(parts, cont)
+	lines, stop := r.add("c1")
+	other, stopOther := r.add("c2")
+	defer stopOther()
+	r.publish(parts, cont)
+	var line map[string]interface{}
+	err := json.Unmarshal(<-lines, &line)
+	c.Assert(err, check.IsNil)
+	c.Assert(line, check.DeepEquals, map[string]interface{}{
+		"time":      "2015-06-05T16:13:47Z",
+		"container": "c1short",
+		"app":       "myapp",
+		"process":   "web",
+		"priority":  float64(30),
+		"class":     "high",
+		"retention": "7d",
+		"message":   "my msg",
+	})
+	c.Assert(other, check.HasLen, 0)
+	stop()
+	stop()
+	c.Assert(r.count, check.Equals, int32(1))
+	r.publish(parts, cont)
+	c.Assert(lines, check.HasLen, 0)
+}
+
+func (s *S) TestTailRegistryFullBuffer(c *check.C) {
+	var r tailRegistry
+	cont := &container.Container{Container: docker.Container{ID: "c1"}}
+	lines, stop := r.add("c1")
+	defer stop()
+	for i := 0; i < tailBufferSize+10; i++ {
+		r.publish(&rawLogParts{content: []byte("msg")}, cont)
+	}
+	c.Assert(lines, check.HasLen, tailBufferSize)
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/supervisor"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// tcpReader receives syslog messages in a TCP socket, one message per line,
+// replacing the TCP listener in go-syslog so the sockets can be tuned.
+type tcpReader struct {
+	listener *net.TCPListener
+	format   format.Format
+	handler  syslog.Handler
+	rcvBuf   int
+	maxLine  int
+	logOnce  sync.Once
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func newTCPReader(addr string, f format.Format, handler syslog.Handler, rcvBuf, maxLine int) (*tcpReader, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpReader{
+		listener: listener,
+		format:   f,
+		handler:  handler,
+		rcvBuf:   rcvBuf,
+		maxLine:  maxLine,
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+func (r *tcpReader) start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			conn, err := r.listener.AcceptTCP()
+			if err != nil {
+				if opErr, ok := err.(*net.OpError); ok && !opErr.Temporary() {
+					return
+				}
+				continue
+			}
+			if !r.track(conn) {
+				conn.Close()
+				return
+			}
+			if r.rcvBuf > 0 {
+				r.setReadBuffer(conn)
+			}
+			r.wg.Add(1)
+			go r.scan(conn)
+		}
+	}()
+}
+
+func (r *tcpReader) setReadBuffer(conn *net.TCPConn) {
+	err := conn.SetReadBuffer(r.rcvBuf)
+	r.logOnce.Do(func() {
+		if err != nil {
+			bslog.Warnf("[log forwarder] unable to set tcp socket receive buffer to %d bytes: %s", r.rcvBuf, err)
+			return
+		}
+		logReceiveBuffer("tcp", conn, r.rcvBuf)
+	})
+}
+
+func (r *tcpReader) track(conn net.Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *tcpReader) scan(conn net.Conn) {
+	defer r.wg.Done()
+	defer func() {
+		conn.Close()
+		r.mu.Lock()
+		delete(r.conns, conn)
+		r.mu.Unlock()
+	}()
+	scanner := bufio.NewScanner(conn)
+	if sf := r.format.GetSplitFunc(); sf != nil {
+		scanner.Split(sf)
+	} else {
+		scanner.Buffer(make([]byte, 4096), r.maxLine)
+		scanner.Split(scanLimitedLines(r.maxLine))
+	}
+	supervisor.Run("log tcp reader", func() {
+		for scanner.Scan() {
+			handleLine(r.format, r.handler, scanner.Bytes())
+		}
+	})
+}
+
+// stop closes the listener and every open connection.
+func (r *tcpReader) stop() {
+	r.listener.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for conn := range r.conns {
+		conn.Close()
+	}
+}
+
+func (r *tcpReader) wait() {
+	r.wg.Wait()
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"net"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTCPReader(c *check.C) {
+	handler := &recordingHandler{}
+	r, err := newTCPReader("127.0.0.1:0", &LenientFormat{}, handler, 65536, defaultMaxLineSize)
+	c.Assert(err, check.IsNil)
+	r.start()
+	conn, err := net.Dial("tcp", r.listener.Addr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, msg := range []string{"msg1", "msg2", "msg3"} {
+		_, err = conn.Write([]byte("<30>2015-06-05T16:13:47Z myhost docker/00dfa98fe8e0[4843]: " + msg + "\n"))
+		c.Assert(err, check.IsNil)
+	}
+	contents := handler.waitContents(c, 3)
+	c.Assert(contents, check.DeepEquals, []string{"msg1", "msg2", "msg3"})
+	done := make(chan struct{})
+	go func() {
+		// Open connections must not prevent the reader from stopping.
+		r.stop()
+		r.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for reader to stop")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+var (
+	traceparentKey = []byte("traceparent")
+	traceIDKeys    = [][]byte{[]byte("trace_id"), []byte("traceid"), []byte("trace-id")}
+	spanIDKeys     = [][]byte{[]byte("span_id"), []byte("spanid"), []byte("span-id")}
+)
+
+// extractTraceContext looks for the trace and span IDs of the request that
+// produced a log line, either in a W3C traceparent value or in trace and span
+// ID fields, like X-B3-TraceId and X-B3-SpanId headers or OpenTelemetry
+// trace_id and span_id fields. Keys are matched in any case and may be
+// followed by ':' or '=', optionally quoted. The returned slices point into
+// content.
+func extractTraceContext(content []byte) (traceID, spanID []byte) {
+	if value := fieldValue(content, traceparentKey); value != nil {
+		if traceID, spanID, ok := parseTraceparent(value); ok {
+			return traceID, spanID
+		}
+	}
+	for _, key := range traceIDKeys {
+		if traceID = hexID(fieldValue(content, key), 16, 32); traceID != nil {
+			break
+		}
+	}
+	if traceID == nil {
+		return nil, nil
+	}
+	for _, key := range spanIDKeys {
+		if spanID = hexID(fieldValue(content, key), 16, 16); spanID != nil {
+			break
+		}
+	}
+	return traceID, spanID
+}
+
+// parseTraceparent parses values in the version-traceid-parentid-flags
+// format, e.g. 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01.
+func parseTraceparent(value []byte) (traceID, spanID []byte, ok bool) {
+	const size = 55
+	if len(value) < size || (len(value) > size && isWordChar(value[size])) {
+		return nil, nil, false
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return nil, nil, false
+	}
+	if !isHex(value[:2]) || string(value[:2]) == "ff" || !isHex(value[53:size]) {
+		return nil, nil, false
+	}
+	traceID = hexID(value[3:35], 32, 32)
+	spanID = hexID(value[36:52], 16, 16)
+	return traceID, spanID, traceID != nil && spanID != nil
+}
+
+// fieldValue returns what follows key, matched in any case, and the
+// separator in "key: value", "key=value" or "key":"value" forms.
+func fieldValue(data, key []byte) []byte {
+	for i := 0; i+len(key) <= len(data); i++ {
+		if !hasPrefixFold(data[i:], key) || (i > 0 && isWordChar(data[i-1])) {
+			continue
+		}
+		rest := data[i+len(key):]
+		if len(rest) > 0 && (rest[0] == '"' || rest[0] == '\'') {
+			rest = rest[1:]
+		}
+		rest = trimBlank(rest)
+		if len(rest) == 0 || (rest[0] != ':' && rest[0] != '=') {
+			continue
+		}
+		rest = trimBlank(rest[1:])
+		if len(rest) > 0 && (rest[0] == '"' || rest[0] == '\'') {
+			rest = rest[1:]
+		}
+		return rest
+	}
+	return nil
+}
+
+// hexID returns the hex digits at the start of value if there are exactly
+// min or max of them and they are not all zeros, which is an invalid ID.
+func hexID(value []byte, min, max int) []byte {
+	n := 0
+	for n < len(value) && n <= max && isHexDigit(value[n]) {
+		n++
+	}
+	if (n != min && n != max) || (n < len(value) && isWordChar(value[n])) {
+		return nil
+	}
+	for _, c := range value[:n] {
+		if c != '0' {
+			return value[:n]
+		}
+	}
+	return nil
+}
+
+func hasPrefixFold(data, prefix []byte) bool {
+	if len(data) < len(prefix) {
+		return false
+	}
+	for i, c := range prefix {
+		d := data[i]
+		if d >= 'A' && d <= 'Z' {
+			d += 'a' - 'A'
+		}
+		if d != c {
+			return false
+		}
+	}
+	return true
+}
+
+func trimBlank(data []byte) []byte {
+	for len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+		data = data[1:]
+	}
+	return data
+}
+
+func isHex(data []byte) bool {
+	for _, c := range data {
+		if !isHexDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
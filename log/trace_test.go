@@ -0,0 +1,53 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestExtractTraceContext(c *check.C) {
+	tests := []struct {
+		content string
+		traceID string
+		spanID  string
+	}{
+		{"traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01 GET /", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"},
+		{`{"msg":"ok","traceparent":"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"}`, "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"},
+		{"Traceparent=00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"},
+		{"traceparent: ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "", ""},
+		{"traceparent: 00-00000000000000000000000000000000-b7ad6b7169203331-01", "", ""},
+		{"traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01x", "", ""},
+		{"X-B3-TraceId: 463ac35c9f6413ad X-B3-SpanId: a2fb4a1d1a96d312", "463ac35c9f6413ad", "a2fb4a1d1a96d312"},
+		{"x-b3-traceid=463ac35c9f6413ad48485a3953bb6124", "463ac35c9f6413ad48485a3953bb6124", ""},
+		{`{"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736", "span_id": "00f067aa0ba902b7"}`, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{`level=info trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id="00f067aa0ba902b7" msg=done`, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"},
+		{"span_id=00f067aa0ba902b7 without trace", "", ""},
+		{"trace_id=4bf92f3577b34da6 span_id=xyz", "4bf92f3577b34da6", ""},
+		{"trace_id=4bf92f3577b34da6a3ce929d0e0e4736ff", "", ""},
+		{"trace_id=4bf92f35", "", ""},
+		{"TrACeId:00000000000000001", "", ""},
+		{"trace_id=4bf92f3577b34da6a3ce", "", ""},
+		{"trace_id=4bf92f3577b34da6zz", "", ""},
+		{"subtrace_id=4bf92f3577b34da6", "", ""},
+		{"trace id 4bf92f3577b34da6", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		traceID, spanID := extractTraceContext([]byte(tt.content))
+		c.Check(string(traceID), check.Equals, tt.traceID, check.Commentf("%q", tt.content))
+		c.Check(string(spanID), check.Equals, tt.spanID, check.Commentf("%q", tt.content))
+	}
+}
+
+func BenchmarkExtractTraceContext(b *testing.B) {
+	content := []byte(`level=info msg="request finished" status=200 trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		extractTraceContext(content)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/supervisor"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const (
+	defaultUDPReadBatch = 32
+	maxDatagramSize     = 64 * 1024
+)
+
+// datagramReader reads datagrams from a socket. read blocks until at least one
+// datagram is available, the returned slices are only valid until the next
+// call.
+type datagramReader interface {
+	read() ([][]byte, error)
+}
+
+// udpReader receives syslog messages in an UDP socket, replacing the UDP
+// listener in go-syslog. Where supported, datagrams are read in batches,
+// reducing the number of syscalls during bursts.
+type udpReader struct {
+	conn       *net.UDPConn
+	reader     datagramReader
+	format     format.Format
+	handler    syslog.Handler
+	supervisor *supervisor.Supervisor
+	wg         sync.WaitGroup
+}
+
+func newUDPReader(addr string, f format.Format, handler syslog.Handler, batch, rcvBuf int) (*udpReader, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	if rcvBuf > 0 {
+		err = conn.SetReadBuffer(rcvBuf)
+		if err != nil {
+			bslog.Warnf("[log forwarder] unable to set udp socket receive buffer to %d bytes: %s", rcvBuf, err)
+		} else {
+			logReceiveBuffer("udp", conn, rcvBuf)
+		}
+	} else {
+		conn.SetReadBuffer(maxDatagramSize)
+	}
+	reader, err := newDatagramReader(conn, batch)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &udpReader{
+		conn:       conn,
+		reader:     reader,
+		format:     f,
+		handler:    handler,
+		supervisor: supervisor.Default,
+	}, nil
+}
+
+func (r *udpReader) start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.supervisor.Run("log udp reader", r.readLoop)
+	}()
+}
+
+func (r *udpReader) readLoop() {
+	for {
+		msgs, err := r.reader.read()
+		if err != nil {
+			if opErr, ok := err.(*net.OpError); ok && !opErr.Temporary() && !opErr.Timeout() {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		for _, msg := range msgs {
+			handleDatagram(r.format, r.handler, msg)
+		}
+	}
+}
+
+// drops returns the number of datagrams dropped by the kernel because the
+// socket receive buffer was full.
+func (r *udpReader) drops() (uint64, error) {
+	return socketDrops(r.conn)
+}
+
+func (r *udpReader) stop() {
+	r.conn.Close()
+}
+
+func (r *udpReader) wait() {
+	r.wg.Wait()
+}
+
+// singleReader reads one datagram per call.
+type singleReader struct {
+	conn *net.UDPConn
+	buf  []byte
+	out  [][]byte
+}
+
+func newSingleReader(conn *net.UDPConn) *singleReader {
+	return &singleReader{
+		conn: conn,
+		buf:  make([]byte, maxDatagramSize),
+		out:  make([][]byte, 1),
+	}
+}
+
+func (r *singleReader) read() ([][]byte, error) {
+	n, _, err := r.conn.ReadFrom(r.buf)
+	if err != nil {
+		return nil, err
+	}
+	r.out[0] = r.buf[:n]
+	return r.out, nil
+}
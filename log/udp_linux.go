@@ -0,0 +1,83 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr matches struct mmsghdr from recvmmsg(2).
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// mmsgReader reads up to len(bufs) datagrams per syscall using recvmmsg.
+type mmsgReader struct {
+	raw  syscall.RawConn
+	bufs [][]byte
+	iovs []unix.Iovec
+	msgs []mmsghdr
+	out  [][]byte
+}
+
+func newDatagramReader(conn *net.UDPConn, batch int) (datagramReader, error) {
+	if batch <= 1 {
+		return newSingleReader(conn), nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	r := &mmsgReader{
+		raw:  raw,
+		bufs: make([][]byte, batch),
+		iovs: make([]unix.Iovec, batch),
+		msgs: make([]mmsghdr, batch),
+		out:  make([][]byte, 0, batch),
+	}
+	for i := range r.bufs {
+		r.bufs[i] = make([]byte, maxDatagramSize)
+		r.iovs[i].Base = &r.bufs[i][0]
+		r.iovs[i].SetLen(maxDatagramSize)
+		r.msgs[i].hdr.Iov = &r.iovs[i]
+		r.msgs[i].hdr.Iovlen = 1
+	}
+	return r, nil
+}
+
+func (r *mmsgReader) read() ([][]byte, error) {
+	var n int
+	var errno syscall.Errno
+	err := r.raw.Read(func(fd uintptr) bool {
+		for {
+			r0, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.msgs[0])), uintptr(len(r.msgs)), unix.MSG_DONTWAIT, 0, 0)
+			switch e {
+			case unix.EINTR:
+				continue
+			case unix.EAGAIN:
+				return false
+			}
+			n, errno = int(r0), e
+			return true
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, os.NewSyscallError("recvmmsg", errno)
+	}
+	r.out = r.out[:0]
+	for i := 0; i < n; i++ {
+		r.out = append(r.out, r.bufs[i][:r.msgs[i].len])
+	}
+	return r.out, nil
+}
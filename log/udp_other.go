@@ -0,0 +1,14 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package log
+
+import "net"
+
+func newDatagramReader(conn *net.UDPConn, batch int) (datagramReader, error) {
+	return newSingleReader(conn), nil
+}
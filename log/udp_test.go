@@ -0,0 +1,139 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package log
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/supervisor"
+	"gopkg.in/check.v1"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+type recordingHandler struct {
+	mu       sync.Mutex
+	contents []string
+}
+
+func (h *recordingHandler) Handle(logParts format.LogParts, _ int64, err error) {
+	if err != nil {
+		return
+	}
+	parts := logParts["parts"].(*rawLogParts)
+	h.mu.Lock()
+	h.contents = append(h.contents, string(parts.content))
+	h.mu.Unlock()
+	parts.release()
+}
+
+func (h *recordingHandler) waitContents(c *check.C, n int) []string {
+	timeout := time.After(5 * time.Second)
+	for {
+		h.mu.Lock()
+		contents := append([]string(nil), h.contents...)
+		h.mu.Unlock()
+		if len(contents) >= n {
+			sort.Strings(contents)
+			return contents
+		}
+		select {
+		case <-timeout:
+			c.Fatalf("timeout waiting for %d messages, got %d", n, len(contents))
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// panickingHandler panics when handling messages containing "panic".
+type panickingHandler struct {
+	recordingHandler
+}
+
+func (h *panickingHandler) Handle(logParts format.LogParts, n int64, err error) {
+	if parts, ok := logParts["parts"].(*rawLogParts); ok && bytes.Contains(parts.content, []byte("panic")) {
+		panic("bad message")
+	}
+	h.recordingHandler.Handle(logParts, n, err)
+}
+
+func (s *S) testUDPReader(c *check.C, batch int) {
+	handler := &recordingHandler{}
+	r, err := newUDPReader("127.0.0.1:0", &LenientFormat{}, handler, batch, 0)
+	c.Assert(err, check.IsNil)
+	r.start()
+	conn, err := net.Dial("udp", r.conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, msg := range []string{"msg1\n", "msg2\x00", "msg3", "msg4\r\n"} {
+		_, err = conn.Write([]byte("<30>2015-06-05T16:13:47Z myhost docker/00dfa98fe8e0[4843]: " + msg))
+		c.Assert(err, check.IsNil)
+	}
+	_, err = conn.Write([]byte("\n\x00"))
+	c.Assert(err, check.IsNil)
+	contents := handler.waitContents(c, 4)
+	c.Assert(contents, check.DeepEquals, []string{"msg1", "msg2", "msg3", "msg4"})
+	r.stop()
+	r.wait()
+}
+
+func (s *S) TestUDPReader(c *check.C) {
+	s.testUDPReader(c, 1)
+}
+
+func (s *S) TestUDPReaderBatch(c *check.C) {
+	s.testUDPReader(c, 8)
+}
+
+func (s *S) TestUDPReaderRecoversFromPanic(c *check.C) {
+	logBuf := bytes.NewBuffer(nil)
+	prevLog := bslog.Logger
+	bslog.Logger = log.New(logBuf, "", 0)
+	defer func() {
+		bslog.Logger = prevLog
+	}()
+	handler := &panickingHandler{}
+	r, err := newUDPReader("127.0.0.1:0", &LenientFormat{}, handler, 1, 0)
+	c.Assert(err, check.IsNil)
+	r.supervisor = supervisor.New()
+	r.supervisor.MinBackoff = time.Millisecond
+	r.start()
+	conn, err := net.Dial("udp", r.conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	for _, msg := range []string{"panic", "msg1"} {
+		_, err = conn.Write([]byte("<30>2015-06-05T16:13:47Z myhost docker/00dfa98fe8e0[4843]: " + msg))
+		c.Assert(err, check.IsNil)
+	}
+	contents := handler.waitContents(c, 1)
+	c.Assert(contents, check.DeepEquals, []string{"msg1"})
+	r.stop()
+	r.wait()
+	c.Assert(r.supervisor.Panics(), check.DeepEquals, map[string]uint64{"log udp reader": 1})
+	c.Assert(strings.Contains(logBuf.String(), "recovered panic in log udp reader: bad message"), check.Equals, true)
+}
+
+func (s *S) TestUDPReaderStopWithoutMessages(c *check.C) {
+	r, err := newUDPReader("127.0.0.1:0", &LenientFormat{}, &recordingHandler{}, defaultUDPReadBatch, 0)
+	c.Assert(err, check.IsNil)
+	r.start()
+	done := make(chan struct{})
+	go func() {
+		r.stop()
+		r.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for reader to stop")
+	}
+}
@@ -17,8 +17,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/tsuru/bs/audit"
 	"github.com/tsuru/bs/bslog"
 	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/tsuruapi"
 	"github.com/tsuru/tsuru/app"
 	"golang.org/x/net/websocket"
 )
@@ -31,14 +33,13 @@ var (
 )
 
 type tsuruBackend struct {
-	msgCh      chan<- LogMessage
-	quitCh     chan<- bool
+	queue      *messageQueue
 	nextNotify *time.Timer
 }
 
 type wsForwarder struct {
 	url           string
-	token         string
+	token         *tsuruapi.TokenSource
 	connMutex     sync.Mutex
 	pingInterval  time.Duration
 	pongInterval  time.Duration
@@ -63,7 +64,9 @@ func (b *tsuruBackend) initialize() error {
 		bslog.Warnf("invalid WS pong interval %v (it must be higher than ping interval). Using the default value of %v", wsPongInterval, newPongInterval)
 		wsPongInterval = newPongInterval
 	}
-	wsConnMaxAge := config.SecondsEnvOrDefault(-1, "LOG_TSURU_CONN_MAX_AGE")
+	wsConnMaxAge := config.SecondsEnvOrDefault(defaultConnMaxAge, "LOG_TSURU_CONN_MAX_AGE")
+	shards := config.IntEnvOrDefault(defaultForwardShards, "LOG_TSURU_FORWARD_SHARDS", "LOG_FORWARD_SHARDS")
+	maxBytesPerSecond := config.IntEnvOrDefault(0, "LOG_TSURU_MAX_BYTES_PER_SECOND", "LOG_MAX_BYTES_PER_SECOND")
 	b.nextNotify = time.NewTimer(0)
 	tsuruUrl, err := url.Parse(config.Config.TsuruEndpoint)
 	if err != nil {
@@ -75,21 +78,22 @@ func (b *tsuruBackend) initialize() error {
 	} else {
 		tsuruUrl.Scheme = "ws"
 	}
-	forwardChan, quitChan, err := processMessages(&wsForwarder{
-		url:          tsuruUrl.String(),
-		token:        config.Config.TsuruToken,
-		pingInterval: wsPingInterval,
-		pongInterval: wsPongInterval,
-		connMaxAge:   wsConnMaxAge,
-	}, bufferSize)
-	if err != nil {
-		return err
-	}
-	b.msgCh = forwardChan
-	b.quitCh = quitChan
+	token := tsuruapi.NewTokenSource(config.Config.TsuruToken, config.Config.TsuruTokenFile)
+	b.queue = newMessageQueue(func() forwarderBackend {
+		return &wsForwarder{
+			url:          tsuruUrl.String(),
+			token:        token,
+			pingInterval: wsPingInterval,
+			pongInterval: wsPongInterval,
+			connMaxAge:   wsConnMaxAge,
+		}
+	}, shards, bufferSize, maxBytesPerSecond)
 	return nil
 }
 
+// sendMessage sends the message to tsuru. METRICS_EXTRA_TAGS isn't applied, as
+// the tsuru API has no field for tags and adding them to the message would
+// change the logs shown to users.
 func (b *tsuruBackend) sendMessage(parts *rawLogParts, appName, processName, container string) {
 	msg := &app.Applog{
 		Date:    parts.ts,
@@ -98,12 +102,11 @@ func (b *tsuruBackend) sendMessage(parts *rawLogParts, appName, processName, con
 		Source:  processName,
 		Unit:    container,
 	}
-	select {
-	case b.msgCh <- msg:
-	default:
+	if !b.queue.send(parts.key(container), msg, parts.class) {
 		select {
 		case <-b.nextNotify.C:
 			bslog.Errorf("Dropping log messages to tsuru due to full channel buffer.")
+			audit.Record("log", "dropped %d messages to tsuru due to full channel buffer", b.queue.takeDropped())
 			b.nextNotify.Reset(time.Minute)
 		default:
 		}
@@ -111,13 +114,21 @@ func (b *tsuruBackend) sendMessage(parts *rawLogParts, appName, processName, con
 }
 
 func (b *tsuruBackend) stop() {
-	close(b.quitCh)
+	b.queue.stop()
+}
+
+func (b *tsuruBackend) messageQueues() []*messageQueue {
+	return []*messageQueue{b.queue}
 }
 
 func (f *wsForwarder) initialize(quitCh <-chan bool) {
 	f.quitCh = quitCh
 }
 
+func (f *wsForwarder) String() string {
+	return "tsuru " + f.url
+}
+
 func (f *wsForwarder) connect() (net.Conn, error) {
 	config, err := websocket.NewConfig(f.url, "ws://localhost/")
 	if err != nil {
@@ -126,7 +137,7 @@ func (f *wsForwarder) connect() (net.Conn, error) {
 	if testTlsConfig != nil {
 		config.TlsConfig = testTlsConfig
 	}
-	config.Header.Add("Authorization", "bearer "+f.token)
+	config.Header.Add("Authorization", "bearer "+f.token.Token())
 	var client net.Conn
 	host, port, _ := net.SplitHostPort(config.Location.Host)
 	if host == "" {
@@ -141,12 +152,12 @@ func (f *wsForwarder) connect() (net.Conn, error) {
 		if port == "" {
 			port = "80"
 		}
-		client, err = dialer.Dial("tcp", fmt.Sprintf("%s:%s", host, port))
+		client, err = dialer.Dial("tcp", net.JoinHostPort(host, port))
 	case "wss":
 		if port == "" {
 			port = "443"
 		}
-		client, err = tls.DialWithDialer(dialer, "tcp", fmt.Sprintf("%s:%s", host, port), config.TlsConfig)
+		client, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, port), config.TlsConfig)
 	default:
 		err = websocket.ErrBadScheme
 	}
@@ -154,7 +165,10 @@ func (f *wsForwarder) connect() (net.Conn, error) {
 		return nil, err
 	}
 	f.connCreatedAt = time.Now()
-	f.expireConnCh = make(chan bool)
+	// The goroutines of the connection use their own copy of the channel,
+	// as the field is replaced by the next connection.
+	expireConnCh := make(chan bool)
+	f.expireConnCh = expireConnCh
 	ws, err := websocket.NewClient(config, client)
 	if err != nil {
 		client.Close()
@@ -176,7 +190,7 @@ func (f *wsForwarder) connect() (net.Conn, error) {
 			frame, err := ws.NewFrameReader()
 			if err != nil {
 				select {
-				case <-f.expireConnCh:
+				case <-expireConnCh:
 					return
 				default:
 				}
@@ -197,7 +211,7 @@ func (f *wsForwarder) connect() (net.Conn, error) {
 			case <-time.After(f.pingInterval):
 			case <-f.quitCh:
 				return
-			case <-f.expireConnCh:
+			case <-expireConnCh:
 				return
 			}
 			err := f.writeWithDeadline(ws, pingWriter, []byte{'z'})
@@ -7,32 +7,47 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/fsouza/go-dockerclient"
 	"github.com/google/gops/agent"
+	"github.com/tsuru/bs/api"
+	"github.com/tsuru/bs/audit"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/buildinfo"
+	"github.com/tsuru/bs/clock"
 	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/dryrun"
+	"github.com/tsuru/bs/heartbeat"
 	"github.com/tsuru/bs/log"
+	"github.com/tsuru/bs/maintenance"
 	"github.com/tsuru/bs/metric"
 	_ "github.com/tsuru/bs/metric/logstash"
+	"github.com/tsuru/bs/netprobe"
+	"github.com/tsuru/bs/node"
+	"github.com/tsuru/bs/privilege"
+	"github.com/tsuru/bs/problem"
+	"github.com/tsuru/bs/startup"
+	"github.com/tsuru/bs/statedump"
 	"github.com/tsuru/bs/status"
+	"github.com/tsuru/bs/supervisor"
+	"github.com/tsuru/bs/tsuruapi"
+	"github.com/tsuru/bs/watchdog"
 )
 
-const (
-	version = "v1.12"
-)
-
-var printVersion bool
-
 type StopWaiter interface {
 	Stop()
 	Wait()
 }
 
 func init() {
-	flag.BoolVar(&printVersion, "version", false, "Print version and exit")
+	rand.Seed(time.Now().UnixNano())
 }
 
 func startSignalHandler(callback func(os.Signal), signals ...os.Signal) {
@@ -45,7 +60,81 @@ func startSignalHandler(callback func(os.Signal), signals ...os.Signal) {
 	signal.Notify(sigChan, signals...)
 }
 
+// newStartupWaiter returns a waiter for the Docker daemon, the host proc
+// filesystem and the tsuru API, leaving out the ones not configured.
+func newStartupWaiter(tsuruClient *tsuruapi.Client) *startup.Waiter {
+	waiter := &startup.Waiter{Timeout: config.Config.StartupWaitTimeout}
+	if infoClient, err := container.NewClient(config.Config.DockerEndpoint); err == nil {
+		waiter.Add(startup.DockerDependency(infoClient.GetClient()))
+	}
+	if procPath := os.Getenv("HOST_PROC"); procPath != "" {
+		waiter.Add(startup.ProcDependency(procPath))
+	}
+	if config.Config.TsuruEndpoint != "" {
+		waiter.Add(startup.TsuruDependency(tsuruClient))
+	}
+	return waiter
+}
+
+// netProbes returns the network probes configured in the environment. The
+// dockerClient may be nil, disabling the pull of the registry probe.
+func netProbes(dockerClient *docker.Client) []netprobe.Probe {
+	var probes []netprobe.Probe
+	for _, name := range config.Config.NetProbeDNSNames {
+		probes = append(probes, &netprobe.DNSProbe{Name: name, Timeout: config.Config.NetProbeTimeout})
+	}
+	for _, spec := range config.Config.NetProbeHTTPURLs {
+		probe, err := netprobe.ParseHTTPProbe(spec, config.Config.NetProbeTimeout)
+		if err != nil {
+			bslog.Warnf("Skipping network probe: %s", err)
+			continue
+		}
+		probes = append(probes, probe)
+	}
+	if image := config.Config.NetProbeImage; image != "" {
+		probe := &netprobe.RegistryProbe{
+			Image:    image,
+			Username: config.Config.NetProbeUsername,
+			Password: config.Config.NetProbePassword,
+			Insecure: config.Config.NetProbeInsecure,
+			Timeout:  config.Config.NetProbeTimeout,
+		}
+		if config.Config.NetProbePull {
+			if dockerClient == nil {
+				bslog.Warnf("Docker client unavailable, requesting the manifest of %s instead of pulling it", image)
+			}
+			probe.Docker = dockerClient
+		}
+		probes = append(probes, probe)
+	}
+	return probes
+}
+
 func main() {
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "bs: unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "bs %s: %s\n", cmd.name, err)
+		os.Exit(1)
+	}
+}
+
+// run runs bs as a daemon, until it's signaled to stop.
+func run(args []string) error {
+	flags := flag.NewFlagSet("bs run", flag.ExitOnError)
+	printVersion := flags.Bool("version", false, "Print version and exit")
+	flags.Parse(args)
+	if *printVersion {
+		return version(nil)
+	}
 	err := agent.Listen(&agent.Options{
 		NoShutdownCleanup: true,
 	})
@@ -53,50 +142,270 @@ func main() {
 		bslog.Fatalf("Unable to initialize gops agent: %s\n", err)
 	}
 	defer agent.Close()
-	flag.Parse()
-	if printVersion {
-		fmt.Printf("bs version %s\n", version)
-		return
+	info := buildinfo.Get()
+	bslog.Infof("bs %s starting, features: %s\n", info, strings.Join(info.Features, ","))
+	err = audit.Setup(config.Config.AuditLog, config.Config.AuditLogFacility)
+	if err != nil {
+		bslog.Warnf("Unable to initialize audit log: %s\n", err)
+	}
+	audit.Record("bs", "bs %s started with config: %s", info, config.Summary())
+	privilege.Report(privilege.Operations(config.Config.DockerEndpoint)...)
+	tsuruClient := tsuruapi.NewClient(tsuruapi.Config{
+		Endpoint:        config.Config.TsuruEndpoint,
+		Token:           config.Config.TsuruToken,
+		TokenFile:       config.Config.TsuruTokenFile,
+		DialTimeout:     config.Config.TsuruDialTimeout,
+		RequestTimeout:  config.Config.TsuruRequestTimeout,
+		IdleConnTimeout: config.Config.TsuruIdleTimeout,
+		MaxRetries:      config.Config.TsuruMaxRetries,
+	})
+	if config.Config.DryRun {
+		err = dryrun.Setup(config.Config.DryRunOutput)
+		if err != nil {
+			bslog.Fatalf("Unable to initialize dry-run mode: %s\n", err)
+		}
+		bslog.Warnf("Running in dry-run mode, logs and metrics won't be sent\n")
+	}
+	var waiter *startup.Waiter
+	if config.Config.StartupWaitTimeout > 0 {
+		waiter = newStartupWaiter(tsuruClient)
+		if err = waiter.Wait(); err != nil {
+			bslog.Errorf("%s, starting anyway\n", err)
+			audit.Record("bs", "starting with %s", err)
+		}
+	}
+	var nodeMetadata *node.MetadataCache
+	if config.Config.NodeMetadataEnabled {
+		addrs, err := node.GetNodeAddrs()
+		if err != nil {
+			bslog.Warnf("Unable to get network addresses for node metadata: %s\n", err)
+		} else {
+			nodeMetadata = node.NewMetadataCache(tsuruClient, addrs, config.Config.NodeMetadataTTL)
+		}
 	}
 	lf := log.LogForwarder{
 		BindAddress:     config.Config.SyslogListenAddress,
 		DockerEndpoint:  config.Config.DockerEndpoint,
 		EnabledBackends: config.Config.LogBackends,
+		NodeMetadata:    nodeMetadata,
 	}
 	err = lf.Start()
 	if err != nil {
 		bslog.Fatalf("Unable to initialize log forwarder: %s\n", err)
 	}
+	var dockerClient *docker.Client
+	if infoClient, err := container.NewClient(config.Config.DockerEndpoint); err == nil {
+		dockerClient = infoClient.GetClient()
+	} else {
+		bslog.Warnf("Unable to create docker client: %s\n", err)
+	}
+	var detector *problem.Detector
+	if config.Config.NodeProblemEnabled {
+		interval := config.Config.NodeProblemInterval
+		if interval == 0 {
+			interval = config.Config.StatusInterval
+		}
+		detector = problem.NewDetector(dockerClient, interval)
+		detector.Start()
+	}
+	var skewMonitor *clock.SkewMonitor
+	if config.Config.ClockSkewEnabled {
+		skewMonitor = clock.NewSkewMonitor(clock.SkewConfig{
+			NTPServer:   config.Config.ClockSkewNTPServer,
+			TsuruClient: tsuruClient,
+			Interval:    config.Config.ClockSkewInterval,
+			Threshold:   config.Config.ClockSkewThreshold,
+		})
+		skewMonitor.Start()
+	}
+	var beat *heartbeat.Sender
+	if config.Config.HeartbeatInterval > 0 && !config.HeartbeatEnabled() {
+		bslog.Warnf("Heartbeats disabled: HEARTBEAT_INTERVAL requires HEARTBEAT_URL or HEARTBEAT_PATH")
+	}
+	if config.HeartbeatEnabled() {
+		addrs, err := node.GetNodeAddrs()
+		if err != nil {
+			bslog.Warnf("Unable to get network addresses for heartbeats: %s\n", err)
+		}
+		beat = heartbeat.NewSender(heartbeat.Config{
+			TsuruClient: tsuruClient,
+			Path:        config.Config.HeartbeatPath,
+			URL:         config.Config.HeartbeatURL,
+			Addrs:       addrs,
+			Interval:    config.Config.HeartbeatInterval,
+		})
+		beat.Start()
+	}
+	var prober *netprobe.Prober
+	if config.NetProbeEnabled() {
+		prober = netprobe.NewProber(netProbes(dockerClient), config.Config.NetProbeInterval)
+		prober.Start()
+	}
+	var gc *maintenance.GarbageCollector
+	if config.Config.GCEnabled && dockerClient != nil {
+		gc = maintenance.NewGarbageCollector(dockerClient, maintenance.GCConfig{
+			Interval:  config.Config.GCInterval,
+			DiskPath:  config.Config.GCDiskPath,
+			Threshold: float64(config.Config.GCThreshold),
+			MinAge:    config.Config.GCMinAge,
+		})
+		gc.Start()
+	}
+	var dog *watchdog.Watchdog
+	if config.Config.WatchdogEnabled {
+		dog = watchdog.New(watchdog.Config{
+			Interval:         config.Config.WatchdogInterval,
+			StalledIntervals: config.Config.WatchdogStalled,
+			Action:           config.Config.WatchdogAction,
+		})
+		dog.Add("log forwarder", &lf)
+	}
+	hostSources := []metric.HostMetricsSource{&lf, metric.SupervisorSource(supervisor.Default), info}
+	if detector != nil {
+		hostSources = append(hostSources, detector)
+	}
+	if skewMonitor != nil {
+		hostSources = append(hostSources, skewMonitor)
+	}
+	if beat != nil {
+		hostSources = append(hostSources, beat)
+	}
+	if prober != nil {
+		hostSources = append(hostSources, prober)
+	}
+	if gc != nil {
+		hostSources = append(hostSources, gc)
+	}
+	if dog != nil {
+		hostSources = append(hostSources, dog)
+	}
 	mRunner := metric.NewRunner(config.Config.DockerEndpoint, config.Config.MetricsInterval,
 		config.Config.MetricsBackend)
+	mRunner.SetNodeMetadata(nodeMetadata)
+	mRunner.SetIntervalJitter(config.Config.MetricsJitter)
+	for _, source := range hostSources {
+		mRunner.AddHostMetricsSource(source)
+	}
+	mRunner.AddContainerMetricsSource(&lf)
+	flushers := []api.Flusher{&lf}
 	err = mRunner.Start()
 	if err != nil {
 		bslog.Warnf("Unable to initialize metrics runner: %s\n", err)
+	} else {
+		flushers = append(flushers, mRunner)
+		if dog != nil {
+			dog.Add("metrics runner", mRunner)
+		}
+	}
+	if dog != nil {
+		dog.Start()
+	}
+	drift, err := config.CheckDrift(config.Config.FingerprintFile, time.Now())
+	if err != nil {
+		bslog.Warnf("Unable to save config fingerprint: %s\n", err)
+	}
+	if drift.Drifted() {
+		bslog.Warnf("Config changed since the last start (%s -> %s): %s\n", drift.Previous, drift.Fingerprint, strings.Join(drift.Changed, ", "))
+		audit.Record("bs", "config changed since the last start (%s -> %s): %s", drift.Previous, drift.Fingerprint, strings.Join(drift.Changed, ", "))
 	}
 	reporter, err := status.NewReporter(&status.ReporterConfig{
-		TsuruEndpoint:  config.Config.TsuruEndpoint,
-		TsuruToken:     config.Config.TsuruToken,
-		DockerEndpoint: config.Config.DockerEndpoint,
-		Interval:       config.Config.StatusInterval,
+		TsuruEndpoint:    config.Config.TsuruEndpoint,
+		TsuruToken:       config.Config.TsuruToken,
+		TsuruClient:      tsuruClient,
+		DockerEndpoint:   config.Config.DockerEndpoint,
+		Interval:         config.Config.StatusInterval,
+		Compress:         config.Config.StatusCompress,
+		ChunkSize:        config.Config.StatusChunkSize,
+		Differential:     config.Config.StatusDifferential,
+		FullSyncInterval: config.Config.StatusFullSync,
+		Jitter:           config.Config.StatusJitter,
+		RetryQueueSize:   config.Config.StatusRetryQueue,
+		Problems:         detector,
+		Drift:            &drift,
 	})
 	if err != nil {
 		bslog.Warnf("Unable to initialize status reporter: %s\n", err)
 	}
+	var apiServer *api.Server
+	if config.Config.APIListenAddress != "" || config.Config.APIGRPCAddress != "" {
+		apiServer = &api.Server{
+			Address:        config.Config.APIListenAddress,
+			GRPCAddress:    config.Config.APIGRPCAddress,
+			DockerEndpoint: config.Config.DockerEndpoint,
+			SocketOwner:    config.Config.APISocketOwner,
+			SocketGroup:    config.Config.APISocketGroup,
+			SocketMode:     config.Config.APISocketMode,
+			LogTailer:      &lf,
+			Forwarders:     &lf,
+			Flushers:       flushers,
+			AdminToken:     config.Config.APIAdminToken,
+			TLSCertFile:    config.Config.APITLSCertFile,
+			TLSKeyFile:     config.Config.APITLSKeyFile,
+		}
+		if waiter != nil {
+			apiServer.Readiness = waiter
+		}
+		for _, source := range hostSources {
+			apiServer.AddHostMetricsSource(source)
+		}
+		if err = apiServer.Start(); err != nil {
+			bslog.Warnf("Unable to initialize local API: %s\n", err)
+			apiServer = nil
+		}
+	}
 	monitorEl := []StopWaiter{&lf, mRunner}
 	if reporter != nil {
 		monitorEl = append(monitorEl, reporter)
 	}
+	if detector != nil {
+		monitorEl = append(monitorEl, detector)
+	}
+	if skewMonitor != nil {
+		monitorEl = append(monitorEl, skewMonitor)
+	}
+	if beat != nil {
+		monitorEl = append(monitorEl, beat)
+	}
+	if prober != nil {
+		monitorEl = append(monitorEl, prober)
+	}
+	if gc != nil {
+		monitorEl = append(monitorEl, gc)
+	}
+	if dog != nil {
+		monitorEl = append(monitorEl, dog)
+	}
+	if apiServer != nil {
+		monitorEl = append(monitorEl, apiServer)
+	}
+	dumper := &statedump.Dumper{}
+	dumper.Add("log forwarder", &lf)
+	dumper.Add("metrics runner", mRunner)
+	dumper.Add("supervisor", supervisor.Default)
+	if beat != nil {
+		dumper.Add("heartbeat", beat)
+	}
+	if prober != nil {
+		dumper.Add("network prober", prober)
+	}
+	if dog != nil {
+		dumper.Add("watchdog", dog)
+	}
+	dumper.Notify(os.Stderr, syscall.SIGQUIT)
 	var signaled bool
 	startSignalHandler(func(signal os.Signal) {
 		signaled = true
+		audit.Record("bs", "bs stopping on %s", signal)
 		for _, m := range monitorEl {
 			go m.Stop()
 		}
-	}, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	}, os.Interrupt, syscall.SIGTERM)
 	for _, m := range monitorEl {
 		m.Wait()
 	}
+	audit.Flush(5 * time.Second)
 	if !signaled {
 		bslog.Fatalf("Exiting bs because no service could be initialized.")
 	}
+	return nil
 }
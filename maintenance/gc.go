@@ -0,0 +1,231 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package maintenance implements node maintenance tasks, like removing
+// unused Docker containers and images when the node is running out of disk.
+package maintenance
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/tsuru/bs/audit"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/supervisor"
+)
+
+const (
+	DefaultGCInterval  = 5 * time.Minute
+	DefaultGCThreshold = 85
+	DefaultGCMinAge    = time.Hour
+)
+
+// Overridden by tests.
+var diskUsage = disk.DiskUsage
+
+type GCConfig struct {
+	// Interval is the interval between disk usage checks.
+	Interval time.Duration
+	// DiskPath is the path whose filesystem usage is checked.
+	DiskPath string
+	// Threshold is the disk usage percentage that triggers a collection.
+	Threshold float64
+	// MinAge is how long a container must be exited before being removed.
+	MinAge time.Duration
+}
+
+// GarbageCollector removes exited containers and dangling images when the
+// disk usage crosses a threshold. Containers belonging to tsuru apps are
+// never removed, as tsuru may start them again.
+type GarbageCollector struct {
+	client *docker.Client
+	config GCConfig
+	mu     sync.Mutex
+	stats  gcStats
+	abort  chan struct{}
+	exit   chan struct{}
+}
+
+type gcStats struct {
+	runs              int64
+	containersRemoved int64
+	imagesRemoved     int64
+	reclaimedBytes    int64
+}
+
+func NewGarbageCollector(client *docker.Client, config GCConfig) *GarbageCollector {
+	if config.Interval <= 0 {
+		config.Interval = DefaultGCInterval
+	}
+	if config.DiskPath == "" {
+		config.DiskPath = "/"
+	}
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultGCThreshold
+	}
+	if config.MinAge <= 0 {
+		config.MinAge = DefaultGCMinAge
+	}
+	return &GarbageCollector{
+		client: client,
+		config: config,
+		abort:  make(chan struct{}),
+		exit:   make(chan struct{}),
+	}
+}
+
+// Start checks the disk usage periodically, collecting garbage when needed,
+// until Stop is called.
+func (g *GarbageCollector) Start() {
+	supervisor.Go("garbage collector", func() {
+		for {
+			g.Run()
+			select {
+			case <-g.abort:
+				close(g.exit)
+				return
+			case <-time.After(g.config.Interval):
+			}
+		}
+	})
+}
+
+// Stop stops the collector, blocking until it actually stops.
+func (g *GarbageCollector) Stop() {
+	close(g.abort)
+	<-g.exit
+}
+
+// Wait blocks until the collector stops.
+func (g *GarbageCollector) Wait() {
+	<-g.exit
+}
+
+// Run collects garbage if the disk usage is above the threshold.
+func (g *GarbageCollector) Run() {
+	usage, err := diskUsage(g.config.DiskPath)
+	if err != nil {
+		bslog.Errorf("[docker gc] unable to get disk usage for %s: %s", g.config.DiskPath, err)
+		return
+	}
+	if usage.UsedPercent < g.config.Threshold {
+		return
+	}
+	bslog.Warnf("[docker gc] disk usage for %s is %.1f%%, above the %.1f%% threshold, collecting garbage",
+		g.config.DiskPath, usage.UsedPercent, g.config.Threshold)
+	containers, containersSize := g.removeContainers()
+	images, imagesSize := g.removeImages()
+	bslog.Warnf("[docker gc] removed %d containers and %d images, reclaiming %d bytes",
+		containers, images, containersSize+imagesSize)
+	audit.Record("gc", "disk usage for %s was %.1f%%, removed %d containers and %d images, reclaiming %d bytes",
+		g.config.DiskPath, usage.UsedPercent, containers, images, containersSize+imagesSize)
+	g.mu.Lock()
+	g.stats.runs++
+	g.stats.containersRemoved += containers
+	g.stats.imagesRemoved += images
+	g.stats.reclaimedBytes += containersSize + imagesSize
+	g.mu.Unlock()
+}
+
+func (g *GarbageCollector) removeContainers() (int64, int64) {
+	containers, err := g.client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Size:    true,
+		Filters: map[string][]string{"status": {"exited"}},
+	})
+	if err != nil {
+		bslog.Errorf("[docker gc] unable to list containers: %s", err)
+		return 0, 0
+	}
+	var removed, size int64
+	for _, c := range containers {
+		cont, err := g.client.InspectContainer(c.ID)
+		if err != nil {
+			bslog.Errorf("[docker gc] unable to inspect container %s: %s", c.ID, err)
+			continue
+		}
+		if !g.removable(cont) {
+			continue
+		}
+		err = g.client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, RemoveVolumes: true})
+		if err != nil {
+			bslog.Errorf("[docker gc] unable to remove container %s: %s", c.ID, err)
+			continue
+		}
+		audit.Record("gc", "removed container %s (%s), exited at %s", c.ID, c.Image, cont.State.FinishedAt.Format(time.RFC3339))
+		removed++
+		size += c.SizeRw
+	}
+	return removed, size
+}
+
+func (g *GarbageCollector) removable(cont *docker.Container) bool {
+	if cont.State.Running || cont.State.Paused || cont.State.Restarting {
+		return false
+	}
+	if cont.State.FinishedAt.IsZero() || time.Since(cont.State.FinishedAt) < g.config.MinAge {
+		return false
+	}
+	if cont.Config != nil {
+		for _, env := range cont.Config.Env {
+			if strings.HasPrefix(env, "TSURU_APPNAME=") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (g *GarbageCollector) removeImages() (int64, int64) {
+	images, err := g.client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{"dangling": {"true"}},
+	})
+	if err != nil {
+		bslog.Errorf("[docker gc] unable to list images: %s", err)
+		return 0, 0
+	}
+	var removed, size int64
+	for _, img := range images {
+		if !isDangling(img) {
+			continue
+		}
+		err = g.client.RemoveImage(img.ID)
+		if err != nil {
+			// Images used by containers can't be removed, there's nothing
+			// else to do about them.
+			bslog.Debugf("[docker gc] unable to remove image %s: %s", img.ID, err)
+			continue
+		}
+		audit.Record("gc", "removed dangling image %s", img.ID)
+		removed++
+		size += img.Size
+	}
+	return removed, size
+}
+
+func isDangling(img docker.APIImages) bool {
+	for _, tag := range img.RepoTags {
+		if tag != "<none>:<none>" {
+			return false
+		}
+	}
+	return true
+}
+
+// HostMetrics returns the number of collections, the removed containers and
+// images and the reclaimed bytes since the collector was created.
+func (g *GarbageCollector) HostMetrics() map[string]metric.Metric {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return map[string]metric.Metric{
+		"gc_runs":               metric.Int(int64(g.stats.runs)),
+		"gc_containers_removed": metric.Int(int64(g.stats.containersRemoved)),
+		"gc_images_removed":     metric.Int(int64(g.stats.imagesRemoved)),
+		"gc_reclaimed_bytes":    metric.Int(int64(g.stats.reclaimedBytes)),
+	}
+}
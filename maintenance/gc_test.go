@@ -0,0 +1,118 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package maintenance
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	server *dtesting.DockerServer
+	client *docker.Client
+	usage  float64
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.server, err = dtesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, check.IsNil)
+	s.usage = 90
+	diskUsage = func(path string) (*disk.DiskUsageStat, error) {
+		return &disk.DiskUsageStat{Path: path, UsedPercent: s.usage}, nil
+	}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	s.server.Stop()
+	diskUsage = disk.DiskUsage
+}
+
+func (s *S) createContainer(c *check.C, name string, env []string, state docker.State) string {
+	err := s.client.PullImage(docker.PullImageOptions{Repository: "tsuru/python"}, docker.AuthConfiguration{})
+	c.Assert(err, check.IsNil)
+	cont, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name:   name,
+		Config: &docker.Config{Image: "tsuru/python", Env: env},
+	})
+	c.Assert(err, check.IsNil)
+	err = s.server.MutateContainer(cont.ID, state)
+	c.Assert(err, check.IsNil)
+	return cont.ID
+}
+
+func (s *S) listContainers(c *check.C) []string {
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, check.IsNil)
+	var names []string
+	for _, cont := range containers {
+		names = append(names, cont.Names[0])
+	}
+	return names
+}
+
+func (s *S) TestGarbageCollectorRun(c *check.C) {
+	old := time.Now().Add(-2 * time.Hour)
+	s.createContainer(c, "exited", nil, docker.State{FinishedAt: old})
+	s.createContainer(c, "recent", nil, docker.State{FinishedAt: time.Now()})
+	s.createContainer(c, "running", nil, docker.State{Running: true})
+	s.createContainer(c, "app", []string{"TSURU_APPNAME=myapp"}, docker.State{FinishedAt: old})
+	gc := NewGarbageCollector(s.client, GCConfig{})
+	gc.Run()
+	c.Assert(s.listContainers(c), check.HasLen, 3)
+	for _, name := range s.listContainers(c) {
+		c.Assert(name, check.Not(check.Equals), "/exited")
+	}
+	metrics := gc.HostMetrics()
+	c.Assert(metrics["gc_runs"], check.Equals, metric.Int(1))
+	c.Assert(metrics["gc_containers_removed"], check.Equals, metric.Int(1))
+}
+
+func (s *S) TestGarbageCollectorRunBelowThreshold(c *check.C) {
+	s.usage = 50
+	s.createContainer(c, "exited", nil, docker.State{FinishedAt: time.Now().Add(-2 * time.Hour)})
+	gc := NewGarbageCollector(s.client, GCConfig{Threshold: 80})
+	gc.Run()
+	c.Assert(s.listContainers(c), check.HasLen, 1)
+	c.Assert(gc.HostMetrics()["gc_runs"], check.Equals, metric.Int(0))
+}
+
+func (s *S) TestGarbageCollectorRunDiskUsageError(c *check.C) {
+	diskUsage = func(string) (*disk.DiskUsageStat, error) {
+		return nil, errors.New("no disk")
+	}
+	s.createContainer(c, "exited", nil, docker.State{FinishedAt: time.Now().Add(-2 * time.Hour)})
+	gc := NewGarbageCollector(s.client, GCConfig{})
+	gc.Run()
+	c.Assert(s.listContainers(c), check.HasLen, 1)
+}
+
+func (s *S) TestGarbageCollectorStartStop(c *check.C) {
+	s.usage = 0
+	gc := NewGarbageCollector(s.client, GCConfig{Interval: time.Millisecond})
+	gc.Start()
+	gc.Stop()
+}
+
+func (s *S) TestIsDangling(c *check.C) {
+	c.Assert(isDangling(docker.APIImages{}), check.Equals, true)
+	c.Assert(isDangling(docker.APIImages{RepoTags: []string{"<none>:<none>"}}), check.Equals, true)
+	c.Assert(isDangling(docker.APIImages{RepoTags: []string{"tsuru/python:latest"}}), check.Equals, false)
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"sync"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+// aggregatedMetrics are the container metrics summed across the containers
+// of each app running in the node.
+var aggregatedMetrics = []string{"cpu_max", "mem_max", "netrx", "nettx"}
+
+// appAggregator sums the metrics of the running containers of each app in a
+// reporting round, so the app can be charted without reading the documents
+// of every one of its containers.
+type appAggregator struct {
+	mu   sync.Mutex
+	apps map[string]map[string]Metric
+}
+
+// add adds the metrics of a container of the given app.
+func (a *appAggregator) add(app string, metrics map[string]Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.apps == nil {
+		a.apps = make(map[string]map[string]Metric)
+	}
+	sums := a.apps[app]
+	if sums == nil {
+		sums = map[string]Metric{"containers": Int(0)}
+		a.apps[app] = sums
+	}
+	sums["containers"] = sums["containers"].add(Int(1))
+	for _, key := range aggregatedMetrics {
+		value, ok := metrics[key]
+		if !ok {
+			continue
+		}
+		if sum, ok := sums[key]; ok {
+			value = sum.add(value)
+		}
+		sums[key] = value
+	}
+}
+
+// sendAppAggregates sends the metrics summed for each app, along with the
+// number of containers of the app, flagged as aggregates.
+func (r *Reporter) sendAppAggregates(a *appAggregator) {
+	metadata := r.nodeMetadata.Get()
+	for app, metrics := range a.apps {
+		info := ContainerInfo{App: app, Pool: metadata.Pool, Node: metadata.Address, Aggregate: true}
+		for key, value := range metrics {
+			if err := r.backend.Send(info, key, value); err != nil {
+				bslog.Errorf("failed to send aggregated metrics for app %q: %s", app, err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+)
+
+// getHostARP returns the number of entries in the IPv4 neighbor table of the
+// host and the garbage collection thresholds of the table. After gc_thresh3
+// entries, the kernel refuses new entries, breaking the connectivity with
+// hosts not in the table.
+func (h *HostClient) getHostARP() (map[string]Metric, error) {
+	file, err := os.Open(filepath.Join(hostNetPath(), "arp"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]Metric{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	entries, err := countARPEntries(file)
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]Metric{"arp_entries": Int(int64(entries))}
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	neighPath := filepath.Join(procPath, "sys", "net", "ipv4", "neigh", "default")
+	for _, name := range []string{"gc_thresh1", "gc_thresh2", "gc_thresh3"} {
+		data, err := ioutil.ReadFile(filepath.Join(neighPath, name))
+		if err != nil {
+			// The thresholds are only visible in the network
+			// namespace of the host.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stats["arp_"+name] = Int(value)
+	}
+	if thresh3 := stats["arp_gc_thresh3"]; thresh3.Float64() > 0 {
+		stats["arp_pct_of_gc_thresh3"] = Float(float64(entries) / thresh3.Float64() * 100)
+	}
+	return stats, nil
+}
+
+// countARPEntries counts the entries in /proc/net/arp, incomplete ones
+// included, as they use the table too.
+func countARPEntries(r io.Reader) (int, error) {
+	var count int
+	scanner := bufio.NewScanner(r)
+	// Skip the header.
+	scanner.Scan()
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+const procNetARP = `IP address       HW type     Flags       HW address            Mask     Device
+10.0.0.1         0x1         0x2         02:42:0a:00:00:01     *        eth0
+10.0.0.7         0x1         0x0         00:00:00:00:00:00     *        eth0
+172.17.0.2       0x1         0x2         02:42:ac:11:00:02     *        docker0
+`
+
+func (*S) TestCountARPEntries(c *check.C) {
+	count, err := countARPEntries(strings.NewReader(procNetARP))
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 3)
+}
+
+func (*S) TestGetHostARP(c *check.C) {
+	dir, err := ioutil.TempDir("", "arp")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", dir)
+	defer os.Setenv("HOST_PROC", oldProc)
+	var h HostClient
+	metrics, err := h.getHostARP()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{})
+	files := map[string]string{
+		"1/net/arp":                             procNetARP,
+		"sys/net/ipv4/neigh/default/gc_thresh1": "128\n",
+		"sys/net/ipv4/neigh/default/gc_thresh2": "512\n",
+		"sys/net/ipv4/neigh/default/gc_thresh3": "1024\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		c.Assert(err, check.IsNil)
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		c.Assert(err, check.IsNil)
+	}
+	metrics, err = h.getHostARP()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"arp_entries":           Int(3),
+		"arp_gc_thresh1":        Int(128),
+		"arp_gc_thresh2":        Int(512),
+		"arp_gc_thresh3":        Int(1024),
+		"arp_pct_of_gc_thresh3": Float(3.0 / 1024 * 100),
+	})
+}
@@ -17,6 +17,14 @@ type ContainerInfo struct {
 	App      string
 	Process  string
 	Labels   map[string]string
+	Pool     string
+	Node     string
+	// Terminated is set in the final metrics of containers that exited
+	// before being collected by the periodic reporter.
+	Terminated bool
+	// Aggregate is set in the metrics summed across the containers of an
+	// app in the node, which have no container name.
+	Aggregate bool
 }
 
 func NewContainerInfo(container *container.Container) ContainerInfo {
@@ -30,7 +38,7 @@ func NewContainerInfo(container *container.Container) ContainerInfo {
 		Hostname: container.ShortHostname,
 		Labels:   container.Config.Labels,
 	}
-	if container.TsuruApp {
+	if container.Identified() {
 		info.Process = container.ProcessName
 		info.App = container.AppName
 	}
@@ -40,6 +48,8 @@ func NewContainerInfo(container *container.Container) ContainerInfo {
 type HostInfo struct {
 	Name  string
 	Addrs []string
+	Pool  string
+	Node  string
 }
 
 type backendFactory func() (Backend, error)
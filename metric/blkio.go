@@ -0,0 +1,157 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/cgroup"
+)
+
+// blkioStats holds the cumulative block I/O of a container, summed over
+// every device.
+type blkioStats struct {
+	readBytes  uint64
+	writeBytes uint64
+	readOps    uint64
+	writeOps   uint64
+}
+
+func (b *blkioStats) metrics() map[string]Metric {
+	return map[string]Metric{
+		"blkio_read_bytes":  Int(int64(b.readBytes)),
+		"blkio_write_bytes": Int(int64(b.writeBytes)),
+		"blkio_read_ops":    Int(int64(b.readOps)),
+		"blkio_write_ops":   Int(int64(b.writeOps)),
+	}
+}
+
+// readBlkio reads the container block I/O from io.stat in cgroup v2 or from
+// the blkio throttle files in cgroup v1, which are kept regardless of the I/O
+// scheduler in use.
+func readBlkio(h *cgroup.Hierarchy, id string) (*blkioStats, error) {
+	var stats blkioStats
+	if h.Mode == cgroup.ModeUnified {
+		file, err := h.OpenContainerFile("io", id, "io.stat")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		err = parseIOStat(file, &stats)
+		if err != nil {
+			return nil, err
+		}
+		return &stats, nil
+	}
+	file, err := h.OpenContainerFile("blkio", id, "blkio.throttle.io_service_bytes")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	err = parseBlkioFile(file, &stats.readBytes, &stats.writeBytes)
+	if err != nil {
+		return nil, err
+	}
+	opsFile, err := h.OpenContainerFile("blkio", id, "blkio.throttle.io_serviced")
+	if err != nil {
+		return nil, err
+	}
+	defer opsFile.Close()
+	err = parseBlkioFile(opsFile, &stats.readOps, &stats.writeOps)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// parseIOStat parses the cgroup v2 io.stat format, with one line per device
+// holding key=value pairs.
+func parseIOStat(r io.Reader, stats *blkioStats) error {
+	fields := map[string]*uint64{
+		"rbytes": &stats.readBytes,
+		"wbytes": &stats.writeBytes,
+		"rios":   &stats.readOps,
+		"wios":   &stats.writeOps,
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		for _, pair := range strings.Fields(scanner.Text()) {
+			parts := strings.SplitN(pair, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			dst, ok := fields[parts[0]]
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			*dst += value
+		}
+	}
+	return scanner.Err()
+}
+
+// parseBlkioFile parses the cgroup v1 blkio format, with a line per device
+// and operation.
+func parseBlkioFile(r io.Reader, read, write *uint64) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		switch parts[1] {
+		case "Read":
+			*read += value
+		case "Write":
+			*write += value
+		}
+	}
+	return scanner.Err()
+}
+
+func blkioFromStats(s *docker.Stats) *blkioStats {
+	var stats blkioStats
+	for _, entry := range s.BlkioStats.IOServiceBytesRecursive {
+		switch entry.Op {
+		case "Read":
+			stats.readBytes += entry.Value
+		case "Write":
+			stats.writeBytes += entry.Value
+		}
+	}
+	for _, entry := range s.BlkioStats.IOServicedRecursive {
+		switch entry.Op {
+		case "Read":
+			stats.readOps += entry.Value
+		case "Write":
+			stats.writeOps += entry.Value
+		}
+	}
+	return &stats
+}
+
+// blkioMetrics returns the container block I/O metrics, read from its cgroup
+// when the hierarchy is known, falling back to the ones reported by Docker.
+func (r *Reporter) blkioMetrics(id string, stats *docker.Stats) map[string]Metric {
+	if r.cgroups != nil {
+		b, err := readBlkio(r.cgroups, id)
+		if err == nil {
+			return b.metrics()
+		}
+	}
+	return blkioFromStats(stats).metrics()
+}
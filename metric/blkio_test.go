@@ -0,0 +1,59 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseIOStat(c *check.C) {
+	data := `8:0 rbytes=1024 wbytes=2048 rios=3 wios=4 dbytes=0 dios=0
+8:16 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0
+`
+	var stats blkioStats
+	err := parseIOStat(strings.NewReader(data), &stats)
+	c.Assert(err, check.IsNil)
+	c.Assert(stats, check.Equals, blkioStats{readBytes: 1124, writeBytes: 2248, readOps: 4, writeOps: 6})
+}
+
+func (s *S) TestParseBlkioFile(c *check.C) {
+	data := `8:0 Read 1024
+8:0 Write 2048
+8:0 Sync 3072
+8:0 Async 0
+8:0 Total 3072
+8:16 Read 100
+8:16 Write 0
+Total 3172
+`
+	var read, write uint64
+	err := parseBlkioFile(strings.NewReader(data), &read, &write)
+	c.Assert(err, check.IsNil)
+	c.Assert(read, check.Equals, uint64(1124))
+	c.Assert(write, check.Equals, uint64(2048))
+}
+
+func (s *S) TestBlkioMetricsFromStats(c *check.C) {
+	var stats docker.Stats
+	stats.BlkioStats.IOServiceBytesRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Op: "Read", Value: 1024},
+		{Major: 8, Op: "Write", Value: 512},
+		{Major: 8, Op: "Total", Value: 1536},
+	}
+	stats.BlkioStats.IOServicedRecursive = []docker.BlkioStatsEntry{
+		{Major: 8, Op: "Read", Value: 10},
+		{Major: 8, Op: "Write", Value: 5},
+	}
+	r := &Reporter{}
+	c.Assert(r.blkioMetrics("c1", &stats), check.DeepEquals, map[string]Metric{
+		"blkio_read_bytes":  Int(1024),
+		"blkio_write_bytes": Int(512),
+		"blkio_read_ops":    Int(10),
+		"blkio_write_ops":   Int(5),
+	})
+}
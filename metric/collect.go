@@ -0,0 +1,119 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/cgroup"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/privilege"
+)
+
+// Snapshot holds the metrics collected in a single reporting round.
+type Snapshot struct {
+	Host       *HostSnapshot        `json:"host,omitempty"`
+	Containers []*ContainerSnapshot `json:"containers"`
+}
+
+type HostSnapshot struct {
+	Info    HostInfo               `json:"info"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+type ContainerSnapshot struct {
+	Info        ContainerInfo          `json:"info"`
+	Metrics     map[string]interface{} `json:"metrics"`
+	Connections []string               `json:"connections,omitempty"`
+}
+
+// snapshotBackend keeps the metrics sent to it in a Snapshot.
+type snapshotBackend struct {
+	mu         sync.Mutex
+	host       *HostSnapshot
+	containers map[string]*ContainerSnapshot
+}
+
+func (b *snapshotBackend) container(info ContainerInfo) *ContainerSnapshot {
+	if b.containers == nil {
+		b.containers = make(map[string]*ContainerSnapshot)
+	}
+	c := b.containers[info.Name]
+	if c == nil {
+		c = &ContainerSnapshot{Info: info, Metrics: make(map[string]interface{})}
+		b.containers[info.Name] = c
+	}
+	return c
+}
+
+func (b *snapshotBackend) Send(container ContainerInfo, key string, value interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.container(container).Metrics[key] = value
+	return nil
+}
+
+func (b *snapshotBackend) SendConn(container ContainerInfo, host string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.container(container)
+	c.Connections = append(c.Connections, host)
+	return nil
+}
+
+func (b *snapshotBackend) SendHost(host HostInfo, key string, value interface{}) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.host == nil {
+		b.host = &HostSnapshot{Info: host, Metrics: make(map[string]interface{})}
+	}
+	b.host.Metrics[key] = value
+	return nil
+}
+
+// snapshot returns the metrics sent so far, with containers sorted by name.
+func (b *snapshotBackend) snapshot() *Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &Snapshot{Host: b.host, Containers: make([]*ContainerSnapshot, 0, len(b.containers))}
+	for _, c := range b.containers {
+		s.Containers = append(s.Containers, c)
+	}
+	sort.Slice(s.Containers, func(i, j int) bool {
+		return s.Containers[i].Info.Name < s.Containers[j].Info.Name
+	})
+	return s
+}
+
+// CollectOnce collects the host and container metrics once, the same way
+// the runner does on every interval, returning them instead of sending them
+// to a backend.
+func CollectOnce(dockerEndpoint string) (*Snapshot, error) {
+	client, err := container.NewClient(dockerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	hostClient, err := NewHostClient()
+	if err != nil {
+		bslog.Warnf("Failed to create host client: %s", err)
+	}
+	cgroups, err := cgroup.Detect()
+	if err != nil {
+		bslog.Warnf("Failed to detect cgroup hierarchy: %s", privilege.Cgroups.Wrap(err))
+	}
+	backend := &snapshotBackend{}
+	reporter := &Reporter{
+		backend:               backend,
+		infoClient:            client,
+		containerSelectionEnv: config.StringEnvOrDefault("", "CONTAINER_SELECTION_ENV"),
+		hostClient:            hostClient,
+		cgroups:               cgroups,
+	}
+	reporter.Do()
+	return backend.snapshot(), nil
+}
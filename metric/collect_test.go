@@ -0,0 +1,55 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestSnapshotBackend(c *check.C) {
+	b := &snapshotBackend{}
+	c.Assert(b.snapshot(), check.DeepEquals, &Snapshot{Containers: []*ContainerSnapshot{}})
+	err := b.Send(ContainerInfo{Name: "c2", App: "myapp"}, "cpu_max", Float(1.5))
+	c.Assert(err, check.IsNil)
+	err = b.Send(ContainerInfo{Name: "c1"}, "cpu_max", Float(0.5))
+	c.Assert(err, check.IsNil)
+	err = b.Send(ContainerInfo{Name: "c2", App: "myapp"}, "mem_max", Int(10))
+	c.Assert(err, check.IsNil)
+	err = b.SendConn(ContainerInfo{Name: "c2", App: "myapp"}, "10.0.0.1:80")
+	c.Assert(err, check.IsNil)
+	err = b.SendHost(HostInfo{Name: "host1"}, "load1", Float(2))
+	c.Assert(err, check.IsNil)
+	c.Assert(b.snapshot(), check.DeepEquals, &Snapshot{
+		Host: &HostSnapshot{
+			Info:    HostInfo{Name: "host1"},
+			Metrics: map[string]interface{}{"load1": Float(2)},
+		},
+		Containers: []*ContainerSnapshot{
+			{
+				Info:    ContainerInfo{Name: "c1"},
+				Metrics: map[string]interface{}{"cpu_max": Float(0.5)},
+			},
+			{
+				Info:        ContainerInfo{Name: "c2", App: "myapp"},
+				Metrics:     map[string]interface{}{"cpu_max": Float(1.5), "mem_max": Int(10)},
+				Connections: []string{"10.0.0.1:80"},
+			},
+		},
+	})
+}
+
+func (s *S) TestCollectOnce(c *check.C) {
+	dockerServer, conts := s.startDockerServer(s.buildContainers(), nil, c)
+	defer dockerServer.Stop()
+	s.prepareStats(dockerServer, conts)
+	snapshot, err := CollectOnce(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	var names []string
+	for _, cont := range snapshot.Containers {
+		names = append(names, cont.Info.Name)
+		c.Assert(cont.Metrics["status"], check.NotNil)
+	}
+	c.Assert(names, check.DeepEquals, []string{"app", "nonApp"})
+}
@@ -4,25 +4,47 @@
 
 package metric
 
-import "github.com/fsouza/go-dockerclient"
+import (
+	"errors"
 
-func statsToMetricsMap(s *docker.Stats) (map[string]float, error) {
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/container"
+)
+
+// ContainerMetrics returns the current metrics of the container, the same
+// ones reported to the metrics backend.
+func ContainerMetrics(cont *container.Container) (map[string]Metric, error) {
+	stats, err := cont.Stats()
+	if err != nil {
+		return nil, err
+	}
+	if stats == nil {
+		return nil, errors.New("no stats available")
+	}
+	return statsToMetricsMap(stats)
+}
+
+func statsToMetricsMap(s *docker.Stats) (map[string]Metric, error) {
 	previousCPU := s.PreCPUStats.CPUUsage.TotalUsage
 	previousSystem := s.PreCPUStats.SystemCPUUsage
-	cpuPercent := calculateCPUPercent(previousCPU, previousSystem, s)
 	memPercent := 0.0
 	if s.MemoryStats.Limit > 0 {
 		memPercent = float64(s.MemoryStats.Usage) / float64(s.MemoryStats.Limit) * 100.0
 	}
-	stats := map[string]float{
-		"cpu_max":     float(cpuPercent),
-		"mem_max":     float(s.MemoryStats.Usage),
-		"mem_pct_max": float(memPercent),
-		"mem_limit":   float(s.MemoryStats.Limit),
+	stats := map[string]Metric{
+		"mem_max":     Int(int64(s.MemoryStats.Usage)),
+		"mem_pct_max": Float(memPercent),
+		"mem_limit":   Int(int64(s.MemoryStats.Limit)),
+	}
+	if cpuPercent, ok := calculateCPUPercent(previousCPU, previousSystem, s); ok {
+		stats["cpu_max"] = Float(cpuPercent)
+	}
+	for key, value := range memoryBreakdown(s) {
+		stats[key] = value
 	}
 	if s.MemoryStats.Stats.Swap != 0 {
-		stats["swap"] = float(s.MemoryStats.Stats.Swap)
-		stats["swap_limit"] = float(s.MemoryStats.Stats.HierarchicalMemswLimit - s.MemoryStats.Stats.HierarchicalMemoryLimit)
+		stats["swap"] = Int(int64(s.MemoryStats.Stats.Swap))
+		stats["swap_limit"] = Int(int64(s.MemoryStats.Stats.HierarchicalMemswLimit - s.MemoryStats.Stats.HierarchicalMemoryLimit))
 	}
 	var netRx, netTx uint64
 	if len(s.Networks) > 0 {
@@ -34,21 +56,47 @@ func statsToMetricsMap(s *docker.Stats) (map[string]float, error) {
 		netRx = s.Network.RxBytes
 		netTx = s.Network.TxBytes
 	}
-	stats["netrx"] = float(netRx)
-	stats["nettx"] = float(netTx)
+	stats["netrx"] = Int(int64(netRx))
+	stats["nettx"] = Int(int64(netTx))
 	return stats, nil
 }
 
-func calculateCPUPercent(previousCPU, previousSystem uint64, s *docker.Stats) float64 {
+// memoryBreakdown returns the container memory split by kind and the
+// percentage of the cgroup memory limit used without counting the page cache,
+// which the kernel reclaims before hitting the limit. Swap and the limit
+// itself are reported by statsToMetricsMap as swap and mem_limit.
+func memoryBreakdown(s *docker.Stats) map[string]Metric {
+	memStats := s.MemoryStats.Stats
+	limit := s.MemoryStats.Limit
+	if memStats.HierarchicalMemoryLimit > 0 && (limit == 0 || memStats.HierarchicalMemoryLimit < limit) {
+		limit = memStats.HierarchicalMemoryLimit
+	}
+	var pct float64
+	if limit > 0 && s.MemoryStats.Usage > memStats.Cache {
+		pct = float64(s.MemoryStats.Usage-memStats.Cache) / float64(limit) * 100
+	}
+	return map[string]Metric{
+		"mem_rss":          Int(int64(memStats.Rss)),
+		"mem_cache":        Int(int64(memStats.Cache)),
+		"mem_mapped_file":  Int(int64(memStats.MappedFile)),
+		"mem_pct_of_limit": Float(pct),
+	}
+}
+
+// calculateCPUPercent returns the CPU usage of the container between the
+// previous reading and the current one. It returns false when there's no
+// valid delta: on the first reading, which has no previous counters, or when
+// the counters were reset, like after the container restarts, so a phantom 0%
+// is not reported.
+func calculateCPUPercent(previousCPU, previousSystem uint64, s *docker.Stats) (float64, bool) {
+	if previousSystem == 0 || s.CPUStats.CPUUsage.TotalUsage < previousCPU || s.CPUStats.SystemCPUUsage <= previousSystem {
+		return 0, false
+	}
 	var (
-		cpuPercent = 0.0
 		// calculate the change for the cpu usage of the container in between readings
 		cpuDelta = float64(s.CPUStats.CPUUsage.TotalUsage) - float64(previousCPU)
 		// calculate the change for the entire system between readings
 		systemDelta = float64(s.CPUStats.SystemCPUUsage) - float64(previousSystem)
 	)
-	if systemDelta > 0.0 && cpuDelta > 0.0 {
-		cpuPercent = (cpuDelta / systemDelta) * float64(len(s.CPUStats.CPUUsage.PercpuUsage)) * 100.0
-	}
-	return cpuPercent
+	return (cpuDelta / systemDelta) * float64(len(s.CPUStats.CPUUsage.PercpuUsage)) * 100.0, true
 }
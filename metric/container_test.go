@@ -119,16 +119,21 @@ func (s *S) TestStatsToMetricsMap(c *check.C) {
 	c.Assert(err, check.IsNil)
 	metricsMap, err := statsToMetricsMap(&stats)
 	c.Assert(err, check.IsNil)
-	c.Assert(metricsMap["mem_max"], check.Equals, float(6537216))
-	c.Assert(metricsMap["mem_limit"], check.Equals, float(67108864))
-	c.Assert(metricsMap["swap"], check.Equals, float(47312896))
-	c.Assert(metricsMap["swap_limit"], check.Equals, float(1543503872))
-	c.Assert(metricsMap["netrx"], check.Equals, float(648))
-	c.Assert(metricsMap["nettx"], check.Equals, float(649))
-	diffMemPctMax := 9.74 - metricsMap["mem_pct_max"]
+	c.Assert(metricsMap["mem_max"], check.Equals, Int(6537216))
+	c.Assert(metricsMap["mem_limit"], check.Equals, Int(67108864))
+	c.Assert(metricsMap["swap"], check.Equals, Int(47312896))
+	c.Assert(metricsMap["swap_limit"], check.Equals, Int(1543503872))
+	c.Assert(metricsMap["netrx"], check.Equals, Int(648))
+	c.Assert(metricsMap["nettx"], check.Equals, Int(649))
+	c.Assert(metricsMap["mem_rss"], check.Equals, Int(6537216))
+	c.Assert(metricsMap["mem_cache"], check.Equals, Int(0))
+	c.Assert(metricsMap["mem_mapped_file"], check.Equals, Int(0))
+	diffMemPctOfLimit := 9.74 - metricsMap["mem_pct_of_limit"].Float64()
+	c.Assert(diffMemPctOfLimit < 0.01, check.Equals, true)
+	diffMemPctMax := 9.74 - metricsMap["mem_pct_max"].Float64()
 	c.Assert(diffMemPctMax < 0.01, check.Equals, true)
-	diffCpuMax := 0 - metricsMap["cpu_max"]
-	c.Assert(diffCpuMax < 0.01, check.Equals, true)
+	_, ok := metricsMap["cpu_max"]
+	c.Assert(ok, check.Equals, false)
 	stats.Networks = map[string]docker.NetworkStats{
 		"eth0": {
 			RxBytes: 1,
@@ -141,6 +146,42 @@ func (s *S) TestStatsToMetricsMap(c *check.C) {
 	}
 	metricsMap, err = statsToMetricsMap(&stats)
 	c.Assert(err, check.IsNil)
-	c.Assert(metricsMap["netrx"], check.Equals, float(4))
-	c.Assert(metricsMap["nettx"], check.Equals, float(6))
+	c.Assert(metricsMap["netrx"], check.Equals, Int(4))
+	c.Assert(metricsMap["nettx"], check.Equals, Int(6))
+}
+
+func (s *S) TestMemoryBreakdown(c *check.C) {
+	var stats docker.Stats
+	stats.MemoryStats.Usage = 600
+	stats.MemoryStats.Limit = 4000
+	stats.MemoryStats.Stats.Rss = 300
+	stats.MemoryStats.Stats.Cache = 200
+	stats.MemoryStats.Stats.MappedFile = 50
+	stats.MemoryStats.Stats.HierarchicalMemoryLimit = 1 << 62
+	c.Assert(memoryBreakdown(&stats), check.DeepEquals, map[string]Metric{
+		"mem_rss":          Int(300),
+		"mem_cache":        Int(200),
+		"mem_mapped_file":  Int(50),
+		"mem_pct_of_limit": Float(10),
+	})
+	stats.MemoryStats.Stats.HierarchicalMemoryLimit = 1000
+	breakdown := memoryBreakdown(&stats)
+	c.Assert(breakdown["mem_pct_of_limit"], check.Equals, Float(40))
+}
+
+func (s *S) TestCalculateCPUPercent(c *check.C) {
+	var stats docker.Stats
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{150, 150}
+	stats.CPUStats.SystemCPUUsage = 2000
+	pct, ok := calculateCPUPercent(0, 0, &stats)
+	c.Assert(ok, check.Equals, false)
+	pct, ok = calculateCPUPercent(100, 1000, &stats)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(pct, check.Equals, 40.0)
+	pct, ok = calculateCPUPercent(300, 1000, &stats)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(pct, check.Equals, 0.0)
+	_, ok = calculateCPUPercent(500, 1000, &stats)
+	c.Assert(ok, check.Equals, false)
 }
@@ -0,0 +1,51 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"encoding/json"
+
+	"github.com/tsuru/bs/dryrun"
+)
+
+// dryRunBackend writes the metrics that would be sent to a backend to the
+// dry-run output.
+type dryRunBackend struct {
+	name string
+}
+
+type dryRunMetric struct {
+	Container *ContainerInfo `json:",omitempty"`
+	Host      *HostInfo      `json:",omitempty"`
+	Key       string
+	Value     interface{}
+	Tags      map[string]string `json:",omitempty"`
+}
+
+func (b *dryRunBackend) write(metric dryRunMetric) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+	return dryrun.Write("metrics "+b.name, data)
+}
+
+func (b *dryRunBackend) Send(container ContainerInfo, key string, value interface{}) error {
+	return b.write(dryRunMetric{Container: &container, Key: key, Value: value})
+}
+
+func (b *dryRunBackend) SendConn(container ContainerInfo, host string) error {
+	return b.write(dryRunMetric{Container: &container, Key: "connection", Value: host})
+}
+
+func (b *dryRunBackend) SendHost(host HostInfo, key string, value interface{}) error {
+	m := dryRunMetric{Host: &host, Key: key, Value: value}
+	if tagged, ok := value.(Metric); ok {
+		if tagKey, tagValue := tagged.Tag(); tagKey != "" {
+			m.Tags = map[string]string{tagKey: tagValue}
+		}
+	}
+	return b.write(m)
+}
@@ -0,0 +1,36 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/tsuru/bs/dryrun"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestDryRunBackend(c *check.C) {
+	path := filepath.Join(c.MkDir(), "dry-run.log")
+	err := dryrun.Setup(path)
+	c.Assert(err, check.IsNil)
+	defer dryrun.Disable()
+	b := &dryRunBackend{name: "logstash"}
+	err = b.Send(ContainerInfo{Name: "c1", App: "myapp"}, "cpu_max", Float(1.5))
+	c.Assert(err, check.IsNil)
+	err = b.SendConn(ContainerInfo{Name: "c1", App: "myapp"}, "10.0.0.1:80")
+	c.Assert(err, check.IsNil)
+	err = b.SendHost(HostInfo{Name: "host1"}, "load1", Float(2))
+	c.Assert(err, check.IsNil)
+	err = b.SendHost(HostInfo{Name: "host1"}, "process_1_mem_rss", Int(4096).WithTag("process_name", "java"))
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(data), check.Equals,
+		`[dry-run] metrics logstash {"Container":{"Name":"c1","Image":"","Hostname":"","App":"myapp","Process":"","Labels":null,"Pool":"","Node":"","Terminated":false,"Aggregate":false},"Key":"cpu_max","Value":1.5}`+"\n"+
+			`[dry-run] metrics logstash {"Container":{"Name":"c1","Image":"","Hostname":"","App":"myapp","Process":"","Labels":null,"Pool":"","Node":"","Terminated":false,"Aggregate":false},"Key":"connection","Value":"10.0.0.1:80"}`+"\n"+
+			`[dry-run] metrics logstash {"Host":{"Name":"host1","Addrs":null,"Pool":"","Node":""},"Key":"load1","Value":2.0}`+"\n"+
+			`[dry-run] metrics logstash {"Host":{"Name":"host1","Addrs":null,"Pool":"","Node":""},"Key":"process_1_mem_rss","Value":4096,"Tags":{"process_name":"java"}}`+"\n")
+}
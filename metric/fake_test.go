@@ -15,13 +15,15 @@ func init() {
 }
 
 type fakeStat struct {
-	container string
-	image     string
-	app       string
-	hostname  string
-	process   string
-	key       string
-	value     interface{}
+	container  string
+	image      string
+	app        string
+	hostname   string
+	process    string
+	key        string
+	value      interface{}
+	terminated bool
+	aggregate  bool
 }
 
 type fake struct {
@@ -44,13 +46,15 @@ func (s *fake) Send(container ContainerInfo, key string, value interface{}) erro
 		return err
 	default:
 		stat := fakeStat{
-			app:       container.App,
-			hostname:  container.Hostname,
-			process:   container.Process,
-			container: container.Name,
-			image:     container.Image,
-			key:       key,
-			value:     value,
+			app:        container.App,
+			hostname:   container.Hostname,
+			process:    container.Process,
+			container:  container.Name,
+			image:      container.Image,
+			key:        key,
+			value:      value,
+			terminated: container.Terminated,
+			aggregate:  container.Aggregate,
 		}
 		s.stats = append(s.stats, stat)
 		return nil
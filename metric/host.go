@@ -5,23 +5,39 @@
 package metric
 
 import (
-	"errors"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/disk"
-	"github.com/shirou/gopsutil/host"
 	"github.com/shirou/gopsutil/load"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/net"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/cgroup"
 	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/privilege"
 )
 
+func init() {
+	config.DeclareDefault("METRICS_NETWORK_INTERFACE", defaultNetworkInterface)
+}
+
 type HostClient struct {
 	ifaceName    string
 	lastCPUStats *cpu.CPUTimesStat
+	lastCPUTime  time.Time
+	lastVMStat   *vmStat
+	optional     []optionalCollector
+	stateFile    string
+}
+
+// optionalCollector collects host metrics enabled by environment variables,
+// which may depend on tools or privileges not always available, so their
+// failures are logged without failing the other host metrics.
+type optionalCollector struct {
+	name    string
+	collect func() (map[string]Metric, error)
 }
 
 type errInterfaceNotFound struct {
@@ -33,26 +49,83 @@ func (e errInterfaceNotFound) Error() string {
 }
 
 func NewHostClient() (*HostClient, error) {
-	proc := os.Getenv("HOST_PROC")
-	if proc == "" {
-		return nil, errors.New("HOST_PROC must be set to be able to send host metrics")
-	}
-	return &HostClient{
-		ifaceName: config.StringEnvOrDefault("eth0", "METRICS_NETWORK_INTERFACE"),
-	}, nil
-}
-
-func (h *HostClient) GetHostMetrics() ([]map[string]float, error) {
-	collectors := []func() (map[string]float, error){
-		h.getHostLoad,
-		h.getHostMem,
-		h.getHostSwap,
-		h.getHostFileSystemUsage,
-		h.getHostUptime,
-		h.getHostCpuTimes,
-		h.getHostNetworkUsage,
-	}
-	var metrics []map[string]float
+	if err := checkHostProc(); err != nil {
+		return nil, err
+	}
+	h := &HostClient{
+		ifaceName: config.StringEnvOrDefault(defaultNetworkInterface, "METRICS_NETWORK_INTERFACE"),
+		optional:  optionalCollectors(),
+		stateFile: config.StringEnvOrDefault("", "METRICS_STATE_FILE"),
+	}
+	if err := h.loadState(time.Now()); err != nil {
+		bslog.Warnf("Ignoring host metrics state in %q: %s", h.stateFile, err)
+	}
+	return h, nil
+}
+
+// optionalCollectors returns the optional collectors enabled in the
+// environment, logging the invalid settings.
+func optionalCollectors() []optionalCollector {
+	var collectors []optionalCollector
+	if source := config.StringEnvOrDefault("", "METRICS_NTP_SOURCE"); source != "" {
+		collect, err := ntpCollector(source)
+		if err != nil {
+			bslog.Warnf("Skipping NTP metrics: %s", err)
+		} else if source == ntpSourceAdjtimex {
+			collectors = append(collectors, optionalCollector{name: "NTP", collect: collect})
+		} else {
+			collectors = appendExecCollector(collectors, "NTP", collect)
+		}
+	}
+	if config.BoolEnvOrDefault(false, "METRICS_SYSTEMD_ENABLED") {
+		units := config.StringsEnvOrDefault(nil, "METRICS_SYSTEMD_UNITS")
+		if len(units) == 0 {
+			units = defaultSystemdUnits
+		}
+		collectors = appendExecCollector(collectors, "systemd", systemdCollector(units))
+	}
+	if config.BoolEnvOrDefault(false, "METRICS_SMART_ENABLED") {
+		collectors = appendExecCollector(collectors, "SMART", smartCollector)
+	}
+	if config.BoolEnvOrDefault(false, "METRICS_SLICES_ENABLED") {
+		hierarchy, err := cgroup.Detect()
+		if err != nil {
+			bslog.Warnf("Skipping slice metrics: %s", privilege.Cgroups.Wrap(err))
+		} else {
+			slices := config.StringsEnvOrDefault(nil, "METRICS_SLICES")
+			if len(slices) == 0 {
+				slices = defaultSlices
+			}
+			collectors = append(collectors, optionalCollector{name: "slice", collect: slicesCollector(hierarchy, slices)})
+		}
+	}
+	if entries := config.StringsEnvOrDefault(nil, "METRICS_SYSCTLS"); len(entries) > 0 {
+		checks, err := parseSysctlChecks(entries)
+		if err != nil {
+			bslog.Warnf("Skipping sysctl metrics: %s", err)
+		} else {
+			collectors = append(collectors, optionalCollector{name: "sysctl", collect: sysctlCollector(checks)})
+		}
+	}
+	if n := config.IntEnvOrDefault(0, "METRICS_TOP_PROCESSES"); n > 0 {
+		collectors = append(collectors, optionalCollector{name: "top processes", collect: topProcessesCollector(n)})
+	}
+	return collectors
+}
+
+// appendExecCollector appends a collector running external binaries, unless
+// executing them is blocked by the container profile.
+func appendExecCollector(collectors []optionalCollector, name string, collect func() (map[string]Metric, error)) []optionalCollector {
+	if err := privilege.Exec.Check(); err != nil {
+		bslog.Warnf("Skipping %s metrics: %s", name, err)
+		return collectors
+	}
+	return append(collectors, optionalCollector{name: name, collect: collect})
+}
+
+func (h *HostClient) GetHostMetrics() ([]map[string]Metric, error) {
+	collectors := h.collectors()
+	var metrics []map[string]Metric
 	for _, collector := range collectors {
 		metric, err := collector()
 		if err != nil {
@@ -64,122 +137,170 @@ func (h *HostClient) GetHostMetrics() ([]map[string]float, error) {
 		}
 		metrics = append(metrics, metric)
 	}
+	for _, collector := range h.optional {
+		metric, err := collector.collect()
+		if err != nil {
+			bslog.Warnf("Skipping %s metrics: %s", collector.name, err)
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	if err := h.saveState(time.Now()); err != nil {
+		bslog.Errorf("failed to save host metrics state in %q: %s", h.stateFile, err)
+	}
 	return metrics, nil
 }
 
-func (h *HostClient) getHostLoad() (map[string]float, error) {
+func (h *HostClient) getHostLoad() (map[string]Metric, error) {
 	loadStat, err := load.LoadAvg()
 	if err != nil {
 		return nil, err
 	}
-	stats := map[string]float{
-		"load1":  float(loadStat.Load1),
-		"load5":  float(loadStat.Load5),
-		"load15": float(loadStat.Load15),
+	cpus, err := hostCPUCount()
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]Metric{
+		"load1":         Float(loadStat.Load1),
+		"load5":         Float(loadStat.Load5),
+		"load15":        Float(loadStat.Load15),
+		"load1_per_cpu": Float(loadStat.Load1 / float64(cpus)),
 	}
 	return stats, nil
 }
 
-func (h *HostClient) getHostMem() (map[string]float, error) {
-	memStat, err := mem.VirtualMemory()
+// hostCPUCount returns the number of CPUs in the host, counted from the per
+// CPU times, as runtime.NumCPU is limited to the CPUs bs may run on.
+func hostCPUCount() (int, error) {
+	cpuStats, err := cpu.CPUTimes(true)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	stats := map[string]float{
-		"mem_total": float(memStat.Total),
-		"mem_used":  float(memStat.Used),
-		"mem_free":  float(memStat.Free),
+	if len(cpuStats) == 0 {
+		return 1, nil
 	}
-	return stats, nil
+	return len(cpuStats), nil
 }
 
-func (h *HostClient) getHostSwap() (map[string]float, error) {
-	swap, err := mem.SwapMemory()
+func (h *HostClient) getHostMem() (map[string]Metric, error) {
+	memStat, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]Metric{
+		"mem_total":   Int(int64(memStat.Total)),
+		"mem_used":    Int(int64(memStat.Used)),
+		"mem_free":    Int(int64(memStat.Free)),
+		"mem_cached":  Int(int64(memStat.Cached)),
+		"mem_buffers": Int(int64(memStat.Buffers)),
+	}
+	extra, err := hostMemExtra()
 	if err != nil {
 		return nil, err
 	}
-	stats := map[string]float{
-		"swap_total": float(swap.Total),
-		"swap_used":  float(swap.Used),
-		"swap_free":  float(swap.Free),
+	for name, value := range extra {
+		stats[name] = value
 	}
 	return stats, nil
 }
 
-func (h *HostClient) getHostFileSystemUsage() (map[string]float, error) {
-	diskStat, err := disk.DiskUsage("/")
+func (h *HostClient) getHostSwap() (map[string]Metric, error) {
+	swap, err := mem.SwapMemory()
 	if err != nil {
 		return nil, err
 	}
-	stats := map[string]float{
-		"disk_total": float(diskStat.Total),
-		"disk_used":  float(diskStat.Used),
-		"disk_free":  float(diskStat.Free),
+	stats := map[string]Metric{
+		"swap_total": Int(int64(swap.Total)),
+		"swap_used":  Int(int64(swap.Used)),
+		"swap_free":  Int(int64(swap.Free)),
 	}
 	return stats, nil
 }
 
-func (h *HostClient) getHostUptime() (map[string]float, error) {
-	uptime, err := host.Uptime()
+func (h *HostClient) getHostFileSystemUsage() (map[string]Metric, error) {
+	diskStat, err := disk.DiskUsage(hostDiskPath)
 	if err != nil {
 		return nil, err
 	}
-	stats := map[string]float{"uptime": float(uptime)}
+	stats := map[string]Metric{
+		"disk_total": Int(int64(diskStat.Total)),
+		"disk_used":  Int(int64(diskStat.Used)),
+		"disk_free":  Int(int64(diskStat.Free)),
+	}
 	return stats, nil
 }
 
-func (h *HostClient) getHostCpuTimes() (map[string]float, error) {
+func (h *HostClient) getHostCpuTimes() (map[string]Metric, error) {
 	cpuStats, err := cpu.CPUTimes(false)
 	if err != nil {
 		return nil, err
 	}
+	cpus, err := hostCPUCount()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
 	stats := h.calculateCpuPercent(&cpuStats[0])
+	for name, value := range h.calculateSteal(&cpuStats[0], now, cpus) {
+		stats[name] = value
+	}
 	h.lastCPUStats = &cpuStats[0]
+	h.lastCPUTime = now
 	return stats, nil
 }
 
-func (h *HostClient) calculateCpuPercent(currentCpuStats *cpu.CPUTimesStat) map[string]float {
-	var user, sys, idle, stolen, wait float64
-	if h.lastCPUStats != nil {
-		deltaTotal := currentCpuStats.Total() - h.lastCPUStats.Total()
-		user = (currentCpuStats.User - h.lastCPUStats.User) / deltaTotal
-		sys = (currentCpuStats.System - h.lastCPUStats.System) / deltaTotal
-		idle = (currentCpuStats.Idle - h.lastCPUStats.Idle) / deltaTotal
-		stolen = (currentCpuStats.Stolen - h.lastCPUStats.Stolen) / deltaTotal
-		wait = (currentCpuStats.Iowait - h.lastCPUStats.Iowait) / deltaTotal
-	}
-	stats := map[string]float{
-		"cpu_user":   float(user),
-		"cpu_sys":    float(sys),
-		"cpu_idle":   float(idle),
-		"cpu_stolen": float(stolen),
-		"cpu_wait":   float(wait),
-		"cpu_busy":   float(user + sys),
+// calculateSteal returns the CPU time stolen by the hypervisor since boot, in
+// seconds, and the average percentage of each CPU stolen since the last
+// reading, relative to the wall clock time elapsed. The percentage is left out
+// on the first reading and when the counters go backwards, as after a reboot.
+func (h *HostClient) calculateSteal(currentCpuStats *cpu.CPUTimesStat, now time.Time, cpus int) map[string]Metric {
+	stats := map[string]Metric{"cpu_stolen_seconds": Float(currentCpuStats.Stolen)}
+	if h.lastCPUStats != nil && currentCpuStats.Stolen >= h.lastCPUStats.Stolen {
+		elapsed := now.Sub(h.lastCPUTime).Seconds() * float64(cpus)
+		if elapsed > 0 {
+			stats["cpu_stolen_pct"] = Float((currentCpuStats.Stolen - h.lastCPUStats.Stolen) / elapsed * 100)
+		}
 	}
 	return stats
 }
 
-func (h *HostClient) getHostNetworkUsage() (map[string]float, error) {
+// calculateCpuPercent returns the share of the CPU time spent in each state
+// since the last reading. Nothing is returned on the first reading, as there's
+// no previous reading to compare with, and when the counters go backwards, so
+// bs doesn't report a phantom idle CPU.
+func (h *HostClient) calculateCpuPercent(currentCpuStats *cpu.CPUTimesStat) map[string]Metric {
+	stats := make(map[string]Metric)
+	if h.lastCPUStats == nil {
+		return stats
+	}
+	deltaTotal := currentCpuStats.Total() - h.lastCPUStats.Total()
+	if deltaTotal <= 0 {
+		return stats
+	}
+	user := (currentCpuStats.User - h.lastCPUStats.User) / deltaTotal
+	sys := (currentCpuStats.System - h.lastCPUStats.System) / deltaTotal
+	stats["cpu_user"] = Float(user)
+	stats["cpu_sys"] = Float(sys)
+	stats["cpu_idle"] = Float((currentCpuStats.Idle - h.lastCPUStats.Idle) / deltaTotal)
+	stats["cpu_stolen"] = Float((currentCpuStats.Stolen - h.lastCPUStats.Stolen) / deltaTotal)
+	stats["cpu_wait"] = Float((currentCpuStats.Iowait - h.lastCPUStats.Iowait) / deltaTotal)
+	stats["cpu_busy"] = Float(user + sys)
+	return stats
+}
+
+func (h *HostClient) getHostNetworkUsage() (map[string]Metric, error) {
 	netStat, err := net.NetIOCounters(true)
 	if err != nil {
 		return nil, err
 	}
 	for _, netInterface := range netStat {
 		if netInterface.Name == h.ifaceName {
-			stats := map[string]float{
-				"netrx": float(netInterface.BytesRecv),
-				"nettx": float(netInterface.BytesSent),
+			stats := map[string]Metric{
+				"netrx": Int(int64(netInterface.BytesRecv)),
+				"nettx": Int(int64(netInterface.BytesSent)),
 			}
 			return stats, nil
 		}
 	}
 	return nil, errInterfaceNotFound{name: h.ifaceName}
 }
-
-func (h *HostClient) GetHostname() (string, error) {
-	hostInfo, err := host.HostInfo()
-	if err != nil {
-		return "", err
-	}
-	return hostInfo.Hostname, nil
-}
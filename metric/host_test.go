@@ -5,10 +5,13 @@
 package metric
 
 import (
+	"errors"
 	"net"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/shirou/gopsutil/cpu"
 	"gopkg.in/check.v1"
 )
 
@@ -52,6 +55,45 @@ func (h *H) TestGetSystemMetrics(c *check.C) {
 	h.assertUptime(c, metrics[4])
 	h.assertCpuTimes(c, metrics[5])
 	h.assertNetworkUsage(c, metrics[6])
+	h.assertVMStat(c, metrics[7])
+	c.Assert(metrics[8], check.NotNil)
+	c.Assert(metrics[9], check.HasLen, 2)
+	c.Assert(metrics[10], check.NotNil)
+}
+
+func (h *H) TestGetSystemMetricsOptionalCollectors(c *check.C) {
+	hostClient, _ := NewHostClient()
+	hostClient.optional = []optionalCollector{
+		{name: "failing", collect: func() (map[string]Metric, error) {
+			return nil, errors.New("unavailable")
+		}},
+		{name: "working", collect: func() (map[string]Metric, error) {
+			return map[string]Metric{"optional_metric": Int(1)}, nil
+		}},
+	}
+	metrics, err := hostClient.GetHostMetrics()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.HasLen, 12)
+	c.Assert(metrics[11], check.DeepEquals, map[string]Metric{"optional_metric": Int(1)})
+}
+
+func (h *H) TestOptionalCollectors(c *check.C) {
+	defer os.Unsetenv("METRICS_NTP_SOURCE")
+	c.Assert(optionalCollectors(), check.HasLen, 0)
+	os.Setenv("METRICS_NTP_SOURCE", "chrony")
+	collectors := optionalCollectors()
+	c.Assert(collectors, check.HasLen, 1)
+	c.Assert(collectors[0].name, check.Equals, "NTP")
+	os.Setenv("METRICS_NTP_SOURCE", "invalid")
+	c.Assert(optionalCollectors(), check.HasLen, 0)
+}
+
+func (h *H) TestOptionalCollectorsSystemd(c *check.C) {
+	defer os.Unsetenv("METRICS_SYSTEMD_ENABLED")
+	os.Setenv("METRICS_SYSTEMD_ENABLED", "true")
+	collectors := optionalCollectors()
+	c.Assert(collectors, check.HasLen, 1)
+	c.Assert(collectors[0].name, check.Equals, "systemd")
 }
 
 func (h *H) TestGetSystemLoad(c *check.C) {
@@ -110,36 +152,87 @@ func (h *H) TestGetHostNetworkUsage(c *check.C) {
 	h.assertNetworkUsage(c, net)
 }
 
-func (h *H) assertNetworkUsage(c *check.C, net map[string]float) {
-	c.Assert(net["netrx"] != 0 || net["nettx"] != 0, check.Equals, true)
-}
-
-func (h *H) assertCpuTimes(c *check.C, cpu map[string]float) {
-	c.Assert(cpu, check.HasLen, 6)
-}
-
-func (h *H) assertLoad(c *check.C, load map[string]float) {
-	c.Assert(load["load1"], check.Not(check.Equals), float(0))
-	c.Assert(load["load5"], check.Not(check.Equals), float(0))
-	c.Assert(load["load15"], check.Not(check.Equals), float(0))
+func (h *H) TestGetHostVMStat(c *check.C) {
+	hostClient, _ := NewHostClient()
+	vmstat, err := hostClient.getHostVMStat()
+	c.Assert(err, check.IsNil)
+	h.assertVMStat(c, vmstat)
+	c.Assert(hostClient.lastVMStat, check.NotNil)
+}
+
+func (h *H) assertVMStat(c *check.C, vmstat map[string]Metric) {
+	c.Assert(vmstat, check.HasLen, 4)
+}
+
+func (h *H) assertNetworkUsage(c *check.C, net map[string]Metric) {
+	c.Assert(net["netrx"] != Int(0) || net["nettx"] != Int(0), check.Equals, true)
+}
+
+func (h *H) assertCpuTimes(c *check.C, cpu map[string]Metric) {
+	c.Assert(cpu, check.HasLen, 1)
+	_, ok := cpu["cpu_stolen_seconds"]
+	c.Assert(ok, check.Equals, true)
+}
+
+func (h *H) TestCalculateCpuPercent(c *check.C) {
+	hostClient := &HostClient{}
+	current := &cpu.CPUTimesStat{User: 30, System: 10, Idle: 50, Iowait: 10}
+	c.Assert(hostClient.calculateCpuPercent(current), check.DeepEquals, map[string]Metric{})
+	hostClient.lastCPUStats = &cpu.CPUTimesStat{User: 10, System: 10, Idle: 30, Iowait: 0}
+	c.Assert(hostClient.calculateCpuPercent(current), check.DeepEquals, map[string]Metric{
+		"cpu_user":   Float(0.4),
+		"cpu_sys":    Float(0),
+		"cpu_idle":   Float(0.4),
+		"cpu_stolen": Float(0),
+		"cpu_wait":   Float(0.2),
+		"cpu_busy":   Float(0.4),
+	})
+	hostClient.lastCPUStats = &cpu.CPUTimesStat{User: 300, System: 100, Idle: 500}
+	c.Assert(hostClient.calculateCpuPercent(current), check.DeepEquals, map[string]Metric{})
+}
+
+func (h *H) TestCalculateSteal(c *check.C) {
+	now := time.Now()
+	hostClient := &HostClient{}
+	stats := hostClient.calculateSteal(&cpu.CPUTimesStat{Stolen: 30}, now, 2)
+	c.Assert(stats, check.DeepEquals, map[string]Metric{"cpu_stolen_seconds": Float(30)})
+	hostClient.lastCPUStats = &cpu.CPUTimesStat{Stolen: 30}
+	hostClient.lastCPUTime = now
+	stats = hostClient.calculateSteal(&cpu.CPUTimesStat{Stolen: 36}, now.Add(60*time.Second), 2)
+	c.Assert(stats, check.DeepEquals, map[string]Metric{"cpu_stolen_seconds": Float(36), "cpu_stolen_pct": Float(5)})
+	stats = hostClient.calculateSteal(&cpu.CPUTimesStat{Stolen: 2}, now.Add(60*time.Second), 2)
+	c.Assert(stats, check.DeepEquals, map[string]Metric{"cpu_stolen_seconds": Float(2)})
+}
+
+func (h *H) assertLoad(c *check.C, load map[string]Metric) {
+	c.Assert(load["load1"], check.Not(check.Equals), Float(0))
+	c.Assert(load["load5"], check.Not(check.Equals), Float(0))
+	c.Assert(load["load15"], check.Not(check.Equals), Float(0))
+	cpus, err := hostCPUCount()
+	c.Assert(err, check.IsNil)
+	c.Assert(load["load1_per_cpu"], check.Equals, Float(load["load1"].Float64()/float64(cpus)))
 }
 
-func (h *H) assertMem(c *check.C, mem map[string]float) {
-	c.Assert(mem["mem_total"], check.Not(check.Equals), float(0))
-	c.Assert(mem["mem_used"], check.Not(check.Equals), float(0))
-	c.Assert(mem["mem_free"], check.Not(check.Equals), float(0))
+func (h *H) assertMem(c *check.C, mem map[string]Metric) {
+	c.Assert(mem["mem_total"], check.Not(check.Equals), Int(0))
+	c.Assert(mem["mem_used"], check.Not(check.Equals), Int(0))
+	c.Assert(mem["mem_free"], check.Not(check.Equals), Int(0))
+	for _, name := range []string{"mem_cached", "mem_buffers", "mem_slab", "mem_dirty", "mem_writeback"} {
+		_, ok := mem[name]
+		c.Assert(ok, check.Equals, true, check.Commentf(name))
+	}
 }
 
-func (h *H) assertSwap(c *check.C, swap map[string]float) {
+func (h *H) assertSwap(c *check.C, swap map[string]Metric) {
 	c.Assert(swap, check.HasLen, 3)
 }
 
-func (h *H) assertFileSystem(c *check.C, disk map[string]float) {
-	c.Assert(disk["disk_total"], check.Not(check.Equals), float(0))
-	c.Assert(disk["disk_used"], check.Not(check.Equals), float(0))
-	c.Assert(disk["disk_free"], check.Not(check.Equals), float(0))
+func (h *H) assertFileSystem(c *check.C, disk map[string]Metric) {
+	c.Assert(disk["disk_total"], check.Not(check.Equals), Int(0))
+	c.Assert(disk["disk_used"], check.Not(check.Equals), Int(0))
+	c.Assert(disk["disk_free"], check.Not(check.Equals), Int(0))
 }
 
-func (h *H) assertUptime(c *check.C, uptime map[string]float) {
-	c.Assert(uptime["uptime"], check.Not(check.Equals), float(0))
+func (h *H) assertUptime(c *check.C, uptime map[string]Metric) {
+	c.Assert(uptime["uptime"], check.Not(check.Equals), Int(0))
 }
@@ -0,0 +1,66 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package metric
+
+import (
+	"os"
+
+	"github.com/shirou/gopsutil/host"
+	"github.com/tsuru/bs/privilege"
+)
+
+const (
+	defaultNetworkInterface = "eth0"
+	hostDiskPath            = "/"
+)
+
+func checkHostProc() error {
+	return privilege.HostProc.Check()
+}
+
+// collectors returns the host metrics collectors, which only read procfs and
+// make syscalls, so they keep working when executing binaries is blocked. The
+// only exec left is the getconf CLK_TCK run by gopsutil when initialized.
+// When it fails, gopsutil falls back to 100 ticks per second. That's the
+// kernel USER_HZ on x86, arm and most other architectures, but not on all of
+// them, like alpha and ia64, where CPU times would be off.
+func (h *HostClient) collectors() []func() (map[string]Metric, error) {
+	return []func() (map[string]Metric, error){
+		h.getHostLoad,
+		h.getHostMem,
+		h.getHostSwap,
+		h.getHostFileSystemUsage,
+		h.getHostUptime,
+		h.getHostCpuTimes,
+		h.getHostNetworkUsage,
+		h.getHostVMStat,
+		h.getHostMDStat,
+		h.getHostListeningSockets,
+		h.getHostARP,
+	}
+}
+
+// hostMemExtra returns the memory metrics read directly from /proc/meminfo.
+func hostMemExtra() (map[string]Metric, error) {
+	return readMemInfo()
+}
+
+func (h *HostClient) getHostUptime() (map[string]Metric, error) {
+	uptime, err := host.Uptime()
+	if err != nil {
+		return nil, err
+	}
+	stats := map[string]Metric{"uptime": Int(int64(uptime))}
+	return stats, nil
+}
+
+// GetHostname returns the hostname without gopsutil HostInfo, which may
+// execute lsb_release.
+func (h *HostClient) GetHostname() (string, error) {
+	return os.Hostname()
+}
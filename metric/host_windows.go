@@ -0,0 +1,56 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultNetworkInterface = "Ethernet"
+	hostDiskPath            = `C:\`
+)
+
+var procGetTickCount64 = syscall.NewLazyDLL("kernel32.dll").NewProc("GetTickCount64")
+
+// checkHostProc always succeeds, as host metrics on Windows don't depend on
+// the proc filesystem.
+func checkHostProc() error {
+	return nil
+}
+
+// collectors skips the load average, which isn't available on Windows.
+func (h *HostClient) collectors() []func() (map[string]Metric, error) {
+	return []func() (map[string]Metric, error){
+		h.getHostMem,
+		h.getHostSwap,
+		h.getHostFileSystemUsage,
+		h.getHostUptime,
+		h.getHostCpuTimes,
+		h.getHostNetworkUsage,
+	}
+}
+
+// hostMemExtra returns no metrics, as slab, dirty and writeback memory are
+// only reported on Linux.
+func hostMemExtra() (map[string]Metric, error) {
+	return nil, nil
+}
+
+func (h *HostClient) getHostUptime() (map[string]Metric, error) {
+	ticks, _, err := procGetTickCount64.Call()
+	if ticks == 0 {
+		return nil, err
+	}
+	uptime := time.Duration(ticks) * time.Millisecond
+	stats := map[string]Metric{"uptime": Int(int64(uptime.Seconds()))}
+	return stats, nil
+}
+
+func (h *HostClient) GetHostname() (string, error) {
+	return os.Hostname()
+}
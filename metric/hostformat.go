@@ -0,0 +1,96 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostMetricsFormats are the formats supported by WriteHostMetrics.
+var HostMetricsFormats = []string{"json", "influx", "prom"}
+
+// WriteHostMetrics writes the metrics of a host to w in the given format:
+// a JSON object, InfluxDB line protocol or Prometheus text exposition format,
+// the latter without timestamps so it can be used by the node exporter
+// textfile collector. NaN and infinite values are left out of the JSON and
+// InfluxDB outputs, as those formats can't represent them.
+func WriteHostMetrics(w io.Writer, format, hostname string, metrics map[string]float64, ts time.Time) error {
+	keys := make([]string, 0, len(metrics))
+	for key, value := range metrics {
+		if format != "prom" && (math.IsNaN(value) || math.IsInf(value, 0)) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	switch format {
+	case "json":
+		values := make(map[string]float64, len(keys))
+		for _, key := range keys {
+			values[key] = metrics[key]
+		}
+		return json.NewEncoder(w).Encode(struct {
+			Host      string             `json:"host"`
+			Timestamp time.Time          `json:"timestamp"`
+			Metrics   map[string]float64 `json:"metrics"`
+		}{Host: hostname, Timestamp: ts, Metrics: values})
+	case "influx":
+		if len(keys) == 0 {
+			return nil
+		}
+		fields := make([]string, len(keys))
+		for i, key := range keys {
+			fields[i] = influxEscape(key) + "=" + formatValue(metrics[key])
+		}
+		_, err := fmt.Fprintf(w, "bs_host,host=%s %s %d\n", influxEscape(hostname), strings.Join(fields, ","), ts.UnixNano())
+		return err
+	case "prom":
+		bw := bufio.NewWriter(w)
+		host := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(hostname)
+		for _, key := range keys {
+			name := "bs_host_" + promName(key)
+			fmt.Fprintf(bw, "# TYPE %s gauge\n%s{host=\"%s\"} %s\n", name, name, host, formatValue(metrics[key]))
+		}
+		return bw.Flush()
+	}
+	return fmt.Errorf("invalid format %q, expected one of %s", format, strings.Join(HostMetricsFormats, ", "))
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+var influxReplacer = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func influxEscape(s string) string {
+	return influxReplacer.Replace(s)
+}
+
+// promName replaces the characters not allowed in Prometheus metric names
+// with underscores.
+func promName(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, key)
+}
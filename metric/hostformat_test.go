@@ -0,0 +1,45 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bytes"
+	"math"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestWriteHostMetrics(c *check.C) {
+	metrics := map[string]float64{
+		"load1":        0.5,
+		"mem_total":    2048,
+		"cpu_busy":     math.NaN(),
+		"disk.used-gb": 10,
+	}
+	ts := time.Date(2017, 6, 5, 16, 13, 47, 0, time.UTC)
+	var buf bytes.Buffer
+	err := WriteHostMetrics(&buf, "json", "my host", metrics, ts)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `{"host":"my host","timestamp":"2017-06-05T16:13:47Z","metrics":{"disk.used-gb":10,"load1":0.5,"mem_total":2048}}`+"\n")
+	buf.Reset()
+	err = WriteHostMetrics(&buf, "influx", "my host", metrics, ts)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `bs_host,host=my\ host disk.used-gb=10,load1=0.5,mem_total=2048 1496679227000000000`+"\n")
+	buf.Reset()
+	err = WriteHostMetrics(&buf, "prom", `my "host"`, metrics, ts)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, `# TYPE bs_host_cpu_busy gauge
+bs_host_cpu_busy{host="my \"host\""} NaN
+# TYPE bs_host_disk_used_gb gauge
+bs_host_disk_used_gb{host="my \"host\""} 10
+# TYPE bs_host_load1 gauge
+bs_host_load1{host="my \"host\""} 0.5
+# TYPE bs_host_mem_total gauge
+bs_host_mem_total{host="my \"host\""} 2048
+`)
+	err = WriteHostMetrics(&buf, "xml", "my host", metrics, ts)
+	c.Assert(err, check.ErrorMatches, `invalid format "xml", expected one of json, influx, prom`)
+}
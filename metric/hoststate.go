@@ -0,0 +1,98 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+)
+
+// maxHostStateAge is how old the saved host state may be to still be used,
+// past that the rates calculated from it would be averages over the time bs
+// was down, not over the last interval.
+const maxHostStateAge = 10 * time.Minute
+
+// hostState is the last reading of the host counters used to calculate CPU
+// percentages and paging rates, saved to METRICS_STATE_FILE so restarting
+// bs doesn't reset those calculations.
+type hostState struct {
+	SavedAt time.Time         `json:"saved_at"`
+	CPU     *cpu.CPUTimesStat `json:"cpu,omitempty"`
+	CPUTime time.Time         `json:"cpu_time"`
+	VMStat  *vmStatState      `json:"vmstat,omitempty"`
+}
+
+type vmStatState struct {
+	Time       time.Time `json:"time"`
+	SwapIn     uint64    `json:"swap_in"`
+	SwapOut    uint64    `json:"swap_out"`
+	Faults     uint64    `json:"faults"`
+	MajorFault uint64    `json:"major_fault"`
+}
+
+// loadState restores the counters saved in the state file, if any. A missing,
+// invalid or stale state file is ignored, as the deltas are then calculated
+// from the next reading on.
+func (h *HostClient) loadState(now time.Time) error {
+	if h.stateFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(h.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var state hostState
+	if err = json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if now.Sub(state.SavedAt) > maxHostStateAge || state.SavedAt.After(now) {
+		return nil
+	}
+	h.lastCPUStats = state.CPU
+	h.lastCPUTime = state.CPUTime
+	if v := state.VMStat; v != nil {
+		h.lastVMStat = &vmStat{time: v.Time, swapIn: v.SwapIn, swapOut: v.SwapOut, faults: v.Faults, majorFault: v.MajorFault}
+	}
+	return nil
+}
+
+// saveState writes the last reading of the counters to the state file,
+// replacing it atomically so a crash never leaves a partial file behind.
+func (h *HostClient) saveState(now time.Time) error {
+	if h.stateFile == "" {
+		return nil
+	}
+	state := hostState{SavedAt: now, CPU: h.lastCPUStats, CPUTime: h.lastCPUTime}
+	if v := h.lastVMStat; v != nil {
+		state.VMStat = &vmStatState{Time: v.time, SwapIn: v.swapIn, SwapOut: v.swapOut, Faults: v.faults, MajorFault: v.majorFault}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(h.stateFile), filepath.Base(h.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.stateFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestHostStateRoundTrip(c *check.C) {
+	path := filepath.Join(c.MkDir(), "state.json")
+	now := time.Now()
+	h := &HostClient{
+		stateFile:    path,
+		lastCPUStats: &cpu.CPUTimesStat{User: 10, System: 5, Idle: 100, Stolen: 2},
+		lastCPUTime:  now.Add(-time.Minute),
+		lastVMStat:   &vmStat{time: now.Add(-time.Minute), swapIn: 1, swapOut: 2, faults: 3, majorFault: 4},
+	}
+	c.Assert(h.saveState(now), check.IsNil)
+	restored := &HostClient{stateFile: path}
+	c.Assert(restored.loadState(now.Add(time.Minute)), check.IsNil)
+	c.Assert(restored.lastCPUStats, check.DeepEquals, h.lastCPUStats)
+	c.Assert(restored.lastCPUTime.Equal(h.lastCPUTime), check.Equals, true)
+	c.Assert(restored.lastVMStat, check.NotNil)
+	c.Assert(restored.lastVMStat.time.Equal(h.lastVMStat.time), check.Equals, true)
+	c.Assert(restored.lastVMStat.swapIn, check.Equals, uint64(1))
+	c.Assert(restored.lastVMStat.majorFault, check.Equals, uint64(4))
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	c.Assert(err, check.IsNil)
+	c.Assert(files, check.HasLen, 1)
+}
+
+func (s *S) TestHostStateStale(c *check.C) {
+	path := filepath.Join(c.MkDir(), "state.json")
+	now := time.Now()
+	h := &HostClient{stateFile: path, lastCPUStats: &cpu.CPUTimesStat{User: 10}, lastCPUTime: now}
+	c.Assert(h.saveState(now), check.IsNil)
+	restored := &HostClient{stateFile: path}
+	c.Assert(restored.loadState(now.Add(maxHostStateAge+time.Second)), check.IsNil)
+	c.Assert(restored.lastCPUStats, check.IsNil)
+}
+
+func (s *S) TestHostStateMissingFile(c *check.C) {
+	h := &HostClient{stateFile: filepath.Join(c.MkDir(), "state.json")}
+	c.Assert(h.loadState(time.Now()), check.IsNil)
+	c.Assert(h.lastCPUStats, check.IsNil)
+	h = &HostClient{}
+	c.Assert(h.saveState(time.Now()), check.IsNil)
+}
+
+func (s *S) TestHostStateInvalidFile(c *check.C) {
+	path := filepath.Join(c.MkDir(), "state.json")
+	c.Assert(ioutil.WriteFile(path, []byte("not json"), 0600), check.IsNil)
+	h := &HostClient{stateFile: path}
+	c.Assert(h.loadState(time.Now()), check.NotNil)
+	c.Assert(h.lastCPUStats, check.IsNil)
+}
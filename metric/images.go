@@ -0,0 +1,62 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"github.com/fsouza/go-dockerclient"
+)
+
+// getImageMetrics reports the disk space used by Docker images in the node:
+// the number of images and their total size, the size of dangling images and
+// the size of the build cache, made of the intermediate images created by
+// docker build. Those are the images removed by a garbage collection.
+func getImageMetrics(client *docker.Client) (map[string]Metric, error) {
+	images, err := client.ListImages(docker.ListImagesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	allImages, err := client.ListImages(docker.ListImagesOptions{All: true})
+	if err != nil {
+		return nil, err
+	}
+	dangling, err := client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{"dangling": {"true"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imageMetrics(images, allImages, dangling), nil
+}
+
+func imageMetrics(images, allImages, dangling []docker.APIImages) map[string]Metric {
+	var imagesSize, danglingSize, buildCacheSize int64
+	topLevel := make(map[string]struct{}, len(images))
+	for _, img := range images {
+		topLevel[img.ID] = struct{}{}
+		imagesSize += img.Size
+	}
+	for _, img := range dangling {
+		danglingSize += img.Size
+	}
+	sizes := make(map[string]int64, len(allImages))
+	for _, img := range allImages {
+		sizes[img.ID] = img.Size
+	}
+	for _, img := range allImages {
+		if _, ok := topLevel[img.ID]; ok {
+			continue
+		}
+		// Image sizes include their parents, only the size of the layer
+		// added by the intermediate image is accounted.
+		buildCacheSize += img.Size - sizes[img.ParentID]
+	}
+	return map[string]Metric{
+		"images":                  Int(int64(len(images))),
+		"images_size":             Int(int64(imagesSize)),
+		"images_dangling":         Int(int64(len(dangling))),
+		"images_dangling_size":    Int(int64(danglingSize)),
+		"images_build_cache_size": Int(int64(buildCacheSize)),
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestImageMetrics(c *check.C) {
+	base := docker.APIImages{ID: "base", RepoTags: []string{"ubuntu:latest"}, Size: 100}
+	app := docker.APIImages{ID: "app", ParentID: "step2", RepoTags: []string{"myapp:v1"}, Size: 180}
+	old := docker.APIImages{ID: "old", ParentID: "base", Size: 150}
+	step1 := docker.APIImages{ID: "step1", ParentID: "base", Size: 120}
+	step2 := docker.APIImages{ID: "step2", ParentID: "step1", Size: 170}
+	images := []docker.APIImages{base, app, old}
+	allImages := []docker.APIImages{base, app, old, step1, step2}
+	dangling := []docker.APIImages{old}
+	c.Assert(imageMetrics(images, allImages, dangling), check.DeepEquals, map[string]Metric{
+		"images":                  Int(3),
+		"images_size":             Int(430),
+		"images_dangling":         Int(1),
+		"images_dangling_size":    Int(150),
+		"images_build_cache_size": Int(70),
+	})
+}
+
+func (s *S) TestGetImageMetrics(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		images := []docker.APIImages{{ID: "app", ParentID: "step1", RepoTags: []string{"myapp:v1"}, Size: 200}}
+		if r.URL.Query().Get("all") == "1" {
+			images = append(images, docker.APIImages{ID: "step1", Size: 150})
+		}
+		if r.URL.Query().Get("filters") != "" {
+			images = []docker.APIImages{{ID: "old", Size: 50}}
+		}
+		json.NewEncoder(w).Encode(images)
+	}))
+	defer server.Close()
+	client, err := docker.NewClient(server.URL)
+	c.Assert(err, check.IsNil)
+	metrics, err := getImageMetrics(client)
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"images":                  Int(1),
+		"images_size":             Int(200),
+		"images_dangling":         Int(1),
+		"images_dangling_size":    Int(50),
+		"images_build_cache_size": Int(150),
+	})
+}
@@ -6,6 +6,7 @@ package logstash
 
 import (
 	"encoding/json"
+	"fmt"
 	"net"
 
 	"github.com/tsuru/bs/bslog"
@@ -13,33 +14,61 @@ import (
 	"github.com/tsuru/bs/metric"
 )
 
+// Versions of the documents sent to logstash, set in
+// METRICS_LOGSTASH_SCHEMA_VERSION. Version 1 is the legacy schema, with the
+// container and host information at the top level of the document and host
+// metrics prefixed with "host_". Version 2 carries the version in the
+// schema_version field and moves the information identifying the source of
+// the metric to the tags object.
+const (
+	schemaLegacy = 1
+	schemaTags   = 2
+)
+
+const (
+	defaultClient   = "tsuru"
+	defaultPort     = "1984"
+	defaultHost     = "localhost"
+	defaultProtocol = "udp"
+)
+
 func init() {
 	metric.Register("logstash", new)
+	config.DeclareDefault("METRICS_LOGSTASH_SCHEMA_VERSION", schemaLegacy)
+	config.DeclareDefault("METRICS_LOGSTASH_CLIENT", defaultClient)
+	config.DeclareDefault("METRICS_LOGSTASH_HOST", defaultHost)
+	config.DeclareDefault("METRICS_LOGSTASH_PORT", defaultPort)
+	config.DeclareDefault("METRICS_LOGSTASH_PROTOCOL", defaultProtocol)
 }
 
 func new() (metric.Backend, error) {
-	const (
-		defaultClient   = "tsuru"
-		defaultPort     = "1984"
-		defaultHost     = "localhost"
-		defaultProtocol = "udp"
-	)
+	version := config.IntEnvOrDefault(schemaLegacy, "METRICS_LOGSTASH_SCHEMA_VERSION")
+	if version != schemaLegacy && version != schemaTags {
+		return nil, fmt.Errorf("invalid METRICS_LOGSTASH_SCHEMA_VERSION %d, expected %d or %d", version, schemaLegacy, schemaTags)
+	}
 	return &logStash{
-		Client:   config.StringEnvOrDefault(defaultClient, "METRICS_LOGSTASH_CLIENT"),
-		Host:     config.StringEnvOrDefault(defaultHost, "METRICS_LOGSTASH_HOST"),
-		Port:     config.StringEnvOrDefault(defaultPort, "METRICS_LOGSTASH_PORT"),
-		Protocol: config.StringEnvOrDefault(defaultProtocol, "METRICS_LOGSTASH_PROTOCOL"),
+		Client:        config.StringEnvOrDefault(defaultClient, "METRICS_LOGSTASH_CLIENT"),
+		Host:          config.StringEnvOrDefault(defaultHost, "METRICS_LOGSTASH_HOST"),
+		Port:          config.StringEnvOrDefault(defaultPort, "METRICS_LOGSTASH_PORT"),
+		Protocol:      config.StringEnvOrDefault(defaultProtocol, "METRICS_LOGSTASH_PROTOCOL"),
+		SchemaVersion: version,
+		ExtraTags:     config.TagsEnvOrDefault(nil, "METRICS_EXTRA_TAGS"),
 	}, nil
 }
 
 type logStash struct {
-	Host     string
-	Port     string
-	Client   string
-	Protocol string
+	Host          string
+	Port          string
+	Client        string
+	Protocol      string
+	SchemaVersion int
+	ExtraTags     map[string]string
 }
 
 func (s *logStash) Send(container metric.ContainerInfo, key string, value interface{}) error {
+	if s.SchemaVersion == schemaTags {
+		return s.send(s.taggedMessage(key, value, container.Hostname, containerTags(container)))
+	}
 	message := map[string]interface{}{
 		"client": s.Client,
 		"count":  1,
@@ -51,6 +80,9 @@ func (s *logStash) Send(container metric.ContainerInfo, key string, value interf
 }
 
 func (s *logStash) SendConn(container metric.ContainerInfo, host string) error {
+	if s.SchemaVersion == schemaTags {
+		return s.send(s.taggedMessage("connection", host, container.Hostname, containerTags(container)))
+	}
 	message := map[string]interface{}{
 		"client":     s.Client,
 		"count":      1,
@@ -62,6 +94,15 @@ func (s *logStash) SendConn(container metric.ContainerInfo, host string) error {
 }
 
 func (s *logStash) SendHost(host metric.HostInfo, key string, value interface{}) error {
+	if s.SchemaVersion == schemaTags {
+		tags := map[string]interface{}{
+			"source": "host",
+			"addr":   host.Addrs,
+		}
+		appendNode(tags, host.Pool, host.Node)
+		appendTag(tags, value)
+		return s.send(s.taggedMessage(key, value, host.Name, tags))
+	}
 	message := map[string]interface{}{
 		"client": s.Client,
 		"count":  1,
@@ -70,6 +111,8 @@ func (s *logStash) SendHost(host metric.HostInfo, key string, value interface{})
 		"host":   host.Name,
 		"addr":   host.Addrs,
 	}
+	appendNode(message, host.Pool, host.Node)
+	appendTag(message, value)
 	return s.send(message)
 }
 
@@ -83,9 +126,86 @@ func (s *logStash) appendInfo(message map[string]interface{}, container metric.C
 		message["image"] = container.Image
 	}
 	message["labels"] = container.Labels
+	if container.Terminated {
+		message["terminated"] = true
+	}
+	if container.Aggregate {
+		message["aggregate"] = true
+	}
+	appendNode(message, container.Pool, container.Node)
+}
+
+// appendTag adds the tag of a metric value, like the process name of the top
+// processes metrics, to the message.
+func appendTag(message map[string]interface{}, value interface{}) {
+	if m, ok := value.(metric.Metric); ok {
+		if key, tagValue := m.Tag(); key != "" {
+			message[key] = tagValue
+		}
+	}
+}
+
+// taggedMessage returns a message in the version 2 schema.
+func (s *logStash) taggedMessage(key string, value interface{}, host string, tags map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schema_version": schemaTags,
+		"client":         s.Client,
+		"count":          1,
+		"metric":         key,
+		"value":          value,
+		"host":           host,
+		"tags":           tags,
+	}
+}
+
+func containerTags(container metric.ContainerInfo) map[string]interface{} {
+	tags := map[string]interface{}{
+		"source": "container",
+		"labels": container.Labels,
+	}
+	if container.App != "" {
+		tags["app"] = container.App
+		tags["process"] = container.Process
+	} else {
+		tags["container"] = container.Name
+		tags["image"] = container.Image
+	}
+	if container.Terminated {
+		tags["terminated"] = true
+	}
+	if container.Aggregate {
+		tags["aggregate"] = true
+	}
+	appendNode(tags, container.Pool, container.Node)
+	return tags
+}
+
+func appendNode(message map[string]interface{}, pool, node string) {
+	if pool != "" {
+		message["pool"] = pool
+	}
+	if node != "" {
+		message["node"] = node
+	}
+}
+
+// appendExtraTags adds the tags from METRICS_EXTRA_TAGS to the message, to
+// the tags object in the version 2 schema or to the top level in the legacy
+// one. They never replace the fields set by bs.
+func (s *logStash) appendExtraTags(message map[string]interface{}) {
+	target := message
+	if tags, ok := message["tags"].(map[string]interface{}); ok {
+		target = tags
+	}
+	for k, v := range s.ExtraTags {
+		if _, ok := target[k]; !ok {
+			target[k] = v
+		}
+	}
 }
 
 func (s *logStash) send(message map[string]interface{}) error {
+	s.appendExtraTags(message)
 	conn, err := net.Dial(s.Protocol, net.JoinHostPort(s.Host, s.Port))
 	if err != nil {
 		return err
@@ -99,6 +99,78 @@ func (s *S) TestSend(c *check.C) {
 	c.Assert(got, check.DeepEquals, expected)
 }
 
+func (s *S) TestSendHostWithNode(c *check.C) {
+	addr := net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	conn, err := net.ListenUDP("udp", &addr)
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	st := logStash{
+		Client:   "test",
+		Host:     host,
+		Port:     port,
+		Protocol: "udp",
+	}
+	err = st.SendHost(metric.HostInfo{
+		Name:  "hostname",
+		Addrs: []string{"10.0.0.1"},
+		Pool:  "mypool",
+		Node:  "http://10.0.0.1:2375",
+	}, "cpu", 10)
+	c.Assert(err, check.IsNil)
+	var data [512]byte
+	n, _, err := conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	expected := map[string]interface{}{
+		"count":  float64(1),
+		"client": "test",
+		"metric": "host_cpu",
+		"value":  float64(10),
+		"host":   "hostname",
+		"addr":   []interface{}{"10.0.0.1"},
+		"pool":   "mypool",
+		"node":   "http://10.0.0.1:2375",
+	}
+	var got map[string]interface{}
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, expected)
+}
+
+func (s *S) TestSendHostTaggedMetric(c *check.C) {
+	addr := net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	conn, err := net.ListenUDP("udp", &addr)
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	st := logStash{
+		Client:   "test",
+		Host:     host,
+		Port:     port,
+		Protocol: "udp",
+	}
+	err = st.SendHost(metric.HostInfo{Name: "hostname"}, "process_1_mem_rss", metric.Int(9000).WithTag("process_name", "java"))
+	c.Assert(err, check.IsNil)
+	var data [512]byte
+	n, _, err := conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	expected := map[string]interface{}{
+		"count":        float64(1),
+		"client":       "test",
+		"metric":       "host_process_1_mem_rss",
+		"value":        float64(9000),
+		"host":         "hostname",
+		"addr":         nil,
+		"process_name": "java",
+	}
+	var got map[string]interface{}
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, expected)
+}
+
 func (s *S) TestSendTCP(c *check.C) {
 	addr := net.TCPAddr{IP: net.ParseIP("127.0.0.1")}
 	conn, err := net.ListenTCP("tcp", &addr)
@@ -157,14 +229,16 @@ func (s *S) TestNewLogStasDefaults(c *check.C) {
 	os.Unsetenv("METRICS_LOGSTASH_HOST")
 	os.Unsetenv("METRICS_LOGSTASH_PORT")
 	os.Unsetenv("METRICS_LOGSTASH_PROTOCOL")
+	os.Unsetenv("METRICS_LOGSTASH_SCHEMA_VERSION")
 
 	st, err := new()
 	c.Assert(err, check.IsNil)
 	expected := &logStash{
-		Host:     "localhost",
-		Port:     "1984",
-		Client:   "tsuru",
-		Protocol: "udp",
+		Host:          "localhost",
+		Port:          "1984",
+		Client:        "tsuru",
+		Protocol:      "udp",
+		SchemaVersion: 1,
 	}
 	c.Assert(st, check.DeepEquals, expected)
 }
@@ -174,14 +248,161 @@ func (s *S) TestNewLogStashEnvs(c *check.C) {
 	os.Setenv("METRICS_LOGSTASH_HOST", "127.0.0.1")
 	os.Setenv("METRICS_LOGSTASH_PORT", "1983")
 	os.Setenv("METRICS_LOGSTASH_PROTOCOL", "tcp")
+	os.Setenv("METRICS_LOGSTASH_SCHEMA_VERSION", "2")
+	defer os.Unsetenv("METRICS_LOGSTASH_SCHEMA_VERSION")
 
 	st, err := new()
 	c.Assert(err, check.IsNil)
 	expected := &logStash{
-		Host:     "127.0.0.1",
-		Port:     "1983",
-		Client:   "tsurutest",
-		Protocol: "tcp",
+		Host:          "127.0.0.1",
+		Port:          "1983",
+		Client:        "tsurutest",
+		Protocol:      "tcp",
+		SchemaVersion: 2,
 	}
 	c.Assert(st, check.DeepEquals, expected)
 }
+
+func (s *S) TestNewLogStashInvalidSchemaVersion(c *check.C) {
+	os.Setenv("METRICS_LOGSTASH_SCHEMA_VERSION", "3")
+	defer os.Unsetenv("METRICS_LOGSTASH_SCHEMA_VERSION")
+	_, err := new()
+	c.Assert(err, check.ErrorMatches, "invalid METRICS_LOGSTASH_SCHEMA_VERSION 3, expected 1 or 2")
+}
+
+func (s *S) TestSendSchemaVersion2(c *check.C) {
+	addr := net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	conn, err := net.ListenUDP("udp", &addr)
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	st := logStash{
+		Client:        "test",
+		Host:          host,
+		Port:          port,
+		Protocol:      "udp",
+		SchemaVersion: 2,
+	}
+	err = st.Send(metric.ContainerInfo{
+		App:      "app",
+		Hostname: "hostname",
+		Process:  "process",
+		Labels: map[string]string{
+			"tsuru.pool.name": "mypool",
+		},
+		Pool: "mypool",
+	}, "key", 10)
+	c.Assert(err, check.IsNil)
+	var data [512]byte
+	n, _, err := conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	expected := map[string]interface{}{
+		"schema_version": float64(2),
+		"count":          float64(1),
+		"client":         "test",
+		"metric":         "key",
+		"value":          float64(10),
+		"host":           "hostname",
+		"tags": map[string]interface{}{
+			"source":  "container",
+			"app":     "app",
+			"process": "process",
+			"pool":    "mypool",
+			"labels": map[string]interface{}{
+				"tsuru.pool.name": "mypool",
+			},
+		},
+	}
+	var got map[string]interface{}
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, expected)
+	err = st.SendHost(metric.HostInfo{
+		Name:  "hostname",
+		Addrs: []string{"10.0.0.1"},
+		Node:  "http://10.0.0.1:2375",
+	}, "cpu", 10)
+	c.Assert(err, check.IsNil)
+	n, _, err = conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	expected = map[string]interface{}{
+		"schema_version": float64(2),
+		"count":          float64(1),
+		"client":         "test",
+		"metric":         "cpu",
+		"value":          float64(10),
+		"host":           "hostname",
+		"tags": map[string]interface{}{
+			"source": "host",
+			"addr":   []interface{}{"10.0.0.1"},
+			"node":   "http://10.0.0.1:2375",
+		},
+	}
+	got = nil
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, expected)
+	err = st.SendHost(metric.HostInfo{Name: "hostname"}, "process_1_cpu_pct", metric.Float(42.5).WithTag("process_name", "dockerd"))
+	c.Assert(err, check.IsNil)
+	n, _, err = conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	expected = map[string]interface{}{
+		"schema_version": float64(2),
+		"count":          float64(1),
+		"client":         "test",
+		"metric":         "process_1_cpu_pct",
+		"value":          42.5,
+		"host":           "hostname",
+		"tags": map[string]interface{}{
+			"source":       "host",
+			"addr":         nil,
+			"process_name": "dockerd",
+		},
+	}
+	got = nil
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got, check.DeepEquals, expected)
+}
+
+func (s *S) TestSendExtraTags(c *check.C) {
+	addr := net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	conn, err := net.ListenUDP("udp", &addr)
+	c.Assert(err, check.IsNil)
+	defer conn.Close()
+	host, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	c.Assert(err, check.IsNil)
+	st := logStash{
+		Client:    "test",
+		Host:      host,
+		Port:      port,
+		Protocol:  "udp",
+		ExtraTags: map[string]string{"dc": "sp1", "host": "other"},
+	}
+	err = st.SendHost(metric.HostInfo{Name: "hostname"}, "cpu", 10)
+	c.Assert(err, check.IsNil)
+	var data [512]byte
+	n, _, err := conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	var got map[string]interface{}
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got["dc"], check.Equals, "sp1")
+	c.Assert(got["host"], check.Equals, "hostname")
+	st.SchemaVersion = schemaTags
+	err = st.SendHost(metric.HostInfo{Name: "hostname"}, "cpu", 10)
+	c.Assert(err, check.IsNil)
+	n, _, err = conn.ReadFrom(data[:])
+	c.Assert(err, check.IsNil)
+	got = nil
+	err = json.Unmarshal(data[:n], &got)
+	c.Assert(err, check.IsNil)
+	c.Assert(got["dc"], check.IsNil)
+	c.Assert(got["tags"], check.DeepEquals, map[string]interface{}{
+		"source": "host",
+		"addr":   nil,
+		"dc":     "sp1",
+		"host":   "other",
+	})
+}
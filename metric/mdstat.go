@@ -0,0 +1,121 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+)
+
+var (
+	// mdDevicesRegexp matches the number of devices of an array and the
+	// number of devices in use, like [2/1], in the status line.
+	mdDevicesRegexp = regexp.MustCompile(`\[(\d+)/(\d+)\]`)
+	// mdSyncRegexp matches the progress of a resync, recovery, reshape or
+	// check of an array, like "recovery =  8.5%".
+	mdSyncRegexp = regexp.MustCompile(`(resync|recovery|reshape|check)\s*=\s*([\d.]+)%`)
+)
+
+type mdArray struct {
+	name          string
+	active        bool
+	devices       int
+	activeDevices int
+	failedDevices int
+	syncPct       float64
+}
+
+func (h *HostClient) getHostMDStat() (map[string]Metric, error) {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	file, err := os.Open(filepath.Join(procPath, "mdstat"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]Metric{}, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	arrays, err := parseMDStat(file)
+	if err != nil {
+		return nil, err
+	}
+	return mdStatMetrics(arrays), nil
+}
+
+// parseMDStat parses the software RAID arrays listed in /proc/mdstat.
+func parseMDStat(r io.Reader) ([]mdArray, error) {
+	var arrays []mdArray
+	var current *mdArray
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "md") && len(fields) >= 3 && fields[1] == ":" {
+			arrays = append(arrays, mdArray{
+				name:    fields[0],
+				active:  fields[2] == "active",
+				syncPct: 100,
+			})
+			current = &arrays[len(arrays)-1]
+			for _, device := range fields[3:] {
+				if strings.HasSuffix(device, "(F)") {
+					current.failedDevices++
+				}
+			}
+			continue
+		}
+		if current == nil || !strings.HasPrefix(line, " ") {
+			current = nil
+			continue
+		}
+		if m := mdDevicesRegexp.FindStringSubmatch(line); m != nil && strings.Contains(line, "blocks") {
+			current.devices, _ = strconv.Atoi(m[1])
+			current.activeDevices, _ = strconv.Atoi(m[2])
+		}
+		if m := mdSyncRegexp.FindStringSubmatch(line); m != nil {
+			pct, err := strconv.ParseFloat(m[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s progress of %s: %s", m[1], current.name, err)
+			}
+			current.syncPct = pct
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return arrays, nil
+}
+
+// mdStatMetrics returns the state of every array, along with the number of
+// degraded arrays in the host. An array is degraded when it's missing
+// devices, which happens for arrays without redundancy only when they're not
+// active.
+func mdStatMetrics(arrays []mdArray) map[string]Metric {
+	metrics := make(map[string]Metric)
+	var degradedArrays int64
+	for _, array := range arrays {
+		var degraded int64
+		if !array.active || array.activeDevices < array.devices {
+			degraded = 1
+		}
+		degradedArrays += degraded
+		metrics["md_degraded_"+array.name] = Int(degraded)
+		metrics["md_failed_devices_"+array.name] = Int(int64(array.failedDevices))
+		metrics["md_sync_pct_"+array.name] = Float(array.syncPct)
+	}
+	metrics["md_degraded_arrays"] = Int(degradedArrays)
+	return metrics
+}
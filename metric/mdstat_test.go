@@ -0,0 +1,88 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+const mdStatSample = `Personalities : [raid1] [raid6] [raid5] [raid4] [raid0]
+md0 : active raid1 sdb1[1] sda1[0]
+      1046528 blocks super 1.2 [2/2] [UU]
+
+md1 : active raid1 sdb2[2](F) sda2[0]
+      975584256 blocks super 1.2 [2/1] [U_]
+      [=>...................]  recovery =  8.5% (83071616/975584256) finish=72.5min speed=205114K/sec
+      bitmap: 4/8 pages [16KB], 65536KB chunk
+
+md2 : active raid0 sdd1[1] sdc1[0]
+      2095104 blocks super 1.2 512k chunks
+
+md3 : inactive sde1[0](S)
+      1048576 blocks super 1.2
+
+unused devices: <none>
+`
+
+func (*S) TestParseMDStat(c *check.C) {
+	arrays, err := parseMDStat(strings.NewReader(mdStatSample))
+	c.Assert(err, check.IsNil)
+	c.Assert(arrays, check.DeepEquals, []mdArray{
+		{name: "md0", active: true, devices: 2, activeDevices: 2, syncPct: 100},
+		{name: "md1", active: true, devices: 2, activeDevices: 1, failedDevices: 1, syncPct: 8.5},
+		{name: "md2", active: true, syncPct: 100},
+		{name: "md3", syncPct: 100},
+	})
+}
+
+func (*S) TestMDStatMetrics(c *check.C) {
+	arrays, err := parseMDStat(strings.NewReader(mdStatSample))
+	c.Assert(err, check.IsNil)
+	c.Assert(mdStatMetrics(arrays), check.DeepEquals, map[string]Metric{
+		"md_degraded_arrays":    Int(2),
+		"md_degraded_md0":       Int(0),
+		"md_failed_devices_md0": Int(0),
+		"md_sync_pct_md0":       Float(100),
+		"md_degraded_md1":       Int(1),
+		"md_failed_devices_md1": Int(1),
+		"md_sync_pct_md1":       Float(8.5),
+		"md_degraded_md2":       Int(0),
+		"md_failed_devices_md2": Int(0),
+		"md_sync_pct_md2":       Float(100),
+		"md_degraded_md3":       Int(1),
+		"md_failed_devices_md3": Int(0),
+		"md_sync_pct_md3":       Float(100),
+	})
+}
+
+func (*S) TestMDStatMetricsNoArrays(c *check.C) {
+	arrays, err := parseMDStat(strings.NewReader("Personalities : \nunused devices: <none>\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(arrays, check.HasLen, 0)
+	c.Assert(mdStatMetrics(arrays), check.DeepEquals, map[string]Metric{"md_degraded_arrays": Int(0)})
+}
+
+func (*S) TestGetHostMDStat(c *check.C) {
+	dir, err := ioutil.TempDir("", "mdstat")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", dir)
+	defer os.Setenv("HOST_PROC", oldProc)
+	var h HostClient
+	metrics, err := h.getHostMDStat()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{})
+	err = ioutil.WriteFile(filepath.Join(dir, "mdstat"), []byte(mdStatSample), 0644)
+	c.Assert(err, check.IsNil)
+	metrics, err = h.getHostMDStat()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics["md_degraded_arrays"], check.Equals, Int(2))
+}
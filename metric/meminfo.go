@@ -0,0 +1,59 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+)
+
+// memInfoFields maps the /proc/meminfo fields not exposed by gopsutil to
+// the metrics reported for them.
+var memInfoFields = map[string]string{
+	"Slab":      "mem_slab",
+	"Dirty":     "mem_dirty",
+	"Writeback": "mem_writeback",
+}
+
+func readMemInfo() (map[string]Metric, error) {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	file, err := os.Open(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseMemInfo(file)
+}
+
+// parseMemInfo returns the fields in memInfoFields, in bytes.
+func parseMemInfo(r io.Reader) (map[string]Metric, error) {
+	stats := make(map[string]Metric, len(memInfoFields))
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, ok := memInfoFields[parts[0]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(parts[1]), " kB"), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stats[name] = Int(int64(value * 1024))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
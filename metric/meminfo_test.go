@@ -0,0 +1,29 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseMemInfo(c *check.C) {
+	data := `MemTotal:       16323740 kB
+Buffers:          386424 kB
+Cached:          5123112 kB
+Dirty:               148 kB
+Writeback:             0 kB
+Slab:             623380 kB
+SReclaimable:     496784 kB
+`
+	stats, err := parseMemInfo(strings.NewReader(data))
+	c.Assert(err, check.IsNil)
+	c.Assert(stats, check.DeepEquals, map[string]Metric{
+		"mem_slab":      Int(623380 * 1024),
+		"mem_dirty":     Int(148 * 1024),
+		"mem_writeback": Int(0),
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources of the NTP metrics, set in METRICS_NTP_SOURCE.
+const (
+	ntpSourceAdjtimex = "adjtimex"
+	ntpSourceChrony   = "chrony"
+	ntpSourceNTPd     = "ntpd"
+)
+
+// ntpStatus is the state of the host clock synchronization. Offset is
+// positive when the host clock is behind the reference clock, like
+// clock_skew_ms.
+type ntpStatus struct {
+	offsetMs float64
+	synced   bool
+	stratum  float64
+}
+
+func (s *ntpStatus) metrics() map[string]Metric {
+	metrics := map[string]Metric{
+		"ntp_offset_ms": Float(s.offsetMs),
+		"ntp_synced":    Int(0),
+	}
+	if s.synced {
+		metrics["ntp_synced"] = Int(1)
+	}
+	if s.stratum > 0 {
+		metrics["ntp_stratum"] = Int(int64(s.stratum))
+	}
+	return metrics
+}
+
+// ntpCollector returns the collector of the NTP offset and sync status read
+// from the given source.
+func ntpCollector(source string) (func() (map[string]Metric, error), error) {
+	var read func() (*ntpStatus, error)
+	switch source {
+	case ntpSourceAdjtimex:
+		read = adjtimexStatus
+	case ntpSourceChrony:
+		read = chronyStatus
+	case ntpSourceNTPd:
+		read = ntpdStatus
+	default:
+		return nil, fmt.Errorf("invalid NTP source %q, expected %s, %s or %s", source, ntpSourceAdjtimex, ntpSourceChrony, ntpSourceNTPd)
+	}
+	return func() (map[string]Metric, error) {
+		status, err := read()
+		if err != nil {
+			return nil, err
+		}
+		return status.metrics(), nil
+	}, nil
+}
+
+// commandTimeout is how long the commands run by collectors, like chronyc,
+// ntpq and busctl, may take before being killed, so a hung daemon doesn't
+// stall the collection. Overridden by tests.
+var commandTimeout = 10 * time.Second
+
+func runCommand(name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out after %s", name, commandTimeout)
+		}
+		return nil, fmt.Errorf("%s failed: %s. Output: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func chronyStatus() (*ntpStatus, error) {
+	out, err := runCommand("chronyc", "-c", "tracking")
+	if err != nil {
+		return nil, err
+	}
+	return parseChronyTracking(out)
+}
+
+// parseChronyTracking parses the CSV output of chronyc tracking. The system
+// time field is positive when the system clock is slow.
+func parseChronyTracking(out []byte) (*ntpStatus, error) {
+	fields := strings.Split(strings.TrimSpace(string(out)), ",")
+	if len(fields) < 14 {
+		return nil, fmt.Errorf("invalid chronyc tracking output: %q", out)
+	}
+	stratum, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chronyc stratum %q: %s", fields[2], err)
+	}
+	offset, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chronyc system time %q: %s", fields[4], err)
+	}
+	return &ntpStatus{
+		offsetMs: offset * 1000,
+		synced:   fields[13] != "Not synchronised" && stratum > 0,
+		stratum:  stratum,
+	}, nil
+}
+
+func ntpdStatus() (*ntpStatus, error) {
+	out, err := runCommand("ntpq", "-n", "-c", "rv 0 leap,stratum,offset")
+	if err != nil {
+		return nil, err
+	}
+	return parseNTPqVariables(out)
+}
+
+// parseNTPqVariables parses the system variables printed by ntpq. The offset
+// is the offset of the server relative to the host, in milliseconds, and a
+// leap indicator of 11 means the clock isn't synchronized.
+func parseNTPqVariables(out []byte) (*ntpStatus, error) {
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		for _, pair := range strings.Split(scanner.Text(), ",") {
+			parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(parts) == 2 {
+				vars[parts[0]] = strings.Trim(parts[1], `"`)
+			}
+		}
+	}
+	if vars["offset"] == "" || vars["leap"] == "" || vars["stratum"] == "" {
+		return nil, errors.New("leap, stratum or offset missing in ntpq output")
+	}
+	offset, err := strconv.ParseFloat(vars["offset"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ntpq offset %q: %s", vars["offset"], err)
+	}
+	stratum, err := strconv.ParseFloat(vars["stratum"], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ntpq stratum %q: %s", vars["stratum"], err)
+	}
+	return &ntpStatus{
+		offsetMs: offset,
+		synced:   vars["leap"] != "11" && stratum < 16,
+		stratum:  stratum,
+	}, nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import "syscall"
+
+const (
+	// adjtimexTimeError is the clock state returned by adjtimex when the
+	// clock isn't synchronized.
+	adjtimexTimeError = 5
+	staUnsync         = 0x0040
+	staNano           = 0x2000
+)
+
+// adjtimexStatus reads the offset still being corrected by the kernel and its
+// synchronization status, as set by the NTP daemon running in the host.
+func adjtimexStatus() (*ntpStatus, error) {
+	var timex syscall.Timex
+	state, err := syscall.Adjtimex(&timex)
+	if err != nil {
+		return nil, err
+	}
+	offsetMs := float64(timex.Offset) / 1e3
+	if timex.Status&staNano != 0 {
+		offsetMs = float64(timex.Offset) / 1e6
+	}
+	return &ntpStatus{
+		offsetMs: offsetMs,
+		synced:   state != adjtimexTimeError && timex.Status&staUnsync == 0,
+	}, nil
+}
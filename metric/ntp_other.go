@@ -0,0 +1,14 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package metric
+
+import "errors"
+
+func adjtimexStatus() (*ntpStatus, error) {
+	return nil, errors.New("adjtimex is only available on Linux")
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"time"
+
+	"github.com/tsuru/commandmocker"
+	"gopkg.in/check.v1"
+)
+
+func (*S) TestParseChronyTracking(c *check.C) {
+	out := "A9FEA97B,169.254.169.123,3,1496679227.123456789,0.000012500,-0.000002115,0.000012345,-3.456,0.001,0.012,0.000123,0.000456,64.3,Normal\n"
+	status, err := parseChronyTracking([]byte(out))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.metrics(), check.DeepEquals, map[string]Metric{
+		"ntp_offset_ms": Float(0.0125),
+		"ntp_synced":    Int(1),
+		"ntp_stratum":   Int(3),
+	})
+	out = "00000000,,0,0.000000000,0.000000000,0.000000000,0.000000000,0.000,0.000,0.000,1.000000000,1.000000000,0.0,Not synchronised\n"
+	status, err = parseChronyTracking([]byte(out))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.metrics(), check.DeepEquals, map[string]Metric{
+		"ntp_offset_ms": Float(0),
+		"ntp_synced":    Int(0),
+	})
+	_, err = parseChronyTracking([]byte("506 Cannot talk to daemon\n"))
+	c.Assert(err, check.ErrorMatches, `invalid chronyc tracking output: .*`)
+}
+
+func (*S) TestParseNTPqVariables(c *check.C) {
+	out := "leap=00, stratum=2, offset=-1.234\n"
+	status, err := parseNTPqVariables([]byte(out))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.metrics(), check.DeepEquals, map[string]Metric{
+		"ntp_offset_ms": Float(-1.234),
+		"ntp_synced":    Int(1),
+		"ntp_stratum":   Int(2),
+	})
+	out = "associd=0 status=c016 leap_alarm, sync_unspec, 1 event, restart,\nleap=11, stratum=16,\noffset=0.000\n"
+	status, err = parseNTPqVariables([]byte(out))
+	c.Assert(err, check.IsNil)
+	c.Assert(status.metrics(), check.DeepEquals, map[string]Metric{
+		"ntp_offset_ms": Float(0),
+		"ntp_synced":    Int(0),
+		"ntp_stratum":   Int(16),
+	})
+	_, err = parseNTPqVariables([]byte("leap=00\n"))
+	c.Assert(err, check.ErrorMatches, "leap, stratum or offset missing in ntpq output")
+}
+
+func (*S) TestNTPCollectorChrony(c *check.C) {
+	dir, err := commandmocker.Add("chronyc", "A9FEA97B,169.254.169.123,3,1496679227.1,-0.002,0,0,0,0,0,0,0,64.3,Normal")
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	collect, err := ntpCollector("chrony")
+	c.Assert(err, check.IsNil)
+	metrics, err := collect()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"ntp_offset_ms": Float(-2),
+		"ntp_synced":    Int(1),
+		"ntp_stratum":   Int(3),
+	})
+}
+
+func (*S) TestNTPCollectorCommandFailure(c *check.C) {
+	dir, err := commandmocker.Error("ntpq", "connection refused", 1)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	collect, err := ntpCollector("ntpd")
+	c.Assert(err, check.IsNil)
+	_, err = collect()
+	c.Assert(err, check.ErrorMatches, "ntpq failed: exit status 1. Output: connection refused")
+}
+
+func (*S) TestRunCommandTimeout(c *check.C) {
+	defer func(timeout time.Duration) { commandTimeout = timeout }(commandTimeout)
+	commandTimeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := runCommand("sleep", "10")
+	c.Assert(err, check.ErrorMatches, "sleep timed out after 50ms")
+	c.Assert(time.Since(start) < 5*time.Second, check.Equals, true)
+}
+
+func (*S) TestNTPCollectorInvalidSource(c *check.C) {
+	_, err := ntpCollector("sntp")
+	c.Assert(err, check.ErrorMatches, `invalid NTP source "sntp", expected adjtimex, chrony or ntpd`)
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/cgroup"
+)
+
+// readPidsFile reads a single value file from the container pids cgroup. The
+// "max" value, meaning no limit, is returned as zero.
+func readPidsFile(h *cgroup.Hierarchy, id, name string) (uint64, error) {
+	file, err := h.OpenContainerFile("pids", id, name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// pidsMetrics returns the number of tasks, processes and threads, in the
+// container and its limit, when there is one. The number of tasks is read
+// from the container cgroup when the hierarchy is known, falling back to the
+// one reported by Docker, which doesn't include the limit.
+func (r *Reporter) pidsMetrics(id string, stats *docker.Stats) map[string]Metric {
+	if r.cgroups != nil {
+		current, err := readPidsFile(r.cgroups, id, "pids.current")
+		if err == nil {
+			metrics := map[string]Metric{"pids_current": Int(int64(current))}
+			max, err := readPidsFile(r.cgroups, id, "pids.max")
+			if err == nil && max > 0 {
+				metrics["pids_max"] = Int(int64(max))
+				metrics["pids_pct_of_max"] = Float(float64(current) / float64(max) * 100)
+			}
+			return metrics
+		}
+	}
+	if stats.PidsStats.Current == 0 {
+		return nil
+	}
+	return map[string]Metric{"pids_current": Int(int64(stats.PidsStats.Current))}
+}
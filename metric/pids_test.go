@@ -0,0 +1,70 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/cgroup"
+	"gopkg.in/check.v1"
+)
+
+// fakeCgroups creates a cgroup v1 hierarchy in a temporary directory, with
+// the files of a container using the cgroupfs driver.
+func fakeCgroups(c *check.C, id string, files map[string]string) (*cgroup.Hierarchy, func()) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	c.Assert(err, check.IsNil)
+	mountInfo := "29 24 0:25 / /sys/fs/cgroup/pids rw - cgroup cgroup rw,pids\n"
+	err = os.MkdirAll(filepath.Join(dir, "proc", "1"), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "proc", "1", "mountinfo"), []byte(mountInfo), 0644)
+	c.Assert(err, check.IsNil)
+	contDir := filepath.Join(dir, "sys", "fs", "cgroup", "pids", "docker", id)
+	err = os.MkdirAll(contDir, 0755)
+	c.Assert(err, check.IsNil)
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(contDir, name), []byte(data), 0644)
+		c.Assert(err, check.IsNil)
+	}
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", filepath.Join(dir, "proc"))
+	os.Setenv("CGROUP_MOUNT_PREFIX", dir)
+	defer func() {
+		os.Setenv("HOST_PROC", oldProc)
+		os.Unsetenv("CGROUP_MOUNT_PREFIX")
+	}()
+	h, err := cgroup.Detect()
+	c.Assert(err, check.IsNil)
+	return h, func() { os.RemoveAll(dir) }
+}
+
+func (s *S) TestPidsMetricsFromCgroup(c *check.C) {
+	h, cleanup := fakeCgroups(c, "c1", map[string]string{"pids.current": "25\n", "pids.max": "100\n"})
+	defer cleanup()
+	r := &Reporter{cgroups: h}
+	c.Assert(r.pidsMetrics("c1", &docker.Stats{}), check.DeepEquals, map[string]Metric{
+		"pids_current":    Int(25),
+		"pids_max":        Int(100),
+		"pids_pct_of_max": Float(25),
+	})
+}
+
+func (s *S) TestPidsMetricsUnlimited(c *check.C) {
+	h, cleanup := fakeCgroups(c, "c1", map[string]string{"pids.current": "3\n", "pids.max": "max\n"})
+	defer cleanup()
+	r := &Reporter{cgroups: h}
+	c.Assert(r.pidsMetrics("c1", &docker.Stats{}), check.DeepEquals, map[string]Metric{"pids_current": Int(3)})
+}
+
+func (s *S) TestPidsMetricsFromStats(c *check.C) {
+	r := &Reporter{}
+	var stats docker.Stats
+	c.Assert(r.pidsMetrics("c1", &stats), check.IsNil)
+	stats.PidsStats.Current = 7
+	c.Assert(r.pidsMetrics("c1", &stats), check.DeepEquals, map[string]Metric{"pids_current": Int(7)})
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+)
+
+// processClockTicks is the number of ticks per second of the CPU times in
+// /proc/<pid>/stat, the kernel USER_HZ. It's 100 on x86, arm and most other
+// architectures, but not on all of them, like alpha and ia64, where CPU
+// percentages would be off. It isn't read with sysconf(_SC_CLK_TCK), as that
+// requires cgo.
+const processClockTicks = 100
+
+// processNameTag is the tag holding the process name in the metrics of the
+// top processes.
+const processNameTag = "process_name"
+
+// procStat holds the usage of a process read from /proc/<pid>/stat.
+type procStat struct {
+	pid   int
+	name  string
+	ticks uint64
+	rss   uint64
+}
+
+// topProcessesCollector returns the collector of the n processes of the host
+// using the most CPU and the n processes using the most memory. The CPU usage
+// is calculated since the last collection, so it's left out of the first one.
+func topProcessesCollector(n int) func() (map[string]Metric, error) {
+	var (
+		lastTicks map[int]uint64
+		lastTime  time.Time
+	)
+	return func() (map[string]Metric, error) {
+		now := time.Now()
+		procs, err := readProcesses()
+		if err != nil {
+			return nil, err
+		}
+		metrics := topProcessMetrics(procs, lastTicks, now.Sub(lastTime), n)
+		lastTicks = make(map[int]uint64, len(procs))
+		for _, p := range procs {
+			lastTicks[p.pid] = p.ticks
+		}
+		lastTime = now
+		return metrics, nil
+	}
+}
+
+// readProcesses reads the usage of every process of the host. Processes that
+// exit while being read, or whose stat can't be parsed, are skipped.
+func readProcesses() ([]procStat, error) {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	dirs, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(os.Getpagesize())
+	var procs []procStat
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil || !dir.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(procPath, dir.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		p, err := parseProcStat(data, pageSize)
+		if err != nil {
+			bslog.Debugf("[top processes] unable to parse stat of process %d: %s", pid, err)
+			continue
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat. The name of the
+// process is enclosed in parentheses and may contain spaces and parentheses
+// itself, so the other fields are read after the last closing parenthesis.
+func parseProcStat(data []byte, pageSize uint64) (procStat, error) {
+	start := bytes.IndexByte(data, '(')
+	end := bytes.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("invalid stat %q", data)
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data[:start])))
+	if err != nil {
+		return procStat{}, err
+	}
+	// Fields after the name, starting from the state, the third field.
+	fields := bytes.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("invalid stat %q", data)
+	}
+	var values [3]uint64
+	for i, field := range [][]byte{fields[11], fields[12], fields[21]} {
+		values[i], err = strconv.ParseUint(string(field), 10, 64)
+		if err != nil {
+			return procStat{}, err
+		}
+	}
+	return procStat{
+		pid:   pid,
+		name:  string(data[start+1 : end]),
+		ticks: values[0] + values[1],
+		rss:   values[2] * pageSize,
+	}, nil
+}
+
+// topProcessMetrics returns the CPU percentage of the n processes using the
+// most CPU since the last reading and the resident memory of the n processes
+// using the most memory. Metrics are named after the rank of the process,
+// like process_1_cpu_pct and process_1_mem_rss, so the number of metrics is
+// bounded, with the pid of the process in process_1_cpu_pid and
+// process_1_mem_pid. The usage metrics are tagged with the name of the
+// process, as process_name.
+func topProcessMetrics(procs []procStat, lastTicks map[int]uint64, elapsed time.Duration, n int) map[string]Metric {
+	metrics := make(map[string]Metric)
+	type usage struct {
+		proc procStat
+		pct  float64
+	}
+	var cpu []usage
+	if lastTicks != nil && elapsed > 0 {
+		for _, p := range procs {
+			last, ok := lastTicks[p.pid]
+			if !ok || p.ticks < last {
+				continue
+			}
+			pct := float64(p.ticks-last) / processClockTicks / elapsed.Seconds() * 100
+			cpu = append(cpu, usage{proc: p, pct: pct})
+		}
+	}
+	sort.Slice(cpu, func(i, j int) bool { return cpu[i].pct > cpu[j].pct })
+	for i := 0; i < len(cpu) && i < n; i++ {
+		prefix := "process_" + strconv.Itoa(i+1)
+		metrics[prefix+"_cpu_pct"] = Float(cpu[i].pct).WithTag(processNameTag, cpu[i].proc.name)
+		metrics[prefix+"_cpu_pid"] = Int(int64(cpu[i].proc.pid))
+	}
+	mem := append([]procStat(nil), procs...)
+	sort.Slice(mem, func(i, j int) bool { return mem[i].rss > mem[j].rss })
+	for i := 0; i < len(mem) && i < n; i++ {
+		prefix := "process_" + strconv.Itoa(i+1)
+		metrics[prefix+"_mem_rss"] = Int(int64(mem[i].rss)).WithTag(processNameTag, mem[i].name)
+		metrics[prefix+"_mem_pid"] = Int(int64(mem[i].pid))
+	}
+	return metrics
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseProcStat(c *check.C) {
+	data := "1234 (my (weird) proc) S 1 1234 1234 0 -1 4194560 2573 0 0 0 150 50 0 0 20 0 12 0 1000 123456789 300 18446744073709551615\n"
+	p, err := parseProcStat([]byte(data), 4096)
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.Equals, procStat{pid: 1234, name: "my (weird) proc", ticks: 200, rss: 300 * 4096})
+	_, err = parseProcStat([]byte("1234 (short) S 1 2"), 4096)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestTopProcessMetrics(c *check.C) {
+	procs := []procStat{
+		{pid: 1, name: "init", ticks: 100, rss: 1000},
+		{pid: 20, name: "dockerd", ticks: 1100, rss: 5000},
+		{pid: 30, name: "java", ticks: 700, rss: 9000},
+		{pid: 40, name: "new", ticks: 50, rss: 10},
+	}
+	c.Assert(topProcessMetrics(procs, nil, 0, 2), check.DeepEquals, map[string]Metric{
+		"process_1_mem_rss": Int(9000).WithTag("process_name", "java"),
+		"process_1_mem_pid": Int(30),
+		"process_2_mem_rss": Int(5000).WithTag("process_name", "dockerd"),
+		"process_2_mem_pid": Int(20),
+	})
+	last := map[int]uint64{1: 100, 20: 100, 30: 500}
+	c.Assert(topProcessMetrics(procs, last, 10*time.Second, 2), check.DeepEquals, map[string]Metric{
+		"process_1_cpu_pct": Float(100).WithTag("process_name", "dockerd"),
+		"process_1_cpu_pid": Int(20),
+		"process_2_cpu_pct": Float(20).WithTag("process_name", "java"),
+		"process_2_cpu_pid": Int(30),
+		"process_1_mem_rss": Int(9000).WithTag("process_name", "java"),
+		"process_1_mem_pid": Int(30),
+		"process_2_mem_rss": Int(5000).WithTag("process_name", "dockerd"),
+		"process_2_mem_pid": Int(20),
+	})
+}
+
+func (s *S) TestTopProcessesCollector(c *check.C) {
+	dir, err := ioutil.TempDir("", "proc")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "42"), 0755), check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "sys"), 0755), check.IsNil)
+	stat := "42 (bs) S 1 42 42 0 -1 0 0 0 0 0 10 5 0 0 20 0 1 0 1 1 2 0\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "42", "stat"), []byte(stat), 0644), check.IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "43"), 0755), check.IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "43", "stat"), []byte("43 (broken) S 1 2\n"), 0644), check.IsNil)
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", dir)
+	defer os.Setenv("HOST_PROC", oldProc)
+	metrics, err := topProcessesCollector(5)()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"process_1_mem_rss": Int(int64(2*os.Getpagesize())).WithTag("process_name", "bs"),
+		"process_1_mem_pid": Int(42),
+	})
+}
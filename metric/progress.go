@@ -0,0 +1,88 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressBackend wraps the backend used by the runner, counting the metrics
+// sent and the ones successfully sent, and allowing the backend to be
+// replaced by a new one when it gets stuck.
+type progressBackend struct {
+	constructor func() (Backend, error)
+	mu          sync.RWMutex
+	backend     Backend
+	sent        uint64
+	succeeded   uint64
+	errMu       sync.Mutex
+	err         string
+	errTime     time.Time
+}
+
+func newProgressBackend(constructor func() (Backend, error)) (*progressBackend, error) {
+	backend, err := constructor()
+	if err != nil {
+		return nil, err
+	}
+	return &progressBackend{constructor: constructor, backend: backend}, nil
+}
+
+func (b *progressBackend) current() Backend {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.backend
+}
+
+func (b *progressBackend) count(err error) error {
+	atomic.AddUint64(&b.sent, 1)
+	if err == nil {
+		atomic.AddUint64(&b.succeeded, 1)
+		return nil
+	}
+	b.errMu.Lock()
+	b.err = err.Error()
+	b.errTime = time.Now()
+	b.errMu.Unlock()
+	return err
+}
+
+func (b *progressBackend) Send(container ContainerInfo, key string, value interface{}) error {
+	return b.count(b.current().Send(container, key, value))
+}
+
+func (b *progressBackend) SendConn(container ContainerInfo, host string) error {
+	return b.count(b.current().SendConn(container, host))
+}
+
+func (b *progressBackend) SendHost(host HostInfo, key string, value interface{}) error {
+	return b.count(b.current().SendHost(host, key, value))
+}
+
+// progress returns the number of metrics sent and successfully sent.
+func (b *progressBackend) progress() (sent, succeeded uint64) {
+	return atomic.LoadUint64(&b.sent), atomic.LoadUint64(&b.succeeded)
+}
+
+// lastError returns the last error sending metrics and when it happened.
+func (b *progressBackend) lastError() (string, time.Time) {
+	b.errMu.Lock()
+	defer b.errMu.Unlock()
+	return b.err, b.errTime
+}
+
+// restart replaces the backend with a new one.
+func (b *progressBackend) restart() error {
+	backend, err := b.constructor()
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.backend = backend
+	b.mu.Unlock()
+	return nil
+}
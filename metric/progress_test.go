@@ -0,0 +1,58 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"errors"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestProgressBackend(c *check.C) {
+	fakeBackend.reset()
+	var created int
+	b, err := newProgressBackend(func() (Backend, error) {
+		created++
+		return &fakeBackend, nil
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, 1)
+	err = b.Send(ContainerInfo{Name: "c1"}, "cpu_max", 1)
+	c.Assert(err, check.IsNil)
+	err = b.SendHost(HostInfo{Name: "host"}, "load1", 2)
+	c.Assert(err, check.IsNil)
+	fakeBackend.prepareFailure(errors.New("send error"))
+	err = b.SendConn(ContainerInfo{Name: "c1"}, "10.0.0.1:80")
+	c.Assert(err, check.ErrorMatches, "send error")
+	sent, succeeded := b.progress()
+	c.Assert(sent, check.Equals, uint64(3))
+	c.Assert(succeeded, check.Equals, uint64(2))
+	c.Assert(fakeBackend.stats, check.HasLen, 2)
+	lastErr, errTime := b.lastError()
+	c.Assert(lastErr, check.Equals, "send error")
+	c.Assert(errTime.IsZero(), check.Equals, false)
+	err = b.restart()
+	c.Assert(err, check.IsNil)
+	c.Assert(created, check.Equals, 2)
+	sent, succeeded = b.progress()
+	c.Assert(sent, check.Equals, uint64(3))
+	c.Assert(succeeded, check.Equals, uint64(2))
+}
+
+func (s *S) TestProgressBackendRestartError(c *check.C) {
+	fakeBackend.reset()
+	var fail bool
+	b, err := newProgressBackend(func() (Backend, error) {
+		if fail {
+			return nil, errors.New("create error")
+		}
+		return &fakeBackend, nil
+	})
+	c.Assert(err, check.IsNil)
+	fail = true
+	err = b.restart()
+	c.Assert(err, check.ErrorMatches, "create error")
+	c.Assert(b.current(), check.Equals, Backend(&fakeBackend))
+}
@@ -9,8 +9,11 @@ import (
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/cgroup"
 	"github.com/tsuru/bs/container"
 	"github.com/tsuru/bs/node"
+	"github.com/tsuru/bs/privilege"
+	"github.com/tsuru/bs/supervisor"
 )
 
 type Reporter struct {
@@ -18,6 +21,45 @@ type Reporter struct {
 	infoClient            *container.InfoClient
 	containerSelectionEnv string
 	hostClient            *HostClient
+	hostSources           []HostMetricsSource
+	containerSources      []ContainerMetricsSource
+	nodeMetadata          *node.MetadataCache
+	cgroups               *cgroup.Hierarchy
+	mu                    sync.Mutex
+	lastThrottling        map[string]*cpuThrottling
+	appAggregates         bool
+}
+
+// HostMetricsSource provides host metrics collected by other bs components,
+// reported along with the ones collected by the HostClient.
+type HostMetricsSource interface {
+	HostMetrics() map[string]Metric
+}
+
+// ContainerMetricsSource provides container metrics collected by other bs
+// components, reported along with the ones collected from Docker for each
+// running container.
+type ContainerMetricsSource interface {
+	ContainerMetrics(id string) map[string]Metric
+}
+
+// SupervisorSource returns a source reporting the total number of panics
+// recovered by the given supervisor, which can't report them itself as this
+// package uses it.
+func SupervisorSource(s *supervisor.Supervisor) HostMetricsSource {
+	return supervisorSource{s}
+}
+
+type supervisorSource struct {
+	s *supervisor.Supervisor
+}
+
+func (s supervisorSource) HostMetrics() map[string]Metric {
+	var total uint64
+	for _, n := range s.s.Panics() {
+		total += n
+	}
+	return map[string]Metric{"goroutine_panics": Int(int64(total))}
 }
 
 func (r *Reporter) Do() {
@@ -38,17 +80,32 @@ func (r *Reporter) Do() {
 
 func (r *Reporter) getMetrics(containers []docker.APIContainers, selectionEnvs []string) {
 	var wg sync.WaitGroup
-	conns, err := conntrack()
-	if err != nil {
-		bslog.Errorf("failed to execute conntrack: %s", err)
+	var conns []conn
+	// A blocked exec is logged once at startup.
+	if privilege.Exec.Check() == nil {
+		var err error
+		conns, err = conntrack()
+		if err != nil {
+			bslog.Errorf("failed to execute conntrack: %s", err)
+		}
+	}
+	running := make(map[string]bool, len(containers))
+	var aggregator *appAggregator
+	if r.appAggregates {
+		aggregator = &appAggregator{}
 	}
 	for _, cont := range containers {
-		if cont.State != "" && cont.State != "running" {
+		status := containerStatus(cont)
+		if status == statusStopped {
 			continue
 		}
+		if status == statusRunning || status == statusUnhealthy {
+			running[cont.ID] = true
+		}
 		wg.Add(1)
-		go func(contID string) {
+		go func(contID string, status int64) {
 			defer wg.Done()
+			defer supervisor.Recover("container metrics")
 			cont, err := r.infoClient.GetContainer(contID, true, selectionEnvs)
 			if err != nil {
 				if err != container.ErrTsuruVariablesNotFound {
@@ -56,6 +113,16 @@ func (r *Reporter) getMetrics(containers []docker.APIContainers, selectionEnvs [
 				}
 				return
 			}
+			if cont.Excluded() {
+				return
+			}
+			if status != statusRunning && status != statusUnhealthy {
+				err = r.sendMetrics(cont, map[string]Metric{"status": Int(status)})
+				if err != nil {
+					bslog.Errorf("failed to send metrics for container %#v: %s", cont, err)
+				}
+				return
+			}
 			stats, err := cont.Stats()
 			if err != nil || stats == nil {
 				bslog.Errorf("cannot get stats for container %#v: %s", cont, err)
@@ -66,6 +133,24 @@ func (r *Reporter) getMetrics(containers []docker.APIContainers, selectionEnvs [
 				bslog.Errorf("failed to get metrics for container %#v: %s", cont, err)
 				return
 			}
+			for key, value := range r.throttlingMetrics(contID, stats) {
+				metrics[key] = value
+			}
+			for key, value := range r.blkioMetrics(contID, stats) {
+				metrics[key] = value
+			}
+			for key, value := range r.pidsMetrics(contID, stats) {
+				metrics[key] = value
+			}
+			for _, source := range r.containerSources {
+				for key, value := range source.ContainerMetrics(contID) {
+					metrics[key] = value
+				}
+			}
+			metrics["status"] = Int(status)
+			if aggregator != nil && cont.Identified() && cont.AppName != "" {
+				aggregator.add(cont.AppName, metrics)
+			}
 			err = r.sendMetrics(cont, metrics)
 			if err != nil {
 				bslog.Errorf("failed to send metrics for container %#v: %s", cont, err)
@@ -74,14 +159,22 @@ func (r *Reporter) getMetrics(containers []docker.APIContainers, selectionEnvs [
 			if err != nil {
 				bslog.Errorf("failed to send conn metrics for container %#v: %s", cont, err)
 			}
-		}(cont.ID)
+		}(cont.ID, status)
 	}
 	wg.Wait()
+	r.forgetThrottling(running)
+	if aggregator != nil {
+		r.sendAppAggregates(aggregator)
+	}
 }
 
-func (r *Reporter) sendMetrics(container *container.Container, metrics map[string]float) error {
+func (r *Reporter) sendMetrics(container *container.Container, metrics map[string]Metric) error {
+	return r.sendContainerMetrics(container, r.containerInfo(container), metrics)
+}
+
+func (r *Reporter) sendContainerMetrics(container *container.Container, info ContainerInfo, metrics map[string]Metric) error {
 	for key, value := range metrics {
-		err := r.backend.Send(NewContainerInfo(container), key, value)
+		err := r.backend.Send(info, key, value)
 		if err != nil {
 			bslog.Errorf("failed to send metrics for container %#v: %s", container, err)
 			return err
@@ -90,6 +183,14 @@ func (r *Reporter) sendMetrics(container *container.Container, metrics map[strin
 	return nil
 }
 
+func (r *Reporter) containerInfo(container *container.Container) ContainerInfo {
+	info := NewContainerInfo(container)
+	metadata := r.nodeMetadata.Get()
+	info.Pool = metadata.Pool
+	info.Node = metadata.Address
+	return info
+}
+
 func (r *Reporter) sendConnMetrics(container *container.Container, conns []conn) error {
 	for _, conn := range conns {
 		var value string
@@ -100,7 +201,7 @@ func (r *Reporter) sendConnMetrics(container *container.Container, conns []conn)
 			value = conn.SourceIP + ":" + conn.SourcePort
 		}
 		if value != "" {
-			err := r.backend.SendConn(NewContainerInfo(container), value)
+			err := r.backend.SendConn(r.containerInfo(container), value)
 			if err != nil {
 				bslog.Errorf("failed to send connection metrics for container %#v: %s", container, err)
 				return err
@@ -118,6 +219,15 @@ func (r *Reporter) getHostMetrics() error {
 	if err != nil {
 		return err
 	}
+	metrics = append(metrics, r.sourceMetrics()...)
+	if r.infoClient != nil {
+		imageMetrics, err := getImageMetrics(r.infoClient.GetClient())
+		if err != nil {
+			bslog.Errorf("failed to get image metrics: %s", err)
+		} else {
+			metrics = append(metrics, imageMetrics)
+		}
+	}
 	hostname, err := r.hostClient.GetHostname()
 	if err != nil {
 		return err
@@ -126,7 +236,8 @@ func (r *Reporter) getHostMetrics() error {
 	if err != nil {
 		return err
 	}
-	hostInfo := HostInfo{Name: hostname, Addrs: addrs}
+	metadata := r.nodeMetadata.Get()
+	hostInfo := HostInfo{Name: hostname, Addrs: addrs, Pool: metadata.Pool, Node: metadata.Address}
 	for _, metric := range metrics {
 		err := r.sendHostMetrics(hostInfo, metric)
 		if err != nil {
@@ -136,7 +247,15 @@ func (r *Reporter) getHostMetrics() error {
 	return nil
 }
 
-func (r *Reporter) sendHostMetrics(hostInfo HostInfo, metrics map[string]float) error {
+func (r *Reporter) sourceMetrics() []map[string]Metric {
+	var metrics []map[string]Metric
+	for _, source := range r.hostSources {
+		metrics = append(metrics, source.HostMetrics())
+	}
+	return metrics
+}
+
+func (r *Reporter) sendHostMetrics(hostInfo HostInfo, metrics map[string]Metric) error {
 	for key, value := range metrics {
 		err := r.backend.SendHost(hostInfo, key, value)
 		if err != nil {
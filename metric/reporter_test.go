@@ -7,10 +7,13 @@ package metric
 import (
 	"errors"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/supervisor"
 	"gopkg.in/check.v1"
 )
 
@@ -42,12 +45,12 @@ func (s *S) createContainer() container.Container {
 func (s *S) TestSendMetrics(c *check.C) {
 	cont := s.createContainer()
 	r := Reporter{backend: &fakeBackend}
-	metrics := map[string]float{"cpu": float(900), "mem": float(512)}
+	metrics := map[string]Metric{"cpu": Int(900), "mem": Int(512)}
 	err := r.sendMetrics(&cont, metrics)
 	c.Assert(err, check.IsNil)
 	expected := []fakeStat{
-		{app: "myapp", hostname: "afdb3737ff", process: "myprocess", key: "cpu", value: float(900)},
-		{app: "myapp", hostname: "afdb3737ff", process: "myprocess", key: "mem", value: float(512)},
+		{app: "myapp", hostname: "afdb3737ff", process: "myprocess", key: "cpu", value: Int(900)},
+		{app: "myapp", hostname: "afdb3737ff", process: "myprocess", key: "mem", value: Int(512)},
 	}
 	if fakeBackend.stats[0].key != "cpu" {
 		expected[0], expected[1] = expected[1], expected[0]
@@ -60,7 +63,7 @@ func (s *S) TestSendMetricsFailure(c *check.C) {
 	r := Reporter{backend: &fakeBackend}
 	prepErr := errors.New("something went wrong")
 	fakeBackend.prepareFailure(prepErr)
-	err := r.sendMetrics(&cont, map[string]float{"cpu": float(256)})
+	err := r.sendMetrics(&cont, map[string]Metric{"cpu": Int(256)})
 	c.Assert(err, check.Equals, prepErr)
 }
 
@@ -118,25 +121,124 @@ func (s *S) TestGetMetrics(c *check.C) {
 	r.getMetrics(containers, []string{})
 	id0 := conts[0].ID[:12]
 	id1 := conts[2].ID[:12]
+	idRestarting := conts[1].ID[:12]
 	expected := []fakeStat{
-		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_pct_max", value: float(0)},
-		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_limit", value: float(0)},
-		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "netrx", value: float(0)},
-		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "nettx", value: float(0)},
-		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "cpu_max", value: float(250)},
-		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_max", value: float(0)},
-		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "cpu_max", value: float(250)},
-		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_max", value: float(0)},
-		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_pct_max", value: float(0)},
-		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_limit", value: float(0)},
-		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "netrx", value: float(0)},
-		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "nettx", value: float(0)},
+		{container: "app", image: "tsuru/python", app: "someapp", process: "myprocess", hostname: idRestarting, key: "status", value: Int(statusRestarting)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "status", value: Int(statusRunning)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "status", value: Int(statusRunning)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_pct_max", value: Float(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_limit", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "netrx", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "nettx", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_rss", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_cache", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_mapped_file", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_pct_of_limit", value: Float(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "blkio_read_bytes", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "blkio_write_bytes", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "blkio_read_ops", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "blkio_write_ops", value: Int(0)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "cpu_max", value: Float(250)},
+		{container: "nonApp", image: "tsuru/python", hostname: id0, key: "mem_max", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "cpu_max", value: Float(250)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_max", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_pct_max", value: Float(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_limit", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "netrx", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "nettx", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_rss", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_cache", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_mapped_file", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "mem_pct_of_limit", value: Float(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "blkio_read_bytes", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "blkio_write_bytes", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "blkio_read_ops", value: Int(0)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id1, key: "blkio_write_ops", value: Int(0)},
 	}
 	sort.Sort(fakeStatList(expected))
 	sort.Sort(fakeStatList(fakeBackend.stats))
 	c.Assert(fakeBackend.stats, check.DeepEquals, expected)
 }
 
+func (s *S) TestGetMetricsAppAggregates(c *check.C) {
+	bogusContainers := s.buildContainers()
+	dockerServer, conts := s.startDockerServer(bogusContainers, nil, c)
+	s.prepareStats(dockerServer, conts)
+	client, err := container.NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	defer dockerServer.Stop()
+	r := Reporter{backend: &fakeBackend, infoClient: client, appAggregates: true}
+	containers := make([]docker.APIContainers, len(conts))
+	containers[0] = docker.APIContainers{ID: conts[0].ID}
+	containers[1] = docker.APIContainers{ID: conts[1].ID, State: "restarting"}
+	containers[2] = docker.APIContainers{ID: conts[2].ID, State: "running"}
+	r.getMetrics(containers, []string{})
+	var aggregates []fakeStat
+	for _, stat := range fakeBackend.stats {
+		if stat.aggregate {
+			aggregates = append(aggregates, stat)
+		}
+	}
+	expected := []fakeStat{
+		{app: "someapp", key: "containers", value: Int(1), aggregate: true},
+		{app: "someapp", key: "cpu_max", value: Float(250), aggregate: true},
+		{app: "someapp", key: "mem_max", value: Int(0), aggregate: true},
+		{app: "someapp", key: "netrx", value: Int(0), aggregate: true},
+		{app: "someapp", key: "nettx", value: Int(0), aggregate: true},
+	}
+	sort.Sort(fakeStatList(aggregates))
+	c.Assert(aggregates, check.DeepEquals, expected)
+}
+
+func (s *S) TestAppAggregator(c *check.C) {
+	var a appAggregator
+	a.add("app1", map[string]Metric{"cpu_max": Float(10), "mem_max": Int(100), "netrx": Int(5), "nettx": Int(7), "status": Int(1)})
+	a.add("app1", map[string]Metric{"cpu_max": Float(2.5), "mem_max": Int(50), "netrx": Int(1), "nettx": Int(1)})
+	a.add("app2", map[string]Metric{"mem_max": Int(30)})
+	c.Assert(a.apps, check.DeepEquals, map[string]map[string]Metric{
+		"app1": {"containers": Int(2), "cpu_max": Float(12.5), "mem_max": Int(150), "netrx": Int(6), "nettx": Int(8)},
+		"app2": {"containers": Int(1), "mem_max": Int(30)},
+	})
+}
+
+type fakeContainerSource map[string]map[string]Metric
+
+func (s fakeContainerSource) ContainerMetrics(id string) map[string]Metric {
+	return s[id]
+}
+
+func (s *S) TestGetMetricsContainerSources(c *check.C) {
+	bogusContainers := s.buildContainers()
+	dockerServer, conts := s.startDockerServer(bogusContainers, nil, c)
+	s.prepareStats(dockerServer, conts)
+	client, err := container.NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	defer dockerServer.Stop()
+	source := fakeContainerSource{
+		conts[1].ID: {"http_request_rate": Float(3)},
+		conts[2].ID: {"http_request_rate": Float(2), "http_5xx_rate": Float(0.5)},
+	}
+	r := Reporter{backend: &fakeBackend, infoClient: client, containerSources: []ContainerMetricsSource{source}}
+	containers := []docker.APIContainers{
+		{ID: conts[1].ID, State: "restarting"},
+		{ID: conts[2].ID, State: "running"},
+	}
+	r.getMetrics(containers, []string{})
+	var got []fakeStat
+	for _, stat := range fakeBackend.stats {
+		if strings.HasPrefix(stat.key, "http_") {
+			got = append(got, stat)
+		}
+	}
+	id := conts[2].ID[:12]
+	expected := []fakeStat{
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id, key: "http_5xx_rate", value: Float(0.5)},
+		{container: "test", image: "tsuru/python", app: "someapp", hostname: id, key: "http_request_rate", value: Float(2)},
+	}
+	sort.Sort(fakeStatList(got))
+	c.Assert(got, check.DeepEquals, expected)
+}
+
 func (s *S) TestGetMetricsEmpty(c *check.C) {
 	var containers []docker.APIContainers
 	r := &Reporter{}
@@ -145,13 +247,13 @@ func (s *S) TestGetMetricsEmpty(c *check.C) {
 
 func (s *S) TestSendHostMetrics(c *check.C) {
 	r := Reporter{backend: &fakeBackend}
-	metrics := map[string]float{"cpu": float(900), "mem": float(512)}
+	metrics := map[string]Metric{"cpu": Int(900), "mem": Int(512)}
 	hostInfo := HostInfo{Name: "hostname"}
 	err := r.sendHostMetrics(hostInfo, metrics)
 	c.Assert(err, check.IsNil)
 	expected := []fakeStat{
-		{app: "sysapp", hostname: "hostname", process: "-", key: "cpu", value: float(900)},
-		{app: "sysapp", hostname: "hostname", process: "-", key: "mem", value: float(512)},
+		{app: "sysapp", hostname: "hostname", process: "-", key: "cpu", value: Int(900)},
+		{app: "sysapp", hostname: "hostname", process: "-", key: "mem", value: Int(512)},
 	}
 	if fakeBackend.stats[0].key != "cpu" {
 		expected[0], expected[1] = expected[1], expected[0]
@@ -163,8 +265,40 @@ func (s *S) TestSendHostMetricsFailure(c *check.C) {
 	r := Reporter{backend: &fakeBackend}
 	prepErr := errors.New("something wen wrong")
 	fakeBackend.prepareFailure(prepErr)
-	metrics := map[string]float{"cpu": float(900)}
+	metrics := map[string]Metric{"cpu": Int(900)}
 	hostInfo := HostInfo{Name: "hostname"}
 	err := r.sendHostMetrics(hostInfo, metrics)
 	c.Assert(err, check.Equals, prepErr)
 }
+
+type fakeHostSource map[string]Metric
+
+func (s fakeHostSource) HostMetrics() map[string]Metric {
+	return s
+}
+
+func (s *S) TestSourceMetrics(c *check.C) {
+	r := Reporter{hostSources: []HostMetricsSource{
+		fakeHostSource{"problems": Int(1)},
+		fakeHostSource{"skew": Float(0.5), "synced": Int(0)},
+	}}
+	metrics := r.sourceMetrics()
+	c.Assert(metrics, check.DeepEquals, []map[string]Metric{
+		{"problems": Int(1)},
+		{"skew": Float(0.5), "synced": Int(0)},
+	})
+}
+
+func (s *S) TestSupervisorSource(c *check.C) {
+	sup := supervisor.New()
+	sup.MinBackoff = time.Millisecond
+	c.Assert(SupervisorSource(sup).HostMetrics(), check.DeepEquals, map[string]Metric{"goroutine_panics": Int(0)})
+	var calls int
+	sup.Run("worker", func() {
+		calls++
+		if calls < 2 {
+			panic("bad message")
+		}
+	})
+	c.Assert(SupervisorSource(sup).HostMetrics(), check.DeepEquals, map[string]Metric{"goroutine_panics": Int(1)})
+}
@@ -5,26 +5,40 @@
 package metric
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/cgroup"
+	"github.com/tsuru/bs/config"
 	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/dryrun"
+	"github.com/tsuru/bs/jitter"
+	"github.com/tsuru/bs/node"
+	"github.com/tsuru/bs/privilege"
+	"github.com/tsuru/bs/supervisor"
 )
 
 type runner struct {
-	dockerEndpoint string
-	interval       time.Duration
-	metricsBackend string
-	abort          chan struct{}
-	exit           chan struct{}
+	dockerEndpoint   string
+	interval         time.Duration
+	jitter           time.Duration
+	metricsBackend   string
+	hostSources      []HostMetricsSource
+	containerSources []ContainerMetricsSource
+	nodeMetadata     *node.MetadataCache
+	backend          *progressBackend
+	abort            chan struct{}
+	exit             chan struct{}
+	flush            chan chan struct{}
 }
 
 func NewRunner(dockerEndpoint string, interval time.Duration, metricsBackend string) *runner {
 	return &runner{
 		abort:          make(chan struct{}),
 		exit:           make(chan struct{}),
+		flush:          make(chan chan struct{}),
 		dockerEndpoint: dockerEndpoint,
 		interval:       interval,
 		metricsBackend: metricsBackend,
@@ -44,42 +58,144 @@ func (r *runner) Start() (err error) {
 	if err != nil {
 		return
 	}
-	containerSelectionEnv := os.Getenv("CONTAINER_SELECTION_ENV")
+	containerSelectionEnv := config.StringEnvOrDefault("", "CONTAINER_SELECTION_ENV")
 	constructor := backends[r.metricsBackend]
 	if constructor == nil {
 		err = fmt.Errorf("no metrics backend found with name %q", r.metricsBackend)
 		return
 	}
-	backend, err := constructor()
+	if dryrun.Enabled() {
+		constructor = func() (Backend, error) {
+			return &dryRunBackend{name: r.metricsBackend}, nil
+		}
+	}
+	backend, err := newProgressBackend(constructor)
 	if err != nil {
 		return
 	}
+	r.backend = backend
 	hostClient, err := NewHostClient()
 	if err != nil {
 		bslog.Warnf("Failed to create host client: %s", err)
 		err = nil
 	}
+	cgroups, cgroupErr := cgroup.Detect()
+	if cgroupErr != nil {
+		bslog.Warnf("Failed to detect cgroup hierarchy: %s", privilege.Cgroups.Wrap(cgroupErr))
+	}
 	reporter := &Reporter{
 		backend:               backend,
 		infoClient:            client,
 		containerSelectionEnv: containerSelectionEnv,
 		hostClient:            hostClient,
+		hostSources:           r.hostSources,
+		containerSources:      r.containerSources,
+		nodeMetadata:          r.nodeMetadata,
+		cgroups:               cgroups,
+		appAggregates:         config.BoolEnvOrDefault(false, "METRICS_APP_AGGREGATES"),
+	}
+	if config.BoolEnvOrDefault(false, "METRICS_SHORT_LIVED_CONTAINERS") {
+		watcher := newShortLivedWatcher(reporter, client, r.interval)
+		if watchErr := watcher.run(r.abort); watchErr != nil {
+			bslog.Errorf("Failed to watch short lived containers: %s", watchErr)
+		}
 	}
-	go func() {
+	supervisor.Go("metrics reporter", func() {
+		var flushed chan struct{}
 		for {
 			reporter.Do()
+			if flushed != nil {
+				close(flushed)
+				flushed = nil
+			}
 			select {
 			case <-r.abort:
 				close(r.exit)
 				return
-			case <-time.After(r.interval):
+			case <-time.After(jitter.Add(r.interval, r.jitter)):
+			case flushed = <-r.flush:
 			}
-
 		}
-	}()
+	})
 	return
 }
 
+// Progress returns the number of metrics sent to the backend and the number
+// of metrics successfully sent.
+func (r *runner) Progress() (sent, succeeded uint64) {
+	if r.backend == nil {
+		return 0, 0
+	}
+	return r.backend.progress()
+}
+
+// State returns the metrics backend, its counters and last error, for state
+// dumps.
+func (r *runner) State() map[string]interface{} {
+	state := map[string]interface{}{"backend": r.metricsBackend}
+	if r.backend == nil {
+		return state
+	}
+	state["sent"], state["succeeded"] = r.backend.progress()
+	if err, errTime := r.backend.lastError(); err != "" {
+		state["last_error"] = err
+		state["last_error_time"] = errTime
+	}
+	return state
+}
+
+// Restart replaces the metrics backend with a new one.
+func (r *runner) Restart() error {
+	if r.backend == nil {
+		return nil
+	}
+	return r.backend.restart()
+}
+
+// Flush collects and sends the metrics immediately, instead of waiting for
+// the next interval, blocking until they are sent or timeout.
+func (r *runner) Flush(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	flushed := make(chan struct{})
+	select {
+	case r.flush <- flushed:
+	case <-r.exit:
+		return errors.New("metrics runner is stopped")
+	case <-deadline:
+		return errors.New("timeout waiting for metrics to be sent")
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-deadline:
+		return errors.New("timeout waiting for metrics to be sent")
+	}
+}
+
+// AddHostMetricsSource adds a source of host metrics to be reported by the
+// runner. It must be called before Start.
+func (r *runner) AddHostMetricsSource(source HostMetricsSource) {
+	r.hostSources = append(r.hostSources, source)
+}
+
+// AddContainerMetricsSource adds a source of container metrics to be reported
+// by the runner. It must be called before Start.
+func (r *runner) AddContainerMetricsSource(source ContainerMetricsSource) {
+	r.containerSources = append(r.containerSources, source)
+}
+
+// SetNodeMetadata sets the cache used to add the node pool and address to
+// the reported metrics. It must be called before Start.
+func (r *runner) SetNodeMetadata(metadata *node.MetadataCache) {
+	r.nodeMetadata = metadata
+}
+
+// SetIntervalJitter sets the max random delay added to each interval. It must
+// be called before Start.
+func (r *runner) SetIntervalJitter(jitter time.Duration) {
+	r.jitter = jitter
+}
+
 // Stop stops the runner.
 func (r *runner) Stop() {
 	close(r.abort)
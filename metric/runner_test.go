@@ -43,7 +43,7 @@ func (s *S) TestRunner(c *check.C) {
 			image:     "tsuru/python",
 			hostname:  conts[0].ID[:12],
 			key:       "cpu_max",
-			value:     float(250),
+			value:     Float(250),
 		},
 		{
 			container: "app",
@@ -52,7 +52,7 @@ func (s *S) TestRunner(c *check.C) {
 			hostname:  conts[1].ID[:12],
 			process:   "myprocess",
 			key:       "cpu_max",
-			value:     float(250),
+			value:     Float(250),
 		},
 	}
 	if cpuStat[0].hostname != conts[0].ID[:12] {
@@ -63,6 +63,30 @@ func (s *S) TestRunner(c *check.C) {
 	}
 }
 
+func (s *S) TestRunnerFlush(c *check.C) {
+	os.Unsetenv("CONTAINER_SELECTION_ENV")
+	bogusContainers := s.buildContainers()
+	dockerServer, conts := s.startDockerServer(bogusContainers, nil, c)
+	defer dockerServer.Stop()
+	s.prepareStats(dockerServer, conts)
+	fakeBackend.reset()
+	r := NewRunner(dockerServer.URL(), time.Hour, "fake")
+	err := r.Start()
+	c.Assert(err, check.IsNil)
+	err = r.Flush(10 * time.Second)
+	c.Assert(err, check.IsNil)
+	r.Stop()
+	var cpuStats int
+	for _, stat := range fakeBackend.stats {
+		if stat.key == "cpu_max" {
+			cpuStats++
+		}
+	}
+	c.Assert(cpuStats, check.Equals, 4)
+	err = r.Flush(time.Second)
+	c.Assert(err, check.ErrorMatches, "metrics runner is stopped")
+}
+
 func (s *S) TestRunnerSelectionEnv(c *check.C) {
 	os.Setenv("CONTAINER_SELECTION_ENV", "TSURU_APPNAME")
 	defer os.Unsetenv("CONTAINER_SELECTION_ENV")
@@ -89,7 +113,7 @@ func (s *S) TestRunnerSelectionEnv(c *check.C) {
 			hostname:  conts[1].ID[:12],
 			process:   "myprocess",
 			key:       "cpu_max",
-			value:     float(250),
+			value:     Float(250),
 		},
 	}
 	c.Assert(cpuStat[0], check.DeepEquals, expected[0])
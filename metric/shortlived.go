@@ -0,0 +1,170 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/supervisor"
+)
+
+// shortLivedWatcher streams the stats of every started container during its
+// first metrics interval. Containers dying in this window would never be seen
+// by the periodic reporter, so their last stats are reported as terminated.
+type shortLivedWatcher struct {
+	reporter      *Reporter
+	client        *container.InfoClient
+	selectionEnvs []string
+	window        time.Duration
+	streamStats   func(cont *container.Container, stats chan<- *docker.Stats, done <-chan bool) error
+	abort         <-chan struct{}
+	mu            sync.Mutex
+	died          map[string]chan struct{}
+	wg            sync.WaitGroup
+}
+
+func newShortLivedWatcher(reporter *Reporter, client *container.InfoClient, window time.Duration) *shortLivedWatcher {
+	var selectionEnvs []string
+	if reporter.containerSelectionEnv != "" {
+		selectionEnvs = []string{reporter.containerSelectionEnv}
+	}
+	return &shortLivedWatcher{
+		reporter:      reporter,
+		client:        client,
+		selectionEnvs: selectionEnvs,
+		window:        window,
+		streamStats: func(cont *container.Container, stats chan<- *docker.Stats, done <-chan bool) error {
+			return cont.StreamStats(stats, done)
+		},
+		abort: make(chan struct{}),
+		died:  make(map[string]chan struct{}),
+	}
+}
+
+// run handles Docker events until abort is closed.
+func (w *shortLivedWatcher) run(abort <-chan struct{}) error {
+	listener, err := w.client.NewEventListener()
+	if err != nil {
+		return err
+	}
+	w.abort = abort
+	supervisor.Go("short lived containers watcher", func() {
+		listener.Run(abort, w.handleEvent)
+		w.wg.Wait()
+	})
+	return nil
+}
+
+func (w *shortLivedWatcher) handleEvent(ev *docker.APIEvents) {
+	if ev.Type != "" && ev.Type != "container" {
+		return
+	}
+	action, id := ev.Action, ev.Actor.ID
+	if action == "" {
+		action = ev.Status
+	}
+	if id == "" {
+		id = ev.ID
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch action {
+	case "start":
+		if _, ok := w.died[id]; ok {
+			return
+		}
+		died := make(chan struct{})
+		w.died[id] = died
+		w.wg.Add(1)
+		go w.watch(id, died)
+	case "die":
+		if died, ok := w.died[id]; ok {
+			close(died)
+			delete(w.died, id)
+		}
+	}
+}
+
+func (w *shortLivedWatcher) forget(id string, died chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.died[id] == died {
+		delete(w.died, id)
+	}
+}
+
+func (w *shortLivedWatcher) watch(id string, died chan struct{}) {
+	defer w.wg.Done()
+	defer w.forget(id, died)
+	cont, err := w.client.GetContainer(id, false, w.selectionEnvs)
+	if err != nil {
+		if err != container.ErrTsuruVariablesNotFound {
+			bslog.Errorf("cannot inspect container %q: %s", id, err)
+		}
+		return
+	}
+	if cont.Excluded() {
+		return
+	}
+	statsCh := make(chan *docker.Stats)
+	done := make(chan bool)
+	go func() {
+		err := w.streamStats(cont, statsCh, done)
+		if err != nil {
+			bslog.Debugf("stats stream for container %q finished: %s", id, err)
+		}
+	}()
+	defer func() {
+		close(done)
+		for range statsCh {
+		}
+	}()
+	var last *docker.Stats
+	stream := statsCh
+	timeout := time.After(w.window)
+	for {
+		select {
+		case stats, ok := <-stream:
+			if !ok {
+				stream = nil
+				continue
+			}
+			// Stats of exited containers are empty.
+			if stats.MemoryStats.Usage > 0 {
+				last = stats
+			}
+		case <-died:
+			if last != nil {
+				w.reporter.sendTerminated(cont, last)
+			}
+			return
+		case <-timeout:
+			return
+		case <-w.abort:
+			return
+		}
+	}
+}
+
+func (r *Reporter) sendTerminated(cont *container.Container, stats *docker.Stats) {
+	metrics, err := statsToMetricsMap(stats)
+	if err != nil {
+		bslog.Errorf("failed to get metrics for container %#v: %s", cont, err)
+		return
+	}
+	for key, value := range blkioFromStats(stats).metrics() {
+		metrics[key] = value
+	}
+	info := r.containerInfo(cont)
+	info.Terminated = true
+	err = r.sendContainerMetrics(cont, info, metrics)
+	if err != nil {
+		bslog.Errorf("failed to send metrics for container %#v: %s", cont, err)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/container"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) newTestWatcher(c *check.C, window time.Duration) (*shortLivedWatcher, []docker.Container, func()) {
+	dockerServer, conts := s.startDockerServer(s.buildContainers(), nil, c)
+	client, err := container.NewClient(dockerServer.URL())
+	c.Assert(err, check.IsNil)
+	r := &Reporter{backend: &fakeBackend, infoClient: client}
+	w := newShortLivedWatcher(r, client, window)
+	return w, conts, dockerServer.Stop
+}
+
+func (s *S) TestShortLivedWatcherReportsTerminated(c *check.C) {
+	w, conts, stop := s.newTestWatcher(c, time.Minute)
+	defer stop()
+	streaming := make(chan struct{})
+	w.streamStats = func(cont *container.Container, stats chan<- *docker.Stats, done <-chan bool) error {
+		defer close(stats)
+		running := &docker.Stats{}
+		running.MemoryStats.Usage = 1024
+		running.MemoryStats.Limit = 4096
+		stats <- running
+		stats <- &docker.Stats{}
+		close(streaming)
+		<-done
+		return nil
+	}
+	w.handleEvent(&docker.APIEvents{Type: "container", Action: "start", Actor: docker.APIActor{ID: conts[1].ID}})
+	<-streaming
+	w.handleEvent(&docker.APIEvents{Status: "die", ID: conts[1].ID})
+	w.wg.Wait()
+	c.Assert(w.died, check.HasLen, 0)
+	fakeBackend.mu.Lock()
+	defer fakeBackend.mu.Unlock()
+	c.Assert(len(fakeBackend.stats) > 0, check.Equals, true)
+	values := make(map[string]interface{})
+	for _, stat := range fakeBackend.stats {
+		c.Assert(stat.terminated, check.Equals, true)
+		c.Assert(stat.app, check.Equals, "someapp")
+		values[stat.key] = stat.value
+	}
+	c.Assert(values["mem_max"], check.Equals, Int(1024))
+	c.Assert(values["mem_pct_max"], check.Equals, Float(25))
+}
+
+func (s *S) TestShortLivedWatcherWindowExpired(c *check.C) {
+	w, conts, stop := s.newTestWatcher(c, 10*time.Millisecond)
+	defer stop()
+	w.streamStats = func(cont *container.Container, stats chan<- *docker.Stats, done <-chan bool) error {
+		defer close(stats)
+		running := &docker.Stats{}
+		running.MemoryStats.Usage = 1024
+		stats <- running
+		<-done
+		return nil
+	}
+	w.handleEvent(&docker.APIEvents{Status: "start", ID: conts[0].ID})
+	w.wg.Wait()
+	c.Assert(w.died, check.HasLen, 0)
+	w.handleEvent(&docker.APIEvents{Status: "die", ID: conts[0].ID})
+	c.Assert(fakeBackend.stats, check.HasLen, 0)
+}
+
+func (s *S) TestShortLivedWatcherIgnoresOtherEvents(c *check.C) {
+	w, conts, stop := s.newTestWatcher(c, time.Minute)
+	defer stop()
+	w.handleEvent(&docker.APIEvents{Type: "network", Action: "start", Actor: docker.APIActor{ID: conts[0].ID}})
+	w.handleEvent(&docker.APIEvents{Status: "create", ID: conts[0].ID})
+	c.Assert(w.died, check.HasLen, 0)
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/bs/cgroup"
+)
+
+// defaultSlices are the cgroups reported when METRICS_SLICES isn't set: the
+// host services and user sessions, and the containers run by Docker, with the
+// cgroupfs and systemd drivers, or by Kubernetes.
+var defaultSlices = []string{"system.slice", "user.slice", "docker", "kubepods", "kubepods.slice"}
+
+// sliceUsage holds the usage of a top level cgroup of the host.
+type sliceUsage struct {
+	memory uint64
+	cpu    time.Duration
+}
+
+// slicesCollector returns the collector of the memory and CPU used by the
+// given top level cgroups, so the usage of the host services can be told
+// apart from the usage of the workloads. Cgroups not found in the host are
+// skipped. The CPU percentage is calculated since the last collection, so
+// it's left out of the first one.
+func slicesCollector(h *cgroup.Hierarchy, slices []string) func() (map[string]Metric, error) {
+	var (
+		last     map[string]sliceUsage
+		lastTime time.Time
+	)
+	return func() (map[string]Metric, error) {
+		cpus, err := hostCPUCount()
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		current := make(map[string]sliceUsage, len(slices))
+		for _, slice := range slices {
+			usage, err := readSliceUsage(h, slice)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			current[slice] = usage
+		}
+		metrics := sliceMetrics(last, current, now.Sub(lastTime), cpus)
+		last, lastTime = current, now
+		return metrics, nil
+	}
+}
+
+// sliceMetrics returns the memory used by each cgroup, the CPU time it used,
+// in seconds, and the average percentage of each CPU it used since the last
+// reading, like slice_mem_system_slice.
+func sliceMetrics(last, current map[string]sliceUsage, elapsed time.Duration, cpus int) map[string]Metric {
+	metrics := make(map[string]Metric, len(current)*3)
+	for slice, usage := range current {
+		suffix := metricSuffix(slice)
+		metrics["slice_mem_"+suffix] = Int(int64(usage.memory))
+		metrics["slice_cpu_seconds_"+suffix] = Float(usage.cpu.Seconds())
+		previous, ok := last[slice]
+		if !ok || usage.cpu < previous.cpu || elapsed <= 0 {
+			continue
+		}
+		pct := (usage.cpu - previous.cpu).Seconds() / (elapsed.Seconds() * float64(cpus)) * 100
+		metrics["slice_cpu_pct_"+suffix] = Float(pct)
+	}
+	return metrics
+}
+
+// readSliceUsage reads the memory and CPU usage of a top level cgroup from
+// the cgroup v2 hierarchy, in unified mode, or from the memory and cpuacct
+// cgroup v1 controllers.
+func readSliceUsage(h *cgroup.Hierarchy, slice string) (sliceUsage, error) {
+	if h.Mode == cgroup.ModeUnified {
+		dir := filepath.Join(h.MountPoint("memory"), slice)
+		memory, err := readCgroupUint(filepath.Join(dir, "memory.current"))
+		if err != nil {
+			return sliceUsage{}, err
+		}
+		usec, err := readCPUStatUsage(filepath.Join(dir, "cpu.stat"))
+		if err != nil {
+			return sliceUsage{}, err
+		}
+		return sliceUsage{memory: memory, cpu: time.Duration(usec) * time.Microsecond}, nil
+	}
+	memoryMount, cpuMount := h.MountPoint("memory"), h.MountPoint("cpuacct")
+	if memoryMount == "" || cpuMount == "" {
+		return sliceUsage{}, fmt.Errorf("memory and cpuacct cgroup controllers are required")
+	}
+	memory, err := readCgroupUint(filepath.Join(memoryMount, slice, "memory.usage_in_bytes"))
+	if err != nil {
+		return sliceUsage{}, err
+	}
+	nsec, err := readCgroupUint(filepath.Join(cpuMount, slice, "cpuacct.usage"))
+	if err != nil {
+		return sliceUsage{}, err
+	}
+	return sliceUsage{memory: memory, cpu: time.Duration(nsec)}, nil
+}
+
+// readCgroupUint reads a cgroup file holding a single number.
+func readCgroupUint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readCPUStatUsage reads the CPU time, in microseconds, from a cgroup v2
+// cpu.stat file.
+func readCPUStatUsage(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) == 2 && parts[0] == "usage_usec" {
+			return strconv.ParseUint(parts[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("usage_usec not found in %q", path)
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tsuru/bs/cgroup"
+	"gopkg.in/check.v1"
+)
+
+// fakeUnifiedSlices creates a cgroup v2 hierarchy in a temporary directory,
+// with the given files of each top level cgroup.
+func fakeUnifiedSlices(c *check.C, slices map[string]map[string]string) (*cgroup.Hierarchy, func()) {
+	dir, err := ioutil.TempDir("", "cgroup")
+	c.Assert(err, check.IsNil)
+	mountInfo := "29 24 0:25 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n"
+	err = os.MkdirAll(filepath.Join(dir, "proc", "1"), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "proc", "1", "mountinfo"), []byte(mountInfo), 0644)
+	c.Assert(err, check.IsNil)
+	for slice, files := range slices {
+		sliceDir := filepath.Join(dir, "sys", "fs", "cgroup", slice)
+		err = os.MkdirAll(sliceDir, 0755)
+		c.Assert(err, check.IsNil)
+		for name, data := range files {
+			err = ioutil.WriteFile(filepath.Join(sliceDir, name), []byte(data), 0644)
+			c.Assert(err, check.IsNil)
+		}
+	}
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", filepath.Join(dir, "proc"))
+	os.Setenv("CGROUP_MOUNT_PREFIX", dir)
+	defer func() {
+		os.Setenv("HOST_PROC", oldProc)
+		os.Unsetenv("CGROUP_MOUNT_PREFIX")
+	}()
+	h, err := cgroup.Detect()
+	c.Assert(err, check.IsNil)
+	return h, func() { os.RemoveAll(dir) }
+}
+
+func (s *S) TestReadSliceUsage(c *check.C) {
+	h, cleanup := fakeUnifiedSlices(c, map[string]map[string]string{
+		"system.slice": {
+			"memory.current": "1048576\n",
+			"cpu.stat":       "usage_usec 2500000\nuser_usec 2000000\nsystem_usec 500000\n",
+		},
+	})
+	defer cleanup()
+	usage, err := readSliceUsage(h, "system.slice")
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.Equals, sliceUsage{memory: 1048576, cpu: 2500 * time.Millisecond})
+	_, err = readSliceUsage(h, "user.slice")
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	metrics, err := slicesCollector(h, []string{"system.slice", "user.slice"})()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"slice_mem_system_slice":         Int(1048576),
+		"slice_cpu_seconds_system_slice": Float(2.5),
+	})
+}
+
+func (s *S) TestSliceMetrics(c *check.C) {
+	last := map[string]sliceUsage{
+		"system.slice": {memory: 100, cpu: 10 * time.Second},
+		"docker":       {memory: 500, cpu: 50 * time.Second},
+	}
+	current := map[string]sliceUsage{
+		"system.slice": {memory: 200, cpu: 14 * time.Second},
+		"docker":       {memory: 800, cpu: 5 * time.Second},
+		"kubepods":     {memory: 300, cpu: time.Second},
+	}
+	c.Assert(sliceMetrics(last, current, 10*time.Second, 2), check.DeepEquals, map[string]Metric{
+		"slice_mem_system_slice":         Int(200),
+		"slice_cpu_seconds_system_slice": Float(14),
+		"slice_cpu_pct_system_slice":     Float(20),
+		"slice_mem_docker":               Int(800),
+		"slice_cpu_seconds_docker":       Float(5),
+		"slice_mem_kubepods":             Int(300),
+		"slice_cpu_seconds_kubepods":     Float(1),
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// smartctl exit status bits meaning the command line couldn't be parsed or
+// the device couldn't be opened. The other bits report the disk health, with
+// the JSON output still available.
+const smartctlFatalStatus = 0x3
+
+// smartctlTimeout is how long each smartctl run may take before being
+// killed, as disks failing to answer may hang it. Overridden by tests.
+var smartctlTimeout = 30 * time.Second
+
+// ATA attributes reporting the reallocated sectors and the remaining life of
+// SSDs.
+const (
+	ataReallocatedSectors = 5
+	ataWearLeveling       = 177
+	ataMediaWearout       = 233
+	ataSSDLifeLeft        = 231
+)
+
+type smartctlDevice struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type smartctlAttribute struct {
+	ID    int `json:"id"`
+	Value int `json:"value"`
+	Raw   struct {
+		Value float64 `json:"value"`
+	} `json:"raw"`
+}
+
+type smartctlOutput struct {
+	Devices     []smartctlDevice `json:"devices"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATAAttributes struct {
+		Table []smartctlAttribute `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeHealth *struct {
+		PercentageUsed float64 `json:"percentage_used"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// smartCollector collects the SMART health of every disk found by smartctl.
+// It requires smartctl 7.0 or newer, for the JSON output, and access to the
+// host devices.
+func smartCollector() (map[string]Metric, error) {
+	var scan smartctlOutput
+	if err := runSmartctl(&scan, "--scan"); err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]Metric)
+	for _, device := range scan.Devices {
+		var out smartctlOutput
+		if err := runSmartctl(&out, "-H", "-A", "-d", device.Type, device.Name); err != nil {
+			return nil, fmt.Errorf("unable to read SMART data of %s: %s", device.Name, err)
+		}
+		suffix := metricSuffix(strings.TrimPrefix(device.Name, "/dev/"))
+		for name, value := range out.metrics() {
+			metrics[name+"_"+suffix] = value
+		}
+	}
+	return metrics, nil
+}
+
+// metrics returns the overall health of a device, 1 when the self assessment
+// passed and 0 otherwise, the number of reallocated sectors of ATA disks and
+// the percentage of the life of SSDs used.
+func (o *smartctlOutput) metrics() map[string]Metric {
+	metrics := make(map[string]Metric)
+	if o.SmartStatus != nil {
+		metrics["smart_healthy"] = Int(0)
+		if o.SmartStatus.Passed {
+			metrics["smart_healthy"] = Int(1)
+		}
+	}
+	for _, attr := range o.ATAAttributes.Table {
+		switch attr.ID {
+		case ataReallocatedSectors:
+			metrics["smart_reallocated_sectors"] = Int(int64(attr.Raw.Value))
+		case ataWearLeveling, ataMediaWearout, ataSSDLifeLeft:
+			// The normalized value starts at 100 and decreases as
+			// the disk wears out.
+			metrics["smart_wear_level_pct"] = Float(float64(100 - attr.Value))
+		}
+	}
+	if o.NVMeHealth != nil {
+		metrics["smart_wear_level_pct"] = Float(o.NVMeHealth.PercentageUsed)
+	}
+	return metrics
+}
+
+func runSmartctl(v interface{}, args ...string) error {
+	var stdout, stderr bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), smartctlTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "smartctl", append([]string{"-j"}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("smartctl timed out after %s", smartctlTimeout)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && !smartctlFatal(exitErr) {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("smartctl failed: %s. Output: %s", err, strings.TrimSpace(stdout.String()+stderr.String()))
+	}
+	if err = json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("invalid smartctl output: %s", err)
+	}
+	return nil
+}
+
+func smartctlFatal(err *exec.ExitError) bool {
+	status, ok := err.Sys().(syscall.WaitStatus)
+	return !ok || status.ExitStatus()&smartctlFatalStatus != 0
+}
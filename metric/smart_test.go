@@ -0,0 +1,100 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tsuru/commandmocker"
+	"gopkg.in/check.v1"
+)
+
+const smartctlATAOutput = `{
+  "smart_status": {"passed": true},
+  "ata_smart_attributes": {
+    "table": [
+      {"id": 5, "name": "Reallocated_Sector_Ct", "value": 100, "raw": {"value": 8}},
+      {"id": 9, "name": "Power_On_Hours", "value": 95, "raw": {"value": 21000}},
+      {"id": 177, "name": "Wear_Leveling_Count", "value": 92, "raw": {"value": 150}}
+    ]
+  }
+}`
+
+const smartctlNVMeOutput = `{
+  "smart_status": {"passed": false},
+  "nvme_smart_health_information_log": {"percentage_used": 12, "media_errors": 0}
+}`
+
+func (*S) TestSmartctlOutputMetrics(c *check.C) {
+	var out smartctlOutput
+	err := json.Unmarshal([]byte(smartctlATAOutput), &out)
+	c.Assert(err, check.IsNil)
+	c.Assert(out.metrics(), check.DeepEquals, map[string]Metric{
+		"smart_healthy":             Int(1),
+		"smart_reallocated_sectors": Int(8),
+		"smart_wear_level_pct":      Float(8),
+	})
+	out = smartctlOutput{}
+	err = json.Unmarshal([]byte(smartctlNVMeOutput), &out)
+	c.Assert(err, check.IsNil)
+	c.Assert(out.metrics(), check.DeepEquals, map[string]Metric{
+		"smart_healthy":        Int(0),
+		"smart_wear_level_pct": Float(12),
+	})
+	out = smartctlOutput{}
+	c.Assert(out.metrics(), check.DeepEquals, map[string]Metric{})
+}
+
+func (*S) TestSmartCollector(c *check.C) {
+	// The same output is returned for the scan and for the device, the
+	// fields of one are ignored when reading the other.
+	output := `{"devices": [{"name": "/dev/sda", "type": "sat"}], "smart_status": {"passed": true},
+"ata_smart_attributes": {"table": [{"id": 5, "value": 100, "raw": {"value": 0}}]}}`
+	dir, err := commandmocker.Add("smartctl", output)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	metrics, err := smartCollector()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"smart_healthy_sda":             Int(1),
+		"smart_reallocated_sectors_sda": Int(0),
+	})
+	c.Assert(commandmocker.Parameters(dir), check.DeepEquals, []string{
+		"-j", "--scan",
+		"-j", "-H", "-A", "-d", "sat", "/dev/sda",
+	})
+}
+
+func (*S) TestSmartCollectorFailure(c *check.C) {
+	dir, err := commandmocker.Error("smartctl", "Smartctl open device: /dev/sda failed: Permission denied", 2)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	_, err = smartCollector()
+	c.Assert(err, check.ErrorMatches, "smartctl failed: exit status 2. Output: Smartctl open device: /dev/sda failed: Permission denied")
+}
+
+func (*S) TestSmartCollectorNonFatalStatus(c *check.C) {
+	dir, err := commandmocker.Error("smartctl", "", 4)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	_, err = smartCollector()
+	c.Assert(err, check.ErrorMatches, "invalid smartctl output: .*")
+}
+
+func (*S) TestSmartCollectorTimeout(c *check.C) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "smartctl"), []byte("#!/bin/sh\nexec sleep 10\n"), 0755)
+	c.Assert(err, check.IsNil)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	defer func(timeout time.Duration) { smartctlTimeout = timeout }(smartctlTimeout)
+	smartctlTimeout = 50 * time.Millisecond
+	_, err = smartCollector()
+	c.Assert(err, check.ErrorMatches, "smartctl timed out after 50ms")
+}
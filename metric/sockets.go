@@ -0,0 +1,85 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+)
+
+// Socket states in /proc/net: TCP sockets in the LISTEN state and UDP
+// sockets bound without a remote address, which are reported as closed.
+const (
+	tcpListenState = "0A"
+	udpBoundState  = "07"
+)
+
+// getHostListeningSockets returns the number of TCP and UDP sockets
+// listening in the host, counted in the network namespace of pid 1, which is
+// the one of the host even when bs doesn't run in the host network.
+func (h *HostClient) getHostListeningSockets() (map[string]Metric, error) {
+	netPath := hostNetPath()
+	stats := map[string]Metric{}
+	for _, proto := range []struct {
+		files []string
+		state string
+		name  string
+	}{
+		{files: []string{"tcp", "tcp6"}, state: tcpListenState, name: "listening_sockets_tcp"},
+		{files: []string{"udp", "udp6"}, state: udpBoundState, name: "listening_sockets_udp"},
+	} {
+		var total int
+		for _, name := range proto.files {
+			file, err := os.Open(filepath.Join(netPath, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					// IPv6 disabled or no /proc at all.
+					continue
+				}
+				return nil, err
+			}
+			count, err := countSockets(file, proto.state)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			total += count
+		}
+		stats[proto.name] = Int(int64(total))
+	}
+	return stats, nil
+}
+
+// hostNetPath returns the path of the network information of pid 1 in the
+// proc filesystem, falling back to the one of bs when it's not available.
+func hostNetPath() string {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	netPath := filepath.Join(procPath, "1", "net")
+	if _, err := os.Stat(netPath); err != nil {
+		netPath = filepath.Join(procPath, "net")
+	}
+	return netPath
+}
+
+// countSockets counts the sockets in the given state in a /proc/net/tcp
+// like file.
+func countSockets(r io.Reader, state string) (int, error) {
+	var count int
+	scanner := bufio.NewScanner(r)
+	// Skip the header.
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 3 && fields[3] == state {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
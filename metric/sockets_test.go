@@ -0,0 +1,60 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/check.v1"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 11111 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 22222 1 0000000000000000 100 0 0 10 0
+   2: 0A000002:0016 0A000001:C35A 01 00000000:00000000 02:00089B5A 00000000     0        0 33333 4 0000000000000000 20 4 29 10 -1
+`
+
+const procNetUDP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 44444 2 0000000000000000 0
+  101: 0A000002:D3C1 08080808:0035 01 00000000:00000000 00:00000000 00000000     0        0 55555 2 0000000000000000 0
+`
+
+func (*S) TestCountSockets(c *check.C) {
+	count, err := countSockets(strings.NewReader(procNetTCP), tcpListenState)
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 2)
+	count, err = countSockets(strings.NewReader(procNetUDP), udpBoundState)
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 1)
+	count, err = countSockets(strings.NewReader(""), tcpListenState)
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (*S) TestGetHostListeningSockets(c *check.C) {
+	dir, err := ioutil.TempDir("", "sockets")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", dir)
+	defer os.Setenv("HOST_PROC", oldProc)
+	netPath := filepath.Join(dir, "1", "net")
+	err = os.MkdirAll(netPath, 0755)
+	c.Assert(err, check.IsNil)
+	for name, content := range map[string]string{"tcp": procNetTCP, "tcp6": procNetTCP, "udp": procNetUDP} {
+		err = ioutil.WriteFile(filepath.Join(netPath, name), []byte(content), 0644)
+		c.Assert(err, check.IsNil)
+	}
+	var h HostClient
+	metrics, err := h.getHostListeningSockets()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"listening_sockets_tcp": Int(4),
+		"listening_sockets_udp": Int(1),
+	})
+}
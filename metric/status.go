@@ -0,0 +1,38 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Values of the container status metric.
+const (
+	statusStopped    int64 = 0
+	statusRunning    int64 = 1
+	statusPaused     int64 = 2
+	statusRestarting int64 = 3
+	statusUnhealthy  int64 = 4
+)
+
+// containerStatus returns the numeric status of a listed container. The
+// state is missing in old Docker versions, which only list running
+// containers by default, so those are considered running unless the status
+// text says otherwise.
+func containerStatus(cont docker.APIContainers) int64 {
+	switch {
+	case cont.State == "paused" || strings.HasSuffix(cont.Status, "(Paused)"):
+		return statusPaused
+	case cont.State == "restarting" || strings.HasPrefix(cont.Status, "Restarting"):
+		return statusRestarting
+	case strings.Contains(cont.Status, "(unhealthy)"):
+		return statusUnhealthy
+	case cont.State == "running" || cont.State == "":
+		return statusRunning
+	}
+	return statusStopped
+}
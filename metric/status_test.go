@@ -0,0 +1,30 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestContainerStatus(c *check.C) {
+	tests := []struct {
+		cont     docker.APIContainers
+		expected int64
+	}{
+		{docker.APIContainers{State: "running", Status: "Up 5 minutes"}, statusRunning},
+		{docker.APIContainers{State: "running", Status: "Up 5 minutes (healthy)"}, statusRunning},
+		{docker.APIContainers{State: "running", Status: "Up 5 minutes (unhealthy)"}, statusUnhealthy},
+		{docker.APIContainers{State: "paused", Status: "Up 5 minutes (Paused)"}, statusPaused},
+		{docker.APIContainers{State: "restarting", Status: "Restarting (1) 2 seconds ago"}, statusRestarting},
+		{docker.APIContainers{State: "exited", Status: "Exited (0) 2 seconds ago"}, statusStopped},
+		{docker.APIContainers{Status: "Up 5 minutes"}, statusRunning},
+		{docker.APIContainers{Status: "Up 5 minutes (Paused)"}, statusPaused},
+		{docker.APIContainers{}, statusRunning},
+	}
+	for _, tt := range tests {
+		c.Assert(containerStatus(tt.cont), check.Equals, tt.expected, check.Commentf("%#v", tt.cont))
+	}
+}
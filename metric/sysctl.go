@@ -0,0 +1,89 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/bs/config"
+)
+
+// sysctlCheck is a kernel parameter to report and, when expected is set, the
+// value it should have.
+type sysctlCheck struct {
+	name        string
+	expected    int64
+	hasExpected bool
+}
+
+// parseSysctlChecks parses the kernel parameters set in METRICS_SYSCTLS, each
+// one a name optionally followed by = and its expected value, like
+// net.core.somaxconn=4096.
+func parseSysctlChecks(entries []string) ([]sysctlCheck, error) {
+	checks := make([]sysctlCheck, 0, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		check := sysctlCheck{name: strings.TrimSpace(parts[0])}
+		if check.name == "" || strings.Contains(check.name, "..") {
+			return nil, fmt.Errorf("invalid sysctl %q", entry)
+		}
+		if len(parts) == 2 {
+			expected, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expected value of sysctl %q: %q", check.name, parts[1])
+			}
+			check.expected, check.hasExpected = expected, true
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// sysctlCollector returns the collector of the given kernel parameters, read
+// from /proc/sys. Each value is reported in a metric like
+// sysctl_net_core_somaxconn and, for parameters with an expected value, a
+// metric like sysctl_drift_net_core_somaxconn is 1 when the value differs
+// from the expected one. sysctl_drift is the number of parameters differing
+// from their expected values. Only parameters holding a single integer are
+// supported.
+func sysctlCollector(checks []sysctlCheck) func() (map[string]Metric, error) {
+	return func() (map[string]Metric, error) {
+		procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+		metrics := map[string]Metric{}
+		var drift int64
+		for _, check := range checks {
+			path := filepath.Join(procPath, "sys", filepath.FromSlash(strings.Replace(check.name, ".", "/", -1)))
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			value, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse sysctl %s: %s", check.name, err)
+			}
+			suffix := metricSuffix(check.name)
+			metrics["sysctl_"+suffix] = Int(value)
+			if !check.hasExpected {
+				continue
+			}
+			var drifted int64
+			if value != check.expected {
+				drifted = 1
+			}
+			drift += drifted
+			metrics["sysctl_drift_"+suffix] = Int(drifted)
+		}
+		metrics["sysctl_drift"] = Int(drift)
+		return metrics, nil
+	}
+}
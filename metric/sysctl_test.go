@@ -0,0 +1,65 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseSysctlChecks(c *check.C) {
+	checks, err := parseSysctlChecks([]string{"net.core.somaxconn=4096", "vm.max_map_count"})
+	c.Assert(err, check.IsNil)
+	c.Assert(checks, check.DeepEquals, []sysctlCheck{
+		{name: "net.core.somaxconn", expected: 4096, hasExpected: true},
+		{name: "vm.max_map_count"},
+	})
+	_, err = parseSysctlChecks([]string{"net.core.somaxconn=many"})
+	c.Assert(err, check.NotNil)
+	_, err = parseSysctlChecks([]string{"=1"})
+	c.Assert(err, check.NotNil)
+}
+
+func (s *S) TestSysctlCollector(c *check.C) {
+	dir, err := ioutil.TempDir("", "sysctl")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	for name, value := range map[string]string{
+		"net/core/somaxconn":      "128\n",
+		"net/ipv4/ip_forward":     "1\n",
+		"net/ipv4/tcp_tw_reuse":   "2\n",
+		"vm/max_map_count":        "262144\n",
+		"net/ipv4/tcp_rmem":       "4096\t87380\t6291456\n",
+		"kernel/unused_parameter": "0\n",
+	} {
+		path := filepath.Join(dir, "sys", filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(path), 0755), check.IsNil)
+		c.Assert(ioutil.WriteFile(path, []byte(value), 0644), check.IsNil)
+	}
+	oldProc := os.Getenv("HOST_PROC")
+	os.Setenv("HOST_PROC", dir)
+	defer os.Setenv("HOST_PROC", oldProc)
+	checks, err := parseSysctlChecks([]string{"net.core.somaxconn=4096", "net.ipv4.ip_forward=1", "net.ipv4.tcp_tw_reuse", "vm.max_map_count=262144", "net.missing=1"})
+	c.Assert(err, check.IsNil)
+	metrics, err := sysctlCollector(checks)()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"sysctl_net_core_somaxconn":        Int(128),
+		"sysctl_drift_net_core_somaxconn":  Int(1),
+		"sysctl_net_ipv4_ip_forward":       Int(1),
+		"sysctl_drift_net_ipv4_ip_forward": Int(0),
+		"sysctl_net_ipv4_tcp_tw_reuse":     Int(2),
+		"sysctl_vm_max_map_count":          Int(262144),
+		"sysctl_drift_vm_max_map_count":    Int(0),
+		"sysctl_drift":                     Int(1),
+	})
+	checks, err = parseSysctlChecks([]string{"net.ipv4.tcp_rmem"})
+	c.Assert(err, check.IsNil)
+	_, err = sysctlCollector(checks)()
+	c.Assert(err, check.NotNil)
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultSystemdUnits are the units checked when METRICS_SYSTEMD_UNITS isn't
+// set.
+var defaultSystemdUnits = []string{"docker.service", "containerd.service"}
+
+// systemdCollector returns the collector of the active state of the given
+// systemd units, queried over D-Bus with busctl, so the system bus socket must
+// be reachable from bs.
+func systemdCollector(units []string) func() (map[string]Metric, error) {
+	return func() (map[string]Metric, error) {
+		metrics := make(map[string]Metric, len(units))
+		for _, unit := range units {
+			out, err := runCommand("busctl", "--system", "get-property", "org.freedesktop.systemd1",
+				systemdUnitPath(unit), "org.freedesktop.systemd1.Unit", "ActiveState")
+			if err != nil {
+				return nil, err
+			}
+			state, err := parseBusctlString(out)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read state of unit %s: %s", unit, err)
+			}
+			var active int64
+			if state == "active" || state == "reloading" {
+				active = 1
+			}
+			metrics[systemdMetricName(unit)] = Int(active)
+		}
+		return metrics, nil
+	}
+}
+
+// systemdUnitPath returns the D-Bus object path of a unit, escaping every
+// byte not allowed in object paths as systemd does.
+func systemdUnitPath(unit string) string {
+	var escaped bytes.Buffer
+	for i := 0; i < len(unit); i++ {
+		c := unit[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "_%02x", c)
+		}
+	}
+	if escaped.Len() == 0 {
+		escaped.WriteByte('_')
+	}
+	return "/org/freedesktop/systemd1/unit/" + escaped.String()
+}
+
+// systemdMetricName returns the name of the active metric of a unit, like
+// systemd_active_docker_service for docker.service.
+func systemdMetricName(unit string) string {
+	return "systemd_active_" + metricSuffix(unit)
+}
+
+// metricSuffix replaces the characters other than letters and digits in the
+// name of a host resource with underscores, so it can be appended to the
+// name of a metric.
+func metricSuffix(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// parseBusctlString parses a string property printed by busctl, like
+// `s "active"`.
+func parseBusctlString(out []byte) (string, error) {
+	value := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(value, "s ") {
+		return "", fmt.Errorf("unexpected busctl output: %q", out)
+	}
+	return strconv.Unquote(strings.TrimPrefix(value, "s "))
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"github.com/tsuru/commandmocker"
+	"gopkg.in/check.v1"
+)
+
+func (*S) TestSystemdUnitPath(c *check.C) {
+	c.Assert(systemdUnitPath("docker.service"), check.Equals, "/org/freedesktop/systemd1/unit/docker_2eservice")
+	c.Assert(systemdUnitPath("user@1000.service"), check.Equals, "/org/freedesktop/systemd1/unit/user_401000_2eservice")
+	c.Assert(systemdUnitPath(""), check.Equals, "/org/freedesktop/systemd1/unit/_")
+}
+
+func (*S) TestSystemdMetricName(c *check.C) {
+	c.Assert(systemdMetricName("docker.service"), check.Equals, "systemd_active_docker_service")
+	c.Assert(systemdMetricName("kubelet-proxy.service"), check.Equals, "systemd_active_kubelet_proxy_service")
+}
+
+func (*S) TestParseBusctlString(c *check.C) {
+	state, err := parseBusctlString([]byte("s \"active\"\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(state, check.Equals, "active")
+	_, err = parseBusctlString([]byte("u 1\n"))
+	c.Assert(err, check.ErrorMatches, `unexpected busctl output: "u 1\\n"`)
+}
+
+func (*S) TestSystemdCollector(c *check.C) {
+	dir, err := commandmocker.Add("busctl", `s "active"`)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	metrics, err := systemdCollector(defaultSystemdUnits)()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{
+		"systemd_active_docker_service":     Int(1),
+		"systemd_active_containerd_service": Int(1),
+	})
+	c.Assert(commandmocker.Parameters(dir), check.DeepEquals, []string{
+		"--system", "get-property", "org.freedesktop.systemd1", "/org/freedesktop/systemd1/unit/docker_2eservice", "org.freedesktop.systemd1.Unit", "ActiveState",
+		"--system", "get-property", "org.freedesktop.systemd1", "/org/freedesktop/systemd1/unit/containerd_2eservice", "org.freedesktop.systemd1.Unit", "ActiveState",
+	})
+}
+
+func (*S) TestSystemdCollectorInactive(c *check.C) {
+	dir, err := commandmocker.Add("busctl", `s "failed"`)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	metrics, err := systemdCollector([]string{"docker.service"})()
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, map[string]Metric{"systemd_active_docker_service": Int(0)})
+}
+
+func (*S) TestSystemdCollectorBusError(c *check.C) {
+	dir, err := commandmocker.Error("busctl", "Failed to connect to bus: No such file or directory", 1)
+	c.Assert(err, check.IsNil)
+	defer commandmocker.Remove(dir)
+	_, err = systemdCollector([]string{"docker.service"})()
+	c.Assert(err, check.ErrorMatches, "busctl failed: exit status 1. Output: Failed to connect to bus: No such file or directory")
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/cgroup"
+)
+
+// cpuThrottling holds the cumulative CFS throttling counters of a container.
+type cpuThrottling struct {
+	periods       uint64
+	throttled     uint64
+	throttledTime time.Duration
+}
+
+// readCPUThrottling reads the throttling counters from the cpu.stat file in
+// the container cgroup, supporting both cgroup v1 and v2 formats.
+func readCPUThrottling(h *cgroup.Hierarchy, id string) (*cpuThrottling, error) {
+	file, err := h.OpenContainerFile("cpu", id, "cpu.stat")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseCPUStat(file)
+}
+
+func parseCPUStat(r io.Reader) (*cpuThrottling, error) {
+	var t cpuThrottling
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		switch parts[0] {
+		case "nr_periods":
+			t.periods = value
+		case "nr_throttled":
+			t.throttled = value
+		case "throttled_time":
+			t.throttledTime = time.Duration(value)
+		case "throttled_usec":
+			t.throttledTime = time.Duration(value) * time.Microsecond
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// containerThrottling returns the throttling counters of the container,
+// read from its cgroup when the hierarchy is known, falling back to the
+// ones reported by Docker.
+func (r *Reporter) containerThrottling(id string, stats *docker.Stats) *cpuThrottling {
+	if r.cgroups != nil {
+		t, err := readCPUThrottling(r.cgroups, id)
+		if err == nil {
+			return t
+		}
+	}
+	data := stats.CPUStats.ThrottlingData
+	return &cpuThrottling{
+		periods:       data.Periods,
+		throttled:     data.ThrottledPeriods,
+		throttledTime: time.Duration(data.ThrottledTime),
+	}
+}
+
+// throttlingMetrics returns the throttling metrics of the container, keeping
+// the counters to calculate the percentage of throttled periods in the next
+// interval. Containers without a CPU quota have no metrics.
+func (r *Reporter) throttlingMetrics(id string, stats *docker.Stats) map[string]Metric {
+	current := r.containerThrottling(id, stats)
+	if current.periods == 0 {
+		return nil
+	}
+	r.mu.Lock()
+	if r.lastThrottling == nil {
+		r.lastThrottling = make(map[string]*cpuThrottling)
+	}
+	last := r.lastThrottling[id]
+	r.lastThrottling[id] = current
+	r.mu.Unlock()
+	return calculateThrottling(last, current)
+}
+
+// calculateThrottling returns the throttling counters of the container. The
+// percentage of throttled periods is left out until there's a previous
+// reading to compare with, and when the counters are reset, like after the
+// container restarts.
+func calculateThrottling(last, current *cpuThrottling) map[string]Metric {
+	metrics := map[string]Metric{
+		"cpu_periods":           Int(int64(current.periods)),
+		"cpu_throttled_periods": Int(int64(current.throttled)),
+		"cpu_throttled_time":    Float(current.throttledTime.Seconds()),
+	}
+	if last != nil && current.periods > last.periods && current.throttled >= last.throttled {
+		metrics["cpu_throttled_pct"] = Float(float64(current.throttled-last.throttled) / float64(current.periods-last.periods) * 100)
+	}
+	return metrics
+}
+
+// forgetThrottling drops the counters of containers no longer running.
+func (r *Reporter) forgetThrottling(running map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range r.lastThrottling {
+		if !running[id] {
+			delete(r.lastThrottling, id)
+		}
+	}
+}
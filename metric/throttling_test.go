@@ -0,0 +1,63 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseCPUStat(c *check.C) {
+	t, err := parseCPUStat(strings.NewReader("nr_periods 500\nnr_throttled 20\nthrottled_time 3000000000\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(*t, check.Equals, cpuThrottling{periods: 500, throttled: 20, throttledTime: 3 * time.Second})
+	t, err = parseCPUStat(strings.NewReader("usage_usec 100\nuser_usec 60\nsystem_usec 40\nnr_periods 500\nnr_throttled 20\nthrottled_usec 1500000\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(*t, check.Equals, cpuThrottling{periods: 500, throttled: 20, throttledTime: 1500 * time.Millisecond})
+}
+
+func (s *S) TestCalculateThrottling(c *check.C) {
+	last := &cpuThrottling{periods: 100, throttled: 10, throttledTime: time.Second}
+	current := &cpuThrottling{periods: 300, throttled: 60, throttledTime: 4 * time.Second}
+	c.Assert(calculateThrottling(nil, current), check.DeepEquals, map[string]Metric{
+		"cpu_periods":           Int(300),
+		"cpu_throttled_periods": Int(60),
+		"cpu_throttled_time":    Float(4),
+	})
+	c.Assert(calculateThrottling(last, current), check.DeepEquals, map[string]Metric{
+		"cpu_periods":           Int(300),
+		"cpu_throttled_periods": Int(60),
+		"cpu_throttled_time":    Float(4),
+		"cpu_throttled_pct":     Float(25),
+	})
+	restarted := &cpuThrottling{periods: 50, throttled: 5, throttledTime: time.Second}
+	c.Assert(calculateThrottling(current, restarted), check.DeepEquals, map[string]Metric{
+		"cpu_periods":           Int(50),
+		"cpu_throttled_periods": Int(5),
+		"cpu_throttled_time":    Float(1),
+	})
+}
+
+func (s *S) TestThrottlingMetricsFromStats(c *check.C) {
+	r := &Reporter{}
+	var stats docker.Stats
+	c.Assert(r.throttlingMetrics("c1", &stats), check.IsNil)
+	stats.CPUStats.ThrottlingData.Periods = 10
+	stats.CPUStats.ThrottlingData.ThrottledPeriods = 5
+	stats.CPUStats.ThrottlingData.ThrottledTime = uint64(2 * time.Second)
+	metrics := r.throttlingMetrics("c1", &stats)
+	c.Assert(metrics["cpu_throttled_time"], check.Equals, Float(2))
+	_, ok := metrics["cpu_throttled_pct"]
+	c.Assert(ok, check.Equals, false)
+	stats.CPUStats.ThrottlingData.Periods = 20
+	stats.CPUStats.ThrottlingData.ThrottledPeriods = 10
+	metrics = r.throttlingMetrics("c1", &stats)
+	c.Assert(metrics["cpu_throttled_pct"], check.Equals, Float(50))
+	r.forgetThrottling(map[string]bool{"c2": true})
+	c.Assert(r.lastThrottling, check.HasLen, 0)
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Metric is the value of a single metric. Integer values, like counters and
+// sizes in bytes, are kept as integers and encoded as JSON integers, so large
+// values are never rounded or rendered as floats; other values are always
+// encoded with a decimal point. A metric may carry a tag identifying what the
+// value refers to, like the name of a process, which backends send along with
+// the value.
+type Metric struct {
+	f        float64
+	i        int64
+	integer  bool
+	tagKey   string
+	tagValue string
+}
+
+// Float returns a Metric holding the given floating point value.
+func Float(v float64) Metric {
+	return Metric{f: v}
+}
+
+// Int returns a Metric holding the given integer value.
+func Int(v int64) Metric {
+	return Metric{i: v, integer: true}
+}
+
+// WithTag returns a copy of the metric tagged with the given key and value.
+func (m Metric) WithTag(key, value string) Metric {
+	m.tagKey, m.tagValue = key, value
+	return m
+}
+
+// Tag returns the key and value of the tag of the metric, which are empty
+// when the metric isn't tagged.
+func (m Metric) Tag() (string, string) {
+	return m.tagKey, m.tagValue
+}
+
+// IsInt reports whether the metric holds an integer value.
+func (m Metric) IsInt() bool {
+	return m.integer
+}
+
+// Float64 returns the value of the metric as a float64.
+func (m Metric) Float64() float64 {
+	if m.integer {
+		return float64(m.i)
+	}
+	return m.f
+}
+
+// Int64 returns the value of the metric as an int64, truncating floating
+// point values.
+func (m Metric) Int64() int64 {
+	if m.integer {
+		return m.i
+	}
+	return int64(m.f)
+}
+
+func (m Metric) String() string {
+	if m.integer {
+		return strconv.FormatInt(m.i, 10)
+	}
+	formatted := strconv.FormatFloat(m.f, 'f', -1, 64)
+	if !strings.Contains(formatted, ".") {
+		formatted += ".0"
+	}
+	return formatted
+}
+
+func (m Metric) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// add returns the sum of two metrics, which is an integer only when both are.
+func (m Metric) add(other Metric) Metric {
+	if m.integer && other.integer {
+		return Int(m.i + other.i)
+	}
+	return Float(m.Float64() + other.Float64())
+}
@@ -0,0 +1,28 @@
+// Copyright 2015 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"encoding/json"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestMetricMarshal(c *check.C) {
+	m := []interface{}{Float(1), Float(1.5), Float(10.4823748), Int(0), Int(9007199254740993)}
+	expected := `[1.0,1.5,10.4823748,0,9007199254740993]`
+	got, err := json.Marshal(m)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(got), check.Equals, expected)
+}
+
+func (s *S) TestMetricFloat64(c *check.C) {
+	c.Assert(Float(1.5).Float64(), check.Equals, 1.5)
+	c.Assert(Int(1024).Float64(), check.Equals, float64(1024))
+	c.Assert(Float(1.5).Int64(), check.Equals, int64(1))
+	c.Assert(Int(1<<62+1).Int64(), check.Equals, int64(1<<62+1))
+	c.Assert(Float(1).IsInt(), check.Equals, false)
+	c.Assert(Int(1).IsInt(), check.Equals, true)
+}
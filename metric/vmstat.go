@@ -0,0 +1,106 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsuru/bs/config"
+)
+
+// vmStat holds the cumulative paging counters found in /proc/vmstat.
+type vmStat struct {
+	time       time.Time
+	swapIn     uint64
+	swapOut    uint64
+	faults     uint64
+	majorFault uint64
+}
+
+func readVMStat() (*vmStat, error) {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	file, err := os.Open(filepath.Join(procPath, "vmstat"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseVMStat(file, time.Now())
+}
+
+func parseVMStat(r io.Reader, now time.Time) (*vmStat, error) {
+	stat := vmStat{time: now}
+	fields := map[string]*uint64{
+		"pswpin":     &stat.swapIn,
+		"pswpout":    &stat.swapOut,
+		"pgfault":    &stat.faults,
+		"pgmajfault": &stat.majorFault,
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			continue
+		}
+		dst, ok := fields[parts[0]]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		*dst = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &stat, nil
+}
+
+func (h *HostClient) getHostVMStat() (map[string]Metric, error) {
+	current, err := readVMStat()
+	if err != nil {
+		return nil, err
+	}
+	stats := vmStatRates(h.lastVMStat, current)
+	h.lastVMStat = current
+	return stats, nil
+}
+
+// vmStatRates returns the per second rate of pages swapped in and out and of
+// page faults between two readings. Rates are zero on the first reading.
+func vmStatRates(last, current *vmStat) map[string]Metric {
+	var swapIn, swapOut, faults, majorFaults float64
+	if last != nil {
+		elapsed := current.time.Sub(last.time).Seconds()
+		if elapsed > 0 {
+			swapIn = counterDelta(last.swapIn, current.swapIn) / elapsed
+			swapOut = counterDelta(last.swapOut, current.swapOut) / elapsed
+			faults = counterDelta(last.faults, current.faults) / elapsed
+			majorFaults = counterDelta(last.majorFault, current.majorFault) / elapsed
+		}
+	}
+	return map[string]Metric{
+		"swap_in_rate":      Float(swapIn),
+		"swap_out_rate":     Float(swapOut),
+		"page_faults_rate":  Float(faults),
+		"major_faults_rate": Float(majorFaults),
+	}
+}
+
+// counterDelta returns the increase of a counter, treating a decrease as a
+// counter reset.
+func counterDelta(last, current uint64) float64 {
+	if current < last {
+		return 0
+	}
+	return float64(current - last)
+}
@@ -0,0 +1,44 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metric
+
+import (
+	"strings"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseVMStat(c *check.C) {
+	data := `nr_free_pages 1966541
+pgpgin 5137528
+pswpin 120
+pswpout 300
+pgfault 98765
+pgmajfault 42
+`
+	now := time.Now()
+	stat, err := parseVMStat(strings.NewReader(data), now)
+	c.Assert(err, check.IsNil)
+	c.Assert(*stat, check.Equals, vmStat{time: now, swapIn: 120, swapOut: 300, faults: 98765, majorFault: 42})
+}
+
+func (s *S) TestVMStatRates(c *check.C) {
+	now := time.Now()
+	last := &vmStat{time: now, swapIn: 100, swapOut: 200, faults: 1000, majorFault: 10}
+	current := &vmStat{time: now.Add(10 * time.Second), swapIn: 150, swapOut: 200, faults: 3000, majorFault: 5}
+	c.Assert(vmStatRates(nil, current), check.DeepEquals, map[string]Metric{
+		"swap_in_rate":      Float(0),
+		"swap_out_rate":     Float(0),
+		"page_faults_rate":  Float(0),
+		"major_faults_rate": Float(0),
+	})
+	c.Assert(vmStatRates(last, current), check.DeepEquals, map[string]Metric{
+		"swap_in_rate":      Float(5),
+		"swap_out_rate":     Float(0),
+		"page_faults_rate":  Float(200),
+		"major_faults_rate": Float(0),
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netprobe
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// DNSProbe resolves a host name using the resolver of the node.
+type DNSProbe struct {
+	Name    string
+	Timeout time.Duration
+	// Resolver is the resolver used in the lookups, defaulting to
+	// net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+func (p *DNSProbe) Kind() string {
+	return "dns"
+}
+
+func (p *DNSProbe) Target() string {
+	return p.Name
+}
+
+func (p *DNSProbe) Run() error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	addrs, err := resolver.LookupHost(ctx, p.Name)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no addresses found for %s", p.Name)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netprobe
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBodySize is the size of the response body read by HTTP probes, so
+// connections can be reused without downloading large responses.
+const maxBodySize = 64 * 1024
+
+// HTTPProbe requests an URL, expecting a given status code. Redirects aren't
+// followed, so they can be expected too.
+type HTTPProbe struct {
+	URL string
+	// Status is the expected status code. When zero, any status below 400
+	// is accepted.
+	Status  int
+	Timeout time.Duration
+	client  *http.Client
+}
+
+// ParseHTTPProbe parses a probe in the form "URL [status [timeout]]", the
+// timeout in seconds, using defaultTimeout when no timeout is given.
+func ParseHTTPProbe(spec string, defaultTimeout time.Duration) (*HTTPProbe, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 3 {
+		return nil, fmt.Errorf("invalid HTTP probe %q, expected URL [status [timeout]]", spec)
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP probe %q: %s", spec, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid HTTP probe %q: invalid protocol %q, expected http or https", spec, u.Scheme)
+	}
+	probe := &HTTPProbe{URL: fields[0], Timeout: defaultTimeout}
+	if len(fields) > 1 {
+		probe.Status, err = strconv.Atoi(fields[1])
+		if err != nil || probe.Status < 100 || probe.Status > 599 {
+			return nil, fmt.Errorf("invalid HTTP probe %q: invalid status %q", spec, fields[1])
+		}
+	}
+	if len(fields) > 2 {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("invalid HTTP probe %q: invalid timeout %q", spec, fields[2])
+		}
+		probe.Timeout = time.Duration(seconds * float64(time.Second))
+	}
+	return probe, nil
+}
+
+func (p *HTTPProbe) Kind() string {
+	return "http"
+}
+
+// Target returns the URL without the scheme.
+func (p *HTTPProbe) Target() string {
+	if i := strings.Index(p.URL, "://"); i >= 0 {
+		return p.URL[i+3:]
+	}
+	return p.URL
+}
+
+func (p *HTTPProbe) Run() error {
+	if p.client == nil {
+		timeout := p.Timeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		p.client = &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	resp, err := p.client.Get(p.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxBodySize))
+	if p.Status != 0 {
+		if resp.StatusCode != p.Status {
+			return fmt.Errorf("unexpected status %d, expected %d", resp.StatusCode, p.Status)
+		}
+		return nil
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netprobe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseHTTPProbe(c *check.C) {
+	probe, err := ParseHTTPProbe("https://registry.example.com/v2/", time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(probe, check.DeepEquals, &HTTPProbe{URL: "https://registry.example.com/v2/", Timeout: time.Second})
+	c.Assert(probe.Kind(), check.Equals, "http")
+	c.Assert(probe.Target(), check.Equals, "registry.example.com/v2/")
+	probe, err = ParseHTTPProbe("http://tsuru.example.com/healthcheck  401 2.5", time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(probe, check.DeepEquals, &HTTPProbe{URL: "http://tsuru.example.com/healthcheck", Status: 401, Timeout: 2500 * time.Millisecond})
+	var tests = []struct {
+		spec string
+		err  string
+	}{
+		{"", `invalid HTTP probe "", expected URL \[status \[timeout\]\]`},
+		{"http://a 200 1 x", `invalid HTTP probe "http://a 200 1 x", expected URL \[status \[timeout\]\]`},
+		{"tcp://a", `invalid HTTP probe "tcp://a": invalid protocol "tcp", expected http or https`},
+		{"http://a ok", `invalid HTTP probe "http://a ok": invalid status "ok"`},
+		{"http://a 1000", `invalid HTTP probe "http://a 1000": invalid status "1000"`},
+		{"http://a 200 -1", `invalid HTTP probe "http://a 200 -1": invalid timeout "-1"`},
+	}
+	for _, tt := range tests {
+		_, err = ParseHTTPProbe(tt.spec, time.Second)
+		c.Check(err, check.ErrorMatches, tt.err)
+	}
+}
+
+func (s *S) TestHTTPProbe(c *check.C) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	probe := &HTTPProbe{URL: server.URL}
+	c.Assert(probe.Run(), check.IsNil)
+	status = http.StatusServiceUnavailable
+	c.Assert(probe.Run(), check.ErrorMatches, "unexpected status 503")
+	probe = &HTTPProbe{URL: server.URL, Status: http.StatusServiceUnavailable}
+	c.Assert(probe.Run(), check.IsNil)
+	probe = &HTTPProbe{URL: server.URL + "/redirect", Status: http.StatusOK}
+	c.Assert(probe.Run(), check.ErrorMatches, "unexpected status 302, expected 200")
+}
+
+func (s *S) TestHTTPProbeTimeout(c *check.C) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+	probe := &HTTPProbe{URL: server.URL, Timeout: 50 * time.Millisecond}
+	c.Assert(probe.Run(), check.ErrorMatches, ".*(Timeout|timeout).*")
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package netprobe periodically checks, from the node, the services bs and
+// the containers depend on, like DNS and HTTP services, reporting their
+// availability and latency as host metrics, as node-local network failures
+// are otherwise silent.
+package netprobe
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/supervisor"
+)
+
+const (
+	DefaultInterval = time.Minute
+	DefaultTimeout  = 10 * time.Second
+)
+
+// Probe checks a single target.
+type Probe interface {
+	// Kind is the kind of the probe, used as prefix of its metrics.
+	Kind() string
+	// Target is the checked target, used as suffix of its metrics.
+	Target() string
+	// Run checks the target once, returning an error if it's unavailable.
+	Run() error
+}
+
+type result struct {
+	up      bool
+	latency time.Duration
+}
+
+// Prober runs the probes periodically, keeping their last results to be
+// reported as host metrics.
+type Prober struct {
+	probes   []Probe
+	interval time.Duration
+	mu       sync.RWMutex
+	results  map[Probe]result
+	abort    chan struct{}
+	exit     chan struct{}
+}
+
+func NewProber(probes []Probe, interval time.Duration) *Prober {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Prober{
+		probes:   probes,
+		interval: interval,
+		results:  make(map[Probe]result),
+		abort:    make(chan struct{}),
+		exit:     make(chan struct{}),
+	}
+}
+
+// Start runs the probes periodically until Stop is called.
+func (p *Prober) Start() {
+	supervisor.Go("network prober", func() {
+		for {
+			p.Run()
+			select {
+			case <-p.abort:
+				close(p.exit)
+				return
+			case <-time.After(p.interval):
+			}
+		}
+	})
+}
+
+// Stop stops the prober, blocking until it actually stops.
+func (p *Prober) Stop() {
+	close(p.abort)
+	<-p.exit
+}
+
+// Wait blocks until the prober stops.
+func (p *Prober) Wait() {
+	<-p.exit
+}
+
+// Run runs every probe once, concurrently, so a slow target doesn't delay
+// the others.
+func (p *Prober) Run() {
+	var wg sync.WaitGroup
+	for _, probe := range p.probes {
+		wg.Add(1)
+		go func(probe Probe) {
+			defer wg.Done()
+			start := time.Now()
+			err := probe.Run()
+			res := result{up: err == nil, latency: time.Since(start)}
+			p.mu.Lock()
+			last, ok := p.results[probe]
+			p.results[probe] = res
+			p.mu.Unlock()
+			if err != nil && (!ok || last.up) {
+				bslog.Warnf("[netprobe] %s probe of %s failed: %s", probe.Kind(), probe.Target(), err)
+			} else if err == nil && ok && !last.up {
+				bslog.Warnf("[netprobe] %s probe of %s recovered", probe.Kind(), probe.Target())
+			}
+		}(probe)
+	}
+	wg.Wait()
+}
+
+// HostMetrics returns, for each probe already run, a 0/1 metric named
+// <kind>_up_<target>, 1 when the target is available, and the latency of
+// the last check in milliseconds, named <kind>_latency_ms_<target>, along
+// with the number of failing probes of each kind, named <kind>_failures.
+func (p *Prober) HostMetrics() map[string]metric.Metric {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.results) == 0 {
+		return nil
+	}
+	metrics := make(map[string]metric.Metric, len(p.results)*2)
+	failures := make(map[string]int64)
+	for probe, res := range p.results {
+		suffix := metricSuffix(probe.Target())
+		var up int64
+		if res.up {
+			up = 1
+		}
+		failures[probe.Kind()] += 1 - up
+		metrics[probe.Kind()+"_up_"+suffix] = metric.Int(up)
+		metrics[probe.Kind()+"_latency_ms_"+suffix] = metric.Float(float64(res.latency) / float64(time.Millisecond))
+	}
+	for kind, n := range failures {
+		metrics[kind+"_failures"] = metric.Int(n)
+	}
+	return metrics
+}
+
+// State returns the targets of the probes, grouped by kind.
+func (p *Prober) State() map[string]interface{} {
+	targets := make(map[string][]string)
+	for _, probe := range p.probes {
+		targets[probe.Kind()] = append(targets[probe.Kind()], probe.Target())
+	}
+	state := make(map[string]interface{}, len(targets))
+	for kind, t := range targets {
+		sort.Strings(t)
+		state[kind] = t
+	}
+	return state
+}
+
+// metricSuffix replaces the characters other than letters and digits in a
+// target with underscores, trimming the leading and trailing ones, so it can
+// be appended to the name of a metric.
+func metricSuffix(target string) string {
+	return strings.Trim(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, target), "_")
+}
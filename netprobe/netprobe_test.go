@@ -0,0 +1,110 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netprobe
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+type fakeProbe struct {
+	kind   string
+	target string
+	delay  time.Duration
+	err    error
+}
+
+func (p *fakeProbe) Kind() string {
+	return p.kind
+}
+
+func (p *fakeProbe) Target() string {
+	return p.target
+}
+
+func (p *fakeProbe) Run() error {
+	time.Sleep(p.delay)
+	return p.err
+}
+
+func (s *S) TestProberHostMetrics(c *check.C) {
+	failing := &fakeProbe{kind: "dns", target: "registry.example.com", err: errors.New("no such host")}
+	prober := NewProber([]Probe{
+		&fakeProbe{kind: "dns", target: "tsuru.example.com", delay: 20 * time.Millisecond},
+		failing,
+	}, 0)
+	c.Assert(prober.interval, check.Equals, DefaultInterval)
+	c.Assert(prober.HostMetrics(), check.IsNil)
+	prober.Run()
+	metrics := prober.HostMetrics()
+	c.Assert(metrics, check.HasLen, 5)
+	c.Assert(metrics["dns_failures"], check.Equals, metric.Int(1))
+	c.Assert(metrics["dns_up_tsuru_example_com"], check.Equals, metric.Int(1))
+	c.Assert(metrics["dns_up_registry_example_com"], check.Equals, metric.Int(0))
+	c.Assert(metrics["dns_latency_ms_tsuru_example_com"].Float64() >= 20, check.Equals, true)
+	failing.err = nil
+	prober.Run()
+	metrics = prober.HostMetrics()
+	c.Assert(metrics["dns_failures"], check.Equals, metric.Int(0))
+	c.Assert(metrics["dns_up_registry_example_com"], check.Equals, metric.Int(1))
+}
+
+func (s *S) TestProberStartStop(c *check.C) {
+	probe := &fakeProbe{kind: "dns", target: "localhost"}
+	prober := NewProber([]Probe{probe}, time.Hour)
+	prober.Start()
+	prober.Stop()
+	c.Assert(prober.HostMetrics()["dns_up_localhost"], check.Equals, metric.Int(1))
+}
+
+func (s *S) TestProberState(c *check.C) {
+	prober := NewProber([]Probe{
+		&fakeProbe{kind: "dns", target: "b"},
+		&fakeProbe{kind: "dns", target: "a"},
+	}, 0)
+	c.Assert(prober.State(), check.DeepEquals, map[string]interface{}{
+		"dns": []string{"a", "b"},
+	})
+}
+
+func (s *S) TestDNSProbe(c *check.C) {
+	probe := &DNSProbe{Name: "localhost", Resolver: &net.Resolver{PreferGo: true}}
+	c.Assert(probe.Kind(), check.Equals, "dns")
+	c.Assert(probe.Target(), check.Equals, "localhost")
+	c.Assert(probe.Run(), check.IsNil)
+}
+
+func (s *S) TestDNSProbeFailure(c *check.C) {
+	probe := &DNSProbe{
+		Name:    "registry.example.com",
+		Timeout: time.Second,
+		Resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				return nil, errors.New("dns server unreachable")
+			},
+		},
+	}
+	c.Assert(probe.Run(), check.ErrorMatches, ".*dns server unreachable.*")
+}
+
+func (s *S) TestMetricSuffix(c *check.C) {
+	c.Assert(metricSuffix("registry.example.com"), check.Equals, "registry_example_com")
+	c.Assert(metricSuffix("registry.example.com/v2/"), check.Equals, "registry_example_com_v2")
+}
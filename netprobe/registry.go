@@ -0,0 +1,223 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netprobe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	defaultRegistry   = "registry-1.docker.io"
+	dockerHubRegistry = "docker.io"
+)
+
+var (
+	manifestMediaTypes = []string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}
+	authParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// RegistryProbe checks whether an image can be pulled from its registry,
+// either by requesting its manifest from the registry, or, when Docker is
+// set, by pulling it through the Docker daemon, which downloads the layers
+// too. Pulled images are removed after each check, so the next check
+// downloads them again, so the image should be a tiny one used only by the
+// probe.
+type RegistryProbe struct {
+	Image    string
+	Username string
+	Password string
+	// Insecure makes the probe reach the registry using plain HTTP,
+	// instead of HTTPS, when requesting the manifest.
+	Insecure bool
+	Timeout  time.Duration
+	// Docker is the client used to pull the image. When nil, only the
+	// manifest is requested.
+	Docker *docker.Client
+	client *http.Client
+}
+
+func (p *RegistryProbe) Kind() string {
+	return "registry"
+}
+
+func (p *RegistryProbe) Target() string {
+	return p.Image
+}
+
+func (p *RegistryProbe) Run() error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if p.Docker != nil {
+		return p.pull(timeout)
+	}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: timeout}
+	}
+	return p.headManifest()
+}
+
+func (p *RegistryProbe) pull(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	repository, tag := docker.ParseRepositoryTag(p.Image)
+	if tag == "" {
+		tag = "latest"
+	}
+	err := p.Docker.PullImage(docker.PullImageOptions{
+		Repository: repository,
+		Tag:        tag,
+		Context:    ctx,
+	}, docker.AuthConfiguration{Username: p.Username, Password: p.Password})
+	if err != nil {
+		return err
+	}
+	p.Docker.RemoveImage(repository + ":" + tag)
+	return nil
+}
+
+// headManifest requests the manifest of the image, getting a token from the
+// authorization service of the registry if required.
+func (p *RegistryProbe) headManifest() error {
+	registry, repository, reference := parseImage(p.Image)
+	scheme := "https"
+	if p.Insecure {
+		scheme = "http"
+	}
+	manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, registry, repository, reference)
+	resp, err := p.requestManifest(manifestURL, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return errors.New("registry requires authentication")
+		}
+		token, err := p.token(challenge)
+		if err != nil {
+			return err
+		}
+		resp, err = p.requestManifest(manifestURL, token)
+		if err != nil {
+			return err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d requesting manifest of %s", resp.StatusCode, p.Image)
+	}
+	return nil
+}
+
+func (p *RegistryProbe) requestManifest(manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequest("HEAD", manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// token requests a pull token from the authorization service announced in
+// the challenge of the registry.
+func (p *RegistryProbe) token(challenge string) (string, error) {
+	params := make(map[string]string)
+	for _, m := range authParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return "", fmt.Errorf("no realm in registry challenge %q", challenge)
+	}
+	query := url.Values{}
+	for _, name := range []string{"service", "scope"} {
+		if params[name] != "" {
+			query.Set(name, params[name])
+		}
+	}
+	req, err := http.NewRequest("GET", params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, resp.Body)
+		return "", fmt.Errorf("unexpected status %d requesting registry token", resp.StatusCode)
+	}
+	var data struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", fmt.Errorf("invalid registry token response: %s", err)
+	}
+	if data.Token != "" {
+		return data.Token, nil
+	}
+	if data.AccessToken != "" {
+		return data.AccessToken, nil
+	}
+	return "", errors.New("no token in registry token response")
+}
+
+// parseImage splits an image name in registry, repository and reference,
+// either a tag or a digest, applying the defaults of the Docker Hub.
+func parseImage(image string) (registry, repository, reference string) {
+	registry = defaultRegistry
+	repository = image
+	reference = "latest"
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, reference = repository[:i], repository[i+1:]
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, reference = repository[:i], repository[i+1:]
+	}
+	if i := strings.Index(repository, "/"); i >= 0 {
+		host := repository[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			registry, repository = host, repository[i+1:]
+		}
+	}
+	if registry == dockerHubRegistry {
+		registry = defaultRegistry
+	}
+	if registry == defaultRegistry && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return registry, repository, reference
+}
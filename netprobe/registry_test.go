@@ -0,0 +1,114 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netprobe
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestParseImage(c *check.C) {
+	var tests = []struct {
+		image      string
+		registry   string
+		repository string
+		reference  string
+	}{
+		{"busybox", "registry-1.docker.io", "library/busybox", "latest"},
+		{"docker.io/tsuru/bs:v1", "registry-1.docker.io", "tsuru/bs", "v1"},
+		{"registry.example.com/canary", "registry.example.com", "canary", "latest"},
+		{"localhost:5000/tsuru/canary:1.0", "localhost:5000", "tsuru/canary", "1.0"},
+		{"registry.example.com/canary@sha256:abcd", "registry.example.com", "canary", "sha256:abcd"},
+	}
+	for _, tt := range tests {
+		registry, repository, reference := parseImage(tt.image)
+		c.Check(registry, check.Equals, tt.registry, check.Commentf(tt.image))
+		c.Check(repository, check.Equals, tt.repository, check.Commentf(tt.image))
+		c.Check(reference, check.Equals, tt.reference, check.Commentf(tt.image))
+	}
+}
+
+func (s *S) TestRegistryProbeManifest(c *check.C) {
+	var mu sync.Mutex
+	var requests []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.String())
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/token":
+			if user, pass, _ := r.BasicAuth(); user != "bs" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "abc"}`)
+		case "/v2/tsuru/canary/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:tsuru/canary:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			c.Check(r.Header.Get("Accept"), check.Matches, ".*application/vnd.docker.distribution.manifest.v2\\+json.*")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	probe := &RegistryProbe{Image: host + "/tsuru/canary", Username: "bs", Password: "secret", Insecure: true}
+	c.Assert(probe.Kind(), check.Equals, "registry")
+	c.Assert(probe.Target(), check.Equals, host+"/tsuru/canary")
+	c.Assert(probe.Run(), check.IsNil)
+	c.Assert(requests, check.DeepEquals, []string{
+		"HEAD /v2/tsuru/canary/manifests/latest",
+		"GET /token?scope=repository%3Atsuru%2Fcanary%3Apull&service=registry",
+		"HEAD /v2/tsuru/canary/manifests/latest",
+	})
+	probe.Password = "wrong"
+	c.Assert(probe.Run(), check.ErrorMatches, "unexpected status 401 requesting registry token")
+	probe = &RegistryProbe{Image: host + "/tsuru/missing", Insecure: true}
+	c.Assert(probe.Run(), check.ErrorMatches, "unexpected status 404 requesting manifest of .*/tsuru/missing")
+}
+
+func (s *S) TestRegistryProbeBasicChallenge(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	probe := &RegistryProbe{Image: strings.TrimPrefix(server.URL, "http://") + "/canary", Insecure: true}
+	c.Assert(probe.Run(), check.ErrorMatches, "registry requires authentication")
+}
+
+func (s *S) TestRegistryProbePull(c *check.C) {
+	var mu sync.Mutex
+	var requests []string
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+	})
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	client, err := docker.NewClient(server.URL())
+	c.Assert(err, check.IsNil)
+	probe := &RegistryProbe{Image: "registry.example.com/canary:1.0", Docker: client}
+	c.Assert(probe.Run(), check.IsNil)
+	c.Assert(requests, check.DeepEquals, []string{
+		"POST /images/create",
+		"DELETE /images/registry.example.com/canary:1.0",
+	})
+	images, err := client.ListImages(docker.ListImagesOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(images, check.HasLen, 0)
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/tsuruapi"
+)
+
+const DefaultMetadataTTL = 5 * time.Minute
+
+// Metadata holds the information tsuru keeps about the node bs is running
+// on.
+type Metadata struct {
+	Address  string
+	Pool     string
+	Metadata map[string]string
+}
+
+type apiNode struct {
+	Address  string
+	Pool     string
+	Metadata map[string]string
+}
+
+// MetadataCache keeps the node metadata fetched from the tsuru API. The
+// metadata is refreshed in background once it expires, so callers never
+// block waiting for the API.
+type MetadataCache struct {
+	client     *tsuruapi.Client
+	addrs      []string
+	ttl        time.Duration
+	mu         sync.Mutex
+	metadata   Metadata
+	expiresAt  time.Time
+	refreshing bool
+}
+
+func NewMetadataCache(client *tsuruapi.Client, addrs []string, ttl time.Duration) *MetadataCache {
+	if ttl <= 0 {
+		ttl = DefaultMetadataTTL
+	}
+	return &MetadataCache{client: client, addrs: addrs, ttl: ttl}
+}
+
+// Get returns the last metadata fetched from the tsuru API, triggering a
+// refresh in background if it's expired. An empty Metadata is returned
+// until the first fetch succeeds.
+func (c *MetadataCache) Get() Metadata {
+	if c == nil {
+		return Metadata{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.refreshing && time.Now().After(c.expiresAt) {
+		c.refreshing = true
+		go c.refresh()
+	}
+	return c.metadata
+}
+
+// Refresh fetches the node metadata from the tsuru API, blocking until it's
+// done.
+func (c *MetadataCache) Refresh() error {
+	metadata, err := c.fetch()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		// Try again on the next interval, without hammering the API.
+		c.expiresAt = time.Now().Add(c.ttl)
+		return err
+	}
+	c.metadata = metadata
+	c.expiresAt = time.Now().Add(c.ttl)
+	return nil
+}
+
+func (c *MetadataCache) refresh() {
+	if err := c.Refresh(); err != nil {
+		bslog.Errorf("[node metadata] unable to fetch node metadata: %s", err)
+	}
+}
+
+func (c *MetadataCache) fetch() (Metadata, error) {
+	resp, err := c.client.Do("GET", "/node", nil, nil)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return Metadata{}, fmt.Errorf("unexpected response from tsuru %d: %s", resp.StatusCode, string(body))
+	}
+	var result struct {
+		Nodes []apiNode `json:"nodes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return Metadata{}, err
+	}
+	addrs := make(map[string]struct{}, len(c.addrs))
+	for _, addr := range c.addrs {
+		addrs[addr] = struct{}{}
+	}
+	for _, n := range result.Nodes {
+		if _, ok := addrs[addressHost(n.Address)]; !ok {
+			continue
+		}
+		pool := n.Pool
+		if pool == "" {
+			pool = n.Metadata["pool"]
+		}
+		return Metadata{Address: n.Address, Pool: pool, Metadata: n.Metadata}, nil
+	}
+	return Metadata{}, fmt.Errorf("node with addresses %v not found in tsuru", c.addrs)
+}
+
+// addressHost returns the host of a node address, which may be an URL like
+// http://10.0.0.1:2375, a host:port pair or a plain host.
+func addressHost(address string) string {
+	if strings.Contains(address, "://") {
+		if u, err := url.Parse(address); err == nil {
+			address = u.Host
+		}
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsuru/bs/tsuruapi"
+	"gopkg.in/check.v1"
+)
+
+const nodesResponse = `{"nodes": [
+	{"Address": "http://10.0.0.1:2375", "Metadata": {"pool": "pool1", "zone": "a"}},
+	{"Address": "10.0.0.2", "Pool": "pool2", "Metadata": {"zone": "b"}}
+]}`
+
+func (h *H) TestMetadataCacheRefresh(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/node")
+		w.Write([]byte(nodesResponse))
+	}))
+	defer server.Close()
+	client := tsuruapi.NewClient(tsuruapi.Config{Endpoint: server.URL})
+	cache := NewMetadataCache(client, []string{"127.0.0.1", "10.0.0.1"}, 0)
+	err := cache.Refresh()
+	c.Assert(err, check.IsNil)
+	c.Assert(cache.Get(), check.DeepEquals, Metadata{
+		Address:  "http://10.0.0.1:2375",
+		Pool:     "pool1",
+		Metadata: map[string]string{"pool": "pool1", "zone": "a"},
+	})
+	cache = NewMetadataCache(client, []string{"10.0.0.2"}, 0)
+	err = cache.Refresh()
+	c.Assert(err, check.IsNil)
+	c.Assert(cache.Get().Pool, check.Equals, "pool2")
+}
+
+func (h *H) TestMetadataCacheRefreshNotFound(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(nodesResponse))
+	}))
+	defer server.Close()
+	client := tsuruapi.NewClient(tsuruapi.Config{Endpoint: server.URL})
+	cache := NewMetadataCache(client, []string{"10.0.0.3"}, 0)
+	err := cache.Refresh()
+	c.Assert(err, check.ErrorMatches, `node with addresses \[10.0.0.3\] not found in tsuru`)
+	c.Assert(cache.Get(), check.DeepEquals, Metadata{})
+}
+
+func (h *H) TestMetadataCacheGetRefreshesInBackground(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Write([]byte(nodesResponse))
+	}))
+	defer server.Close()
+	client := tsuruapi.NewClient(tsuruapi.Config{Endpoint: server.URL})
+	cache := NewMetadataCache(client, []string{"10.0.0.1"}, time.Hour)
+	c.Assert(cache.Get(), check.DeepEquals, Metadata{})
+	timeout := time.After(5 * time.Second)
+	for cache.Get().Pool == "" {
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for metadata refresh")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	c.Assert(cache.Get().Pool, check.Equals, "pool1")
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(1))
+}
+
+func (h *H) TestMetadataCacheGetNil(c *check.C) {
+	var cache *MetadataCache
+	c.Assert(cache.Get(), check.DeepEquals, Metadata{})
+}
+
+func (h *H) TestAddressHost(c *check.C) {
+	c.Assert(addressHost("http://10.0.0.1:2375"), check.Equals, "10.0.0.1")
+	c.Assert(addressHost("10.0.0.1:2375"), check.Equals, "10.0.0.1")
+	c.Assert(addressHost("10.0.0.1"), check.Equals, "10.0.0.1")
+	c.Assert(addressHost("https://node1.example.com"), check.Equals, "node1.example.com")
+}
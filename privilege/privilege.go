@@ -0,0 +1,182 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package privilege describes the privileged operations bs depends on, along
+// with the capability each one requires and the features disabled without
+// it, so bs can run as non-root with a reduced feature set instead of failing
+// opaquely.
+package privilege
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+)
+
+const dockerTimeout = 10 * time.Second
+
+// Operation is a privileged operation bs depends on. When it's unavailable
+// the features depending on it are disabled, with errors naming the missing
+// capability.
+type Operation struct {
+	// Name is the resource accessed by the operation.
+	Name string
+	// Requires describes the capability or permission the operation needs.
+	Requires string
+	// Disables describes the features unavailable without the operation.
+	Disables string
+	check    func() error
+}
+
+// Error is the error of an unavailable operation.
+type Error struct {
+	Op  *Operation
+	Err error
+}
+
+func (e *Error) Error() string {
+	if isPermission(e.Err) {
+		return fmt.Sprintf("%s unavailable: %s; requires %s, disabling %s", e.Op.Name, e.Err, e.Op.Requires, e.Op.Disables)
+	}
+	return fmt.Sprintf("%s unavailable: %s; disabling %s", e.Op.Name, e.Err, e.Op.Disables)
+}
+
+// Check returns an *Error when the operation can't be performed.
+func (o *Operation) Check() error {
+	return o.Wrap(o.check())
+}
+
+// Wrap annotates an error returned while performing the operation with the
+// features disabled and, for permission errors, the missing capability. It
+// returns nil when err is nil.
+func (o *Operation) Wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{Op: o, Err: err}
+}
+
+// HostProc reads the host /proc filesystem, mounted in HOST_PROC.
+var HostProc = &Operation{
+	Name:     "host proc filesystem",
+	Requires: "the host /proc mounted in HOST_PROC, /host/proc or /rootfs/proc and readable by the bs user",
+	Disables: "host metrics",
+	check: func() error {
+		procPath := os.Getenv("HOST_PROC")
+		if procPath == "" {
+			return errors.New("HOST_PROC is not set")
+		}
+		return readable(filepath.Join(procPath, "meminfo"))
+	},
+}
+
+// Cgroups reads the mount table of the host init process, used to find the
+// cgroup hierarchy, and the cgroup files of containers.
+var Cgroups = &Operation{
+	Name:     "cgroup hierarchy",
+	Requires: "CAP_SYS_PTRACE, or running as root, when the host /proc is mounted with hidepid, and read access to the host cgroup filesystem",
+	Disables: "container metrics read from cgroups, falling back to Docker stats, and slice metrics",
+	check: func() error {
+		procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+		return readable(filepath.Join(procPath, "1", "mountinfo"))
+	},
+}
+
+// Exec executes external binaries, which may be blocked by the seccomp or
+// AppArmor profile of the bs container. The check runs once, as the profile
+// doesn't change while bs is running.
+var Exec = &Operation{
+	Name:     "external binaries",
+	Requires: "a seccomp and AppArmor profile allowing execve",
+	Disables: "conntrack, chrony, ntpd, systemd and SMART metrics and Kubernetes log files monitoring",
+	check: func() error {
+		execTest.once.Do(func() {
+			execTest.err = runExecTest()
+		})
+		return execTest.err
+	},
+}
+
+var execTest = struct {
+	once    sync.Once
+	err     error
+	command string
+}{command: "true"}
+
+func runExecTest() error {
+	path, err := exec.LookPath(execTest.command)
+	if err != nil {
+		// Without a binary to test, features fail on their own.
+		return nil
+	}
+	return exec.Command(path).Run()
+}
+
+// Docker talks to the Docker daemon at the given endpoint.
+func Docker(endpoint string) *Operation {
+	return &Operation{
+		Name:     "Docker daemon",
+		Requires: "read and write access to the Docker socket, as root or a member of the docker group",
+		Disables: "container metadata in logs, container metrics and status reports",
+		check: func() error {
+			client, err := docker.NewClient(endpoint)
+			if err != nil {
+				return err
+			}
+			client.SetTimeout(dockerTimeout)
+			return client.Ping()
+		},
+	}
+}
+
+// Operations returns the privileged operations used in the current platform.
+func Operations(dockerEndpoint string) []*Operation {
+	if runtime.GOOS == "windows" {
+		return []*Operation{Docker(dockerEndpoint)}
+	}
+	return []*Operation{HostProc, Cgroups, Exec, Docker(dockerEndpoint)}
+}
+
+// Report checks the operations, logging the unavailable ones, and returns
+// their errors.
+func Report(ops ...*Operation) []error {
+	var errs []error
+	for _, op := range ops {
+		if err := op.Check(); err != nil {
+			bslog.Warnf("[privilege] %s", err)
+			errs = append(errs, err)
+		}
+	}
+	if uid := os.Geteuid(); len(errs) > 0 && uid > 0 {
+		bslog.Warnf("[privilege] running as uid %d, %d of %d privileged operations unavailable", uid, len(errs), len(ops))
+	}
+	return errs
+}
+
+func readable(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// isPermission reports whether err is a permission error, including the ones
+// wrapped by network and Docker client errors.
+func isPermission(err error) bool {
+	return os.IsPermission(err) || strings.Contains(err.Error(), "permission denied")
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package privilege
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/bs/bslog"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+func (s *S) TestErrorPermission(c *check.C) {
+	op := &Operation{Name: "thing", Requires: "CAP_THING", Disables: "thing metrics"}
+	err := op.Wrap(&os.PathError{Op: "open", Path: "/thing", Err: os.ErrPermission})
+	c.Assert(err, check.ErrorMatches, `thing unavailable: open /thing: permission denied; requires CAP_THING, disabling thing metrics`)
+	c.Assert(op.Wrap(err), check.Equals, err)
+	err = op.Wrap(errors.New("dial unix /var/run/docker.sock: connect: permission denied"))
+	c.Assert(err, check.ErrorMatches, `thing unavailable: .*; requires CAP_THING, disabling thing metrics`)
+	err = op.Wrap(errors.New("connection refused"))
+	c.Assert(err, check.ErrorMatches, `thing unavailable: connection refused; disabling thing metrics`)
+	c.Assert(op.Wrap(nil), check.IsNil)
+}
+
+func (s *S) TestHostProc(c *check.C) {
+	oldProc := os.Getenv("HOST_PROC")
+	defer os.Setenv("HOST_PROC", oldProc)
+	os.Setenv("HOST_PROC", "")
+	c.Assert(HostProc.Check(), check.ErrorMatches, `host proc filesystem unavailable: HOST_PROC is not set; disabling host metrics`)
+	dir := c.MkDir()
+	os.Setenv("HOST_PROC", dir)
+	c.Assert(HostProc.Check(), check.NotNil)
+	err := ioutil.WriteFile(filepath.Join(dir, "meminfo"), nil, 0644)
+	c.Assert(err, check.IsNil)
+	c.Assert(HostProc.Check(), check.IsNil)
+}
+
+func (s *S) TestCgroups(c *check.C) {
+	oldProc := os.Getenv("HOST_PROC")
+	defer os.Setenv("HOST_PROC", oldProc)
+	dir := c.MkDir()
+	os.Setenv("HOST_PROC", dir)
+	c.Assert(Cgroups.Check(), check.NotNil)
+	err := os.Mkdir(filepath.Join(dir, "1"), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "1", "mountinfo"), nil, 0644)
+	c.Assert(err, check.IsNil)
+	c.Assert(Cgroups.Check(), check.IsNil)
+}
+
+func (s *S) TestExec(c *check.C) {
+	defer func() {
+		execTest.once = sync.Once{}
+		execTest.err = nil
+		execTest.command = "true"
+	}()
+	execTest.once = sync.Once{}
+	c.Assert(Exec.Check(), check.IsNil)
+	execTest.once = sync.Once{}
+	execTest.command = "bs-missing-binary"
+	c.Assert(Exec.Check(), check.IsNil)
+	path := filepath.Join(c.MkDir(), "invalid")
+	err := ioutil.WriteFile(path, []byte("not a binary"), 0755)
+	c.Assert(err, check.IsNil)
+	execTest.once = sync.Once{}
+	execTest.command = path
+	c.Assert(Exec.Check(), check.ErrorMatches, `external binaries unavailable: .*; disabling conntrack, chrony, ntpd, systemd and SMART metrics and Kubernetes log files monitoring`)
+	execTest.command = "true"
+	c.Assert(Exec.Check(), check.NotNil)
+}
+
+func (s *S) TestDocker(c *check.C) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	c.Assert(Docker(server.URL()).Check(), check.IsNil)
+	err = Docker("unix://" + filepath.Join(c.MkDir(), "docker.sock")).Check()
+	c.Assert(err, check.ErrorMatches, `Docker daemon unavailable: .*; disabling container metadata in logs, container metrics and status reports`)
+}
+
+func (s *S) TestReport(c *check.C) {
+	var logOutput bytes.Buffer
+	bslog.Logger = log.New(&logOutput, "", 0)
+	defer func() { bslog.Logger = log.New(os.Stderr, "", log.LstdFlags) }()
+	ok := &Operation{Name: "ok", check: func() error { return nil }}
+	missing := &Operation{Name: "thing", Requires: "CAP_THING", Disables: "thing metrics", check: func() error {
+		return os.ErrPermission
+	}}
+	errs := Report(ok, missing)
+	c.Assert(errs, check.HasLen, 1)
+	c.Assert(errs[0], check.ErrorMatches, `thing unavailable: permission denied; requires CAP_THING, disabling thing metrics`)
+	c.Assert(logOutput.String(), check.Matches, `(?s).*\[privilege\] thing unavailable: permission denied; requires CAP_THING.*`)
+}
@@ -0,0 +1,34 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package problem
+
+import (
+	"errors"
+	"syscall"
+)
+
+// timeError is the clock state returned by adjtimex when the kernel clock is
+// not synchronized (TIME_ERROR).
+const timeError = 5
+
+// clockProbe checks whether the kernel considers the system clock
+// synchronized by NTP, as unsynchronized nodes slowly drift apart.
+type clockProbe struct{}
+
+func (p *clockProbe) Name() string {
+	return clockProbeName
+}
+
+func (p *clockProbe) Check() error {
+	var timex syscall.Timex
+	state, err := syscall.Adjtimex(&timex)
+	if err != nil {
+		return err
+	}
+	if state == timeError {
+		return errors.New("system clock is not synchronized")
+	}
+	return nil
+}
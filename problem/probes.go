@@ -0,0 +1,413 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package problem
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+
+	"github.com/tsuru/bs/metric"
+)
+
+const (
+	readOnlyProbeName  = "readonly_fs"
+	dockerProbeName    = "docker_unresponsive"
+	clockProbeName     = "clock_unsynchronized"
+	zombieProbeName    = "docker_zombies"
+	conntrackProbeName = "conntrack_full"
+	writableProbeName  = "unwritable_fs"
+	portProbeName      = "port_conflict"
+
+	defaultDockerProbeTimeout = 10 * time.Second
+	defaultMaxZombies         = 10
+	defaultConntrackThreshold = 90
+	defaultWriteTimeout       = 10 * time.Second
+
+	writableCheckFile = ".bs-writable-check"
+)
+
+// readOnlyProbe finds mount points remounted as read-only, which usually
+// happens silently after filesystem errors.
+type readOnlyProbe struct {
+	procPath string
+	mounts   []string
+}
+
+func (p *readOnlyProbe) Name() string {
+	return readOnlyProbeName
+}
+
+func (p *readOnlyProbe) Check() error {
+	// Mounts from pid 1 are the ones seen by the host, not by the bs
+	// container.
+	data, err := ioutil.ReadFile(filepath.Join(p.procPath, "1", "mounts"))
+	if err != nil {
+		data, err = ioutil.ReadFile(filepath.Join(p.procPath, "mounts"))
+		if err != nil {
+			return err
+		}
+	}
+	wanted := make(map[string]struct{}, len(p.mounts))
+	for _, m := range p.mounts {
+		wanted[m] = struct{}{}
+	}
+	var readOnly []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if _, ok := wanted[fields[1]]; !ok {
+			continue
+		}
+		for _, opt := range strings.Split(fields[3], ",") {
+			if opt == "ro" {
+				readOnly = append(readOnly, fields[1])
+				break
+			}
+		}
+	}
+	if len(readOnly) > 0 {
+		return fmt.Errorf("read-only mount points: %s", strings.Join(readOnly, ", "))
+	}
+	return nil
+}
+
+// writableProbe writes and removes a small file in each path, catching
+// filesystems that fail writes without being listed as read-only. The paths
+// are the mount points of the host as seen by bs, so they must be mounted as
+// volumes inside the bs container.
+type writableProbe struct {
+	paths   []string
+	timeout time.Duration
+	mu      sync.Mutex
+	// pending holds the result of writes not finished in time, so a hung
+	// filesystem doesn't pile up goroutines.
+	pending  map[string]chan error
+	writable map[string]bool
+}
+
+func (p *writableProbe) Name() string {
+	return writableProbeName
+}
+
+func (p *writableProbe) Check() error {
+	var failures []string
+	writable := make(map[string]bool, len(p.paths))
+	for _, path := range p.paths {
+		err := p.checkPath(path)
+		writable[path] = err == nil
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%s)", path, err))
+		}
+	}
+	p.mu.Lock()
+	p.writable = writable
+	p.mu.Unlock()
+	if len(failures) > 0 {
+		return fmt.Errorf("unable to write to %s", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+func (p *writableProbe) checkPath(path string) error {
+	p.mu.Lock()
+	if p.pending == nil {
+		p.pending = make(map[string]chan error)
+	}
+	errCh := p.pending[path]
+	if errCh == nil {
+		errCh = make(chan error, 1)
+		p.pending[path] = errCh
+		go func() {
+			errCh <- writeCheckFile(filepath.Join(path, writableCheckFile))
+		}()
+	}
+	p.mu.Unlock()
+	select {
+	case err := <-errCh:
+		p.mu.Lock()
+		delete(p.pending, path)
+		p.mu.Unlock()
+		return err
+	case <-time.After(p.timeout):
+		return fmt.Errorf("write took more than %v", p.timeout)
+	}
+}
+
+func writeCheckFile(name string) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte("ok"))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// Metrics returns a 0/1 metric for each path, 1 when the path was writable in
+// the last check, named after the path, like fs_writable_var_lib_docker for
+// /var/lib/docker and fs_writable_root for /.
+func (p *writableProbe) Metrics() map[string]metric.Metric {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	metrics := make(map[string]metric.Metric, len(p.writable))
+	for path, writable := range p.writable {
+		var value int64
+		if writable {
+			value = 1
+		}
+		metrics[writableMetricName(path)] = metric.Int(value)
+	}
+	return metrics
+}
+
+func writableMetricName(path string) string {
+	name := strings.Trim(filepath.ToSlash(path), "/")
+	if name == "" {
+		return "fs_writable_root"
+	}
+	return "fs_writable_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// portConflictProbe finds published ports of running containers bound by
+// more than one container in overlapping addresses, which Docker accepts in
+// some setups, like with the userland proxy disabled, leaving one of the
+// containers silently unreachable.
+type portConflictProbe struct {
+	list func() ([]docker.APIContainers, error)
+}
+
+func (p *portConflictProbe) Name() string {
+	return portProbeName
+}
+
+func (p *portConflictProbe) Check() error {
+	containers, err := p.list()
+	if err != nil {
+		return fmt.Errorf("unable to list containers: %s", err)
+	}
+	type binding struct {
+		container string
+		ip        string
+	}
+	bindings := make(map[string][]binding)
+	for _, c := range containers {
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		for _, port := range c.Ports {
+			if port.PublicPort == 0 {
+				continue
+			}
+			key := fmt.Sprintf("%d/%s", port.PublicPort, port.Type)
+			bindings[key] = append(bindings[key], binding{container: name, ip: port.IP})
+		}
+	}
+	var conflicts []string
+	for key, list := range bindings {
+		conflicting := make(map[string]struct{})
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				if list[i].container != list[j].container && overlappingIPs(list[i].ip, list[j].ip) {
+					conflicting[list[i].container] = struct{}{}
+					conflicting[list[j].container] = struct{}{}
+				}
+			}
+		}
+		if len(conflicting) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(conflicting))
+		for name := range conflicting {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		conflicts = append(conflicts, fmt.Sprintf("%s (%s)", key, strings.Join(names, ", ")))
+	}
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("ports published by more than one container: %s", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// overlappingIPs returns whether ports bound to both addresses conflict,
+// which happens when they're the same address or either one is a wildcard.
+func overlappingIPs(a, b string) bool {
+	isWildcard := func(ip string) bool {
+		return ip == "" || ip == "0.0.0.0" || ip == "::"
+	}
+	return a == b || isWildcard(a) || isWildcard(b)
+}
+
+// dockerProbe checks whether the Docker daemon answers in a timely manner.
+type dockerProbe struct {
+	client  *docker.Client
+	timeout time.Duration
+}
+
+func (p *dockerProbe) Name() string {
+	return dockerProbeName
+}
+
+func (p *dockerProbe) Check() error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- p.client.Ping()
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return fmt.Errorf("docker ping failed: %s", err)
+		}
+		return nil
+	case <-time.After(p.timeout):
+		return fmt.Errorf("docker ping took more than %v", p.timeout)
+	}
+}
+
+// zombieProbe counts zombie processes left behind by the Docker daemon and
+// its shims, a sign of a daemon in trouble reaping its children.
+type zombieProbe struct {
+	procPath string
+	max      int
+}
+
+func (p *zombieProbe) Name() string {
+	return zombieProbeName
+}
+
+type procStat struct {
+	comm  string
+	state string
+	ppid  int
+}
+
+func readProcStat(path string) (procStat, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return procStat{}, err
+	}
+	// The command name is enclosed in parenthesis and may contain spaces.
+	start := bytes.IndexByte(data, '(')
+	end := bytes.LastIndexByte(data, ')')
+	if start == -1 || end < start {
+		return procStat{}, fmt.Errorf("invalid stat file %q", path)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 2 {
+		return procStat{}, fmt.Errorf("invalid stat file %q", path)
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, err
+	}
+	return procStat{comm: string(data[start+1 : end]), state: fields[0], ppid: ppid}, nil
+}
+
+func isDockerProcess(comm string) bool {
+	return strings.HasPrefix(comm, "dockerd") ||
+		strings.HasPrefix(comm, "docker-containe") ||
+		strings.HasPrefix(comm, "containerd")
+}
+
+func (p *zombieProbe) Check() error {
+	dirs, err := filepath.Glob(filepath.Join(p.procPath, "[0-9]*"))
+	if err != nil {
+		return err
+	}
+	stats := make(map[int]procStat, len(dirs))
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		st, err := readProcStat(filepath.Join(dir, "stat"))
+		if err != nil {
+			// Processes may finish while we're reading them.
+			continue
+		}
+		stats[pid] = st
+	}
+	var zombies int
+	for _, st := range stats {
+		if st.state != "Z" {
+			continue
+		}
+		if parent, ok := stats[st.ppid]; ok && isDockerProcess(parent.comm) {
+			zombies++
+		}
+	}
+	if zombies > p.max {
+		return fmt.Errorf("%d zombie processes from docker daemon, max allowed is %d", zombies, p.max)
+	}
+	return nil
+}
+
+// conntrackProbe checks whether the conntrack table is close to its limit,
+// after which the kernel starts dropping new connections.
+type conntrackProbe struct {
+	procPath  string
+	threshold int
+}
+
+func (p *conntrackProbe) Name() string {
+	return conntrackProbeName
+}
+
+func readIntFile(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (p *conntrackProbe) Check() error {
+	base := filepath.Join(p.procPath, "sys", "net", "netfilter")
+	count, err := readIntFile(filepath.Join(base, "nf_conntrack_count"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// conntrack module not loaded, there's no table to fill.
+			return nil
+		}
+		return err
+	}
+	max, err := readIntFile(filepath.Join(base, "nf_conntrack_max"))
+	if err != nil {
+		return err
+	}
+	if max > 0 && count*100 >= max*int64(p.threshold) {
+		return fmt.Errorf("conntrack table usage is %d of %d entries", count, max)
+	}
+	return nil
+}
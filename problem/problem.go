@@ -0,0 +1,243 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package problem implements a node problem detector, periodically running
+// probes that look for common node failures and keeping their last results
+// to be reported as metrics and status events.
+package problem
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/supervisor"
+)
+
+// defaultProbes are the probes enabled when NODE_PROBLEM_PROBES isn't set.
+var defaultProbes = []string{
+	readOnlyProbeName,
+	dockerProbeName,
+	clockProbeName,
+	zombieProbeName,
+	conntrackProbeName,
+	writableProbeName,
+	portProbeName,
+}
+
+var defaultMounts = []string{"/"}
+
+func init() {
+	config.DeclareDefault("NODE_PROBLEM_PROBES", defaultProbes)
+	config.DeclareDefault("NODE_PROBLEM_MOUNTS", defaultMounts)
+	config.DeclareDefault("NODE_PROBLEM_DOCKER_TIMEOUT", defaultDockerProbeTimeout.Seconds())
+	config.DeclareDefault("NODE_PROBLEM_MAX_ZOMBIES", defaultMaxZombies)
+	config.DeclareDefault("NODE_PROBLEM_CONNTRACK_THRESHOLD", defaultConntrackThreshold)
+	config.DeclareDefault("NODE_PROBLEM_WRITE_TIMEOUT", defaultWriteTimeout.Seconds())
+}
+
+// Probe looks for a single kind of problem in the node. Check returns a non
+// nil error describing the problem when one is found.
+type Probe interface {
+	Name() string
+	Check() error
+}
+
+// metricsProbe is a probe reporting host metrics besides its 0/1 problem
+// metric.
+type metricsProbe interface {
+	Metrics() map[string]metric.Metric
+}
+
+// Problem is the last result of a probe.
+type Problem struct {
+	Name    string
+	Failing bool
+	Message string
+	Since   time.Time
+}
+
+type Detector struct {
+	probes   []Probe
+	interval time.Duration
+	mu       sync.RWMutex
+	results  map[string]Problem
+	abort    chan struct{}
+	exit     chan struct{}
+}
+
+// NewDetector returns a detector running the probes enabled in the
+// environment. The dockerClient may be nil, disabling Docker related probes.
+func NewDetector(dockerClient *docker.Client, interval time.Duration) *Detector {
+	procPath := config.StringEnvOrDefault(config.DefaultHostProc, "HOST_PROC")
+	enabled := config.StringsEnvOrDefault(defaultProbes, "NODE_PROBLEM_PROBES")
+	available := map[string]func() Probe{
+		readOnlyProbeName: func() Probe {
+			return &readOnlyProbe{
+				procPath: procPath,
+				mounts:   config.StringsEnvOrDefault(defaultMounts, "NODE_PROBLEM_MOUNTS"),
+			}
+		},
+		dockerProbeName: func() Probe {
+			if dockerClient == nil {
+				return nil
+			}
+			return &dockerProbe{
+				client:  dockerClient,
+				timeout: config.SecondsEnvOrDefault(defaultDockerProbeTimeout.Seconds(), "NODE_PROBLEM_DOCKER_TIMEOUT"),
+			}
+		},
+		clockProbeName: func() Probe {
+			return &clockProbe{}
+		},
+		zombieProbeName: func() Probe {
+			return &zombieProbe{
+				procPath: procPath,
+				max:      config.IntEnvOrDefault(defaultMaxZombies, "NODE_PROBLEM_MAX_ZOMBIES"),
+			}
+		},
+		conntrackProbeName: func() Probe {
+			return &conntrackProbe{
+				procPath:  procPath,
+				threshold: config.IntEnvOrDefault(defaultConntrackThreshold, "NODE_PROBLEM_CONNTRACK_THRESHOLD"),
+			}
+		},
+		portProbeName: func() Probe {
+			if dockerClient == nil {
+				return nil
+			}
+			return &portConflictProbe{list: func() ([]docker.APIContainers, error) {
+				return dockerClient.ListContainers(docker.ListContainersOptions{})
+			}}
+		},
+		writableProbeName: func() Probe {
+			paths := config.StringsEnvOrDefault(nil, "NODE_PROBLEM_WRITABLE_PATHS")
+			if len(paths) == 0 {
+				return nil
+			}
+			return &writableProbe{
+				paths:   paths,
+				timeout: config.SecondsEnvOrDefault(defaultWriteTimeout.Seconds(), "NODE_PROBLEM_WRITE_TIMEOUT"),
+			}
+		},
+	}
+	var probes []Probe
+	for _, name := range enabled {
+		constructor := available[name]
+		if constructor == nil {
+			bslog.Warnf("[node problem] unknown probe %q", name)
+			continue
+		}
+		if p := constructor(); p != nil {
+			probes = append(probes, p)
+		}
+	}
+	return newDetector(probes, interval)
+}
+
+func newDetector(probes []Probe, interval time.Duration) *Detector {
+	return &Detector{
+		probes:   probes,
+		interval: interval,
+		results:  make(map[string]Problem),
+		abort:    make(chan struct{}),
+		exit:     make(chan struct{}),
+	}
+}
+
+// Start runs the probes periodically until Stop is called.
+func (d *Detector) Start() {
+	supervisor.Go("node problem detector", func() {
+		for {
+			d.Run()
+			select {
+			case <-d.abort:
+				close(d.exit)
+				return
+			case <-time.After(d.interval):
+			}
+		}
+	})
+}
+
+// Stop stops the detector, blocking until it actually stops.
+func (d *Detector) Stop() {
+	close(d.abort)
+	<-d.exit
+}
+
+// Wait blocks until the detector stops.
+func (d *Detector) Wait() {
+	<-d.exit
+}
+
+// Run runs every probe once, storing and returning their results.
+func (d *Detector) Run() []Problem {
+	now := time.Now()
+	for _, p := range d.probes {
+		err := p.Check()
+		name := p.Name()
+		d.mu.Lock()
+		last, ok := d.results[name]
+		result := Problem{Name: name, Failing: err != nil, Since: now}
+		if err != nil {
+			result.Message = err.Error()
+		}
+		if ok && last.Failing == result.Failing {
+			result.Since = last.Since
+		}
+		d.results[name] = result
+		d.mu.Unlock()
+		if err != nil && (!ok || !last.Failing) {
+			bslog.Warnf("[node problem] %s: %s", name, err)
+		} else if err == nil && ok && last.Failing {
+			bslog.Warnf("[node problem] %s: problem solved", name)
+		}
+	}
+	return d.Problems()
+}
+
+// Problems returns the last result of each probe, sorted by name.
+func (d *Detector) Problems() []Problem {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	problems := make([]Problem, 0, len(d.results))
+	for _, p := range d.results {
+		problems = append(problems, p)
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return problems[i].Name < problems[j].Name
+	})
+	return problems
+}
+
+// HostMetrics returns a 0/1 metric for each probe, named after the probe
+// with a problem_ prefix, the total number of problems found and the metrics
+// reported by the probes themselves.
+func (d *Detector) HostMetrics() map[string]metric.Metric {
+	problems := d.Problems()
+	metrics := make(map[string]metric.Metric, len(problems)+1)
+	var total int64
+	for _, p := range problems {
+		var value int64
+		if p.Failing {
+			value = 1
+			total++
+		}
+		metrics["problem_"+p.Name] = metric.Int(value)
+	}
+	metrics["problems"] = metric.Int(total)
+	for _, p := range d.probes {
+		if mp, ok := p.(metricsProbe); ok {
+			for k, v := range mp.Metrics() {
+				metrics[k] = v
+			}
+		}
+	}
+	return metrics
+}
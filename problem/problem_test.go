@@ -0,0 +1,218 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package problem
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	procPath string
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.procPath = c.MkDir()
+}
+
+func (s *S) writeProcFile(c *check.C, path, content string) {
+	path = filepath.Join(s.procPath, path)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(path, []byte(content), 0644)
+	c.Assert(err, check.IsNil)
+}
+
+type fakeProbe struct {
+	name string
+	err  error
+}
+
+func (p *fakeProbe) Name() string {
+	return p.name
+}
+
+func (p *fakeProbe) Check() error {
+	return p.err
+}
+
+func (s *S) TestDetectorRun(c *check.C) {
+	failing := &fakeProbe{name: "b", err: errors.New("something is wrong")}
+	detector := newDetector([]Probe{failing, &fakeProbe{name: "a"}}, 0)
+	problems := detector.Run()
+	c.Assert(problems, check.HasLen, 2)
+	c.Assert(problems[0].Name, check.Equals, "a")
+	c.Assert(problems[0].Failing, check.Equals, false)
+	c.Assert(problems[1].Name, check.Equals, "b")
+	c.Assert(problems[1].Failing, check.Equals, true)
+	c.Assert(problems[1].Message, check.Equals, "something is wrong")
+	since := problems[1].Since
+	problems = detector.Run()
+	c.Assert(problems[1].Since, check.Equals, since)
+	failing.err = nil
+	problems = detector.Run()
+	c.Assert(problems[1].Failing, check.Equals, false)
+	c.Assert(problems[1].Message, check.Equals, "")
+	c.Assert(problems[1].Since.After(since), check.Equals, true)
+}
+
+func (s *S) TestDetectorHostMetrics(c *check.C) {
+	detector := newDetector([]Probe{
+		&fakeProbe{name: "a", err: errors.New("fail")},
+		&fakeProbe{name: "b"},
+		&fakeProbe{name: "c", err: errors.New("fail")},
+	}, 0)
+	detector.Run()
+	c.Assert(detector.HostMetrics(), check.DeepEquals, map[string]metric.Metric{
+		"problem_a": metric.Int(1),
+		"problem_b": metric.Int(0),
+		"problem_c": metric.Int(1),
+		"problems":  metric.Int(2),
+	})
+}
+
+func (s *S) TestDetectorStartStop(c *check.C) {
+	detector := newDetector([]Probe{&fakeProbe{name: "a"}}, 0)
+	detector.Start()
+	detector.Stop()
+	c.Assert(detector.Problems(), check.HasLen, 1)
+}
+
+func (s *S) TestReadOnlyProbe(c *check.C) {
+	s.writeProcFile(c, "1/mounts", `/dev/sda1 / ext4 rw,relatime,data=ordered 0 0
+/dev/sdb1 /var/lib/docker ext4 ro,relatime 0 0
+/dev/sdc1 /mnt ext4 ro,relatime 0 0
+`)
+	probe := &readOnlyProbe{procPath: s.procPath, mounts: []string{"/"}}
+	c.Assert(probe.Check(), check.IsNil)
+	probe.mounts = []string{"/", "/var/lib/docker"}
+	err := probe.Check()
+	c.Assert(err, check.ErrorMatches, "read-only mount points: /var/lib/docker")
+}
+
+func (s *S) TestZombieProbe(c *check.C) {
+	s.writeProcFile(c, "10/stat", "10 (dockerd) S 1 10 10 0 -1")
+	s.writeProcFile(c, "20/stat", "20 (sh) Z 10 20 20 0 -1")
+	s.writeProcFile(c, "21/stat", "21 (my app) Z 10 21 21 0 -1")
+	s.writeProcFile(c, "30/stat", "30 (bash) S 1 30 30 0 -1")
+	s.writeProcFile(c, "31/stat", "31 (sleep) Z 30 31 31 0 -1")
+	probe := &zombieProbe{procPath: s.procPath, max: 2}
+	c.Assert(probe.Check(), check.IsNil)
+	probe.max = 1
+	err := probe.Check()
+	c.Assert(err, check.ErrorMatches, "2 zombie processes from docker daemon, max allowed is 1")
+}
+
+func (s *S) TestConntrackProbe(c *check.C) {
+	probe := &conntrackProbe{procPath: s.procPath, threshold: 90}
+	c.Assert(probe.Check(), check.IsNil)
+	s.writeProcFile(c, "sys/net/netfilter/nf_conntrack_max", "1000\n")
+	s.writeProcFile(c, "sys/net/netfilter/nf_conntrack_count", "899\n")
+	c.Assert(probe.Check(), check.IsNil)
+	s.writeProcFile(c, "sys/net/netfilter/nf_conntrack_count", "900\n")
+	err := probe.Check()
+	c.Assert(err, check.ErrorMatches, "conntrack table usage is 900 of 1000 entries")
+}
+
+func (s *S) TestWritableProbe(c *check.C) {
+	dir := c.MkDir()
+	probe := &writableProbe{paths: []string{dir}, timeout: time.Second}
+	c.Assert(probe.Check(), check.IsNil)
+	_, err := os.Stat(filepath.Join(dir, writableCheckFile))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	c.Assert(probe.Metrics(), check.DeepEquals, map[string]metric.Metric{
+		writableMetricName(dir): metric.Int(1),
+	})
+	missing := filepath.Join(dir, "missing")
+	probe.paths = []string{dir, missing}
+	err = probe.Check()
+	c.Assert(err, check.ErrorMatches, "unable to write to "+regexp.QuoteMeta(missing)+" .*no such file or directory.*")
+	c.Assert(probe.Metrics(), check.DeepEquals, map[string]metric.Metric{
+		writableMetricName(dir):     metric.Int(1),
+		writableMetricName(missing): metric.Int(0),
+	})
+}
+
+func (s *S) TestWritableProbeTimeout(c *check.C) {
+	dir := c.MkDir()
+	errCh := make(chan error, 1)
+	probe := &writableProbe{
+		paths:   []string{dir},
+		timeout: 10 * time.Millisecond,
+		pending: map[string]chan error{dir: errCh},
+	}
+	err := probe.Check()
+	c.Assert(err, check.ErrorMatches, "unable to write to .* \\(write took more than 10ms\\)")
+	c.Assert(probe.Metrics(), check.DeepEquals, map[string]metric.Metric{writableMetricName(dir): metric.Int(0)})
+	errCh <- nil
+	c.Assert(probe.Check(), check.IsNil)
+	c.Assert(probe.pending, check.HasLen, 0)
+}
+
+func (s *S) TestWritableMetricName(c *check.C) {
+	c.Assert(writableMetricName("/"), check.Equals, "fs_writable_root")
+	c.Assert(writableMetricName("/var/lib/docker/"), check.Equals, "fs_writable_var_lib_docker")
+	c.Assert(writableMetricName("/mnt/data-1"), check.Equals, "fs_writable_mnt_data_1")
+}
+
+func (s *S) TestDetectorHostMetricsFromProbes(c *check.C) {
+	probe := &writableProbe{paths: []string{c.MkDir()}, timeout: time.Second}
+	detector := newDetector([]Probe{probe}, 0)
+	detector.Run()
+	metrics := detector.HostMetrics()
+	c.Assert(metrics, check.HasLen, 3)
+	c.Assert(metrics["problem_unwritable_fs"], check.Equals, metric.Int(0))
+	c.Assert(metrics[writableMetricName(probe.paths[0])], check.Equals, metric.Int(1))
+}
+
+func (s *S) TestPortConflictProbe(c *check.C) {
+	containers := []docker.APIContainers{
+		{ID: "a1", Names: []string{"/app1"}, Ports: []docker.APIPort{
+			{PrivatePort: 8888, PublicPort: 32768, Type: "tcp", IP: "0.0.0.0"},
+			{PrivatePort: 8888, PublicPort: 32768, Type: "tcp", IP: "::"},
+		}},
+		{ID: "a2", Names: []string{"/app2"}, Ports: []docker.APIPort{
+			{PrivatePort: 8888, PublicPort: 32769, Type: "tcp", IP: "10.0.0.1"},
+			{PrivatePort: 53, PublicPort: 32768, Type: "udp", IP: "0.0.0.0"},
+			{PrivatePort: 9000, Type: "tcp"},
+		}},
+		{ID: "a3", Names: []string{"/app3"}, Ports: []docker.APIPort{
+			{PrivatePort: 8888, PublicPort: 32769, Type: "tcp", IP: "10.0.0.2"},
+		}},
+	}
+	probe := &portConflictProbe{list: func() ([]docker.APIContainers, error) {
+		return containers, nil
+	}}
+	c.Assert(probe.Check(), check.IsNil)
+	containers = append(containers,
+		docker.APIContainers{ID: "a4", Ports: []docker.APIPort{
+			{PrivatePort: 80, PublicPort: 32769, Type: "tcp", IP: "0.0.0.0"},
+		}},
+		docker.APIContainers{ID: "a5", Names: []string{"/app5"}, Ports: []docker.APIPort{
+			{PrivatePort: 80, PublicPort: 32768, Type: "tcp", IP: "127.0.0.1"},
+		}},
+	)
+	err := probe.Check()
+	c.Assert(err, check.ErrorMatches, `ports published by more than one container: 32768/tcp \(app1, app5\), 32769/tcp \(a4, app2, app3\)`)
+	probe.list = func() ([]docker.APIContainers, error) {
+		return nil, errors.New("docker down")
+	}
+	c.Assert(probe.Check(), check.ErrorMatches, "unable to list containers: docker down")
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package startup waits for the dependencies of bs, like the Docker daemon
+// and the tsuru API, to be available before its components are started,
+// keeping track of whether bs is ready.
+package startup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/tsuruapi"
+)
+
+const (
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Dependency is something bs needs to work. Check returns a non nil error
+// while the dependency isn't available.
+type Dependency struct {
+	Name  string
+	Check func() error
+}
+
+type Waiter struct {
+	// Timeout is the max time Wait blocks. With a zero timeout the
+	// dependencies are checked only once before Wait returns.
+	Timeout    time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	deps       []Dependency
+	mu         sync.Mutex
+	pending    map[string]string
+	checked    bool
+}
+
+// Add adds a dependency. It must be called before Wait.
+func (w *Waiter) Add(dep Dependency) {
+	w.deps = append(w.deps, dep)
+}
+
+// Wait checks the dependencies until all of them are available, backing off
+// between attempts, for at most Timeout. It returns an error listing the
+// dependencies still unavailable when the timeout expires, in which case they
+// keep being checked in background until available, so Ready eventually
+// reports bs as ready.
+func (w *Waiter) Wait() error {
+	minBackoff, maxBackoff := w.MinBackoff, w.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = DefaultMinBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	deadline := time.Now().Add(w.Timeout)
+	backoff := minBackoff
+	for {
+		if w.check() {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		bslog.Warnf("[startup] waiting for %s, retrying in %s", strings.Join(w.Pending(), ", "), backoff)
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	go func() {
+		for !w.check() {
+			time.Sleep(maxBackoff)
+		}
+		bslog.Infof("[startup] all dependencies available, bs is ready")
+	}()
+	return w.pendingError()
+}
+
+// check runs the checks of the pending dependencies, returning whether all
+// of them are available.
+func (w *Waiter) check() bool {
+	w.mu.Lock()
+	first := !w.checked
+	pending := w.pending
+	w.mu.Unlock()
+	result := make(map[string]string)
+	for _, dep := range w.deps {
+		if _, ok := pending[dep.Name]; !first && !ok {
+			continue
+		}
+		if err := dep.Check(); err != nil {
+			result[dep.Name] = err.Error()
+		}
+	}
+	w.mu.Lock()
+	w.pending = result
+	w.checked = true
+	w.mu.Unlock()
+	return len(result) == 0
+}
+
+// Pending returns the names of the dependencies not available in the last
+// check, sorted.
+func (w *Waiter) Pending() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	names := make([]string, 0, len(w.pending))
+	for name := range w.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (w *Waiter) pendingError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	msgs := make([]string, 0, len(w.pending))
+	for name, msg := range w.pending {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, msg))
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("dependencies unavailable after %s: %s", w.Timeout, strings.Join(msgs, "; "))
+}
+
+// Ready returns whether every dependency was available in the last check
+// and the errors of the unavailable ones, by name.
+func (w *Waiter) Ready() (bool, map[string]string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := make(map[string]string, len(w.pending))
+	for name, msg := range w.pending {
+		pending[name] = msg
+	}
+	return w.checked && len(pending) == 0, pending
+}
+
+// DockerDependency pings the Docker daemon.
+func DockerDependency(client *docker.Client) Dependency {
+	return Dependency{Name: "docker", Check: client.Ping}
+}
+
+// ProcDependency checks that the host proc filesystem is mounted in path.
+func ProcDependency(path string) Dependency {
+	return Dependency{Name: "proc", Check: func() error {
+		_, err := os.Stat(filepath.Join(path, "stat"))
+		return err
+	}}
+}
+
+// TsuruDependency checks that the tsuru API is answering requests.
+func TsuruDependency(client *tsuruapi.Client) Dependency {
+	return Dependency{Name: "tsuru", Check: func() error {
+		resp, err := client.Do("GET", "/healthcheck", nil, nil)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return errors.New("unexpected status " + resp.Status)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package startup
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/tsuruapi"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	logOutput lockedBuffer
+	oldLogger *log.Logger
+}
+
+// lockedBuffer is a buffer safe for the background checks to log to while
+// tests read it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.logOutput.Reset()
+	s.oldLogger = bslog.Logger
+	bslog.Logger = log.New(&s.logOutput, "", 0)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	bslog.Logger = s.oldLogger
+}
+
+// flakyCheck fails until it's called n times.
+type flakyCheck struct {
+	mu    sync.Mutex
+	calls int
+	n     int
+}
+
+func (f *flakyCheck) check() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.calls <= f.n {
+		return errors.New("not yet")
+	}
+	return nil
+}
+
+func (f *flakyCheck) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (s *S) TestWaitAvailable(c *check.C) {
+	w := Waiter{Timeout: time.Second}
+	ok := &flakyCheck{}
+	w.Add(Dependency{Name: "dep", Check: ok.check})
+	ready, _ := w.Ready()
+	c.Assert(ready, check.Equals, false)
+	err := w.Wait()
+	c.Assert(err, check.IsNil)
+	c.Assert(ok.count(), check.Equals, 1)
+	ready, pending := w.Ready()
+	c.Assert(ready, check.Equals, true)
+	c.Assert(pending, check.HasLen, 0)
+	c.Assert(s.logOutput.String(), check.Equals, "")
+}
+
+func (s *S) TestWaitRetriesWithBackoff(c *check.C) {
+	w := Waiter{Timeout: 5 * time.Second, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	ok := &flakyCheck{}
+	flaky := &flakyCheck{n: 3}
+	w.Add(Dependency{Name: "ok", Check: ok.check})
+	w.Add(Dependency{Name: "flaky", Check: flaky.check})
+	err := w.Wait()
+	c.Assert(err, check.IsNil)
+	c.Assert(flaky.count(), check.Equals, 4)
+	// available dependencies aren't checked again
+	c.Assert(ok.count(), check.Equals, 1)
+	c.Assert(s.logOutput.String(), check.Matches, `(?s).*\[startup\] waiting for flaky, retrying in 1ms.*retrying in 2ms.*retrying in 2ms.*`)
+	ready, _ := w.Ready()
+	c.Assert(ready, check.Equals, true)
+}
+
+func (s *S) TestWaitTimeout(c *check.C) {
+	w := Waiter{Timeout: 20 * time.Millisecond, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	down := &flakyCheck{n: 1 << 30}
+	late := &flakyCheck{n: 1 << 30}
+	w.Add(Dependency{Name: "down", Check: down.check})
+	w.Add(Dependency{Name: "late", Check: late.check})
+	w.Add(Dependency{Name: "ok", Check: (&flakyCheck{}).check})
+	start := time.Now()
+	err := w.Wait()
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+	c.Assert(err, check.ErrorMatches, `dependencies unavailable after 20ms: down: not yet; late: not yet`)
+	ready, pending := w.Ready()
+	c.Assert(ready, check.Equals, false)
+	c.Assert(pending, check.DeepEquals, map[string]string{"down": "not yet", "late": "not yet"})
+	c.Assert(w.Pending(), check.DeepEquals, []string{"down", "late"})
+	down.mu.Lock()
+	down.n = 0
+	down.mu.Unlock()
+	late.mu.Lock()
+	late.n = 0
+	late.mu.Unlock()
+	s.waitReady(c, &w)
+}
+
+func (s *S) TestWaitZeroTimeout(c *check.C) {
+	w := Waiter{MaxBackoff: time.Millisecond}
+	down := &flakyCheck{n: 1}
+	w.Add(Dependency{Name: "down", Check: down.check})
+	err := w.Wait()
+	c.Assert(err, check.ErrorMatches, `dependencies unavailable after 0s: down: not yet`)
+	s.waitReady(c, &w)
+	c.Assert(s.logOutput.String(), check.Not(check.Matches), `(?s).*waiting for.*`)
+}
+
+func (s *S) waitReady(c *check.C, w *Waiter) {
+	const readyMsg = "[INFO] [startup] all dependencies available, bs is ready"
+	timeout := time.After(5 * time.Second)
+	for {
+		ready, pending := w.Ready()
+		if ready && strings.Contains(s.logOutput.String(), readyMsg) {
+			c.Assert(pending, check.HasLen, 0)
+			return
+		}
+		select {
+		case <-timeout:
+			c.Fatal("timeout waiting for background checks")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func (s *S) TestDockerDependency(c *check.C) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	client, err := docker.NewClient(server.URL())
+	c.Assert(err, check.IsNil)
+	dep := DockerDependency(client)
+	c.Assert(dep.Name, check.Equals, "docker")
+	c.Assert(dep.Check(), check.IsNil)
+	server.Stop()
+	c.Assert(dep.Check(), check.NotNil)
+}
+
+func (s *S) TestProcDependency(c *check.C) {
+	dir, err := ioutil.TempDir("", "proc")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(dir)
+	dep := ProcDependency(dir)
+	c.Assert(dep.Name, check.Equals, "proc")
+	c.Assert(dep.Check(), check.NotNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "stat"), []byte("cpu 1 2 3"), 0644)
+	c.Assert(err, check.IsNil)
+	c.Assert(dep.Check(), check.IsNil)
+}
+
+func (s *S) TestTsuruDependency(c *check.C) {
+	status := http.StatusOK
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	dep := TsuruDependency(tsuruapi.NewClient(tsuruapi.Config{Endpoint: srv.URL}))
+	c.Assert(dep.Name, check.Equals, "tsuru")
+	c.Assert(dep.Check(), check.IsNil)
+	c.Assert(path, check.Equals, "/healthcheck")
+	status = http.StatusUnauthorized
+	c.Assert(dep.Check(), check.IsNil)
+	status = http.StatusInternalServerError
+	c.Assert(dep.Check(), check.ErrorMatches, "unexpected status 500 Internal Server Error")
+}
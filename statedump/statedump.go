@@ -0,0 +1,100 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package statedump writes the internal state of bs components, like queue
+// depths, cache sizes and the last errors of the backends, along with the
+// stacks of every goroutine, to debug instances that are running but not
+// delivering anything.
+package statedump
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+// Source is a bs component able to describe its internal state.
+type Source interface {
+	State() map[string]interface{}
+}
+
+type Dumper struct {
+	mu      sync.Mutex
+	names   []string
+	sources map[string]Source
+}
+
+type dump struct {
+	Time       time.Time                         `json:"time"`
+	Goroutines int                               `json:"goroutines"`
+	Memory     map[string]uint64                 `json:"memory"`
+	Components map[string]map[string]interface{} `json:"components"`
+}
+
+// Add adds a component to the dump.
+func (d *Dumper) Add(name string, source Source) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.sources == nil {
+		d.sources = make(map[string]Source)
+	}
+	if _, ok := d.sources[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.sources[name] = source
+}
+
+// Dump writes the state of every component as a JSON object, followed by
+// the stacks of every goroutine.
+func (d *Dumper) Dump(w io.Writer) error {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	state := dump{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		Memory: map[string]uint64{
+			"heap_alloc":   mem.HeapAlloc,
+			"heap_objects": mem.HeapObjects,
+			"sys":          mem.Sys,
+			"num_gc":       uint64(mem.NumGC),
+		},
+		Components: make(map[string]map[string]interface{}),
+	}
+	d.mu.Lock()
+	for _, name := range d.names {
+		state.Components[name] = d.sources[name].State()
+	}
+	d.mu.Unlock()
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "=== bs state dump ===\n%s\n=== goroutine stacks ===\n", data)
+	if err = pprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "=== end of bs state dump ===\n")
+	return err
+}
+
+// Notify writes a dump to w every time one of the signals is received.
+func (d *Dumper) Notify(w io.Writer, signals ...os.Signal) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, signals...)
+	go func() {
+		for range sigChan {
+			if err := d.Dump(w); err != nil {
+				bslog.Errorf("[statedump] unable to write state dump: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,54 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package statedump
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct{}
+
+type fakeSource map[string]interface{}
+
+func (f fakeSource) State() map[string]interface{} {
+	return f
+}
+
+func (s *S) TestDump(c *check.C) {
+	var d Dumper
+	d.Add("queue", fakeSource{"queued": 10})
+	d.Add("cache", fakeSource{"size": 2})
+	d.Add("queue", fakeSource{"queued": 20})
+	var buf bytes.Buffer
+	err := d.Dump(&buf)
+	c.Assert(err, check.IsNil)
+	output := buf.String()
+	c.Assert(strings.HasPrefix(output, "=== bs state dump ===\n"), check.Equals, true)
+	c.Assert(strings.HasSuffix(output, "=== end of bs state dump ===\n"), check.Equals, true)
+	parts := strings.SplitN(strings.TrimPrefix(output, "=== bs state dump ===\n"), "\n=== goroutine stacks ===\n", 2)
+	c.Assert(parts, check.HasLen, 2)
+	var state dump
+	err = json.Unmarshal([]byte(parts[0]), &state)
+	c.Assert(err, check.IsNil)
+	c.Assert(state.Components, check.DeepEquals, map[string]map[string]interface{}{
+		"queue": {"queued": float64(20)},
+		"cache": {"size": float64(2)},
+	})
+	c.Assert(state.Goroutines > 0, check.Equals, true)
+	c.Assert(state.Memory["heap_alloc"] > 0, check.Equals, true)
+	c.Assert(parts[1], check.Matches, `(?s)goroutine \d+ \[running\]:.*statedump\.\(\*Dumper\)\.Dump.*`)
+	c.Assert(d.names, check.DeepEquals, []string{"queue", "cache"})
+}
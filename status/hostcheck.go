@@ -10,15 +10,26 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"regexp"
 	"strings"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/cgroup"
 	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/netprobe"
 )
 
+const (
+	defaultRootPathOverride      = "/"
+	defaultContainerCheckMessage = "ok"
+)
+
+func init() {
+	config.DeclareDefault("HOSTCHECK_ROOT_PATH_OVERRIDE", defaultRootPathOverride)
+	config.DeclareDefault("HOSTCHECK_CONTAINER_MESSAGE", defaultContainerCheckMessage)
+}
+
 type hostCheck interface {
 	Run() error
 	Name() string
@@ -27,6 +38,8 @@ type hostCheck interface {
 
 type checkCollection struct {
 	checks          []hostCheck
+	clusterChecks   []hostCheck
+	leader          leaderElector
 	checksFilterSet map[string]struct{}
 	timeout         time.Duration
 	errChannels     map[string]chan error
@@ -38,13 +51,11 @@ type hostCheckResult struct {
 	Successful bool
 }
 
-var cgroupIDRegexp = regexp.MustCompile(`(?ms).*/([a-fA-F0-9]+?)$`)
-
 func NewCheckCollection(client *docker.Client) *checkCollection {
 	hostCheckTimeout := config.SecondsEnvOrDefault(0, "HOSTCHECK_TIMEOUT")
 	baseContainerName := config.StringEnvOrDefault("", "HOSTCHECK_BASE_CONTAINER_NAME")
-	rootPathOverride := config.StringEnvOrDefault("/", "HOSTCHECK_ROOT_PATH_OVERRIDE")
-	containerCheckMessage := config.StringEnvOrDefault("ok", "HOSTCHECK_CONTAINER_MESSAGE")
+	rootPathOverride := config.StringEnvOrDefault(defaultRootPathOverride, "HOSTCHECK_ROOT_PATH_OVERRIDE")
+	containerCheckMessage := config.StringEnvOrDefault(defaultContainerCheckMessage, "HOSTCHECK_CONTAINER_MESSAGE")
 	checksFilter := config.StringsEnvOrDefault(nil, "HOSTCHECK_KIND_FILTER")
 	var checksFilterSet map[string]struct{}
 	if len(checksFilter) > 0 {
@@ -66,12 +77,31 @@ func NewCheckCollection(client *docker.Client) *checkCollection {
 	for _, p := range extraPaths {
 		checkColl.checks = append(checkColl.checks, &writableCheck{path: p})
 	}
+	registryImage := config.StringEnvOrDefault("", "HOSTCHECK_REGISTRY_IMAGE")
+	if registryImage != "" {
+		checkColl.clusterChecks = append(checkColl.clusterChecks, &probeCheck{probe: &netprobe.RegistryProbe{
+			Image:    registryImage,
+			Username: config.StringEnvOrDefault("", "NETPROBE_REGISTRY_USERNAME"),
+			Password: config.StringEnvOrDefault("", "NETPROBE_REGISTRY_PASSWORD"),
+			Insecure: config.BoolEnvOrDefault(false, "NETPROBE_REGISTRY_INSECURE"),
+		}})
+	}
+	dnsNames := config.StringsEnvOrDefault(nil, "HOSTCHECK_DNS_NAMES")
+	for _, name := range dnsNames {
+		checkColl.clusterChecks = append(checkColl.clusterChecks, &probeCheck{probe: &netprobe.DNSProbe{Name: name}})
+	}
 	return checkColl
 }
 
+// Run runs every host check and, if this instance is the leader (or no
+// leader election is configured), every cluster check.
 func (c *checkCollection) Run() []hostCheckResult {
-	result := make([]hostCheckResult, 0, len(c.checks))
-	for _, check := range c.checks {
+	checks := c.checks
+	if len(c.clusterChecks) > 0 && (c.leader == nil || c.leader.IsLeader()) {
+		checks = append(checks[:len(checks):len(checks)], c.clusterChecks...)
+	}
+	result := make([]hostCheckResult, 0, len(checks))
+	for _, check := range checks {
 		if c.checksFilterSet != nil {
 			if _, inSet := c.checksFilterSet[check.Kind()]; !inSet {
 				continue
@@ -154,11 +184,11 @@ func parseContainerID(file string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	result := cgroupIDRegexp.FindSubmatch(data)
-	if len(result) != 2 {
+	id := cgroup.ContainerID(data)
+	if id == "" {
 		return "", fmt.Errorf("unable to parse container id from %q, returned data:\n%s", file, string(data))
 	}
-	return string(result[1]), nil
+	return id, nil
 }
 
 func (c *createContainerCheck) setBaseContainerID() error {
@@ -234,3 +264,20 @@ func (c *createContainerCheck) Run() error {
 	}
 	return nil
 }
+
+// probeCheck runs a network probe as a host check.
+type probeCheck struct {
+	probe netprobe.Probe
+}
+
+func (c *probeCheck) Kind() string {
+	return c.probe.Kind()
+}
+
+func (c *probeCheck) Name() string {
+	return fmt.Sprintf("%s-%s", c.probe.Kind(), c.probe.Target())
+}
+
+func (c *probeCheck) Run() error {
+	return c.probe.Run()
+}
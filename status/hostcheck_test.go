@@ -9,6 +9,7 @@ import (
 	"os"
 
 	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/bs/netprobe"
 	"gopkg.in/check.v1"
 )
 
@@ -179,6 +180,60 @@ func (s S) TestCheckCollectionRunTimeout(c *check.C) {
 	})
 }
 
+type fakeLeader bool
+
+func (l fakeLeader) IsLeader() bool {
+	return bool(l)
+}
+
+func (s S) TestNewCheckCollectionClusterChecks(c *check.C) {
+	os.Setenv("HOSTCHECK_REGISTRY_IMAGE", "registry.example.com/bs-probe:latest")
+	os.Setenv("HOSTCHECK_DNS_NAMES", "tsuru.example.com, registry.example.com")
+	os.Setenv("NETPROBE_REGISTRY_USERNAME", "user")
+	defer os.Unsetenv("HOSTCHECK_REGISTRY_IMAGE")
+	defer os.Unsetenv("HOSTCHECK_DNS_NAMES")
+	defer os.Unsetenv("NETPROBE_REGISTRY_USERNAME")
+	checkColl := NewCheckCollection(nil)
+	c.Assert(checkColl.clusterChecks, check.DeepEquals, []hostCheck{
+		&probeCheck{probe: &netprobe.RegistryProbe{Image: "registry.example.com/bs-probe:latest", Username: "user"}},
+		&probeCheck{probe: &netprobe.DNSProbe{Name: "tsuru.example.com"}},
+		&probeCheck{probe: &netprobe.DNSProbe{Name: "registry.example.com"}},
+	})
+	c.Assert(checkColl.clusterChecks[0].Name(), check.Equals, "registry-registry.example.com/bs-probe:latest")
+	c.Assert(checkColl.clusterChecks[0].Kind(), check.Equals, "registry")
+}
+
+func (s S) TestCheckCollectionRunClusterChecksLeader(c *check.C) {
+	tmpdir, err := ioutil.TempDir("", "hostcheck")
+	c.Assert(err, check.IsNil)
+	defer os.RemoveAll(tmpdir)
+	checkColl := &checkCollection{
+		checks:        []hostCheck{&writableCheck{path: tmpdir}},
+		clusterChecks: []hostCheck{&probeCheck{probe: &netprobe.DNSProbe{Name: "localhost"}}},
+		errChannels:   make(map[string]chan error),
+	}
+	results := checkColl.Run()
+	c.Assert(results, check.DeepEquals, []hostCheckResult{
+		{Name: "writablePath-" + tmpdir, Successful: true},
+		{Name: "dns-localhost", Successful: true},
+	})
+	checkColl.leader = fakeLeader(false)
+	results = checkColl.Run()
+	c.Assert(results, check.DeepEquals, []hostCheckResult{
+		{Name: "writablePath-" + tmpdir, Successful: true},
+	})
+	c.Assert(checkColl.checks, check.HasLen, 1)
+	checkColl.leader = fakeLeader(true)
+	results = checkColl.Run()
+	c.Assert(results, check.HasLen, 2)
+}
+
+func (s S) TestProbeCheckRunFailure(c *check.C) {
+	dnsCheck := &probeCheck{probe: &netprobe.DNSProbe{Name: "invalid.bs-test.invalid"}}
+	c.Assert(dnsCheck.Name(), check.Equals, "dns-invalid.bs-test.invalid")
+	c.Assert(dnsCheck.Run(), check.NotNil)
+}
+
 func (s S) TestParseContainerID(c *check.C) {
 	tests := []struct {
 		data     string
@@ -212,6 +267,13 @@ func (s S) TestParseContainerID(c *check.C) {
 2:cpuset:/docker/6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804`,
 			expected: "6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804",
 		},
+		{
+			data: `12:pids:/system.slice/docker-6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804.scope
+11:memory:/system.slice/docker-6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804.scope
+1:name=systemd:/system.slice/docker-6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804.scope
+0::/system.slice/docker-6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804.scope`,
+			expected: "6d52b4d36625e83be18320e0ce56304186e205334510131e14c6dc73526f5804",
+		},
 		{
 			data: `11:memory:/user.slice
 10:hugetlb:/
@@ -0,0 +1,183 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
+	"github.com/tsuru/bs/supervisor"
+)
+
+const (
+	defaultLeaderLockName = "bs-cluster-checks"
+	defaultLeaderLockTTL  = 5 * time.Minute
+	minLeaderLockTTL      = 5 * time.Second
+	leaderLockTimeout     = 10 * time.Second
+	leaderLockKeyPrefix   = "/v2/keys/bs/locks/"
+)
+
+func init() {
+	config.DeclareDefault("HOSTCHECK_LEADER_LOCK_NAME", defaultLeaderLockName)
+	config.DeclareDefault("HOSTCHECK_LEADER_LOCK_TTL", defaultLeaderLockTTL.Seconds())
+}
+
+type leaderElector interface {
+	IsLeader() bool
+}
+
+// newLeaderLock returns the leader lock configured through the environment,
+// or nil when leader election is disabled.
+func newLeaderLock(addrs []string) *etcdLeaderLock {
+	endpoints := config.StringsEnvOrDefault(nil, "HOSTCHECK_LEADER_ETCD_ENDPOINTS")
+	if len(endpoints) == 0 {
+		return nil
+	}
+	owner, _ := os.Hostname()
+	if len(addrs) > 0 {
+		owner = strings.Join(addrs, ",")
+	}
+	return &etcdLeaderLock{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: leaderLockTimeout},
+		name:      config.StringEnvOrDefault(defaultLeaderLockName, "HOSTCHECK_LEADER_LOCK_NAME"),
+		owner:     owner,
+		ttl:       leaderLockTTL(),
+	}
+}
+
+// leaderLockTTL returns the TTL of the lock, in whole seconds as etcd takes
+// it. TTLs shorter than minLeaderLockTTL would expire before being renewed
+// under any etcd latency, so the default is used instead.
+func leaderLockTTL() time.Duration {
+	ttl := config.SecondsEnvOrDefault(defaultLeaderLockTTL.Seconds(), "HOSTCHECK_LEADER_LOCK_TTL")
+	if ttl < minLeaderLockTTL {
+		bslog.Warnf("[leader lock] HOSTCHECK_LEADER_LOCK_TTL of %s is shorter than %s, using the default value of %s", ttl, minLeaderLockTTL, defaultLeaderLockTTL)
+		return defaultLeaderLockTTL
+	}
+	return ttl / time.Second * time.Second
+}
+
+// etcdLeaderLock elects a leader using a key with a TTL in etcd, through the
+// etcd v2 keys API. Every instance tries to create the key, holding its
+// identity, on every third of the TTL, so the lease is renewed before
+// expiring regardless of the status interval. When the key already exists,
+// its TTL is refreshed only if it holds the identity of this instance.
+type etcdLeaderLock struct {
+	endpoints []string
+	client    *http.Client
+	name      string
+	owner     string
+	ttl       time.Duration
+	mu        sync.Mutex
+	heldUntil time.Time
+}
+
+// start renews the lease right away and then on every third of its TTL,
+// until quit is closed.
+func (l *etcdLeaderLock) start(quit <-chan struct{}) {
+	supervisor.Go("leader lock", func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			l.renew()
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// IsLeader returns whether this instance holds a valid lease.
+func (l *etcdLeaderLock) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.heldUntil)
+}
+
+func (l *etcdLeaderLock) renew() {
+	acquired, err := l.acquire()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		// Keep the current lease while it's still valid so a transient etcd
+		// failure doesn't leave the cluster without a leader.
+		bslog.Errorf("[leader lock] unable to acquire lock %q: %s", l.name, err)
+		return
+	}
+	if !acquired {
+		l.heldUntil = time.Time{}
+		return
+	}
+	l.heldUntil = time.Now().Add(l.ttl)
+}
+
+// acquire creates the lock key, or refreshes it when it's already held by
+// this instance. etcd answers with 412 when the key exists or holds another
+// owner, and with 404 when it expired between both requests, in which case
+// it's created in the next renewal.
+func (l *etcdLeaderLock) acquire() (bool, error) {
+	status, err := l.put(url.Values{"prevExist": []string{"false"}})
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusCreated {
+		return true, nil
+	}
+	if status != http.StatusPreconditionFailed {
+		return false, fmt.Errorf("unexpected response from etcd creating the lock: %d", status)
+	}
+	status, err = l.put(url.Values{"prevValue": []string{l.owner}})
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusPreconditionFailed, http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected response from etcd renewing the lock: %d", status)
+}
+
+// put sets the lock key to the owner, with the lock TTL, under the given
+// conditions, trying each endpoint in order until one of them answers.
+func (l *etcdLeaderLock) put(conditions url.Values) (int, error) {
+	form := url.Values{
+		"value": []string{l.owner},
+		"ttl":   []string{strconv.Itoa(int(l.ttl / time.Second))},
+	}
+	var err error
+	for _, endpoint := range l.endpoints {
+		keyURL := strings.TrimRight(endpoint, "/") + leaderLockKeyPrefix + url.PathEscape(l.name) + "?" + conditions.Encode()
+		var req *http.Request
+		req, err = http.NewRequest("PUT", keyURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		var resp *http.Response
+		resp, err = l.client.Do(req)
+		if err != nil {
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	return 0, err
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+// fakeEtcd implements the compare and swap semantics of the etcd v2 keys API
+// used by the leader lock, ignoring TTLs, which expire keys only when
+// expire is called.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	values   map[string]string
+	ttls     map[string]string
+	failures int
+	requests int
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{values: make(map[string]string), ttls: make(map[string]string)}
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests++
+	if e.failures > 0 {
+		e.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if r.Method != "PUT" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	key := r.URL.Path
+	current, exists := e.values[key]
+	query := r.URL.Query()
+	switch {
+	case query.Get("prevExist") == "false" && exists:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	case query.Get("prevValue") != "" && !exists:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case query.Get("prevValue") != "" && query.Get("prevValue") != current:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	e.values[key] = r.PostForm.Get("value")
+	e.ttls[key] = r.PostForm.Get("ttl")
+	if exists {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (e *fakeEtcd) expire(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.values, key)
+}
+
+func newTestLeaderLock(endpoints []string, owner string, ttl time.Duration) *etcdLeaderLock {
+	return &etcdLeaderLock{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: time.Second},
+		name:      "mylock",
+		owner:     owner,
+		ttl:       ttl,
+	}
+}
+
+func (S) TestNewLeaderLockDisabled(c *check.C) {
+	os.Unsetenv("HOSTCHECK_LEADER_ETCD_ENDPOINTS")
+	c.Assert(newLeaderLock(nil), check.IsNil)
+}
+
+func (S) TestNewLeaderLock(c *check.C) {
+	os.Setenv("HOSTCHECK_LEADER_ETCD_ENDPOINTS", "http://etcd1:2379, http://etcd2:2379")
+	os.Setenv("HOSTCHECK_LEADER_LOCK_TTL", "30")
+	defer os.Unsetenv("HOSTCHECK_LEADER_ETCD_ENDPOINTS")
+	defer os.Unsetenv("HOSTCHECK_LEADER_LOCK_TTL")
+	lock := newLeaderLock([]string{"10.0.0.1", "172.17.0.1"})
+	c.Assert(lock, check.NotNil)
+	c.Assert(lock.client.Timeout, check.Equals, leaderLockTimeout)
+	lock.client = nil
+	c.Assert(lock, check.DeepEquals, &etcdLeaderLock{
+		endpoints: []string{"http://etcd1:2379", "http://etcd2:2379"},
+		name:      "bs-cluster-checks",
+		owner:     "10.0.0.1,172.17.0.1",
+		ttl:       30 * time.Second,
+	})
+}
+
+func (S) TestNewLeaderLockTTL(c *check.C) {
+	os.Setenv("HOSTCHECK_LEADER_ETCD_ENDPOINTS", "http://etcd1:2379")
+	defer os.Unsetenv("HOSTCHECK_LEADER_ETCD_ENDPOINTS")
+	defer os.Unsetenv("HOSTCHECK_LEADER_LOCK_TTL")
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", defaultLeaderLockTTL},
+		{"0", defaultLeaderLockTTL},
+		{"-10", defaultLeaderLockTTL},
+		{"0.5", defaultLeaderLockTTL},
+		{"4.9", defaultLeaderLockTTL},
+		{"5", 5 * time.Second},
+		{"10.7", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		os.Setenv("HOSTCHECK_LEADER_LOCK_TTL", tt.value)
+		lock := newLeaderLock(nil)
+		c.Check(lock.ttl, check.Equals, tt.expected, check.Commentf("%q", tt.value))
+	}
+}
+
+func (S) TestEtcdLeaderLock(c *check.C) {
+	etcd := newFakeEtcd()
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	first := newTestLeaderLock([]string{server.URL}, "10.0.0.1", time.Minute)
+	second := newTestLeaderLock([]string{server.URL}, "10.0.0.2", time.Minute)
+	c.Assert(first.IsLeader(), check.Equals, false)
+	first.renew()
+	c.Assert(first.IsLeader(), check.Equals, true)
+	c.Assert(etcd.values, check.DeepEquals, map[string]string{"/v2/keys/bs/locks/mylock": "10.0.0.1"})
+	c.Assert(etcd.ttls, check.DeepEquals, map[string]string{"/v2/keys/bs/locks/mylock": "60"})
+	second.renew()
+	c.Assert(second.IsLeader(), check.Equals, false)
+	first.renew()
+	c.Assert(first.IsLeader(), check.Equals, true)
+	etcd.failures = 1
+	first.renew()
+	c.Assert(first.IsLeader(), check.Equals, true)
+	etcd.expire("/v2/keys/bs/locks/mylock")
+	second.renew()
+	c.Assert(second.IsLeader(), check.Equals, true)
+	first.renew()
+	c.Assert(first.IsLeader(), check.Equals, false)
+}
+
+func (S) TestEtcdLeaderLockTriesNextEndpoint(c *check.C) {
+	etcd := newFakeEtcd()
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	lock := newTestLeaderLock([]string{down.URL, server.URL + "/"}, "10.0.0.1", time.Minute)
+	lock.renew()
+	c.Assert(lock.IsLeader(), check.Equals, true)
+	c.Assert(etcd.values["/v2/keys/bs/locks/mylock"], check.Equals, "10.0.0.1")
+}
+
+func (S) TestEtcdLeaderLockRenewsOnTicker(c *check.C) {
+	etcd := newFakeEtcd()
+	server := httptest.NewServer(etcd)
+	defer server.Close()
+	lock := newTestLeaderLock([]string{server.URL}, "10.0.0.1", 30*time.Millisecond)
+	quit := make(chan struct{})
+	defer close(quit)
+	lock.start(quit)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		etcd.mu.Lock()
+		requests := etcd.requests
+		etcd.mu.Unlock()
+		if requests >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			c.Fatalf("timeout waiting for renewals, got %d requests", requests)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package status
+
+import (
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+// timestampHeader carries the time a status report was collected, allowing
+// tsuru to process reports sent again after a failure idempotently.
+const timestampHeader = "X-Tsuru-Status-Timestamp"
+
+const maxRetryBackoff = 10 * time.Minute
+
+// pendingReport is a status report that failed to be sent, kept to be sent
+// again with the time it was originally collected.
+type pendingReport struct {
+	data      *hostStatus
+	timestamp time.Time
+}
+
+// retryPending sends the reports queued after previous failures, oldest
+// first, and returns whether the queue is empty. Sending stops at the first
+// failure, delaying the next attempt with exponential backoff.
+func (r *Reporter) retryPending(now time.Time) bool {
+	if len(r.pending) == 0 {
+		return true
+	}
+	if now.Before(r.nextRetry) {
+		return false
+	}
+	for len(r.pending) > 0 {
+		report := r.pending[0]
+		if !r.sendStatus(report.data, report.timestamp) {
+			r.scheduleRetry(now)
+			return false
+		}
+		r.pending[0] = pendingReport{}
+		r.pending = r.pending[1:]
+	}
+	r.retryAttempt = 0
+	return true
+}
+
+// enqueue keeps a report to be sent again later, dropping the oldest one when
+// the queue is full. It returns false when the queue is disabled.
+func (r *Reporter) enqueue(data *hostStatus, timestamp time.Time) bool {
+	size := r.config.RetryQueueSize
+	if size <= 0 {
+		return false
+	}
+	r.pending = append(r.pending, pendingReport{data: data, timestamp: timestamp})
+	if dropped := len(r.pending) - size; dropped > 0 {
+		bslog.Warnf("[status reporter] retry queue is full, dropping %d status reports", dropped)
+		r.pending = append([]pendingReport(nil), r.pending[dropped:]...)
+		// forces the next differential report to be a full sync, replacing
+		// the dropped statuses.
+		r.lastSync = time.Time{}
+	}
+	return true
+}
+
+func (r *Reporter) scheduleRetry(now time.Time) {
+	delay := r.config.Interval << uint(r.retryAttempt)
+	if delay <= 0 || delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	r.nextRetry = now.Add(delay)
+	r.retryAttempt++
+}
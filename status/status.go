@@ -6,11 +6,11 @@ package status
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -19,8 +19,13 @@ import (
 	"github.com/ajg/form"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
 	"github.com/tsuru/bs/container"
+	"github.com/tsuru/bs/jitter"
 	node "github.com/tsuru/bs/node"
+	"github.com/tsuru/bs/problem"
+	"github.com/tsuru/bs/supervisor"
+	"github.com/tsuru/bs/tsuruapi"
 	"github.com/tsuru/tsuru/provision"
 )
 
@@ -40,6 +45,29 @@ type ReporterConfig struct {
 	DockerEndpoint string
 	TsuruEndpoint  string
 	TsuruToken     string
+	// TsuruClient is the client used to talk to the tsuru API. If nil, a new
+	// client is created using TsuruEndpoint, TsuruToken and default settings.
+	TsuruClient *tsuruapi.Client
+	// Compress enables gzip compression of the payloads sent to tsuru.
+	Compress bool
+	// ChunkSize is the max number of containers sent in each request. Zero
+	// sends every container in a single request.
+	ChunkSize int
+	// Differential makes the reporter send only containers whose status
+	// changed since the last successful report. Every container is still
+	// sent once every FullSyncInterval.
+	Differential     bool
+	FullSyncInterval time.Duration
+	// Jitter is the max random delay added to each interval.
+	Jitter time.Duration
+	// Problems, when set, has its last results reported as host checks.
+	Problems *problem.Detector
+	// RetryQueueSize is the max number of failed reports kept to be sent
+	// again, with backoff, in the next intervals. Zero discards failed
+	// reports.
+	RetryQueueSize int
+	// Drift, when set, is sent along with every report.
+	Drift *config.Drift
 }
 
 type Reporter struct {
@@ -49,21 +77,27 @@ type Reporter struct {
 	checks     *checkCollection
 	addrs      []string
 	infoClient *container.InfoClient
-	httpClient *http.Client
+	client     *tsuruapi.Client
 	mu         sync.Mutex
 	removeMap  map[string]chan struct{}
+	lastStatus map[string]string
+	lastSync   time.Time
+
+	pending      []pendingReport
+	retryAttempt int
+	nextRetry    time.Time
 }
 
 type hostStatus struct {
 	Addrs  []string
 	Units  []containerStatus
 	Checks []hostCheckResult
+	// Config identifies the bs configuration, so drift across the nodes
+	// and restarts can be spotted by tsuru.
+	Config *config.Drift `form:",omitempty" json:",omitempty"`
 }
 
-const (
-	dialTimeout = 10 * time.Second
-	fullTimeout = 1 * time.Minute
-)
+const defaultFullSyncIntervals = 10
 
 var errRouteNotFound = errors.New("route not found")
 
@@ -74,6 +108,9 @@ func NewReporter(config *ReporterConfig) (*Reporter, error) {
 	if config.TsuruEndpoint == "" {
 		return nil, errors.New("tsuru endpoint must be set for status reporting")
 	}
+	if config.Differential && config.FullSyncInterval <= 0 {
+		config.FullSyncInterval = config.Interval * defaultFullSyncIntervals
+	}
 	abort := make(chan struct{})
 	exit := make(chan struct{})
 	infoClient, err := container.NewClient(config.DockerEndpoint)
@@ -85,12 +122,16 @@ func NewReporter(config *ReporterConfig) (*Reporter, error) {
 	if err != nil {
 		return nil, fmt.Errorf("[status reporter] unable to get network addresses: %s", err)
 	}
-	transport := http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   dialTimeout,
-			KeepAlive: 30 * time.Second,
-		}).Dial,
-		TLSHandshakeTimeout: dialTimeout,
+	client := config.TsuruClient
+	if client == nil {
+		client = tsuruapi.NewClient(tsuruapi.Config{
+			Endpoint: config.TsuruEndpoint,
+			Token:    config.TsuruToken,
+		})
+	}
+	lock := newLeaderLock(addrs)
+	if lock != nil {
+		checks.leader = lock
 	}
 	reporter := Reporter{
 		config:     config,
@@ -99,23 +140,23 @@ func NewReporter(config *ReporterConfig) (*Reporter, error) {
 		infoClient: infoClient,
 		checks:     checks,
 		addrs:      addrs,
-		httpClient: &http.Client{
-			Transport: &transport,
-			Timeout:   fullTimeout,
-		},
-		removeMap: make(map[string]chan struct{}),
+		client:     client,
+		removeMap:  make(map[string]chan struct{}),
+	}
+	if lock != nil {
+		lock.start(abort)
 	}
-	go func(abort <-chan struct{}) {
+	supervisor.Go("status reporter", func() {
 		for {
 			reporter.reportStatus()
 			select {
 			case <-abort:
 				close(exit)
 				return
-			case <-time.After(reporter.config.Interval):
+			case <-time.After(jitter.Add(reporter.config.Interval, reporter.config.Jitter)):
 			}
 		}
-	}(abort)
+	})
 	return &reporter, nil
 }
 
@@ -140,24 +181,109 @@ func (r *Reporter) reportStatus() {
 		return
 	}
 	containerStatuses := r.retrieveContainerStatuses(containers)
-	hostChecks := r.checks.Run()
-	hostData := &hostStatus{
-		Addrs:  r.addrs,
-		Units:  containerStatuses,
-		Checks: hostChecks,
+	toReport, fullSync := r.changedStatuses(containerStatuses)
+	hostChecks := append(r.checks.Run(), r.problemChecks()...)
+	chunks := chunkStatuses(toReport, r.config.ChunkSize)
+	now := time.Now()
+	send := r.retryPending(now)
+	var failed int
+	for _, units := range chunks {
+		hostData := &hostStatus{
+			Addrs:  r.addrs,
+			Units:  units,
+			Checks: hostChecks,
+			Config: r.config.Drift,
+		}
+		if send && r.sendStatus(hostData, now) {
+			continue
+		}
+		if send && len(r.pending) == 0 {
+			r.scheduleRetry(now)
+		}
+		if !r.enqueue(hostData, now) {
+			failed++
+		}
+	}
+	if failed > 0 && len(chunks) > 1 {
+		bslog.Errorf("[status reporter] failed to report %d of %d status chunks", failed, len(chunks))
+	}
+	if failed == 0 {
+		r.markReported(containerStatuses, fullSync)
+	}
+}
+
+// changedStatuses returns the statuses that must be sent to tsuru and whether
+// they represent a full sync. Outside differential mode every status is always
+// reported.
+func (r *Reporter) changedStatuses(statuses []containerStatus) ([]containerStatus, bool) {
+	if !r.config.Differential || r.lastStatus == nil || time.Since(r.lastSync) >= r.config.FullSyncInterval {
+		return statuses, true
+	}
+	changed := make([]containerStatus, 0, len(statuses))
+	for _, st := range statuses {
+		if last, ok := r.lastStatus[st.ID]; !ok || last != st.Status {
+			changed = append(changed, st)
+		}
+	}
+	return changed, false
+}
+
+func (r *Reporter) markReported(statuses []containerStatus, fullSync bool) {
+	if !r.config.Differential {
+		return
 	}
-	resp, err := r.updateNode(hostData)
+	r.lastStatus = make(map[string]string, len(statuses))
+	for _, st := range statuses {
+		r.lastStatus[st.ID] = st.Status
+	}
+	if fullSync {
+		r.lastSync = time.Now()
+	}
+}
+
+func (r *Reporter) sendStatus(hostData *hostStatus, timestamp time.Time) bool {
+	resp, err := r.updateNode(hostData, timestamp)
 	if err == errRouteNotFound {
-		resp, err = r.updateUnits(hostData.Units)
+		resp, err = r.updateUnits(hostData.Units, timestamp)
 	}
 	if err != nil {
-		bslog.Errorf("[status reporter] failed to send data to the tsuru server at %q: %s", r.config.TsuruEndpoint, err)
-		return
+		bslog.Errorf("[status reporter] failed to send data to the tsuru server at %q: %s", r.client.Endpoint(), err)
+		return false
 	}
 	err = r.handleTsuruResponse(resp)
 	if err != nil {
 		bslog.Errorf("[status reporter] failed to handle tsuru response: %s", err)
+		return false
+	}
+	return true
+}
+
+func (r *Reporter) problemChecks() []hostCheckResult {
+	if r.config.Problems == nil {
+		return nil
+	}
+	problems := r.config.Problems.Problems()
+	result := make([]hostCheckResult, len(problems))
+	for i, p := range problems {
+		result[i] = hostCheckResult{
+			Name:       "problem-" + p.Name,
+			Err:        p.Message,
+			Successful: !p.Failing,
+		}
 	}
+	return result
+}
+
+func chunkStatuses(statuses []containerStatus, size int) [][]containerStatus {
+	if size <= 0 || len(statuses) <= size {
+		return [][]containerStatus{statuses}
+	}
+	chunks := make([][]containerStatus, 0, (len(statuses)+size-1)/size)
+	for len(statuses) > size {
+		chunks = append(chunks, statuses[:size])
+		statuses = statuses[size:]
+	}
+	return append(chunks, statuses)
 }
 
 func (r *Reporter) retrieveContainerStatuses(containers []docker.APIContainers) []containerStatus {
@@ -193,46 +319,59 @@ func (r *Reporter) retrieveContainerStatuses(containers []docker.APIContainers)
 	return statuses
 }
 
-func (r *Reporter) updateNode(payload *hostStatus) (*http.Response, error) {
+func (r *Reporter) updateNode(payload *hostStatus, timestamp time.Time) (*http.Response, error) {
 	bodyContent, err := form.EncodeToString(payload)
 	if err != nil {
 		return nil, err
 	}
-	url := fmt.Sprintf("%s/node/status", strings.TrimRight(r.config.TsuruEndpoint, "/"))
-	request, err := http.NewRequest("POST", url, strings.NewReader(bodyContent))
+	header := http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}}
+	header.Set(timestampHeader, timestamp.UTC().Format(time.RFC3339Nano))
+	body, err := r.encodeBody(header, []byte(bodyContent))
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	request.Header.Add("Authorization", "bearer "+r.config.TsuruToken)
-	resp, err := r.httpClient.Do(request)
+	resp, err := r.client.Do("POST", "/node/status", header, body)
 	if err != nil {
 		return nil, err
 	}
 	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
 		return nil, errRouteNotFound
 	}
 	return resp, nil
 }
 
-func (r *Reporter) updateUnits(payload []containerStatus) (*http.Response, error) {
+func (r *Reporter) updateUnits(payload []containerStatus, timestamp time.Time) (*http.Response, error) {
 	var body bytes.Buffer
 	err := json.NewEncoder(&body).Encode(payload)
 	if err != nil {
 		return nil, err
 	}
-	url := fmt.Sprintf("%s/units/status", strings.TrimRight(r.config.TsuruEndpoint, "/"))
-	request, err := http.NewRequest("POST", url, &body)
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	header.Set(timestampHeader, timestamp.UTC().Format(time.RFC3339Nano))
+	data, err := r.encodeBody(header, body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return r.client.Do("POST", "/units/status", header, data)
+}
+
+func (r *Reporter) encodeBody(header http.Header, data []byte) ([]byte, error) {
+	if !r.config.Compress {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("Content-Type", "application/json")
-	request.Header.Add("Authorization", "bearer "+r.config.TsuruToken)
-	resp, err := r.httpClient.Do(request)
+	err = writer.Close()
 	if err != nil {
 		return nil, err
 	}
-	return resp, err
+	header.Set("Content-Encoding", "gzip")
+	return buf.Bytes(), nil
 }
 
 func (r *Reporter) tryRemoveContainer(id string) {
@@ -6,6 +6,7 @@ package status
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -23,6 +24,7 @@ import (
 	"github.com/fsouza/go-dockerclient"
 	dtesting "github.com/fsouza/go-dockerclient/testing"
 	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/config"
 	"gopkg.in/check.v1"
 )
 
@@ -234,6 +236,256 @@ func (s S) TestReportStatusMultipleRemovals(c *check.C) {
 	c.Assert(apiContainers, check.HasLen, 0)
 }
 
+func (s S) TestReportStatusChunked(c *check.C) {
+	bogusContainers := []bogusContainer{
+		{name: "x1", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+		{name: "x2", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+		{name: "x3", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+	}
+	dockerServer, _ := s.startDockerServer(bogusContainers, nil, c)
+	defer dockerServer.Stop()
+	tsuruServer, requests := s.startTsuruServer(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewBufferString("[]")),
+		}
+	})
+	defer tsuruServer.Close()
+	reporter, err := NewReporter(&ReporterConfig{
+		Interval:       10 * time.Minute,
+		DockerEndpoint: dockerServer.URL(),
+		TsuruEndpoint:  tsuruServer.URL,
+		TsuruToken:     "some-token",
+		ChunkSize:      2,
+	})
+	c.Assert(err, check.IsNil)
+	reporter.Stop()
+	reporter.reportStatus()
+	var names []string
+	for i := 0; i < 2; i++ {
+		req := <-requests
+		c.Assert(req.request.URL.Path, check.Equals, "/node/status")
+		var input hostStatus
+		err = form.DecodeString(&input, string(req.body))
+		c.Assert(err, check.IsNil)
+		c.Assert(input.Checks, check.HasLen, 2)
+		for _, u := range input.Units {
+			names = append(names, u.Name)
+		}
+		if i == 0 {
+			c.Assert(input.Units, check.HasLen, 2)
+		}
+	}
+	sort.Strings(names)
+	c.Assert(names, check.DeepEquals, []string{"x1", "x2", "x3"})
+}
+
+func (s S) TestReportStatusCompressed(c *check.C) {
+	bogusContainers := []bogusContainer{
+		{name: "x1", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+	}
+	dockerServer, containers := s.startDockerServer(bogusContainers, nil, c)
+	defer dockerServer.Stop()
+	tsuruServer, requests := s.startTsuruServer(&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewBufferString("[]")),
+	})
+	defer tsuruServer.Close()
+	reporter, err := NewReporter(&ReporterConfig{
+		Interval:       10 * time.Minute,
+		DockerEndpoint: dockerServer.URL(),
+		TsuruEndpoint:  tsuruServer.URL,
+		TsuruToken:     "some-token",
+		Compress:       true,
+	})
+	c.Assert(err, check.IsNil)
+	reporter.Stop()
+	reporter.reportStatus()
+	req := <-requests
+	c.Assert(req.request.Header.Get("Content-Encoding"), check.Equals, "gzip")
+	c.Assert(req.request.Header.Get("Content-Type"), check.Equals, "application/x-www-form-urlencoded")
+	reader, err := gzip.NewReader(bytes.NewReader(req.body))
+	c.Assert(err, check.IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, check.IsNil)
+	var input hostStatus
+	err = form.DecodeString(&input, string(data))
+	c.Assert(err, check.IsNil)
+	c.Assert(input.Units, check.DeepEquals, []containerStatus{
+		{ID: containers[0].ID, Status: "started", Name: "x1"},
+	})
+}
+
+func (s S) TestReportStatusConfigDrift(c *check.C) {
+	bogusContainers := []bogusContainer{
+		{name: "x1", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+	}
+	dockerServer, _ := s.startDockerServer(bogusContainers, nil, c)
+	defer dockerServer.Stop()
+	tsuruServer, requests := s.startTsuruServer(&http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(bytes.NewBufferString("[]")),
+	})
+	defer tsuruServer.Close()
+	drift := &config.Drift{Fingerprint: "abc123", Previous: "def456", Changed: []string{"LOG_BACKENDS", "STATUS_INTERVAL"}}
+	reporter, err := NewReporter(&ReporterConfig{
+		Interval:       10 * time.Minute,
+		DockerEndpoint: dockerServer.URL(),
+		TsuruEndpoint:  tsuruServer.URL,
+		TsuruToken:     "some-token",
+		Drift:          drift,
+	})
+	c.Assert(err, check.IsNil)
+	reporter.Stop()
+	reporter.reportStatus()
+	req := <-requests
+	var input hostStatus
+	err = form.DecodeString(&input, string(req.body))
+	c.Assert(err, check.IsNil)
+	c.Assert(input.Config, check.DeepEquals, drift)
+}
+
+func (s S) TestReportStatusDifferential(c *check.C) {
+	bogusContainers := []bogusContainer{
+		{name: "x1", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+		{name: "x2", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+	}
+	dockerServer, containers := s.startDockerServer(bogusContainers, nil, c)
+	defer dockerServer.Stop()
+	tsuruServer, requests := s.startTsuruServer(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewBufferString("[]")),
+		}
+	})
+	defer tsuruServer.Close()
+	reporter, err := NewReporter(&ReporterConfig{
+		Interval:       10 * time.Minute,
+		DockerEndpoint: dockerServer.URL(),
+		TsuruEndpoint:  tsuruServer.URL,
+		TsuruToken:     "some-token",
+		Differential:   true,
+	})
+	c.Assert(err, check.IsNil)
+	reporter.Stop()
+	c.Assert(reporter.config.FullSyncInterval, check.Equals, 100*time.Minute)
+	readUnits := func() []containerStatus {
+		req := <-requests
+		var input hostStatus
+		inErr := form.DecodeString(&input, string(req.body))
+		c.Assert(inErr, check.IsNil)
+		return input.Units
+	}
+	c.Assert(readUnits(), check.HasLen, 2)
+	reporter.reportStatus()
+	c.Assert(readUnits(), check.HasLen, 0)
+	err = dockerServer.MutateContainer(containers[1].ID, docker.State{Running: false, StartedAt: time.Now().Add(-time.Hour)})
+	c.Assert(err, check.IsNil)
+	reporter.reportStatus()
+	c.Assert(readUnits(), check.DeepEquals, []containerStatus{
+		{ID: containers[1].ID, Status: "stopped", Name: "x2"},
+	})
+	reporter.lastSync = time.Now().Add(-101 * time.Minute)
+	reporter.reportStatus()
+	c.Assert(readUnits(), check.HasLen, 2)
+}
+
+func (s S) TestReportStatusRetryQueue(c *check.C) {
+	bogusContainers := []bogusContainer{
+		{name: "x1", config: docker.Config{Image: "tsuru/python", Env: []string{"TSURU_APPNAME=someapp"}}, state: docker.State{Running: true}},
+	}
+	dockerServer, _ := s.startDockerServer(bogusContainers, nil, c)
+	defer dockerServer.Stop()
+	var available int32
+	tsuruServer, requests := s.startTsuruServer(func(req *http.Request) *http.Response {
+		if atomic.LoadInt32(&available) == 0 {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(bytes.NewBufferString("unavailable")),
+			}
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     make(http.Header),
+			Body:       ioutil.NopCloser(bytes.NewBufferString("[]")),
+		}
+	})
+	defer tsuruServer.Close()
+	reporter, err := NewReporter(&ReporterConfig{
+		Interval:       10 * time.Minute,
+		DockerEndpoint: dockerServer.URL(),
+		TsuruEndpoint:  tsuruServer.URL,
+		TsuruToken:     "some-token",
+		RetryQueueSize: 2,
+	})
+	c.Assert(err, check.IsNil)
+	reporter.Stop()
+	first := <-requests
+	c.Assert(reporter.pending, check.HasLen, 1)
+	c.Assert(reporter.retryAttempt, check.Equals, 1)
+	c.Assert(reporter.nextRetry.After(time.Now().Add(9*time.Minute)), check.Equals, true)
+	reporter.reportStatus()
+	c.Assert(reporter.pending, check.HasLen, 2)
+	c.Assert(requests, check.HasLen, 0)
+	atomic.StoreInt32(&available, 1)
+	reporter.nextRetry = time.Time{}
+	reporter.reportStatus()
+	c.Assert(reporter.pending, check.HasLen, 0)
+	c.Assert(reporter.retryAttempt, check.Equals, 0)
+	c.Assert(requests, check.HasLen, 3)
+	var timestamps []time.Time
+	for i := 0; i < 3; i++ {
+		req := <-requests
+		ts, tsErr := time.Parse(time.RFC3339Nano, req.request.Header.Get(timestampHeader))
+		c.Assert(tsErr, check.IsNil)
+		timestamps = append(timestamps, ts)
+	}
+	c.Assert(first.request.Header.Get(timestampHeader), check.Equals, timestamps[0].Format(time.RFC3339Nano))
+	c.Assert(timestamps[1].After(timestamps[0]), check.Equals, true)
+	c.Assert(timestamps[2].After(timestamps[1]), check.Equals, true)
+}
+
+func (S) TestReporterEnqueue(c *check.C) {
+	reporter := &Reporter{config: &ReporterConfig{RetryQueueSize: 2}, lastSync: time.Now()}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		c.Assert(reporter.enqueue(&hostStatus{}, now.Add(time.Duration(i)*time.Second)), check.Equals, true)
+	}
+	c.Assert(reporter.pending, check.HasLen, 2)
+	c.Assert(reporter.pending[0].timestamp, check.Equals, now.Add(time.Second))
+	c.Assert(reporter.lastSync.IsZero(), check.Equals, true)
+	reporter = &Reporter{config: &ReporterConfig{}}
+	c.Assert(reporter.enqueue(&hostStatus{}, now), check.Equals, false)
+	c.Assert(reporter.pending, check.HasLen, 0)
+}
+
+func (S) TestReporterScheduleRetry(c *check.C) {
+	reporter := &Reporter{config: &ReporterConfig{Interval: time.Minute}}
+	now := time.Now()
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, maxRetryBackoff, maxRetryBackoff}
+	for _, delay := range expected {
+		reporter.scheduleRetry(now)
+		c.Assert(reporter.nextRetry.Sub(now), check.Equals, delay)
+	}
+}
+
+func (S) TestChunkStatuses(c *check.C) {
+	statuses := []containerStatus{{ID: "1"}, {ID: "2"}, {ID: "3"}, {ID: "4"}, {ID: "5"}}
+	c.Assert(chunkStatuses(statuses, 0), check.DeepEquals, [][]containerStatus{statuses})
+	c.Assert(chunkStatuses(statuses, 5), check.DeepEquals, [][]containerStatus{statuses})
+	c.Assert(chunkStatuses(statuses, 2), check.DeepEquals, [][]containerStatus{
+		{{ID: "1"}, {ID: "2"}},
+		{{ID: "3"}, {ID: "4"}},
+		{{ID: "5"}},
+	})
+	c.Assert(chunkStatuses(nil, 2), check.DeepEquals, [][]containerStatus{nil})
+}
+
 type tsuruRequest struct {
 	request *http.Request
 	body    []byte
@@ -0,0 +1,130 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package supervisor keeps the long running goroutines of bs alive,
+// recovering from panics and restarting them with backoff, so a single bad
+// message can't take down log forwarding or metric reporting for the whole
+// node.
+package supervisor
+
+import (
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// Default is the supervisor used by the package level functions.
+var Default = New()
+
+type Supervisor struct {
+	// MinBackoff is the delay before the first restart of a goroutine. It
+	// doubles after each consecutive panic, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	mu         sync.Mutex
+	panics     map[string]uint64
+}
+
+func New() *Supervisor {
+	return &Supervisor{
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		panics:     make(map[string]uint64),
+	}
+}
+
+// Run calls fn, calling it again after a backoff whenever it panics. It only
+// returns once fn returns normally. The backoff is reset when fn runs for
+// longer than MaxBackoff before panicking.
+func (s *Supervisor) Run(name string, fn func()) {
+	backoff := s.MinBackoff
+	for {
+		started := time.Now()
+		if !s.call(name, fn) {
+			return
+		}
+		if time.Since(started) > s.MaxBackoff {
+			backoff = s.MinBackoff
+		}
+		bslog.Warnf("[supervisor] restarting %s in %s", name, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// Go calls Run in a new goroutine.
+func (s *Supervisor) Go(name string, fn func()) {
+	go s.Run(name, fn)
+}
+
+// Recover must be deferred directly by short lived goroutines that shouldn't
+// be restarted. It recovers from a panic, logging and counting it.
+func (s *Supervisor) Recover(name string) {
+	if r := recover(); r != nil {
+		s.handlePanic(name, r)
+	}
+}
+
+func (s *Supervisor) call(name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.handlePanic(name, r)
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
+
+func (s *Supervisor) handlePanic(name string, r interface{}) {
+	bslog.Errorf("[supervisor] recovered panic in %s: %v\n%s", name, r, debug.Stack())
+	s.mu.Lock()
+	s.panics[name]++
+	s.mu.Unlock()
+}
+
+// Panics returns the number of panics recovered so far, by goroutine name.
+func (s *Supervisor) Panics() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	panics := make(map[string]uint64, len(s.panics))
+	for name, n := range s.panics {
+		panics[name] = n
+	}
+	return panics
+}
+
+// State returns the number of panics recovered by goroutine name, for state
+// dumps.
+func (s *Supervisor) State() map[string]interface{} {
+	return map[string]interface{}{"panics": s.Panics()}
+}
+
+// Run calls Default.Run.
+func Run(name string, fn func()) {
+	Default.Run(name, fn)
+}
+
+// Go calls Default.Go.
+func Go(name string, fn func()) {
+	Default.Go(name, fn)
+}
+
+// Recover recovers from a panic using the Default supervisor. Like the
+// builtin recover, it must be called directly by a deferred call.
+func Recover(name string) {
+	if r := recover(); r != nil {
+		Default.handlePanic(name, r)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package supervisor
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	logOutput bytes.Buffer
+	oldLogger *log.Logger
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.logOutput.Reset()
+	s.oldLogger = bslog.Logger
+	bslog.Logger = log.New(&s.logOutput, "", 0)
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	bslog.Logger = s.oldLogger
+}
+
+func newTestSupervisor() *Supervisor {
+	sup := New()
+	sup.MinBackoff = time.Millisecond
+	sup.MaxBackoff = 4 * time.Millisecond
+	return sup
+}
+
+func (s *S) TestRunRestartsAfterPanic(c *check.C) {
+	sup := newTestSupervisor()
+	var calls int
+	sup.Run("worker", func() {
+		calls++
+		if calls < 3 {
+			panic("bad message")
+		}
+	})
+	c.Assert(calls, check.Equals, 3)
+	c.Assert(sup.Panics(), check.DeepEquals, map[string]uint64{"worker": 2})
+	output := s.logOutput.String()
+	c.Assert(output, check.Matches, `(?s).*\[ERROR\] \[supervisor\] recovered panic in worker: bad message\n.*supervisor_test.go.*`)
+	c.Assert(output, check.Matches, `(?s).*\[WARNING\] \[supervisor\] restarting worker in 1ms.*restarting worker in 2ms.*`)
+}
+
+func (s *S) TestRunNoPanic(c *check.C) {
+	sup := newTestSupervisor()
+	var calls int
+	sup.Run("worker", func() {
+		calls++
+	})
+	c.Assert(calls, check.Equals, 1)
+	c.Assert(sup.Panics(), check.HasLen, 0)
+	c.Assert(s.logOutput.String(), check.Equals, "")
+}
+
+func (s *S) TestRunBackoffLimit(c *check.C) {
+	sup := newTestSupervisor()
+	var calls int
+	sup.Run("worker", func() {
+		calls++
+		if calls < 6 {
+			panic("bad message")
+		}
+	})
+	output := s.logOutput.String()
+	c.Assert(output, check.Matches, `(?s).*restarting worker in 4ms.*restarting worker in 4ms.*`)
+	c.Assert(output, check.Not(check.Matches), `(?s).*restarting worker in 8ms.*`)
+}
+
+func (s *S) TestGo(c *check.C) {
+	sup := newTestSupervisor()
+	done := make(chan struct{})
+	var calls int
+	sup.Go("worker", func() {
+		calls++
+		if calls == 1 {
+			panic("bad message")
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for goroutine restart")
+	}
+	c.Assert(sup.Panics(), check.DeepEquals, map[string]uint64{"worker": 1})
+}
+
+func (s *S) TestRecover(c *check.C) {
+	sup := newTestSupervisor()
+	func() {
+		defer sup.Recover("task")
+		panic("bad task")
+	}()
+	c.Assert(sup.Panics(), check.DeepEquals, map[string]uint64{"task": 1})
+	c.Assert(s.logOutput.String(), check.Matches, `(?s).*recovered panic in task: bad task.*`)
+	c.Assert(s.logOutput.String(), check.Not(check.Matches), `(?s).*restarting.*`)
+}
+
+func (s *S) TestPackageRecover(c *check.C) {
+	old := Default
+	defer func() { Default = old }()
+	Default = newTestSupervisor()
+	func() {
+		defer Recover("task")
+		panic("bad task")
+	}()
+	c.Assert(Default.Panics(), check.DeepEquals, map[string]uint64{"task": 1})
+}
@@ -0,0 +1,203 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuruapi
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDialTimeout     = 10 * time.Second
+	DefaultRequestTimeout  = time.Minute
+	DefaultIdleConnTimeout = 90 * time.Second
+	DefaultMaxIdleConns    = 10
+	DefaultMaxRetries      = 3
+
+	maxRetryDelay = time.Minute
+)
+
+var (
+	// Overridden by tests to avoid waiting between retries.
+	sleep = time.Sleep
+
+	retryBaseDelay = 500 * time.Millisecond
+)
+
+// Config holds the settings used to build a Client. Zero values are replaced
+// by their defaults, a negative MaxRetries disables retries.
+type Config struct {
+	Endpoint string
+	Token    string
+	// TokenFile is a file holding the token, read again whenever it changes.
+	// It takes precedence over Token, which is used while the file can't be
+	// read.
+	TokenFile       string
+	DialTimeout     time.Duration
+	RequestTimeout  time.Duration
+	IdleConnTimeout time.Duration
+	MaxIdleConns    int
+	MaxRetries      int
+}
+
+// Client is a tsuru API client meant to be shared by every bs component
+// talking to tsuru, so connections are kept alive and reused between
+// requests.
+type Client struct {
+	endpoint   string
+	token      *TokenSource
+	maxRetries int
+	httpClient *http.Client
+}
+
+func NewClient(config Config) *Client {
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = DefaultDialTimeout
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = DefaultRequestTimeout
+	}
+	if config.IdleConnTimeout <= 0 {
+		config.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultMaxRetries
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: config.DialTimeout,
+		MaxIdleConns:        config.MaxIdleConns,
+		MaxIdleConnsPerHost: config.MaxIdleConns,
+		IdleConnTimeout:     config.IdleConnTimeout,
+	}
+	return &Client{
+		endpoint:   strings.TrimRight(config.Endpoint, "/"),
+		token:      NewTokenSource(config.Token, config.TokenFile),
+		maxRetries: config.MaxRetries,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   config.RequestTimeout,
+		},
+	}
+}
+
+// Endpoint returns the tsuru API address used by the client.
+func (c *Client) Endpoint() string {
+	return c.endpoint
+}
+
+// Token returns the token currently used to authenticate in the tsuru API.
+func (c *Client) Token() string {
+	return c.token.Token()
+}
+
+// Do sends a request to path in the tsuru API, retrying with jittered
+// exponential backoff on network errors and on responses indicating a
+// temporary failure. The Retry-After header, when present, takes precedence
+// over the computed backoff. Requests rejected with status 401 are sent
+// again once when the token changed, as it may have been rotated.
+func (c *Client) Do(method, path string, header http.Header, body []byte) (*http.Response, error) {
+	url := c.endpoint + "/" + strings.TrimLeft(path, "/")
+	var refreshed bool
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, values := range header {
+			for _, v := range values {
+				request.Header.Add(k, v)
+			}
+		}
+		token := c.token.Token()
+		request.Header.Set("Authorization", "bearer "+token)
+		resp, err := c.httpClient.Do(request)
+		if err == nil && resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			refreshed = true
+			c.token.Refresh()
+			if c.token.Token() != token {
+				io.Copy(ioutil.Discard, resp.Body)
+				resp.Body.Close()
+				attempt--
+				continue
+			}
+		}
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		if attempt >= c.maxRetries {
+			if err != nil {
+				return nil, err
+			}
+			return resp, nil
+		}
+		delay := backoff(attempt)
+		if err == nil {
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				delay = retryAfter
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		sleep(delay)
+	}
+}
+
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func backoff(attempt int) time.Duration {
+	base := retryBaseDelay << uint(attempt)
+	if base <= 0 || base > maxRetryDelay {
+		base = maxRetryDelay
+	}
+	return base/2 + time.Duration(rand.Int63n(int64(base/2)+1))
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	delay := t.Sub(time.Now())
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
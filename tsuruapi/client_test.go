@@ -0,0 +1,156 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuruapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	sleeps []time.Duration
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.sleeps = nil
+	sleep = func(d time.Duration) {
+		s.sleeps = append(s.sleeps, d)
+	}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	sleep = time.Sleep
+}
+
+func (s *S) TestClientDo(c *check.C) {
+	var body []byte
+	var req *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL + "/", Token: "mytoken"})
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	resp, err := client.Do("POST", "/node/status", header, []byte("data"))
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(req.URL.Path, check.Equals, "/node/status")
+	c.Assert(req.Header.Get("Authorization"), check.Equals, "bearer mytoken")
+	c.Assert(req.Header.Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(string(body), check.Equals, "data")
+	c.Assert(s.sleeps, check.HasLen, 0)
+}
+
+func (s *S) TestClientDoRetry(c *check.C) {
+	var calls int32
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL})
+	resp, err := client.Do("POST", "/units/status", nil, []byte("data"))
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(bodies, check.DeepEquals, []string{"data", "data", "data"})
+	c.Assert(s.sleeps, check.HasLen, 2)
+	c.Assert(s.sleeps[0] >= retryBaseDelay/2 && s.sleeps[0] <= retryBaseDelay, check.Equals, true)
+	c.Assert(s.sleeps[1] >= retryBaseDelay && s.sleeps[1] <= 2*retryBaseDelay, check.Equals, true)
+}
+
+func (s *S) TestClientDoRetryAfter(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL})
+	resp, err := client.Do("GET", "/", nil, nil)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(s.sleeps, check.DeepEquals, []time.Duration{7 * time.Second})
+}
+
+func (s *S) TestClientDoRetriesExhausted(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL, MaxRetries: 2})
+	resp, err := client.Do("GET", "/", nil, nil)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusBadGateway)
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(3))
+}
+
+func (s *S) TestClientDoNoRetries(c *check.C) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL, MaxRetries: -1})
+	resp, err := client.Do("GET", "/", nil, nil)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(atomic.LoadInt32(&calls), check.Equals, int32(1))
+	c.Assert(s.sleeps, check.HasLen, 0)
+}
+
+func (s *S) TestClientDoNetworkError(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Close()
+	client := NewClient(Config{Endpoint: server.URL, MaxRetries: 1})
+	_, err := client.Do("GET", "/", nil, nil)
+	c.Assert(err, check.NotNil)
+	c.Assert(s.sleeps, check.HasLen, 1)
+}
+
+func (s *S) TestParseRetryAfter(c *check.C) {
+	d, ok := parseRetryAfter("")
+	c.Assert(ok, check.Equals, false)
+	d, ok = parseRetryAfter("30")
+	c.Assert(ok, check.Equals, true)
+	c.Assert(d, check.Equals, 30*time.Second)
+	d, ok = parseRetryAfter("-1")
+	c.Assert(ok, check.Equals, false)
+	d, ok = parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	c.Assert(ok, check.Equals, true)
+	c.Assert(d > 59*time.Minute && d <= time.Hour, check.Equals, true)
+	_, ok = parseRetryAfter("soon")
+	c.Assert(ok, check.Equals, false)
+}
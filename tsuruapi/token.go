@@ -0,0 +1,103 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuruapi
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+)
+
+// TokenSource provides the token used to authenticate in the tsuru API,
+// either a static one or one read from a file. The file is read again
+// whenever it changes, so rotated tokens are used without restarting bs.
+type TokenSource struct {
+	static  string
+	file    string
+	mu      sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+	lastErr string
+}
+
+// NewTokenSource returns a source of the token in file, falling back to the
+// static token while the file can't be read. When file is empty the static
+// token is always used.
+func NewTokenSource(token, file string) *TokenSource {
+	return &TokenSource{static: token, file: file}
+}
+
+// Token returns the current token.
+func (s *TokenSource) Token() string {
+	if s == nil {
+		return ""
+	}
+	if s.file == "" {
+		return s.static
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Stat(s.file)
+	if err != nil {
+		s.failed(err)
+		return s.current()
+	}
+	if s.token != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		s.recovered()
+		return s.token
+	}
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		s.failed(err)
+		return s.current()
+	}
+	s.recovered()
+	s.token = strings.TrimSpace(string(data))
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return s.current()
+}
+
+// Refresh forces the token file to be read again in the next call to Token,
+// used when the current token is rejected.
+func (s *TokenSource) Refresh() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modTime = time.Time{}
+	s.size = -1
+}
+
+// failed logs an error reading the token file, only when it differs from the
+// last one, as Token is called on every request to the tsuru API.
+func (s *TokenSource) failed(err error) {
+	if err.Error() == s.lastErr {
+		return
+	}
+	s.lastErr = err.Error()
+	bslog.Warnf("[tsuru api] unable to read token file %q, using the last token read: %s", s.file, err)
+}
+
+func (s *TokenSource) recovered() {
+	if s.lastErr == "" {
+		return
+	}
+	s.lastErr = ""
+	bslog.Infof("[tsuru api] token file %q readable again", s.file)
+}
+
+func (s *TokenSource) current() string {
+	if s.token != "" {
+		return s.token
+	}
+	return s.static
+}
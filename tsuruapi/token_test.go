@@ -0,0 +1,110 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tsuruapi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestTokenSourceStatic(c *check.C) {
+	source := NewTokenSource("mytoken", "")
+	c.Assert(source.Token(), check.Equals, "mytoken")
+}
+
+func (s *S) TestTokenSourceFile(c *check.C) {
+	file := filepath.Join(c.MkDir(), "token")
+	err := ioutil.WriteFile(file, []byte("token1\n"), 0600)
+	c.Assert(err, check.IsNil)
+	source := NewTokenSource("static", file)
+	c.Assert(source.Token(), check.Equals, "token1")
+	err = ioutil.WriteFile(file, []byte("token-2\n"), 0600)
+	c.Assert(err, check.IsNil)
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(file, future, future)
+	c.Assert(err, check.IsNil)
+	c.Assert(source.Token(), check.Equals, "token-2")
+	err = os.Remove(file)
+	c.Assert(err, check.IsNil)
+	c.Assert(source.Token(), check.Equals, "token-2")
+}
+
+func (s *S) TestTokenSourceMissingFile(c *check.C) {
+	source := NewTokenSource("static", filepath.Join(c.MkDir(), "token"))
+	c.Assert(source.Token(), check.Equals, "static")
+}
+
+func (s *S) TestTokenSourceLogsOncePerStateChange(c *check.C) {
+	var output bytes.Buffer
+	oldLogger := bslog.Logger
+	bslog.Logger = log.New(&output, "", 0)
+	defer func() { bslog.Logger = oldLogger }()
+	file := filepath.Join(c.MkDir(), "token")
+	source := NewTokenSource("static", file)
+	for i := 0; i < 3; i++ {
+		c.Assert(source.Token(), check.Equals, "static")
+	}
+	c.Assert(strings.Count(output.String(), "unable to read token file"), check.Equals, 1)
+	err := ioutil.WriteFile(file, []byte("token1"), 0600)
+	c.Assert(err, check.IsNil)
+	c.Assert(source.Token(), check.Equals, "token1")
+	c.Assert(source.Token(), check.Equals, "token1")
+	c.Assert(strings.Count(output.String(), "readable again"), check.Equals, 1)
+	err = os.Remove(file)
+	c.Assert(err, check.IsNil)
+	c.Assert(source.Token(), check.Equals, "token1")
+	c.Assert(source.Token(), check.Equals, "token1")
+	c.Assert(strings.Count(output.String(), "unable to read token file"), check.Equals, 2)
+}
+
+func (s *S) TestClientDoRefreshesTokenOnUnauthorized(c *check.C) {
+	file := filepath.Join(c.MkDir(), "token")
+	err := ioutil.WriteFile(file, []byte("old"), 0600)
+	c.Assert(err, check.IsNil)
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "bearer new" {
+			ioutil.WriteFile(file, []byte("new"), 0600)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL, TokenFile: file})
+	resp, err := client.Do("POST", "/node/status", nil, []byte("data"))
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(tokens, check.DeepEquals, []string{"bearer old", "bearer new"})
+	c.Assert(client.Token(), check.Equals, "new")
+	c.Assert(s.sleeps, check.HasLen, 0)
+}
+
+func (s *S) TestClientDoUnauthorizedSameToken(c *check.C) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	client := NewClient(Config{Endpoint: server.URL, Token: "mytoken"})
+	resp, err := client.Do("POST", "/node/status", nil, nil)
+	c.Assert(err, check.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusUnauthorized)
+	c.Assert(calls, check.Equals, 1)
+}
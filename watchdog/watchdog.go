@@ -0,0 +1,215 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package watchdog detects stalled bs components, the ones receiving work but
+// not completing any of it for a number of intervals, restarting them or
+// exiting bs so it's restarted by the container orchestrator.
+package watchdog
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tsuru/bs/audit"
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+	"github.com/tsuru/bs/supervisor"
+)
+
+const (
+	DefaultInterval         = time.Minute
+	DefaultStalledIntervals = 3
+
+	// ActionRestart restarts stalled components, exiting if a component
+	// can't be restarted or is still stalled after being restarted.
+	ActionRestart = "restart"
+	// ActionExit exits as soon as a stalled component is found.
+	ActionExit = "exit"
+)
+
+var exit = os.Exit
+
+// Component is a bs component watched by the watchdog. Progress returns
+// counters of the work received and completed by the component so far.
+type Component interface {
+	Progress() (received, completed uint64)
+}
+
+// Restarter is implemented by components able to restart themselves.
+type Restarter interface {
+	Restart() error
+}
+
+type Config struct {
+	Interval time.Duration
+	// StalledIntervals is the number of consecutive intervals a component
+	// may receive work without completing any before being considered
+	// stalled.
+	StalledIntervals int
+	Action           string
+}
+
+type Watchdog struct {
+	config     Config
+	components []*watched
+	mu         sync.Mutex
+	restarts   uint64
+	abort      chan struct{}
+	exit       chan struct{}
+}
+
+type watched struct {
+	name      string
+	component Component
+	checked   bool
+	received  uint64
+	completed uint64
+	stalled   int
+	restarted bool
+}
+
+func New(config Config) *Watchdog {
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.StalledIntervals <= 0 {
+		config.StalledIntervals = DefaultStalledIntervals
+	}
+	switch config.Action {
+	case ActionRestart, ActionExit:
+	case "":
+		config.Action = ActionRestart
+	default:
+		bslog.Warnf("[watchdog] invalid action %q, using %q", config.Action, ActionRestart)
+		config.Action = ActionRestart
+	}
+	return &Watchdog{
+		config: config,
+		abort:  make(chan struct{}),
+		exit:   make(chan struct{}),
+	}
+}
+
+// Add adds a component to be watched. It must be called before Start.
+func (w *Watchdog) Add(name string, component Component) {
+	w.components = append(w.components, &watched{name: name, component: component})
+}
+
+// Start checks the components periodically until Stop is called.
+func (w *Watchdog) Start() {
+	supervisor.Go("watchdog", func() {
+		for {
+			select {
+			case <-w.abort:
+				close(w.exit)
+				return
+			case <-time.After(w.config.Interval):
+			}
+			w.Run()
+		}
+	})
+}
+
+// Stop stops the watchdog, blocking until it actually stops.
+func (w *Watchdog) Stop() {
+	close(w.abort)
+	<-w.exit
+}
+
+// Wait blocks until the watchdog stops.
+func (w *Watchdog) Wait() {
+	<-w.exit
+}
+
+// Run checks every component once, restarting the stalled ones or exiting,
+// depending on the configured action.
+func (w *Watchdog) Run() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, c := range w.components {
+		if !w.stalled(c) {
+			continue
+		}
+		stalledFor := time.Duration(c.stalled) * w.config.Interval
+		restarter, ok := c.component.(Restarter)
+		if w.config.Action == ActionRestart && ok && !c.restarted {
+			bslog.Errorf("[watchdog] %s stalled for %s, restarting it", c.name, stalledFor)
+			audit.Record("watchdog", "restarting %s, stalled for %s", c.name, stalledFor)
+			err := restarter.Restart()
+			if err == nil {
+				c.stalled = 0
+				c.restarted = true
+				w.restarts++
+				continue
+			}
+			bslog.Errorf("[watchdog] unable to restart %s: %s", c.name, err)
+		}
+		bslog.Errorf("[watchdog] %s stalled for %s, exiting", c.name, stalledFor)
+		audit.Record("watchdog", "exiting, %s stalled for %s", c.name, stalledFor)
+		audit.Flush(time.Second)
+		exit(1)
+		return
+	}
+}
+
+// stalled updates the state of a component, returning whether it received
+// work without completing any for the configured number of intervals.
+func (w *Watchdog) stalled(c *watched) bool {
+	received, completed := c.component.Progress()
+	defer func() {
+		c.checked = true
+		c.received = received
+		c.completed = completed
+	}()
+	if !c.checked {
+		return false
+	}
+	if received == c.received || completed != c.completed {
+		c.stalled = 0
+		if completed != c.completed {
+			c.restarted = false
+		}
+		return false
+	}
+	c.stalled++
+	return c.stalled >= w.config.StalledIntervals
+}
+
+// State returns the progress counters and number of stalled intervals of
+// every component, for state dumps.
+func (w *Watchdog) State() map[string]interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	components := make(map[string]interface{}, len(w.components))
+	for _, c := range w.components {
+		components[c.name] = map[string]interface{}{
+			"received":          c.received,
+			"completed":         c.completed,
+			"stalled_intervals": c.stalled,
+			"restarted":         c.restarted,
+		}
+	}
+	return map[string]interface{}{
+		"components": components,
+		"restarts":   w.restarts,
+	}
+}
+
+// HostMetrics returns the number of components currently stalled and the
+// number of restarts done by the watchdog so far.
+func (w *Watchdog) HostMetrics() map[string]metric.Metric {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var stalled int64
+	for _, c := range w.components {
+		if c.stalled > 0 {
+			stalled++
+		}
+	}
+	return map[string]metric.Metric{
+		"watchdog_stalled_components": metric.Int(stalled),
+		"watchdog_restarts":           metric.Int(int64(w.restarts)),
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 bs authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package watchdog
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tsuru/bs/bslog"
+	"github.com/tsuru/bs/metric"
+	"gopkg.in/check.v1"
+)
+
+var _ = check.Suite(&S{})
+
+func Test(t *testing.T) {
+	check.TestingT(t)
+}
+
+type S struct {
+	logOutput bytes.Buffer
+	oldLogger *log.Logger
+	exitCodes []int
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	s.logOutput.Reset()
+	s.oldLogger = bslog.Logger
+	bslog.Logger = log.New(&s.logOutput, "", 0)
+	s.exitCodes = nil
+	exit = func(code int) {
+		s.exitCodes = append(s.exitCodes, code)
+	}
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	bslog.Logger = s.oldLogger
+}
+
+type fakeComponent struct {
+	received   uint64
+	completed  uint64
+	restarts   int
+	restartErr error
+}
+
+func (f *fakeComponent) Progress() (uint64, uint64) {
+	return f.received, f.completed
+}
+
+type fakeRestarter struct {
+	fakeComponent
+}
+
+func (f *fakeRestarter) Restart() error {
+	f.restarts++
+	return f.restartErr
+}
+
+func (s *S) TestNewDefaults(c *check.C) {
+	w := New(Config{})
+	c.Assert(w.config, check.DeepEquals, Config{
+		Interval:         DefaultInterval,
+		StalledIntervals: DefaultStalledIntervals,
+		Action:           ActionRestart,
+	})
+	w = New(Config{Action: "reboot"})
+	c.Assert(w.config.Action, check.Equals, ActionRestart)
+	c.Assert(s.logOutput.String(), check.Matches, `(?s).*invalid action "reboot".*`)
+	w = New(Config{Action: ActionExit})
+	c.Assert(w.config.Action, check.Equals, ActionExit)
+}
+
+func (s *S) TestRunNotStalled(c *check.C) {
+	w := New(Config{StalledIntervals: 2})
+	comp := &fakeRestarter{}
+	w.Add("comp", comp)
+	for i := 0; i < 5; i++ {
+		// no work received
+		w.Run()
+	}
+	for i := 0; i < 5; i++ {
+		comp.received += 10
+		comp.completed += 1
+		w.Run()
+	}
+	c.Assert(comp.restarts, check.Equals, 0)
+	c.Assert(s.exitCodes, check.IsNil)
+	c.Assert(w.HostMetrics(), check.DeepEquals, map[string]metric.Metric{
+		"watchdog_stalled_components": metric.Int(0),
+		"watchdog_restarts":           metric.Int(0),
+	})
+}
+
+func (s *S) TestRunRestart(c *check.C) {
+	w := New(Config{StalledIntervals: 2})
+	comp := &fakeRestarter{}
+	w.Add("comp", comp)
+	w.Run()
+	comp.received++
+	w.Run()
+	c.Assert(w.HostMetrics()["watchdog_stalled_components"], check.Equals, metric.Int(1))
+	comp.received++
+	w.Run()
+	c.Assert(comp.restarts, check.Equals, 1)
+	c.Assert(s.exitCodes, check.IsNil)
+	c.Assert(w.HostMetrics(), check.DeepEquals, map[string]metric.Metric{
+		"watchdog_stalled_components": metric.Int(0),
+		"watchdog_restarts":           metric.Int(1),
+	})
+	c.Assert(s.logOutput.String(), check.Matches, `(?s).*\[watchdog\] comp stalled for 2m0s, restarting it.*`)
+	comp.received++
+	comp.completed++
+	w.Run()
+	for i := 0; i < 2; i++ {
+		comp.received++
+		w.Run()
+	}
+	c.Assert(comp.restarts, check.Equals, 2)
+	c.Assert(s.exitCodes, check.IsNil)
+}
+
+func (s *S) TestRunExitWhenStillStalledAfterRestart(c *check.C) {
+	w := New(Config{StalledIntervals: 2})
+	comp := &fakeRestarter{}
+	w.Add("comp", comp)
+	w.Run()
+	for i := 0; i < 4; i++ {
+		comp.received++
+		w.Run()
+	}
+	c.Assert(comp.restarts, check.Equals, 1)
+	c.Assert(s.exitCodes, check.DeepEquals, []int{1})
+	c.Assert(s.logOutput.String(), check.Matches, `(?s).*\[watchdog\] comp stalled for 2m0s, exiting.*`)
+}
+
+func (s *S) TestRunExitWhenRestartFails(c *check.C) {
+	w := New(Config{StalledIntervals: 1})
+	comp := &fakeRestarter{}
+	comp.restartErr = errors.New("my error")
+	w.Add("comp", comp)
+	w.Run()
+	comp.received++
+	w.Run()
+	c.Assert(comp.restarts, check.Equals, 1)
+	c.Assert(s.exitCodes, check.DeepEquals, []int{1})
+	c.Assert(s.logOutput.String(), check.Matches, `(?s).*unable to restart comp: my error.*`)
+}
+
+func (s *S) TestRunExitWithoutRestarter(c *check.C) {
+	w := New(Config{StalledIntervals: 1})
+	comp := &fakeComponent{}
+	w.Add("comp", comp)
+	w.Run()
+	comp.received++
+	w.Run()
+	c.Assert(s.exitCodes, check.DeepEquals, []int{1})
+}
+
+func (s *S) TestRunActionExit(c *check.C) {
+	w := New(Config{StalledIntervals: 1, Action: ActionExit})
+	comp := &fakeRestarter{}
+	w.Add("comp", comp)
+	w.Run()
+	comp.received++
+	w.Run()
+	c.Assert(comp.restarts, check.Equals, 0)
+	c.Assert(s.exitCodes, check.DeepEquals, []int{1})
+}
+
+func (s *S) TestStartStop(c *check.C) {
+	w := New(Config{Interval: time.Millisecond, StalledIntervals: 1})
+	comp := &fakeComponent{}
+	w.Add("comp", comp)
+	w.Start()
+	time.Sleep(10 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		w.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("timeout waiting for watchdog to stop")
+	}
+	c.Assert(s.exitCodes, check.IsNil)
+}